@@ -0,0 +1,263 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Command ldt-admin inspects the things db of the local digital twins service.
+//
+// The things db file cannot be opened while the service holds it, so if the service is running,
+// its admin socket is used instead. The things db file is opened read-only only when the service is stopped.
+// The detected access mode is printed to the standard error output.
+//
+// Usage:
+//
+//	ldt-admin [flags] status
+//	ldt-admin [flags] things [<limit> [<after>]]
+//	ldt-admin [flags] thing <thingId>
+//	ldt-admin [flags] events <thingId> [<fromRevision> [<toRevision>]]
+//	ldt-admin [flags] snapshot <file>
+//	ldt-admin [flags] backup <file>
+//	ldt-admin [flags] export <file>
+//	ldt-admin [flags] cloud-diff <thingId>
+//	ldt-admin [flags] compact
+//	ldt-admin [flags] reconcile
+//	ldt-admin [flags] integrity [repair]
+//	ldt-admin [flags] topics <thingId> <action>
+//
+// The things are listed all at once, unless a limit is provided, then a page of the sorted thing IDs after
+// the provided one is listed with the cursor of the next page, if there are more things.
+// The events are the journaled locally generated events of the thing, if the events journal is enabled.
+// The snapshot is a consistent copy of the things db, e.g. to be compared with ldt-diff.
+// The backup is a consistent copy of the things db written by the service itself, without streaming it
+// over the admin socket, e.g. to back up a running service in place.
+// The export is a portable JSON document of all things, e.g. to be imported with the twins -importState flag.
+// The cloud diff compares the local thing with the cloud one, retrieved by the service while connected to the hub,
+// listing the local-only, the cloud-only and the differing paths without modifying any of them.
+// The compaction copies the things db records to a fresh file replacing the original one, so that the space
+// of the removed data is released. The running service compacts it once the in-flight messages and
+// synchronization are completed. The file sizes before and after the compaction are printed.
+// The reconciliation rebuilds the things IDs index from the stored things, e.g. if the listed things differ
+// from the stored ones after a crash, and prints the repaired inconsistencies.
+// The integrity check verifies that the things IDs index, the things, the features and the things system data
+// records are mutually consistent and prints the found inconsistencies, which are repaired if requested.
+// The corrupted records are reported only.
+// The topics command prints the local broker topics the service would use for the responses and the events
+// with the provided action, e.g. 'modify' or 'modified', of the thing, and whether they are the root device ones.
+// The hub events topic of a thing, other than the root device, is printed if the tenant ID is provided.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+func main() {
+	f := flag.NewFlagSet("ldt-admin", flag.ExitOnError)
+	socket := f.String("adminSocket", admin.DefaultSocket,
+		"Admin socket of the running service, empty to access the things db file only")
+	thingsDB := f.String("thingsDb", "things.db", "Things db file, used if the service is not running")
+	engine := f.String("thingsDbEngine", persistence.EngineBolt, "Things db storage engine, 'bbolt' or 'sqlite'")
+	tenantID := f.String("tenantId", "", "Tenant ID of the device, used to print the hub topics")
+	f.Parse(os.Args[1:])
+
+	args := f.Args()
+	if len(args) == 0 {
+		log.Fatal("A command must be provided: status, things [<limit> [<after>]], thing <thingId>, events <thingId>, " +
+			"snapshot <file>, backup <file>, export <file>, cloud-diff <thingId>, compact, reconcile, " +
+			"integrity [repair] or topics <thingId> <action>")
+	}
+
+	access, err := admin.Open(*socket, *engine, *thingsDB)
+	if err != nil {
+		log.Fatalf("Cannot access the things db: %v", err)
+	}
+	defer access.Close()
+	fmt.Fprintf(os.Stderr, "Access mode: %s\n", access.Mode())
+
+	if err := run(access, args, *tenantID); err != nil {
+		access.Close()
+		log.Fatal(err)
+	}
+}
+
+func run(access admin.Access, args []string, tenantID string) error {
+	switch args[0] {
+	case "status":
+		status, err := access.Status()
+		if err != nil {
+			return err
+		}
+		return printJSON(status)
+
+	case "things":
+		if len(args) > 3 {
+			return fmt.Errorf("only the page limit and the thing ID to list the things after could be provided")
+		}
+		if len(args) == 1 {
+			ids, err := access.ThingIDs()
+			if err != nil {
+				return err
+			}
+			return printJSON(ids)
+		}
+		limit, err := strconv.Atoi(args[1])
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid page limit '%s'", args[1])
+		}
+		after := ""
+		if len(args) == 3 {
+			after = args[2]
+		}
+		page, err := access.ThingIDsPage(after, limit)
+		if err != nil {
+			return err
+		}
+		return printJSON(page)
+
+	case "thing":
+		if len(args) != 2 {
+			return fmt.Errorf("the thing ID must be provided")
+		}
+		thing, err := access.Thing(args[1])
+		if err != nil {
+			return err
+		}
+		return printJSON(thing)
+
+	case "events":
+		if len(args) < 2 || len(args) > 4 {
+			return fmt.Errorf("the thing ID and optionally the revisions range must be provided")
+		}
+		var revisions [2]int64
+		for i, arg := range args[2:] {
+			revision, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid revision '%s'", arg)
+			}
+			revisions[i] = revision
+		}
+		events, err := access.Events(args[1], revisions[0], revisions[1])
+		if err != nil {
+			return err
+		}
+		return printJSON(events)
+
+	case "snapshot":
+		if len(args) != 2 {
+			return fmt.Errorf("the snapshot file must be provided")
+		}
+		return writeFile(args[1], access.Snapshot)
+
+	case "backup":
+		if len(args) != 2 {
+			return fmt.Errorf("the backup file must be provided")
+		}
+		// the service may run in another working directory
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		return access.Backup(path)
+
+	case "export":
+		if len(args) != 2 {
+			return fmt.Errorf("the export file must be provided")
+		}
+		return writeFile(args[1], access.Export)
+
+	case "cloud-diff":
+		if len(args) != 2 {
+			return fmt.Errorf("the thing ID must be provided")
+		}
+		diff, err := access.CloudDiff(args[1])
+		if err != nil {
+			return err
+		}
+		return printJSON(diff)
+
+	case "compact":
+		if len(args) != 1 {
+			return fmt.Errorf("the compact command has no arguments")
+		}
+		report, err := access.Compact()
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+
+	case "reconcile":
+		if len(args) != 1 {
+			return fmt.Errorf("the reconcile command has no arguments")
+		}
+		report, err := access.Reconcile()
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+
+	case "integrity":
+		if len(args) > 2 || len(args) == 2 && args[1] != "repair" {
+			return fmt.Errorf("the integrity command has only the optional 'repair' argument")
+		}
+		report, err := access.CheckIntegrity(len(args) == 2)
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+
+	case "topics":
+		if len(args) != 3 {
+			return fmt.Errorf("the thing ID and the action must be provided")
+		}
+		status, err := access.Status()
+		if err != nil {
+			return err
+		}
+		topics, err := commands.ResolveTopics(status.DeviceID, tenantID, args[1], protocol.TopicAction(args[2]))
+		if err != nil {
+			return err
+		}
+		return printJSON(topics)
+
+	default:
+		return fmt.Errorf("unknown command '%s'", args[0])
+	}
+}
+
+// writeFile creates the file, which must not exist, and writes it with the provided function.
+func writeFile(file string, write func(w io.Writer) error) error {
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		os.Remove(file)
+		return err
+	}
+	return out.Close()
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Command ldt-diff prints the changes of the things between two snapshots of the things db,
+// e.g. the backup files taken before and after a maintenance window, as a JSON change set.
+// Each changed thing is reported as created, modified or deleted, the modified ones with
+// the JSON merge patch transforming the old thing into the new one.
+//
+// Both snapshots are opened read-only. The things db cannot be read while the local digital twins service
+// holds it open, so a copy of it has to be used to compare with its current state.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+func main() {
+	f := flag.NewFlagSet("ldt-diff", flag.ExitOnError)
+	from := f.String("from", "", "Things db snapshot file of the old state")
+	to := f.String("to", "", "Things db snapshot file of the new state")
+	f.Parse(os.Args[1:])
+
+	if len(*from) == 0 || len(*to) == 0 {
+		log.Fatal("Both from and to things db snapshot files must be provided")
+	}
+
+	changes, err := persistence.DiffFiles(*from, *to)
+	if err != nil {
+		log.Fatalf("Cannot compare things db snapshots: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(changes); err != nil {
+		log.Fatalf("Cannot print the changes: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Command ldt-ditto prints the configuration of an Eclipse Ditto connection, registering the local digital twins
+// as a connectivity source of a Ditto instance running on the edge. The printed JSON is to be created via
+// the Ditto connectivity API, e.g. with the devops createConnection piggyback command.
+//
+// The local digital twins service publishes the locally generated thing events, mapped to twin commands,
+// only if started with the same topic set as dittoFederationTopic.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/federation"
+)
+
+func main() {
+	f := flag.NewFlagSet("ldt-ditto", flag.ExitOnError)
+	settings := federation.ConnectionSettings{}
+	f.StringVar(&settings.ID, "id", "local-digital-twins", "Ditto connection ID")
+	f.StringVar(&settings.Type, "type", federation.ConnectionMQTT, "Ditto connection type: 'mqtt' or 'amqp-10'")
+	f.StringVar(&settings.URI, "uri", "tcp://localhost:1883", "URI of the endpoint Ditto connects to")
+	f.StringVar(&settings.Topic, "topic", federation.DefaultTopic,
+		"Local broker topics prefix of the federated twin commands, the same as dittoFederationTopic of the service")
+	f.StringVar(&settings.AuthSubject, "authSubject", "integration:local-digital-twins",
+		"Authorization subject of the connection source, granted write access by the things policies")
+	f.Parse(os.Args[1:])
+
+	connection, err := federation.NewConnection(settings)
+	if err != nil {
+		log.Fatalf("Cannot generate the Ditto connection: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(connection); err != nil {
+		log.Fatalf("Cannot print the Ditto connection: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Command ldt-migrate copies the things db into a new file and verifies the copy by comparing
+// the records count and hash of both files. The source things db is never modified,
+// so switching over to the new file is done by pointing the thingsDb setting to it.
+//
+// Only the bbolt backend with the gob codec is supported as a target at the moment.
+// The source things db cannot be read while the local digital twins service holds it open.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+func main() {
+	f := flag.NewFlagSet("ldt-migrate", flag.ExitOnError)
+	source := f.String("source", "things.db", "Source things db file, it is opened read-only")
+	target := f.String("target", "", "Target things db file, must not exist")
+	verify := f.Bool("verify", false, "Only compare the records count and hash of the source and target things db files")
+	f.Parse(os.Args[1:])
+
+	if len(*target) == 0 {
+		log.Fatal("No target things db file provided")
+	}
+
+	if *verify {
+		if err := verifyMigration(*source, *target); err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		return
+	}
+
+	report, err := persistence.Migrate(*source, *target)
+	if err != nil {
+		log.Fatalf("Cannot migrate things db: %v", err)
+	}
+	fmt.Printf("Migrated %d records to '%s', hash %s\n", report.Records, *target, report.Hash)
+}
+
+func verifyMigration(source, target string) error {
+	expected, err := persistence.Checksum(source)
+	if err != nil {
+		return err
+	}
+	actual, err := persistence.Checksum(target)
+	if err != nil {
+		return err
+	}
+	if *expected != *actual {
+		return fmt.Errorf("'%s' has %d records with hash %s, '%s' has %d records with hash %s",
+			source, expected.Records, expected.Hash, target, actual.Records, actual.Hash)
+	}
+	fmt.Printf("Verified %d records, hash %s\n", actual.Records, actual.Hash)
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// compactionScheduler periodically compacts the things db file. A scheduled compaction is postponed
+// while the things db is busy, i.e. while a synchronization or a burst of commands is in-flight,
+// until it is not used for the quiet period.
+type compactionScheduler struct {
+	maintenance *persistence.Maintenance
+	interval    time.Duration
+	quiet       time.Duration
+	logger      logger.Logger
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// start starts the scheduled compactions, it is a no-op if the compaction interval is not positive.
+func (c *compactionScheduler) start() {
+	if c.interval <= 0 {
+		return
+	}
+
+	c.quit = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		timer := time.NewTimer(c.interval)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-c.quit:
+				return
+			case <-timer.C:
+				if c.maintenance.Busy(c.quiet) {
+					c.logger.Debug("Things DB compaction is postponed as the things db is busy", nil)
+					timer.Reset(c.retryDelay())
+					continue
+				}
+				c.compact()
+				timer.Reset(c.interval)
+			}
+		}
+	}()
+}
+
+// stop stops the scheduled compactions and waits for the compaction in progress, if any.
+func (c *compactionScheduler) stop() {
+	if c.quit == nil {
+		return
+	}
+	close(c.quit)
+	c.wg.Wait()
+	c.quit = nil
+}
+
+func (c *compactionScheduler) retryDelay() time.Duration {
+	if c.quiet > 0 {
+		return c.quiet
+	}
+	return time.Second
+}
+
+func (c *compactionScheduler) compact() {
+	report, err := c.maintenance.Compact()
+	if err != nil {
+		c.logger.Error("Failed to compact the things db", err, nil)
+		return
+	}
+	c.logger.Info("Things DB is compacted", watermill.LogFields{
+		"sizeBefore": report.SizeBefore,
+		"sizeAfter":  report.SizeAfter,
+	})
+}
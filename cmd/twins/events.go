@@ -16,8 +16,6 @@ import (
 	"github.com/ThreeDotsLabs/watermill/message"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
-	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
-	"github.com/eclipse-kanto/suite-connector/logger"
 
 	conn "github.com/eclipse-kanto/suite-connector/connector"
 )
@@ -29,19 +27,12 @@ const (
 func eventsBus(router *message.Router,
 	honoPub message.Publisher,
 	mosquittoClient *conn.MQTTConnection,
-	deviceInfo commands.DeviceInfo,
-	storage persistence.ThingsStorage,
-	logger logger.Logger,
+	h *commands.Handler,
 ) *message.Handler {
 	mosquittoSub := conn.NewSubscriber(mosquittoClient, conn.QosAtLeastOnce, false, router.Logger(), nil)
 
-	h := &commands.Handler{
-		DeviceInfo:   deviceInfo,
-		MosquittoPub: conn.NewPublisher(mosquittoClient, conn.QosAtLeastOnce, router.Logger(), nil),
-		HonoPub:      honoPub,
-		Storage:      storage,
-		Logger:       logger,
-	}
+	h.MosquittoPub = conn.NewPublisher(mosquittoClient, conn.QosAtLeastOnce, router.Logger(), nil)
+	h.HonoPub = honoPub
 
 	//Gateway -> Mosquitto Broker -> Message bus -> Hono
 	return router.AddHandler("events_bus",
@@ -16,6 +16,7 @@ import (
 	"context"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -30,11 +31,19 @@ import (
 
 	conn "github.com/eclipse-kanto/suite-connector/connector"
 
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/health"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/replica"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
 	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
 )
 
+// echoSuppressionTTL is the duration for which the forwarded to the cloud commands are tracked,
+// so that their echoed back twin events are not republished to the local subscribers.
+const echoSuppressionTTL = time.Minute
+
 // Launcher contains the launch system data.
 type launcher struct {
 	manager   conn.SubscriptionManager
@@ -44,6 +53,8 @@ type launcher struct {
 
 	done    chan bool
 	signals chan os.Signal
+
+	maintenance *persistence.Maintenance
 }
 
 func newLauncher(client *conn.MQTTConnection, pub message.Publisher, manager conn.SubscriptionManager) app.Launcher {
@@ -79,8 +90,52 @@ func (l *launcher) Run(
 		DeviceID:         settings.Settings.DeviceID,
 		TenantID:         settings.Settings.TenantID,
 		AutoProvisioning: settings.Settings.AutoProvisioningEnabled,
+
+		AutoProvisioningFilter:  settings.AutoProvisioningFilter(),
+		FeatureAutoProvisioning: settings.AutoProvisioningFeatures,
+
+		SortedKeys:        settings.SortedKeys,
+		EventsExtraFields: settings.EventsExtraFields,
+		FeatureRevisions:  settings.FeatureRevisions,
+		StrictMode:        settings.StrictMode,
+
+		SearchDisabled: !settings.SearchEnabled,
+		LiveDisabled:   !settings.LiveEnabled,
+		BatchDisabled:  !settings.BatchEnabled,
+		LocalPolicies:  settings.PoliciesEnabled,
+
+		MaxEnvelopeSize: settings.MaxEnvelopeSize,
+		MaxValueSize:    settings.MaxValueSize,
+
+		RateLimit: settings.CommandsRateLimit,
+		RateBurst: settings.CommandsRateBurst,
+
+		ThingQuota: settings.ThingQuota,
+
+		DuplicatesCacheSize: settings.DuplicatesCacheSize,
+	}
+	if len(settings.ViewsFile) > 0 {
+		if deviceInfo.Views, err = commands.LoadViews(settings.ViewsFile); err != nil {
+			return errors.Wrap(err, "failed to load the composite views")
+		}
+	}
+	if len(settings.RedactionFile) > 0 {
+		if deviceInfo.Redaction, err = commands.LoadRedaction(settings.RedactionFile); err != nil {
+			return errors.Wrap(err, "failed to load the events redaction rules")
+		}
+	}
+	var validators *commands.Validators
+	if len(settings.DefinitionsModels) > 0 {
+		if validators, err = commands.LoadValidators(settings.DefinitionsModels); err != nil {
+			return errors.Wrap(err, "failed to load the feature definitions models")
+		}
+	}
+	if err := commands.ValidateDevice(deviceInfo.DeviceID, deviceInfo.TenantID); err != nil {
+		return err
 	}
 	logger.Infof("Launching with device info %+v", deviceInfo)
+	logger.Info("Resolved the responses and events topics scheme",
+		commands.TopicSchemeFields(deviceInfo.DeviceID, deviceInfo.TenantID))
 
 	honoClient, cleanup, err := config.CreateHubConnection(settings.HubConnection(), true, logger)
 	if err != nil {
@@ -95,33 +150,279 @@ func (l *launcher) Run(
 	honoSub := config.NewHonoSub(logger, honoClient)
 
 	mosquittoSub := conn.NewSubscriber(cloudClient, conn.QosAtLeastOnce, false, logger, nil)
-	mosquittoPub := conn.NewPublisher(cloudClient, conn.QosAtLeastOnce, logger, nil)
+	var mosquittoPub message.Publisher = conn.NewPublisher(cloudClient, conn.QosAtLeastOnce, logger, nil)
+	var topicGuard *status.TopicGuard
+	if settings.TopicMaxRejections > 0 {
+		topicGuard = &status.TopicGuard{
+			DeviceID:      settings.DeviceID,
+			MaxRejections: settings.TopicMaxRejections,
+			Logger:        logger,
+		}
+		mosquittoPub = topicGuard.Wrap(mosquittoPub)
+	}
 
 	routing.CommandsResBus(router, honoPub, mosquittoSub, reqCache)
 
-	storage, err := persistence.NewThingsDB(settings.ThingsDb, settings.DeviceID)
+	storage, err := settings.ThingsStorage()
 	if err != nil {
 		return errors.Wrap(err, "failed to create Things DB")
 	}
+	if corrupted := storage.Recovered(); len(corrupted) > 0 {
+		logger.Warn("Things DB is corrupted and is started clean", nil, watermill.LogFields{
+			"path":      settings.ThingsDb,
+			"corrupted": corrupted,
+		})
+	}
+	storage.SetLimits(settings.StorageLimits())
+	if err := storage.SetDurability(settings.StorageDurability()); err != nil {
+		storage.Close()
+		return errors.Wrap(err, "failed to set Things DB durability")
+	}
+	resolver, err := sync.NewConflictResolver(settings.SyncConflictStrategy)
+	if err != nil {
+		storage.Close()
+		return errors.Wrap(err, "invalid hub synchronization conflict strategy")
+	}
+	if err := storage.SetIndexedAttributes(settings.IndexedAttributes); err != nil {
+		storage.Close()
+		return errors.Wrap(err, "failed to index Things DB")
+	}
+	if settings.ThingsDbReconcile != persistence.ReconcileOff {
+		report, err := storage.ReconcileThingIDs(settings.ThingsDbReconcile == persistence.ReconcileRebuild)
+		if err != nil {
+			storage.Close()
+			return errors.Wrap(err, "failed to reconcile Things DB")
+		}
+		if report.Repaired() {
+			logger.Warn("Things DB things IDs index is repaired", nil, watermill.LogFields{
+				"things":   report.Things,
+				"added":    report.Added,
+				"removed":  report.Removed,
+				"restored": report.Restored,
+				"orphans":  report.Orphans,
+			})
+		}
+	}
 	logger.Info("Things DB is opened", watermill.LogFields{
 		"path":     settings.ThingsDb,
 		"deviceID": storage.GetDeviceID(),
 	})
 
+	if retention := settings.BackupRetention(); retention != (persistence.BackupRetention{}) {
+		if removed, err := persistence.CleanupBackups(settings.ThingsDb, retention); err != nil {
+			logger.Error("Failed to clean up Things DB backups", err, nil)
+		} else if len(removed) > 0 {
+			logger.Info("Things DB backups are removed", watermill.LogFields{"files": removed})
+		}
+	}
+
 	routing.TelemetryBus(router, honoPub, mosquittoSub)
 
-	eventsBus(router, honoPub, cloudClient, deviceInfo, storage, logger)
+	l.maintenance = persistence.NewMaintenance(storage)
+	compaction := &compactionScheduler{
+		maintenance: l.maintenance,
+		interval:    time.Duration(settings.CompactionInterval) * time.Second,
+		quiet:       time.Duration(settings.CompactionQuietPeriod) * time.Second,
+		logger:      logger,
+	}
 
-	handler := routing.CommandsReqBus(router, mosquittoPub, honoSub, reqCache)
+	counters := &status.Counters{
+		Storage:  storage,
+		Interval: time.Duration(settings.CountersPersistInterval) * time.Second,
+		Logger:   logger,
+	}
+	if settings.ResetCounters {
+		err = counters.Reset()
+	} else {
+		err = counters.Load()
+	}
+	if err != nil {
+		logger.Error("Failed to load the metrics counters", err, nil)
+	}
+
+	var watchdog *commands.StorageWatchdog
+	if settings.StorageDeadline > 0 {
+		watchdog = &commands.StorageWatchdog{
+			Deadline: time.Duration(settings.StorageDeadline) * time.Second,
+			Counters: counters,
+			Logger:   logger,
+		}
+	}
+
+	progress := &health.Progress{}
+	brokerHealth := &health.Connection{}
+	var healthServer *health.Server
+	if len(settings.HealthAddress) > 0 {
+		healthServer = &health.Server{
+			Liveness: map[string]health.Check{
+				"handler": progress.Check(time.Duration(settings.HealthStallTimeout) * time.Second),
+			},
+			Readiness: map[string]health.Check{
+				"storage": health.StorageCheck(storage),
+				"broker":  brokerHealth.Check,
+			},
+			Logger: logger,
+		}
+		if watchdog != nil {
+			healthServer.Readiness["watchdog"] = watchdog.Check
+		}
+		if err := healthServer.Start(settings.HealthAddress); err != nil {
+			storage.Close()
+			return err
+		}
+	}
+
+	var changes commands.ChangesRecorder
+	var replicaServer *replica.Server
+	if len(settings.ReplicaAddress) > 0 {
+		feed := &replica.Feed{Storage: storage, Logger: logger}
+		replicaServer = &replica.Server{
+			Feed:      feed,
+			Heartbeat: time.Duration(settings.ReplicaHeartbeat) * time.Second,
+			Logger:    logger,
+		}
+		if err := replicaServer.Start(settings.ReplicaAddress); err != nil {
+			if healthServer != nil {
+				healthServer.Stop()
+			}
+			storage.Close()
+			return err
+		}
+		changes = feed
+	}
 
 	synchronizer := &sync.Synchronizer{
 		DeviceInfo:   deviceInfo,
 		HonoPub:      honoPub,
 		MosquittoPub: mosquittoPub,
 		Storage:      storage,
+		Budget:       settings.SyncBudget,
+		Timeout:      time.Duration(settings.SyncTimeout) * time.Second,
+		Counters:     counters,
+		Changes:      changes,
+		Resolver:     resolver,
+		Merge:        settings.SyncMerge,
 		Logger:       logger,
+
+		RetrievesValidity: time.Duration(settings.SyncRetrievesValidity) * time.Second,
+		SessionValidity:   time.Duration(settings.SyncSessionValidity) * time.Second,
+	}
+	if settings.SyncMaxDelay > 0 {
+		synchronizer.Pacer = &sync.Pacer{
+			TargetRTT: time.Duration(settings.SyncTargetRTT) * time.Millisecond,
+			MaxDelay:  time.Duration(settings.SyncMaxDelay) * time.Millisecond,
+		}
+	}
+
+	var adminServer *admin.Server
+	if len(settings.AdminSocket) > 0 {
+		adminServer = &admin.Server{
+			Storage:     storage,
+			Maintenance: l.maintenance,
+			CloudDiffer: synchronizer,
+			Logger:      logger,
+		}
+		if err := adminServer.Start(settings.AdminSocket); err != nil {
+			if healthServer != nil {
+				healthServer.Stop()
+			}
+			if replicaServer != nil {
+				replicaServer.Stop()
+			}
+			storage.Close()
+			return err
+		}
+	}
+
+	var shadow *commands.ShadowVerifier
+	if settings.ShadowPercentage > 0 {
+		shadow = &commands.ShadowVerifier{Percentage: settings.ShadowPercentage, Logger: logger}
+	}
+
+	var journal *commands.EventJournal
+	if settings.JournalEnabled {
+		journal = &commands.EventJournal{
+			Storage:   storage,
+			Retention: settings.JournalRetention(),
+			Logger:    logger,
+		}
+	}
+
+	var replay *commands.EventReplay
+	if settings.ReplayEnabled {
+		replay = &commands.EventReplay{
+			Storage:   storage,
+			Publisher: honoPub,
+			DeviceID:  settings.DeviceID,
+			TenantID:  settings.TenantID,
+			Topic:     settings.ReplayTopic,
+			MaxEvents: settings.ReplayMaxEvents,
+			Logger:    logger,
+		}
+	}
+
+	var aggregator *commands.EventAggregator
+	if settings.EventsAggregationWindow > 0 {
+		aggregator = &commands.EventAggregator{
+			Window: time.Duration(settings.EventsAggregationWindow) * time.Millisecond,
+			Logger: logger,
+		}
+	}
+
+	var federation *commands.DittoFederation
+	if len(settings.DittoFederationTopic) > 0 {
+		federation = &commands.DittoFederation{Topic: settings.DittoFederationTopic, Logger: logger}
+	}
+
+	echoes := commands.NewEchoFilter(echoSuppressionTTL)
+	commandsHandler := &commands.Handler{
+		DeviceInfo: deviceInfo,
+		Storage:    storage,
+		Logger:     logger,
+		Echoes:     echoes,
+		Counters:   counters,
+		Validators: validators,
+		Changes:    changes,
+		Shadow:     shadow,
+		Watchdog:   watchdog,
+		Journal:    journal,
+		Replay:     replay,
+		Aggregator: aggregator,
+		Federation: federation,
+	}
+	eventsBus(router, honoPub, cloudClient, commandsHandler).
+		AddMiddleware(receivedMiddleware(), maintenanceMiddleware(l.maintenance), progressMiddleware(progress))
+	if topicGuard != nil {
+		commandsHandler.MosquittoPub = topicGuard.Wrap(commandsHandler.MosquittoPub)
+	}
+
+	handler := routing.CommandsReqBus(router, mosquittoPub, honoSub, reqCache)
+
+	handler.AddMiddleware(
+		maintenanceMiddleware(l.maintenance), progressMiddleware(progress),
+		syncMiddleware(logger, synchronizer), echoMiddleware(logger, echoes),
+	)
+	if shadow != nil {
+		handler.AddMiddleware(shadowMiddleware(logger, shadow))
+	}
+
+	reporter := &status.Reporter{
+		DeviceID:   settings.DeviceID,
+		Publisher:  mosquittoPub,
+		Interval:   time.Duration(settings.ProcessStatsInterval) * time.Second,
+		Thresholds: settings.ProcessStatsThresholds(),
+		Counters:   counters,
+		Storage:    storage,
+		Logger:     logger,
+	}
+
+	expiry := &commands.Expiry{
+		Storage:         storage,
+		Publisher:       mosquittoPub,
+		Interval:        time.Duration(settings.ExpiryInterval) * time.Second,
+		DesiredInterval: time.Duration(settings.DesiredExpiryInterval) * time.Second,
+		Logger:          logger,
 	}
-	handler.AddMiddleware(syncMiddleware(logger, synchronizer))
 
 	paramsPub := conn.NewPublisher(cloudClient, conn.QosAtMostOnce, logger, nil)
 	paramsSub := conn.NewSubscriber(cloudClient, conn.QosAtMostOnce, true, logger, nil)
@@ -129,18 +430,48 @@ func (l *launcher) Run(
 	params := routing.NewGwParams(settings.DeviceID, settings.TenantID, settings.PolicyID)
 	routing.ParamsBus(router, params, paramsPub, paramsSub, logger)
 
+	routerStopped := make(chan struct{})
+
 	shutdown := func(r *message.Router) error {
 		go func() {
+			reason := status.RecoveryRestarted
 			defer func() {
+				reporter.Stop()
+				expiry.Stop()
+				compaction.stop()
+				if journal != nil {
+					journal.Stop()
+				}
+				if aggregator != nil {
+					aggregator.Flush()
+				}
+				counters.Stop()
+				if replicaServer != nil {
+					replicaServer.Stop()
+				}
+				if adminServer != nil {
+					adminServer.Stop()
+				}
+				if healthServer != nil {
+					healthServer.Stop()
+				}
+
 				routing.SendStatus(routing.StatusConnectionClosed, l.statusPub, logger)
 
 				reqCache.Close()
 
 				cleanup()
 
+				suspendPending(storage, commandsHandler, reason, logger)
 				storage.Close()
 
 				logger.Info("Messages router stopped", nil)
+				if reason == status.RecoveryRouterStopped {
+					l.restart(func() error {
+						return l.Run(false, global, args, logger)
+					}, logger)
+					return
+				}
 				l.done <- true
 			}()
 
@@ -152,6 +483,8 @@ func (l *launcher) Run(
 			}
 			cloudClient.AddConnectionListener(statusHandler)
 			defer cloudClient.RemoveConnectionListener(statusHandler)
+			cloudClient.AddConnectionListener(brokerHealth)
+			defer cloudClient.RemoveConnectionListener(brokerHealth)
 
 			errorsHandler := &routing.ErrorsHandler{
 				StatusPub: l.statusPub,
@@ -162,16 +495,31 @@ func (l *launcher) Run(
 
 			synchronizeHandler := &synchronizeHandler{
 				synchronizer: synchronizer,
+				maintenance:  l.maintenance,
 				logger:       logger,
 			}
 			honoClient.AddConnectionListener(synchronizeHandler)
 			defer honoClient.RemoveConnectionListener(synchronizeHandler)
 
+			if replay != nil {
+				replayHandler := &replayHandler{replay: replay, maintenance: l.maintenance, logger: logger}
+				honoClient.AddConnectionListener(replayHandler)
+				defer honoClient.RemoveConnectionListener(replayHandler)
+			}
+
 			if err := config.LocalConnect(context.Background(), cloudClient, logger); err != nil {
 				logger.Error("Cannot connect to local broker", err, nil)
 				app.StopRouter(r)
 				return
 			}
+			resumePending(storage, commandsHandler, mosquittoPub, settings.DeviceID, logger)
+			reporter.Start()
+			expiry.Start()
+			compaction.start()
+			if journal != nil {
+				journal.Start()
+			}
+			counters.Start()
 
 			ctx, cancel := context.WithTimeout(context.Background(), hubParamsAnnounceTimeout())
 			defer cancel()
@@ -183,12 +531,17 @@ func (l *launcher) Run(
 			if !errors.Is(err, context.Canceled) {
 				defer honoClient.Disconnect()
 
-				<-l.signals
+				select {
+				case <-l.signals:
+				case <-routerStopped:
+					reason = status.RecoveryRouterStopped
+				}
 			}
 
 			honoClient.RemoveConnectionListener(synchronizeHandler)
 			honoClient.RemoveConnectionListener(errorsHandler)
 			cloudClient.RemoveConnectionListener(statusHandler)
+			cloudClient.RemoveConnectionListener(brokerHealth)
 
 			cloudClient.Disconnect()
 
@@ -199,7 +552,12 @@ func (l *launcher) Run(
 	}
 	router.AddPlugin(shutdown)
 
-	app.StartRouter(router)
+	go func() {
+		if err := router.Run(context.Background()); err != nil {
+			logger.Error("Failed to create cloud router", err, nil)
+		}
+		close(routerStopped)
+	}()
 
 	return nil
 }
@@ -214,6 +572,15 @@ func (l *launcher) Stop() {
 	<-l.done
 }
 
+// Maintain runs the maintenance operation on the things db file, e.g. compaction or backup restore,
+// while the things db is closed and the messages handling is suspended.
+func (l *launcher) Maintain(operation func() error) error {
+	if l == nil || l.maintenance == nil {
+		return errors.New("things db is not opened")
+	}
+	return l.maintenance.Run(operation)
+}
+
 func (l *launcher) pushGwParams(ctx context.Context, params *routing.GwParams, logger logger.Logger) {
 	go func() {
 		<-ctx.Done()
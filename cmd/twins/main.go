@@ -25,6 +25,10 @@ import (
 	"github.com/eclipse-kanto/suite-connector/config"
 	"github.com/eclipse-kanto/suite-connector/flags"
 	"github.com/eclipse-kanto/suite-connector/logger"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
 )
 
 var (
@@ -37,6 +41,181 @@ func run(ctx context.Context, factory app.LaunchFactory, args []string) error {
 	cmd := new(TwinSettings)
 	flags.Add(f, &cmd.Settings)
 	f.StringVar(&cmd.ThingsDb, "thingsDb", "things.db", "Things db file")
+	f.StringVar(&cmd.ThingsDbEngine, "thingsDbEngine", persistence.EngineBolt,
+		"Things db storage engine, 'bbolt', 'sqlite' or 'memory' to keep the things in memory only")
+	f.BoolVar(&cmd.ThingsDbPartitioned, "thingsDbPartitioned", false,
+		"Keep the things of each device in its own partition of the things db file, "+
+			"instead of moving aside the things db file of another device, i.e. the things of the previously "+
+			"served gateway identities are kept and available once the service is back with their identity")
+	f.StringVar(&cmd.ThingsDbCodec, "thingsDbCodec", persistence.CodecGob,
+		"Things db values codec, 'gob', 'json' or 'cbor' to keep the values readable by external tools. "+
+			"The values written by any codec are read, so that they are migrated on modification")
+	f.StringVar(&cmd.ThingsDbReconcile, "thingsDbReconcile", persistence.ReconcileVerify,
+		"Things IDs index reconciliation on startup, 'verify' to rebuild it only if it differs from the stored things, "+
+			"'rebuild' to always rebuild it by scanning the things db or 'off'")
+	f.StringVar(&cmd.ThingsDbDurability, "thingsDbDurability", persistence.DurabilityAlways,
+		"Things db durability, 'always' to sync each write to the disk, 'interval' to write the writes at once "+
+			"on the sync interval or 'shutdown' to write them on stop only. Unless 'always', the writes since the last "+
+			"sync interval, or all the writes since the start in 'shutdown' mode, up to the max pending writes, are lost "+
+			"on a power loss or a crash of the process with the 'bbolt' engine and on a power loss with the 'sqlite' one")
+	f.IntVar(&cmd.ThingsDbSyncInterval, "thingsDbSyncInterval", 1000,
+		"Interval in milliseconds of writing the things db writes to the disk in 'interval' durability mode")
+	f.IntVar(&cmd.ThingsDbSyncMaxPending, "thingsDbSyncMaxPending", 0,
+		"Maximum number of the things db writes not written to the disk in 'interval' or 'shutdown' durability mode, "+
+			"on reaching which they are written, 0 for unlimited")
+	f.IntVar(&cmd.BackupsMaxCount, "backupsMaxCount", 3,
+		"Maximum number of the newest automatically created things db backup files to be kept, 0 for unlimited")
+	f.Int64Var(&cmd.BackupsMaxSize, "backupsMaxSize", 0,
+		"Maximum total size in bytes of the automatically created things db backup files to be kept, 0 for unlimited")
+	f.IntVar(&cmd.BackupsMaxAge, "backupsMaxAge", 0,
+		"Maximum age in seconds of the automatically created things db backup files to be kept, 0 for unlimited")
+	f.Var(flags.NewStringSliceV(&cmd.IndexedAttributes), "indexedAttributes",
+		"Space-separated slash-separated paths of the thing attributes indexed in the things db, "+
+			"so that the searches for things with equal attribute values do not load all things, e.g. 'location building/floor'")
+	f.Var(flags.NewStringSliceV(&cmd.AutoProvisioningAllow), "autoProvisioningAllow",
+		"Space-separated patterns of the thing IDs allowed to be auto-provisioned, e.g. 'org.eclipse.kanto:*'")
+	f.Var(flags.NewStringSliceV(&cmd.AutoProvisioningDeny), "autoProvisioningDeny",
+		"Space-separated patterns of the thing IDs not allowed to be auto-provisioned")
+	f.IntVar(&cmd.AutoProvisioningMaxThings, "autoProvisioningMaxThings", 0,
+		"Maximum number of stored things up to which auto-provisioning is performed, 0 for unlimited")
+	f.BoolVar(&cmd.AutoProvisioningFeatures, "autoProvisioningFeatures", false,
+		"Create the missing features on property modify commands")
+	f.BoolVar(&cmd.SortedKeys, "sortedKeys", false,
+		"Publish the local responses and events with lexicographically sorted JSON object keys")
+	f.StringVar(&cmd.EventsExtraFields, "eventsExtraFields", "",
+		"Fields selector of the thing data added as extra to the local events, e.g. 'attributes/location'")
+	f.BoolVar(&cmd.FeatureRevisions, "featureRevisions", false,
+		"Add the thing revision and the timestamp of the last modification of the feature as 'feature-revision' "+
+			"and 'feature-modified' headers to the local feature-level events and retrieve responses")
+	f.IntVar(&cmd.EventsAggregationWindow, "eventsAggregationWindow", 0,
+		"Window in milliseconds of batching the local events of a thing into a single thing merged event "+
+			"with the whole thing, 0 to publish each event")
+	f.BoolVar(&cmd.StrictMode, "strictMode", false,
+		"Reply with not implemented error to the locally unsupported commands while there is no hub connection")
+	f.StringVar(&cmd.ViewsFile, "viewsFile", "",
+		"Path to a JSON file defining the composite views of selected properties of multiple features")
+	f.StringVar(&cmd.DefinitionsModels, "definitionsModels", "",
+		"Path to a JSON file mapping the feature definition IDs to WoT Thing Model or JSON schema files, "+
+			"used to validate the modified feature properties")
+	f.StringVar(&cmd.RedactionFile, "redactionFile", "",
+		"Path to a JSON file with the rules masking or dropping the values at the matching thing paths "+
+			"of the published and journaled events, e.g. secrets stored as desired properties")
+	f.Int64Var(&cmd.SyncBudget, "syncBudget", 0,
+		"Maximum size in bytes of the messages published on a hub synchronization session, 0 for unlimited")
+	f.IntVar(&cmd.SyncTimeout, "syncTimeout", 0,
+		"Timeout in seconds of the hub synchronization retrieve commands, 0 for the default command timeout")
+	f.IntVar(&cmd.SyncRetrievesValidity, "syncRetrievesValidity", 0,
+		"Validity in seconds of the persisted hub synchronization retrieve commands, i.e. their responses received "+
+			"after a restart or reconnect are applied within it, 0 to disable")
+	f.IntVar(&cmd.SyncSessionValidity, "syncSessionValidity", 0,
+		"Validity in seconds of the persisted hub synchronization session progress, i.e. a session interrupted "+
+			"by a restart or reconnect is resumed within it since the session start, 0 to disable")
+	f.IntVar(&cmd.SyncMaxDelay, "syncMaxDelay", 0,
+		"Maximum delay in milliseconds between the hub synchronization messages on a lossy or slow hub link, "+
+			"0 to publish them without adapting to the link quality")
+	f.IntVar(&cmd.SyncTargetRTT, "syncTargetRtt", 500,
+		"Round trip time in milliseconds of a good hub link, the synchronization is throttled above twice of it")
+	f.StringVar(&cmd.SyncConflictStrategy, "syncConflictStrategy", sync.ConflictCloudWins,
+		"Resolution strategy of the desired properties modified both locally and in the hub on synchronization, "+
+			"one of 'cloud-wins', 'local-wins' or 'last-writer-wins'")
+	f.BoolVar(&cmd.SyncMerge, "syncMerge", false,
+		"Synchronize all the local changes of a thing with the hub using a single merge command "+
+			"instead of a command per change")
+	f.IntVar(&cmd.MaxEnvelopeSize, "maxEnvelopeSize", 0,
+		"Maximum size in bytes of a twin command envelope, the larger ones are rejected, 0 for unlimited")
+	f.IntVar(&cmd.MaxValueSize, "maxValueSize", 0,
+		"Maximum size in bytes of a twin command value, the larger ones are rejected, 0 for unlimited")
+	f.Float64Var(&cmd.CommandsRateLimit, "commandsRateLimit", 0,
+		"Maximum rate of the modifying twin commands per thing in commands per second, 0 for unlimited")
+	f.IntVar(&cmd.CommandsRateBurst, "commandsRateBurst", 1,
+		"Maximum number of the modifying twin commands per thing handled at once on exceeding the rate limit")
+	f.Int64Var(&cmd.ThingQuota, "thingQuota", 0,
+		"Maximum stored size in bytes of a thing, on exceeding which its further modifying twin commands are "+
+			"rejected and a warning is published, 0 for unlimited")
+	f.IntVar(&cmd.MaxThings, "maxThings", 0,
+		"Maximum number of the stored things, adding further ones is rejected, 0 for unlimited")
+	f.IntVar(&cmd.MaxFeatures, "maxFeatures", 0,
+		"Maximum number of the stored features per thing, adding further ones is rejected, 0 for unlimited")
+	f.IntVar(&cmd.MaxPropertySize, "maxPropertySize", 0,
+		"Maximum size in bytes of a stored feature property value, storing larger ones is rejected, 0 for unlimited")
+	f.IntVar(&cmd.DuplicatesCacheSize, "duplicatesCacheSize", 256,
+		"Number of the recently handled modifying twin commands remembered by correlation ID to ignore "+
+			"their redelivered duplicates, 0 to disable")
+	f.Float64Var(&cmd.ShadowPercentage, "shadowPercentage", 0,
+		"Percentage of the locally answered retrieve commands verified against the cloud responses "+
+			"while connected, any divergence is logged, 0 to disable")
+	f.IntVar(&cmd.ProcessStatsInterval, "processStatsInterval", 0,
+		"Interval in seconds of publishing the process stats into the status feature of the device thing, 0 to disable")
+	f.Int64Var(&cmd.ProcessStatsMaxHeap, "processStatsMaxHeap", 0,
+		"Allocated heap bytes, on exceeding which a resource warning is published, 0 for unlimited")
+	f.IntVar(&cmd.ProcessStatsMaxGoroutines, "processStatsMaxGoroutines", 0,
+		"Number of goroutines, on exceeding which a resource warning is published, 0 for unlimited")
+	f.IntVar(&cmd.ProcessStatsMaxOpenFiles, "processStatsMaxOpenFiles", 0,
+		"Number of open files, on exceeding which a resource warning is published, 0 for unlimited")
+	f.Float64Var(&cmd.ProcessStatsMaxCPU, "processStatsMaxCPU", 0,
+		"CPU usage percentage, on exceeding which a resource warning is published, 0 for unlimited")
+	f.IntVar(&cmd.CountersPersistInterval, "countersPersistInterval", 60,
+		"Interval in seconds of persisting the metrics counters in the things db, 0 to persist them only on stop")
+	f.BoolVar(&cmd.ResetCounters, "resetCounters", false,
+		"Reset the persisted metrics counters on start, i.e. count the handled commands, synchronized features "+
+			"and errors from zero")
+	f.StringVar(&cmd.ReplicaAddress, "replicaAddress", "",
+		"TCP address to serve the read replicas followers over gRPC, e.g. 'localhost:9080', empty to disable")
+	f.IntVar(&cmd.ReplicaHeartbeat, "replicaHeartbeat", 10,
+		"Interval in seconds of the heartbeats sent to the read replicas followers while there are no changes")
+	f.StringVar(&cmd.AdminSocket, "adminSocket", admin.DefaultSocket,
+		"Absolute path of the unix socket to serve the ldt-admin access to the things db while the service is running, "+
+			"empty to disable")
+	f.StringVar(&cmd.HealthAddress, "healthAddress", "",
+		"TCP address to serve the HTTP liveness and readiness probes on, e.g. 'localhost:8081', empty to disable")
+	f.IntVar(&cmd.HealthStallTimeout, "healthStallTimeout", 60,
+		"Timeout in seconds of a message handling, on exceeding which the liveness probe fails")
+	f.IntVar(&cmd.StorageDeadline, "storageDeadline", 0,
+		"Deadline in seconds of a twin command execution on the things db, on exceeding which the command fails "+
+			"as unavailable and the readiness probe fails until the execution completes, 0 to disable")
+	f.IntVar(&cmd.TopicMaxRejections, "topicMaxRejections", 3,
+		"Number of the consecutive authorization rejections of a local broker topic, on reaching which "+
+			"the topic is blocked and reported in the status feature of the device thing, 0 to disable")
+	f.IntVar(&cmd.ExpiryInterval, "expiryInterval", 60,
+		"Interval in seconds of removing the properties and the features with expired 'expiry' metadata "+
+			"and of releasing the expired leases, 0 to disable")
+	f.IntVar(&cmd.DesiredExpiryInterval, "desiredExpiryInterval", 0,
+		"Interval in seconds of clearing the not reconciled desired properties values with expired 'expiry' "+
+			"metadata, 0 to disable")
+	f.BoolVar(&cmd.JournalEnabled, "journalEnabled", false,
+		"Persist the locally generated thing events in a journal, readable with ldt-admin")
+	f.Int64Var(&cmd.JournalMaxSize, "journalMaxSize", 16*1024*1024,
+		"Maximum total size in bytes of the journaled events to be kept, 0 for unlimited")
+	f.IntVar(&cmd.JournalMaxAge, "journalMaxAge", 7*24*60*60,
+		"Maximum age in seconds of the journaled events to be kept, 0 for unlimited")
+	f.BoolVar(&cmd.ReplayEnabled, "replayEnabled", false,
+		"Queue the locally generated thing events while offline and replay them in order to the hub on reconnect")
+	f.StringVar(&cmd.ReplayTopic, "replayTopic", "t",
+		"Hub topic the offline events are replayed to, e.g. 't' for telemetry, the events of the things other than "+
+			"the root device are replayed to '<topic>/<tenantId>/<thingId>'")
+	f.IntVar(&cmd.ReplayMaxEvents, "replayMaxEvents", 10000,
+		"Maximum number of the queued offline events, the oldest ones are dropped on exceeding it, 0 for unlimited")
+	f.IntVar(&cmd.CompactionInterval, "compactionInterval", 0,
+		"Interval in seconds of compacting the things db file, so that the space of the removed data is released, "+
+			"0 to compact it only on ldt-admin request")
+	f.IntVar(&cmd.CompactionQuietPeriod, "compactionQuietPeriod", 10,
+		"Time in seconds the things db must not be used by a synchronization or a command "+
+			"for a scheduled compaction to start, otherwise the compaction is postponed")
+	f.StringVar(&cmd.DittoFederationTopic, "dittoFederationTopic", "",
+		"Local broker topics prefix to publish the locally generated thing events to, mapped to twin commands "+
+			"for an edge-hosted Eclipse Ditto connection generated with ldt-ditto, empty to disable")
+	f.StringVar(&cmd.Profile, "profile", "",
+		"Configuration profile tuning the default settings: 'minimal', 'standard' or 'full'")
+	f.BoolVar(&cmd.SearchEnabled, "searchEnabled", true, "Answer the things search commands locally")
+	f.BoolVar(&cmd.LiveEnabled, "liveEnabled", true, "Route the live-preferred retrieve commands to the live channel")
+	f.BoolVar(&cmd.BatchEnabled, "batchEnabled", true, "Execute the batch commands locally")
+	f.BoolVar(&cmd.PoliciesEnabled, "policiesEnabled", false,
+		"Store the policy documents of the policies commands locally instead of forwarding them to the hub")
+	fCleanupBackups := f.Bool("cleanupBackups", false, "Remove the things db backup files exceeding the retention and exit")
+	fExportState := f.String("exportState", "",
+		"Export all things of the things db as a portable JSON document to the provided new file and exit")
+	fImportState := f.String("importState", "",
+		"Replace all things of the things db with the ones of the provided exported JSON document and exit")
 
 	fConfigFile := flags.AddGlobal(f)
 
@@ -47,14 +226,21 @@ func run(ctx context.Context, factory app.LaunchFactory, args []string) error {
 		return err
 	}
 
-	settings := DefaultSettings()
-	if err := config.ReadConfig(*fConfigFile, settings); err != nil {
-		return errors.Wrap(err, "cannot parse config")
+	cli := flags.Copy(f)
+	settings, err := loadSettings(DefaultSettings(), *fConfigFile, cli)
+	if err != nil {
+		return err
 	}
 
-	cli := flags.Copy(f)
-	if err := mergo.Map(settings, cli, mergo.WithOverwriteWithEmptyValue); err != nil {
-		return errors.Wrap(err, "cannot process settings")
+	if len(settings.Profile) > 0 {
+		// reload on top of the profile, so that the explicitly configured values take precedence
+		profile, err := ProfileSettings(settings.Profile)
+		if err != nil {
+			return errors.Wrap(err, "settings validation error")
+		}
+		if settings, err = loadSettings(profile, *fConfigFile, cli); err != nil {
+			return err
+		}
 	}
 
 	if err := settings.ValidateStatic(); err != nil {
@@ -67,6 +253,28 @@ func run(ctx context.Context, factory app.LaunchFactory, args []string) error {
 	logger.Infof("Starting local digital twin %s", version)
 	flags.ConfigCheck(logger, *fConfigFile)
 
+	if *fCleanupBackups {
+		removed, err := persistence.CleanupBackups(settings.ThingsDb, settings.BackupRetention())
+		logger.Infof("Removed things db backup files %v", removed)
+		return err
+	}
+
+	if len(*fExportState) > 0 {
+		err := exportState(settings, *fExportState)
+		if err == nil {
+			logger.Infof("Things are exported to %s", *fExportState)
+		}
+		return err
+	}
+
+	if len(*fImportState) > 0 {
+		err := importState(settings, *fImportState)
+		if err == nil {
+			logger.Infof("Things are imported from %s", *fImportState)
+		}
+		return err
+	}
+
 	if err := app.Run(ctx, factory, settings, cli, logger); err != nil {
 		logger.Error("Init failure", err, nil)
 		return err
@@ -75,6 +283,17 @@ func run(ctx context.Context, factory app.LaunchFactory, args []string) error {
 	return nil
 }
 
+func loadSettings(settings *TwinSettings, configFile string, cli map[string]interface{}) (*TwinSettings, error) {
+	if err := config.ReadConfig(configFile, settings); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config")
+	}
+
+	if err := mergo.Map(settings, cli, mergo.WithOverwriteWithEmptyValue); err != nil {
+		return nil, errors.Wrap(err, "cannot process settings")
+	}
+	return settings, nil
+}
+
 func main() {
 	if err := run(context.Background(), newLauncher, os.Args[1:]); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
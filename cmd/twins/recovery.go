@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// routerRestartDelay is the delay of restarting the messages router stopped unexpectedly.
+const routerRestartDelay = 5 * time.Second
+
+// suspendPending persists the commands awaiting acknowledgements or live responses on the messages router stop,
+// so that they are awaited again after the router is restarted. The unexpected stop is persisted
+// even if there are no pending commands, so that its recovery is reported.
+func suspendPending(
+	storage persistence.ThingsStorage, handler *commands.Handler, reason string, logger logger.Logger,
+) {
+	suspended := handler.SuspendPending()
+	if len(suspended) == 0 && reason == status.RecoveryRestarted {
+		return
+	}
+
+	cmds, err := json.Marshal(suspended)
+	if err != nil {
+		logger.Error("Failed to encode the pending commands", err, nil)
+		return
+	}
+	pending := &data.PendingData{
+		Commands: cmds,
+		Reason:   reason,
+		Stopped:  time.Now().Format(time.RFC3339Nano),
+	}
+	if err := storage.SetPendingCommands(pending); err != nil {
+		logger.Error("Failed to persist the pending commands", err, nil)
+	}
+}
+
+// resumePending awaits again the commands pending on the previous messages router stop, if any,
+// and publishes the recovered message of the device status feature describing the recovery.
+func resumePending(
+	storage persistence.ThingsStorage,
+	handler *commands.Handler,
+	publisher message.Publisher,
+	deviceID string,
+	logger logger.Logger,
+) {
+	pending := &data.PendingData{}
+	if err := storage.GetPendingCommands(pending); err != nil {
+		if !errors.Is(err, persistence.ErrNotFound) {
+			logger.Error("Failed to load the pending commands", err, nil)
+		}
+		return
+	}
+	if err := storage.RemovePendingCommands(); err != nil {
+		logger.Error("Failed to remove the persisted pending commands", err, nil)
+	}
+
+	var suspended []commands.PendingCommand
+	if err := json.Unmarshal(pending.Commands, &suspended); err != nil {
+		logger.Error("Failed to decode the pending commands", err, nil)
+	}
+
+	recovery := &status.Recovery{Reason: pending.Reason, Stopped: pending.Stopped}
+	recovery.Resumed, recovery.Expired = handler.ResumePending(suspended)
+	logger.Info("Recovered after the messages router restart", watermill.LogFields{
+		"reason":  recovery.Reason,
+		"stopped": recovery.Stopped,
+		"resumed": recovery.Resumed,
+		"expired": recovery.Expired,
+	})
+
+	if err := status.PublishRecovery(publisher, deviceID, recovery); err != nil {
+		logger.Error("Failed to publish the recovery", err, nil)
+	}
+}
+
+// restart runs the launcher again after the messages router is stopped unexpectedly,
+// so that all of its subscriptions are established again. The restart is canceled by a stop request.
+func (l *launcher) restart(run func() error, logger logger.Logger) {
+	logger.Warn("Messages router stopped unexpectedly, restarting", nil, watermill.LogFields{
+		"delay": routerRestartDelay.String(),
+	})
+
+	select {
+	case <-l.signals:
+		l.done <- true
+		return
+	case <-time.After(routerRestartDelay):
+	}
+
+	if err := run(); err != nil {
+		logger.Error("Failed to restart the messages router", err, nil)
+		l.done <- true
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const recoveryDeviceID = "org.eclipse.kanto:recovery"
+
+type recordingPublisher struct {
+	msgs []*message.Message
+}
+
+func (p *recordingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestRecoveryAfterRouterStopped(t *testing.T) {
+	storage := persistence.NewInMemoryThingsDB(recoveryDeviceID, 0)
+	defer storage.Close()
+	log := testutil.NewLogger("recovery", logger.DEBUG, t)
+	handler := &commands.Handler{Storage: storage, Logger: log}
+	pub := &recordingPublisher{}
+
+	// nothing to recover after a requested restart without pending commands
+	suspendPending(storage, handler, status.RecoveryRestarted, log)
+	resumePending(storage, handler, pub, recoveryDeviceID, log)
+	assert.Empty(t, pub.msgs)
+
+	suspendPending(storage, handler, status.RecoveryRouterStopped, log)
+	resumePending(storage, handler, pub, recoveryDeviceID, log)
+	require.Equal(t, 1, len(pub.msgs))
+
+	env := &protocol.Envelope{}
+	require.NoError(t, json.Unmarshal(pub.msgs[0].Payload, env))
+	assert.Equal(t, protocol.CriterionMessages, env.Topic.Criterion)
+	assert.Equal(t, "/features/status/outbox/messages/"+status.SubjectRecovered, env.Path)
+
+	recovery := &status.Recovery{}
+	require.NoError(t, json.Unmarshal(env.Value, recovery))
+	assert.Equal(t, status.RecoveryRouterStopped, recovery.Reason)
+	assert.NotEmpty(t, recovery.Stopped)
+	assert.Equal(t, 0, recovery.Resumed)
+	assert.Equal(t, 0, recovery.Expired)
+
+	// recovered once only
+	resumePending(storage, handler, pub, recoveryDeviceID, log)
+	assert.Equal(t, 1, len(pub.msgs))
+}
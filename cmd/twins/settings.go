@@ -15,18 +15,133 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/eclipse-kanto/suite-connector/config"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
 )
 
 // TwinSettings contains the Local Digital Twin configurable data.
 type TwinSettings struct {
 	config.Settings
 
-	ThingsDb string `json:"thingsDb"`
+	ThingsDb            string `json:"thingsDb"`
+	ThingsDbEngine      string `json:"thingsDbEngine"`
+	ThingsDbPartitioned bool   `json:"thingsDbPartitioned"`
+	ThingsDbCodec       string `json:"thingsDbCodec"`
+	ThingsDbReconcile   string `json:"thingsDbReconcile"`
+
+	ThingsDbDurability     string `json:"thingsDbDurability"`
+	ThingsDbSyncInterval   int    `json:"thingsDbSyncInterval"`
+	ThingsDbSyncMaxPending int    `json:"thingsDbSyncMaxPending"`
+
+	BackupsMaxCount int   `json:"backupsMaxCount"`
+	BackupsMaxSize  int64 `json:"backupsMaxSize"`
+	BackupsMaxAge   int   `json:"backupsMaxAge"`
+
+	IndexedAttributes []string `json:"indexedAttributes"`
+
+	AutoProvisioningAllow     []string `json:"autoProvisioningAllow"`
+	AutoProvisioningDeny      []string `json:"autoProvisioningDeny"`
+	AutoProvisioningMaxThings int      `json:"autoProvisioningMaxThings"`
+	AutoProvisioningFeatures  bool     `json:"autoProvisioningFeatures"`
+
+	SortedKeys        bool   `json:"sortedKeys"`
+	EventsExtraFields string `json:"eventsExtraFields"`
+	FeatureRevisions  bool   `json:"featureRevisions"`
+	StrictMode        bool   `json:"strictMode"`
+	ViewsFile         string `json:"viewsFile"`
+	DefinitionsModels string `json:"definitionsModels"`
+	RedactionFile     string `json:"redactionFile"`
+
+	EventsAggregationWindow int `json:"eventsAggregationWindow"`
+
+	SyncBudget            int64 `json:"syncBudget"`
+	SyncTimeout           int   `json:"syncTimeout"`
+	SyncRetrievesValidity int   `json:"syncRetrievesValidity"`
+	SyncSessionValidity   int   `json:"syncSessionValidity"`
+	SyncMaxDelay          int   `json:"syncMaxDelay"`
+	SyncTargetRTT         int   `json:"syncTargetRtt"`
+
+	SyncConflictStrategy string `json:"syncConflictStrategy"`
+	SyncMerge            bool   `json:"syncMerge"`
+
+	MaxEnvelopeSize int `json:"maxEnvelopeSize"`
+	MaxValueSize    int `json:"maxValueSize"`
+
+	CommandsRateLimit float64 `json:"commandsRateLimit"`
+	CommandsRateBurst int     `json:"commandsRateBurst"`
+
+	ThingQuota int64 `json:"thingQuota"`
+
+	MaxThings       int `json:"maxThings"`
+	MaxFeatures     int `json:"maxFeatures"`
+	MaxPropertySize int `json:"maxPropertySize"`
+
+	DuplicatesCacheSize int `json:"duplicatesCacheSize"`
+
+	ShadowPercentage float64 `json:"shadowPercentage"`
+
+	ProcessStatsInterval      int     `json:"processStatsInterval"`
+	ProcessStatsMaxHeap       int64   `json:"processStatsMaxHeap"`
+	ProcessStatsMaxGoroutines int     `json:"processStatsMaxGoroutines"`
+	ProcessStatsMaxOpenFiles  int     `json:"processStatsMaxOpenFiles"`
+	ProcessStatsMaxCPU        float64 `json:"processStatsMaxCPU"`
+
+	CountersPersistInterval int  `json:"countersPersistInterval"`
+	ResetCounters           bool `json:"resetCounters"`
+
+	ReplicaAddress   string `json:"replicaAddress"`
+	ReplicaHeartbeat int    `json:"replicaHeartbeat"`
+
+	AdminSocket string `json:"adminSocket"`
+
+	HealthAddress      string `json:"healthAddress"`
+	HealthStallTimeout int    `json:"healthStallTimeout"`
+
+	StorageDeadline int `json:"storageDeadline"`
+
+	TopicMaxRejections int `json:"topicMaxRejections"`
+
+	ExpiryInterval        int `json:"expiryInterval"`
+	DesiredExpiryInterval int `json:"desiredExpiryInterval"`
+
+	JournalEnabled bool  `json:"journalEnabled"`
+	JournalMaxSize int64 `json:"journalMaxSize"`
+	JournalMaxAge  int   `json:"journalMaxAge"`
+
+	ReplayEnabled   bool   `json:"replayEnabled"`
+	ReplayTopic     string `json:"replayTopic"`
+	ReplayMaxEvents int    `json:"replayMaxEvents"`
+
+	CompactionInterval    int `json:"compactionInterval"`
+	CompactionQuietPeriod int `json:"compactionQuietPeriod"`
+
+	DittoFederationTopic string `json:"dittoFederationTopic"`
+
+	Profile string `json:"profile"`
+
+	SearchEnabled   bool `json:"searchEnabled"`
+	LiveEnabled     bool `json:"liveEnabled"`
+	BatchEnabled    bool `json:"batchEnabled"`
+	PoliciesEnabled bool `json:"policiesEnabled"`
 }
 
+// Predefined configuration profiles, selecting the enabled subsystems and the storage limits.
+const (
+	ProfileMinimal  = "minimal"
+	ProfileStandard = "standard"
+	ProfileFull     = "full"
+)
+
 // Provisioning implementation.
 func (settings *TwinSettings) Provisioning() string {
 	return settings.ProvisioningFile
@@ -42,6 +157,158 @@ func (settings *TwinSettings) HubConnection() *config.HubConnectionSettings {
 	return &settings.HubConnectionSettings
 }
 
+// BackupRetention returns the retention policy of the things db backup files.
+func (settings *TwinSettings) BackupRetention() persistence.BackupRetention {
+	return persistence.BackupRetention{
+		MaxCount: settings.BackupsMaxCount,
+		MaxSize:  settings.BackupsMaxSize,
+		MaxAge:   time.Duration(settings.BackupsMaxAge) * time.Second,
+	}
+}
+
+// JournalRetention returns the retention policy of the journaled events.
+func (settings *TwinSettings) JournalRetention() persistence.JournalRetention {
+	return persistence.JournalRetention{
+		MaxSize: settings.JournalMaxSize,
+		MaxAge:  time.Duration(settings.JournalMaxAge) * time.Second,
+	}
+}
+
+// ThingsStorage opens the things storage of the device, partitioned if configured,
+// writing its values with the configured codec.
+func (settings *TwinSettings) ThingsStorage() (persistence.ThingsStorage, error) {
+	return persistence.OpenThingsStorage(settings.ThingsDb, settings.DeviceID, persistence.StorageOptions{
+		Engine:      settings.ThingsDbEngine,
+		Codec:       settings.ThingsDbCodec,
+		Partitioned: settings.ThingsDbPartitioned,
+	})
+}
+
+// StorageDurability returns the policy of syncing the committed things db transactions to the disk.
+func (settings *TwinSettings) StorageDurability() persistence.Durability {
+	return persistence.Durability{
+		Mode:       settings.ThingsDbDurability,
+		Interval:   time.Duration(settings.ThingsDbSyncInterval) * time.Millisecond,
+		MaxPending: settings.ThingsDbSyncMaxPending,
+	}
+}
+
+// StorageLimits returns the limits of the stored things, features and feature properties.
+func (settings *TwinSettings) StorageLimits() persistence.Limits {
+	return persistence.Limits{
+		MaxThings:       settings.MaxThings,
+		MaxFeatures:     settings.MaxFeatures,
+		MaxPropertySize: settings.MaxPropertySize,
+	}
+}
+
+// AutoProvisioningFilter returns the restrictions of the things that could be auto-provisioned.
+func (settings *TwinSettings) AutoProvisioningFilter() commands.ProvisioningFilter {
+	return commands.ProvisioningFilter{
+		Allow:     settings.AutoProvisioningAllow,
+		Deny:      settings.AutoProvisioningDeny,
+		MaxThings: settings.AutoProvisioningMaxThings,
+	}
+}
+
+// ProcessStatsThresholds returns the process stats limits, on exceeding which a warning is published.
+func (settings *TwinSettings) ProcessStatsThresholds() status.Thresholds {
+	thresholds := status.Thresholds{
+		Goroutines: settings.ProcessStatsMaxGoroutines,
+		OpenFiles:  settings.ProcessStatsMaxOpenFiles,
+		CPUUsage:   settings.ProcessStatsMaxCPU,
+	}
+	if settings.ProcessStatsMaxHeap > 0 {
+		thresholds.HeapAlloc = uint64(settings.ProcessStatsMaxHeap)
+	}
+	return thresholds
+}
+
+// ValidateStatic validates the connection settings, the profile name, the events extra fields selector,
+// the indexed attributes paths, the things db engine, codec and reconciliation mode
+// the things db durability and the auto-provisioning filter patterns.
+func (settings *TwinSettings) ValidateStatic() error {
+	if err := settings.Settings.ValidateStatic(); err != nil {
+		return err
+	}
+	if len(settings.Profile) > 0 {
+		if _, err := ProfileSettings(settings.Profile); err != nil {
+			return err
+		}
+	}
+	if len(settings.EventsExtraFields) > 0 {
+		if _, err := jsonutil.SelectorToJSONPointers(settings.EventsExtraFields); err != nil {
+			return errors.Wrap(err, "invalid events extra fields")
+		}
+	}
+	for _, path := range settings.IndexedAttributes {
+		if len(strings.Trim(path, "/")) == 0 || strings.Contains(path, "//") {
+			return errors.Errorf("invalid indexed attribute path '%s'", path)
+		}
+	}
+	if settings.EventsAggregationWindow < 0 {
+		return errors.Errorf("events aggregation window %d is negative", settings.EventsAggregationWindow)
+	}
+	if settings.JournalMaxSize < 0 || settings.JournalMaxAge < 0 {
+		return errors.New("journal retention limits must not be negative")
+	}
+	if settings.ReplayEnabled {
+		if len(settings.ReplayTopic) == 0 || strings.ContainsAny(settings.ReplayTopic, "+#") {
+			return errors.Errorf("invalid offline events replay topic '%s'", settings.ReplayTopic)
+		}
+	}
+	if settings.ReplayMaxEvents < 0 {
+		return errors.Errorf("offline events replay max events %d is negative", settings.ReplayMaxEvents)
+	}
+	if settings.CompactionInterval < 0 || settings.CompactionQuietPeriod < 0 {
+		return errors.New("things db compaction schedule must not be negative")
+	}
+	if len(settings.HealthAddress) > 0 && settings.HealthStallTimeout <= 0 {
+		return errors.Errorf("health stall timeout %d is not positive", settings.HealthStallTimeout)
+	}
+	if settings.SyncMaxDelay < 0 || settings.SyncTargetRTT < 0 {
+		return errors.New("hub synchronization pacing must not be negative")
+	}
+	if _, err := sync.NewConflictResolver(settings.SyncConflictStrategy); err != nil {
+		return errors.Wrap(err, "invalid hub synchronization conflict strategy")
+	}
+	if settings.TopicMaxRejections < 0 {
+		return errors.Errorf("topic max rejections %d is negative", settings.TopicMaxRejections)
+	}
+	if settings.StorageDeadline < 0 {
+		return errors.Errorf("storage deadline %d is negative", settings.StorageDeadline)
+	}
+	if settings.ThingQuota < 0 {
+		return errors.Errorf("thing quota %d is negative", settings.ThingQuota)
+	}
+	if settings.MaxThings < 0 || settings.MaxFeatures < 0 || settings.MaxPropertySize < 0 {
+		return errors.New("storage limits must not be negative")
+	}
+	if settings.ShadowPercentage < 0 || settings.ShadowPercentage > 100 {
+		return errors.Errorf("shadow percentage %v is not between 0 and 100", settings.ShadowPercentage)
+	}
+	switch settings.ThingsDbEngine {
+	case persistence.EngineBolt, persistence.EngineSQLite, persistence.EngineMemory:
+	default:
+		return errors.Errorf("unknown things db engine '%s'", settings.ThingsDbEngine)
+	}
+	switch settings.ThingsDbCodec {
+	case persistence.CodecGob, persistence.CodecJSON, persistence.CodecCBOR:
+	default:
+		return errors.Errorf("unknown things db codec '%s'", settings.ThingsDbCodec)
+	}
+	if err := settings.StorageDurability().Validate(); err != nil {
+		return errors.Wrap(err, "invalid things db durability")
+	}
+	switch settings.ThingsDbReconcile {
+	case persistence.ReconcileOff, persistence.ReconcileVerify, persistence.ReconcileRebuild:
+	default:
+		return errors.Errorf("unknown things db reconciliation mode '%s'", settings.ThingsDbReconcile)
+	}
+	filter := settings.AutoProvisioningFilter()
+	return filter.Validate()
+}
+
 // DeepCopy implementation.
 func (settings *TwinSettings) DeepCopy() config.SettingsAccessor {
 	clone := *settings
@@ -54,9 +321,72 @@ func DefaultSettings() *TwinSettings {
 	def.LogFile = "log/local-digital-twins.log"
 
 	return &TwinSettings{
-		Settings: *def,
-		ThingsDb: "things.db",
+		Settings:          *def,
+		ThingsDb:          "things.db",
+		ThingsDbEngine:    persistence.EngineBolt,
+		ThingsDbCodec:     persistence.CodecGob,
+		ThingsDbReconcile: persistence.ReconcileVerify,
+
+		ThingsDbDurability:   persistence.DurabilityAlways,
+		ThingsDbSyncInterval: 1000,
+
+		BackupsMaxCount: 3,
+
+		CommandsRateBurst: 1,
+
+		DuplicatesCacheSize: 256,
+
+		CountersPersistInterval: 60,
+
+		ReplicaHeartbeat: 10,
+
+		AdminSocket: admin.DefaultSocket,
+
+		HealthStallTimeout: 60,
+
+		TopicMaxRejections: 3,
+
+		ExpiryInterval: 60,
+
+		SyncTargetRTT:        500,
+		SyncConflictStrategy: sync.ConflictCloudWins,
+
+		JournalMaxSize: 16 * 1024 * 1024,
+		JournalMaxAge:  7 * 24 * 60 * 60,
+
+		ReplayTopic:     "t",
+		ReplayMaxEvents: 10000,
+
+		CompactionQuietPeriod: 10,
+
+		SearchEnabled: true,
+		LiveEnabled:   true,
+		BatchEnabled:  true,
+	}
+}
+
+// ProfileSettings returns the default settings tuned by the named profile.
+// The standard profile is equal to the default settings, the minimal one disables the optional subsystems
+// and restricts the storage usage, the full one publishes the process stats.
+func ProfileSettings(profile string) (*TwinSettings, error) {
+	settings := DefaultSettings()
+	settings.Profile = profile
+
+	switch profile {
+	case ProfileMinimal:
+		settings.BackupsMaxCount = 1
+		settings.BackupsMaxSize = 16 * 1024 * 1024
+		settings.AutoProvisioningMaxThings = 100
+		settings.SearchEnabled = false
+		settings.LiveEnabled = false
+		settings.BatchEnabled = false
+	case ProfileStandard:
+	case ProfileFull:
+		settings.ProcessStatsInterval = 60
+	default:
+		return nil, errors.Errorf("unknown profile '%s'", profile)
 	}
+	return settings, nil
 }
 
 func hubParamsAnnounceTimeout() time.Duration {
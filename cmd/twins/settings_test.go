@@ -14,10 +14,18 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
 )
 
 func TestTwinSettingsDefaults(t *testing.T) {
@@ -26,6 +34,46 @@ func TestTwinSettingsDefaults(t *testing.T) {
 	assert.NotNil(t, settings.HubConnection())
 	assert.Equal(t, settings.ProvisioningFile, settings.Provisioning())
 	assert.Equal(t, settings, settings.DeepCopy())
+	assert.Equal(t, 3, settings.BackupRetention().MaxCount)
+	assert.Equal(t, int64(0), settings.BackupRetention().MaxSize)
+	assert.Equal(t, time.Duration(0), settings.BackupRetention().MaxAge)
+	assert.False(t, settings.SortedKeys)
+	assert.True(t, settings.SearchEnabled)
+	assert.True(t, settings.LiveEnabled)
+	assert.True(t, settings.BatchEnabled)
+	assert.False(t, settings.PoliciesEnabled)
+	assert.Equal(t, 60, settings.ExpiryInterval)
+	assert.Zero(t, settings.DesiredExpiryInterval, "the desired expiry is opt-in")
+	assert.Equal(t, admin.DefaultSocket, settings.AdminSocket)
+}
+
+func TestProfileSettings(t *testing.T) {
+	standard, err := ProfileSettings(ProfileStandard)
+	require.NoError(t, err)
+	assert.Equal(t, ProfileStandard, standard.Profile)
+	standard.Profile = ""
+	assert.Equal(t, DefaultSettings(), standard)
+
+	minimal, err := ProfileSettings(ProfileMinimal)
+	require.NoError(t, err)
+	assert.Equal(t, 1, minimal.BackupRetention().MaxCount)
+	assert.True(t, minimal.AutoProvisioningFilter().MaxThings > 0)
+	assert.False(t, minimal.SearchEnabled)
+	assert.False(t, minimal.LiveEnabled)
+	assert.False(t, minimal.BatchEnabled)
+
+	full, err := ProfileSettings(ProfileFull)
+	require.NoError(t, err)
+	assert.Equal(t, 3, standard.BackupRetention().MaxCount)
+	assert.Equal(t, 3, full.BackupRetention().MaxCount)
+	assert.True(t, full.SearchEnabled)
+
+	_, err = ProfileSettings("tiny")
+	assert.Error(t, err)
+
+	settings := DefaultSettings()
+	settings.Profile = "tiny"
+	assert.Error(t, settings.ValidateStatic())
 }
 
 func TestParamsAnnounceTimeout(t *testing.T) {
@@ -38,3 +86,269 @@ func TestParamsAnnounceTimeout(t *testing.T) {
 	assert.NoError(t, os.Setenv("HUB_PARAMS_ANNOUNCE_TIMEOUT", "1"))
 	assert.Equal(t, time.Second, hubParamsAnnounceTimeout())
 }
+
+func TestValidateEventsExtraFields(t *testing.T) {
+	settings := DefaultSettings()
+	require.NoError(t, settings.ValidateStatic())
+
+	settings.EventsExtraFields = "attributes(location,model),features/meter/properties"
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.EventsExtraFields = "attributes(location"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateEventsAggregationWindow(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, 0, settings.EventsAggregationWindow)
+
+	settings.EventsAggregationWindow = 100
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.EventsAggregationWindow = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateStorageDeadline(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, 0, settings.StorageDeadline)
+
+	settings.StorageDeadline = 5
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.StorageDeadline = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateThingsDbEngine(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, persistence.EngineBolt, settings.ThingsDbEngine)
+
+	settings.ThingsDbEngine = persistence.EngineSQLite
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbEngine = persistence.EngineMemory
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbEngine = "leveldb"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateThingsDbCodec(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, persistence.CodecGob, settings.ThingsDbCodec)
+
+	settings.ThingsDbCodec = persistence.CodecJSON
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbCodec = persistence.CodecCBOR
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbCodec = "protobuf"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateThingsDbReconcile(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, persistence.ReconcileVerify, settings.ThingsDbReconcile)
+
+	settings.ThingsDbReconcile = persistence.ReconcileRebuild
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbReconcile = persistence.ReconcileOff
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbReconcile = "repair"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateThingsDbDurability(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, persistence.DurabilityAlways, settings.ThingsDbDurability)
+	assert.Equal(t, time.Second, settings.StorageDurability().Interval)
+
+	settings.ThingsDbDurability = persistence.DurabilityInterval
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbSyncInterval = 0
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.ThingsDbDurability = persistence.DurabilityShutdown
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingsDbSyncMaxPending = -1
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.ThingsDbSyncMaxPending = 100
+	settings.ThingsDbDurability = "never"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateShadowPercentage(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, float64(0), settings.ShadowPercentage)
+
+	settings.ShadowPercentage = 12.5
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ShadowPercentage = 101
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.ShadowPercentage = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateThingQuota(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, int64(0), settings.ThingQuota)
+
+	settings.ThingQuota = 1024
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ThingQuota = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestStorageLimits(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, persistence.Limits{}, settings.StorageLimits())
+
+	settings.MaxThings = 10
+	settings.MaxFeatures = 20
+	settings.MaxPropertySize = 1024
+	assert.NoError(t, settings.ValidateStatic())
+	assert.Equal(t, persistence.Limits{MaxThings: 10, MaxFeatures: 20, MaxPropertySize: 1024}, settings.StorageLimits())
+
+	settings.MaxFeatures = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestJournalRetention(t *testing.T) {
+	settings := DefaultSettings()
+	assert.False(t, settings.JournalEnabled)
+	assert.Equal(t, persistence.JournalRetention{
+		MaxSize: 16 * 1024 * 1024, MaxAge: 7 * 24 * time.Hour,
+	}, settings.JournalRetention())
+
+	settings.JournalMaxAge = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestProcessStatsThresholds(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, 0, settings.ProcessStatsInterval)
+	assert.Equal(t, status.Thresholds{}, settings.ProcessStatsThresholds())
+
+	settings.ProcessStatsMaxHeap = 1024
+	settings.ProcessStatsMaxGoroutines = 100
+	settings.ProcessStatsMaxOpenFiles = 50
+	settings.ProcessStatsMaxCPU = 80
+	assert.Equal(t, status.Thresholds{
+		HeapAlloc: 1024, Goroutines: 100, OpenFiles: 50, CPUUsage: 80,
+	}, settings.ProcessStatsThresholds())
+}
+
+func TestValidateSyncPacing(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, 0, settings.SyncMaxDelay)
+	assert.Equal(t, 500, settings.SyncTargetRTT)
+
+	settings.SyncMaxDelay = 2000
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.SyncMaxDelay = -1
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.SyncMaxDelay = 0
+	settings.SyncTargetRTT = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateSyncConflictStrategy(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, sync.ConflictCloudWins, settings.SyncConflictStrategy)
+
+	settings.SyncConflictStrategy = sync.ConflictLastWriterWins
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.SyncConflictStrategy = "device-wins"
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateCompaction(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Equal(t, 0, settings.CompactionInterval)
+	assert.Equal(t, 10, settings.CompactionQuietPeriod)
+
+	settings.CompactionInterval = 24 * 60 * 60
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.CompactionInterval = -1
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.CompactionInterval = 0
+	settings.CompactionQuietPeriod = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateReplay(t *testing.T) {
+	settings := DefaultSettings()
+	assert.False(t, settings.ReplayEnabled)
+	assert.Equal(t, "t", settings.ReplayTopic)
+	assert.Equal(t, 10000, settings.ReplayMaxEvents)
+
+	settings.ReplayEnabled = true
+	settings.ReplayTopic = "telemetry"
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.ReplayTopic = "telemetry/#"
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.ReplayTopic = ""
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.ReplayTopic = "t"
+	settings.ReplayMaxEvents = -1
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestValidateIndexedAttributes(t *testing.T) {
+	settings := DefaultSettings()
+	assert.Empty(t, settings.IndexedAttributes)
+
+	settings.IndexedAttributes = []string{"location", "/building/floor"}
+	assert.NoError(t, settings.ValidateStatic())
+
+	settings.IndexedAttributes = []string{"building//floor"}
+	assert.Error(t, settings.ValidateStatic())
+
+	settings.IndexedAttributes = []string{"/"}
+	assert.Error(t, settings.ValidateStatic())
+}
+
+func TestThingsStoragePartitioned(t *testing.T) {
+	settings := DefaultSettings()
+	settings.ThingsDb = filepath.Join(t.TempDir(), "things.db")
+	settings.ThingsDbPartitioned = true
+
+	// the things of the previously served gateway identity are kept
+	served := map[string]bool{}
+	for _, deviceID := range []string{"test:gateway-a", "test:gateway-b", "test:gateway-a"} {
+		settings.DeviceID = deviceID
+		storage, err := settings.ThingsStorage()
+		require.NoError(t, err)
+		assert.Equal(t, deviceID, storage.GetDeviceID())
+
+		ids, err := storage.GetThingIDs()
+		require.NoError(t, err)
+		if served[deviceID] {
+			assert.Equal(t, []string{deviceID}, ids)
+		} else {
+			assert.Empty(t, ids)
+			_, err = storage.AddThing(&model.Thing{ID: model.NewNamespacedIDFrom(deviceID)})
+			require.NoError(t, err)
+			served[deviceID] = true
+		}
+		require.NoError(t, storage.Close())
+	}
+}
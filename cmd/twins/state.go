@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+// exportState writes all things of the things db as a portable JSON document to the file, which must not exist.
+func exportState(settings *TwinSettings, file string) error {
+	storage, err := openStateStorage(settings)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "cannot create the export file")
+	}
+	if err := storage.Export(out); err != nil {
+		out.Close()
+		os.Remove(file)
+		return err
+	}
+	return out.Close()
+}
+
+// importState replaces all things of the things db with the ones of the JSON document file.
+func importState(settings *TwinSettings, file string) error {
+	storage, err := openStateStorage(settings)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	in, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "cannot open the import file")
+	}
+	defer in.Close()
+
+	return storage.Import(in)
+}
+
+func openStateStorage(settings *TwinSettings) (persistence.ThingsStorage, error) {
+	if settings.ThingsDbEngine == persistence.EngineMemory {
+		return nil, errors.New("the things are not persisted by the memory storage engine")
+	}
+	storage, err := settings.ThingsStorage()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open Things DB")
+	}
+	return storage, nil
+}
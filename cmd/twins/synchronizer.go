@@ -13,11 +13,16 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/health"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
 	"github.com/eclipse-kanto/suite-connector/logger"
 )
@@ -25,12 +30,15 @@ import (
 type synchronizeHandler struct {
 	logger       logger.Logger
 	synchronizer *sync.Synchronizer
+	maintenance  *persistence.Maintenance
 }
 
 func (h *synchronizeHandler) Connected(connected bool, err error) {
 	if connected {
 		go func() {
 			time.Sleep(2 * time.Second)
+			release := h.maintenance.Use()
+			defer release()
 			if err := h.synchronizer.Start(); err != nil {
 				h.logger.Error("Synchronize error", err, nil)
 			}
@@ -40,6 +48,29 @@ func (h *synchronizeHandler) Connected(connected bool, err error) {
 	}
 }
 
+type replayHandler struct {
+	logger      logger.Logger
+	replay      *commands.EventReplay
+	maintenance *persistence.Maintenance
+}
+
+func (h *replayHandler) Connected(connected bool, err error) {
+	h.replay.Connected(connected)
+	if connected {
+		go func() {
+			release := h.maintenance.Use()
+			defer release()
+			replayed, err := h.replay.Replay()
+			if err != nil {
+				h.logger.Error("Offline events replay error", err, nil)
+			}
+			if replayed > 0 {
+				h.logger.Infof("Replayed %d offline events", replayed)
+			}
+		}()
+	}
+}
+
 func syncMiddleware(logger watermill.LoggerAdapter, synchronizer *sync.Synchronizer) message.HandlerMiddleware {
 	return func(h message.HandlerFunc) message.HandlerFunc {
 		return func(message *message.Message) ([]*message.Message, error) {
@@ -58,3 +89,69 @@ func syncMiddleware(logger watermill.LoggerAdapter, synchronizer *sync.Synchroni
 		}
 	}
 }
+
+func receivedMiddleware() message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(message *message.Message) ([]*message.Message, error) {
+			message.Metadata.Set(commands.MetadataReceived, time.Now().Format(time.RFC3339Nano))
+			return h(message)
+		}
+	}
+}
+
+func maintenanceMiddleware(maintenance *persistence.Maintenance) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(message *message.Message) ([]*message.Message, error) {
+			release := maintenance.Use()
+			defer release()
+
+			return h(message)
+		}
+	}
+}
+
+// progressMiddleware records the messages in handling, so that a stalled handling fails the liveness probe.
+func progressMiddleware(progress *health.Progress) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(message *message.Message) ([]*message.Message, error) {
+			done := progress.Begin()
+			defer done()
+
+			return h(message)
+		}
+	}
+}
+
+func echoMiddleware(logger watermill.LoggerAdapter, echoes *commands.EchoFilter) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(message *message.Message) ([]*message.Message, error) {
+			env := protocol.Envelope{}
+			if err := json.Unmarshal(message.Payload, &env); err == nil && echoes.IsEcho(&env) {
+				logger.Trace("Hub event of locally applied command suppressed", watermill.LogFields{
+					"topic": env.Topic.String(),
+					"path":  env.Path,
+				})
+				return nil, nil
+			}
+
+			return h(message)
+		}
+	}
+}
+
+func shadowMiddleware(logger watermill.LoggerAdapter, shadow *commands.ShadowVerifier) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(message *message.Message) ([]*message.Message, error) {
+			env := protocol.Envelope{}
+			if err := json.Unmarshal(message.Payload, &env); err == nil && shadow.ResponseReceived(&env) {
+				logger.Trace("Hub response of verified retrieve command consumed", watermill.LogFields{
+					"topic": env.Topic.String(),
+					"path":  env.Path,
+				})
+				return nil, nil
+			}
+
+			return h(message)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build integration
+
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/eclipse-kanto/kanto/integration/util"
+	"github.com/eclipse/ditto-clients-golang/protocol"
+	"github.com/eclipse/ditto-clients-golang/protocol/things"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ErrResponseTimeout is returned if no command response is received within the configured timeout.
+var ErrResponseTimeout = errors.New("response timeout")
+
+// Event is the expected event of a command, received via the digital twin websocket.
+type Event struct {
+	// Filter is the RQL filter of the subscription for the events, e.g. "like(resource:path,'/features/*')".
+	Filter string
+	// Topic is the expected event topic.
+	Topic string
+	// Path is the expected event path.
+	Path string
+	// Value is the expected event value, nil if the event has no value.
+	Value interface{}
+}
+
+// AssertEvent sends the command to the local MQTT broker and asserts that the expected event is received
+// via the digital twin websocket within the configured websocket events timeout.
+func (suite *Suite) AssertEvent(command *things.Command, expected *Event) {
+	ws, err := util.NewDigitalTwinWSConnection(suite.Cfg)
+	require.NoError(suite.T(), err, "cannot create a websocket connection to the backend")
+	defer ws.Close()
+
+	require.NoError(suite.T(), util.SubscribeForWSMessages(suite.Cfg, ws, util.StartSendEvents, expected.Filter),
+		"subscription for events should succeed")
+	defer util.UnsubscribeFromWSMessages(suite.Cfg, ws, util.StopSendEvents)
+
+	value, err := jsonValue(expected.Value)
+	require.NoError(suite.T(), err, "invalid expected event value")
+
+	msg := command.Envelope(protocol.WithResponseRequired(true))
+	require.NoError(suite.T(), util.SendMQTTMessage(suite.Cfg, suite.MQTTClient, EventsTopic, msg),
+		"unable to send event to the backend")
+
+	result := util.ProcessWSMessages(suite.Cfg, ws, func(msg *protocol.Envelope) (bool, error) {
+		if expected.Topic == msg.Topic.String() && expected.Path == msg.Path && reflect.DeepEqual(msg.Value, value) {
+			return true, nil
+		}
+		return false, fmt.Errorf("unexpected value: %s", msg.Value)
+	})
+	require.NoError(suite.T(), result, "event should be received")
+}
+
+// CommandResponse sends the command to the local MQTT broker and returns its response, received via
+// the local MQTT broker within the configured response timeout.
+func (suite *Suite) CommandResponse(command *things.Command) (*protocol.Envelope, error) {
+	correlationID := uuid.New().String()
+	msg := command.Envelope(protocol.WithResponseRequired(true), protocol.WithCorrelationID(correlationID))
+
+	done := make(chan *protocol.Envelope, 1)
+	dittoHandler := func(requestID string, msg *protocol.Envelope) {
+		if msg.Headers.CorrelationID() != correlationID || msg.Topic.String() != command.Topic.String() {
+			return
+		}
+		// the retrieve responses have no originator
+		if msg.Topic.Action == protocol.ActionRetrieve || msg.Headers.Originator() != "" {
+			select {
+			case done <- msg:
+			default:
+			}
+		}
+	}
+	suite.DittoClient.Subscribe(dittoHandler)
+	defer suite.DittoClient.Unsubscribe(dittoHandler)
+
+	if err := util.SendMQTTMessage(suite.Cfg, suite.MQTTClient, EventsTopic, msg); err != nil {
+		return nil, errors.Wrap(err, "unable to send command to the backend")
+	}
+
+	select {
+	case response := <-done:
+		return response, nil
+	case <-time.After(suite.Config.ResponseTimeout()):
+		return nil, ErrResponseTimeout
+	}
+}
+
+// AssertResponseStatus sends the command and asserts the status of its response.
+// Returns the response for further assertions.
+func (suite *Suite) AssertResponseStatus(command *things.Command, status int) *protocol.Envelope {
+	response, err := suite.CommandResponse(command)
+	require.NoError(suite.T(), err, "could not get response")
+	assert.Equal(suite.T(), status, response.Status, "unexpected status code")
+	return response
+}
+
+// AssertJSONEqual asserts that the retrieved digital twin API resource equals to the expected value.
+func (suite *Suite) AssertJSONEqual(expected interface{}, actual []byte) {
+	expectedBody, err := json.Marshal(expected)
+	require.NoError(suite.T(), err, "invalid expected value")
+	assert.JSONEq(suite.T(), string(expectedBody), string(actual))
+}
+
+// jsonValue returns the value as unmarshalled from its JSON representation, so that it could be compared
+// with the received envelopes values. The strings and nil are returned as they are.
+func jsonValue(value interface{}) (interface{}, error) {
+	if value == nil || reflect.TypeOf(value).Kind() == reflect.String {
+		return value, nil
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	jsonValue := make(map[string]interface{})
+	if err := json.Unmarshal(bytes, &jsonValue); err != nil {
+		return nil, err
+	}
+	return jsonValue, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2023 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build integration
+
+// Package harness provides the reusable setup of the local digital twins integration suites, i.e. the suite
+// bootstrap from the environment, the test thing lifecycle helpers and the assertions of the events received
+// via the digital twin websocket and the responses received via the local MQTT broker.
+//
+// An integration suite of a subsystem embeds the Suite and sets it up and tears it down with SetupHarness and
+// TearDownHarness, e.g.
+//
+//	type searchSuite struct {
+//		harness.Suite
+//	}
+//
+//	func (suite *searchSuite) SetupSuite() {
+//		suite.SetupHarness()
+//	}
+//
+//	func (suite *searchSuite) TearDownSuite() {
+//		suite.TearDownHarness()
+//	}
+package harness
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/eclipse-kanto/kanto/integration/util"
+	"github.com/eclipse/ditto-clients-golang/model"
+	"github.com/eclipse/ditto-clients-golang/protocol"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// EventsTopic is the local MQTT topic the commands of the device are sent to the local digital twins.
+const EventsTopic = "e"
+
+// Configuration contains the local digital twins integration settings, provided via the environment.
+type Configuration struct {
+	StatusTimeoutMs             int    `env:"SCT_STATUS_TIMEOUT_MS" envDefault:"10000"`
+	StatusReadySinceTimeDeltaMs int    `env:"SCT_STATUS_READY_SINCE_TIME_DELTA_MS" envDefault:"0"`
+	StatusRetryIntervalMs       int    `env:"SCT_STATUS_RETRY_INTERVAL_MS" envDefault:"2000"`
+	ResponseTimeoutMs           int    `env:"LDT_RESPONSE_TIMEOUT_MS" envDefault:"30000"`
+	PolicyID                    string `env:"POLICY_ID"`
+}
+
+// ResponseTimeout returns the timeout of waiting for a command response.
+func (cfg *Configuration) ResponseTimeout() time.Duration {
+	return util.MillisToDuration(cfg.ResponseTimeoutMs)
+}
+
+// Suite is the base of the local digital twins integration suites. It connects to the local MQTT broker
+// and the digital twin API of the device and creates the device test thing on setup.
+type Suite struct {
+	suite.Suite
+	util.SuiteInitializer
+
+	// Config contains the local digital twins integration settings.
+	Config *Configuration
+	// ThingID is the namespaced ID of the device test thing.
+	ThingID *model.NamespacedID
+	// ThingURL is the digital twin API URL of the device test thing.
+	ThingURL string
+}
+
+// SetupHarness connects the suite and creates the device test thing with the configured policy.
+func (suite *Suite) SetupHarness() {
+	suite.Setup(suite.T())
+
+	cfg := &Configuration{}
+	opts := env.Options{RequiredIfNoDef: true}
+	require.NoError(suite.T(), env.Parse(cfg, opts), "failed to process local digital twins test environment variables")
+	suite.Config = cfg
+
+	suite.ThingID = model.NewNamespacedIDFrom(suite.ThingCfg.DeviceID)
+	suite.ThingURL = util.GetThingURL(suite.Cfg.DigitalTwinAPIAddress, suite.ThingCfg.DeviceID)
+	suite.CreateThing(suite.TestThing())
+}
+
+// TearDownHarness removes the device test thing and disconnects the suite.
+func (suite *Suite) TearDownHarness() {
+	suite.RemoveThing()
+	suite.TearDown()
+}
+
+// TestThing returns a new empty device test thing with the configured policy.
+func (suite *Suite) TestThing() *model.Thing {
+	return (&model.Thing{}).WithID(suite.ThingID).WithPolicyIDFrom(suite.Config.PolicyID)
+}
+
+// TwinEventTopic returns the twin events topic of the device test thing with the provided action.
+func (suite *Suite) TwinEventTopic(action protocol.TopicAction) string {
+	return util.GetTwinEventTopic(suite.ThingCfg.DeviceID, action)
+}
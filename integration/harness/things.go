@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build integration
+
+package harness
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eclipse-kanto/kanto/integration/util"
+	"github.com/eclipse/ditto-clients-golang/model"
+	"github.com/eclipse/ditto-clients-golang/protocol"
+	"github.com/eclipse/ditto-clients-golang/protocol/things"
+	"github.com/stretchr/testify/require"
+)
+
+// Command returns a new command of the device test thing.
+func (suite *Suite) Command() *things.Command {
+	return things.NewCommand(suite.ThingID)
+}
+
+// Send sends the command without requiring a response, failing the test if it could not be sent.
+func (suite *Suite) Send(command *things.Command, msgAndArgs ...interface{}) {
+	require.NoError(suite.T(),
+		suite.DittoClient.Send(command.Envelope(protocol.WithResponseRequired(false))), msgAndArgs...)
+}
+
+// CreateThing creates the thing, replacing the existing one.
+func (suite *Suite) CreateThing(thing *model.Thing) {
+	suite.Send(things.NewCommand(thing.ID).Twin().Create(thing), "creation of test thing failed")
+}
+
+// RemoveThing removes the device test thing.
+func (suite *Suite) RemoveThing() {
+	suite.Send(suite.Command().Twin().Delete(), "removal of test thing failed")
+}
+
+// CreateFeature creates or replaces the feature of the device test thing.
+func (suite *Suite) CreateFeature(featureID string, feature *model.Feature) {
+	suite.Send(suite.Command().Twin().Feature(featureID).Modify(feature), "creation of test feature failed")
+}
+
+// RemoveFeatures removes all features of the device test thing.
+func (suite *Suite) RemoveFeatures() {
+	suite.Send(suite.Command().Twin().Features().Delete(), "removal of test features failed")
+}
+
+// GetThing retrieves the device test thing via the digital twin API.
+func (suite *Suite) GetThing() ([]byte, error) {
+	return suite.GetURL(suite.ThingURL)
+}
+
+// GetFeature retrieves the feature of the device test thing via the digital twin API.
+func (suite *Suite) GetFeature(featureID string) ([]byte, error) {
+	return suite.GetURL(util.GetFeatureURL(suite.ThingURL, featureID))
+}
+
+// GetFeatures retrieves all features of the device test thing via the digital twin API.
+func (suite *Suite) GetFeatures() ([]byte, error) {
+	return suite.GetURL(suite.ThingURL + "/features")
+}
+
+// GetURL retrieves the digital twin API resource with the provided URL.
+func (suite *Suite) GetURL(url string) ([]byte, error) {
+	return util.SendDigitalTwinRequest(suite.Cfg, http.MethodGet, url, nil)
+}
+
+// ToMap unmarshals the JSON object, failing the test if it is not a JSON object.
+func (suite *Suite) ToMap(bytes []byte) map[string]interface{} {
+	value := make(map[string]interface{})
+	require.NoError(suite.T(), json.Unmarshal(bytes, &value), "could not unmarshal")
+	return value
+}
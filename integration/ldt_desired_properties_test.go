@@ -66,22 +66,22 @@ func (suite *ldtDesiredPropertiesSuite) TestEventModifyOrCreateDesiredProperties
 
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			suite.executeCommandEvent("e", suite.messagesFilter, properties, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.CreateFeature(featureID, testCase.feature)
+			suite.executeCommandEvent(suite.messagesFilter, properties, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			expectedBody, _ := json.Marshal(properties)
 
 			actualBody, err := suite.getAllDesiredPropertiesOfFeature(featureID)
 			require.NoError(suite.T(), err, "unable to get desired properties")
 
-			assert.True(suite.T(), reflect.DeepEqual(suite.convertToMap(expectedBody), suite.convertToMap(actualBody)))
-			suite.removeTestFeatures()
+			assert.True(suite.T(), reflect.DeepEqual(suite.ToMap(expectedBody), suite.ToMap(actualBody)))
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtDesiredPropertiesSuite) TestEventDeleteDesiredProperties() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 
 	body, err := suite.getAllDesiredPropertiesOfFeature(featureID)
 	require.Error(suite.T(), err, "desired properties of feature should have been deleted")
@@ -104,29 +104,29 @@ func (suite *ldtDesiredPropertiesSuite) TestCommandResponseModifyOrCreateDesired
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			response, err := suite.executeCommandResponse(testCase.command)
+			suite.CreateFeature(featureID, testCase.feature)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtDesiredPropertiesSuite) TestCommandResponseDeleteDesiredProperties() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Delete())
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 }
 
 func (suite *ldtDesiredPropertiesSuite) TestCommandResponseRetrieveDesiredProperties() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Retrieve())
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperties(featureID).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
 
 	actualBody, err := suite.getAllDesiredPropertiesOfFeature(featureID)
 	require.NoError(suite.T(), err, "unable to get desired properties")
-	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.convertToMap(actualBody)))
+	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.ToMap(actualBody)))
 }
@@ -65,20 +65,20 @@ func (suite *ldtDesiredPropertySuite) TestEventModifyOrCreateProperty() {
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			suite.executeCommandEvent("e", suite.messagesFilter, value, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.CreateFeature(featureID, testCase.feature)
+			suite.executeCommandEvent(suite.messagesFilter, value, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			b, _ := json.Marshal(value)
 			body, err := suite.getDesiredPropertyOfFeature(featureID, desiredProperty)
 			require.NoError(suite.T(), err, "unable to get property")
 			assert.Equal(suite.T(), string(b), strings.TrimSpace(string(body)), "desired property doesn't match")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtDesiredPropertySuite) TestEventDeleteDesiredProperty() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 
 	body, err := suite.getDesiredPropertyOfFeature(featureID, desiredProperty)
 	require.Error(suite.T(), err, fmt.Sprintf("Desired property with key: '%s' should have been deleted", desiredProperty))
@@ -101,26 +101,26 @@ func (suite *ldtDesiredPropertySuite) TestCommandResponseModifyOrCreateDesiredPr
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			response, err := suite.executeCommandResponse(testCase.command)
+			suite.CreateFeature(featureID, testCase.feature)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtDesiredPropertySuite) TestCommandResponseDeleteDesiredProperty() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Delete())
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 
 }
 
 func (suite *ldtDesiredPropertySuite) TestCommandResponseRetrieveDesiredProperty() {
-	suite.createTestFeature(featureWithDesiredProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Retrieve())
+	suite.CreateFeature(featureID, featureWithDesiredProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureDesiredProperty(featureID, desiredProperty).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
 
@@ -65,24 +65,24 @@ func (suite *ldtFeatureSuite) TestEventModifyOrCreateFeature() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.feature != nil {
-				suite.createTestFeature(testCase.feature, featureID)
+				suite.CreateFeature(featureID, testCase.feature)
 			}
-			suite.executeCommandEvent("e", suite.messagesFilter, emptyFeature, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.executeCommandEvent(suite.messagesFilter, emptyFeature, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			expectedBody, _ := json.Marshal(emptyFeature)
-			actualBody, err := suite.getFeature(featureID)
+			actualBody, err := suite.GetFeature(featureID)
 			require.NoError(suite.T(), err, "unable to get feature")
 
-			assert.True(suite.T(), reflect.DeepEqual(suite.convertToMap(expectedBody), suite.convertToMap(actualBody)))
-			suite.removeTestFeatures()
+			assert.True(suite.T(), reflect.DeepEqual(suite.ToMap(expectedBody), suite.ToMap(actualBody)))
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtFeatureSuite) TestEventDeleteFeature() {
-	suite.createTestFeature(emptyFeature, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().Feature(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, emptyFeature)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().Feature(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 
-	body, err := suite.getFeature(featureID)
+	body, err := suite.GetFeature(featureID)
 	require.Error(suite.T(), err, "feature should have been deleted")
 	assert.Nil(suite.T(), body, "body should be nil")
 }
@@ -103,29 +103,29 @@ func (suite *ldtFeatureSuite) TestCommandResponseModifyOrCreateFeature() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.feature != nil {
-				suite.createTestFeature(testCase.feature, featureID)
+				suite.CreateFeature(featureID, testCase.feature)
 			}
-			response, err := suite.executeCommandResponse(testCase.command)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtFeatureSuite) TestCommandResponseDeleteFeature() {
-	suite.createTestFeature(emptyFeature, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Feature(featureID).Delete())
+	suite.CreateFeature(featureID, emptyFeature)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Feature(featureID).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 }
 
 func (suite *ldtFeatureSuite) TestCommandResponseRetrieveFeature() {
-	suite.createTestFeature(emptyFeature, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Feature(featureID).Retrieve())
+	suite.CreateFeature(featureID, emptyFeature)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Feature(featureID).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
-	actualBody, err := suite.getFeature(featureID)
+	actualBody, err := suite.GetFeature(featureID)
 	require.NoError(suite.T(), err, "unable to get feature")
-	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.convertToMap(actualBody)))
+	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.ToMap(actualBody)))
 }
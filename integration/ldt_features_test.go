@@ -64,23 +64,23 @@ func (suite *ldtFeaturesSuite) TestEventModifyOrCreateFeatures() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.feature != nil {
-				suite.createTestFeature(testCase.feature, featureID)
+				suite.CreateFeature(featureID, testCase.feature)
 			}
-			suite.executeCommandEvent("e", suite.messagesFilter, features, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.executeCommandEvent(suite.messagesFilter, features, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			expectedBody, _ := json.Marshal(features)
-			actualBody, err := suite.getAllFeatures()
+			actualBody, err := suite.GetFeatures()
 			require.NoError(suite.T(), err, "unable to get features")
 
-			assert.True(suite.T(), reflect.DeepEqual(suite.convertToMap(expectedBody), suite.convertToMap(actualBody)))
-			suite.removeTestFeatures()
+			assert.True(suite.T(), reflect.DeepEqual(suite.ToMap(expectedBody), suite.ToMap(actualBody)))
+			suite.RemoveFeatures()
 		})
 	}
 }
 func (suite *ldtFeaturesSuite) TestEventDeleteFeatures() {
-	suite.createTestFeature(emptyFeature, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().Features().Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, emptyFeature)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().Features().Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 
-	body, err := suite.getAllFeatures()
+	body, err := suite.GetFeatures()
 	require.Error(suite.T(), err, "features should have been deleted")
 	assert.Nil(suite.T(), body, "body should be nil")
 }
@@ -101,30 +101,30 @@ func (suite *ldtFeaturesSuite) TestCommandResponseModifyOrCreateFeatures() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.feature != nil {
-				suite.createTestFeature(testCase.feature, featureID)
+				suite.CreateFeature(featureID, testCase.feature)
 			}
-			response, err := suite.executeCommandResponse(testCase.command)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtFeaturesSuite) TestCommandResponseDeleteFeatures() {
-	suite.createTestFeature(emptyFeature, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Features().Delete())
+	suite.CreateFeature(featureID, emptyFeature)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Features().Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 }
 
 func (suite *ldtFeaturesSuite) TestCommandResponseRetrieveFeatures() {
-	suite.createTestFeature(emptyFeature, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Features().Retrieve())
+	suite.CreateFeature(featureID, emptyFeature)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Features().Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
 
-	actualBody, err := suite.getAllFeatures()
+	actualBody, err := suite.GetFeatures()
 	require.NoError(suite.T(), err, "unable to get features")
-	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.convertToMap(actualBody)))
+	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.ToMap(actualBody)))
 }
@@ -67,20 +67,20 @@ func (suite *ldtPropertiesSuite) TestEventModifyOrCreateProperties() {
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			suite.executeCommandEvent("e", suite.messagesFilter, properties, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.CreateFeature(featureID, testCase.feature)
+			suite.executeCommandEvent(suite.messagesFilter, properties, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			expectedBody, _ := json.Marshal(properties)
 			actualBody, err := suite.getAllPropertiesOfFeature(featureID)
 			require.NoError(suite.T(), err, "unable to get properties")
 
-			assert.True(suite.T(), reflect.DeepEqual(suite.convertToMap(expectedBody), suite.convertToMap(actualBody)))
-			suite.removeTestFeatures()
+			assert.True(suite.T(), reflect.DeepEqual(suite.ToMap(expectedBody), suite.ToMap(actualBody)))
+			suite.RemoveFeatures()
 		})
 	}
 }
 func (suite *ldtPropertiesSuite) TestEventDeleteProperties() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().FeatureProperties(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, featureWithProperties)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().FeatureProperties(featureID).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 	body, err := suite.getAllPropertiesOfFeature(featureID)
 	require.Error(suite.T(), err, "properties should have been deleted")
 	assert.Nil(suite.T(), body, "body should be nil")
@@ -103,28 +103,28 @@ func (suite *ldtPropertiesSuite) TestCommandResponseModifyOrCreateProperties() {
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			response, err := suite.executeCommandResponse(testCase.command)
+			suite.CreateFeature(featureID, testCase.feature)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtPropertiesSuite) TestCommandResponseDeleteProperties() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureProperties(featureID).Delete())
+	suite.CreateFeature(featureID, featureWithProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureProperties(featureID).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 }
 
 func (suite *ldtPropertiesSuite) TestCommandResponseRetrieveProperties() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureProperties(featureID).Retrieve())
+	suite.CreateFeature(featureID, featureWithProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureProperties(featureID).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
 	actualBody, err := suite.getAllPropertiesOfFeature(featureID)
 	require.NoError(suite.T(), err, "unable to get properties")
-	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.convertToMap(actualBody)))
+	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.ToMap(actualBody)))
 }
@@ -66,19 +66,19 @@ func (suite *ldtPropertySuite) TestEventModifyOrCreateProperty() {
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			suite.executeCommandEvent("e", suite.messagesFilter, value, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.CreateFeature(featureID, testCase.feature)
+			suite.executeCommandEvent(suite.messagesFilter, value, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			b, _ := json.Marshal(value)
 			body, err := suite.getPropertyOfFeature(featureID, property)
 			require.NoError(suite.T(), err, "unable to get property")
 			assert.Equal(suite.T(), string(b), strings.TrimSpace(string(body)), "property doesn't match")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 func (suite *ldtPropertySuite) TestEventDeleteProperty() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().FeatureProperty(featureID, property).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	suite.CreateFeature(featureID, featureWithProperties)
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(suite.namespacedID).Twin().FeatureProperty(featureID, property).Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
 	body, err := suite.getPropertyOfFeature(featureID, property)
 	require.Error(suite.T(), err, fmt.Sprintf("Property with key: '%s' should have been deleted", property))
 	assert.Nil(suite.T(), body, "body should be nil")
@@ -101,25 +101,25 @@ func (suite *ldtPropertySuite) TestCommandResponseModifyOrCreateProperty() {
 	}
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
-			suite.createTestFeature(testCase.feature, featureID)
-			response, err := suite.executeCommandResponse(testCase.command)
+			suite.CreateFeature(featureID, testCase.feature)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
-			suite.removeTestFeatures()
+			suite.RemoveFeatures()
 		})
 	}
 }
 
 func (suite *ldtPropertySuite) TestCommandResponseDeleteProperty() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureProperty(featureID, property).Delete())
+	suite.CreateFeature(featureID, featureWithProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureProperty(featureID, property).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
 }
 
 func (suite *ldtPropertySuite) TestCommandResponseRetrieveProperty() {
-	suite.createTestFeature(featureWithProperties, featureID)
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).FeatureProperty(featureID, property).Retrieve())
+	suite.CreateFeature(featureID, featureWithProperties)
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).FeatureProperty(featureID, property).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
 	body, _ := suite.getPropertyOfFeature(featureID, property)
@@ -40,7 +40,7 @@ func (suite *ldtThingSuite) SetupSuite() {
 	suite.messagesFilter = "like(resource:path,'/')"
 	suite.expectedPath = "/"
 
-	suite.thing = (&model.Thing{}).WithID(model.NewNamespacedIDFrom(suite.ThingCfg.DeviceID)).WithPolicyIDFrom(suite.ldtTestConfiguration.PolicyId)
+	suite.thing = suite.TestThing()
 }
 
 func (suite *ldtThingSuite) TearDownSuite() {
@@ -67,27 +67,27 @@ func (suite *ldtThingSuite) TestEventModifyOrCreateThing() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.command.Topic.Action == protocol.ActionCreate {
-				suite.removeTestThing()
+				suite.RemoveThing()
 			}
-			suite.executeCommandEvent("e", suite.messagesFilter, suite.thing, testCase.command, suite.expectedPath, testCase.expectedTopic)
+			suite.executeCommandEvent(suite.messagesFilter, suite.thing, testCase.command, suite.expectedPath, testCase.expectedTopic)
 			expectedBody, err := json.Marshal(suite.thing)
 			require.NoError(suite.T(), err, "unable to marshal the expected body")
 
-			actualBody, err := suite.getThing()
+			actualBody, err := suite.GetThing()
 			require.NoError(suite.T(), err, "unable to get thing")
 
-			assert.True(suite.T(), reflect.DeepEqual(suite.convertToMap(expectedBody), suite.convertToMap(actualBody)))
+			assert.True(suite.T(), reflect.DeepEqual(suite.ToMap(expectedBody), suite.ToMap(actualBody)))
 		})
 	}
 }
 
 func (suite *ldtThingSuite) TestEventDeleteThing() {
-	suite.executeCommandEvent("e", suite.messagesFilter, nil, things.NewCommand(model.NewNamespacedIDFrom(suite.ThingCfg.DeviceID)).Twin().Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
-	body, err := suite.getThing()
+	suite.executeCommandEvent(suite.messagesFilter, nil, things.NewCommand(model.NewNamespacedIDFrom(suite.ThingCfg.DeviceID)).Twin().Delete(), suite.expectedPath, suite.twinEventTopicDeleted)
+	body, err := suite.GetThing()
 	require.Error(suite.T(), err, "thing should have been deleted")
 	assert.Nil(suite.T(), body, "body should be nil")
 
-	suite.createTestThing(suite.thing)
+	suite.CreateThing(suite.thing)
 }
 
 func (suite *ldtThingSuite) TestCommandResponseModifyOrCreateThing() {
@@ -105,9 +105,9 @@ func (suite *ldtThingSuite) TestCommandResponseModifyOrCreateThing() {
 	for testName, testCase := range tests {
 		suite.Run(testName, func() {
 			if testCase.command.Topic.Action == protocol.ActionCreate {
-				suite.removeTestThing()
+				suite.RemoveThing()
 			}
-			response, err := suite.executeCommandResponse(testCase.command)
+			response, err := suite.CommandResponse(testCase.command)
 			require.NoError(suite.T(), err, "could not get response")
 			assert.Equal(suite.T(), testCase.expectedStatusCode, response.Status, "unexpected status code")
 		})
@@ -115,17 +115,17 @@ func (suite *ldtThingSuite) TestCommandResponseModifyOrCreateThing() {
 }
 
 func (suite *ldtThingSuite) TestCommandResponseDeleteThing() {
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Delete())
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Delete())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 204, response.Status, "unexpected status code")
-	suite.createTestThing(suite.thing)
+	suite.CreateThing(suite.thing)
 }
 
 func (suite *ldtThingSuite) TestCommandResponseRetrieveThing() {
-	response, err := suite.executeCommandResponse(things.NewCommand(suite.namespacedID).Retrieve())
+	response, err := suite.CommandResponse(things.NewCommand(suite.namespacedID).Retrieve())
 	require.NoError(suite.T(), err, "could not get response")
 	assert.Equal(suite.T(), 200, response.Status, "unexpected status code")
-	actualBody, err := suite.getThing()
+	actualBody, err := suite.GetThing()
 	require.NoError(suite.T(), err, "unable to get thing")
-	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.convertToMap(actualBody)))
+	assert.True(suite.T(), reflect.DeepEqual(response.Value, suite.ToMap(actualBody)))
 }
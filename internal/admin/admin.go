@@ -0,0 +1,247 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package admin provides the administration access to the things storage, either directly to the things db
+// file while the local digital twins service is stopped, or via the admin socket of the running service,
+// as the things db file cannot be opened by another process while the service holds it.
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/pkg/errors"
+)
+
+// DefaultSocket is the default admin socket of the running service.
+const DefaultSocket = "/run/local-digital-twins/ldt-admin.sock"
+
+// Access mode names.
+const (
+	// ModeService is the access via the admin socket of the running service.
+	ModeService = "service"
+	// ModeOffline is the direct access to the things db file of the stopped service.
+	ModeOffline = "offline"
+)
+
+// Status contains the things storage summary.
+type Status struct {
+	DeviceID string `json:"deviceId"`
+	Things   int    `json:"things"`
+}
+
+// Access provides the administration operations on the things storage.
+type Access interface {
+	// Mode returns the access mode, i.e. ModeService or ModeOffline.
+	Mode() string
+	// Status returns the things storage summary.
+	Status() (*Status, error)
+	// ThingIDs returns the sorted IDs of the stored things.
+	ThingIDs() ([]string, error)
+	// ThingIDsPage returns at most limit sorted IDs of the stored things after the provided cursor,
+	// i.e. the Next cursor of the previous page or empty for the first page. A non-positive limit is unlimited.
+	ThingIDsPage(after string, limit int) (*persistence.ThingIDsPage, error)
+	// Thing returns the stored thing with the provided ID.
+	// Returns persistence.ErrThingNotFound if no thing is found with the provided ID.
+	Thing(thingID string) (*model.Thing, error)
+	// Events returns the journaled events of the thing with revisions within the provided inclusive range,
+	// ordered by revision. A zero or negative upper bound is unlimited.
+	Events(thingID string, fromRevision int64, toRevision int64) ([]json.RawMessage, error)
+	// Snapshot writes a consistent copy of the things db file.
+	Snapshot(w io.Writer) error
+	// Backup writes a consistent copy of the things db file to the provided path, which must not exist.
+	// The path is local to the service, i.e. it should be absolute when accessing the service via the admin socket.
+	Backup(path string) error
+	// Export writes all things as a portable JSON document.
+	Export(w io.Writer) error
+	// CloudDiff retrieves the cloud thing with the provided ID and compares it with the local one.
+	// Returns ErrCloudUnavailable if not accessing the running service connected to the hub.
+	CloudDiff(thingID string) (*sync.CloudDiff, error)
+	// Compact compacts the things db file, so that the space of the removed data is released.
+	// The running service compacts it once the messages being handled are completed and the synchronization
+	// with the hub is finished, meanwhile any further messages handling is suspended.
+	// Returns ErrCompactionUnavailable if the things db file is not accessible for compaction.
+	Compact() (*persistence.CompactionReport, error)
+	// Reconcile rebuilds the things IDs index from the stored things, e.g. if it drifts after a crash,
+	// restoring the missing things system data and removing the records of the missing things.
+	Reconcile() (*persistence.ReconcileReport, error)
+	// CheckIntegrity verifies that the things IDs index, the things, the features and the things system data
+	// are mutually consistent, repairing the found inconsistencies if requested.
+	CheckIntegrity(repair bool) (*persistence.IntegrityReport, error)
+	// Close releases the access.
+	Close() error
+}
+
+// ErrCloudUnavailable is returned on comparing with the cloud things without a hub connection,
+// e.g. on offline access.
+var ErrCloudUnavailable = errors.New("the cloud things are available via the running service connected to the hub only")
+
+// ErrCompactionUnavailable is returned on compacting a things db, which file is not accessible for compaction,
+// e.g. it is shared with other devices or opened via another storage.
+var ErrCompactionUnavailable = errors.New("the things db file is not accessible for compaction")
+
+// CloudDiffer compares the local things with the cloud ones, i.e. the things synchronizer.
+type CloudDiffer interface {
+	CloudDiff(thingID string) (*sync.CloudDiff, error)
+}
+
+// storageAccess provides the administration operations directly on the things storage.
+type storageAccess struct {
+	storage persistence.ThingsStorage
+	mode    string
+
+	// engine and path locate the things db file opened read-only, so that it could be compacted offline.
+	engine string
+	path   string
+}
+
+// NewStorageAccess returns the administration access to the opened things storage.
+func NewStorageAccess(storage persistence.ThingsStorage, mode string) Access {
+	return &storageAccess{storage: storage, mode: mode}
+}
+
+func (a *storageAccess) Mode() string {
+	return a.mode
+}
+
+func (a *storageAccess) Status() (*Status, error) {
+	count, err := a.storage.CountThings()
+	if err != nil {
+		return nil, err
+	}
+	return &Status{DeviceID: a.storage.GetDeviceID(), Things: count}, nil
+}
+
+func (a *storageAccess) ThingIDs() ([]string, error) {
+	ids, err := a.storage.GetThingIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (a *storageAccess) ThingIDsPage(after string, limit int) (*persistence.ThingIDsPage, error) {
+	return a.storage.GetThingIDsPage(after, limit)
+}
+
+func (a *storageAccess) Thing(thingID string) (*model.Thing, error) {
+	thing := &model.Thing{}
+	if err := a.storage.GetThing(thingID, thing); err != nil {
+		return nil, err
+	}
+	return thing, nil
+}
+
+func (a *storageAccess) Events(thingID string, fromRevision int64, toRevision int64) ([]json.RawMessage, error) {
+	entries, err := a.storage.GetEvents(thingID, fromRevision, toRevision)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, entry.Event)
+	}
+	return events, nil
+}
+
+func (a *storageAccess) Snapshot(w io.Writer) error {
+	return a.storage.Snapshot(w)
+}
+
+func (a *storageAccess) Backup(path string) error {
+	return a.storage.Backup(path)
+}
+
+func (a *storageAccess) Export(w io.Writer) error {
+	return a.storage.Export(w)
+}
+
+func (a *storageAccess) CloudDiff(thingID string) (*sync.CloudDiff, error) {
+	return nil, ErrCloudUnavailable
+}
+
+// Compact compacts the things db file opened read-only, reopening it afterwards.
+func (a *storageAccess) Compact() (*persistence.CompactionReport, error) {
+	if len(a.path) == 0 {
+		return nil, ErrCompactionUnavailable
+	}
+
+	if err := a.storage.Close(); err != nil && !errors.Is(err, persistence.ErrDatabaseClosed) {
+		return nil, err
+	}
+	report, err := persistence.Compact(a.engine, a.path)
+
+	storage, openErr := persistence.OpenReadOnly(a.engine, a.path)
+	if openErr != nil {
+		return nil, errors.Wrap(openErr, "failed to reopen the compacted things db")
+	}
+	a.storage = storage
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Reconcile rebuilds the things IDs index of the service storage or of the things db file opened read-only,
+// reopening it afterwards.
+func (a *storageAccess) Reconcile() (*persistence.ReconcileReport, error) {
+	if len(a.path) == 0 {
+		return a.storage.ReconcileThingIDs(true)
+	}
+
+	if err := a.storage.Close(); err != nil && !errors.Is(err, persistence.ErrDatabaseClosed) {
+		return nil, err
+	}
+	report, err := persistence.Reconcile(a.engine, a.path)
+
+	storage, openErr := persistence.OpenReadOnly(a.engine, a.path)
+	if openErr != nil {
+		return nil, errors.Wrap(openErr, "failed to reopen the reconciled things db")
+	}
+	a.storage = storage
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CheckIntegrity checks the integrity of the service storage or of the things db file opened read-only.
+// The things db file is reopened read-only after the repair.
+func (a *storageAccess) CheckIntegrity(repair bool) (*persistence.IntegrityReport, error) {
+	if !repair || len(a.path) == 0 {
+		return a.storage.CheckIntegrity(repair)
+	}
+
+	if err := a.storage.Close(); err != nil && !errors.Is(err, persistence.ErrDatabaseClosed) {
+		return nil, err
+	}
+	report, err := persistence.RepairIntegrity(a.engine, a.path)
+
+	storage, openErr := persistence.OpenReadOnly(a.engine, a.path)
+	if openErr != nil {
+		return nil, errors.Wrap(openErr, "failed to reopen the repaired things db")
+	}
+	a.storage = storage
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (a *storageAccess) Close() error {
+	return a.storage.Close()
+}
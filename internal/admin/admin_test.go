@@ -0,0 +1,347 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package admin_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/admin"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testDeviceID = "org.eclipse.kanto:device"
+	thingA       = "org.eclipse.kanto:a"
+	thingB       = "org.eclipse.kanto:b"
+)
+
+type AdminSuite struct {
+	suite.Suite
+
+	dir      string
+	thingsDB string
+	socket   string
+
+	storage persistence.ThingsStorage
+	server  *admin.Server
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminSuite))
+}
+
+func (s *AdminSuite) SetupTest() {
+	// keep the socket path short, as it is limited to about a hundred characters
+	dir, err := os.MkdirTemp("", "ldt")
+	require.NoError(s.T(), err)
+	s.dir = dir
+	s.thingsDB = filepath.Join(dir, "things.db")
+	s.socket = filepath.Join(dir, "admin.sock")
+
+	storage, err := persistence.NewThingsDB(s.thingsDB, testDeviceID)
+	require.NoError(s.T(), err)
+	s.storage = storage
+
+	for _, thingID := range []string{thingB, thingA} {
+		_, err := storage.AddThing(&model.Thing{ID: model.NewNamespacedIDFrom(thingID)})
+		require.NoError(s.T(), err)
+	}
+	for revision := int64(1); revision <= 3; revision++ {
+		require.NoError(s.T(), storage.AppendEvent(&data.JournalEntry{
+			ThingID: thingA, Revision: revision, Event: []byte(fmt.Sprintf(`{"revision":%d}`, revision)),
+		}))
+	}
+
+	s.server = &admin.Server{
+		Storage:     storage,
+		Maintenance: persistence.NewMaintenance(storage),
+		Logger:      testutil.NewLogger("admin", logger.DEBUG, s.T()),
+	}
+}
+
+func (s *AdminSuite) TearDownTest() {
+	s.server.Stop()
+	s.storage.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *AdminSuite) TestServiceMode() {
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	assert.Equal(s.T(), admin.ModeService, access.Mode())
+	s.assertAccess(access)
+}
+
+func (s *AdminSuite) TestOfflineMode() {
+	require.NoError(s.T(), s.storage.Close())
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	assert.Equal(s.T(), admin.ModeOffline, access.Mode())
+	s.assertAccess(access)
+}
+
+type testCloudDiffer struct{}
+
+func (testCloudDiffer) CloudDiff(thingID string) (*sync.CloudDiff, error) {
+	if thingID != thingA {
+		return nil, sync.ErrNoConnection
+	}
+	return &sync.CloudDiff{ThingID: thingID, CloudOnly: []string{"/attributes"}}, nil
+}
+
+func (s *AdminSuite) TestCloudDiff() {
+	s.server.CloudDiffer = testCloudDiffer{}
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	diff, err := access.CloudDiff(thingA)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), thingA, diff.ThingID)
+	assert.Equal(s.T(), []string{"/attributes"}, diff.CloudOnly)
+
+	_, err = access.CloudDiff(thingB)
+	assert.ErrorIs(s.T(), err, admin.ErrCloudUnavailable)
+
+	offline := admin.NewStorageAccess(s.storage, admin.ModeOffline)
+	_, err = offline.CloudDiff(thingA)
+	assert.ErrorIs(s.T(), err, admin.ErrCloudUnavailable)
+}
+
+func (s *AdminSuite) TestCompact() {
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	require.NoError(s.T(), s.storage.RemoveThing(thingB))
+	report, err := access.Compact()
+	require.NoError(s.T(), err)
+	assert.Positive(s.T(), report.SizeAfter)
+	assert.LessOrEqual(s.T(), report.SizeAfter, report.SizeBefore)
+	ids, err := access.ThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{thingA}, ids)
+
+	s.server.Stop()
+	require.NoError(s.T(), s.storage.Close())
+	offline, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer offline.Close()
+	require.Equal(s.T(), admin.ModeOffline, offline.Mode())
+
+	report, err = offline.Compact()
+	require.NoError(s.T(), err)
+	assert.Positive(s.T(), report.SizeAfter)
+	thing, err := offline.Thing(thingA)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), thingA, thing.ID.String())
+
+	_, err = admin.NewStorageAccess(s.storage, admin.ModeService).Compact()
+	assert.ErrorIs(s.T(), err, admin.ErrCompactionUnavailable)
+}
+
+func (s *AdminSuite) TestReconcile() {
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	report, err := access.Reconcile()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ReconcileReport{Things: 2, Rebuilt: true}, report)
+
+	s.server.Stop()
+	require.NoError(s.T(), s.storage.Close())
+	offline, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer offline.Close()
+	require.Equal(s.T(), admin.ModeOffline, offline.Mode())
+
+	report, err = offline.Reconcile()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, report.Things)
+	ids, err := offline.ThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{thingA, thingB}, ids)
+}
+
+func (s *AdminSuite) TestCheckIntegrity() {
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	access, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+
+	report, err := access.CheckIntegrity(false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.IntegrityReport{Things: 2}, report)
+
+	s.server.Stop()
+	require.NoError(s.T(), s.storage.Close())
+	db, err := persistence.NewDatabase(s.thingsDB)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), db.SetAs(data.FeatureKey("org.eclipse.kanto:orphan", "meter"),
+		&data.FeatureData{ThingID: "org.eclipse.kanto:orphan", ID: "meter"}))
+	require.NoError(s.T(), db.Close())
+
+	offline, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer offline.Close()
+	require.Equal(s.T(), admin.ModeOffline, offline.Mode())
+
+	report, err = offline.CheckIntegrity(false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"org.eclipse.kanto:orphan/features/meter"}, report.OrphanFeatures)
+	assert.False(s.T(), report.Repaired)
+
+	report, err = offline.CheckIntegrity(true)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), report.Repaired)
+
+	report, err = offline.CheckIntegrity(false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.IntegrityReport{Things: 2}, report)
+	ids, err := offline.ThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{thingA, thingB}, ids)
+}
+
+func (s *AdminSuite) TestServiceWithoutSocket() {
+	_, err := admin.Open(s.socket, persistence.EngineBolt, s.thingsDB)
+	assert.ErrorIs(s.T(), err, admin.ErrServiceHoldsDatabase)
+
+	_, err = admin.Open("", persistence.EngineBolt, s.thingsDB)
+	assert.ErrorIs(s.T(), err, admin.ErrServiceHoldsDatabase)
+}
+
+func (s *AdminSuite) TestStaleSocket() {
+	require.NoError(s.T(), s.server.Start(s.socket))
+	require.Error(s.T(), s.server.Start(s.socket))
+
+	s.server.Stop()
+	require.NoError(s.T(), os.WriteFile(s.socket, nil, 0600))
+	require.NoError(s.T(), s.server.Start(s.socket))
+
+	info, err := os.Stat(s.socket)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), os.FileMode(0600), info.Mode().Perm())
+}
+
+func (s *AdminSuite) TestSocketDirectory() {
+	socket := filepath.Join(filepath.Dir(s.socket), "run", "admin.sock")
+	require.NoError(s.T(), s.server.Start(socket))
+
+	info, err := os.Stat(filepath.Dir(socket))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), os.FileMode(0700), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(socket))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 1, "the private directory of the socket creation is removed")
+	assert.Equal(s.T(), "admin.sock", entries[0].Name())
+
+	access, err := admin.Open(socket, persistence.EngineBolt, s.thingsDB)
+	require.NoError(s.T(), err)
+	defer access.Close()
+	assert.Equal(s.T(), admin.ModeService, access.Mode())
+}
+
+func (s *AdminSuite) assertAccess(access admin.Access) {
+	status, err := access.Status()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &admin.Status{DeviceID: testDeviceID, Things: 2}, status)
+
+	ids, err := access.ThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{thingA, thingB}, ids)
+
+	page, err := access.ThingIDsPage("", 1)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: []string{thingA}, Next: thingA}, page)
+	page, err = access.ThingIDsPage(page.Next, 1)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: []string{thingB}}, page)
+
+	thing, err := access.Thing(thingA)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), thingA, thing.ID.String())
+
+	_, err = access.Thing("org.eclipse.kanto:missing")
+	assert.ErrorIs(s.T(), err, persistence.ErrThingNotFound)
+
+	events, err := access.Events(thingA, 2, 0)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 2)
+	assert.JSONEq(s.T(), `{"revision":2}`, string(events[0]))
+	assert.JSONEq(s.T(), `{"revision":3}`, string(events[1]))
+
+	events, err = access.Events(thingB, 0, 0)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), events)
+
+	var exported bytes.Buffer
+	require.NoError(s.T(), access.Export(&exported))
+	imported := persistence.NewInMemoryThingsDB(testDeviceID, 0)
+	defer imported.Close()
+	require.NoError(s.T(), imported.Import(&exported))
+	ids, err = imported.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{thingA, thingB}, ids)
+
+	var snapshot bytes.Buffer
+	require.NoError(s.T(), access.Snapshot(&snapshot))
+	location := filepath.Join(s.T().TempDir(), "snapshot.db")
+	require.NoError(s.T(), os.WriteFile(location, snapshot.Bytes(), 0600))
+
+	copied, err := persistence.OpenReadOnly(persistence.EngineBolt, location)
+	require.NoError(s.T(), err)
+	defer copied.Close()
+	ids, err = copied.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{thingA, thingB}, ids)
+
+	backup := filepath.Join(s.T().TempDir(), "backup.db")
+	require.NoError(s.T(), access.Backup(backup))
+	assert.ErrorIs(s.T(), access.Backup(backup), persistence.ErrBackupExists)
+
+	backedUp, err := persistence.OpenReadOnly(persistence.EngineBolt, backup)
+	require.NoError(s.T(), err)
+	defer backedUp.Close()
+	ids, err = backedUp.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{thingA, thingB}, ids)
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/pkg/errors"
+)
+
+const dialTimeout = time.Second
+
+// ErrServiceUnavailable is returned when no running service is serving on the admin socket.
+var ErrServiceUnavailable = errors.New("the service admin socket is not available")
+
+// ErrServiceHoldsDatabase is returned when the things db is locked by the running service,
+// but its admin socket is not available, e.g. it is disabled or another socket path is configured.
+var ErrServiceHoldsDatabase = errors.New(
+	"the things db is held by the running service, but its admin socket is not available")
+
+// socketAccess provides the administration operations via the admin socket of the running service.
+type socketAccess struct {
+	client *http.Client
+}
+
+// Open detects the access mode and returns the administration access to the things storage.
+// The admin socket of the running service is used if available, otherwise the things db file is opened read-only.
+// Returns ErrServiceHoldsDatabase if the service is running without an available admin socket.
+func Open(socket, engine, thingsDB string) (Access, error) {
+	access, err := Dial(socket)
+	if err == nil {
+		return access, nil
+	}
+	if !errors.Is(err, ErrServiceUnavailable) {
+		return nil, err
+	}
+
+	storage, err := persistence.OpenReadOnly(engine, thingsDB)
+	if err != nil {
+		if errors.Is(err, persistence.ErrDatabaseLocked) {
+			return nil, ErrServiceHoldsDatabase
+		}
+		return nil, err
+	}
+	return &storageAccess{storage: storage, mode: ModeOffline, engine: engine, path: thingsDB}, nil
+}
+
+// Dial returns the administration access via the provided admin socket of the running service.
+// Returns ErrServiceUnavailable if no service is serving on the socket.
+func Dial(socket string) (Access, error) {
+	if len(socket) == 0 {
+		return nil, ErrServiceUnavailable
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	access := &socketAccess{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	if _, err := access.Status(); err != nil {
+		access.Close()
+		var netErr *net.OpError
+		if errors.As(err, &netErr) {
+			return nil, ErrServiceUnavailable
+		}
+		return nil, err
+	}
+	return access, nil
+}
+
+func (a *socketAccess) Mode() string {
+	return ModeService
+}
+
+func (a *socketAccess) Status() (*Status, error) {
+	status := &Status{}
+	if err := a.getJSON(pathStatus, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (a *socketAccess) ThingIDs() ([]string, error) {
+	var ids []string
+	if err := a.getJSON(pathThings, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (a *socketAccess) ThingIDsPage(after string, limit int) (*persistence.ThingIDsPage, error) {
+	query := url.Values{}
+	query.Set("after", after)
+	query.Set("limit", strconv.Itoa(limit))
+
+	page := &persistence.ThingIDsPage{}
+	if err := a.getJSON(pathThingIDs+"?"+query.Encode(), page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (a *socketAccess) Thing(thingID string) (*model.Thing, error) {
+	thing := &model.Thing{}
+	if err := a.getJSON(pathThings+"/"+url.PathEscape(thingID), thing); err != nil {
+		return nil, err
+	}
+	return thing, nil
+}
+
+func (a *socketAccess) Events(thingID string, fromRevision int64, toRevision int64) ([]json.RawMessage, error) {
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(fromRevision, 10))
+	query.Set("to", strconv.FormatInt(toRevision, 10))
+
+	var events []json.RawMessage
+	if err := a.getJSON(pathEvents+"/"+url.PathEscape(thingID)+"?"+query.Encode(), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (a *socketAccess) Snapshot(w io.Writer) error {
+	resp, err := a.get(pathSnapshot)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (a *socketAccess) Backup(path string) error {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := a.client.Post("http://admin"+pathBackup+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return responseError(resp)
+}
+
+func (a *socketAccess) Export(w io.Writer) error {
+	resp, err := a.get(pathExport)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (a *socketAccess) CloudDiff(thingID string) (*sync.CloudDiff, error) {
+	diff := &sync.CloudDiff{}
+	if err := a.getJSON(pathDiff+"/"+url.PathEscape(thingID), diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+func (a *socketAccess) Compact() (*persistence.CompactionReport, error) {
+	resp, err := a.client.Post("http://admin"+pathCompact, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	report := &persistence.CompactionReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (a *socketAccess) Reconcile() (*persistence.ReconcileReport, error) {
+	resp, err := a.client.Post("http://admin"+pathReconcile, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	report := &persistence.ReconcileReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (a *socketAccess) CheckIntegrity(repair bool) (*persistence.IntegrityReport, error) {
+	query := url.Values{}
+	query.Set("repair", strconv.FormatBool(repair))
+
+	resp, err := a.client.Post("http://admin"+pathIntegrity+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	report := &persistence.IntegrityReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (a *socketAccess) Close() error {
+	a.client.CloseIdleConnections()
+	return nil
+}
+
+func (a *socketAccess) getJSON(path string, value interface{}) error {
+	resp, err := a.get(path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+func (a *socketAccess) get(path string) (*http.Response, error) {
+	resp, err := a.client.Get("http://admin" + path)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// responseError returns the error replied by the service, if the response status is not OK.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return persistence.ErrThingNotFound
+	case http.StatusConflict:
+		return persistence.ErrBackupExists
+	case http.StatusServiceUnavailable:
+		return ErrCloudUnavailable
+	case http.StatusNotImplemented:
+		return ErrCompactionUnavailable
+	}
+	return errors.New(strings.TrimSpace(string(msg)))
+}
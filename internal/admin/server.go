@@ -0,0 +1,345 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/pkg/errors"
+)
+
+// Admin socket endpoints.
+const (
+	pathStatus    = "/status"
+	pathThings    = "/things"
+	pathThingIDs  = "/thingids"
+	pathEvents    = "/events"
+	pathSnapshot  = "/snapshot"
+	pathBackup    = "/backup"
+	pathExport    = "/export"
+	pathDiff      = "/clouddiff"
+	pathCompact   = "/compact"
+	pathReconcile = "/reconcile"
+	pathIntegrity = "/integrity"
+)
+
+// Server serves the administration access to the things storage of the running service on a local unix socket.
+type Server struct {
+	Storage     persistence.ThingsStorage
+	Maintenance *persistence.Maintenance
+	// CloudDiffer, if set, compares the local things with the cloud ones while connected to the hub.
+	CloudDiffer CloudDiffer
+
+	Logger logger.Logger
+
+	mutex  gosync.Mutex
+	socket string
+	server *http.Server
+}
+
+// Start removes any stale socket file left by a previous run and starts serving on the provided unix socket.
+// The socket is accessible by the service user only, its missing directory is created as such.
+func (s *Server) Start(socket string) error {
+	if err := removeStaleSocket(socket); err != nil {
+		return err
+	}
+
+	listener, err := listenPrivate(socket)
+	if err != nil {
+		return err
+	}
+
+	access := NewStorageAccess(s.Storage, ModeService)
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathStatus, s.use(func(w http.ResponseWriter, r *http.Request) {
+		status, err := access.Status()
+		writeJSON(w, status, err)
+	}))
+	mux.HandleFunc(pathThings, s.use(func(w http.ResponseWriter, r *http.Request) {
+		ids, err := access.ThingIDs()
+		writeJSON(w, ids, err)
+	}))
+	mux.HandleFunc(pathThingIDs, s.use(func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if value := r.URL.Query().Get("limit"); len(value) > 0 {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "invalid limit '"+value+"'", http.StatusBadRequest)
+				return
+			}
+		}
+		page, err := access.ThingIDsPage(r.URL.Query().Get("after"), limit)
+		writeJSON(w, page, err)
+	}))
+	mux.HandleFunc(pathThings+"/", s.use(func(w http.ResponseWriter, r *http.Request) {
+		thing, err := access.Thing(strings.TrimPrefix(r.URL.Path, pathThings+"/"))
+		writeJSON(w, thing, err)
+	}))
+	mux.HandleFunc(pathEvents+"/", s.use(func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := revisionRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events, err := access.Events(strings.TrimPrefix(r.URL.Path, pathEvents+"/"), from, to)
+		writeJSON(w, events, err)
+	}))
+	mux.HandleFunc(pathSnapshot, s.use(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := access.Snapshot(w); err != nil {
+			s.Logger.Error("Failed to write the things db snapshot to the admin socket", err, nil)
+		}
+	}))
+	mux.HandleFunc(pathBackup, s.handle(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if len(path) == 0 {
+			http.Error(w, "the backup path must be provided", http.StatusBadRequest)
+			return
+		}
+		if err := access.Backup(path); err != nil {
+			s.Logger.Error("Failed to backup the things db", err, watermill.LogFields{"path": path})
+			code := http.StatusInternalServerError
+			if errors.Is(err, persistence.ErrBackupExists) {
+				code = http.StatusConflict
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		s.Logger.Infof("Things db backed up to %s", path)
+	}))
+	mux.HandleFunc(pathExport, s.use(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := access.Export(w); err != nil {
+			s.Logger.Error("Failed to export the things to the admin socket", err, nil)
+		}
+	}))
+	mux.HandleFunc(pathCompact, s.compact)
+	mux.HandleFunc(pathReconcile, s.handle(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		report, err := access.Reconcile()
+		if err != nil {
+			s.Logger.Error("Failed to reconcile the things IDs index", err, nil)
+		} else if report.Repaired() {
+			s.Logger.Info("Things IDs index is repaired", watermill.LogFields{
+				"added":    report.Added,
+				"removed":  report.Removed,
+				"restored": report.Restored,
+				"orphans":  report.Orphans,
+			})
+		}
+		writeJSON(w, report, err)
+	}))
+	mux.HandleFunc(pathIntegrity, s.handle(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		repair, err := strconv.ParseBool(r.URL.Query().Get("repair"))
+		if err != nil {
+			http.Error(w, "the repair flag must be provided", http.StatusBadRequest)
+			return
+		}
+		report, err := access.CheckIntegrity(repair)
+		if err != nil {
+			s.Logger.Error("Failed to check the things db integrity", err, nil)
+		} else if report.Repaired {
+			s.Logger.Info("Things db integrity is repaired", integrityLogFields(report))
+		} else if !report.Consistent() {
+			s.Logger.Warn("Things db integrity is violated", nil, integrityLogFields(report))
+		}
+		writeJSON(w, report, err)
+	}))
+
+	mux.HandleFunc(pathDiff+"/", s.use(func(w http.ResponseWriter, r *http.Request) {
+		if s.CloudDiffer == nil {
+			http.Error(w, ErrCloudUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		diff, err := s.CloudDiffer.CloudDiff(strings.TrimPrefix(r.URL.Path, pathDiff+"/"))
+		if errors.Is(err, sync.ErrNoConnection) {
+			http.Error(w, ErrCloudUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, diff, err)
+	}))
+
+	server := &http.Server{Handler: mux}
+	s.mutex.Lock()
+	s.socket = socket
+	s.server = server
+	s.mutex.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Error("Admin socket server stopped", err, nil)
+		}
+	}()
+	s.Logger.Infof("Serving the admin access on %s", socket)
+	return nil
+}
+
+// Stop stops the server and removes the socket file.
+func (s *Server) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		s.server.Close()
+		os.Remove(s.socket)
+	}
+}
+
+// compact compacts the things db file as a maintenance operation. It is not wrapped with handle,
+// as the maintenance operation waits for all storage usages to be released.
+func (s *Server) compact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Maintenance == nil {
+		http.Error(w, ErrCompactionUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	report, err := s.Maintenance.Compact()
+	if err != nil {
+		s.Logger.Error("Failed to compact the things db", err, nil)
+		code := http.StatusInternalServerError
+		if errors.Is(err, persistence.ErrCompactionUnsupported) {
+			code = http.StatusNotImplemented
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	s.Logger.Info("Things DB is compacted", watermill.LogFields{
+		"sizeBefore": report.SizeBefore,
+		"sizeAfter":  report.SizeAfter,
+	})
+	writeJSON(w, report, nil)
+}
+
+// use wraps the GET handler to wait for any running maintenance operation, as the storage is closed meanwhile.
+func (s *Server) use(handler http.HandlerFunc) http.HandlerFunc {
+	return s.handle(http.MethodGet, handler)
+}
+
+// handle wraps the handler of the provided method to wait for any running maintenance operation.
+func (s *Server) handle(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Maintenance != nil {
+			release := s.Maintenance.Use()
+			defer release()
+		}
+		handler(w, r)
+	}
+}
+
+// integrityLogFields returns the log fields of the found integrity inconsistencies.
+func integrityLogFields(report *persistence.IntegrityReport) watermill.LogFields {
+	return watermill.LogFields{
+		"unindexed":           report.Unindexed,
+		"unstored":            report.Unstored,
+		"missingSystemData":   report.MissingSystemData,
+		"orphanSystemData":    report.OrphanSystemData,
+		"orphanFeatures":      report.OrphanFeatures,
+		"mismatchedFeatures":  report.MismatchedFeatures,
+		"staleDeleted":        report.StaleDeleted,
+		"staleUnsynchronized": report.StaleUnsynchronized,
+		"corrupted":           report.Corrupted,
+	}
+}
+
+// revisionRange parses the optional 'from' and 'to' revisions query parameters.
+func revisionRange(query url.Values) (int64, int64, error) {
+	var revisions [2]int64
+	for i, name := range []string{"from", "to"} {
+		if value := query.Get(name); len(value) > 0 {
+			revision, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, 0, errors.Errorf("invalid '%s' revision '%s'", name, value)
+			}
+			revisions[i] = revision
+		}
+	}
+	return revisions[0], revisions[1], nil
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}, err error) {
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, persistence.ErrThingNotFound) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// listenPrivate listens on the provided unix socket, which is accessible by the service user only.
+// The socket is created within a private temporary directory and moved in place once restricted,
+// so that it is never accessible by other users meanwhile.
+func listenPrivate(socket string) (net.Listener, error) {
+	dir := filepath.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "cannot create the admin socket directory")
+	}
+	private, err := os.MkdirTemp(dir, ".ldt-admin-")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create the admin socket directory")
+	}
+	defer os.RemoveAll(private)
+
+	bound := filepath.Join(private, filepath.Base(socket))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot listen on the admin socket")
+	}
+	// the bound path is moved, the socket is removed on stop instead
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(bound, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "cannot restrict the admin socket permissions")
+	}
+	if err := os.Rename(bound, socket); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "cannot move the admin socket in place")
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file if no server is listening on it anymore.
+// Returns an error if another service instance is still serving on it.
+func removeStaleSocket(socket string) error {
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return nil
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return errors.Errorf("the admin socket %s is already served", socket)
+	}
+	return errors.Wrap(os.Remove(socket), "cannot remove the stale admin socket")
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+const (
+	// AckLabelTwinPersisted is the label of the acknowledgement issued when a twin command is persisted.
+	AckLabelTwinPersisted = "twin-persisted"
+
+	ackStatusTimeout          = 408
+	ackStatusFailedDependency = 424
+)
+
+// Acknowledgement represents a single acknowledgement within the aggregated acknowledgements response value.
+type Acknowledgement struct {
+	Status  int               `json:"status"`
+	Payload json.RawMessage   `json:"payload,omitempty"`
+	Headers *protocol.Headers `json:"headers,omitempty"`
+}
+
+// pendingAcks contains the command awaiting custom acknowledgements from the local subscribers
+// and the acknowledgements received so far.
+type pendingAcks struct {
+	command  *protocol.Envelope
+	acks     map[string]*Acknowledgement
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// acksRegistry keeps the commands awaiting acknowledgements by their correlation ID.
+type acksRegistry struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingAcks
+}
+
+// awaitAcks checks if the command requests custom acknowledgements, i.e. other than twin-persisted,
+// and if so, withholds the command response until all of them are received or the command timeout expires.
+// The twin-persisted acknowledgement, if requested, is issued from the command response.
+// Acknowledgements are aggregated only for successfully applied commands requiring a response.
+func (h *Handler) awaitAcks(command *protocol.Envelope, output *CommandOutput) {
+	if output.response == nil || output.event == nil {
+		return
+	}
+
+	correlationID := command.Headers.CorrelationID()
+	labels := command.Headers.RequestedAcks()
+	if len(correlationID) == 0 || len(labels) == 0 ||
+		(len(labels) == 1 && labels[0] == AckLabelTwinPersisted) {
+		return
+	}
+
+	pending := &pendingAcks{
+		command: command,
+		acks:    make(map[string]*Acknowledgement, len(labels)),
+	}
+	for _, label := range labels {
+		pending.acks[label] = nil
+	}
+	if _, ok := pending.acks[AckLabelTwinPersisted]; ok {
+		pending.acks[AckLabelTwinPersisted] = &Acknowledgement{
+			Status:  output.response.Status,
+			Payload: output.response.Value,
+			Headers: output.response.Headers,
+		}
+	}
+
+	state := h.shared()
+	state.acks.mutex.Lock()
+	defer state.acks.mutex.Unlock()
+
+	if state.acks.pending == nil {
+		state.acks.pending = make(map[string]*pendingAcks)
+	}
+	if _, ok := state.acks.pending[correlationID]; ok {
+		return // already awaiting acknowledgements with the same correlation ID, respond immediately
+	}
+	state.acks.pending[correlationID] = pending
+	pending.deadline = time.Now().Add(command.Headers.Timeout())
+	pending.timer = time.AfterFunc(command.Headers.Timeout(), func() {
+		h.acksTimeout(correlationID)
+	})
+
+	output.response = nil
+}
+
+// acknowledgementReceived registers an acknowledgement issued by a local subscriber.
+// Returns false if there is no command awaiting the acknowledgement, i.e. it is not consumed.
+func (h *Handler) acknowledgementReceived(ack *protocol.Envelope) bool {
+	correlationID := ack.Headers.CorrelationID()
+	label := string(ack.Topic.Action)
+
+	state := h.shared()
+	state.acks.mutex.Lock()
+	defer state.acks.mutex.Unlock()
+
+	pending, ok := state.acks.pending[correlationID]
+	if !ok {
+		return false
+	}
+	if received, requested := pending.acks[label]; !requested || received != nil {
+		return false
+	}
+
+	pending.acks[label] = &Acknowledgement{
+		Status:  ack.Status,
+		Payload: ack.Value,
+		Headers: ack.Headers,
+	}
+	for _, received := range pending.acks {
+		if received == nil {
+			return true
+		}
+	}
+
+	pending.timer.Stop()
+	delete(state.acks.pending, correlationID)
+	h.publishAcks(pending)
+	return true
+}
+
+func (h *Handler) acksTimeout(correlationID string) {
+	state := h.shared()
+	state.acks.mutex.Lock()
+	defer state.acks.mutex.Unlock()
+
+	pending, ok := state.acks.pending[correlationID]
+	if !ok {
+		return
+	}
+	delete(state.acks.pending, correlationID)
+
+	for label, received := range pending.acks {
+		if received == nil {
+			pending.acks[label] = &Acknowledgement{Status: ackStatusTimeout}
+		}
+	}
+	h.Logger.Debugf("Acknowledgements of command with correlation ID '%s' timed out", correlationID)
+	h.publishAcks(pending)
+}
+
+// publishAcks publishes the aggregated acknowledgements as the command response.
+// The response status is 200 if all acknowledgements are successful, otherwise 424.
+func (h *Handler) publishAcks(pending *pendingAcks) {
+	status := ok
+	for _, ack := range pending.acks {
+		if ack.Status < 200 || ack.Status > 299 {
+			status = ackStatusFailedDependency
+			break
+		}
+	}
+
+	response := &protocol.Envelope{
+		Topic: &protocol.Topic{
+			Namespace: pending.command.Topic.Namespace,
+			EntityID:  pending.command.Topic.EntityID,
+			Group:     protocol.GroupThings,
+			Channel:   protocol.ChannelTwin,
+			Criterion: protocol.CriterionAcks,
+		},
+		Headers: responseHeadersWithContent(pending.command.Headers),
+		Path:    pending.command.Path,
+		Status:  status,
+	}
+	response.WithValue(pending.acks)
+
+	if data, err := json.Marshal(response); err != nil {
+		logCmdError("Unable to publish acknowledgements", err, pending.command, h.Logger)
+	} else {
+		msg := message.NewMessage(watermill.NewUUID(), []byte(data))
+		h.MosquittoPub.Publish(ResponsePublishTopic(h.DeviceID, pending.command.Topic), msg)
+	}
+}
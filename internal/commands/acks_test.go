@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	ackRequestedModifyPropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/acks",
+			"requested-acks": %s,
+			"timeout": "%s"
+		},
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}`
+
+	customAck = `{
+		"topic": "org.eclipse.kanto/test/things/twin/acks/custom",
+		"headers": {
+			"correlation-id": "%s"
+		},
+		"path": "/features/meter/properties/x",
+		"value": {"handled": true},
+		"status": %d
+	}`
+)
+
+type AcksCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestAcksCommandsSuite(t *testing.T) {
+	suite.Run(t, new(AcksCommandsSuite))
+}
+
+func (s *AcksCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+}
+
+func (s *AcksCommandsSuite) TestTwinPersistedAck() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["twin-persisted"]`, "10s")
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.CriterionCommands, response.Topic.Criterion)
+	assert.Equal(s.T(), 204, response.Status)
+}
+
+func (s *AcksCommandsSuite) TestCustomAcks() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["twin-persisted", "custom"]`, "10s")
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.CriterionEvents, event.Topic.Criterion)
+	assertPublishedNone(s.S())
+
+	msgs := s.handleCommandF(customAck, "test/local-digital-twins/acks", 200)
+	assert.Empty(s.T(), msgs)
+
+	acks := s.pullAcks(200)
+	assert.Equal(s.T(), 204, acks[commands.AckLabelTwinPersisted].Status)
+	assert.Equal(s.T(), 200, acks["custom"].Status)
+	assert.JSONEq(s.T(), `{"handled": true}`, string(acks["custom"].Payload))
+	assertPublishedNone(s.S())
+}
+
+func (s *AcksCommandsSuite) TestCustomAckFailed() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["custom"]`, "10s")
+	pullPublishedEnvelope(s.S()) // event
+
+	s.handleCommandF(customAck, "test/local-digital-twins/acks", 500)
+
+	acks := s.pullAcks(424)
+	assert.Equal(s.T(), 1, len(acks))
+	assert.Equal(s.T(), 500, acks["custom"].Status)
+}
+
+func (s *AcksCommandsSuite) TestCustomAcksTimeout() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["twin-persisted", "custom"]`, "50ms")
+	pullPublishedEnvelope(s.S()) // event
+
+	pub := s.handler.MosquittoPub.(*testPublisher)
+	var response *protocol.Envelope
+	assert.Eventually(s.T(), func() bool {
+		msg, err := pub.Pull()
+		if err != nil {
+			return false
+		}
+		response = &protocol.Envelope{}
+		return json.Unmarshal(msg.Payload, response) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NotNil(s.T(), response)
+	assert.Equal(s.T(), 424, response.Status)
+
+	acks := map[string]*commands.Acknowledgement{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &acks))
+	assert.Equal(s.T(), 204, acks[commands.AckLabelTwinPersisted].Status)
+	assert.Equal(s.T(), 408, acks["custom"].Status)
+
+	// late acknowledgement is not consumed
+	msgs := s.handleCommandF(customAck, "test/local-digital-twins/acks", 200)
+	assert.Equal(s.T(), 1, len(msgs))
+}
+
+func (s *AcksCommandsSuite) TestSuspendResumePending() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["twin-persisted", "custom"]`, "10s")
+	pullPublishedEnvelope(s.S()) // event
+
+	suspended := s.handler.SuspendPending()
+	require.Equal(s.T(), 1, len(suspended))
+	assert.Equal(s.T(), commands.PendingAcks, suspended[0].Kind)
+
+	// not awaited while suspended
+	msgs := s.handleCommandF(customAck, "test/local-digital-twins/acks", 200)
+	assert.Equal(s.T(), 1, len(msgs))
+
+	resumed, expired := s.handler.ResumePending(persistedPending(s.T(), suspended))
+	assert.Equal(s.T(), 1, resumed)
+	assert.Equal(s.T(), 0, expired)
+
+	msgs = s.handleCommandF(customAck, "test/local-digital-twins/acks", 200)
+	assert.Empty(s.T(), msgs)
+
+	acks := s.pullAcks(200)
+	assert.Equal(s.T(), 204, acks[commands.AckLabelTwinPersisted].Status)
+	assert.Equal(s.T(), 200, acks["custom"].Status)
+}
+
+func (s *AcksCommandsSuite) TestResumeExpiredPending() {
+	s.handleCommandF(ackRequestedModifyPropertyCmd, `["custom"]`, "10s")
+	pullPublishedEnvelope(s.S()) // event
+
+	suspended := s.handler.SuspendPending()
+	require.Equal(s.T(), 1, len(suspended))
+	suspended[0].Deadline = time.Now().Add(-time.Second)
+
+	resumed, expired := s.handler.ResumePending(persistedPending(s.T(), suspended))
+	assert.Equal(s.T(), 0, resumed)
+	assert.Equal(s.T(), 1, expired)
+
+	pub := s.handler.MosquittoPub.(*testPublisher)
+	var response *protocol.Envelope
+	assert.Eventually(s.T(), func() bool {
+		msg, err := pub.Pull()
+		if err != nil {
+			return false
+		}
+		response = &protocol.Envelope{}
+		return json.Unmarshal(msg.Payload, response) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NotNil(s.T(), response)
+	assert.Equal(s.T(), 424, response.Status)
+}
+
+func (s *AcksCommandsSuite) TestUnexpectedAck() {
+	msgs := s.handleCommandF(customAck, "test/local-digital-twins/unknown", 200)
+	assert.Equal(s.T(), 1, len(msgs))
+	assertPublishedNone(s.S())
+}
+
+// persistedPending returns the pending commands as decoded after being persisted.
+func persistedPending(t *testing.T, suspended []commands.PendingCommand) []commands.PendingCommand {
+	data, err := json.Marshal(suspended)
+	require.NoError(t, err)
+
+	var persisted []commands.PendingCommand
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	return persisted
+}
+
+func (s *AcksCommandsSuite) pullAcks(status int) map[string]*commands.Acknowledgement {
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.CriterionAcks, response.Topic.Criterion)
+	assert.Equal(s.T(), status, response.Status)
+
+	acks := map[string]*commands.Acknowledgement{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &acks))
+	return acks
+}
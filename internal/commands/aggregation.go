@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// EventAggregator batches the thing events published to the local subscribers within a short window
+// into a single thing-level merged event with the whole stored thing, for the subscribers preferring
+// coarse notifications. The individual events are still journaled and federated as they are generated.
+//
+// The thing deleted events are not merged, as there is no stored thing to be notified,
+// they replace the aggregated events of the thing and are published once the window is over.
+type EventAggregator struct {
+	Window time.Duration
+
+	Logger logger.Logger
+
+	pending map[string]*aggregatedEvents
+	mutex   sync.Mutex
+}
+
+type aggregatedEvents struct {
+	handler *Handler
+	last    *protocol.Envelope
+	timer   *time.Timer
+}
+
+// Add aggregates the thing event to be published once the aggregation window of the thing is over.
+// Returns false if the event is not aggregated, e.g. the live channel and the composite views events,
+// and is to be published as is.
+func (a *EventAggregator) Add(h *Handler, event *protocol.Envelope) bool {
+	if event.Topic == nil || event.Topic.Channel != protocol.ChannelTwin || strings.HasPrefix(event.Path, pathViews) {
+		return false
+	}
+
+	thingID := TopicNamespaceID(event.Topic)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[string]*aggregatedEvents)
+	}
+	if events, ok := a.pending[thingID]; ok {
+		events.last = event
+		return true
+	}
+
+	events := &aggregatedEvents{handler: h, last: event}
+	events.timer = time.AfterFunc(a.Window, func() {
+		a.flush(thingID, events)
+	})
+	a.pending[thingID] = events
+	return true
+}
+
+// Flush publishes the aggregated events of all things at once, e.g. on stop.
+func (a *EventAggregator) Flush() {
+	a.mutex.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mutex.Unlock()
+
+	for _, events := range pending {
+		if events.timer.Stop() {
+			a.publish(events)
+		}
+	}
+}
+
+func (a *EventAggregator) flush(thingID string, events *aggregatedEvents) {
+	a.mutex.Lock()
+	if a.pending[thingID] != events {
+		a.mutex.Unlock()
+		return
+	}
+	delete(a.pending, thingID)
+	a.mutex.Unlock()
+
+	a.publish(events)
+}
+
+func (a *EventAggregator) publish(events *aggregatedEvents) {
+	if event := a.mergedEvent(events.handler, events.last); event != nil {
+		publishLocalEvent(events.handler, event)
+	}
+}
+
+// mergedEvent builds the thing merged event with the whole stored thing or returns the last event as is
+// if the thing is deleted.
+func (a *EventAggregator) mergedEvent(h *Handler, last *protocol.Envelope) *protocol.Envelope {
+	if last.Topic.Action == protocol.ActionDeleted && last.Path == "/" {
+		return last
+	}
+
+	thingID := TopicNamespaceID(last.Topic)
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(thingID, &thing); err != nil {
+		a.Logger.Errorf("Unable to publish the aggregated events of thing '%s': %v", thingID, err)
+		return nil
+	}
+
+	event := &protocol.Envelope{
+		Topic:     eventTopic(last.Topic, protocol.ActionMerged),
+		Path:      "/",
+		Revision:  thing.Revision,
+		Timestamp: thing.Timestamp,
+	}
+	event.WithHeaders(responseHeaders(last.Headers).WithContentType(protocol.ContentTypeJSONMerge)).
+		WithValue(&thing)
+	event = h.Redaction.Apply(event)
+	if h.SortedKeys {
+		event.Value = sortedKeysValue(event.Value)
+	}
+	return event
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/connector"
+	"github.com/pkg/errors"
+)
+
+// errBatchCommandFailed is returned on batch command failure to discard all batch changes.
+var errBatchCommandFailed = errors.New("batch command failed")
+
+// batchPublisher keeps the messages published on batch commands execution,
+// so that they are published only if all batch changes are applied.
+type batchPublisher struct {
+	topics []string
+	msgs   []*message.Message
+}
+
+func (p *batchPublisher) Publish(topic string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		p.topics = append(p.topics, topic)
+		p.msgs = append(p.msgs, msg)
+	}
+	return nil
+}
+
+func (p *batchPublisher) Close() error {
+	return nil
+}
+
+func (p *batchPublisher) flush(pub message.Publisher) {
+	for i, msg := range p.msgs {
+		pub.Publish(p.topics[i], msg)
+	}
+}
+
+// handleBatch executes the commands of the batch envelope value atomically, i.e. either all of them are applied
+// to the local storage within a single transaction or none of them. The commands responses are aggregated into
+// the batch response with status 200 if all commands are successful, otherwise with the first failed command status.
+// The aggregated responses end with the failed command one, as the rest of the commands are not executed.
+// If the batch is applied, the commands events are published and the commands are forwarded to the hub one by one.
+func (h *Handler) handleBatch(msg *message.Message, command *protocol.Envelope) {
+	var commands []*protocol.Envelope
+	if err := json.Unmarshal(command.Value, &commands); err != nil || len(commands) == 0 {
+		if err == nil {
+			err = errors.New("no batch commands")
+		}
+		logCmdError("Invalid batch command value", err, command, h.Logger)
+		if command.Headers.ResponseRequired() {
+			publishResponse(h, NewInvalidJSONValueError(command, err))
+		}
+		return
+	}
+
+	pub := &batchPublisher{}
+	var outputs []*CommandOutput
+	var responses []*protocol.Envelope
+	status := ok
+
+	err := h.Storage.Batch(func(storage persistence.ThingsStorage) error {
+		batch := h.transactional(storage, pub, nil)
+		for _, next := range commands {
+			output := batch.batchCommand(command, next)
+			responses = append(responses, output.response)
+			if output.response.Status < 200 || output.response.Status > 299 {
+				status = output.response.Status
+				return errBatchCommandFailed
+			}
+			outputs = append(outputs, output)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchCommandFailed) {
+		response := commandUnknownError("Batch command failed", err, command, h.Logger)
+		if response != nil {
+			publishResponse(h, response)
+		}
+		return
+	}
+
+	if status == ok {
+		pub.flush(h.MosquittoPub)
+		for i, output := range outputs {
+			h.recordChange(TopicNamespaceID(commands[i].Topic), output)
+		}
+	}
+	if command.Headers.ResponseRequired() {
+		response := &protocol.Envelope{
+			Topic:   command.Topic,
+			Headers: responseHeadersWithContent(command.Headers),
+			Path:    command.Path,
+			Status:  status,
+		}
+		publishResponse(h, response.WithValue(responses))
+	}
+	logCmdHandled(command, h.Logger)
+
+	if status == ok {
+		for i, output := range outputs {
+			h.forwardBatchCommand(msg, commands[i], output)
+		}
+	}
+}
+
+// batchCommand executes a single command of the batch and publishes its event, if any.
+// The command response is always provided.
+func (h *Handler) batchCommand(batch *protocol.Envelope, command *protocol.Envelope) *CommandOutput {
+	if command.Headers == nil {
+		command.Headers = protocol.NewHeaders()
+	}
+	if len(command.Headers.CorrelationID()) == 0 {
+		command.Headers.WithCorrelationID(batch.Headers.CorrelationID())
+	}
+	command.Headers.WithResponseRequired(true)
+
+	output := &CommandOutput{}
+	if command.Topic == nil || command.Topic.Channel != protocol.ChannelTwin ||
+		command.Topic.Criterion != protocol.CriterionCommands {
+		output.response = NewUnsupportedCommandError(&protocol.Envelope{
+			Topic: batch.Topic, Headers: command.Headers, Path: command.Path,
+		})
+		return output
+	}
+
+	cmdFunc, cmd, err := h.parseCommand(command)
+	if err != nil || cmdFunc == nil {
+		output.response = NewUnsupportedCommandError(command)
+		return output
+	}
+
+	if conflicts := command.Headers.ConflictingHeaders(); len(conflicts) > 0 {
+		output.response = NewHeadersConflictError(command, conflicts)
+		return output
+	}
+
+	if !h.rateLimited(cmd, output) && !h.quotaExceeded(cmd, output) &&
+		h.conditionMet(cmd, output) && h.preconditionsMet(cmd, output) && h.definitionsConformed(cmd, output) {
+		cmdFunc(h, cmd, output)
+		h.putMetadata(cmd, output)
+		h.eventWithExtra(cmd, output)
+		h.featureRevisionHeaders(cmd, output)
+		h.trackUsage(cmd.thingID, output)
+	}
+	if output.response == nil {
+		output.response = NewUnknownError(command, "Batch command failed", errors.New("no command response"))
+	}
+	if output.event != nil {
+		publishEvent(h, output.event)
+		h.publishViewEvents(cmd, output)
+	}
+	h.countCommand(output)
+	return output
+}
+
+func (h *Handler) forwardBatchCommand(msg *message.Message, command *protocol.Envelope, output *CommandOutput) {
+	if output.local {
+		return
+	}
+
+	err := PublishHonoMsg(cmdWithNoResponseRequired(msg, command), h.HonoPub, h.DeviceInfo,
+		TopicNamespaceID(command.Topic))
+	if err != nil {
+		if errors.Is(err, connector.ErrNotConnected) {
+			h.Logger.Trace("Batch command not forwarded to hono: no hub connection", nil)
+		} else {
+			logCmdError("Batch command not forwarded to hono, unexpected error:", err, command, h.Logger)
+		}
+		return
+	}
+
+	h.resourceSynchronized(output)
+	if h.Echoes != nil && len(output.thingID) > 0 {
+		h.Echoes.Forwarded(command)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const batchCmd = `{
+	"topic": "_/_/things/twin/commands/batch",
+	"headers": {"correlation-id": "test/local-digital-twins/batch"},
+	"path": "/",
+	"value": %s
+}`
+
+type BatchCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestBatchCommandsSuite(t *testing.T) {
+	suite.Run(t, new(BatchCommandsSuite))
+}
+
+func (s *BatchCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+}
+
+func (s *BatchCommandsSuite) TestBatch() {
+	msgs := s.handleCommandF(batchCmd, `[{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}, {
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/other",
+		"value": {"properties": {"y": 1}}
+	}]`)
+	assert.Empty(s.T(), msgs)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionModified, event.Topic.Action)
+	assert.Equal(s.T(), "/features/meter/properties/x", event.Path)
+	event = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionCreated, event.Topic.Action)
+	assert.Equal(s.T(), "/features/other", event.Path)
+
+	responses := s.pullBatchResponse(200)
+	require.Equal(s.T(), 2, len(responses))
+	assert.Equal(s.T(), 204, responses[0].Status)
+	assert.Equal(s.T(), "test/local-digital-twins/batch", responses[0].Headers.CorrelationID())
+	assert.Equal(s.T(), 201, responses[1].Status)
+	assertPublishedNone(s.S())
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.EqualValues(s.T(), 20, thing.Features[testFeatureID].Properties["x"])
+	assert.EqualValues(s.T(), 1, thing.Features["other"].Properties["y"])
+
+	hono := s.handler.HonoPub.(*testPublisher)
+	for i := 0; i < 2; i++ {
+		forwarded, err := hono.Pull()
+		require.NoError(s.T(), err)
+		env := protocol.Envelope{}
+		require.NoError(s.T(), json.Unmarshal(forwarded.Payload, &env))
+		assert.Equal(s.T(), protocol.ActionModify, env.Topic.Action)
+		assert.False(s.T(), env.Headers.ResponseRequired())
+	}
+}
+
+func (s *BatchCommandsSuite) TestBatchFailed() {
+	s.handleCommandF(batchCmd, `[{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}, {
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		"path": "/features/unknown"
+	}, {
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/y",
+		"value": 30
+	}]`)
+
+	responses := s.pullBatchResponse(404)
+	require.Equal(s.T(), 2, len(responses))
+	assert.Equal(s.T(), 204, responses[0].Status)
+	assert.Equal(s.T(), 404, responses[1].Status)
+	assertPublishedNone(s.S())
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.EqualValues(s.T(), 10, thing.Features[testFeatureID].Properties["x"])
+	assert.Nil(s.T(), thing.Features[testFeatureID].Properties["y"])
+
+	_, err := s.handler.HonoPub.(*testPublisher).Pull()
+	assert.Error(s.T(), err)
+}
+
+func (s *BatchCommandsSuite) TestBatchUnsupportedCommand() {
+	s.handleCommandF(batchCmd, `[{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}, {
+		"topic": "_/_/things/twin/commands/batch",
+		"path": "/",
+		"value": []
+	}]`)
+
+	responses := s.pullBatchResponse(400)
+	require.Equal(s.T(), 2, len(responses))
+	assert.Equal(s.T(), protocol.CriterionErrors, responses[1].Topic.Criterion)
+	assertPublishedNone(s.S())
+}
+
+func (s *BatchCommandsSuite) TestBatchInvalidValue() {
+	s.handleCommandF(batchCmd, `{"topic": "org.eclipse.kanto/test/things/twin/commands/modify"}`)
+	s.assertErrorResponse(400, "json.invalid")
+
+	s.handleCommandF(batchCmd, `[]`)
+	s.assertErrorResponse(400, "json.invalid")
+}
+
+func (s *BatchCommandsSuite) TestBatchDisabled() {
+	s.handler.BatchDisabled = true
+	defer func() {
+		s.handler.BatchDisabled = false
+	}()
+
+	msgs := s.handleCommandF(batchCmd, `[{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}]`)
+	assert.Equal(s.T(), 1, len(msgs))
+	assertPublishedNone(s.S())
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.EqualValues(s.T(), 10, thing.Features[testFeatureID].Properties["x"])
+}
+
+func (s *BatchCommandsSuite) pullBatchResponse(status int) []*protocol.Envelope {
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionBatch, response.Topic.Action)
+	assert.Equal(s.T(), status, response.Status)
+
+	var responses []*protocol.Envelope
+	require.NoError(s.T(), json.Unmarshal(response.Value, &responses))
+	return responses
+}
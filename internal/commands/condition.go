@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+	"github.com/pkg/errors"
+)
+
+var errorConditionFailed = errors.New("condition does not match the thing state")
+
+// conditionMet evaluates the command 'condition' header, if any, against the locally stored thing,
+// i.e. the condition properties are relative to the thing root, e.g. 'features/meter/properties/x'.
+// A missing thing is evaluated as no value, e.g. 'not(exists(attributes))' holds for it.
+// Returns false and builds the command output error response if the condition is invalid or does not hold.
+func (h *Handler) conditionMet(cmd *Command, out *CommandOutput) bool {
+	expression := cmd.envelope.Headers.Condition()
+	if len(expression) == 0 ||
+		cmd.envelope.Topic.Namespace == protocol.TopicPlaceholder ||
+		cmd.envelope.Topic.EntityID == protocol.TopicPlaceholder {
+		return true
+	}
+
+	condition, err := rql.Parse(expression)
+	if err != nil {
+		logCmdError("Invalid command condition", err, cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewConditionInvalidError(cmd.envelope, err)
+		}
+		return false
+	}
+
+	var value interface{}
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		if !errors.Is(err, persistence.ErrThingNotFound) {
+			out.response = commandUnknownError("Command condition evaluation failed", err, cmd.envelope, h.Logger)
+			return false
+		}
+	} else if value, err = thingValue(&thing); err != nil {
+		out.response = commandUnknownError("Command condition evaluation failed", err, cmd.envelope, h.Logger)
+		return false
+	}
+
+	if !condition.Evaluate(value) {
+		logCmdError("Command condition not met", errorConditionFailed, cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewConditionFailedError(cmd.envelope, cmd.thingID)
+		}
+		return false
+	}
+	return true
+}
+
+func thingValue(thing *model.Thing) (interface{}, error) {
+	data, err := json.Marshal(thing)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	conditionalModifyPropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			"condition": %q
+		},
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}`
+
+	conditionalRetrieveFeatureCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			"condition": %q
+		},
+		"path": "/features/meter"
+	}`
+)
+
+type ConditionCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestConditionCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ConditionCommandsSuite))
+}
+
+func (s *ConditionCommandsSuite) TestConditionMet() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+
+	s.handleCommandF(conditionalModifyPropertyCmd, "and(exists(features/meter),lt(features/meter/properties/x,15))")
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 20, feature.Properties["x"])
+
+	s.handleCommandF(conditionalRetrieveFeatureCmd, `eq(features/meter/properties/x,20)`)
+	assert.Equal(s.T(), 200, pullPublishedEnvelope(s.S()).Status)
+}
+
+func (s *ConditionCommandsSuite) TestConditionFailed() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+
+	s.handleCommandF(conditionalModifyPropertyCmd, `gt(features/meter/properties/x,15)`)
+	s.assertErrorResponse(412, "things:condition.failed")
+	assertPublishedNone(s.S())
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 10, feature.Properties["x"])
+
+	s.handleCommandF(conditionalRetrieveFeatureCmd, `not(exists(features/meter))`)
+	s.assertErrorResponse(412, "things:condition.failed")
+}
+
+func (s *ConditionCommandsSuite) TestConditionInvalid() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+
+	s.handleCommandF(conditionalModifyPropertyCmd, `gt(features/meter/properties/x`)
+	s.assertErrorResponse(400, "things:condition.invalid")
+	assertPublishedNone(s.S())
+}
+
+func (s *ConditionCommandsSuite) TestConditionThingNotFound() {
+	s.deleteThing()
+
+	s.handleCommandF(conditionalRetrieveFeatureCmd, `exists(features/meter)`)
+	s.assertErrorResponse(412, "things:condition.failed")
+
+	s.handleCommandF(conditionalRetrieveFeatureCmd, `not(exists(features/meter))`)
+	s.assertErrorResponse(404, "things:thing.notfound")
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// commandKey identifies the twin commands with given action on resources of given scope.
+type commandKey struct {
+	scope  Scope
+	action protocol.TopicAction
+}
+
+// commandsTable contains the functions performing the locally supported twin commands.
+var commandsTable = map[commandKey]CommandFunc{
+	// /
+	{ScopeThing, protocol.ActionCreate}:   createThing,
+	{ScopeThing, protocol.ActionModify}:   modifyThing,
+	{ScopeThing, protocol.ActionMerge}:    mergeThing,
+	{ScopeThing, protocol.ActionDelete}:   deleteThing,
+	{ScopeThing, protocol.ActionRetrieve}: retrieveThing,
+
+	{ScopeThing, protocol.ActionMigrateDefinition}: migrateDefinition,
+
+	// /features
+	{ScopeFeatures, protocol.ActionModify}:   modifyFeatures,
+	{ScopeFeatures, protocol.ActionMerge}:    mergeFeatures,
+	{ScopeFeatures, protocol.ActionDelete}:   deleteFeatures,
+	{ScopeFeatures, protocol.ActionRetrieve}: retrieveFeatures,
+
+	// /features/<featureID>
+	{ScopeFeature, protocol.ActionModify}:   modifyFeature,
+	{ScopeFeature, protocol.ActionMerge}:    mergeFeature,
+	{ScopeFeature, protocol.ActionDelete}:   deleteFeature,
+	{ScopeFeature, protocol.ActionRetrieve}: retrieveFeature,
+
+	// /features/<featureID>/properties
+	{ScopeFeatureProperties, protocol.ActionModify}:   modifyProperties,
+	{ScopeFeatureProperties, protocol.ActionMerge}:    mergeProperties,
+	{ScopeFeatureProperties, protocol.ActionDelete}:   deleteProperties,
+	{ScopeFeatureProperties, protocol.ActionRetrieve}: retrieveProperties,
+
+	// /features/<featureID>/properties/<propertyPath>
+	{ScopeFeatureProperty, protocol.ActionModify}:   modifyProperty,
+	{ScopeFeatureProperty, protocol.ActionMerge}:    mergeProperty,
+	{ScopeFeatureProperty, protocol.ActionDelete}:   deleteProperty,
+	{ScopeFeatureProperty, protocol.ActionRetrieve}: retrieveProperty,
+
+	// /features/<featureID>/desiredProperties
+	{ScopeFeatureDesiredProperties, protocol.ActionModify}:   modifyDesiredProperties,
+	{ScopeFeatureDesiredProperties, protocol.ActionMerge}:    mergeDesiredProperties,
+	{ScopeFeatureDesiredProperties, protocol.ActionDelete}:   deleteDesiredProperties,
+	{ScopeFeatureDesiredProperties, protocol.ActionRetrieve}: retrieveDesiredProperties,
+
+	// /features/<featureID>/desiredProperties/<propertyPath>
+	{ScopeFeatureDesiredProperty, protocol.ActionModify}:   modifyDesiredProperty,
+	{ScopeFeatureDesiredProperty, protocol.ActionMerge}:    mergeDesiredProperty,
+	{ScopeFeatureDesiredProperty, protocol.ActionDelete}:   deleteDesiredProperty,
+	{ScopeFeatureDesiredProperty, protocol.ActionRetrieve}: retrieveDesiredProperty,
+
+	// /views/<viewName>
+	{ScopeView, protocol.ActionRetrieve}: retrieveView,
+
+	// /leases/<leaseName>
+	{ScopeLease, protocol.ActionModify}:   acquireLease,
+	{ScopeLease, protocol.ActionDelete}:   releaseLease,
+	{ScopeLease, protocol.ActionRetrieve}: retrieveLease,
+}
+
+// CommandMiddleware wraps the function performing twin commands, e.g. to trace or to restrict them.
+type CommandMiddleware func(CommandFunc) CommandFunc
+
+// commandsDispatch contains the registered command functions and middlewares of a Handler
+// and the dispatch table built of them together with the built-in command functions.
+type commandsDispatch struct {
+	registered  map[commandKey]CommandFunc
+	middlewares []CommandMiddleware
+	table       map[commandKey]CommandFunc
+}
+
+// RegisterCommand registers the function performing the twin commands with given action on resources
+// of given scope, e.g. to handle locally the commands on a currently unsupported scope
+// or to replace a built-in command. Must be called before the commands handling is started.
+func (h *Handler) RegisterCommand(scope Scope, action protocol.TopicAction, f CommandFunc) {
+	if h.dispatch.registered == nil {
+		h.dispatch.registered = make(map[commandKey]CommandFunc)
+	}
+	h.dispatch.registered[commandKey{scope, action}] = f
+	h.dispatch.build()
+}
+
+// AddCommandMiddleware adds middlewares applied on both the built-in and the registered command functions,
+// the first added middleware is the outermost one. Must be called before the commands handling is started.
+func (h *Handler) AddCommandMiddleware(m ...CommandMiddleware) {
+	h.dispatch.middlewares = append(h.dispatch.middlewares, m...)
+	h.dispatch.build()
+}
+
+func (d *commandsDispatch) build() {
+	d.table = make(map[commandKey]CommandFunc, len(commandsTable)+len(d.registered))
+	for key, f := range commandsTable {
+		d.table[key] = f
+	}
+	for key, f := range d.registered {
+		d.table[key] = f
+	}
+	for key, f := range d.table {
+		for i := len(d.middlewares) - 1; i >= 0; i-- {
+			f = d.middlewares[i](f)
+		}
+		d.table[key] = f
+	}
+}
+
+// commandFunc returns the function performing the twin commands with given action on resources
+// of given scope or nil if such commands are not supported.
+func (h *Handler) commandFunc(scope Scope, action protocol.TopicAction) CommandFunc {
+	if h.dispatch.table != nil {
+		return h.dispatch.table[commandKey{scope, action}]
+	}
+	return commandsTable[commandKey{scope, action}]
+}
+
+// Envelope returns the command envelope.
+func (cmd *Command) Envelope() *protocol.Envelope {
+	return cmd.envelope
+}
+
+// ThingID returns the command thing ID.
+func (cmd *Command) ThingID() string {
+	return cmd.thingID
+}
+
+// Target returns the command target, e.g. the feature ID or the attribute path.
+func (cmd *Command) Target() string {
+	return cmd.target
+}
+
+// Path returns the command additional path, e.g. the property path.
+func (cmd *Command) Path() string {
+	return cmd.path
+}
+
+// Response returns the command response, nil if there is no response yet.
+func (out *CommandOutput) Response() *protocol.Envelope {
+	return out.response
+}
+
+// SetResponse sets the command response.
+func (out *CommandOutput) SetResponse(response *protocol.Envelope) {
+	out.response = response
+}
+
+// SetEvent sets the event to be published on successfully executed command.
+func (out *CommandOutput) SetEvent(event *protocol.Envelope) {
+	out.event = event
+}
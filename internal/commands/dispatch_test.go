@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+type DispatchCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestDispatchCommandsSuite(t *testing.T) {
+	suite.Run(t, new(DispatchCommandsSuite))
+}
+
+func (s *DispatchCommandsSuite) TestRegisterCommand() {
+	s.addTestThing()
+
+	attributeCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/attributes/location"
+	}`
+
+	// unsupported attributes scope is forwarded
+	assert.NotEmpty(s.T(), s.handleCommandF(attributeCmd, defaultHeaders))
+	assertPublishedNone(s.S())
+
+	var handled *commands.Command
+	s.handler.RegisterCommand(commands.ScopeAttributes, protocol.ActionRetrieve,
+		func(h *commands.Handler, cmd *commands.Command, out *commands.CommandOutput) {
+			handled = cmd
+			out.SetResponse(commands.ResponseEnvelopeWithValue(cmd.Envelope(), 200, "edge"))
+		})
+
+	assert.Empty(s.T(), s.handleCommandF(attributeCmd, defaultHeaders))
+	require.NotNil(s.T(), handled)
+	assert.Equal(s.T(), testThingID, handled.ThingID())
+	assert.Equal(s.T(), "/location", handled.Target())
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `"edge"`, string(response.Value))
+}
+
+func (s *DispatchCommandsSuite) TestCommandMiddleware() {
+	s.addTestThing()
+
+	var trace []string
+	tracing := func(name string) commands.CommandMiddleware {
+		return func(f commands.CommandFunc) commands.CommandFunc {
+			return func(h *commands.Handler, cmd *commands.Command, out *commands.CommandOutput) {
+				trace = append(trace, name)
+				f(h, cmd, out)
+			}
+		}
+	}
+	s.handler.AddCommandMiddleware(tracing("outer"), tracing("inner"))
+
+	thingCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/"
+	}`
+	assert.Empty(s.T(), s.handleCommandF(thingCmd, defaultHeaders))
+	assert.Equal(s.T(), []string{"outer", "inner"}, trace)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+}
+
+func (s *DispatchCommandsSuite) TestRegisterModifyCommand() {
+	s.addTestThing()
+
+	echoes := commands.NewEchoFilter(time.Minute)
+	s.handler.Echoes = echoes
+	defer func() {
+		s.handler.Echoes = nil
+	}()
+
+	var tx *commands.Handler
+	s.handler.RegisterCommand(commands.ScopeAttributes, protocol.ActionModify,
+		func(h *commands.Handler, cmd *commands.Command, out *commands.CommandOutput) {
+			tx = h
+			out.SetResponse(commands.ResponseEnvelopeWithValue(cmd.Envelope(), 204, nil))
+		})
+
+	attributeCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/attributes/location",
+		"value": "edge"
+	}`
+	assert.Empty(s.T(), s.handleCommandF(attributeCmd, defaultHeaders))
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+
+	// the modifying command is executed by a transactional copy of the handler
+	require.NotNil(s.T(), tx)
+	assert.NotSame(s.T(), s.handler, tx)
+	assert.NotEqual(s.T(), s.handler.Storage, tx.Storage)
+	assert.Same(s.T(), echoes, tx.Echoes)
+	assert.Equal(s.T(), s.handler.DeviceID, tx.DeviceID)
+}
+
+func BenchmarkHandleCommand(b *testing.B) {
+	const benchDB = "things_bench.db"
+
+	storage, err := persistence.NewThingsDB(benchDB, testThingID)
+	require.NoError(b, err)
+	defer func() {
+		storage.Close()
+		os.Remove(benchDB)
+	}()
+
+	_, err = storage.AddThing((&model.Thing{}).WithIDFrom(testThingID).
+		WithFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 1)))
+	require.NoError(b, err)
+
+	mosquittoPub := &testPublisher{buffer: list.New()}
+	handler := &commands.Handler{
+		Storage:      storage,
+		MosquittoPub: mosquittoPub,
+		HonoPub:      &testPublisher{buffer: list.New()},
+		Logger:       testutil.NewLogger("commands", logger.ERROR, b),
+	}
+	handler.DeviceID = testThingID
+
+	payload := []byte(fmt.Sprintf(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/features/meter/properties/x"
+	}`, defaultHeaders))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := handler.HandleCommand(&message.Message{Payload: payload}); err != nil {
+			b.Fatal(err)
+		}
+		mosquittoPub.buffer.Init()
+	}
+}
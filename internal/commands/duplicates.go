@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// duplicatesCache contains the recently handled modifying commands by their correlation IDs
+// together with their responses, the least recently handled ones are evicted first.
+type duplicatesCache struct {
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type handledCommand struct {
+	correlationID string
+	payload       []byte
+	response      *protocol.Envelope
+}
+
+// duplicate checks if the command is a redelivery of a recently handled command, e.g. a QoS 1 duplicate,
+// i.e. it has the same correlation ID and payload. If so, the response of the handled command is published
+// again, if required, and the command is neither executed nor forwarded. The retrieve commands are not checked.
+func (h *Handler) duplicate(msg *message.Message, cmd *Command) bool {
+	if !h.duplicatesChecked(cmd) {
+		return false
+	}
+
+	handled, ok := h.shared().duplicates.get(cmd.envelope.Headers.CorrelationID(), msg.Payload)
+	if !ok {
+		return false
+	}
+
+	h.Logger.Debug("Duplicate thing command ignored", CmdLogFields(cmd.envelope))
+	if handled.response != nil && cmd.envelope.Headers.ResponseRequired() {
+		publishResponse(h, handled.response)
+	}
+	return true
+}
+
+// commandHandled remembers the handled command and its response to detect its duplicates.
+func (h *Handler) commandHandled(msg *message.Message, cmd *Command, output *CommandOutput) {
+	if h.duplicatesChecked(cmd) {
+		h.shared().duplicates.put(&handledCommand{
+			correlationID: cmd.envelope.Headers.CorrelationID(),
+			payload:       msg.Payload,
+			response:      output.response,
+		}, h.DuplicatesCacheSize)
+	}
+}
+
+func (h *Handler) duplicatesChecked(cmd *Command) bool {
+	return h.DuplicatesCacheSize > 0 &&
+		cmd.envelope.Topic.Action != protocol.ActionRetrieve &&
+		len(cmd.envelope.Headers.CorrelationID()) > 0
+}
+
+func (c *duplicatesCache) get(correlationID string, payload []byte) (*handledCommand, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[correlationID]
+	if !ok {
+		return nil, false
+	}
+
+	handled := element.Value.(*handledCommand)
+	if !bytes.Equal(handled.payload, payload) {
+		return nil, false // the correlation ID is reused by another command
+	}
+	c.order.MoveToFront(element)
+	return handled, true
+}
+
+func (c *duplicatesCache) put(handled *handledCommand, size int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.order = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+
+	if element, ok := c.entries[handled.correlationID]; ok {
+		element.Value = handled
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[handled.correlationID] = c.order.PushFront(handled)
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*handledCommand).correlationID)
+	}
+}
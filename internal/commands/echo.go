@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// EchoFilter tracks the locally applied commands which are forwarded to the cloud,
+// so that the corresponding twin events emitted back by the cloud could be recognized
+// and not re-applied or re-published locally.
+type EchoFilter struct {
+	ttl time.Duration
+
+	mutex     sync.Mutex
+	forwarded map[string]*forwardedCommand
+}
+
+type forwardedCommand struct {
+	action  protocol.TopicAction
+	value   interface{}
+	expires time.Time
+}
+
+// NewEchoFilter creates an echo filter keeping the forwarded commands for the provided duration.
+func NewEchoFilter(ttl time.Duration) *EchoFilter {
+	return &EchoFilter{
+		ttl:       ttl,
+		forwarded: make(map[string]*forwardedCommand),
+	}
+}
+
+// Forwarded registers the forwarded to the cloud command, replacing any previously registered one
+// with the same thing and path.
+func (f *EchoFilter) Forwarded(command *protocol.Envelope) {
+	var action protocol.TopicAction
+	switch command.Topic.Action {
+	case protocol.ActionCreate, protocol.ActionModify:
+		action = protocol.ActionModified
+	case protocol.ActionMerge:
+		action = protocol.ActionMerged
+	case protocol.ActionDelete:
+		action = protocol.ActionDeleted
+	default:
+		return
+	}
+
+	value, ok := echoValue(command.Value)
+	if !ok {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	for key, next := range f.forwarded {
+		if now.After(next.expires) {
+			delete(f.forwarded, key)
+		}
+	}
+
+	f.forwarded[echoKey(command)] = &forwardedCommand{
+		action:  action,
+		value:   value,
+		expires: now.Add(f.ttl),
+	}
+}
+
+// IsEcho returns true if the provided envelope is a twin event corresponding to a recently forwarded command,
+// i.e. with the same thing, path and value. A forwarded command is matched by a single event only.
+func (f *EchoFilter) IsEcho(event *protocol.Envelope) bool {
+	if event.Topic == nil ||
+		event.Topic.Channel != protocol.ChannelTwin || event.Topic.Criterion != protocol.CriterionEvents {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := echoKey(event)
+	forwarded, ok := f.forwarded[key]
+	if !ok || time.Now().After(forwarded.expires) {
+		return false
+	}
+
+	action := event.Topic.Action
+	if action == protocol.ActionCreated {
+		action = protocol.ActionModified
+	}
+	if action != forwarded.action {
+		return false
+	}
+
+	value, ok := echoValue(event.Value)
+	if !ok || !reflect.DeepEqual(value, forwarded.value) {
+		return false
+	}
+
+	delete(f.forwarded, key)
+	return true
+}
+
+func echoKey(env *protocol.Envelope) string {
+	return TopicNamespaceID(env.Topic) + env.Path
+}
+
+func echoValue(data json.RawMessage) (interface{}, bool) {
+	if len(data) == 0 {
+		return nil, true
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	modifyPropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/echo"},
+		"path": "/features/meter/properties/x",
+		"value": {"a": 1, "b": [2, 3]}
+	}`
+
+	propertyModifiedEvent = `{
+		"topic": "org.eclipse.kanto/test/things/twin/events/modified",
+		"headers": {"correlation-id": "test/local-digital-twins/echo"},
+		"path": "/features/meter/properties/x",
+		"value": %s,
+		"revision": 10
+	}`
+)
+
+type EchoCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestEchoCommandsSuite(t *testing.T) {
+	suite.Run(t, new(EchoCommandsSuite))
+}
+
+func (s *EchoCommandsSuite) TestForwardedCommandEcho() {
+	echoes := commands.NewEchoFilter(time.Minute)
+	s.handler.Echoes = echoes
+	defer func() {
+		s.handler.Echoes = nil
+	}()
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 1}))
+
+	s.handleCommand(modifyPropertyCmd)
+
+	assert.False(s.T(), echoes.IsEcho(asEchoEnvelope(s.T(), propertyModifiedEvent, `{"a": 2, "b": [2, 3]}`)))
+	assert.True(s.T(), echoes.IsEcho(asEchoEnvelope(s.T(), propertyModifiedEvent, `{"b": [2, 3], "a": 1}`)))
+	// single echo per forwarded command
+	assert.False(s.T(), echoes.IsEcho(asEchoEnvelope(s.T(), propertyModifiedEvent, `{"a": 1, "b": [2, 3]}`)))
+}
+
+func TestEchoFilter(t *testing.T) {
+	echoes := commands.NewEchoFilter(time.Minute)
+
+	echoes.Forwarded(asEchoEnvelope(t, modifyPropertyCmd))
+	assert.False(t, echoes.IsEcho(asEchoEnvelope(t, modifyPropertyCmd)))
+	assert.False(t, echoes.IsEcho(asEchoEnvelope(t, `{
+		"topic": "org.eclipse.kanto/test/things/twin/events/deleted",
+		"path": "/features/meter/properties/x"
+	}`)))
+	assert.False(t, echoes.IsEcho(asEchoEnvelope(t, `{
+		"topic": "org.eclipse.kanto/other/things/twin/events/modified",
+		"path": "/features/meter/properties/x",
+		"value": {"a": 1, "b": [2, 3]}
+	}`)))
+	assert.True(t, echoes.IsEcho(asEchoEnvelope(t, propertyModifiedEvent, `{"a": 1, "b": [2, 3]}`)))
+
+	echoes.Forwarded(asEchoEnvelope(t, `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		"path": "/features/meter"
+	}`))
+	assert.True(t, echoes.IsEcho(asEchoEnvelope(t, `{
+		"topic": "org.eclipse.kanto/test/things/twin/events/deleted",
+		"path": "/features/meter"
+	}`)))
+}
+
+func TestEchoFilterExpired(t *testing.T) {
+	echoes := commands.NewEchoFilter(time.Millisecond)
+
+	echoes.Forwarded(asEchoEnvelope(t, modifyPropertyCmd))
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, echoes.IsEcho(asEchoEnvelope(t, propertyModifiedEvent, `{"a": 1, "b": [2, 3]}`)))
+}
+
+func asEchoEnvelope(t *testing.T, format string, a ...interface{}) *protocol.Envelope {
+	data := format
+	if len(a) > 0 {
+		data = fmt.Sprintf(format, a...)
+	}
+	env := &protocol.Envelope{}
+	require.NoError(t, json.Unmarshal([]byte(data), env))
+	return env
+}
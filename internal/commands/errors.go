@@ -14,6 +14,7 @@ package commands
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 )
@@ -28,13 +29,16 @@ type ThingError struct {
 
 // NewThingNotFoundError creates thing not found error.
 func NewThingNotFoundError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
-	thingsErr := &ThingError{
+	return errorEnvelope(cmdEnvelope, thingNotFoundValue(thingID))
+}
+
+func thingNotFoundValue(thingID string) *ThingError {
+	return &ThingError{
 		Status:      404,
 		Error:       "things:thing.notfound",
 		Message:     fmt.Sprintf("The Thing with ID '%s' could not be found.", thingID),
 		Description: "Check if the ID of your requested Thing was correct.",
 	}
-	return errorEnvelope(cmdEnvelope, thingsErr)
 }
 
 // NewThingConflictError creates thing conflict error.
@@ -131,6 +135,20 @@ func NewIDNotSettableError(cmdEnvelope *protocol.Envelope) *protocol.Envelope {
 	return errorEnvelope(cmdEnvelope, thingsErr)
 }
 
+// NewPolicyIDNotAllowedError creates policy ID not allowed error, i.e. both the policy ID
+// and the policy to copy from are provided on thing creation.
+func NewPolicyIDNotAllowedError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status: 400,
+		Error:  "things:policyId.notallowed",
+		Message: fmt.Sprintf(
+			"The Thing with ID '%s' could not be created as it contained both the Policy ID and the policy to copy from.",
+			thingID),
+		Description: "Provide either the 'policyId' or the '_copyPolicyFrom' field of the Thing.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
 // NewIDInvalidError creates invalid Thing ID error.
 func NewIDInvalidError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
 	thingsErr := &ThingError{
@@ -153,6 +171,17 @@ func NewInvalidJSONValueError(cmdEnvelope *protocol.Envelope, jsonError error) *
 	return errorEnvelope(cmdEnvelope, thingsErr)
 }
 
+// NewInvalidEnvelopeError creates invalid json format error, quoting the envelope field which could not be parsed.
+func NewInvalidEnvelopeError(cmdEnvelope *protocol.Envelope, envelopeError *protocol.EnvelopeError) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "json.invalid",
+		Message:     fmt.Sprintf("Failed to parse command envelope field '%s': %s.", envelopeError.Field, envelopeError.Reason),
+		Description: fmt.Sprintf("Check the offending JSON fragment '%s'.", envelopeError.Fragment),
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
 // NewInvalidFieldSelectorError creates invalid json field selector error.
 func NewInvalidFieldSelectorError(cmdEnvelope *protocol.Envelope, jsonError error) *protocol.Envelope {
 	thingsErr := &ThingError{
@@ -164,6 +193,289 @@ func NewInvalidFieldSelectorError(cmdEnvelope *protocol.Envelope, jsonError erro
 	return errorEnvelope(cmdEnvelope, thingsErr)
 }
 
+// NewHeadersConflictError creates invalid headers error, i.e. the command headers contradict each other.
+func NewHeadersConflictError(cmdEnvelope *protocol.Envelope, headers []string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "headers.invalid",
+		Message:     fmt.Sprintf("The headers '%s' must not be combined.", strings.Join(headers, "', '")),
+		Description: "Remove one of the conflicting headers or change its value.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewConditionFailedError creates condition failed error, i.e. the command 'condition' header does not hold.
+func NewConditionFailedError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      412,
+		Error:       "things:condition.failed",
+		Message:     fmt.Sprintf("The specified condition does not match the state of the Thing with ID '%s'.", thingID),
+		Description: "Check if the specified condition was correct or retrieve the current state of the Thing.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewPreconditionFailedError creates precondition failed error, i.e. the command 'if-match' or 'if-none-match'
+// header does not hold for the entity tag of the command resource, empty if the resource does not exist.
+func NewPreconditionFailedError(cmdEnvelope *protocol.Envelope, header, value, eTag string) *protocol.Envelope {
+	message := fmt.Sprintf("The comparison of precondition header '%s' for the requested Thing resource "+
+		"evaluated to false. Header value: '%s'", header, value)
+	if len(eTag) > 0 {
+		message = fmt.Sprintf("%s, actual entity-tag: '%s'.", message, eTag)
+	} else {
+		message = message + ", the resource does not exist."
+	}
+	thingsErr := &ThingError{
+		Status:      412,
+		Error:       "things:precondition.failed",
+		Message:     message,
+		Description: "Check the value of your conditional header or retrieve the current entity-tag of the resource.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewConditionInvalidError creates invalid condition error, i.e. the command 'condition' header
+// is not a valid RQL expression.
+func NewConditionInvalidError(cmdEnvelope *protocol.Envelope, conditionError error) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "things:condition.invalid",
+		Message:     fmt.Sprintf("Invalid condition: %s.", conditionError),
+		Description: "Check the condition syntax, it must be a valid RQL expression.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewLiveTimeoutError creates live timeout error, i.e. no live response is received for a command routed
+// to the live channel within the command timeout.
+func NewLiveTimeoutError(cmdEnvelope *protocol.Envelope) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      408,
+		Error:       "command.timeout",
+		Message:     fmt.Sprintf("The live command timed out after %s.", cmdEnvelope.Headers.Timeout()),
+		Description: "Check if the owning application is connected or use 'on-live-channel-timeout' header 'use-twin'.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewCommandTimeoutError creates command timeout error, i.e. the command timeout has elapsed before
+// the command is handled or before the command response is available.
+func NewCommandTimeoutError(cmdEnvelope *protocol.Envelope) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      408,
+		Error:       "command.timeout",
+		Message:     fmt.Sprintf("The command reached the specified timeout of %s.", cmdEnvelope.Headers.Timeout()),
+		Description: "Try increasing the command 'timeout' header.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewUnsupportedCommandError creates unsupported command error, e.g. unsupported batch command.
+func NewUnsupportedCommandError(cmdEnvelope *protocol.Envelope) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "signal.unsupported",
+		Message:     fmt.Sprintf("The command '%s' with path '%s' is not supported.", cmdEnvelope.Topic, cmdEnvelope.Path),
+		Description: "Check if the command topic and path are correct and the command is not a batch one.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewNotImplementedError creates not implemented error, i.e. the command is not supported locally
+// and cannot be forwarded to the cloud as there is no hub connection.
+func NewNotImplementedError(cmdEnvelope *protocol.Envelope) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      501,
+		Error:       "command.notimplemented",
+		Message:     fmt.Sprintf("The command '%s' with path '%s' is not implemented locally.", cmdEnvelope.Topic, cmdEnvelope.Path),
+		Description: "Retry the command when the device is connected to the cloud.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewThingUnavailableError creates thing unavailable error, i.e. the command execution stalled
+// on the things storage for longer than the storage deadline.
+func NewThingUnavailableError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      503,
+		Error:       "things:thing.unavailable",
+		Message:     fmt.Sprintf("The Thing with ID '%s' is not available, please try again later.", thingID),
+		Description: "The things storage is not responding in time.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewTooLargeError creates entity too large error, i.e. the command envelope or value size exceeds
+// the configured limit.
+func NewTooLargeError(cmdEnvelope *protocol.Envelope, size int, maxSize int) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      413,
+		Error:       "things:thing.toolarge",
+		Message:     fmt.Sprintf("The size of '%d' bytes exceeds the maximal allowed size of '%d' bytes.", size, maxSize),
+		Description: "Reduce the size of the command value.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewTooManyRequestsError creates too many requests error, i.e. the rate limit of the modifying commands
+// of the thing is exceeded.
+func NewTooManyRequestsError(cmdEnvelope *protocol.Envelope, thingID string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      429,
+		Error:       "things:thing.toomanymodifyingrequests",
+		Message:     fmt.Sprintf("Too many modifying requests are already outstanding to the Thing with ID '%s'.", thingID),
+		Description: "Throttle your modifying requests to the Thing or re-structure them in a way that they can be processed in parallel.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewQuotaExceededError creates quota exceeded error, i.e. the stored size of the thing exceeds
+// the configured per-thing quota.
+func NewQuotaExceededError(cmdEnvelope *protocol.Envelope, thingID string, usage int64, quota int64) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status: 413,
+		Error:  "things:thing.quotaexceeded",
+		Message: fmt.Sprintf("The Thing with ID '%s' of '%d' bytes exceeds the storage quota of '%d' bytes.",
+			thingID, usage, quota),
+		Description: "Delete or reduce the Thing data before modifying it further.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewLimitExceededError creates storage limit exceeded error, i.e. the thing or feature would exceed
+// the configured storage limits.
+func NewLimitExceededError(cmdEnvelope *protocol.Envelope, thingID string, limitErr error) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      413,
+		Error:       "things:thing.limitexceeded",
+		Message:     fmt.Sprintf("The Thing with ID '%s' could not be stored: %s.", thingID, limitErr.Error()),
+		Description: "Delete other Things, Features or reduce the Feature properties before modifying it further.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewPayloadValidationError creates payload validation error, i.e. the modified feature properties
+// do not conform to the model of the feature definition.
+func NewPayloadValidationError(cmdEnvelope *protocol.Envelope, validationErr error) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "wot:payload.validation.error",
+		Message:     "The provided payload did not conform to the specified WoT (Web of Things) model.",
+		Description: validationErr.Error(),
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewDefinitionInvalidError creates invalid thing definition error, i.e. the definition is not in the form of
+// 'namespace:name:version'.
+func NewDefinitionInvalidError(cmdEnvelope *protocol.Envelope, definition string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "things:definition.identifier.invalid",
+		Message:     fmt.Sprintf("Definition identifier <%s> is invalid.", definition),
+		Description: "It must conform to the 'namespace:name:version' format.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewViewNotFoundError creates view not found error, i.e. there is no composite view with the provided name.
+func NewViewNotFoundError(cmdEnvelope *protocol.Envelope, view string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      404,
+		Error:       "things:view.notfound",
+		Message:     fmt.Sprintf("The view '%s' could not be found.", view),
+		Description: "Check if the name of your requested view was correct.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewLeaseNotFoundError creates lease not found error, i.e. there is no lease feature with the provided name.
+func NewLeaseNotFoundError(cmdEnvelope *protocol.Envelope, thingID string, lease string) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      404,
+		Error:       "things:lease.notfound",
+		Message:     fmt.Sprintf("The lease '%s' of the Thing with ID '%s' could not be found.", lease, thingID),
+		Description: "Check if the name of your requested lease was correct and it is not a Feature of another type.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewLeaseConflictError creates lease conflict error, i.e. the lease is held by another holder and is not expired.
+func NewLeaseConflictError(
+	cmdEnvelope *protocol.Envelope, thingID string, lease string, holder string, expires string,
+) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status: 409,
+		Error:  "things:lease.conflict",
+		Message: fmt.Sprintf("The lease '%s' of the Thing with ID '%s' is held by '%s' until %s.",
+			lease, thingID, holder, expires),
+		Description: "Retry once the lease is released or expired.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+// NewPolicyNotFoundError creates policy not found error, i.e. there is no locally stored policy with the provided ID.
+func NewPolicyNotFoundError(cmdEnvelope *protocol.Envelope, policyID string) *protocol.Envelope {
+	policiesErr := &ThingError{
+		Status:      404,
+		Error:       "policies:policy.notfound",
+		Message:     fmt.Sprintf("The Policy with ID '%s' could not be found.", policyID),
+		Description: "Check if the ID of your requested Policy was correct.",
+	}
+	return policyError(errorEnvelope(cmdEnvelope, policiesErr))
+}
+
+// NewPolicyConflictError creates policy conflict error, i.e. the created policy is already stored.
+func NewPolicyConflictError(cmdEnvelope *protocol.Envelope, policyID string) *protocol.Envelope {
+	policiesErr := &ThingError{
+		Status:      409,
+		Error:       "policies:policy.conflict",
+		Message:     fmt.Sprintf("The Policy with ID '%s' already exists.", policyID),
+		Description: "Choose another Policy ID.",
+	}
+	return policyError(errorEnvelope(cmdEnvelope, policiesErr))
+}
+
+// NewPolicyIDInvalidError creates invalid policy ID error, i.e. the policy document ID differs from the topic one.
+func NewPolicyIDInvalidError(cmdEnvelope *protocol.Envelope, policyID string) *protocol.Envelope {
+	policiesErr := &ThingError{
+		Status:      400,
+		Error:       "policies:id.invalid",
+		Message:     fmt.Sprintf("The Policy ID '%s' is not valid.", policyID),
+		Description: "The Policy ID of the document must be the same as the one of the topic.",
+	}
+	return policyError(errorEnvelope(cmdEnvelope, policiesErr))
+}
+
+// NewInvalidOptionsError creates invalid options error, i.e. the sort or pagination options are not well-formed.
+func NewInvalidOptionsError(cmdEnvelope *protocol.Envelope, optionsError error) *protocol.Envelope {
+	thingsErr := &ThingError{
+		Status:      400,
+		Error:       "things:options.invalid",
+		Message:     fmt.Sprintf("Invalid options: %s.", optionsError),
+		Description: "Check the options syntax, e.g. 'sort(+thingId),size(10),cursor(<cursor>)'.",
+	}
+	return errorEnvelope(cmdEnvelope, thingsErr)
+}
+
+func searchQueryInvalidValue(queryError error) *ThingError {
+	return &ThingError{
+		Status:      400,
+		Error:       "thing-search:query.invalid",
+		Message:     fmt.Sprintf("Invalid search query: %s.", queryError),
+		Description: "Check the search filter, it must be a valid RQL expression, and the search options syntax.",
+	}
+}
+
+func searchSubscriptionNotFoundValue(subscriptionID string) *ThingError {
+	return &ThingError{
+		Status:      404,
+		Error:       "thing-search:subscription.notfound",
+		Message:     fmt.Sprintf("The search subscription with ID '%s' could not be found.", subscriptionID),
+		Description: "Check if the subscription ID was correct and the subscription is not completed or cancelled.",
+	}
+}
+
 // NewUnknownError creates ThingError for unexpected error.
 func NewUnknownError(cmdEnvelope *protocol.Envelope, msg string, error error) *protocol.Envelope {
 	thingsErr := &ThingError{
@@ -190,3 +502,10 @@ func errorEnvelope(cmdEnvelope *protocol.Envelope, value *ThingError) *protocol.
 	}
 	return env.WithValue(value)
 }
+
+// policyError moves the error envelope to the policies group.
+func policyError(env *protocol.Envelope) *protocol.Envelope {
+	env.Topic.Group = protocol.GroupPolicies
+	env.Topic.Channel = ""
+	return env
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	parser "github.com/Jeffail/gabs/v2"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+const (
+	// MetadataExpiry is the desired property metadata field containing the RFC3339 timestamp
+	// after which the desired value expires, if not reconciled, e.g. set with the 'put-metadata' header
+	// [{"key": "expiry", "value": "2022-06-01T10:00:00Z"}] on the modify desired property command.
+	MetadataExpiry = "expiry"
+
+	// HeaderDesiredExpiry is the header of the desired property deleted events on expiry,
+	// containing the expired metadata timestamp.
+	HeaderDesiredExpiry = "desired-expiry"
+
+	// HeaderExpiry is the header of the property and feature deleted events on expiry,
+	// containing the expired metadata timestamp.
+	HeaderExpiry = "expiry"
+
+	segmentProperties        = "properties"
+	segmentDesiredProperties = "desiredProperties"
+
+	// topicLocalCommand is the local broker topic the expiry commands are published on,
+	// so that they are handled as any other local command.
+	topicLocalCommand = "e"
+)
+
+// Expiry periodically removes the expired twin resources with delete commands published on the local broker,
+// so that the deleted events are emitted and the hub is updated as on any other local command.
+//
+// The properties and the whole features stored with an expiry, e.g. the transient telemetry-like values
+// mirrored into the twin, are deleted once expired. Their expiry is set as 'expiry' metadata of the property,
+// e.g. with the 'put-metadata' header [{"key": "expiry", "value": "2022-06-01T10:00:00Z"}] on the modify property
+// command, or of the feature.
+//
+// The expired leases are released the same way with delete lease commands, so that the released events are emitted.
+// Note that an expired lease could be acquired by another holder before its release.
+//
+// The expired desired properties values, which are not reconciled, i.e. the reported property value differs
+// from the desired one, are cleared with delete desired property commands on their own interval.
+type Expiry struct {
+	Storage   persistence.ThingsStorage
+	Publisher message.Publisher
+	// Interval is the interval of the properties, the features and the leases expiry checks, disabled if not positive.
+	Interval time.Duration
+	// DesiredInterval is the interval of the desired properties expiry checks, disabled if not positive.
+	DesiredInterval time.Duration
+
+	Logger logger.Logger
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start starts the periodic expiry checks, it is a no-op if none of the check intervals is positive.
+func (e *Expiry) Start() {
+	if e.Interval <= 0 && e.DesiredInterval <= 0 {
+		return
+	}
+
+	e.stop = make(chan struct{})
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		resources := newTicker(e.Interval)
+		defer resources.stop()
+		desired := newTicker(e.DesiredInterval)
+		defer desired.stop()
+
+		for {
+			select {
+			case <-e.stop:
+				return
+			case now := <-resources.c:
+				if err := e.expire(now, true, false); err != nil {
+					e.Logger.Error("Failed to remove the expired properties, features and leases", err, nil)
+				}
+			case now := <-desired.c:
+				if err := e.expire(now, false, true); err != nil {
+					e.Logger.Error("Failed to clear the expired desired properties", err, nil)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic expiry checks and waits for the check in progress, if any.
+func (e *Expiry) Stop() {
+	if e.stop == nil {
+		return
+	}
+	close(e.stop)
+	e.wg.Wait()
+	e.stop = nil
+}
+
+// Expire removes the properties and the features, releases the leases and clears the desired properties values
+// expired at the provided time.
+// The expiry metadata of the reconciled or already removed desired properties and properties is removed.
+func (e *Expiry) Expire(now time.Time) error {
+	return e.expire(now, true, true)
+}
+
+func (e *Expiry) expire(now time.Time, resources bool, desired bool) error {
+	thingIDs, err := e.Storage.GetThingIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, thingID := range thingIDs {
+		thing := model.Thing{}
+		if err := e.Storage.GetThing(thingID, &thing); err != nil {
+			continue // removed meanwhile
+		}
+		for featureID, feature := range thing.Features {
+			if feature == nil {
+				continue
+			}
+			if resources {
+				if lease := expiredLease(feature, now); lease != nil {
+					cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Twin().Delete()
+					cmd.Path = pathLeases + "/" + featureID
+					if err := e.publishExpired(cmd, lease.Expires, HeaderExpiry); err != nil {
+						return err
+					}
+					continue
+				}
+				if expiry, ok := feature.Metadata[MetadataExpiry].(string); ok && expired(expiry, now) {
+					cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Twin().Feature(featureID).Delete()
+					if err := e.publishExpired(cmd, expiry, HeaderExpiry); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := e.expireFeature(thingID, featureID, feature, now, resources, desired); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Expiry) expireFeature(
+	thingID, featureID string, feature *model.Feature, now time.Time, resources bool, desired bool,
+) error {
+	removed := map[string]interface{}{}
+	var err error
+
+	properties, _ := feature.Metadata[segmentProperties].(map[string]interface{})
+	forEachExpiry(properties, nil, func(path []string, expiry string) {
+		if err != nil || !resources || !expired(expiry, now) {
+			return
+		}
+		if parser.Wrap(feature.Properties).Search(path...).Data() != nil {
+			cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Twin().
+				FeatureProperty(featureID, strings.Join(path, "/")).Delete()
+			err = e.publishExpired(cmd, expiry, HeaderExpiry)
+			return
+		}
+		putMetadataValue(removed, append([]string{segmentProperties}, append(path, MetadataExpiry)...), nil)
+	})
+
+	desiredProperties, _ := feature.Metadata[segmentDesiredProperties].(map[string]interface{})
+	forEachExpiry(desiredProperties, nil, func(path []string, expiry string) {
+		if err != nil || !desired || !expired(expiry, now) {
+			return
+		}
+
+		desiredValue := parser.Wrap(feature.DesiredProperties).Search(path...).Data()
+		reported := parser.Wrap(feature.Properties).Search(path...).Data()
+		if desiredValue != nil && !jsonEqual(desiredValue, reported) {
+			cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Twin().
+				FeatureDesiredProperty(featureID, strings.Join(path, "/")).Delete()
+			err = e.publishExpired(cmd, expiry, HeaderDesiredExpiry)
+			return
+		}
+		putMetadataValue(removed, append([]string{segmentDesiredProperties}, append(path, MetadataExpiry)...), nil)
+	})
+	if err != nil || len(removed) == 0 {
+		return err
+	}
+	return e.Storage.UpdateMetadata(thingID, featureID, removed)
+}
+
+// publishExpired publishes the delete command of the expired resource, with the expiry timestamp as header.
+func (e *Expiry) publishExpired(cmd *things.Command, expiry string, header string) error {
+	e.Logger.Info("Thing resource expired", watermill.LogFields{
+		"thingId": TopicNamespaceID(cmd.Topic),
+		"path":    cmd.Path,
+		"expiry":  expiry,
+	})
+
+	headers := protocol.NewHeaders().
+		WithCorrelationID(watermill.NewUUID()).
+		WithResponseRequired(false).
+		WithGeneric(header, expiry)
+	data, err := json.Marshal(cmd.Envelope(headers))
+	if err != nil {
+		return err
+	}
+	return e.Publisher.Publish(topicLocalCommand, message.NewMessage(watermill.NewUUID(), data))
+}
+
+// ticker is a time ticker, which never ticks if its interval is not positive.
+type ticker struct {
+	ticker *time.Ticker
+	c      <-chan time.Time
+}
+
+func newTicker(interval time.Duration) *ticker {
+	if interval <= 0 {
+		return &ticker{}
+	}
+	t := time.NewTicker(interval)
+	return &ticker{ticker: t, c: t.C}
+}
+
+func (t *ticker) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
+
+// expired returns true if the RFC3339 expiry timestamp is valid and not after the provided time.
+func expired(expiry string, now time.Time) bool {
+	expiryTime, err := time.Parse(time.RFC3339, expiry)
+	return err == nil && !now.Before(expiryTime)
+}
+
+// forEachExpiry calls the visit function with the property path of each expiry metadata field.
+func forEachExpiry(metadata map[string]interface{}, path []string, visit func(path []string, expiry string)) {
+	for key, value := range metadata {
+		if key == MetadataExpiry {
+			if expiry, ok := value.(string); ok && len(path) > 0 {
+				visit(path, expiry)
+			}
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			forEachExpiry(nested, append(path[:len(path):len(path)], key), visit)
+		}
+	}
+}
+
+func jsonEqual(value1, value2 interface{}) bool {
+	data1, err1 := json.Marshal(value1)
+	data2, err2 := json.Marshal(value2)
+	return err1 == nil && err2 == nil && string(data1) == string(data2)
+}
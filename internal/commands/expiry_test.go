@@ -0,0 +1,224 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"container/list"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExpiryCommandsSuite struct {
+	CommandsSuite
+	expiry    *commands.Expiry
+	publisher *testPublisher
+}
+
+func TestExpiryCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ExpiryCommandsSuite))
+}
+
+func (s *ExpiryCommandsSuite) SetupTest() {
+	s.publisher = &testPublisher{buffer: list.New()}
+	s.expiry = &commands.Expiry{
+		Storage:   s.handler.Storage,
+		Publisher: s.publisher,
+		Logger:    s.handler.Logger,
+	}
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).
+		WithProperty("x", 1).WithProperty("y", 2).
+		WithDesiredProperty("x", 5).WithDesiredProperty("y", 2).WithDesiredProperty("z", 3))
+}
+
+func (s *ExpiryCommandsSuite) putExpiry(expiry map[string]string) {
+	desired := map[string]interface{}{}
+	for path, timestamp := range expiry {
+		desired[path] = map[string]interface{}{commands.MetadataExpiry: timestamp}
+	}
+	require.NoError(s.T(), s.handler.Storage.UpdateMetadata(testThingID, testFeatureID,
+		map[string]interface{}{"desiredProperties": desired}))
+}
+
+func (s *ExpiryCommandsSuite) desiredMetadata() map[string]interface{} {
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	return feature.Metadata["desiredProperties"].(map[string]interface{})
+}
+
+func (s *ExpiryCommandsSuite) TestExpire() {
+	now := time.Now()
+	expired := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	s.putExpiry(map[string]string{
+		"x": expired,                                         // not reconciled
+		"y": expired,                                         // reconciled
+		"z": now.Add(time.Minute).UTC().Format(time.RFC3339), // not expired yet
+		"w": expired,                                         // removed
+		"v": "soon",                                          // invalid
+	})
+
+	require.NoError(s.T(), s.expiry.Expire(now))
+
+	msg, err := s.publisher.Pull()
+	require.NoError(s.T(), err)
+	_, err = s.publisher.Pull()
+	assert.Error(s.T(), err)
+
+	command := protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(msg.Payload, &command))
+	assert.Equal(s.T(), "org.eclipse.kanto/test/things/twin/commands/delete", command.Topic.String())
+	assert.Equal(s.T(), "/features/meter/desiredProperties/x", command.Path)
+	assert.False(s.T(), command.Headers.ResponseRequired())
+	value, ok := command.Headers.Generic(commands.HeaderDesiredExpiry)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), expired, value)
+
+	metadata := s.desiredMetadata()
+	assert.Contains(s.T(), metadata["x"], commands.MetadataExpiry)
+	assert.Contains(s.T(), metadata["z"], commands.MetadataExpiry)
+	assert.Contains(s.T(), metadata["v"], commands.MetadataExpiry)
+	assert.NotContains(s.T(), metadata["y"], commands.MetadataExpiry)
+	assert.NotContains(s.T(), metadata["w"], commands.MetadataExpiry)
+
+	// the expiry command is handled as any other local command
+	_, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDeleted, event.Topic.Action)
+	assert.Equal(s.T(), "/features/meter/desiredProperties/x", event.Path)
+	value, ok = event.Headers.Generic(commands.HeaderDesiredExpiry)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), expired, value)
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.NotContains(s.T(), feature.DesiredProperties, "x")
+	assert.Len(s.T(), feature.DesiredProperties, 2)
+
+	// the metadata of the cleared value is removed with it
+	require.NoError(s.T(), s.expiry.Expire(now))
+	_, err = s.publisher.Pull()
+	assert.Error(s.T(), err)
+	assert.NotContains(s.T(), s.desiredMetadata(), "x")
+}
+
+func (s *ExpiryCommandsSuite) TestExpireProperties() {
+	now := time.Now()
+	expired := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	require.NoError(s.T(), s.handler.Storage.UpdateMetadata(testThingID, testFeatureID, map[string]interface{}{
+		"properties": map[string]interface{}{
+			"x": map[string]interface{}{commands.MetadataExpiry: expired},
+			"y": map[string]interface{}{commands.MetadataExpiry: now.Add(time.Minute).UTC().Format(time.RFC3339)},
+			"w": map[string]interface{}{commands.MetadataExpiry: expired},
+		},
+	}))
+
+	require.NoError(s.T(), s.expiry.Expire(now))
+
+	msg, err := s.publisher.Pull()
+	require.NoError(s.T(), err)
+	_, err = s.publisher.Pull()
+	assert.Error(s.T(), err)
+
+	command := protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(msg.Payload, &command))
+	assert.Equal(s.T(), "org.eclipse.kanto/test/things/twin/commands/delete", command.Topic.String())
+	assert.Equal(s.T(), "/features/meter/properties/x", command.Path)
+	value, ok := command.Headers.Generic(commands.HeaderExpiry)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), expired, value)
+
+	_, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDeleted, event.Topic.Action)
+	assert.Equal(s.T(), "/features/meter/properties/x", event.Path)
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.NotContains(s.T(), feature.Properties, "x")
+	assert.Contains(s.T(), feature.Properties, "y")
+	metadata := feature.Metadata["properties"].(map[string]interface{})
+	assert.NotContains(s.T(), metadata, "x")
+	assert.NotContains(s.T(), metadata, "w")
+	assert.Contains(s.T(), metadata["y"], commands.MetadataExpiry)
+}
+
+func (s *ExpiryCommandsSuite) TestExpireFeature() {
+	now := time.Now()
+	expired := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	require.NoError(s.T(), s.handler.Storage.UpdateMetadata(testThingID, testFeatureID,
+		map[string]interface{}{commands.MetadataExpiry: expired}))
+
+	require.NoError(s.T(), s.expiry.Expire(now))
+
+	msg, err := s.publisher.Pull()
+	require.NoError(s.T(), err)
+	command := protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(msg.Payload, &command))
+	assert.Equal(s.T(), "/features/meter", command.Path)
+
+	_, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDeleted, event.Topic.Action)
+	value, ok := event.Headers.Generic(commands.HeaderExpiry)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), expired, value)
+
+	err = s.handler.Storage.GetFeature(testThingID, testFeatureID, &model.Feature{})
+	assert.ErrorIs(s.T(), err, persistence.ErrFeatureNotFound)
+}
+
+func (s *ExpiryCommandsSuite) TestExpireNotExpired() {
+	s.putExpiry(map[string]string{"x": time.Now().Add(time.Hour).UTC().Format(time.RFC3339)})
+
+	require.NoError(s.T(), s.expiry.Expire(time.Now()))
+	_, err := s.publisher.Pull()
+	assert.Error(s.T(), err)
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 5, feature.DesiredProperties["x"])
+}
+
+func (s *ExpiryCommandsSuite) TestStartStop() {
+	s.putExpiry(map[string]string{"x": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)})
+
+	// the desired properties are not cleared on the properties, features and leases expiry checks
+	s.expiry.Interval = 10 * time.Millisecond
+	s.expiry.Start()
+	time.Sleep(50 * time.Millisecond)
+	s.expiry.Stop()
+	_, err := s.publisher.Pull()
+	assert.Error(s.T(), err)
+
+	s.expiry.DesiredInterval = 10 * time.Millisecond
+	s.expiry.Start()
+	defer s.expiry.Stop()
+
+	assert.Eventually(s.T(), func() bool {
+		_, err := s.publisher.Pull()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
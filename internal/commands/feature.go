@@ -13,6 +13,9 @@
 package commands
 
 import (
+	"encoding/json"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
@@ -42,7 +45,7 @@ func modifyFeature(h *Handler, cmd *Command, out *CommandOutput) {
 		if rev, err := h.Storage.AddFeature(thingID, featureID, &feature); err != nil {
 			out.response = h.resourceNotFound("Modify feature failed", err, env, thingID, featureID)
 		} else {
-			out.response = responseEnvelope(env, status)
+			out.response = withETag(responseEnvelope(env, status), contentETag(&feature))
 			out.event = h.eventEnvelope(thingID, env, action)
 
 			out.thingID = thingID
@@ -52,6 +55,83 @@ func modifyFeature(h *Handler, cmd *Command, out *CommandOutput) {
 	}
 }
 
+// mergeFeature handles merge feature commands and builds the command output.
+// The command value is applied as JSON merge patch to the feature, i.e. the properties and
+// the desired properties with null value are deleted. The feature is created if missing.
+func mergeFeature(h *Handler, cmd *Command, out *CommandOutput) {
+	doFeatureMerge(h, cmd, nil, out)
+}
+
+// doFeatureMerge applies the command value as JSON merge patch to the feature member at the path,
+// given as JSON pointer reference tokens, and persists the merged feature.
+func doFeatureMerge(h *Handler, cmd *Command, path []string, out *CommandOutput) {
+	thingID := cmd.thingID
+	featureID := cmd.target
+
+	feature, err := h.loadFeatureForModify(thingID, featureID, cmd.envelope)
+	if err != nil && len(path) == 0 && errors.Is(err, persistence.ErrFeatureNotFound) {
+		feature, err = &model.Feature{}, nil
+	}
+	if err != nil {
+		out.response = h.resourceNotFound("Merge feature failed. Feature not found",
+			err, cmd.envelope, thingID, featureID)
+		return
+	}
+
+	var patch interface{}
+	if err := commandValue(cmd.envelope, &patch, out); err != nil {
+		return
+	}
+
+	merged := &model.Feature{}
+	if err := mergeFeatureAt(feature, path, patch, merged); err != nil {
+		out.invalidValueError = errors.Wrap(err, "invalid command payload")
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewInvalidJSONValueError(cmd.envelope, err)
+		}
+		return
+	}
+	merged.Metadata = feature.Metadata
+
+	if rev, err := h.Storage.AddFeature(thingID, featureID, merged); err != nil {
+		out.response = commandUnknownError("Merge feature failed", err, cmd.envelope, h.Logger)
+	} else {
+		out.response = withETag(responseEnvelope(cmd.envelope, modified), contentETag(merged))
+		out.event = h.eventEnvelope(thingID, cmd.envelope, protocol.ActionMerged)
+
+		out.thingID = thingID
+		out.featureID = featureID
+		out.revision = rev
+	}
+}
+
+func mergeFeatureAt(feature *model.Feature, path []string, patch interface{}, merged *model.Feature) error {
+	data, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+
+	var current interface{}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return err
+	}
+
+	if data, err = json.Marshal(jsonutil.MergePatchAt(current, path, patch)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, merged); err != nil {
+		return err
+	}
+
+	if len(merged.Properties) == 0 {
+		merged.WithProperties(nil)
+	}
+	if len(merged.DesiredProperties) == 0 {
+		merged.WithDesiredProperties(nil)
+	}
+	return nil
+}
+
 // retrieveFeature handles retrieve feature commands and builds the command output.
 func retrieveFeature(h *Handler, cmd *Command, out *CommandOutput) {
 	thingID := cmd.thingID
@@ -61,7 +141,8 @@ func retrieveFeature(h *Handler, cmd *Command, out *CommandOutput) {
 		out.response = h.resourceNotFound("Unable to retrieve feature. Feature not found",
 			err, cmd.envelope, thingID, featureID)
 	} else {
-		out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, feature)
+		out.response = h.retrieveResponse(cmd.envelope,
+			h.featureSpecialFields(thingID, featureID, feature, cmd.envelope.Fields))
 	}
 }
 
@@ -16,6 +16,7 @@ import (
 	"testing"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -154,7 +155,7 @@ func (s *FeatureCommandsSuite) TestFeatureModify() {
 		featuresOutput := featuresAsMapValue(s.T(), test.output)
 		assert.EqualValues(s.T(), featuresOutput[testFeatureID], thingOut.Features[testFeatureID])
 
-		assertPublishedOnOkF(s.S(), test.response, test.event)
+		assertPublishedOnOkETagF(s.S(), test.response, contentETag(s.T(), thingOut.Features[testFeatureID]), test.event)
 	}
 }
 
@@ -249,3 +250,132 @@ func (s *FeatureCommandsSuite) TestFeatureDelete() {
 
 	s.handleRetrieveCheckResponseF(retrieveFeatureCmd, featureNotFoundErr)
 }
+
+func (s *FeatureCommandsSuite) TestFeatureETag() {
+	modifyFeatureCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {
+			"properties": {"x": 12.34},
+			"desiredProperties": {"x": 5}
+		}
+	}`
+	otherFeatureCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {
+			"properties": {"x": 1}
+		}
+	}`
+
+	s.addTestThing()
+
+	s.handleCommandF(modifyFeatureCmd, defaultHeaders)
+	modifyETag := pullPublishedEnvelope(s.S()).Headers.ETag()
+	pullPublishedEnvelope(s.S()) // modified event
+	assert.Regexp(s.T(), `^"hash:[0-9a-f]+"$`, modifyETag)
+
+	s.handleCommandF(retrieveFeatureCmd, defaultHeaders)
+	assert.Equal(s.T(), modifyETag, pullPublishedEnvelope(s.S()).Headers.ETag())
+
+	s.handleCommandF(otherFeatureCmd, defaultHeaders)
+	otherETag := pullPublishedEnvelope(s.S()).Headers.ETag()
+	pullPublishedEnvelope(s.S()) // modified event
+	assert.NotEqual(s.T(), modifyETag, otherETag)
+
+	s.handleCommandF(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		%s,
+		"path": "/features/meter"
+	}`, defaultHeaders)
+	assert.Empty(s.T(), pullPublishedEnvelope(s.S()).Headers.ETag())
+}
+
+func (s *FeatureCommandsSuite) TestRetrieveWithFields() {
+	retrieveFieldsCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/fields"},
+		"path": "%s",
+		"fields": "%s"
+	}`
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).
+		WithProperties(map[string]interface{}{
+			"x":        12.34,
+			"location": map[string]interface{}{"city": "Sofia", "street": "Main"},
+		}).
+		WithDesiredProperties(map[string]interface{}{"x": 5}))
+
+	tests := []struct {
+		path   string
+		fields string
+		value  string
+	}{
+		{"/features/meter", "properties/x", `{"properties": {"x": 12.34}}`},
+		{"/features", "meter/desiredProperties", `{"meter": {"desiredProperties": {"x": 5}}}`},
+		{"/features/meter/properties", "location(city)", `{"location": {"city": "Sofia"}}`},
+		{"/features/meter/properties/location", "street", `{"street": "Main"}`},
+		{"/features/meter/properties/x", "unknown", `12.34`},
+	}
+
+	for _, test := range tests {
+		s.handleCommandF(retrieveFieldsCmd, test.path, test.fields)
+		response := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), 200, response.Status, test.path)
+		assert.JSONEq(s.T(), test.value, string(response.Value), test.path)
+		assert.NotEmpty(s.T(), response.Headers.ETag(), test.path)
+	}
+
+	s.handleCommandF(retrieveFieldsCmd, "/features/meter", "properties(x")
+	s.assertErrorResponse(400, "json.fieldselector.invalid")
+}
+
+func (s *FeatureCommandsSuite) TestRetrieveFlat() {
+	retrieveFlatCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/flat", "value-format": "flat"},
+		"path": "%s",
+		"fields": "%s"
+	}`
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).
+		WithProperties(map[string]interface{}{
+			"x":        12.34,
+			"location": map[string]interface{}{"city": "Sofia", "street": "Main"},
+		}).
+		WithDesiredProperties(map[string]interface{}{"x": 5}))
+
+	tests := []struct {
+		path   string
+		fields string
+		value  string
+	}{
+		{"/features/meter", "", `{
+			"/properties/x": 12.34,
+			"/properties/location/city": "Sofia",
+			"/properties/location/street": "Main",
+			"/desiredProperties/x": 5
+		}`},
+		{"/features/meter", "properties/location/city", `{"/properties/location/city": "Sofia"}`},
+		{"/features", "meter/desiredProperties", `{"/meter/desiredProperties/x": 5}`},
+		{"/features/meter/properties/x", "", `{"": 12.34}`},
+	}
+
+	for _, test := range tests {
+		s.handleCommandF(retrieveFlatCmd, test.path, test.fields)
+		response := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), 200, response.Status, test.path)
+		assert.JSONEq(s.T(), test.value, string(response.Value), test.path)
+		assert.Equal(s.T(), protocol.ValueFormatFlat, response.Headers.ValueFormat(), test.path)
+	}
+
+	// the entity tag is of the stored value regardless of its format
+	s.handleCommandF(retrieveFeatureCmd, defaultHeaders)
+	nested := pullPublishedEnvelope(s.S())
+	s.handleCommandF(retrieveFlatCmd, "/features/meter", "")
+	assert.Equal(s.T(), nested.Headers.ETag(), pullPublishedEnvelope(s.S()).Headers.ETag())
+}
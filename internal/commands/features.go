@@ -41,7 +41,7 @@ func modifyFeatures(h *Handler, cmd *Command, out *CommandOutput) {
 				out.response = commandUnknownError("Modify thing features failed", err, cmd.envelope, h.Logger)
 
 			} else {
-				out.response = responseEnvelope(cmd.envelope, status)
+				out.response = withETag(responseEnvelope(cmd.envelope, status), contentETag(features))
 				out.event = h.eventEnvelope(thingID, cmd.envelope, action)
 				out.thingID = thingID
 				out.revision = rev
@@ -50,6 +50,40 @@ func modifyFeatures(h *Handler, cmd *Command, out *CommandOutput) {
 	}
 }
 
+// mergeFeatures handles merge features commands and builds the command output.
+// The command value is applied as JSON merge patch to all thing's features at once,
+// i.e. properties and desired properties of multiple features are updated atomically.
+func mergeFeatures(h *Handler, cmd *Command, out *CommandOutput) {
+	thingID := cmd.thingID
+
+	thing, err := h.LoadThing(thingID, cmd.envelope)
+	if err != nil {
+		out.response = h.resourceNotFound("Merge thing features failed. Unknown thing",
+			err, cmd.envelope, thingID, noValue)
+		return
+	}
+
+	features := map[string]*model.Feature{}
+	if thing.Features != nil {
+		features = thing.Features
+	}
+	merged := map[string]*model.Feature{}
+	if err := mergeCommandValue(cmd.envelope, features, &merged, out); err != nil {
+		return
+	}
+	keepFeaturesMetadata(features, merged)
+
+	thing.WithFeatures(merged)
+	if rev, err := h.Storage.AddThing(thing); err != nil {
+		out.response = commandUnknownError("Merge thing features failed", err, cmd.envelope, h.Logger)
+	} else {
+		out.response = withETag(responseEnvelope(cmd.envelope, modified), contentETag(merged))
+		out.event = h.eventEnvelope(thingID, cmd.envelope, protocol.ActionMerged)
+		out.thingID = thingID
+		out.revision = rev
+	}
+}
+
 // retrieveFeatures handles retrieve features commands and builds the command output.
 func retrieveFeatures(h *Handler, cmd *Command, out *CommandOutput) {
 	thingID := cmd.thingID
@@ -62,7 +96,7 @@ func retrieveFeatures(h *Handler, cmd *Command, out *CommandOutput) {
 			out.response = h.featuresNotFound("Unable to retrieve any features of thing ID "+thingID,
 				cmd.envelope, thingID)
 		} else {
-			out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, thing.Features)
+			out.response = h.retrieveResponse(cmd.envelope, thing.Features)
 		}
 	}
 }
@@ -13,10 +13,12 @@
 package commands_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -240,7 +242,10 @@ func (s *FeaturesCommandsSuite) TestThingFeaturesModify() {
 		revision = revision + 2 // 2 storage writings expected
 		assert.EqualValues(s.T(), revision, thingOut.Revision)
 
-		assertPublishedOnOkF(s.S(), test.response, test.event)
+		// the entity tag is the one of the modified features, i.e. of the command value
+		modifiedFeatures := map[string]*model.Feature{}
+		require.NoError(s.T(), json.Unmarshal(s.asEnvelope(formattedCommand).Value, &modifiedFeatures))
+		assertPublishedOnOkETagF(s.S(), test.response, contentETag(s.T(), modifiedFeatures), test.event)
 
 		// check that published hono message is with false value for response required header
 		outputEnv := assertHonoMsgPublished(s.S())
@@ -263,7 +268,10 @@ func (s *FeaturesCommandsSuite) TestThingFeaturesRetrieve() {
 
 	s.addThing(featuresAsMapValue(s.T(), input))
 
-	s.handleRetrieveCheckResponseF(retrieve, retrieveRsp)
+	thingOut := model.Thing{}
+	s.getThing(&thingOut)
+	s.handleCommandF(retrieve, defaultHeaders)
+	assertPublished(s.S(), withETag(s.T(), withResponseHeadersF(retrieveRsp), contentETag(s.T(), thingOut.Features)))
 }
 
 func (s *FeaturesCommandsSuite) TestThingFeaturesRetrieveNoResponse() {
@@ -389,3 +397,81 @@ func (s *FeaturesCommandsSuite) TestModifyFeatures() {
 	assertPublishedOnErrorF(s.S(), thingNotFoundErr)
 	assertHonoMsgPublished(s.S())
 }
+
+func (s *FeaturesCommandsSuite) TestThingFeaturesMerge() {
+	input := `{
+		"meter": {"properties": {"x": 1.2}, "desiredProperties": {"x": 2}},
+		"sensor": {"properties": {"y": 3}, "desiredProperties": {"y": 4}},
+		"unused": {"properties": {"z": 5}}
+	}`
+	command := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/features",
+		"value": {
+			"meter": {"desiredProperties": {"x": 3}},
+			"sensor": {"desiredProperties": {"y": null, "z": 6}},
+			"unused": null
+		}
+	}`
+	response := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/features",
+		"status": 204
+	}`
+	event := `{
+		"topic": "org.eclipse.kanto/test/things/twin/events/merged",
+		%s,
+		"path": "/features",
+		"value": {
+			"meter": {"desiredProperties": {"x": 3}},
+			"sensor": {"desiredProperties": {"y": null, "z": 6}},
+			"unused": null
+		}
+	}`
+	output := `{
+		"meter": {"properties": {"x": 1.2}, "desiredProperties": {"x": 3}},
+		"sensor": {"properties": {"y": 3}, "desiredProperties": {"z": 6}}
+	}`
+
+	s.addThing(featuresAsMapValue(s.T(), input))
+
+	formattedCommand := withDefaultHeadersF(command)
+	assert.Nil(s.T(), s.handleCommand(formattedCommand))
+
+	thingOut := model.Thing{}
+	s.getThing(&thingOut)
+	assert.EqualValues(s.T(), featuresAsMapValue(s.T(), output), thingOut.Features)
+
+	assertPublishedOnOkETagF(s.S(), response, contentETag(s.T(), thingOut.Features), event)
+
+	outputEnv := assertHonoMsgPublished(s.S())
+	assertEnvelopeDataResponseRequiredChanged(s.S(),
+		s.asEnvelope(formattedCommand), outputEnv, true)
+}
+
+func (s *FeaturesCommandsSuite) TestThingFeaturesMergeInvalidValue() {
+	command := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/features",
+		"value": 5
+	}`
+
+	s.addThing(nil)
+	s.handleCommandCheckErrorF(command, defaultHeaders)
+}
+
+func (s *FeaturesCommandsSuite) TestMergeFeaturesThingNotFound() {
+	command := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/features",
+		"value": {"meter": {"desiredProperties": {"x": 1}}}
+	}`
+
+	s.handleCommandF(command, defaultHeaders)
+	assertPublishedOnErrorF(s.S(), thingNotFoundErr)
+	assertHonoMsgPublished(s.S())
+}
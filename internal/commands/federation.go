@@ -0,0 +1,50 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/federation"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// DittoFederation forwards the locally generated thing events to an Eclipse Ditto instance running on the edge,
+// mapped to the twin commands applying the same changes, over a dedicated topic of the local broker.
+type DittoFederation struct {
+	// Topic is the prefix of the local broker topics the twin commands are published to.
+	Topic string
+
+	Logger logger.Logger
+}
+
+// Forward publishes the twin command applying the change of the thing event, if any.
+func (f *DittoFederation) Forward(pub message.Publisher, event *protocol.Envelope) {
+	command := federation.EventCommand(event)
+	if command == nil {
+		return
+	}
+
+	data, err := json.Marshal(command)
+	if err != nil {
+		logCmdError("Unable to federate unexpected event", err, event, f.Logger)
+		return
+	}
+	topic := federation.CommandTopic(f.Topic, TopicNamespaceID(command.Topic))
+	if err := pub.Publish(topic, message.NewMessage(watermill.NewUUID(), data)); err != nil {
+		logCmdError("Unable to federate event", err, event, f.Logger)
+	}
+}
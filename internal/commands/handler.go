@@ -14,11 +14,15 @@ package commands
 
 import (
 	"encoding/json"
+	"sync/atomic"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
 	"github.com/eclipse-kanto/suite-connector/connector"
 	"github.com/eclipse-kanto/suite-connector/logger"
 	"github.com/pkg/errors"
@@ -36,6 +40,60 @@ type DeviceInfo struct {
 	DeviceID         string
 	TenantID         string
 	AutoProvisioning bool
+
+	AutoProvisioningFilter ProvisioningFilter
+
+	// FeatureAutoProvisioning enables creating the missing features on property modify commands.
+	FeatureAutoProvisioning bool
+
+	// SortedKeys enables publishing the responses and events values with lexicographically sorted object keys.
+	SortedKeys bool
+
+	// EventsExtraFields is the fields selector of the stored thing data added as extra to the published events.
+	EventsExtraFields string
+
+	// FeatureRevisions adds the thing revision and the timestamp of the last modification of the feature
+	// as 'feature-revision' and 'feature-modified' headers to the feature-level events and retrieve responses.
+	FeatureRevisions bool
+
+	// SearchDisabled forwards the things search commands to the cloud instead of answering them locally.
+	SearchDisabled bool
+	// LiveDisabled answers the live-preferred retrieve commands from the twin without routing them to the live channel.
+	LiveDisabled bool
+	// BatchDisabled forwards the batch commands to the cloud instead of executing them locally.
+	BatchDisabled bool
+	// LocalPolicies stores and serves the policy documents of the policies commands locally
+	// instead of forwarding them to the cloud.
+	LocalPolicies bool
+
+	// StrictMode replies with not implemented error to the unsupported commands if there is no hub connection,
+	// instead of forwarding them silently.
+	StrictMode bool
+
+	// MaxEnvelopeSize is the maximum size in bytes of a twin command envelope, 0 for unlimited.
+	MaxEnvelopeSize int
+	// MaxValueSize is the maximum size in bytes of a twin command value, 0 for unlimited.
+	MaxValueSize int
+
+	// RateLimit is the maximum rate of the modifying twin commands per thing in commands per second, 0 for unlimited.
+	RateLimit float64
+	// RateBurst is the maximum number of the modifying twin commands per thing handled at once
+	// on exceeding the rate limit, at least 1.
+	RateBurst int
+
+	// ThingQuota is the maximum stored size in bytes of a thing, on exceeding which its further modifying
+	// twin commands are rejected, 0 for unlimited.
+	ThingQuota int64
+
+	// DuplicatesCacheSize is the number of the recently handled modifying commands remembered by correlation ID
+	// to detect their redeliveries, e.g. the QoS 1 duplicates, 0 to disable the duplicates detection.
+	DuplicatesCacheSize int
+
+	// Views are the composite views of selected properties of multiple features.
+	Views Views
+
+	// Redaction are the rules redacting the values of the published, journaled and federated events.
+	Redaction Redaction
 }
 
 // Handler manages ditto protocol commands using the local digital twins storage.
@@ -47,6 +105,71 @@ type Handler struct {
 	Storage      persistence.ThingsStorage
 
 	Logger logger.Logger
+
+	// Echoes, if set, tracks the locally applied commands forwarded to the cloud.
+	Echoes *EchoFilter
+
+	// Counters, if set, counts the handled commands and the error responses.
+	Counters *status.Counters
+
+	// Changes, if set, records the things changed by the handled commands, e.g. for the read replicas.
+	Changes ChangesRecorder
+
+	// Validators, if set, validates the modified feature properties against the models of the feature definitions.
+	Validators *Validators
+
+	// Journal, if set, persists the locally generated thing events.
+	Journal *EventJournal
+
+	// Replay, if set, queues the locally generated thing events while offline and replays them to the hub.
+	Replay *EventReplay
+
+	// Aggregator, if set, batches the thing events published to the local subscribers
+	// into thing-level merged events.
+	Aggregator *EventAggregator
+
+	// Federation, if set, forwards the locally generated thing events to an edge-hosted Eclipse Ditto.
+	Federation *DittoFederation
+
+	// Watchdog, if set, fails the command executions stalled on the things storage.
+	Watchdog *StorageWatchdog
+
+	// Shadow, if set, verifies a sampled percentage of the retrieve commands responses against the cloud ones.
+	Shadow *ShadowVerifier
+
+	dispatch commandsDispatch
+
+	// state keeps the *handlerState shared with the transactional copies of the handler, created on its first use.
+	state atomic.Value
+
+	// tx, if set, keeps the events published within the command storage transaction.
+	tx *txEvents
+}
+
+// handlerState keeps the commands awaiting completion and the per-thing limits of the handler.
+type handlerState struct {
+	acks   acksRegistry
+	live   liveRegistry
+	search searchRegistry
+
+	rateLimiters rateLimiters
+	quotas       thingQuotas
+	duplicates   duplicatesCache
+}
+
+// shared returns the handler state, shared with the transactional copies of the handler.
+// The state is created once per handler, the concurrent first uses create the same state.
+func (h *Handler) shared() *handlerState {
+	if state, ok := h.state.Load().(*handlerState); ok {
+		return state
+	}
+	h.state.CompareAndSwap(nil, &handlerState{})
+	return h.state.Load().(*handlerState)
+}
+
+// ChangesRecorder records the changed things.
+type ChangesRecorder interface {
+	ThingChanged(thingID string)
 }
 
 // Command contains the parsed command data used by CommandFunc to perform the ditto command.
@@ -73,6 +196,16 @@ type CommandOutput struct {
 	thingID   string
 	featureID string
 	revision  int64
+	// thingDeleted is true if the thing is deleted, its tombstone is purged once the command is forwarded.
+	thingDeleted bool
+
+	// committed, if set, publishes the events buffered within the committed storage transaction.
+	committed func()
+
+	// live is true if the command is routed to the live channel and is not forwarded to the hub.
+	live bool
+	// local is true if the command is on a locally defined resource, e.g. a view, and is not forwarded to the hub.
+	local bool
 }
 
 // CommandFunc performs the passed Command using the provided Handler.
@@ -85,77 +218,185 @@ func (h *Handler) HandleCommand(msg *message.Message) ([]*message.Message, error
 	command := &protocol.Envelope{}
 
 	if err := json.Unmarshal(msg.Payload, &command); err != nil {
-		return nil, errors.Wrap(err, "invalid command payload")
+		return nil, errors.Wrap(h.rejectInvalidEnvelope(msg, err), "invalid command payload")
 	}
 
 	if command.Topic.Channel == protocol.ChannelTwin &&
 		command.Topic.Criterion == protocol.CriterionCommands {
 
-		cmdType, target, path := ParseCmdPath(command.Path)
-		if cmdType == ScopeUnknown {
-			return nil, errors.Errorf("invalid command path %s", command.Path)
+		if h.rejectTooLarge(msg, command) || h.rejectConflictingHeaders(command) {
+			return nil, nil
 		}
 
-		var cmdFunc CommandFunc
-		var cmd *Command
-
-		if cmdType == ScopeThing {
-			// commands with '/' path prefix
-			cmdFunc = thingCommand(command.Topic.Action)
-			cmd = &Command{
-				envelope: command,
-				thingID:  TopicNamespaceID(command.Topic),
+		if command.Topic.Action == protocol.ActionBatch {
+			if h.BatchDisabled {
+				logCmdUnsupported(command, h.Logger)
+				return []*message.Message{msg}, nil
 			}
+			h.handleBatch(msg, command)
+			return nil, nil
 		}
 
-		if cmdType >= ScopeFeatures {
-			// all thing commands with '/features' path prefix
-			cmdFunc = featuresPathCommand(command.Topic.Action, cmdType)
-			cmd = &Command{
-				envelope: command,
-				thingID:  TopicNamespaceID(command.Topic),
-				target:   target,
-				path:     path,
-			}
+		cmdFunc, cmd, err := h.parseCommand(command)
+		if err != nil {
+			return nil, err
 		}
 
 		if cmdFunc == nil {
 			logCmdUnsupported(command, h.Logger)
+			if h.StrictMode {
+				h.forwardUnsupported(msg, command)
+				return nil, nil
+			}
 			return []*message.Message{msg}, nil
 		}
 
+		if h.duplicate(msg, cmd) {
+			return nil, nil
+		}
+
 		output := &CommandOutput{}
-		cmdFunc(h, cmd, output)
+		deadline := commandDeadline(msg, command)
+		if h.timedOut(cmd, deadline, output) || h.rateLimited(cmd, output) || h.quotaExceeded(cmd, output) ||
+			!h.definitionsConformed(cmd, output) {
+			// neither executed nor forwarded
+			h.publishCommandLocalOutput(msg, command, output)
+			h.countCommand(output)
+			return nil, nil
+		}
+
+		execute := func(out *CommandOutput) {
+			h.executeAtomically(cmd, out, func(tx *Handler, out *CommandOutput) {
+				if tx.conditionMet(cmd, out) && tx.preconditionsMet(cmd, out) {
+					cmdFunc(tx, cmd, out)
+					tx.putMetadata(cmd, out)
+					tx.eventWithExtra(cmd, out)
+					tx.featureRevisionHeaders(cmd, out)
+				}
+			})
+		}
+		if h.Watchdog == nil {
+			execute(output)
+		} else if !h.Watchdog.Run(cmd, output, execute) {
+			// neither completed nor forwarded
+			h.publishCommandLocalOutput(msg, command, output)
+			h.countCommand(output)
+			return nil, nil
+		}
+		// the events of a stalled execution are never published, its changes are left to the synchronization
+		output.publishCommitted()
+		if command.Topic.Action == protocol.ActionRetrieve && output.response != nil {
+			// the retrieved result is no longer awaited
+			h.timedOut(cmd, deadline, output)
+		}
+		h.awaitAcks(command, output)
+		h.awaitLive(cmd, output)
 
 		h.publishCommandLocalOutput(msg, command, output)
+		h.commandHandled(msg, cmd, output)
+		h.publishViewEvents(cmd, output)
+		h.recordChange(cmd.thingID, output)
+		h.trackUsage(cmd.thingID, output)
+		h.countCommand(output)
 		if output.invalidValueError != nil {
 			logCmdHandled(command, h.Logger)
 			return nil, output.invalidValueError
 		}
 
 		logCmdHandled(command, h.Logger)
-		err := h.publishCommandToHono(msg, command, output)
+		ttl := honoTTL(command, deadline)
+		err = h.publishCommandToHono(msg, command, output, ttl)
 		if err == nil {
 			h.Logger.Trace("Thing command forwarded to hono successfully", nil)
-			h.resourceSynchronized(output)
+			if ttl <= 0 {
+				// the expiring hono messages might not be delivered, leave them to the synchronization
+				h.resourceSynchronized(output)
+			}
+			if h.Echoes != nil && len(output.thingID) > 0 {
+				h.Echoes.Forwarded(command)
+			}
 		}
 		return nil, nil
 	}
 
+	if command.Topic.Channel == protocol.ChannelTwin &&
+		command.Topic.Criterion == protocol.CriterionSearch &&
+		!h.SearchDisabled && h.handleSearch(command) {
+		return nil, nil
+	}
+
+	if command.Topic.Group == protocol.GroupPolicies &&
+		command.Topic.Criterion == protocol.CriterionCommands &&
+		h.LocalPolicies && h.handlePolicy(command) {
+		return nil, nil
+	}
+
+	if command.Topic.Channel == protocol.ChannelLive &&
+		command.Topic.Criterion == protocol.CriterionCommands &&
+		h.liveResponseReceived(command) {
+		h.Logger.Trace("Live response consumed by local live-preferred retrieve command", nil)
+		return nil, nil
+	}
+
+	if h.StrictMode && command.Topic.Criterion == protocol.CriterionMessages && command.Status == 0 {
+		logCmdUnsupported(command, h.Logger)
+		h.forwardUnsupported(msg, command)
+		return nil, nil
+	}
+
+	if command.Topic.Channel == protocol.ChannelTwin &&
+		command.Topic.Criterion == protocol.CriterionAcks &&
+		h.acknowledgementReceived(command) {
+		h.Logger.Tracef("Acknowledgement '%s' consumed by local twin command", command.Topic.Action)
+		return nil, nil
+	}
+
 	return []*message.Message{msg}, nil
 }
 
-func (h *Handler) publishCommandToHono(msg *message.Message, command *protocol.Envelope, output *CommandOutput) error {
+// parseCommand returns the function performing the command and the parsed command data.
+// The returned function is nil if the command is not supported.
+// Returns error if the command path is invalid.
+func (h *Handler) parseCommand(command *protocol.Envelope) (CommandFunc, *Command, error) {
+	cmdType, target, path := ParseCmdPath(command.Path)
+	if cmdType == ScopeUnknown {
+		return nil, nil, errors.Errorf("invalid command path %s", command.Path)
+	}
+
+	return h.commandFunc(cmdType, command.Topic.Action), &Command{
+		envelope: command,
+		thingID:  TopicNamespaceID(command.Topic),
+		target:   target,
+		path:     path,
+	}, nil
+}
+
+func (h *Handler) publishCommandToHono(
+	msg *message.Message, command *protocol.Envelope, output *CommandOutput, ttl time.Duration,
+) error {
+	if output.live || output.local {
+		return nil // answered by the local owning application or by the local twin
+	}
+
 	forwardMsg := msg
+	var shadowID string
 	if output.response != nil {
 		// do not require response if already published
 		if command.Topic.Action == protocol.ActionRetrieve {
-			forwardMsg = cmdWithNoResponseRequired(msg, command)
+			var shadowMsg *message.Message
+			if shadowMsg, shadowID = h.shadowForward(msg, command, output); shadowMsg != nil {
+				forwardMsg = shadowMsg
+			} else {
+				forwardMsg = cmdWithNoResponseRequired(msg, command)
+			}
 		}
 	}
 
-	err := PublishHonoMsg(forwardMsg, h.HonoPub, h.DeviceInfo, TopicNamespaceID(command.Topic))
+	err := PublishHonoMsgTTL(forwardMsg, h.HonoPub, h.DeviceInfo, TopicNamespaceID(command.Topic), ttl)
 	if err != nil {
+		if len(shadowID) > 0 {
+			h.Shadow.cancel(shadowID)
+		}
 		if errors.Is(err, connector.ErrNotConnected) {
 			h.Logger.Trace("Thing command not forwarded to hono: no hub connection", nil)
 		} else {
@@ -165,151 +406,78 @@ func (h *Handler) publishCommandToHono(msg *message.Message, command *protocol.E
 	return err
 }
 
-func thingCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionCreate:
-		return createThing
-
-	case protocol.ActionModify:
-		return modifyThing
-
-	case protocol.ActionDelete:
-		return deleteThing
-
-	case protocol.ActionRetrieve:
-		return retrieveThing
-
-	default:
-		return nil
+// forwardUnsupported forwards the unsupported command to hono.
+// Replies with not implemented error if the command cannot be forwarded as there is no hub connection.
+func (h *Handler) forwardUnsupported(msg *message.Message, command *protocol.Envelope) {
+	err := PublishHonoMsg(msg, h.HonoPub, h.DeviceInfo, TopicNamespaceID(command.Topic))
+	if err == nil {
+		h.Logger.Trace("Unsupported thing command forwarded to hono successfully", nil)
+		return
 	}
-}
-
-func featuresPathCommand(action protocol.TopicAction, cmdType Scope) CommandFunc {
-	switch cmdType {
-	case ScopeFeatures:
-		// /features
-		return featuresCommand(action)
-
-	case ScopeFeature:
-		// /features/<featureID>
-		return featureCommand(action)
-
-	case ScopeFeatureProperties:
-		// /features/<featureID>/properties
-		return propertiesCommand(action)
-
-	case ScopeFeatureProperty:
-		// /features/<featureID>/properties/<propertyPath>
-		return propertyCommand(action)
 
-	case ScopeFeatureDesiredProperties:
-		// /features/<featureID>/desiredProperties
-		return desiredPropertiesCommand(action)
-
-	case ScopeFeatureDesiredProperty:
-		// /features/<featureID>/desiredProperties/<propertyPath>
-		return desiredPropertyCommand(action)
-
-	default:
-		// /features/<featureID>/definition
-		return nil // unsupported
+	if !errors.Is(err, connector.ErrNotConnected) {
+		logCmdError("Unsupported thing command not forwarded to hono, unexpected error:", err, command, h.Logger)
+		return
 	}
-}
-
-func featuresCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyFeatures
 
-	case protocol.ActionDelete:
-		return deleteFeatures
-
-	case protocol.ActionRetrieve:
-		return retrieveFeatures
-
-	default:
-		return nil
+	h.Logger.Trace("Unsupported thing command not forwarded to hono: no hub connection", nil)
+	if command.Headers.ResponseRequired() {
+		publishResponse(h, NewNotImplementedError(command))
 	}
 }
 
-func featureCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyFeature
-
-	case protocol.ActionDelete:
-		return deleteFeature
-
-	case protocol.ActionRetrieve:
-		return retrieveFeature
-
-	default:
-		return nil
+// rejectTooLarge replies with entity too large error if the command envelope or value size exceeds
+// the configured limits. The rejected command is neither executed nor forwarded to the hub.
+func (h *Handler) rejectTooLarge(msg *message.Message, command *protocol.Envelope) bool {
+	size, maxSize := len(msg.Payload), h.MaxEnvelopeSize
+	if maxSize <= 0 || size <= maxSize {
+		size, maxSize = len(command.Value), h.MaxValueSize
+		if maxSize <= 0 || size <= maxSize {
+			return false
+		}
 	}
-}
-
-func propertiesCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyProperties
 
-	case protocol.ActionDelete:
-		return deleteProperties
-
-	case protocol.ActionRetrieve:
-		return retrieveProperties
-
-	default:
-		return nil
+	logCmdError("Thing command rejected", errors.Errorf("size %d exceeds the limit %d", size, maxSize),
+		command, h.Logger)
+	if command.Headers.ResponseRequired() {
+		publishResponse(h, NewTooLargeError(command, size, maxSize))
 	}
+	return true
 }
 
-func desiredPropertiesCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyDesiredProperties
-
-	case protocol.ActionDelete:
-		return deleteDesiredProperties
-
-	case protocol.ActionRetrieve:
-		return retrieveDesiredProperties
-
-	default:
-		return nil
+// rejectConflictingHeaders replies with invalid headers error if the command headers contradict each other.
+// The rejected command is neither executed nor forwarded to the hub.
+func (h *Handler) rejectConflictingHeaders(command *protocol.Envelope) bool {
+	if command.Headers == nil {
+		return false
+	}
+	conflicts := command.Headers.ConflictingHeaders()
+	if len(conflicts) == 0 {
+		return false
 	}
-}
-
-func propertyCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyProperty
-
-	case protocol.ActionDelete:
-		return deleteProperty
-
-	case protocol.ActionRetrieve:
-		return retrieveProperty
 
-	default:
-		return nil
+	logCmdError("Thing command rejected", errors.Errorf("conflicting headers %v", conflicts), command, h.Logger)
+	if command.Headers.ResponseRequired() {
+		publishResponse(h, NewHeadersConflictError(command, conflicts))
 	}
+	return true
 }
 
-func desiredPropertyCommand(action protocol.TopicAction) CommandFunc {
-	switch action {
-	case protocol.ActionModify:
-		return modifyDesiredProperty
-
-	case protocol.ActionDelete:
-		return deleteDesiredProperty
-
-	case protocol.ActionRetrieve:
-		return retrieveDesiredProperty
+// rejectInvalidEnvelope returns the error describing exactly which field of the command envelope could not be parsed.
+// If the envelope is of a twin command with parsed topic and headers, it is responded with the same error.
+func (h *Handler) rejectInvalidEnvelope(msg *message.Message, err error) error {
+	command, validateErr := protocol.ValidateEnvelope(msg.Payload)
+	envelopeErr := &protocol.EnvelopeError{}
+	if !errors.As(validateErr, &envelopeErr) {
+		return err
+	}
 
-	default:
-		return nil
+	if command.Topic != nil && command.Topic.Channel == protocol.ChannelTwin &&
+		command.Topic.Criterion == protocol.CriterionCommands &&
+		command.Headers != nil && command.Headers.ResponseRequired() {
+		publishResponse(h, NewInvalidEnvelopeError(command, envelopeErr))
 	}
+	return envelopeErr
 }
 
 func (h *Handler) eventEnvelope(
@@ -321,7 +489,34 @@ func (h *Handler) eventEnvelope(
 			err, cmdEnvelope, h.Logger)
 		return nil
 	}
-	return eventEnvelope(cmdEnvelope, thing.Revision, thing.Timestamp, action)
+	return eventEnvelope(cmdEnvelope, &thing, action)
+}
+
+// eventWithExtra adds the configured extra fields of the stored thing to the command event, if any,
+// so that the local subscribers are provided with additional thing data without retrieving it.
+func (h *Handler) eventWithExtra(cmd *Command, output *CommandOutput) {
+	if output.event == nil || len(h.EventsExtraFields) == 0 {
+		return
+	}
+
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		return // no extra fields for deleted things
+	}
+	data, err := json.Marshal(thing)
+	if err != nil {
+		logCmdError("Unable to add event extra fields", err, cmd.envelope, h.Logger)
+		return
+	}
+
+	extra, err := jsonutil.JSONSubset(string(data), h.EventsExtraFields)
+	if err != nil {
+		logCmdError("Unable to add event extra fields", err, cmd.envelope, h.Logger)
+		return
+	}
+	if extra != "{}" {
+		output.event.WithExtra(json.RawMessage(extra))
+	}
 }
 
 func (h *Handler) resourceNotFound(
@@ -333,6 +528,9 @@ func (h *Handler) resourceNotFound(
 		if errors.Is(err, persistence.ErrThingNotFound) {
 			return NewThingNotFoundError(cmd, thingID)
 		}
+		if errors.Is(err, persistence.ErrLimitExceeded) {
+			return NewLimitExceededError(cmd, thingID, err)
+		}
 		return NewFeatureNotFoundError(cmd, thingID, featureID)
 	}
 	return nil
@@ -394,6 +592,9 @@ func (h *Handler) LoadFeature(
 
 func autoprovisionThing(h *Handler, cmd *protocol.Envelope, thingID string) (model.Thing, error) {
 	thing := (&model.Thing{}).WithIDFrom(thingID)
+	if err := h.checkAutoProvisioning(thingID); err != nil {
+		return *thing, err
+	}
 	if _, err := h.Storage.AddThing(thing); err != nil {
 		return *thing, err
 	}
@@ -401,6 +602,44 @@ func autoprovisionThing(h *Handler, cmd *protocol.Envelope, thingID string) (mod
 	return *thing, nil
 }
 
+// loadFeatureForModify loads the feature to be modified from the given thing.
+// If there is no such feature and feature auto-provisioning is enabled
+// an empty feature is created, persisted and a feature created event is published.
+// The created feature stays unsynchronized until the next synchronization,
+// as the cloud is not aware of it when the property modify command is forwarded.
+func (h *Handler) loadFeatureForModify(
+	thingID string, featureID string, envelope *protocol.Envelope,
+) (*model.Feature, error) {
+	feature, err := h.LoadFeature(thingID, featureID, envelope)
+	if err != nil && h.FeatureAutoProvisioning && errors.Is(err, persistence.ErrFeatureNotFound) {
+		return autoprovisionFeature(h, envelope, thingID, featureID)
+	}
+	return feature, err
+}
+
+func autoprovisionFeature(
+	h *Handler, cmd *protocol.Envelope, thingID string, featureID string,
+) (*model.Feature, error) {
+	feature := &model.Feature{}
+	if _, err := h.Storage.AddFeature(thingID, featureID, feature); err != nil {
+		return nil, err
+	}
+
+	thing := model.Thing{}
+	if err := h.Storage.GetThingData(thingID, &thing); err != nil {
+		return nil, err
+	}
+	event := &protocol.Envelope{
+		Topic:     eventTopic(cmd.Topic, protocol.ActionCreated),
+		Path:      "/features/" + featureID,
+		Revision:  thing.Revision,
+		Timestamp: thing.Timestamp,
+	}
+	headers := responseHeadersWithContent(cmd.Headers).WithTimestampQuality(thing.TimestampQuality)
+	publishEvent(h, event.WithHeaders(headers).WithValue(feature))
+	return feature, nil
+}
+
 func featureNotFoundError(thingID string, featureID string) error {
 	return errors.Wrapf(persistence.ErrFeatureNotFound,
 		"feature with ID '%s' on the thing with ID '%s' could not be loaded", featureID, thingID)
@@ -430,12 +669,32 @@ func (h *Handler) publishCommandLocalOutput(msg *message.Message, command *proto
 	}
 }
 
+// recordChange records the thing changed by the successfully executed command, if any.
+func (h *Handler) recordChange(thingID string, output *CommandOutput) {
+	if h.Changes != nil && output.event != nil && len(thingID) > 0 {
+		h.Changes.ThingChanged(thingID)
+	}
+}
+
+// countCommand counts the handled command and its error response, if any.
+func (h *Handler) countCommand(output *CommandOutput) {
+	h.Counters.Inc(status.CounterCommandsHandled)
+	if output.response != nil && output.response.Status >= 400 {
+		h.Counters.Inc(status.CounterErrors)
+	}
+}
+
 func (h *Handler) resourceSynchronized(output *CommandOutput) {
 	if len(output.thingID) <= 0 {
 		return
 	}
 
-	if len(output.featureID) > 0 {
+	if output.thingDeleted {
+		if ok, _ := h.Storage.PurgeTombstone(output.thingID, output.revision); ok {
+			h.Logger.Tracef("Thing '%s' deletion is marked as synchronized", output.thingID)
+		}
+
+	} else if len(output.featureID) > 0 {
 		if ok, _ := h.Storage.FeatureSynchronized(output.thingID, output.featureID, output.revision); ok {
 			h.Logger.Tracef("Feature '%s' of thing '%s' is marked as synchronized", output.featureID, output.thingID)
 		}
@@ -18,12 +18,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/connector"
 	"github.com/eclipse-kanto/suite-connector/logger"
 	"github.com/eclipse-kanto/suite-connector/testutil"
 
@@ -126,9 +132,13 @@ func NewTestHandler(autoProvisioning bool, t *testing.T) (*commands.Handler, err
 
 type testPublisher struct {
 	buffer *list.List
+	mutex  sync.Mutex
 }
 
 func (p *testPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	for _, msg := range msgs {
 		if msg.Metadata != nil {
 			msg.Metadata.Set(testAttribute, topic)
@@ -140,11 +150,25 @@ func (p *testPublisher) Publish(topic string, msgs ...*message.Message) error {
 	return nil
 }
 
+// offlinePublisher fails to publish as there is no connection.
+type offlinePublisher struct{}
+
+func (p *offlinePublisher) Publish(topic string, msgs ...*message.Message) error {
+	return connector.ErrNotConnected
+}
+
+func (p *offlinePublisher) Close() error {
+	return nil
+}
+
 func (p *testPublisher) Close() error {
 	return nil
 }
 
 func (p *testPublisher) Pull() (*message.Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	if next := p.buffer.Front(); next != nil {
 		return p.buffer.Remove(next).(*message.Message), nil
 	}
@@ -370,6 +394,600 @@ func (s *CommonCommandsSuite) TestUnsupportedCriterionTopics() {
 	}
 }
 
+func (s *CommonCommandsSuite) TestStrictMode() {
+	s.addTestThing()
+
+	s.handler.StrictMode = true
+	hono := s.handler.HonoPub
+	defer func() {
+		s.handler.StrictMode = false
+		s.handler.HonoPub = hono
+	}()
+
+	attributesCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/attributes/location",
+		"value": "office"
+	}`
+	messageCmd := `{
+		"topic": "org.eclipse.kanto/test/things/live/messages/hello",
+		%s,
+		"path": "/inbox/messages/hello"
+	}`
+
+	assert.Empty(s.T(), s.handleCommandF(attributesCmd, defaultHeaders))
+	assertPublishedNone(s.S())
+	forwarded, err := hono.(*testPublisher).Pull()
+	require.NoError(s.T(), err)
+	assert.NotNil(s.T(), forwarded)
+
+	s.handler.HonoPub = &offlinePublisher{}
+	for _, cmd := range []string{attributesCmd, messageCmd} {
+		assert.Empty(s.T(), s.handleCommandF(cmd, defaultHeaders))
+		s.assertErrorResponse(501, "command.notimplemented")
+
+		assert.Empty(s.T(), s.handleCommandF(cmd, headersNoResponseRequired))
+		assertPublishedNone(s.S())
+	}
+}
+
+func (s *CommonCommandsSuite) TestTooLarge() {
+	s.addTestThing()
+
+	defer func() {
+		s.handler.MaxEnvelopeSize = 0
+		s.handler.MaxValueSize = 0
+	}()
+
+	attributesCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/attributes/location",
+		"value": "a very long location description"
+	}`
+
+	hono := s.handler.HonoPub.(*testPublisher)
+	for _, limits := range [][2]int{{0, 16}, {128, 0}} {
+		s.handler.MaxEnvelopeSize, s.handler.MaxValueSize = limits[0], limits[1]
+
+		assert.Empty(s.T(), s.handleCommandF(attributesCmd, defaultHeaders))
+		s.assertErrorResponse(413, "things:thing.toolarge")
+
+		assert.Empty(s.T(), s.handleCommandF(attributesCmd, headersNoResponseRequired))
+		assertPublishedNone(s.S())
+
+		_, err := hono.Pull()
+		assert.Error(s.T(), err)
+	}
+
+	thing := &model.Thing{}
+	s.getThing(thing)
+	assert.Empty(s.T(), thing.Attributes)
+}
+
+func (s *CommonCommandsSuite) TestConflictingHeaders() {
+	s.addTestThing()
+
+	attributesCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			%s
+		},
+		"path": "/attributes/location",
+		"value": "here"
+	}`
+
+	hono := s.handler.HonoPub.(*testPublisher)
+	for _, headers := range []string{
+		`"if-match": "*", "if-none-match": "*"`,
+		`"timeout": "0", "response-required": true`,
+	} {
+		assert.Empty(s.T(), s.handleCommandF(attributesCmd, headers))
+		s.assertErrorResponse(400, "headers.invalid")
+
+		_, err := hono.Pull()
+		assert.Error(s.T(), err)
+	}
+
+	thing := &model.Thing{}
+	s.getThing(thing)
+	assert.Empty(s.T(), thing.Attributes)
+}
+
+func (s *CommonCommandsSuite) TestRateLimit() {
+	s.addTestThing()
+
+	s.handler.RateLimit = 0.001
+	s.handler.RateBurst = 2
+	defer func() {
+		s.handler.RateLimit = 0
+		s.handler.RateBurst = 0
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {}
+	}`
+	retrieveCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/features/meter"
+	}`
+
+	hono := s.handler.HonoPub.(*testPublisher)
+	for i := 0; i < 2; i++ {
+		assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders))
+		response := pullPublishedEnvelope(s.S())
+		assert.True(s.T(), response.Status < 300)
+		pullPublishedEnvelope(s.S()) // event
+		_, err := hono.Pull()
+		assert.NoError(s.T(), err)
+	}
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders))
+	s.assertErrorResponse(429, "things:thing.toomanymodifyingrequests")
+	_, err := hono.Pull()
+	assert.Error(s.T(), err)
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, headersNoResponseRequired))
+	assertPublishedNone(s.S())
+
+	// retrieve commands are not limited
+	assert.Empty(s.T(), s.handleCommandF(retrieveCmd, defaultHeaders))
+	assert.Equal(s.T(), 200, pullPublishedEnvelope(s.S()).Status)
+}
+
+func (s *CommonCommandsSuite) TestThingQuota() {
+	s.addTestThing()
+
+	s.handler.ThingQuota = 512
+	defer func() {
+		s.handler.ThingQuota = 0
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {"properties": {"x": "%s"}}
+	}`
+	deleteCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		%s,
+		"path": "/features/meter"
+	}`
+	retrieveCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/"
+	}`
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "small"))
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+	pullPublishedEnvelope(s.S()) // event
+	assertPublishedNone(s.S())
+
+	large := strings.Repeat("x", 512)
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, large))
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+	pullPublishedEnvelope(s.S()) // event
+
+	warning := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), "/features/status/outbox/messages/quotaExceeded", warning.Path)
+	value := status.QuotaWarning{}
+	require.NoError(s.T(), json.Unmarshal(warning.Value, &value))
+	assert.Equal(s.T(), testThingID, value.ThingID)
+	assert.Greater(s.T(), value.Usage, int64(512))
+	assert.Equal(s.T(), int64(512), value.Quota)
+
+	// rejected without a repeated warning
+	for i := 0; i < 2; i++ {
+		assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "small"))
+		s.assertErrorResponse(413, "things:thing.quotaexceeded")
+		assertPublishedNone(s.S())
+	}
+
+	// retrieve and delete commands are not rejected
+	assert.Empty(s.T(), s.handleCommandF(retrieveCmd, defaultHeaders))
+	assert.Equal(s.T(), 200, pullPublishedEnvelope(s.S()).Status)
+
+	assert.Empty(s.T(), s.handleCommandF(deleteCmd, defaultHeaders))
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // event
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "small"))
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+}
+
+func (s *CommonCommandsSuite) TestEventJournal() {
+	s.addTestThing()
+
+	journal := &commands.EventJournal{Storage: s.handler.Storage, Logger: s.handler.Logger}
+	s.handler.Journal = journal
+	defer func() {
+		s.handler.Journal = nil
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {"properties": {"x": 1}}
+	}`
+	retrieveCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/features/meter"
+	}`
+	deleteCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		%s,
+		"path": "/features/meter"
+	}`
+	for _, cmd := range []string{modifyCmd, retrieveCmd, deleteCmd} {
+		assert.Empty(s.T(), s.handleCommandF(cmd, defaultHeaders))
+	}
+
+	events, err := journal.Events(testThingID, 0, 0)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 2)
+	assert.Equal(s.T(), protocol.ActionCreated, events[0].Topic.Action)
+	assert.Equal(s.T(), protocol.ActionDeleted, events[1].Topic.Action)
+	assert.Equal(s.T(), "/features/meter", events[1].Path)
+
+	events, err = journal.Events(testThingID, events[1].Revision, events[1].Revision)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), protocol.ActionDeleted, events[0].Topic.Action)
+}
+
+func (s *CommonCommandsSuite) TestEventReplay() {
+	s.addTestThing()
+
+	publisher := &testPublisher{buffer: list.New()}
+	replay := &commands.EventReplay{
+		Storage:   s.handler.Storage,
+		Publisher: publisher,
+		DeviceID:  s.handler.DeviceID,
+		TenantID:  "tenant",
+		Topic:     "t",
+		MaxEvents: 2,
+		Logger:    s.handler.Logger,
+	}
+	s.handler.Replay = replay
+	defer func() {
+		s.handler.Replay = nil
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {"properties": {"x": %d}}
+	}`
+	for value := 1; value <= 3; value++ {
+		assert.Empty(s.T(), s.handleCommandF(fmt.Sprintf(modifyCmd, "%s", value), defaultHeaders))
+	}
+
+	// no events are replayed while offline
+	replayed, err := replay.Replay()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 0, replayed)
+
+	replay.Connected(true)
+	assert.Empty(s.T(), s.handleCommandF(fmt.Sprintf(modifyCmd, "%s", 4), defaultHeaders))
+	replayed, err = replay.Replay()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, replayed)
+
+	// the oldest event is dropped as the queue is full, the online event is not queued
+	require.Equal(s.T(), 2, publisher.buffer.Len())
+	for _, expected := range []int{2, 3} {
+		msg := publisher.buffer.Remove(publisher.buffer.Front()).(*message.Message)
+		assert.Equal(s.T(), "t", msg.Metadata.Get(testAttribute))
+		event := &protocol.Envelope{}
+		require.NoError(s.T(), json.Unmarshal(msg.Payload, event))
+		assert.Equal(s.T(), protocol.ActionModified, event.Topic.Action)
+		assert.JSONEq(s.T(), fmt.Sprintf(`{"properties": {"x": %d}}`, expected), string(event.Value))
+		_, ok := event.Headers.Generic(commands.HeaderReplayed)
+		assert.True(s.T(), ok)
+	}
+
+	replayed, err = replay.Replay()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 0, replayed)
+
+	// the events are kept queued if they cannot be published
+	replay.Connected(false)
+	assert.Empty(s.T(), s.handleCommandF(fmt.Sprintf(modifyCmd, "%s", 5), defaultHeaders))
+	replay.Connected(true)
+	replay.Publisher = &offlinePublisher{}
+	replayed, err = replay.Replay()
+	assert.ErrorIs(s.T(), err, connector.ErrNotConnected)
+	assert.Equal(s.T(), 0, replayed)
+
+	replay.Publisher = publisher
+	replayed, err = replay.Replay()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, replayed)
+}
+
+func (s *CommonCommandsSuite) TestEventAggregator() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 0))
+
+	aggregator := &commands.EventAggregator{Window: time.Hour, Logger: s.handler.Logger}
+	s.handler.Aggregator = aggregator
+	defer func() {
+		s.handler.Aggregator = nil
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter/properties/x",
+		"value": %d
+	}`
+	for i := 1; i <= 3; i++ {
+		assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, i))
+		assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+	}
+	assertPublishedNone(s.S())
+
+	aggregator.Flush()
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionMerged, event.Topic.Action)
+	assert.Equal(s.T(), "/", event.Path)
+	assert.Equal(s.T(), protocol.ContentTypeJSONMerge, event.Headers.ContentType())
+
+	stored := &model.Thing{}
+	s.getThing(stored)
+	assert.Equal(s.T(), stored.Revision, event.Revision)
+
+	thing := &model.Thing{}
+	require.NoError(s.T(), json.Unmarshal(event.Value, thing))
+	assert.EqualValues(s.T(), 3, thing.Features[testFeatureID].Properties["x"])
+	assertPublishedNone(s.S())
+}
+
+// stalledStorage blocks the features loading until released.
+type stalledStorage struct {
+	persistence.ThingsStorage
+	release chan struct{}
+}
+
+func (s *stalledStorage) GetFeature(thingID string, featureID string, feature *model.Feature) error {
+	<-s.release
+	return s.ThingsStorage.GetFeature(thingID, featureID, feature)
+}
+
+func (s *stalledStorage) Batch(f func(storage persistence.ThingsStorage) error) error {
+	<-s.release
+	return s.ThingsStorage.Batch(f)
+}
+
+func (s *CommonCommandsSuite) TestStorageWatchdog() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 1))
+
+	storage := s.handler.Storage
+	stalled := &stalledStorage{ThingsStorage: storage, release: make(chan struct{})}
+	watchdog := &commands.StorageWatchdog{Deadline: 50 * time.Millisecond, Logger: s.handler.Logger}
+	s.handler.Storage = stalled
+	s.handler.Watchdog = watchdog
+	defer func() {
+		s.handler.Storage = storage
+		s.handler.Watchdog = nil
+	}()
+
+	retrieveCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/features/meter"
+	}`
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveCmd, defaultHeaders))
+	s.assertErrorResponse(503, "things:thing.unavailable")
+	assert.Equal(s.T(), 1, watchdog.Stalled())
+	assert.Error(s.T(), watchdog.Check())
+
+	close(stalled.release)
+	assert.Eventually(s.T(), func() bool {
+		return watchdog.Stalled() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(s.T(), watchdog.Check())
+	assertPublishedNone(s.S())
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveCmd, defaultHeaders))
+	assert.Equal(s.T(), 200, pullPublishedEnvelope(s.S()).Status)
+}
+
+func (s *CommonCommandsSuite) TestStorageLimits() {
+	s.addTestThing()
+
+	s.handler.Storage.SetLimits(persistence.Limits{MaxFeatures: 1, MaxPropertySize: 16})
+	defer s.handler.Storage.SetLimits(persistence.Limits{})
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/%s",
+		"value": {"properties": {"x": "%s"}}
+	}`
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "meter", "small"))
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+	pullPublishedEnvelope(s.S()) // event
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "meter", strings.Repeat("x", 16)))
+	s.assertErrorResponse(413, "things:thing.limitexceeded")
+	assertPublishedNone(s.S())
+
+	assert.Empty(s.T(), s.handleCommandF(modifyCmd, defaultHeaders, "other", "small"))
+	s.assertErrorResponse(413, "things:thing.limitexceeded")
+	assertPublishedNone(s.S())
+}
+
+func (s *CommonCommandsSuite) TestDuplicates() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 0))
+
+	s.handler.DuplicatesCacheSize = 2
+	defer func() {
+		s.handler.DuplicatesCacheSize = 0
+	}()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "%s"},
+		"path": "/features/meter/properties/x",
+		"value": %d
+	}`
+
+	hono := s.handler.HonoPub.(*testPublisher)
+	assertHandled := func(correlationID string, value int) {
+		assert.Empty(s.T(), s.handleCommandF(modifyCmd, correlationID, value))
+		response := pullPublishedEnvelope(s.S())
+		assert.True(s.T(), response.Status < 300)
+		assert.Equal(s.T(), correlationID, response.Headers.CorrelationID())
+		pullPublishedEnvelope(s.S()) // event
+		_, err := hono.Pull()
+		assert.NoError(s.T(), err)
+	}
+
+	assertHandled("test/duplicates/1", 1)
+	for i := 0; i < 2; i++ {
+		assert.Empty(s.T(), s.handleCommandF(modifyCmd, "test/duplicates/1", 1))
+		response := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), 204, response.Status)
+		assert.Equal(s.T(), "test/duplicates/1", response.Headers.CorrelationID())
+		assertPublishedNone(s.S())
+		_, err := hono.Pull()
+		assert.Error(s.T(), err)
+	}
+
+	// the same correlation ID of another command
+	assertHandled("test/duplicates/1", 2)
+
+	// the least recently handled command is evicted
+	assertHandled("test/duplicates/2", 3)
+	assertHandled("test/duplicates/3", 4)
+	assertHandled("test/duplicates/1", 2)
+
+	feature := &model.Feature{}
+	s.getFeature(testFeatureID, feature)
+	assert.EqualValues(s.T(), 2, feature.Properties["x"])
+}
+
+func (s *CommonCommandsSuite) TestTimeout() {
+	s.addTestThing()
+
+	modifyCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			"timeout": "%s"
+		},
+		"path": "/features/meter",
+		"value": {
+			"properties": {
+				"location": "%s"
+			}
+		}
+	}`
+	hono := s.handler.HonoPub.(*testPublisher)
+
+	// received long before the command handling
+	msg := message.NewMessage("timeout", []byte(fmt.Sprintf(modifyCmd, "1s", "expired")))
+	msg.Metadata.Set(commands.MetadataReceived, time.Now().Add(-2*time.Second).Format(time.RFC3339Nano))
+	msgs, err := s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), msgs)
+	s.assertErrorResponse(408, "command.timeout")
+	_, err = hono.Pull()
+	assert.Error(s.T(), err)
+
+	thing := &model.Thing{}
+	s.getThing(thing)
+	assert.Empty(s.T(), thing.Features)
+
+	// the remaining time is propagated as hono message expiry
+	msg = message.NewMessage("timeout", []byte(fmt.Sprintf(modifyCmd, "10s", "applied")))
+	msg.Metadata.Set(commands.MetadataReceived, time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+	msgs, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), msgs)
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+	pullPublishedEnvelope(s.S()) // event
+
+	forwarded, err := hono.Pull()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "e/?hono-ttl=9", forwarded.Metadata.Get(testAttribute))
+
+	s.getThing(thing)
+	assert.Equal(s.T(), "applied", thing.Features[testFeatureID].Properties["location"])
+}
+
+type testChangesRecorder struct {
+	thingIDs []string
+}
+
+func (r *testChangesRecorder) ThingChanged(thingID string) {
+	r.thingIDs = append(r.thingIDs, thingID)
+}
+
+func (s *CommonCommandsSuite) TestChangesRecorded() {
+	s.addTestThing()
+
+	recorder := &testChangesRecorder{}
+	s.handler.Changes = recorder
+	defer func() {
+		s.handler.Changes = nil
+	}()
+
+	assert.Empty(s.T(), s.handleCommandF(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/"
+	}`, headersNoResponseRequired))
+	assert.Empty(s.T(), recorder.thingIDs)
+
+	assert.Empty(s.T(), s.handleCommandF(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {}
+	}`, headersNoResponseRequired))
+	assert.Equal(s.T(), []string{testThingID}, recorder.thingIDs)
+}
+
+func (s *CommonCommandsSuite) TestCounters() {
+	s.addTestThing()
+
+	s.handler.Counters = &status.Counters{}
+	defer func() {
+		s.handler.Counters = nil
+	}()
+
+	retrieveCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/features/%s"
+	}`
+	s.addFeature(testFeatureID, &model.Feature{})
+	s.handleCommandF(retrieveCmd, defaultHeaders, testFeatureID)
+	s.handleCommandF(retrieveCmd, defaultHeaders, "unknown")
+
+	assert.Equal(s.T(), map[string]uint64{
+		status.CounterCommandsHandled: 2,
+		status.CounterErrors:          1,
+	}, s.handler.Counters.Value().Values)
+}
+
 func (s *CommonCommandsSuite) TestUnexpectedCommandWithError() {
 	s.addTestThing()
 
@@ -403,6 +1021,18 @@ func (s *CommonCommandsSuite) TestUnexpectedCommandWithError() {
 				%s,
 				"path": ["/features/meter/desiredProperties"]
 			}`,
+			response: `{
+				"topic": "org.eclipse.kanto/test/things/twin/errors",
+				%s,
+				"path": "/",
+				"value": {
+					"status": 400,
+					"error": "json.invalid",
+					"message": "Failed to parse command envelope field 'path': expected string, found array.",
+					"description": "Check the offending JSON fragment '[\"/features/meter/desiredProperties\"]'."
+				},
+				"status": 400
+			}`,
 		},
 
 		// invalid command path
@@ -659,6 +1289,26 @@ func (s *CommandsSuite) handleRetrieveCheckResponseF(cmd string, expectedFormat
 	}
 }
 
+func (s *CommandsSuite) handleRetrieveCheckResponseETagF(cmd string, expectedFormat string, eTag string) {
+	if len(expectedFormat) != 0 {
+		msgs := s.handleCommandF(cmd, defaultHeaders)
+		// assert that response is as expected, including the retrieved resource entity tag
+		assertPublished(s, withETag(s.T(), withResponseHeadersF(expectedFormat), eTag))
+		// assert that command not forwarded to the hub
+		assert.True(s.T(), len(msgs) == 0)
+	}
+}
+
+func (s *CommandsSuite) assertErrorResponse(status int, errorCode string) {
+	response := pullPublishedEnvelope(s.S())
+	require.NotNil(s.T(), response)
+	assert.Equal(s.T(), status, response.Status)
+
+	thingErr := commands.ThingError{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &thingErr))
+	assert.Equal(s.T(), errorCode, thingErr.Error)
+}
+
 func withResponseHeadersF(envFormat string) string {
 	if len(envFormat) == 0 {
 		return envFormat
@@ -701,6 +1351,12 @@ func assertPublishedOnOkF(s *CommandsSuite, response string, event string) {
 		s.asEnvelopeWithValueF(event))
 }
 
+func assertPublishedOnOkETagF(s *CommandsSuite, response string, eTag string, event string) {
+	assertPublished(s,
+		withETag(s.T(), withHeadersNoResponseRequired(response), eTag), // response value not expected, only its entity tag
+		s.asEnvelopeWithValueF(event))
+}
+
 func assertPublishedOnErrorF(s *CommandsSuite, response string) {
 	assertPublished(s,
 		withResponseHeadersF(response)) // response value expected as error json
@@ -751,6 +1407,42 @@ func checkIfPublished(s *CommandsSuite, skipVersioning bool, expected ...interfa
 	assert.Equal(s.T(), 0, pub.buffer.Len())
 }
 
+// withETag sets the etag header of the expected envelope, i.e. the entity tag of the response resource.
+// No entity tag is expected if the provided one is empty, e.g. on error responses.
+func withETag(t *testing.T, expected string, eTag string) string {
+	if len(expected) == 0 || len(eTag) == 0 {
+		return expected
+	}
+	env := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(expected), &env))
+	headers, ok := env["headers"].(map[string]interface{})
+	require.True(t, ok, expected)
+	headers["etag"] = eTag
+
+	payload, err := json.Marshal(env)
+	require.NoError(t, err)
+	return string(payload)
+}
+
+// commandValueETag returns the expected entity tag of the resource modified by the provided command, i.e. of its value.
+func (s *CommandsSuite) commandValueETag(cmd string) string {
+	var value interface{}
+	require.NoError(s.T(), json.Unmarshal(s.asEnvelope(cmd).Value, &value))
+	return contentETag(s.T(), value)
+}
+
+// revisionETag returns the expected entity tag of a thing with the provided revision.
+func revisionETag(revision int64) string {
+	return fmt.Sprintf(`"rev:%d"`, revision)
+}
+
+// contentETag returns the expected entity tag of a thing sub-resource, i.e. the hash of its canonical JSON content.
+func contentETag(t *testing.T, value interface{}) string {
+	hash, err := jsonutil.Hash(value, jsonutil.HashFNV64a)
+	require.NoError(t, err)
+	return fmt.Sprintf(`"hash:%s"`, hash)
+}
+
 func assertEnvelope(s *CommandsSuite, expected *protocol.Envelope, actual protocol.Envelope) {
 	assertEnvelopeData(s, expected, actual)
 	assertEnvelopVersioning(s, expected, actual)
@@ -786,6 +1478,14 @@ func assertEnvelopVersioning(
 	assert.EqualValues(s.T(), expected.Timestamp, actual.Timestamp)
 }
 
+func pullPublishedEnvelope(s *CommandsSuite) *protocol.Envelope {
+	rsp, err := s.handler.MosquittoPub.(*testPublisher).Pull()
+	require.NoError(s.T(), err)
+	env := protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(rsp.Payload, &env))
+	return &env
+}
+
 func assertHonoMsgPublished(s *CommandsSuite) *protocol.Envelope {
 	pub := s.handler.HonoPub.(*testPublisher)
 	rsp, err := pub.Pull()
@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// defaultJournalPruneInterval is the interval of the journal retention checks, if not configured.
+const defaultJournalPruneInterval = time.Minute
+
+// EventJournal persists the locally generated thing events, i.e. the created, modified and deleted ones,
+// in an append-only journal of the things storage, so that the changes could be inspected or replayed later,
+// e.g. the ones made while offline. The oldest events exceeding the retention policy are periodically removed.
+type EventJournal struct {
+	Storage   persistence.ThingsStorage
+	Retention persistence.JournalRetention
+	Interval  time.Duration
+
+	Logger logger.Logger
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start starts the periodic retention checks, it is a no-op if no retention limit is configured.
+func (j *EventJournal) Start() {
+	if j.Retention.MaxSize <= 0 && j.Retention.MaxAge <= 0 {
+		return
+	}
+
+	interval := j.Interval
+	if interval <= 0 {
+		interval = defaultJournalPruneInterval
+	}
+
+	j.stop = make(chan struct{})
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case now := <-ticker.C:
+				if err := j.Prune(now); err != nil {
+					j.Logger.Error("Failed to remove the expired journaled events", err, nil)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic retention checks and waits for the check in progress, if any.
+func (j *EventJournal) Stop() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	j.wg.Wait()
+	j.stop = nil
+}
+
+// Append journals the thing event. The events of the live channel and of the composite views are not journaled,
+// as they do not change the stored things.
+func (j *EventJournal) Append(event *protocol.Envelope) {
+	if event.Topic == nil || event.Topic.Channel != protocol.ChannelTwin || strings.HasPrefix(event.Path, pathViews) {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logCmdError("Unable to journal unexpected event", err, event, j.Logger)
+		return
+	}
+
+	entry := &data.JournalEntry{
+		ThingID:  TopicNamespaceID(event.Topic),
+		Revision: event.Revision,
+		Event:    payload,
+	}
+	if err := j.Storage.AppendEvent(entry); err != nil {
+		logCmdError("Unable to journal event", err, event, j.Logger)
+	}
+}
+
+// Events returns the journaled events of the thing with revisions within the provided inclusive range,
+// ordered by revision. A zero or negative upper bound is unlimited.
+func (j *EventJournal) Events(thingID string, fromRevision int64, toRevision int64) ([]*protocol.Envelope, error) {
+	entries, err := j.Storage.GetEvents(thingID, fromRevision, toRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*protocol.Envelope, 0, len(entries))
+	for _, entry := range entries {
+		event := &protocol.Envelope{}
+		if err := json.Unmarshal(entry.Event, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Prune removes the journaled events exceeding the retention policy at the provided time.
+func (j *EventJournal) Prune(now time.Time) error {
+	removed, err := j.Storage.PruneEvents(j.Retention, now)
+	if removed > 0 {
+		j.Logger.Debugf("Removed %d expired journaled events", removed)
+	}
+	return err
+}
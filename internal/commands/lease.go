@@ -0,0 +1,319 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+	"github.com/pkg/errors"
+)
+
+// LeaseDefinition is the definition of the twin-managed lease features. A lease is acquired, renewed and released
+// with twin commands on path '/leases/<leaseName>' of any thing and is stored as the properties of the feature
+// with the lease name as ID, so that the lease changes are emitted as feature events.
+const LeaseDefinition = "org.eclipse.kanto:Lease:1.0.0"
+
+const pathLeases = "/leases"
+
+var (
+	errLeaseHeld     = errors.New("lease is held by another holder")
+	errLeaseNotFound = errors.New("lease could not be found")
+	errNotLease      = errors.New("feature is not a lease")
+)
+
+// LeaseRequest is the value of the modify lease commands, acquiring or renewing the lease,
+// and of the delete lease commands, releasing it, e.g. {"holder": "app-1", "ttl": 30}.
+type LeaseRequest struct {
+	// Holder identifies the local application holding the lease.
+	Holder string `json:"holder"`
+	// TTL is the time to live of the lease in seconds, counted from its acquisition or from its last renewal.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// Lease is the state of a held lease, i.e. the properties of its feature.
+type Lease struct {
+	Holder string `json:"holder"`
+	TTL    int64  `json:"ttl"`
+	// Acquired is the RFC3339 timestamp of the lease acquisition by the holder.
+	Acquired string `json:"acquired"`
+	// Expires is the RFC3339 timestamp after which the lease could be acquired by another holder.
+	Expires string `json:"expires"`
+}
+
+// acquireLease handles the modify lease commands and builds the command output.
+// The lease is acquired if it is not held or is expired and renewed if it is held by the same holder,
+// the command fails with conflict error otherwise. The lease is compared and set atomically,
+// its feature is created on the first acquisition. The lease commands are not forwarded to the hub.
+func acquireLease(h *Handler, cmd *Command, out *CommandOutput) {
+	out.local = true
+
+	request := LeaseRequest{}
+	if err := commandValue(cmd.envelope, &request, out); err != nil {
+		return
+	}
+	if len(request.Holder) == 0 || request.TTL <= 0 {
+		out.invalidValueError = errors.New("lease holder and positive ttl are required")
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewInvalidJSONValueError(cmd.envelope, out.invalidValueError)
+		}
+		return
+	}
+	if _, err := h.LoadThing(cmd.thingID, cmd.envelope); err != nil {
+		out.response = h.thingNotFound("Acquire lease failed", err, cmd.envelope, cmd.thingID)
+		return
+	}
+
+	now := time.Now().UTC()
+	var (
+		feature    *model.Feature
+		current    *Lease
+		lease      *Lease
+		newFeature bool
+		renewed    bool
+	)
+	err := h.Storage.Batch(func(storage persistence.ThingsStorage) error {
+		var err error
+		newFeature, renewed = false, false
+		if feature, err = loadLease(storage, cmd.thingID, cmd.target); errors.Is(err, errLeaseNotFound) {
+			feature, err = (&model.Feature{}).WithDefinitionFrom(LeaseDefinition), nil
+			newFeature = true
+		}
+		if err != nil {
+			return err
+		}
+
+		if current, err = leaseOf(feature); err != nil {
+			return err
+		}
+		lease = &Lease{
+			Holder:   request.Holder,
+			TTL:      request.TTL,
+			Acquired: now.Format(time.RFC3339),
+			Expires:  now.Add(time.Duration(request.TTL) * time.Second).Format(time.RFC3339),
+		}
+		if current != nil && !expired(current.Expires, now) {
+			if current.Holder != request.Holder {
+				return errLeaseHeld
+			}
+			lease.Acquired = current.Acquired
+			renewed = true
+		}
+
+		properties, err := leaseProperties(lease)
+		if err != nil {
+			return err
+		}
+		_, err = storage.AddFeature(cmd.thingID, cmd.target, feature.WithProperties(properties))
+		return err
+	})
+	if err != nil {
+		out.response = h.leaseFailed("Acquire lease failed", err, cmd, current)
+		return
+	}
+
+	if renewed {
+		out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, lease)
+	} else {
+		out.response = ResponseEnvelopeWithValue(cmd.envelope, created, lease)
+	}
+
+	switch {
+	case newFeature:
+		out.event = h.leaseEvent(cmd, protocol.ActionCreated, things.PathThingFeatureFormat, feature)
+	case current == nil:
+		out.event = h.leaseEvent(cmd, protocol.ActionCreated, things.PathThingFeaturePropertiesFormat, lease)
+	default:
+		out.event = h.leaseEvent(cmd, protocol.ActionModified, things.PathThingFeaturePropertiesFormat, lease)
+	}
+}
+
+// releaseLease handles the delete lease commands and builds the command output.
+// The lease is released if it is held by the holder of the command value or is expired, e.g. on delete commands
+// with no value issued on the lease expiry, the command fails with conflict error otherwise.
+func releaseLease(h *Handler, cmd *Command, out *CommandOutput) {
+	out.local = true
+
+	request := LeaseRequest{}
+	if len(cmd.envelope.Value) > 0 {
+		if err := commandValue(cmd.envelope, &request, out); err != nil {
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	var current *Lease
+	err := h.Storage.Batch(func(storage persistence.ThingsStorage) error {
+		feature, err := loadLease(storage, cmd.thingID, cmd.target)
+		if err != nil {
+			return err
+		}
+		if current, err = leaseOf(feature); err != nil || current == nil {
+			return err // already released
+		}
+		if current.Holder != request.Holder && !expired(current.Expires, now) {
+			return errLeaseHeld
+		}
+
+		_, err = storage.AddFeature(cmd.thingID, cmd.target, feature.WithProperties(nil))
+		return err
+	})
+	if err != nil {
+		out.response = h.leaseFailed("Release lease failed", err, cmd, current)
+		return
+	}
+
+	out.response = responseEnvelope(cmd.envelope, deleted)
+	if current != nil {
+		out.event = h.leaseEvent(cmd, protocol.ActionDeleted, things.PathThingFeaturePropertiesFormat, nil)
+	}
+}
+
+// retrieveLease handles the retrieve lease commands and builds the command output.
+// The lease state is responded, with no members if the lease is not held or is expired, i.e. an expired lease
+// is reported as released, even if the expiry has not released it yet.
+func retrieveLease(h *Handler, cmd *Command, out *CommandOutput) {
+	out.local = true
+
+	feature, err := loadLease(h.Storage, cmd.thingID, cmd.target)
+	if err != nil {
+		out.response = h.leaseFailed("Retrieve lease failed", err, cmd, nil)
+		return
+	}
+	lease, err := leaseOf(feature)
+	if err != nil {
+		out.response = h.leaseFailed("Retrieve lease failed", err, cmd, nil)
+		return
+	}
+	if lease == nil || expired(lease.Expires, time.Now()) {
+		out.response = h.retrieveResponse(cmd.envelope, map[string]interface{}{})
+		return
+	}
+	out.response = h.retrieveResponse(cmd.envelope, lease)
+}
+
+// loadLease loads the lease feature. Returns errLeaseNotFound if there is no such feature
+// and errNotLease if the feature is not a lease.
+func loadLease(storage persistence.ThingsStorage, thingID, name string) (*model.Feature, error) {
+	feature := &model.Feature{}
+	if err := storage.GetFeature(thingID, name, feature); err != nil {
+		if errors.Is(err, persistence.ErrFeatureNotFound) {
+			return nil, errors.Wrapf(errLeaseNotFound, "lease '%s' of the thing with ID '%s'", name, thingID)
+		}
+		return nil, err
+	}
+	if !isLease(feature) {
+		return nil, errors.Wrapf(errNotLease, "feature with ID '%s' of the thing with ID '%s'", name, thingID)
+	}
+	return feature, nil
+}
+
+// isLease returns true if the feature has the lease definition.
+func isLease(feature *model.Feature) bool {
+	for _, definition := range feature.Definition {
+		if definition != nil && definition.String() == LeaseDefinition {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredLease returns the state of the lease feature if the lease is held and is expired at the provided time.
+func expiredLease(feature *model.Feature, now time.Time) *Lease {
+	if !isLease(feature) {
+		return nil
+	}
+	if lease, err := leaseOf(feature); err == nil && lease != nil && expired(lease.Expires, now) {
+		return lease
+	}
+	return nil
+}
+
+// leaseOf returns the state of the lease feature, nil if the lease is not held.
+func leaseOf(feature *model.Feature) (*Lease, error) {
+	if len(feature.Properties) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(feature.Properties)
+	if err != nil {
+		return nil, err
+	}
+	lease := &Lease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		return nil, errors.Wrap(err, "invalid lease properties")
+	}
+	return lease, nil
+}
+
+func leaseProperties(lease *Lease) (map[string]interface{}, error) {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	return properties, json.Unmarshal(data, &properties)
+}
+
+// leaseFailed logs the lease command error and returns the error response, if required.
+func (h *Handler) leaseFailed(msg string, err error, cmd *Command, current *Lease) *protocol.Envelope {
+	switch {
+	case errors.Is(err, errLeaseHeld):
+		logCmdError(msg, err, cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			return NewLeaseConflictError(cmd.envelope, cmd.thingID, cmd.target, current.Holder, current.Expires)
+		}
+		return nil
+
+	case errors.Is(err, errLeaseNotFound), errors.Is(err, errNotLease):
+		logCmdError(msg, err, cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			return NewLeaseNotFoundError(cmd.envelope, cmd.thingID, cmd.target)
+		}
+		return nil
+
+	case errors.Is(err, persistence.ErrThingNotFound):
+		return h.thingNotFound(msg, err, cmd.envelope, cmd.thingID)
+
+	default:
+		return commandUnknownError(msg, err, cmd.envelope, h.Logger)
+	}
+}
+
+// leaseEvent builds the event of the lease feature change on the provided feature path.
+func (h *Handler) leaseEvent(
+	cmd *Command, action protocol.TopicAction, pathFormat string, value interface{},
+) *protocol.Envelope {
+	thing := model.Thing{}
+	if err := h.Storage.GetThingData(cmd.thingID, &thing); err != nil {
+		return nil
+	}
+
+	event := &protocol.Envelope{
+		Topic:     eventTopic(cmd.envelope.Topic, action),
+		Path:      fmt.Sprintf(pathFormat, cmd.target),
+		Revision:  thing.Revision,
+		Timestamp: thing.Timestamp,
+	}
+	if value != nil {
+		event.WithHeaders(responseHeadersWithContent(cmd.envelope.Headers)).WithValue(value)
+	} else {
+		event.WithHeaders(responseHeaders(cmd.envelope.Headers))
+	}
+	event.Headers.WithTimestampQuality(thing.TimestampQuality)
+	return event
+}
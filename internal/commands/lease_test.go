@@ -0,0 +1,228 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"container/list"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	acquireLeaseCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/leases/%s",
+		"value": {"holder": "%s", "ttl": %d}
+	}`
+	releaseLeaseCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		%s,
+		"path": "/leases/%s",
+		"value": {"holder": "%s"}
+	}`
+	retrieveLeaseCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/leases/%s"
+	}`
+
+	testLease = "updater"
+)
+
+type LeaseCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestLeaseCommandsSuite(t *testing.T) {
+	suite.Run(t, new(LeaseCommandsSuite))
+}
+
+func (s *LeaseCommandsSuite) SetupTest() {
+	s.addTestThing()
+}
+
+func (s *LeaseCommandsSuite) lease(response *protocol.Envelope) *commands.Lease {
+	lease := &commands.Lease{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, lease))
+	return lease
+}
+
+func (s *LeaseCommandsSuite) leaseFeature(featureID string) *model.Feature {
+	feature := &model.Feature{}
+	require.NoError(s.T(), s.handler.Storage.GetFeature(testThingID, featureID, feature))
+	return feature
+}
+
+func (s *LeaseCommandsSuite) TestAcquireRenewRelease() {
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testLease, "app-1", 30))
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+	acquired := s.lease(response)
+	assert.Equal(s.T(), "app-1", acquired.Holder)
+	assert.Equal(s.T(), int64(30), acquired.TTL)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionCreated, event.Topic.Action)
+	assert.Equal(s.T(), "/features/"+testLease, event.Path)
+
+	// leases are local only
+	_, err := s.handler.HonoPub.(*testPublisher).Pull()
+	assert.Error(s.T(), err)
+
+	feature := s.leaseFeature(testLease)
+	require.Len(s.T(), feature.Definition, 1)
+	assert.Equal(s.T(), commands.LeaseDefinition, feature.Definition[0].String())
+	assert.Equal(s.T(), "app-1", feature.Properties["holder"])
+
+	// held by another holder
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testLease, "app-2", 30))
+	s.assertErrorResponse(409, "things:lease.conflict")
+	assert.Empty(s.T(), s.handleCommandF(releaseLeaseCmd, defaultHeaders, testLease, "app-2"))
+	s.assertErrorResponse(409, "things:lease.conflict")
+
+	// renewed by the holder
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testLease, "app-1", 60))
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	renewed := s.lease(response)
+	assert.Equal(s.T(), acquired.Acquired, renewed.Acquired)
+	assert.Equal(s.T(), int64(60), renewed.TTL)
+	event = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionModified, event.Topic.Action)
+	assert.Equal(s.T(), "/features/"+testLease+"/properties", event.Path)
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveLeaseCmd, defaultHeaders, testLease))
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.Equal(s.T(), renewed, s.lease(response))
+
+	assert.Empty(s.T(), s.handleCommandF(releaseLeaseCmd, defaultHeaders, testLease, "app-1"))
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	event = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDeleted, event.Topic.Action)
+	assert.Equal(s.T(), "/features/"+testLease+"/properties", event.Path)
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveLeaseCmd, defaultHeaders, testLease))
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{}`, string(response.Value))
+
+	// released leases are acquired by any holder
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testLease, "app-2", 30))
+	assert.Equal(s.T(), 201, pullPublishedEnvelope(s.S()).Status)
+	event = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionCreated, event.Topic.Action)
+	assert.Equal(s.T(), "/features/"+testLease+"/properties", event.Path)
+}
+
+func (s *LeaseCommandsSuite) TestAcquireExpired() {
+	expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	s.addFeature(testLease, (&model.Feature{}).WithDefinitionFrom(commands.LeaseDefinition).
+		WithProperty("holder", "app-1").
+		WithProperty("ttl", 30).
+		WithProperty("acquired", expired).
+		WithProperty("expires", expired))
+
+	// reported as released, though not released by the expiry yet
+	assert.Empty(s.T(), s.handleCommandF(retrieveLeaseCmd, defaultHeaders, testLease))
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{}`, string(response.Value))
+
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testLease, "app-2", 30))
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+	assert.Equal(s.T(), "app-2", s.lease(response).Holder)
+	assert.NotEqual(s.T(), expired, s.lease(response).Acquired)
+}
+
+func (s *LeaseCommandsSuite) TestLeaseInvalid() {
+	assert.Empty(s.T(), s.handleCommandF(retrieveLeaseCmd, defaultHeaders, testLease))
+	s.assertErrorResponse(404, "things:lease.notfound")
+
+	s.handleCommandCheckErrorF(acquireLeaseCmd, defaultHeaders, testLease, "", 30)
+	assert.Equal(s.T(), 400, pullPublishedEnvelope(s.S()).Status)
+	s.handleCommandCheckErrorF(acquireLeaseCmd, defaultHeaders, testLease, "app-1", 0)
+	assert.Equal(s.T(), 400, pullPublishedEnvelope(s.S()).Status)
+
+	// not a lease feature
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 1))
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, defaultHeaders, testFeatureID, "app-1", 30))
+	s.assertErrorResponse(404, "things:lease.notfound")
+
+	assert.Equal(s.T(), map[string]interface{}{"x": 1}, s.leaseFeature(testFeatureID).Properties)
+}
+
+func (s *LeaseCommandsSuite) TestExpireLease() {
+	publisher := &testPublisher{buffer: list.New()}
+	expiry := &commands.Expiry{
+		Storage:   s.handler.Storage,
+		Publisher: publisher,
+		Logger:    s.handler.Logger,
+	}
+
+	assert.Empty(s.T(), s.handleCommandF(acquireLeaseCmd, headersNoResponseRequired, testLease, "app-1", 30))
+	pullPublishedEnvelope(s.S()) // event
+
+	now := time.Now()
+	require.NoError(s.T(), expiry.Expire(now))
+	_, err := publisher.Pull()
+	assert.Error(s.T(), err)
+
+	require.NoError(s.T(), expiry.Expire(now.Add(time.Minute)))
+	msg, err := publisher.Pull()
+	require.NoError(s.T(), err)
+
+	command := protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(msg.Payload, &command))
+	assert.Equal(s.T(), "org.eclipse.kanto/test/things/twin/commands/delete", command.Topic.String())
+	assert.Equal(s.T(), "/leases/"+testLease, command.Path)
+	_, ok := command.Headers.Generic(commands.HeaderExpiry)
+	assert.True(s.T(), ok)
+
+	// the release is rejected as the lease is not expired yet
+	_, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	assertPublishedNone(s.S())
+
+	feature := s.leaseFeature(testLease)
+	assert.Equal(s.T(), "app-1", feature.Properties["holder"])
+
+	// released once expired
+	expired := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	_, err = s.handler.Storage.AddFeature(testThingID, testLease, feature.WithProperty("expires", expired))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), expiry.Expire(now))
+	msg, err = publisher.Pull()
+	require.NoError(s.T(), err)
+
+	_, err = s.handler.HandleCommand(msg)
+	require.NoError(s.T(), err)
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDeleted, event.Topic.Action)
+	assert.Equal(s.T(), "/features/"+testLease+"/properties", event.Path)
+	value, ok := event.Headers.Generic(commands.HeaderExpiry)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), expired, value)
+
+	assert.Empty(s.T(), s.leaseFeature(testLease).Properties)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+)
+
+// pendingLive contains the retrieve command routed to the live channel and its twin response,
+// used as fallback if no live response is received in time.
+type pendingLive struct {
+	command  *protocol.Envelope
+	twin     *protocol.Envelope
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// liveRegistry keeps the retrieve commands awaiting live responses by their correlation ID.
+type liveRegistry struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingLive
+}
+
+// awaitLive checks if the retrieve command is live-preferred, i.e. its 'live-channel-condition' header
+// holds for the stored thing, and if so, publishes the command on the live channel to the owning local
+// application and withholds the twin response until the live response is received or the command timeout expires.
+// On timeout, the twin response is published if the 'on-live-channel-timeout' header is 'use-twin',
+// otherwise a timeout error. The response 'channel' header indicates which channel provided the response.
+func (h *Handler) awaitLive(cmd *Command, output *CommandOutput) {
+	command := cmd.envelope
+	expression := command.Headers.LiveChannelCondition()
+	if h.LiveDisabled || len(expression) == 0 || command.Topic.Action != protocol.ActionRetrieve ||
+		command.Topic.Namespace == protocol.TopicPlaceholder ||
+		command.Topic.EntityID == protocol.TopicPlaceholder ||
+		output.response == nil || output.response.Status != ok {
+		return
+	}
+
+	condition, err := rql.Parse(expression)
+	if err != nil {
+		logCmdError("Invalid command live channel condition", err, command, h.Logger)
+		output.response = NewConditionInvalidError(command, err)
+		return
+	}
+
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		return
+	}
+	value, err := thingValue(&thing)
+	if err != nil {
+		return
+	}
+
+	correlationID := command.Headers.CorrelationID()
+	if len(correlationID) == 0 || !condition.Evaluate(value) {
+		output.response.Headers.WithChannel(protocol.ChannelTwin).WithLiveChannelConditionMatched(false)
+		return
+	}
+
+	state := h.shared()
+	state.live.mutex.Lock()
+	defer state.live.mutex.Unlock()
+
+	if state.live.pending == nil {
+		state.live.pending = make(map[string]*pendingLive)
+	}
+	if _, ok := state.live.pending[correlationID]; ok {
+		return // already awaiting live response with the same correlation ID, respond with twin immediately
+	}
+
+	state.live.pending[correlationID] = &pendingLive{
+		command:  command,
+		twin:     output.response,
+		deadline: time.Now().Add(command.Headers.Timeout()),
+		timer: time.AfterFunc(command.Headers.Timeout(), func() {
+			h.liveTimeout(correlationID)
+		}),
+	}
+
+	liveTopic := *command.Topic
+	liveTopic.Channel = protocol.ChannelLive
+	publishEvent(h, &protocol.Envelope{
+		Topic: &liveTopic,
+		Headers: command.Headers.Clone().
+			WithLiveChannelCondition("").
+			WithOnLiveChannelTimeout("").
+			WithResponseRequired(true),
+		Path:   command.Path,
+		Fields: command.Fields,
+	})
+
+	output.response = nil
+	output.live = true
+}
+
+// liveResponseReceived publishes the live response of a retrieve command routed to the live channel.
+// Returns false if there is no command awaiting the response, i.e. it is not consumed.
+func (h *Handler) liveResponseReceived(response *protocol.Envelope) bool {
+	if response.Status == 0 {
+		return false // live command, not response
+	}
+	correlationID := response.Headers.CorrelationID()
+
+	state := h.shared()
+	state.live.mutex.Lock()
+	defer state.live.mutex.Unlock()
+
+	pending, found := state.live.pending[correlationID]
+	if !found {
+		return false
+	}
+	pending.timer.Stop()
+	delete(state.live.pending, correlationID)
+
+	liveResponse := *pending.twin
+	liveResponse.Status = response.Status
+	liveResponse.Value = response.Value
+	liveResponse.Headers = pending.twin.Headers.Clone().
+		WithChannel(protocol.ChannelLive).
+		WithLiveChannelConditionMatched(true).
+		WithETag("")
+	if response.Status == ok {
+		liveResponse.Headers.WithETag(contentETag(response.Value))
+	}
+	publishResponse(h, &liveResponse)
+	return true
+}
+
+func (h *Handler) liveTimeout(correlationID string) {
+	state := h.shared()
+	state.live.mutex.Lock()
+	defer state.live.mutex.Unlock()
+
+	pending, ok := state.live.pending[correlationID]
+	if !ok {
+		return
+	}
+	delete(state.live.pending, correlationID)
+	h.Logger.Debugf("Live response of command with correlation ID '%s' timed out", correlationID)
+
+	response := pending.twin
+	if pending.command.Headers.OnLiveChannelTimeout() == protocol.LiveChannelTimeoutUseTwin {
+		response.Headers.WithChannel(protocol.ChannelTwin)
+	} else {
+		response = NewLiveTimeoutError(pending.command)
+		response.Headers.WithChannel(protocol.ChannelLive)
+	}
+	response.Headers.WithLiveChannelConditionMatched(true)
+	publishResponse(h, response)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	liveRetrievePropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/live",
+			"live-channel-condition": "%s",
+			"on-live-channel-timeout": "%s",
+			"timeout": "%s"
+		},
+		"path": "/features/meter/properties/x"
+	}`
+
+	liveRetrievePropertyResponse = `{
+		"topic": "org.eclipse.kanto/test/things/live/commands/retrieve",
+		"headers": {"correlation-id": "%s"},
+		"path": "/features/meter/properties/x",
+		"value": 42,
+		"status": 200
+	}`
+
+	liveConditionMet = "exists(features/meter)"
+)
+
+type LiveCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestLiveCommandsSuite(t *testing.T) {
+	suite.Run(t, new(LiveCommandsSuite))
+}
+
+func (s *LiveCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+}
+
+func (s *LiveCommandsSuite) TestLiveResponse() {
+	msgs := s.handleCommandF(liveRetrievePropertyCmd, liveConditionMet, "", "1s")
+	assert.Empty(s.T(), msgs)
+
+	live := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ChannelLive, live.Topic.Channel)
+	assert.Equal(s.T(), protocol.ActionRetrieve, live.Topic.Action)
+	assert.Equal(s.T(), "/features/meter/properties/x", live.Path)
+	assert.Empty(s.T(), live.Headers.LiveChannelCondition())
+	assertPublishedNone(s.S())
+
+	msgs = s.handleCommandF(liveRetrievePropertyResponse, "test/local-digital-twins/live")
+	assert.Empty(s.T(), msgs)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ChannelTwin, response.Topic.Channel)
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), "42", string(response.Value))
+	assert.Equal(s.T(), protocol.ChannelLive, response.Headers.Channel())
+	assert.True(s.T(), response.Headers.LiveChannelConditionMatched())
+	assertPublishedNone(s.S())
+
+	// late live response is not consumed
+	msgs = s.handleCommandF(liveRetrievePropertyResponse, "test/local-digital-twins/live")
+	assert.Equal(s.T(), 1, len(msgs))
+}
+
+func (s *LiveCommandsSuite) TestLiveSuspendResumePending() {
+	s.handleCommandF(liveRetrievePropertyCmd, liveConditionMet, "", "10s")
+	pullPublishedEnvelope(s.S()) // live command
+
+	suspended := s.handler.SuspendPending()
+	require.Equal(s.T(), 1, len(suspended))
+	assert.Equal(s.T(), commands.PendingLive, suspended[0].Kind)
+
+	// not awaited while suspended
+	msgs := s.handleCommandF(liveRetrievePropertyResponse, "test/local-digital-twins/live")
+	assert.Equal(s.T(), 1, len(msgs))
+
+	resumed, expired := s.handler.ResumePending(persistedPending(s.T(), suspended))
+	assert.Equal(s.T(), 1, resumed)
+	assert.Equal(s.T(), 0, expired)
+
+	msgs = s.handleCommandF(liveRetrievePropertyResponse, "test/local-digital-twins/live")
+	assert.Empty(s.T(), msgs)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), "42", string(response.Value))
+	assert.Equal(s.T(), protocol.ChannelLive, response.Headers.Channel())
+}
+
+func (s *LiveCommandsSuite) TestLiveTimeoutUseTwin() {
+	s.handleCommandF(liveRetrievePropertyCmd, liveConditionMet, protocol.LiveChannelTimeoutUseTwin, "50ms")
+	pullPublishedEnvelope(s.S()) // live command
+
+	response := s.pullLiveTimeoutResponse()
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), "10", string(response.Value))
+	assert.Equal(s.T(), protocol.ChannelTwin, response.Headers.Channel())
+	assert.True(s.T(), response.Headers.LiveChannelConditionMatched())
+}
+
+func (s *LiveCommandsSuite) TestLiveTimeoutFail() {
+	s.handleCommandF(liveRetrievePropertyCmd, liveConditionMet, "", "50ms")
+	pullPublishedEnvelope(s.S()) // live command
+
+	response := s.pullLiveTimeoutResponse()
+	assert.Equal(s.T(), 408, response.Status)
+	assert.Equal(s.T(), protocol.CriterionErrors, response.Topic.Criterion)
+	assert.Equal(s.T(), protocol.ChannelLive, response.Headers.Channel())
+}
+
+func (s *LiveCommandsSuite) TestLiveConditionNotMet() {
+	s.handleCommandF(liveRetrievePropertyCmd, "exists(features/unknown)", "", "1s")
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), "10", string(response.Value))
+	assert.Equal(s.T(), protocol.ChannelTwin, response.Headers.Channel())
+	assert.False(s.T(), response.Headers.LiveChannelConditionMatched())
+	assertPublishedNone(s.S())
+}
+
+func (s *LiveCommandsSuite) TestLiveConditionInvalid() {
+	s.handleCommandF(liveRetrievePropertyCmd, "exists(", "", "1s")
+	s.assertErrorResponse(400, "things:condition.invalid")
+	assertPublishedNone(s.S())
+}
+
+func (s *LiveCommandsSuite) pullLiveTimeoutResponse() *protocol.Envelope {
+	pub := s.handler.MosquittoPub.(*testPublisher)
+	var response *protocol.Envelope
+	assert.Eventually(s.T(), func() bool {
+		msg, err := pub.Pull()
+		if err != nil {
+			return false
+		}
+		response = &protocol.Envelope{}
+		return json.Unmarshal(msg.Payload, response) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NotNil(s.T(), response)
+	return response
+}
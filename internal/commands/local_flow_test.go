@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package commands_test
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/testutil"
+	"github.com/eclipse-kanto/suite-connector/connector"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	sctestutil "github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const flowDBLocation = "things_flow_test.db"
+
+func TestLocalCommandFlow(t *testing.T) {
+	broker, err := testutil.NewBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	log := sctestutil.NewLogger("flow", logger.DEBUG, t)
+
+	twinConn, err := broker.Connect(t)
+	require.NoError(t, err)
+	defer twinConn.Disconnect()
+
+	db, err := persistence.NewThingsDB(flowDBLocation, testThingID)
+	require.NoError(t, err)
+	defer os.Remove(flowDBLocation)
+	defer db.Close()
+
+	handler := &commands.Handler{
+		DeviceInfo: commands.DeviceInfo{
+			DeviceID: testThingID,
+			TenantID: testThingID,
+		},
+		MosquittoPub: connector.NewPublisher(twinConn, connector.QosAtLeastOnce, log, nil),
+		HonoPub:      &testPublisher{buffer: list.New()},
+		Storage:      db,
+		Logger:       log,
+	}
+
+	router, err := message.NewRouter(message.RouterConfig{}, log)
+	require.NoError(t, err)
+	router.AddNoPublisherHandler("events_bus", "e/#",
+		connector.NewSubscriber(twinConn, connector.QosAtLeastOnce, false, log, nil),
+		func(msg *message.Message) error {
+			_, err := handler.HandleCommand(msg)
+			return err
+		})
+	go router.Run(context.Background())
+	defer router.Close()
+	<-router.Running()
+
+	appConn, err := broker.Connect(t)
+	require.NoError(t, err)
+	defer appConn.Disconnect()
+
+	appSub := connector.NewSubscriber(appConn, connector.QosAtLeastOnce, false, log, nil)
+	defer appSub.Close()
+	received, err := appSub.Subscribe(context.Background(), "command///req/#")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return broker.Subscribed("e") && broker.Subscribed("command///req//created")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	appPub := connector.NewPublisher(appConn, connector.QosAtLeastOnce, log, nil)
+	defer appPub.Close()
+	require.NoError(t, appPub.Publish("e", message.NewMessage(watermill.NewUUID(), []byte(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/create",
+		"headers": {"correlation-id": "test/local-digital-twins/flow"},
+		"path": "/",
+		"value": {"thingId": "org.eclipse.kanto:test"}
+	}`))))
+
+	response := receiveEnvelope(t, received)
+	assert.Equal(t, 201, response.Status)
+	assert.Equal(t, "test/local-digital-twins/flow", response.Headers.CorrelationID())
+
+	event := receiveEnvelope(t, received)
+	assert.Equal(t, protocol.CriterionEvents, event.Topic.Criterion)
+	assert.Equal(t, protocol.ActionCreated, event.Topic.Action)
+
+	ids, err := db.GetThingIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{testThingID}, ids)
+}
+
+func receiveEnvelope(t *testing.T, received <-chan *message.Message) *protocol.Envelope {
+	select {
+	case msg := <-received:
+		msg.Ack()
+		env := &protocol.Envelope{}
+		require.NoError(t, json.Unmarshal(msg.Payload, env))
+		return env
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no message received")
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const mergeCmd = `{
+	"topic": "org.eclipse.kanto/test/things/twin/commands/%s",
+	"headers": {"correlation-id": "test/local-digital-twins/merge"},
+	"path": "%s",
+	"value": %s
+}`
+
+type MergeCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestMergeCommandsSuite(t *testing.T) {
+	suite.Run(t, new(MergeCommandsSuite))
+}
+
+func (s *MergeCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).
+		WithProperties(map[string]interface{}{"x": 1, "status": map[string]interface{}{"a": 1, "b": 2}}).
+		WithDesiredProperties(map[string]interface{}{"x": 2}))
+}
+
+func (s *MergeCommandsSuite) TestMergeNullDeletes() {
+	tests := []struct {
+		path     string
+		value    string
+		expected string
+	}{
+		{"/features/meter", `{"properties": {"x": null}, "desiredProperties": null}`,
+			`{"properties": {"status": {"a": 1, "b": 2}}}`},
+		{"/features/meter/properties", `{"x": null, "status": {"a": null, "c": 3}}`,
+			`{"properties": {"status": {"b": 2, "c": 3}}, "desiredProperties": {"x": 2}}`},
+		{"/features/meter/properties/status/a", `null`,
+			`{"properties": {"x": 1, "status": {"b": 2}}, "desiredProperties": {"x": 2}}`},
+		{"/features/meter/desiredProperties", `{"x": null}`,
+			`{"properties": {"x": 1, "status": {"a": 1, "b": 2}}}`},
+		{"/features/meter/desiredProperties/x", `null`,
+			`{"properties": {"x": 1, "status": {"a": 1, "b": 2}}}`},
+		{"/features/meter/desiredProperties/y", `{"z": null}`,
+			`{"properties": {"x": 1, "status": {"a": 1, "b": 2}}, "desiredProperties": {"x": 2, "y": {}}}`},
+	}
+
+	for _, test := range tests {
+		s.handleCommandF(mergeCmd, protocol.ActionMerge, test.path, test.value)
+		response := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), 204, response.Status, test.path)
+
+		event := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), protocol.ActionMerged, event.Topic.Action)
+		assert.Equal(s.T(), test.path, event.Path)
+		assert.JSONEq(s.T(), test.value, string(event.Value))
+		assertPublishedNone(s.S())
+
+		feature := model.Feature{}
+		s.getFeature(testFeatureID, &feature)
+		s.assertFeatureJSON(test.expected, &feature, test.path)
+
+		// forwarded to the hub and marked as synchronized
+		assert.Equal(s.T(), 1, s.handler.HonoPub.(*testPublisher).buffer.Len())
+		s.handler.HonoPub.(*testPublisher).buffer.Init()
+		sysData, err := s.handler.Storage.GetSystemThingData(testThingID)
+		require.NoError(s.T(), err)
+		assert.NotContains(s.T(), sysData.UnsynchronizedFeatures, testFeatureID)
+
+		s.deleteThing()
+		s.SetupTest()
+	}
+}
+
+func (s *MergeCommandsSuite) TestMergeFeatureCreated() {
+	s.handleCommandF(mergeCmd, protocol.ActionMerge, "/features/door", `{"properties": {"open": true, "x": null}}`)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // merged event
+
+	feature := model.Feature{}
+	require.NoError(s.T(), s.handler.Storage.GetFeature(testThingID, "door", &feature))
+	s.assertFeatureJSON(`{"properties": {"open": true}}`, &feature, "door")
+}
+
+func (s *MergeCommandsSuite) TestMergeErrors() {
+	s.handleCommandF(mergeCmd, protocol.ActionMerge, "/features/door/properties/x", `null`)
+	s.assertErrorResponse(404, "things:feature.notfound")
+
+	s.handleCommandCheckErrorF(mergeCmd, protocol.ActionMerge, "/features/meter", `{"definition": "invalid"}`)
+	s.assertErrorResponse(400, "json.invalid")
+}
+
+func (s *MergeCommandsSuite) TestModifyPropertiesNullDeletes() {
+	s.handleCommandF(mergeCmd, protocol.ActionModify, "/features/meter/properties",
+		`{"x": null, "status": {"a": null, "b": 3}}`)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionModified, event.Topic.Action)
+	assert.JSONEq(s.T(), `{"status": {"b": 3}}`, string(event.Value))
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	s.assertFeatureJSON(`{"properties": {"status": {"b": 3}}, "desiredProperties": {"x": 2}}`, &feature, "modify")
+}
+
+func (s *MergeCommandsSuite) assertFeatureJSON(expected string, feature *model.Feature, msg string) {
+	data, err := json.Marshal(feature)
+	require.NoError(s.T(), err)
+	assert.JSONEq(s.T(), expected, string(data), msg)
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+const (
+	segmentFeatures = "features"
+
+	fieldCreated  = "_created"
+	fieldModified = "_modified"
+	fieldRevision = "_revision"
+	fieldMetadata = "_metadata"
+)
+
+// thingWithSpecialFields is the thing representation used on retrieve with field selector,
+// i.e. the thing and features metadata is provided as '_metadata' field, the creation and last modification
+// timestamps of the thing and of its features as '_created' and '_modified' fields and the revisions
+// of their last modification as '_revision' field, if selected.
+type thingWithSpecialFields struct {
+	model.Thing
+	Features map[string]*featureWithSpecialFields `json:"features,omitempty"`
+	Metadata interface{}                          `json:"_metadata,omitempty"`
+	Created  string                               `json:"_created,omitempty"`
+	Modified string                               `json:"_modified,omitempty"`
+	Revision int64                                `json:"_revision,omitempty"`
+}
+
+// featureWithSpecialFields is the feature representation used on retrieve with field selector,
+// i.e. the creation and last modification timestamps are provided as '_created' and '_modified' fields,
+// the thing revision of the last modification as '_revision' field and on feature retrieve the feature metadata,
+// parallel to its properties, as '_metadata' field, if selected.
+type featureWithSpecialFields struct {
+	model.Feature
+	Metadata map[string]interface{} `json:"_metadata,omitempty"`
+	Created  string                 `json:"_created,omitempty"`
+	Modified string                 `json:"_modified,omitempty"`
+	Revision int64                  `json:"_revision,omitempty"`
+}
+
+// thingSpecialFields returns the thing representation with the special fields. The timestamps and revisions
+// of the features are provided only if explicitly selected, e.g. 'features/meter/_modified',
+// not to be part of the selected features.
+func (h *Handler) thingSpecialFields(thing *model.Thing, fields string) *thingWithSpecialFields {
+	value := &thingWithSpecialFields{Thing: *thing, Metadata: metadataValue(thing)}
+	if thing.Features != nil {
+		value.Features = make(map[string]*featureWithSpecialFields, len(thing.Features))
+		for featureID, feature := range thing.Features {
+			if feature != nil {
+				value.Features[featureID] = &featureWithSpecialFields{Feature: *feature}
+			}
+		}
+	}
+
+	timestamps := h.selectedTimestamps(thing.ID.String(), fields)
+	if timestamps == nil {
+		return value
+	}
+	value.Created = timestamps.Created
+	value.Modified = timestamps.Modified
+	value.Revision = timestamps.Revision
+
+	pointers, _ := jsonutil.SelectorToJSONPointers(fields)
+	for featureID, feature := range value.Features {
+		prefix := "/" + segmentFeatures + "/" + featureID + "/"
+		for _, pointer := range pointers {
+			if pointer == prefix+fieldCreated || pointer == prefix+fieldModified || pointer == prefix+fieldRevision {
+				feature.Created = timestamps.Features[featureID].Created
+				feature.Modified = timestamps.Features[featureID].Modified
+				feature.Revision = timestamps.Features[featureID].Revision
+			}
+		}
+	}
+	return value
+}
+
+// featureSpecialFields returns the feature representation with the special fields, if selected,
+// or the feature itself.
+func (h *Handler) featureSpecialFields(thingID, featureID string, feature *model.Feature, fields string) interface{} {
+	var metadata map[string]interface{}
+	if strings.Contains(fields, fieldMetadata) {
+		metadata = feature.Metadata
+	}
+	timestamps := h.selectedTimestamps(thingID, fields)
+	if timestamps == nil && len(metadata) == 0 {
+		return feature
+	}
+
+	value := &featureWithSpecialFields{Feature: *feature, Metadata: metadata}
+	if timestamps != nil {
+		value.Created = timestamps.Features[featureID].Created
+		value.Modified = timestamps.Features[featureID].Modified
+		value.Revision = timestamps.Features[featureID].Revision
+	}
+	return value
+}
+
+// selectedTimestamps returns the stored thing timestamps if the timestamps or revision special fields are selected,
+// nil otherwise.
+func (h *Handler) selectedTimestamps(thingID string, fields string) *persistence.ThingTimestamps {
+	if !strings.Contains(fields, fieldCreated) && !strings.Contains(fields, fieldModified) &&
+		!strings.Contains(fields, fieldRevision) {
+		return nil
+	}
+	timestamps, err := h.Storage.GetTimestamps(thingID)
+	if err != nil {
+		return nil
+	}
+	return timestamps
+}
+
+// featureRevisionHeaders adds the thing revision and the timestamp of the last modification of the feature
+// the command is on, e.g. '/features/meter/properties/x', to the command event and successful retrieve response,
+// if enabled. The headers are not added if the feature is deleted.
+func (h *Handler) featureRevisionHeaders(cmd *Command, out *CommandOutput) {
+	if !h.FeatureRevisions {
+		return
+	}
+	segments := strings.SplitN(strings.Trim(cmd.envelope.Path, "/"), "/", 3)
+	if len(segments) < 2 || segments[0] != segmentFeatures {
+		return
+	}
+
+	var envelopes []*protocol.Envelope
+	if out.event != nil {
+		envelopes = append(envelopes, out.event)
+	}
+	if out.response != nil && out.response.Status == ok && cmd.envelope.Topic.Action == protocol.ActionRetrieve {
+		envelopes = append(envelopes, out.response)
+	}
+	if len(envelopes) == 0 {
+		return
+	}
+
+	timestamps, err := h.Storage.GetFeatureTimestamps(cmd.thingID, segments[1])
+	if err != nil {
+		return
+	}
+	for _, env := range envelopes {
+		if env.Headers == nil {
+			env.Headers = protocol.NewHeaders()
+		}
+		env.Headers.WithFeatureRevision(timestamps.Revision).WithFeatureModified(timestamps.Modified)
+	}
+}
+
+// putMetadata applies the command 'put-metadata' header entries to the thing and features metadata
+// once the command is successfully performed. The entry keys are relative to the command path,
+// e.g. 'x/issuedBy' for command with path '/features/meter/properties', or absolute if starting with '/'.
+// Metadata of deleted resources is not updated.
+func (h *Handler) putMetadata(cmd *Command, out *CommandOutput) {
+	entries := cmd.envelope.Headers.PutMetadata()
+	if len(entries) == 0 || len(out.thingID) == 0 || cmd.envelope.Topic.Action == protocol.ActionDelete {
+		return
+	}
+
+	thingMetadata := map[string]interface{}{}
+	featuresMetadata := map[string]map[string]interface{}{}
+	for _, entry := range entries {
+		segments := metadataPath(cmd.envelope.Path, entry.Key)
+		if len(segments) > 1 && segments[0] == segmentFeatures {
+			featureID := segments[1]
+			if featuresMetadata[featureID] == nil {
+				featuresMetadata[featureID] = map[string]interface{}{}
+			}
+			putMetadataValue(featuresMetadata[featureID], segments[2:], entry.Value)
+		} else {
+			putMetadataValue(thingMetadata, segments, entry.Value)
+		}
+	}
+
+	if len(thingMetadata) > 0 {
+		if err := h.Storage.UpdateMetadata(out.thingID, noValue, thingMetadata); err != nil {
+			logCmdError("Unable to put thing metadata", err, cmd.envelope, h.Logger)
+		}
+	}
+	for featureID, metadata := range featuresMetadata {
+		if len(metadata) == 0 {
+			continue
+		}
+		if err := h.Storage.UpdateMetadata(out.thingID, featureID, metadata); err != nil {
+			logCmdError("Unable to put feature metadata", err, cmd.envelope, h.Logger)
+		}
+	}
+}
+
+// metadataPath returns the path segments of the metadata key resolved against the command path.
+func metadataPath(cmdPath string, key string) []string {
+	path := key
+	if !strings.HasPrefix(key, "/") {
+		path = cmdPath + "/" + key
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// putMetadataValue sets the value into the metadata nested object defined by the path segments.
+// Root level value is merged into the metadata if it is an object.
+func putMetadataValue(metadata map[string]interface{}, segments []string, value interface{}) {
+	if len(segments) == 0 {
+		if values, ok := value.(map[string]interface{}); ok {
+			for key, value := range values {
+				metadata[key] = value
+			}
+		}
+		return
+	}
+
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := metadata[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			metadata[segment] = next
+		}
+		metadata = next
+	}
+	metadata[segments[len(segments)-1]] = value
+}
+
+// metadataValue returns the Ditto representation of the thing metadata, i.e. the thing level metadata
+// and the features metadata under 'features/<featureID>', or nil if no metadata is available.
+func metadataValue(thing *model.Thing) map[string]interface{} {
+	value := map[string]interface{}{}
+	for key, metadata := range thing.Metadata {
+		value[key] = metadata
+	}
+
+	features := map[string]interface{}{}
+	for featureID, feature := range thing.Features {
+		if feature != nil && len(feature.Metadata) > 0 {
+			features[featureID] = feature.Metadata
+		}
+	}
+	if len(features) > 0 {
+		value[segmentFeatures] = features
+	}
+
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
+
+// keepMetadata copies the thing and features metadata from the current thing into the updated one,
+// as metadata is not part of the thing JSON representation.
+func keepMetadata(current *model.Thing, updated *model.Thing) {
+	updated.Metadata = current.Metadata
+	keepFeaturesMetadata(current.Features, updated.Features)
+}
+
+func keepFeaturesMetadata(current map[string]*model.Feature, updated map[string]*model.Feature) {
+	for featureID, feature := range updated {
+		if prev, ok := current[featureID]; ok && prev != nil && feature != nil {
+			feature.Metadata = prev.Metadata
+		}
+	}
+}
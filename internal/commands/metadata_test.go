@@ -0,0 +1,294 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	putMetadataModifyPropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/metadata",
+			"put-metadata": %s
+		},
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}`
+
+	putMetadataMergeThingCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/metadata",
+			"put-metadata": [{"key": "attributes/location/issuedBy", "value": "merge"}]
+		},
+		"path": "/",
+		"value": {"attributes": {"location": "lab"}}
+	}`
+
+	retrieveMetadataCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/",
+		"fields": "%s"
+	}`
+)
+
+type MetadataCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestMetadataCommandsSuite(t *testing.T) {
+	suite.Run(t, new(MetadataCommandsSuite))
+}
+
+func (s *MetadataCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+}
+
+func (s *MetadataCommandsSuite) TestPutMetadata() {
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[
+		{"key": "issuedBy", "value": {"name": "kanto"}},
+		{"key": "/attributes/owner", "value": "local"}
+	]`)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), map[string]interface{}{
+		"attributes": map[string]interface{}{"owner": "local"},
+	}, thing.Metadata)
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{
+			"x": map[string]interface{}{"issuedBy": map[string]interface{}{"name": "kanto"}},
+		},
+	}, thing.Features[testFeatureID].Metadata)
+	assert.EqualValues(s.T(), 20, thing.Features[testFeatureID].Properties["x"])
+}
+
+func (s *MetadataCommandsSuite) TestMetadataKeptOnMerge() {
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[{"key": "issuedBy", "value": "modify"}]`)
+	pullPublishedEnvelope(s.S()) // response
+	pullPublishedEnvelope(s.S()) // modified event
+
+	s.handleCommand(putMetadataMergeThingCmd)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // merged event
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"location": map[string]interface{}{"issuedBy": "merge"},
+		},
+	}, thing.Metadata)
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{
+			"x": map[string]interface{}{"issuedBy": "modify"},
+		},
+	}, thing.Features[testFeatureID].Metadata)
+}
+
+func (s *MetadataCommandsSuite) TestRetrieveMetadata() {
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[{"key": "issuedBy", "value": "modify"}]`)
+	pullPublishedEnvelope(s.S()) // response
+	pullPublishedEnvelope(s.S()) // modified event
+
+	s.handleCommandF(retrieveMetadataCmd, "_metadata/features/meter,features/meter/properties")
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{
+		"thingId": null,
+		"features": {"meter": {"properties": {"x": 20}}},
+		"_metadata": {"features": {"meter": {"properties": {"x": {"issuedBy": "modify"}}}}}
+	}`, string(response.Value))
+
+	s.handleCommandF(retrieveMetadataCmd, "thingId")
+	response = pullPublishedEnvelope(s.S())
+	assert.JSONEq(s.T(), `{"thingId": "org.eclipse.kanto:test"}`, string(response.Value))
+}
+
+func (s *MetadataCommandsSuite) TestRetrieveFeatureMetadata() {
+	retrieveFeatureMetadataCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/features/meter",
+		"fields": "%s"
+	}`
+
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[{"key": "issuedBy", "value": "modify"}]`)
+	pullPublishedEnvelope(s.S()) // response
+	pullPublishedEnvelope(s.S()) // modified event
+
+	s.handleCommandF(retrieveFeatureMetadataCmd, "properties,_metadata/properties/x")
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{
+		"properties": {"x": 20},
+		"_metadata": {"properties": {"x": {"issuedBy": "modify"}}}
+	}`, string(response.Value))
+
+	s.handleCommandF(retrieveFeatureMetadataCmd, "properties")
+	response = pullPublishedEnvelope(s.S())
+	assert.JSONEq(s.T(), `{"properties": {"x": 20}}`, string(response.Value))
+}
+
+func (s *MetadataCommandsSuite) TestMetadataRemovedWithProperty() {
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[{"key": "issuedBy", "value": "modify"}]`)
+	pullPublishedEnvelope(s.S()) // response
+	pullPublishedEnvelope(s.S()) // modified event
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/metadata",
+			"put-metadata": [{"key": "y/issuedBy", "value": "other"}]
+		},
+		"path": "/features/meter/properties",
+		"value": {"y": 1}
+	}`)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{
+			"y": map[string]interface{}{"issuedBy": "other"},
+		},
+	}, feature.Metadata)
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/features/meter/properties/y"
+	}`)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // deleted event
+
+	s.getFeature(testFeatureID, &feature)
+	assert.Nil(s.T(), feature.Metadata)
+}
+
+func (s *MetadataCommandsSuite) TestRetrieveTimestamps() {
+	s.handleCommandF(retrieveMetadataCmd, "_created,_modified,features/meter/_modified")
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+
+	value := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &value))
+	assert.NotEmpty(s.T(), value["_created"])
+	assert.NotEmpty(s.T(), value["_modified"])
+	feature := value["features"].(map[string]interface{})[testFeatureID].(map[string]interface{})
+	assert.Equal(s.T(), value["_modified"], feature["_modified"])
+	assert.NotContains(s.T(), feature, "_created")
+
+	s.handleCommandF(retrieveMetadataCmd, "features")
+	response = pullPublishedEnvelope(s.S())
+	assert.NotContains(s.T(), string(response.Value), "_created")
+	assert.NotContains(s.T(), string(response.Value), "_modified")
+}
+
+func (s *MetadataCommandsSuite) TestRetrieveRevisions() {
+	s.handleCommandF(retrieveMetadataCmd, "_revision,features/meter/_revision")
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+
+	value := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &value))
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.EqualValues(s.T(), thing.Revision, value["_revision"])
+	// the feature is added after the thing
+	feature := value["features"].(map[string]interface{})[testFeatureID].(map[string]interface{})
+	assert.Equal(s.T(), value["_revision"], feature["_revision"])
+	assert.NotContains(s.T(), feature, "properties")
+}
+
+func (s *MetadataCommandsSuite) TestFeatureRevisionHeaders() {
+	s.handler.FeatureRevisions = true
+	defer func() {
+		s.handler.FeatureRevisions = false
+	}()
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}`)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 204, response.Status)
+	assert.Zero(s.T(), response.Headers.FeatureRevision())
+	event := pullPublishedEnvelope(s.S())
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), thing.Revision, event.Headers.FeatureRevision())
+	assert.Equal(s.T(), thing.Timestamp, event.Headers.FeatureModified())
+
+	// the thing modification keeps the feature revision
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/",
+		"value": {"attributes": {"location": "lab"}}
+	}`)
+	pullPublishedEnvelope(s.S()) // response
+	assert.Zero(s.T(), pullPublishedEnvelope(s.S()).Headers.FeatureRevision())
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/metadata"},
+		"path": "/features/meter/properties"
+	}`)
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.Equal(s.T(), thing.Revision, response.Headers.FeatureRevision())
+	assert.Equal(s.T(), thing.Timestamp, response.Headers.FeatureModified())
+}
+
+func (s *MetadataCommandsSuite) TestPutMetadataCommandFailed() {
+	s.handleCommandF(putMetadataModifyPropertyCmd, `[{"key": "issuedBy", "value": "modify"}]`)
+	pullPublishedEnvelope(s.S()) // response
+	pullPublishedEnvelope(s.S()) // modified event
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/metadata",
+			"put-metadata": [{"key": "issuedBy", "value": "invalid"}]
+		},
+		"path": "/features/unknown/properties/x",
+		"value": 30
+	}`)
+	assert.Equal(s.T(), 404, pullPublishedEnvelope(s.S()).Status)
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Nil(s.T(), thing.Metadata)
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{
+			"x": map[string]interface{}{"issuedBy": "modify"},
+		},
+	}, thing.Features[testFeatureID].Metadata)
+}
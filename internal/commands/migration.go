@@ -0,0 +1,234 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+	"github.com/pkg/errors"
+)
+
+const (
+	headerDryRun = "dry-run"
+
+	patchConditionPrefix = "thing:"
+
+	memberDefinition        = "definition"
+	memberProperties        = "properties"
+	memberDesiredProperties = "desiredProperties"
+
+	mergeStatusApplied = "APPLIED"
+	mergeStatusDryRun  = "DRY_RUN"
+)
+
+// migrateDefinitionValue is the migrate thing definition command value.
+type migrateDefinitionValue struct {
+	ThingDefinitionURL                      string                 `json:"thingDefinitionUrl"`
+	MigrationPayload                        map[string]interface{} `json:"migrationPayload,omitempty"`
+	PatchConditions                         map[string]string      `json:"patchConditions,omitempty"`
+	InitializeMissingPropertiesFromDefaults bool                   `json:"initializeMissingPropertiesFromDefaults,omitempty"`
+}
+
+// migrateDefinitionResult is the migrate thing definition response value.
+type migrateDefinitionResult struct {
+	ThingID     string      `json:"thingId"`
+	Patch       interface{} `json:"patch"`
+	MergeStatus string      `json:"mergeStatus"`
+}
+
+// migrateDefinition handles migrate thing definition commands and builds the command output.
+// The thing definition is updated and the migration payload is applied as JSON merge patch to the thing,
+// except for its parts with patch conditions not met by the current thing state,
+// e.g. {"thing:/features/meter": "not(exists(features/meter))"}.
+// If requested, the missing feature properties are initialized with the default values of the models
+// registered for the feature definitions. The thing is not modified if the 'dry-run' header is set.
+func migrateDefinition(h *Handler, cmd *Command, out *CommandOutput) {
+	thing, err := h.LoadThing(cmd.thingID, cmd.envelope)
+	if err != nil {
+		out.response = h.thingNotFound("Migrate thing definition failed", err, cmd.envelope, cmd.thingID)
+		return
+	}
+
+	migration := migrateDefinitionValue{}
+	if err := commandValue(cmd.envelope, &migration, out); err != nil {
+		return
+	}
+	if model.NewDefinitionIDFrom(migration.ThingDefinitionURL) == nil {
+		logCmdError("Migrate thing definition failed", errors.New("invalid thing definition"), cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewDefinitionInvalidError(cmd.envelope, migration.ThingDefinitionURL)
+		}
+		return
+	}
+
+	previous, err := thingValue(thing)
+	if err != nil {
+		out.response = commandUnknownError("Migrate thing definition failed", err, cmd.envelope, h.Logger)
+		return
+	}
+	patch, applicable := h.migrationPatch(cmd, &migration, previous, out)
+	if !applicable {
+		return
+	}
+
+	// merged in place, i.e. a copy of the previous value is needed
+	migrated, _ := thingValue(thing)
+	migrated = jsonutil.MergePatch(migrated, patch)
+	if migration.InitializeMissingPropertiesFromDefaults {
+		h.initializeDefaults(migrated)
+	}
+
+	merged := model.Thing{}
+	if err := decodeValue(migrated, &merged); err != nil {
+		out.response = commandUnknownError("Migrate thing definition failed", err, cmd.envelope, h.Logger)
+		return
+	}
+	if merged.ID == nil || merged.ID.String() != cmd.thingID {
+		out.response = NewIDNotSettableError(cmd.envelope)
+		return
+	}
+	if err := h.validateFeatures(cmd.thingID, migrated.(map[string]interface{})[segmentFeatures], false); err != nil {
+		logCmdError("Migrated thing does not conform to the feature definition", err, cmd.envelope, h.Logger)
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewPayloadValidationError(cmd.envelope, err)
+		}
+		return
+	}
+
+	applied, changed := jsonutil.MergeDiff(previous, migrated)
+	if !changed {
+		applied = map[string]interface{}{}
+	}
+	result := &migrateDefinitionResult{ThingID: cmd.thingID, Patch: applied}
+	if dryRun, _ := cmd.envelope.Headers.Generic(headerDryRun); dryRun == true {
+		result.MergeStatus = mergeStatusDryRun
+		out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, result)
+		out.local = true
+		return
+	}
+
+	keepMetadata(thing, &merged)
+	rev, err := h.Storage.AddThing(&merged)
+	if err != nil {
+		out.response = commandUnknownError("Migrate thing definition failed", err, cmd.envelope, h.Logger)
+		return
+	}
+	result.MergeStatus = mergeStatusApplied
+	out.response = withETag(ResponseEnvelopeWithValue(cmd.envelope, ok, result), revisionETag(rev))
+
+	stored := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &stored); err != nil {
+		logCmdError("Failed to create event on command execution. Unknown thing", err, cmd.envelope, h.Logger)
+	} else {
+		out.event = eventThingCreatedEnvelope(cmd.envelope, protocol.ActionDefinitionMigrated, &stored)
+	}
+	out.thingID = cmd.thingID
+	out.revision = rev
+}
+
+// migrationPatch builds the JSON merge patch of the migration, i.e. the migration payload without its parts
+// with patch conditions not met by the current thing value and with the new thing definition.
+// Returns false if a patch condition is invalid and the error response is set.
+func (h *Handler) migrationPatch(
+	cmd *Command, migration *migrateDefinitionValue, current interface{}, out *CommandOutput,
+) (map[string]interface{}, bool) {
+	patch := migration.MigrationPayload
+	if patch == nil {
+		patch = make(map[string]interface{})
+	}
+
+	for pointer, expression := range migration.PatchConditions {
+		condition, err := rql.Parse(expression)
+		if err != nil {
+			logCmdError("Invalid migration patch condition", err, cmd.envelope, h.Logger)
+			if cmd.envelope.Headers.ResponseRequired() {
+				out.response = NewConditionInvalidError(cmd.envelope, err)
+			}
+			return nil, false
+		}
+		if !condition.Evaluate(current) {
+			removePatchPart(patch, strings.TrimPrefix(pointer, patchConditionPrefix))
+		}
+	}
+
+	patch[memberDefinition] = migration.ThingDefinitionURL
+	return patch, true
+}
+
+// removePatchPart removes the patch member at the provided JSON pointer, e.g. '/features/meter', if any.
+func removePatchPart(patch map[string]interface{}, pointer string) {
+	keys := strings.Split(strings.Trim(pointer, "/"), "/")
+	parent := patch
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		parent = next
+	}
+	delete(parent, keys[len(keys)-1])
+}
+
+// initializeDefaults sets the missing properties of the thing features to the default values
+// of the models registered for the feature definitions.
+func (h *Handler) initializeDefaults(thing interface{}) {
+	thingObject, _ := thing.(map[string]interface{})
+	features, _ := thingObject[segmentFeatures].(map[string]interface{})
+	for _, value := range features {
+		feature, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var definition []string
+		if ids, ok := feature[memberDefinition].([]interface{}); ok {
+			for _, id := range ids {
+				if s, ok := id.(string); ok {
+					definition = append(definition, s)
+				}
+			}
+		}
+		if defaults := h.propertiesDefaults(definition); defaults != nil {
+			feature[memberProperties] = withDefaults(feature[memberProperties], defaults)
+		}
+	}
+}
+
+func decodeValue(value interface{}, result interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+// withDefaults returns the value with its missing members set to the provided default ones.
+func withDefaults(value interface{}, defaults interface{}) interface{} {
+	if value == nil {
+		return defaults
+	}
+	valueObject, ok := value.(map[string]interface{})
+	defaultsObject, defaultsOk := defaults.(map[string]interface{})
+	if !ok || !defaultsOk {
+		return value
+	}
+	for key, defaultValue := range defaultsObject {
+		valueObject[key] = withDefaults(valueObject[key], defaultValue)
+	}
+	return valueObject
+}
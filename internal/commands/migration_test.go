@@ -0,0 +1,199 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	meterV2Definition = "org.eclipse.kanto:Meter:2.0.0"
+	thingV2Definition = "org.eclipse.kanto:Sensor:2.0.0"
+
+	meterV2ThingModel = `{
+		"@context": ["https://www.w3.org/2022/wot/td/v1.1"],
+		"@type": "tm:ThingModel",
+		"title": "Meter",
+		"properties": {
+			"x": {"type": "integer", "minimum": 0, "default": 0},
+			"interval": {"type": "integer", "minimum": 1, "default": 10},
+			"status": {
+				"type": "object",
+				"properties": {
+					"mode": {"type": "string", "enum": ["auto", "manual"], "default": "auto"}
+				}
+			}
+		}
+	}`
+
+	migrateDefinitionCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/migrateDefinition",
+		%s,
+		"path": "/",
+		"value": %s
+	}`
+)
+
+type MigrationCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestMigrationCommandsSuite(t *testing.T) {
+	suite.Run(t, new(MigrationCommandsSuite))
+}
+
+func (s *MigrationCommandsSuite) SetupTest() {
+	dir := s.T().TempDir()
+	require.NoError(s.T(), os.WriteFile(filepath.Join(dir, "meter.tm.jsonld"), []byte(meterV2ThingModel), 0600))
+
+	models := filepath.Join(dir, "models.json")
+	require.NoError(s.T(), os.WriteFile(models, []byte(`{"`+meterV2Definition+`": "meter.tm.jsonld"}`), 0600))
+
+	validators, err := commands.LoadValidators(models)
+	require.NoError(s.T(), err)
+	s.handler.Validators = validators
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithDefinitionFrom(meterDefinition).WithProperty("x", 1))
+}
+
+func (s *MigrationCommandsSuite) TestMigrateDefinition() {
+	s.handleCommandF(migrateDefinitionCmd, defaultHeaders, `{
+		"thingDefinitionUrl": "`+thingV2Definition+`",
+		"migrationPayload": {
+			"attributes": {"model": "v2"},
+			"features": {
+				"meter": {"definition": ["`+meterV2Definition+`"]},
+				"lamp": {"properties": {"on": true}}
+			}
+		},
+		"patchConditions": {
+			"thing:/features/lamp": "exists(features/lamp)"
+		},
+		"initializeMissingPropertiesFromDefaults": true
+	}`)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	result := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &result))
+	assert.Equal(s.T(), testThingID, result["thingId"])
+	assert.Equal(s.T(), "APPLIED", result["mergeStatus"])
+	assert.Equal(s.T(), map[string]interface{}{
+		"definition": thingV2Definition,
+		"attributes": map[string]interface{}{"model": "v2"},
+		"features": map[string]interface{}{
+			"meter": map[string]interface{}{
+				"definition": []interface{}{meterV2Definition},
+				"properties": map[string]interface{}{
+					"interval": 10.0,
+					"status":   map[string]interface{}{"mode": "auto"},
+				},
+			},
+		},
+	}, result["patch"])
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDefinitionMigrated, event.Topic.Action)
+	assert.Equal(s.T(), "/", event.Path)
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), thingV2Definition, thing.DefinitionID.String())
+	assert.Equal(s.T(), "v2", thing.Attributes["model"])
+	require.Contains(s.T(), thing.Features, testFeatureID)
+	assert.NotContains(s.T(), thing.Features, "lamp")
+	meter := thing.Features[testFeatureID]
+	assert.Equal(s.T(), meterV2Definition, meter.Definition[0].String())
+	assert.EqualValues(s.T(), 1, meter.Properties["x"])
+	assert.EqualValues(s.T(), 10, meter.Properties["interval"])
+}
+
+func (s *MigrationCommandsSuite) TestMigrateDefinitionNoDefaults() {
+	s.handleCommandF(migrateDefinitionCmd, headersNoResponseRequired, `{
+		"thingDefinitionUrl": "`+thingV2Definition+`",
+		"migrationPayload": {"features": {"meter": {"definition": ["`+meterV2Definition+`"]}}}
+	}`)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionDefinitionMigrated, event.Topic.Action)
+	assertPublishedNone(s.S())
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.Equal(s.T(), map[string]interface{}{"x": 1.0}, feature.Properties)
+}
+
+func (s *MigrationCommandsSuite) TestMigrateDefinitionDryRun() {
+	s.handleCommandF(migrateDefinitionCmd, `"headers": {
+		"correlation-id": "test/local-digital-twins/commands",
+		"dry-run": true
+	}`, `{
+		"thingDefinitionUrl": "`+thingV2Definition+`",
+		"initializeMissingPropertiesFromDefaults": true
+	}`)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	result := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &result))
+	assert.Equal(s.T(), "DRY_RUN", result["mergeStatus"])
+	assert.Equal(s.T(), map[string]interface{}{"definition": thingV2Definition}, result["patch"])
+	assertPublishedNone(s.S())
+
+	_, err := s.handler.HonoPub.(*testPublisher).Pull()
+	assert.Error(s.T(), err)
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Nil(s.T(), thing.DefinitionID)
+}
+
+func (s *MigrationCommandsSuite) TestMigrateDefinitionErrors() {
+	s.handleCommandF(migrateDefinitionCmd, defaultHeaders, `{"thingDefinitionUrl": "https://models/sensor-2.0.0.tm.jsonld"}`)
+	s.assertErrorResponse(400, "things:definition.identifier.invalid")
+
+	s.handleCommandF(migrateDefinitionCmd, defaultHeaders, `{
+		"thingDefinitionUrl": "`+thingV2Definition+`",
+		"patchConditions": {"thing:/attributes": "exists("}
+	}`)
+	s.assertErrorResponse(400, "things:condition.invalid")
+
+	s.handleCommandF(migrateDefinitionCmd, defaultHeaders, `{
+		"thingDefinitionUrl": "`+thingV2Definition+`",
+		"migrationPayload": {"features": {"meter": {"definition": ["`+meterV2Definition+`"], "properties": {"x": -1}}}}
+	}`)
+	s.assertErrorResponse(400, "wot:payload.validation.error")
+
+	s.handleCommandCheckErrorF(migrateDefinitionCmd, defaultHeaders, `[]`)
+	s.assertErrorResponse(400, "json.invalid")
+	assertPublishedNone(s.S())
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Nil(s.T(), thing.DefinitionID)
+
+	s.deleteThing()
+	s.handleCommandF(migrateDefinitionCmd, defaultHeaders, `{"thingDefinitionUrl": "`+thingV2Definition+`"}`)
+	s.assertErrorResponse(404, "things:thing.notfound")
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+const multiStatus = 207
+
+// MultiStatusItem represents the outcome of a single item of a composite command,
+// e.g. a single thing of a retrieve multiple things command.
+// Contains the item status and its value on success or the error details on failure.
+type MultiStatusItem struct {
+	ID     string      `json:"id"`
+	Status int         `json:"status"`
+	Value  interface{} `json:"value,omitempty"`
+	Error  *ThingError `json:"error,omitempty"`
+}
+
+// NewMultiStatusItem creates a successfully handled item with the provided status and value.
+func NewMultiStatusItem(id string, status int, value interface{}) *MultiStatusItem {
+	return &MultiStatusItem{
+		ID:     id,
+		Status: status,
+		Value:  value,
+	}
+}
+
+// NewMultiStatusErrorItem creates a failed item with status matching the provided error status.
+func NewMultiStatusErrorItem(id string, err *ThingError) *MultiStatusItem {
+	return &MultiStatusItem{
+		ID:     id,
+		Status: err.Status,
+		Error:  err,
+	}
+}
+
+// NewMultiStatusResponse creates a 207 multi-status response enumerating the status of each command item.
+// It is used if a composite command is handled only partially, so the requester is able
+// to identify which parts of the command are applied.
+func NewMultiStatusResponse(cmdEnvelope *protocol.Envelope, items []*MultiStatusItem) *protocol.Envelope {
+	return ResponseEnvelopeWithValue(cmdEnvelope, multiStatus, items)
+}
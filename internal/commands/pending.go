@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// Kinds of the pending commands.
+const (
+	// PendingAcks is a command awaiting acknowledgements from the local subscribers.
+	PendingAcks = "acks"
+	// PendingLive is a retrieve command awaiting a live response from the owning local application.
+	PendingLive = "live"
+)
+
+// PendingCommand is a command awaiting acknowledgements or a live response, suspended on the messages router stop,
+// so that it is awaited again by the handler of the restarted router.
+type PendingCommand struct {
+	Kind    string             `json:"kind"`
+	Command *protocol.Envelope `json:"command"`
+	// Acks are the acknowledgements by their labels, nil for the ones not received yet.
+	Acks map[string]*Acknowledgement `json:"acks,omitempty"`
+	// Twin is the twin response of the retrieve command awaiting a live response.
+	Twin     *protocol.Envelope `json:"twin,omitempty"`
+	Deadline time.Time          `json:"deadline"`
+}
+
+// SuspendPending stops awaiting the commands acknowledgements and live responses and returns the pending commands.
+func (h *Handler) SuspendPending() []PendingCommand {
+	var suspended []PendingCommand
+
+	state := h.shared()
+	state.acks.mutex.Lock()
+	for correlationID, pending := range state.acks.pending {
+		pending.timer.Stop()
+		delete(state.acks.pending, correlationID)
+		suspended = append(suspended, PendingCommand{
+			Kind:     PendingAcks,
+			Command:  pending.command,
+			Acks:     pending.acks,
+			Deadline: pending.deadline,
+		})
+	}
+	state.acks.mutex.Unlock()
+
+	state.live.mutex.Lock()
+	for correlationID, pending := range state.live.pending {
+		pending.timer.Stop()
+		delete(state.live.pending, correlationID)
+		suspended = append(suspended, PendingCommand{
+			Kind:     PendingLive,
+			Command:  pending.command,
+			Twin:     pending.twin,
+			Deadline: pending.deadline,
+		})
+	}
+	state.live.mutex.Unlock()
+
+	return suspended
+}
+
+// ResumePending awaits again the commands suspended by the handler of the stopped router.
+// The commands with expired deadline are timed out immediately, i.e. their timeout responses are published.
+// Returns the numbers of the resumed and of the expired commands.
+func (h *Handler) ResumePending(suspended []PendingCommand) (resumed int, expired int) {
+	for _, pending := range suspended {
+		if pending.Command == nil || pending.Command.Topic == nil {
+			continue
+		}
+		correlationID := pending.Command.Headers.CorrelationID()
+		timeout := time.Until(pending.Deadline)
+
+		switch pending.Kind {
+		case PendingAcks:
+			if !h.resumeAcks(correlationID, pending, timeout) {
+				continue
+			}
+		case PendingLive:
+			if pending.Twin == nil || !h.resumeLive(correlationID, pending, timeout) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if timeout > 0 {
+			resumed++
+		} else {
+			expired++
+		}
+	}
+	return resumed, expired
+}
+
+func (h *Handler) resumeAcks(correlationID string, suspended PendingCommand, timeout time.Duration) bool {
+	state := h.shared()
+	state.acks.mutex.Lock()
+	defer state.acks.mutex.Unlock()
+
+	if state.acks.pending == nil {
+		state.acks.pending = make(map[string]*pendingAcks)
+	}
+	if _, ok := state.acks.pending[correlationID]; ok {
+		return false
+	}
+
+	acks := suspended.Acks
+	if acks == nil {
+		acks = make(map[string]*Acknowledgement)
+	}
+	state.acks.pending[correlationID] = &pendingAcks{
+		command:  suspended.Command,
+		acks:     acks,
+		deadline: suspended.Deadline,
+		timer: time.AfterFunc(timeout, func() {
+			h.acksTimeout(correlationID)
+		}),
+	}
+	return true
+}
+
+func (h *Handler) resumeLive(correlationID string, suspended PendingCommand, timeout time.Duration) bool {
+	state := h.shared()
+	state.live.mutex.Lock()
+	defer state.live.mutex.Unlock()
+
+	if state.live.pending == nil {
+		state.live.pending = make(map[string]*pendingLive)
+	}
+	if _, ok := state.live.pending[correlationID]; ok {
+		return false
+	}
+
+	state.live.pending[correlationID] = &pendingLive{
+		command:  suspended.Command,
+		twin:     suspended.Twin,
+		deadline: suspended.Deadline,
+		timer: time.AfterFunc(timeout, func() {
+			h.liveTimeout(correlationID)
+		}),
+	}
+	return true
+}
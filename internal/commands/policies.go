@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+const (
+	pathPolicy       = "/"
+	policyIDProperty = "policyId"
+)
+
+var (
+	errPolicyExists    = errors.New("policy already exists")
+	errPolicyInvalid   = errors.New("policy must be a JSON object")
+	errPolicyIDInvalid = errors.New("policy ID differs from the topic one")
+)
+
+// handlePolicy stores and serves the whole policy documents of the policies commands locally,
+// so that a local policy snapshot is kept alongside the things. Returns false if the command is not
+// handled, e.g. it addresses a part of the policy, and is to be forwarded to the cloud.
+func (h *Handler) handlePolicy(command *protocol.Envelope) bool {
+	if len(command.Path) > 0 && command.Path != pathPolicy {
+		return false
+	}
+
+	policyID := TopicNamespaceID(command.Topic)
+	switch command.Topic.Action {
+	case protocol.ActionCreate:
+		h.storePolicy(command, policyID, false)
+
+	case protocol.ActionModify:
+		h.storePolicy(command, policyID, true)
+
+	case protocol.ActionRetrieve:
+		h.retrievePolicy(command, policyID)
+
+	case protocol.ActionDelete:
+		if err := h.Storage.RemovePolicy(policyID); err != nil {
+			h.policyFailed("Delete policy failed", err, command, policyID)
+		} else {
+			h.publishPolicyResponse(responseEnvelope(command, deleted))
+		}
+
+	default:
+		return false
+	}
+	logCmdHandled(command, h.Logger)
+	return true
+}
+
+// storePolicy creates or replaces the policy. The create commands fail with conflict error if the policy
+// is already stored, the existence is checked and the policy is stored atomically.
+func (h *Handler) storePolicy(command *protocol.Envelope, policyID string, replace bool) {
+	policy, err := policyValue(command.Value, policyID)
+	if err != nil {
+		h.policyFailed("Invalid policy", err, command, policyID)
+		return
+	}
+
+	existing := false
+	err = h.Storage.Batch(func(storage persistence.ThingsStorage) error {
+		_, err := storage.GetPolicy(policyID)
+		if existing = err == nil; existing && !replace {
+			return errPolicyExists
+		}
+		if err != nil && !errors.Is(err, persistence.ErrPolicyNotFound) {
+			return err
+		}
+		_, err = storage.AddPolicy(policyID, policy)
+		return err
+	})
+	if err != nil {
+		h.policyFailed("Store policy failed", err, command, policyID)
+		return
+	}
+
+	if existing {
+		h.publishPolicyResponse(responseEnvelope(command, modified))
+	} else {
+		h.publishPolicyResponse(ResponseEnvelopeWithValue(command, created, json.RawMessage(policy)))
+	}
+}
+
+func (h *Handler) retrievePolicy(command *protocol.Envelope, policyID string) {
+	policyData, err := h.Storage.GetPolicy(policyID)
+	if err != nil {
+		h.policyFailed("Retrieve policy failed", err, command, policyID)
+		return
+	}
+	if command.Headers.ResponseRequired() {
+		h.publishPolicyResponse(h.retrieveResponse(command, json.RawMessage(policyData.Policy)))
+	}
+}
+
+// policyFailed logs the policies command error and responds with the matching error, if a response is required.
+func (h *Handler) policyFailed(msg string, err error, command *protocol.Envelope, policyID string) {
+	logCmdError(msg, err, command, h.Logger)
+	if !command.Headers.ResponseRequired() {
+		return
+	}
+
+	switch {
+	case errors.Is(err, persistence.ErrPolicyNotFound):
+		h.publishPolicyResponse(NewPolicyNotFoundError(command, policyID))
+	case errors.Is(err, errPolicyExists):
+		h.publishPolicyResponse(NewPolicyConflictError(command, policyID))
+	case errors.Is(err, errPolicyIDInvalid):
+		h.publishPolicyResponse(NewPolicyIDInvalidError(command, policyID))
+	case errors.Is(err, errPolicyInvalid):
+		h.publishPolicyResponse(policyError(NewInvalidJSONValueError(command, err)))
+	default:
+		h.publishPolicyResponse(policyError(NewUnknownError(command, msg, err)))
+	}
+}
+
+func (h *Handler) publishPolicyResponse(response *protocol.Envelope) {
+	if response != nil {
+		publishResponse(h, response)
+	}
+}
+
+// policyValue returns the policy document of the command value with the policy ID of the topic.
+func policyValue(value json.RawMessage, policyID string) ([]byte, error) {
+	policy := make(map[string]interface{})
+	if err := json.Unmarshal(value, &policy); err != nil || policy == nil {
+		return nil, errors.Wrap(errPolicyInvalid, "invalid policy value")
+	}
+	if id, ok := policy[policyIDProperty]; ok && id != policyID {
+		return nil, errors.Wrapf(errPolicyIDInvalid, "policy ID '%v'", id)
+	}
+	policy[policyIDProperty] = policyID
+	return json.Marshal(policy)
+}
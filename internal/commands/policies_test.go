@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	policyCmd = `{
+		"topic": "org.eclipse.kanto/test/policies/commands/%s",
+		%s,
+		"path": "%s",
+		"value": %s
+	}`
+
+	testPolicyID = "org.eclipse.kanto:test"
+	testPolicy   = `{"entries": {"DEFAULT": {"subjects": {}, "resources": {}}}}`
+)
+
+type PoliciesCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestPoliciesCommandsSuite(t *testing.T) {
+	suite.Run(t, new(PoliciesCommandsSuite))
+}
+
+func (s *PoliciesCommandsSuite) SetupTest() {
+	s.handler.LocalPolicies = true
+}
+
+func (s *PoliciesCommandsSuite) TearDownTest() {
+	s.handler.LocalPolicies = false
+	s.handler.Storage.RemovePolicy(testPolicyID)
+	s.CommandsSuite.TearDownTest()
+}
+
+func (s *PoliciesCommandsSuite) TestCreateRetrieveDelete() {
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionCreate, defaultHeaders, "/", testPolicy))
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+	assert.Equal(s.T(), protocol.GroupPolicies, response.Topic.Group)
+	assert.JSONEq(s.T(),
+		`{"policyId": "org.eclipse.kanto:test", "entries": {"DEFAULT": {"subjects": {}, "resources": {}}}}`,
+		string(response.Value))
+
+	// policies are local only
+	_, err := s.handler.HonoPub.(*testPublisher).Pull()
+	assert.Error(s.T(), err)
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionCreate, defaultHeaders, "/", testPolicy))
+	s.assertErrorResponse(409, "policies:policy.conflict")
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionModify, defaultHeaders, "/", `{"entries": {}}`))
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionRetrieve, defaultHeaders, "/", "null"))
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{"policyId": "org.eclipse.kanto:test", "entries": {}}`, string(response.Value))
+
+	policy, err := s.handler.Storage.GetPolicy(testPolicyID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), policy.Revision)
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionDelete, defaultHeaders, "/", "null"))
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionRetrieve, defaultHeaders, "/", "null"))
+	s.assertErrorResponse(404, "policies:policy.notfound")
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionDelete, defaultHeaders, "/", "null"))
+	s.assertErrorResponse(404, "policies:policy.notfound")
+}
+
+func (s *PoliciesCommandsSuite) TestModifyCreates() {
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionModify, headersNoResponseRequired, "/", testPolicy))
+	assertPublishedNone(s.S())
+
+	policy, err := s.handler.Storage.GetPolicy(testPolicyID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), policy.Revision)
+}
+
+func (s *PoliciesCommandsSuite) TestPolicyInvalid() {
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionCreate, defaultHeaders, "/", `[]`))
+	s.assertErrorResponse(400, "json.invalid")
+
+	assert.Empty(s.T(), s.handleCommandF(policyCmd, protocol.ActionCreate, defaultHeaders, "/",
+		`{"policyId": "org.eclipse.kanto:other"}`))
+	s.assertErrorResponse(400, "policies:id.invalid")
+
+	_, err := s.handler.Storage.GetPolicy(testPolicyID)
+	assert.Error(s.T(), err)
+}
+
+func (s *PoliciesCommandsSuite) TestPolicyForwarded() {
+	// parts of the policies are not stored locally
+	msgs := s.handleCommandF(policyCmd, protocol.ActionModify, defaultHeaders, "/entries/DEFAULT", "{}")
+	assert.Len(s.T(), msgs, 1)
+	assertPublishedNone(s.S())
+
+	// the policies commands are forwarded if not enabled
+	s.handler.LocalPolicies = false
+	msgs = s.handleCommandF(policyCmd, protocol.ActionCreate, defaultHeaders, "/", testPolicy)
+	assert.Len(s.T(), msgs, 1)
+	assertPublishedNone(s.S())
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"strings"
+
+	parser "github.com/Jeffail/gabs/v2"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+const (
+	headerIfMatch     = "if-match"
+	headerIfNoneMatch = "if-none-match"
+
+	eTagAny = "*"
+)
+
+var errorPreconditionFailed = errors.New("precondition does not match the resource entity tag")
+
+// preconditionsMet evaluates the modify and delete command 'if-match' and 'if-none-match' headers, if any,
+// against the entity tag of the locally stored command resource, i.e. the same entity tag its retrieve responds.
+// The 'if-match' holds if the resource exists and its entity tag is listed, or the '*' is listed.
+// The 'if-none-match' holds if the resource does not exist or its entity tag is not listed, nor the '*'.
+// Returns false and builds the command output error response if a precondition does not hold.
+func (h *Handler) preconditionsMet(cmd *Command, out *CommandOutput) bool {
+	ifMatch := cmd.envelope.Headers.IfMatch()
+	ifNoneMatch := cmd.envelope.Headers.IfNoneMatch()
+	if len(ifMatch) == 0 && len(ifNoneMatch) == 0 {
+		return true
+	}
+
+	switch cmd.envelope.Topic.Action {
+	case protocol.ActionCreate, protocol.ActionModify, protocol.ActionMerge, protocol.ActionDelete:
+	default:
+		return true
+	}
+	if cmd.envelope.Topic.Namespace == protocol.TopicPlaceholder ||
+		cmd.envelope.Topic.EntityID == protocol.TopicPlaceholder {
+		return true
+	}
+
+	eTag, exists, err := h.resourceETag(cmd)
+	if err != nil {
+		out.response = commandUnknownError("Command precondition evaluation failed", err, cmd.envelope, h.Logger)
+		return false
+	}
+
+	if len(ifMatch) > 0 && !(exists && eTagListed(ifMatch, eTag)) {
+		return h.preconditionFailed(cmd, headerIfMatch, ifMatch, eTag, out)
+	}
+	if len(ifNoneMatch) > 0 && exists && eTagListed(ifNoneMatch, eTag) {
+		return h.preconditionFailed(cmd, headerIfNoneMatch, ifNoneMatch, eTag, out)
+	}
+	return true
+}
+
+func (h *Handler) preconditionFailed(cmd *Command, header, value, eTag string, out *CommandOutput) bool {
+	logCmdError("Command precondition not met", errorPreconditionFailed, cmd.envelope, h.Logger)
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewPreconditionFailedError(cmd.envelope, header, value, eTag)
+	}
+	return false
+}
+
+// resourceETag returns the entity tag of the locally stored command resource, i.e. the thing revision one
+// for a thing and the content one for its sub-resources, or false if the resource does not exist.
+func (h *Handler) resourceETag(cmd *Command) (string, bool, error) {
+	scope, _, _ := ParseCmdPath(cmd.envelope.Path)
+	switch scope {
+	case ScopeThing:
+		thing := model.Thing{}
+		if err := h.Storage.GetThingData(cmd.thingID, &thing); err != nil {
+			return resourceMissing(err)
+		}
+		return revisionETag(thing.Revision), true, nil
+
+	case ScopeFeatures:
+		thing := model.Thing{}
+		if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+			return resourceMissing(err)
+		}
+		if thing.Features == nil {
+			return noValue, false, nil
+		}
+		return contentETag(thing.Features), true, nil
+
+	case ScopeFeature, ScopeFeatureProperties, ScopeFeatureProperty,
+		ScopeFeatureDesiredProperties, ScopeFeatureDesiredProperty:
+		feature := model.Feature{}
+		if err := h.Storage.GetFeature(cmd.thingID, cmd.target, &feature); err != nil {
+			return resourceMissing(err)
+		}
+		if scope == ScopeFeature {
+			return contentETag(&feature), true, nil
+		}
+
+		properties := feature.Properties
+		if scope == ScopeFeatureDesiredProperties || scope == ScopeFeatureDesiredProperty {
+			properties = feature.DesiredProperties
+		}
+		if properties == nil {
+			return noValue, false, nil
+		}
+		if scope == ScopeFeatureProperties || scope == ScopeFeatureDesiredProperties {
+			return contentETag(properties), true, nil
+		}
+
+		value, err := parser.Wrap(properties).JSONPointer(cmd.path)
+		if err != nil {
+			return noValue, false, nil
+		}
+		return contentETag(value.Data()), true, nil
+
+	default:
+		return noValue, false, nil
+	}
+}
+
+// resourceMissing returns that the resource does not exist if the provided error is a not found one.
+func resourceMissing(err error) (string, bool, error) {
+	if errors.Is(err, persistence.ErrNotFound) {
+		return noValue, false, nil
+	}
+	return noValue, false, err
+}
+
+// eTagListed returns true if the provided entity tag or the '*' is listed in the comma-separated header value.
+// The entity tags are compared regardless of their quotes.
+func eTagListed(header, eTag string) bool {
+	for _, listed := range strings.Split(header, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == eTagAny || (len(eTag) > 0 && strings.Trim(listed, `"`) == strings.Trim(eTag, `"`)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	retrieveResourceCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands"
+		},
+		"path": %q
+	}`
+
+	preconditionModifyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			%q: %q
+		},
+		"path": %q,
+		"value": %s
+	}`
+
+	preconditionDeleteCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/delete",
+		"headers": {
+			"correlation-id": "test/local-digital-twins/commands",
+			%q: %q
+		},
+		"path": %q
+	}`
+
+	pathThing    = "/"
+	pathProperty = "/features/meter/properties/x"
+)
+
+type PreconditionCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestPreconditionCommandsSuite(t *testing.T) {
+	suite.Run(t, new(PreconditionCommandsSuite))
+}
+
+func (s *PreconditionCommandsSuite) TestIfMatch() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+
+	for _, path := range []string{pathThing, "/features", "/features/meter", "/features/meter/properties", pathProperty} {
+		eTag := s.retrieveETag(path)
+		value := s.retrieveValue(path)
+
+		s.handleCommandF(preconditionModifyCmd, "If-Match", `"hash:0"`, path, value)
+		s.assertErrorResponse(412, "things:precondition.failed")
+		assertPublishedNone(s.S())
+
+		s.handleCommandF(preconditionModifyCmd, "If-Match", `"hash:0", `+eTag, path, value)
+		assert.Contains(s.T(), []int{201, 204}, pullPublishedEnvelope(s.S()).Status, path)
+		pullPublishedEnvelope(s.S()) // modified event
+	}
+
+	s.handleCommandF(preconditionModifyCmd, "If-Match", "*", pathProperty, "30")
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 30, feature.Properties["x"])
+
+	// a missing resource does not match
+	s.handleCommandF(preconditionModifyCmd, "If-Match", "*", "/features/meter/properties/y", "1")
+	s.assertErrorResponse(412, "things:precondition.failed")
+	assertPublishedNone(s.S())
+}
+
+func (s *PreconditionCommandsSuite) TestIfMatchDelete() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+	eTag := s.retrieveETag(pathProperty)
+
+	s.handleCommandF(preconditionModifyCmd, "If-Match", eTag, pathProperty, "20")
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	// the entity tag of the previous value is stale
+	s.handleCommandF(preconditionDeleteCmd, "If-Match", eTag, pathProperty)
+	s.assertErrorResponse(412, "things:precondition.failed")
+	assertPublishedNone(s.S())
+
+	s.handleCommandF(preconditionDeleteCmd, "If-Match", s.retrieveETag(pathProperty), pathProperty)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // deleted event
+
+	s.handleCommandF(preconditionDeleteCmd, "If-Match", s.retrieveETag(pathThing), pathThing)
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+}
+
+func (s *PreconditionCommandsSuite) TestIfNoneMatch() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+	eTag := s.retrieveETag(pathProperty)
+
+	// create only
+	s.handleCommandF(preconditionModifyCmd, "If-None-Match", "*", pathProperty, "20")
+	s.assertErrorResponse(412, "things:precondition.failed")
+	assertPublishedNone(s.S())
+
+	s.handleCommandF(preconditionModifyCmd, "If-None-Match", "*", "/features/meter/properties/y", "1")
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	// modify only if changed meanwhile
+	s.handleCommandF(preconditionModifyCmd, "If-None-Match", eTag, pathProperty, "20")
+	s.assertErrorResponse(412, "things:precondition.failed")
+	assertPublishedNone(s.S())
+
+	s.handleCommandF(preconditionModifyCmd, "If-None-Match", `"hash:0"`, pathProperty, "20")
+	assert.Equal(s.T(), 204, pullPublishedEnvelope(s.S()).Status)
+	pullPublishedEnvelope(s.S()) // modified event
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 20, feature.Properties["x"])
+	assert.EqualValues(s.T(), 1, feature.Properties["y"])
+}
+
+// retrieveETag returns the entity tag the retrieve of the resource on the provided path responds.
+func (s *PreconditionCommandsSuite) retrieveETag(path string) string {
+	s.handleCommandF(retrieveResourceCmd, path)
+	response := pullPublishedEnvelope(s.S())
+	require.Equal(s.T(), 200, response.Status)
+	require.NotEmpty(s.T(), response.Headers.ETag())
+	return response.Headers.ETag()
+}
+
+// retrieveValue returns the JSON value the retrieve of the resource on the provided path responds.
+func (s *PreconditionCommandsSuite) retrieveValue(path string) string {
+	s.handleCommandF(retrieveResourceCmd, path)
+	response := pullPublishedEnvelope(s.S())
+	require.Equal(s.T(), 200, response.Status)
+	return string(response.Value)
+}
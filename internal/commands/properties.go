@@ -13,6 +13,7 @@
 package commands
 
 import (
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/pkg/errors"
 )
@@ -21,6 +22,7 @@ var errorPropertiesNotFound = errors.New("properties of feature could not be fou
 var errorDesiredPropertiesNotFound = errors.New("desired properties of feature could not be found")
 
 // modifyProperties handles add/update properties commands and builds the command output.
+// The properties with null value are deleted, i.e. not set.
 func modifyProperties(h *Handler, cmd *Command, out *CommandOutput) {
 	doPropertiesModify(h, cmd, false, out)
 }
@@ -30,6 +32,16 @@ func modifyDesiredProperties(h *Handler, cmd *Command, out *CommandOutput) {
 	doPropertiesModify(h, cmd, true, out)
 }
 
+// mergeProperties handles merge properties commands and builds the command output.
+func mergeProperties(h *Handler, cmd *Command, out *CommandOutput) {
+	doFeatureMerge(h, cmd, []string{memberProperties}, out)
+}
+
+// mergeDesiredProperties handles merge desired properties commands and builds the command output.
+func mergeDesiredProperties(h *Handler, cmd *Command, out *CommandOutput) {
+	doFeatureMerge(h, cmd, []string{memberDesiredProperties}, out)
+}
+
 // retrieveProperties handles retrieve properties commands and builds the command output.
 func retrieveProperties(h *Handler, cmd *Command, out *CommandOutput) {
 	doPropertiesRetrieve(h, cmd, false, out)
@@ -54,12 +66,13 @@ func doPropertiesModify(h *Handler, cmd *Command, desired bool, out *CommandOutp
 	thingID := cmd.thingID
 	featureID := cmd.target
 
-	if feature, err := h.LoadFeature(thingID, featureID, cmd.envelope); err != nil {
+	if feature, err := h.loadFeatureForModify(thingID, featureID, cmd.envelope); err != nil {
 		out.response = h.resourceNotFound("Modify feature's properties failed. Unknown feature",
 			err, cmd.envelope, thingID, featureID)
 	} else {
 		var newValue map[string]interface{}
 		if err := commandValue(cmd.envelope, &newValue, out); err == nil {
+			newValue = withoutNullProperties(newValue)
 			status := modified
 			action := protocol.ActionModified
 			if desired {
@@ -79,8 +92,10 @@ func doPropertiesModify(h *Handler, cmd *Command, desired bool, out *CommandOutp
 			if rev, err := h.Storage.AddFeature(thingID, featureID, feature); err != nil {
 				out.response = commandUnknownError("Update feature's properties failed", err, cmd.envelope, h.Logger)
 			} else {
-				out.response = responseEnvelope(cmd.envelope, status)
-				out.event = h.eventEnvelope(thingID, cmd.envelope, action)
+				out.response = withETag(responseEnvelope(cmd.envelope, status), contentETag(newValue))
+				if out.event = h.eventEnvelope(thingID, cmd.envelope, action); out.event != nil && newValue != nil {
+					out.event.WithValue(newValue)
+				}
 
 				out.thingID = thingID
 				out.featureID = featureID
@@ -109,7 +124,7 @@ func doPropertiesRetrieve(h *Handler, cmd *Command, desired bool, out *CommandOu
 			out.response = h.propertiesNotFound("Unable to retrieve properties of feature ID "+featureID,
 				cmd.envelope, thingID, featureID, desired)
 		} else {
-			out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, properties)
+			out.response = h.retrieveResponse(cmd.envelope, properties)
 		}
 	}
 }
@@ -167,3 +182,11 @@ func (h *Handler) propertiesNotFound(msg string, cmd *protocol.Envelope,
 	}
 	return nil
 }
+
+// withoutNullProperties removes the properties with null value, i.e. such properties are not set on modify.
+func withoutNullProperties(properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		return nil
+	}
+	return jsonutil.MergePatch(nil, properties).(map[string]interface{})
+}
@@ -353,9 +353,11 @@ func (s *PropertiesCommandsSuite) TestPropertiesModify() {
 		properties = asMapValue(s.T(), test.output)
 		assert.EqualValues(s.T(), properties, featureOut.Properties)
 
-		assertPublishedOnOkF(s.S(), test.response, test.event)
+		eTag := contentETag(s.T(), featureOut.Properties)
+		assertPublishedOnOkETagF(s.S(), test.response, eTag, test.event)
 
-		s.handleRetrieveCheckResponseF(retrievePropertiesCmd, test.retrieveRsp)
+		// the retrieved properties have the entity tag of the modify response
+		s.handleRetrieveCheckResponseETagF(retrievePropertiesCmd, test.retrieveRsp, eTag)
 	}
 }
 
@@ -32,6 +32,18 @@ func modifyDesiredProperty(h *Handler, cmd *Command, out *CommandOutput) {
 	doPropertyModify(h, cmd, true, out)
 }
 
+// mergeProperty handles merge property commands and builds the command output.
+// The property is deleted if the command value is null.
+func mergeProperty(h *Handler, cmd *Command, out *CommandOutput) {
+	doPropertyMerge(h, cmd, memberProperties, out)
+}
+
+// mergeDesiredProperty handles merge desired property commands and builds the command output.
+// The desired property is deleted if the command value is null.
+func mergeDesiredProperty(h *Handler, cmd *Command, out *CommandOutput) {
+	doPropertyMerge(h, cmd, memberDesiredProperties, out)
+}
+
 // retrieveProperty handles retrieve property commands and builds the command output.
 func retrieveProperty(h *Handler, cmd *Command, out *CommandOutput) {
 	doPropertyRetrieve(h, cmd, false, out)
@@ -56,7 +68,7 @@ func doPropertyModify(h *Handler, cmd *Command, desired bool, out *CommandOutput
 	thingID := cmd.thingID
 	featureID := cmd.target
 
-	if feature, err := h.LoadFeature(thingID, featureID, cmd.envelope); err != nil {
+	if feature, err := h.loadFeatureForModify(thingID, featureID, cmd.envelope); err != nil {
 		out.response = h.resourceNotFound("Modify feature property failed. Feature not found",
 			err, cmd.envelope, thingID, featureID)
 	} else {
@@ -90,7 +102,7 @@ func doPropertyModify(h *Handler, cmd *Command, desired bool, out *CommandOutput
 			if rev, err := h.Storage.AddFeature(thingID, cmd.target, feature); err != nil {
 				out.response = commandUnknownError("Update feature property failed", err, cmd.envelope, h.Logger)
 			} else {
-				out.response = responseEnvelope(cmd.envelope, status)
+				out.response = withETag(responseEnvelope(cmd.envelope, status), contentETag(newValue))
 				out.event = h.eventEnvelope(thingID, cmd.envelope, action)
 				addChangeInfo(out, thingID, featureID, rev)
 			}
@@ -98,6 +110,16 @@ func doPropertyModify(h *Handler, cmd *Command, desired bool, out *CommandOutput
 	}
 }
 
+func doPropertyMerge(h *Handler, cmd *Command, member string, out *CommandOutput) {
+	pathSlice, err := parser.JSONPointerToSlice(cmd.path)
+	if err != nil {
+		out.response = commandPropertyNotFoundError("Merge feature property failed. Invalid path.",
+			err, cmd, member == memberDesiredProperties, h.Logger)
+		return
+	}
+	doFeatureMerge(h, cmd, append([]string{member}, pathSlice...), out)
+}
+
 func doPropertyRetrieve(h *Handler, cmd *Command, desired bool, out *CommandOutput) {
 	thingID := cmd.thingID
 	featureID := cmd.target
@@ -127,7 +149,7 @@ func doPropertyRetrieve(h *Handler, cmd *Command, desired bool, out *CommandOutp
 			out.response = commandPropertyNotFoundError("Unable to retrieve property path "+cmd.path,
 				propErr, cmd, desired, h.Logger)
 		} else {
-			out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, propValue.Data())
+			out.response = h.retrieveResponse(cmd.envelope, propValue.Data())
 		}
 	}
 }
@@ -16,7 +16,9 @@ import (
 	"testing"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -281,7 +283,7 @@ func (s *PropertyCommandsSuite) TestPropertyModify() {
 		s.addFeature(testFeatureID, featureIn.WithProperties(properties))
 
 		s.handleCommandF(test.command, defaultHeaders)
-		assertPublishedOnOkF(s.S(), test.response, test.event)
+		assertPublishedOnOkETagF(s.S(), test.response, s.commandValueETag(withDefaultHeadersF(test.command)), test.event)
 
 		s.getFeature(testFeatureID, &featureOut)
 		assert.NotNil(s.T(), featureOut.Properties)
@@ -289,7 +291,11 @@ func (s *PropertyCommandsSuite) TestPropertyModify() {
 		properties = asMapValue(s.T(), test.output)
 		assert.EqualValues(s.T(), properties, featureOut.Properties)
 
-		s.handleRetrieveCheckResponseF(retrieveXCmd, test.retrieveRsp)
+		retrieveETag := "" // not found, no entity tag
+		if x, ok := featureOut.Properties["x"]; ok {
+			retrieveETag = contentETag(s.T(), x)
+		}
+		s.handleRetrieveCheckResponseETagF(retrieveXCmd, test.retrieveRsp, retrieveETag)
 	}
 }
 
@@ -473,6 +479,69 @@ func (s *PropertyCommandsSuite) TestRetrievePropertyErrorStatus() {
 	assertPublishedNone(s.S())
 }
 
+func (s *PropertyCommandsSuite) TestPropertyModifySortedKeys() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, &model.Feature{})
+	s.handler.SortedKeys = true
+	defer func() {
+		s.handler.SortedKeys = false
+	}()
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/sorted"},
+		"path": "/features/meter/properties/x",
+		"value": {"z": 1.50, "a": {"y": "b", "b": [{"d": 2, "c": 1}]}}
+	}`)
+	pullPublishedEnvelope(s.S()) // response
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), `{"a":{"b":[{"c":1,"d":2}],"y":"b"},"z":1.50}`, string(event.Value))
+
+	s.handleCommandF(retrieveXCmd, `"headers": {"correlation-id": "test/local-digital-twins/sorted"}`)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), `{"a":{"b":[{"c":1,"d":2}],"y":"b"},"z":1.5}`, string(response.Value))
+}
+
+func (s *PropertyCommandsSuite) TestPropertyModifyEventExtra() {
+	s.createThing((&model.Thing{}).
+		WithIDFrom(testThingID).
+		WithAttribute("location", "kitchen").
+		WithAttribute("model", "m1"))
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 1, "y": 2}))
+	s.handler.EventsExtraFields = "attributes/location,features/meter/properties/y"
+	defer func() {
+		s.handler.EventsExtraFields = ""
+	}()
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/extra"},
+		"path": "/features/meter/properties/x",
+		"value": 10
+	}`)
+	pullPublishedEnvelope(s.S()) // response
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionModified, event.Topic.Action)
+	assert.Equal(s.T(), map[string]interface{}{
+		"attributes": map[string]interface{}{"location": "kitchen"},
+		"features": map[string]interface{}{
+			"meter": map[string]interface{}{"properties": map[string]interface{}{"y": 2.0}},
+		},
+	}, event.Extra)
+
+	s.handler.EventsExtraFields = "attributes/unknown"
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/extra"},
+		"path": "/features/meter/properties/x",
+		"value": 11
+	}`)
+	pullPublishedEnvelope(s.S()) // response
+	assert.Nil(s.T(), pullPublishedEnvelope(s.S()).Extra)
+}
+
 func (s *PropertyCommandsSuite) TestDesiredPropertyModify() {
 	s.addTestThing()
 	type propertyTest struct {
@@ -578,7 +647,7 @@ func (s *PropertyCommandsSuite) TestDesiredPropertyModify() {
 
 		s.handleCommandF(test.command, defaultHeaders)
 		if len(test.response) != 0 {
-			assertPublishedOnOkF(s.S(), test.response, test.event)
+			assertPublishedOnOkETagF(s.S(), test.response, s.commandValueETag(withDefaultHeadersF(test.command)), test.event)
 		}
 
 		s.getFeature(testFeatureID, &featureOut)
@@ -587,7 +656,11 @@ func (s *PropertyCommandsSuite) TestDesiredPropertyModify() {
 		properties = asMapValue(s.T(), test.output)
 		assert.EqualValues(s.T(), properties, featureOut.DesiredProperties)
 
-		s.handleRetrieveCheckResponseF(retrieveDesiredXCmd, test.retrieveRsp)
+		retrieveETag := "" // not found, no entity tag
+		if x, ok := featureOut.DesiredProperties["x"]; ok {
+			retrieveETag = contentETag(s.T(), x)
+		}
+		s.handleRetrieveCheckResponseETagF(retrieveDesiredXCmd, test.retrieveRsp, retrieveETag)
 	}
 }
 
@@ -714,3 +787,50 @@ func (s *PropertyCommandsSuite) retrieveDesiredPropertyNotFoundError() {
 	}`
 	s.handleRetrieveCheckResponseF(retrieveDesiredXCmd, retrieveRsp)
 }
+
+func (s *PropertyCommandsSuite) TestPropertyModifyFeatureAutoProvisioning() {
+	s.addTestThing()
+	s.handler.FeatureAutoProvisioning = true
+	defer func() {
+		s.handler.FeatureAutoProvisioning = false
+	}()
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/provisioning"},
+		"path": "/features/meter/properties/x",
+		"value": 10
+	}`)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionCreated, event.Topic.Action)
+	assert.Equal(s.T(), "/features/meter", event.Path)
+	assert.Equal(s.T(), "{}", string(event.Value))
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+	event = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.ActionCreated, event.Topic.Action)
+	assert.Equal(s.T(), "/features/meter/properties/x", event.Path)
+	assertPublishedNone(s.S())
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.EqualValues(s.T(), 10, feature.Properties["x"])
+
+	sysData, err := s.handler.Storage.GetSystemThingData(testThingID)
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), sysData.UnsynchronizedFeatures, testFeatureID)
+}
+
+func (s *PropertyCommandsSuite) TestPropertyModifyNoFeatureAutoProvisioning() {
+	s.addTestThing()
+
+	s.handleCommand(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test/local-digital-twins/provisioning"},
+		"path": "/features/meter/properties/x",
+		"value": 10
+	}`)
+	s.assertErrorResponse(404, "things:feature.notfound")
+}
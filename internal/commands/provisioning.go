@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"path"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/pkg/errors"
+)
+
+// ProvisioningFilter restricts the things that could be auto-provisioned.
+//
+// The Allow and Deny patterns are matched against the whole thing ID, where '*' matches
+// any sequence of characters and '?' matches any single character,
+// e.g. 'org.eclipse.kanto:*' or 'org.eclipse.kanto:device?'.
+type ProvisioningFilter struct {
+	// Allow contains the patterns of the thing IDs allowed to be auto-provisioned, all are allowed if empty.
+	Allow []string
+	// Deny contains the patterns of the thing IDs not allowed to be auto-provisioned, takes precedence over Allow.
+	Deny []string
+	// MaxThings is the maximum number of stored things up to which auto-provisioning is performed,
+	// zero or negative for unlimited.
+	MaxThings int
+}
+
+// Validate checks if the filter patterns are well-formed.
+func (f *ProvisioningFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Allow...), f.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid auto-provisioning thing ID pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if the thing ID is matching the Allow patterns and is not matching the Deny ones.
+func (f *ProvisioningFilter) Allowed(thingID string) bool {
+	if matchAny(f.Deny, thingID) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchAny(f.Allow, thingID)
+}
+
+func matchAny(patterns []string, thingID string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, thingID); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAutoProvisioning returns error wrapping persistence.ErrThingNotFound
+// if the thing is not allowed to be auto-provisioned or the things limit is reached.
+func (h *Handler) checkAutoProvisioning(thingID string) error {
+	filter := &h.AutoProvisioningFilter
+	if !filter.Allowed(thingID) {
+		return errors.Wrapf(persistence.ErrThingNotFound,
+			"auto-provisioning of thing with ID '%s' is not allowed", thingID)
+	}
+
+	if filter.MaxThings > 0 {
+		ids, err := h.Storage.GetThingIDs()
+		if err != nil {
+			return err
+		}
+		if len(ids) >= filter.MaxThings {
+			return errors.Wrapf(persistence.ErrThingNotFound,
+				"auto-provisioning of thing with ID '%s' is not allowed, limit of %d things reached",
+				thingID, filter.MaxThings)
+		}
+	}
+	return nil
+}
@@ -14,7 +14,10 @@ package commands_test
 
 import (
 	"testing"
+	"time"
 
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/pkg/errors"
@@ -85,10 +88,43 @@ func (s *ProvisioningCommandsSuite) TestProvisioningFeatureModify() {
 	}`
 	assertPublishedSkipVersioning(s.S(),
 		s.asEnvelopeWithValueF(createThingEvent),
-		withHeadersNoResponseRequired(response),
+		withETag(s.T(), withHeadersNoResponseRequired(response), s.commandValueETag(withDefaultHeadersF(modifyFeatureCmd))),
 		s.asEnvelopeWithValueF(event))
 }
 
+func (s *ProvisioningCommandsSuite) TestProvisioningStalled() {
+	storage := s.handler.Storage
+	stalled := &stalledStorage{ThingsStorage: storage, release: make(chan struct{})}
+	watchdog := &commands.StorageWatchdog{Deadline: 50 * time.Millisecond, Logger: s.handler.Logger}
+	s.handler.Storage = stalled
+	s.handler.Watchdog = watchdog
+	defer func() {
+		s.handler.Storage = storage
+		s.handler.Watchdog = nil
+	}()
+
+	modifyFeatureCmd := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {"properties": {"x": 3.1}}
+	}`
+
+	assert.Empty(s.T(), s.handleCommandF(modifyFeatureCmd, defaultHeaders))
+	s.assertErrorResponse(503, "things:thing.unavailable")
+
+	close(stalled.release)
+	assert.Eventually(s.T(), func() bool {
+		return watchdog.Stalled() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the stalled execution changes are persisted, but the thing created event is not published
+	assertPublishedNone(s.S())
+	feature := &model.Feature{}
+	require.NoError(s.T(), storage.GetFeature(testThingID, testFeatureID, feature))
+	assert.EqualValues(s.T(), 3.1, feature.Properties["x"])
+}
+
 func (s *ProvisioningCommandsSuite) TestProvisioningFeatureRetrieve() {
 	s.assertThingProvisionedOnCmd(retrieveFeatureCmd, featureNotFoundErr)
 }
@@ -141,7 +177,7 @@ func (s *ProvisioningCommandsSuite) TestProvisioningFeaturesModify() {
 	}`
 	assertPublishedSkipVersioning(s.S(),
 		s.asEnvelopeWithValueF(createThingEvent),
-		withHeadersNoResponseRequired(response),
+		withETag(s.T(), withHeadersNoResponseRequired(response), s.commandValueETag(withDefaultHeadersF(command))),
 		s.asEnvelopeWithValueF(event))
 }
 
@@ -250,6 +286,89 @@ func (s *ProvisioningCommandsSuite) TestProvisioningDesiredPropertiesDelete() {
 	s.assertThingProvisionedOnCmd(deleteDesiredPropertiesCmd, featureNotFoundErr)
 }
 
+func (s *ProvisioningCommandsSuite) TestProvisioningNotAllowed() {
+	filters := []commands.ProvisioningFilter{
+		{Allow: []string{"org.eclipse.kanto:device*"}},
+		{Deny: []string{"org.eclipse.kanto:*"}},
+		{Allow: []string{"org.eclipse.kanto:*"}, Deny: []string{"*:test"}},
+	}
+
+	defer func() {
+		s.handler.AutoProvisioningFilter = commands.ProvisioningFilter{}
+	}()
+
+	for _, filter := range filters {
+		s.handler.AutoProvisioningFilter = filter
+
+		thing, err := s.handler.LoadThing(testThingID, createEnvelope())
+		assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), filter)
+		assert.Nil(s.T(), thing)
+
+		s.handleCommand(withDefaultHeadersF(retrieveFeatureCmd))
+		assertPublished(s.S(), withResponseHeadersF(thingNotFoundErr))
+	}
+}
+
+func (s *ProvisioningCommandsSuite) TestProvisioningMaxThings() {
+	otherThingID := "org.eclipse.kanto:other"
+	s.createThing((&model.Thing{}).WithIDFrom(otherThingID))
+
+	defer func() {
+		s.handler.AutoProvisioningFilter = commands.ProvisioningFilter{}
+		s.deleteCreatedThing(otherThingID)
+	}()
+
+	s.handler.AutoProvisioningFilter = commands.ProvisioningFilter{MaxThings: 1}
+	_, err := s.handler.LoadThing(testThingID, createEnvelope())
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound))
+	assertPublishedNone(s.S())
+
+	s.handler.AutoProvisioningFilter = commands.ProvisioningFilter{MaxThings: 2}
+	thing, err := s.handler.LoadThing(testThingID, createEnvelope())
+	require.NoError(s.T(), err)
+	assert.NotNil(s.T(), thing)
+	assertPublishedSkipVersioning(s.S(), s.asEnvelopeWithValueF(createThingEvent))
+}
+
+func (s *ProvisioningCommandsSuite) TestProvisioningDiscardedOnFailedCommit() {
+	storage := s.handler.Storage
+	s.handler.Storage = &failingCommitStorage{storage}
+	defer func() {
+		s.handler.Storage = storage
+	}()
+
+	s.handleCommandF(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter",
+		"value": {"properties": {"x": 3.1}}
+	}`, defaultHeaders)
+
+	// neither the provisioned thing nor the feature is stored, no events are published
+	s.assertErrorResponse(400, "unknown")
+	assertPublishedNone(s.S())
+	assert.True(s.T(), errors.Is(storage.GetThing(testThingID, &model.Thing{}), persistence.ErrThingNotFound))
+}
+
+func TestProvisioningFilter(t *testing.T) {
+	filter := commands.ProvisioningFilter{
+		Allow: []string{"org.eclipse.kanto:*", "org.eclipse.?:device"},
+		Deny:  []string{"org.eclipse.kanto:test*"},
+	}
+	assert.NoError(t, filter.Validate())
+
+	assert.True(t, filter.Allowed("org.eclipse.kanto:device"))
+	assert.True(t, filter.Allowed("org.eclipse.x:device"))
+	assert.False(t, filter.Allowed("org.eclipse.kanto:test"))
+	assert.False(t, filter.Allowed("org.eclipse.kanto:test:sensor"))
+	assert.False(t, filter.Allowed("org.eclipse:device"))
+
+	assert.True(t, (&commands.ProvisioningFilter{}).Allowed("org.eclipse:device"))
+
+	filter.Deny = []string{"org.eclipse.kanto:[device"}
+	assert.Error(t, filter.Validate())
+}
+
 func (s *ProvisioningCommandsSuite) assertThingProvisionedOnCmd(commandFormat string, expErr string) {
 	command := withDefaultHeadersF(commandFormat)
 	s.handleCommand(command)
@@ -270,3 +389,17 @@ func createEnvelope() *protocol.Envelope {
 	envelope := protocol.Envelope{}
 	return envelope.WithPath("/").WithTopic(&topic).WithHeaders(headers)
 }
+
+// failingCommitStorage discards the changes of all of its transactions, as failed on commit.
+type failingCommitStorage struct {
+	persistence.ThingsStorage
+}
+
+func (storage *failingCommitStorage) Batch(f func(storage persistence.ThingsStorage) error) error {
+	return storage.ThingsStorage.Batch(func(tx persistence.ThingsStorage) error {
+		if err := f(tx); err != nil {
+			return err
+		}
+		return errors.New("commit failed")
+	})
+}
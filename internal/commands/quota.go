@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+)
+
+// thingQuotas contains the tracked stored sizes of the things in bytes, i.e. the sizes of their JSON representation.
+type thingQuotas struct {
+	mutex sync.Mutex
+	usage map[string]int64
+}
+
+// quotaExceeded checks if the stored size of the command thing exceeds the configured per-thing quota
+// and if so, builds the quota exceeded error response, if required.
+// The retrieve commands and the delete ones, freeing storage, are not rejected.
+func (h *Handler) quotaExceeded(cmd *Command, out *CommandOutput) bool {
+	action := cmd.envelope.Topic.Action
+	if h.ThingQuota <= 0 || action == protocol.ActionRetrieve || action == protocol.ActionDelete {
+		return false
+	}
+
+	usage, ok := h.shared().quotas.get(cmd.thingID)
+	if !ok {
+		usage = h.updateUsage(cmd.thingID)
+	}
+	if usage <= h.ThingQuota {
+		return false
+	}
+
+	h.Logger.Debug("Thing quota exceeded", CmdLogFields(cmd.envelope))
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewQuotaExceededError(cmd.envelope, cmd.thingID, usage, h.ThingQuota)
+	}
+	return true
+}
+
+// trackUsage updates the stored size of the thing modified by the executed command.
+func (h *Handler) trackUsage(thingID string, output *CommandOutput) {
+	if h.ThingQuota > 0 && output.event != nil && len(thingID) > 0 {
+		h.updateUsage(thingID)
+	}
+}
+
+// updateUsage calculates and stores the stored size of the thing. On exceeding the quota, a warning is logged
+// and published as status feature message of the device thing.
+func (h *Handler) updateUsage(thingID string) int64 {
+	usage := h.thingUsage(thingID)
+	previous, tracked := h.shared().quotas.set(thingID, usage)
+	if usage <= h.ThingQuota || (tracked && previous > h.ThingQuota) {
+		return usage
+	}
+
+	h.Logger.Warn("Thing storage quota exceeded", nil, watermill.LogFields{
+		"thing_id": thingID,
+		"usage":    usage,
+		"quota":    h.ThingQuota,
+	})
+	warning := &status.QuotaWarning{ThingID: thingID, Usage: usage, Quota: h.ThingQuota}
+	if err := status.PublishQuotaExceeded(h.MosquittoPub, h.DeviceID, warning); err != nil {
+		h.Logger.Error("Failed to publish the thing quota exceeded warning", err, nil)
+	}
+	return usage
+}
+
+// thingUsage returns the size of the JSON representation of the stored thing, 0 if there is no such thing.
+func (h *Handler) thingUsage(thingID string) int64 {
+	thing := &model.Thing{}
+	if err := h.Storage.GetThing(thingID, thing); err != nil {
+		return 0
+	}
+	payload, err := json.Marshal(thing)
+	if err != nil {
+		return 0
+	}
+	return int64(len(payload))
+}
+
+func (q *thingQuotas) get(thingID string) (int64, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	usage, ok := q.usage[thingID]
+	return usage, ok
+}
+
+// set stores the thing usage, a zero one removes the thing, and returns the previous usage, if tracked.
+func (q *thingQuotas) set(thingID string, usage int64) (int64, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.usage == nil {
+		q.usage = make(map[string]int64)
+	}
+
+	previous, ok := q.usage[thingID]
+	if usage > 0 {
+		q.usage[thingID] = usage
+	} else {
+		delete(q.usage, thingID)
+	}
+	return previous, ok
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// rateLimiterPruneSize is the number of the tracked things, on reaching which the limiters of the idle things
+// are removed.
+const rateLimiterPruneSize = 1024
+
+// rateLimiters contains the token bucket limiters of the twin commands, one per thing.
+type rateLimiters struct {
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// rateLimited checks if the command exceeds the configured rate of the modifying twin commands of its thing
+// and if so, builds the too many requests error response, if required. The retrieve commands are not limited.
+func (h *Handler) rateLimited(cmd *Command, out *CommandOutput) bool {
+	if h.RateLimit <= 0 || cmd.envelope.Topic.Action == protocol.ActionRetrieve {
+		return false
+	}
+
+	if h.shared().rateLimiters.allow(cmd.thingID, rate.Limit(h.RateLimit), h.rateBurst()) {
+		return false
+	}
+
+	h.Logger.Debug("Thing command rate limit exceeded", CmdLogFields(cmd.envelope))
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewTooManyRequestsError(cmd.envelope, cmd.thingID)
+	}
+	return true
+}
+
+func (h *Handler) rateBurst() int {
+	if h.RateBurst > 0 {
+		return h.RateBurst
+	}
+	return 1
+}
+
+func (r *rateLimiters) allow(thingID string, limit rate.Limit, burst int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.limiters == nil {
+		r.limiters = make(map[string]*rate.Limiter)
+	}
+
+	limiter, ok := r.limiters[thingID]
+	if !ok {
+		if len(r.limiters) >= rateLimiterPruneSize {
+			r.prune()
+		}
+		limiter = rate.NewLimiter(limit, burst)
+		r.limiters[thingID] = limiter
+	} else if limiter.Limit() != limit || limiter.Burst() != burst {
+		limiter.SetLimit(limit)
+		limiter.SetBurst(burst)
+	}
+	return limiter.Allow()
+}
+
+// prune removes the limiters with a full bucket, i.e. behaving as newly created ones.
+func (r *rateLimiters) prune() {
+	for thingID, limiter := range r.limiters {
+		if limiter.Tokens() >= float64(limiter.Burst()) {
+			delete(r.limiters, thingID)
+		}
+	}
+}
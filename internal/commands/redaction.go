@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// Redaction actions.
+const (
+	// RedactMask replaces the redacted value with RedactedValue.
+	RedactMask = "mask"
+	// RedactDrop removes the redacted value.
+	RedactDrop = "drop"
+
+	// RedactedValue is the value the masked values are replaced with.
+	RedactedValue = "***"
+
+	redactWildcard = "*"
+)
+
+// RedactionRule redacts the event values at the paths matching its pattern.
+type RedactionRule struct {
+	// Path is the pattern of the thing path of the redacted values, e.g. '/features/*/desiredProperties/apiKey',
+	// where '*' matches any single path segment.
+	Path string `json:"path"`
+	// Action is RedactMask or RedactDrop.
+	Action string `json:"action"`
+}
+
+// Redaction contains the rules applied to the thing events before they are published, journaled or federated,
+// so that the secrets stored in the things, e.g. API keys pushed as desired properties, are kept persisted
+// but never appear in the local broker traffic or in the journal.
+type Redaction []RedactionRule
+
+// LoadRedaction reads the redaction rules from the provided JSON file,
+// e.g. [{"path": "/features/*/desiredProperties/apiKey", "action": "mask"}].
+func LoadRedaction(file string) (Redaction, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	redaction := Redaction{}
+	if err := json.Unmarshal(data, &redaction); err != nil {
+		return nil, errors.Wrapf(err, "invalid redaction rules file %s", file)
+	}
+	for _, rule := range redaction {
+		if !strings.HasPrefix(rule.Path, "/") || len(pathSegments(rule.Path)) == 0 {
+			return nil, errors.Errorf("invalid redaction rule path '%s'", rule.Path)
+		}
+		if rule.Action != RedactMask && rule.Action != RedactDrop {
+			return nil, errors.Errorf("invalid redaction rule '%s' action '%s'", rule.Path, rule.Action)
+		}
+	}
+	return redaction, nil
+}
+
+// Apply returns a copy of the event with its value and extra fields redacted,
+// or the event itself if there is nothing to be redacted.
+func (r Redaction) Apply(event *protocol.Envelope) *protocol.Envelope {
+	if len(r) == 0 {
+		return event
+	}
+
+	value, valueRedacted := r.redact(event.Path, event.Value)
+	var extra json.RawMessage
+	extraRedacted := false
+	if event.Extra != nil {
+		if data, err := json.Marshal(event.Extra); err == nil {
+			extra, extraRedacted = r.redact("/", data)
+		}
+	}
+	if !valueRedacted && !extraRedacted {
+		return event
+	}
+
+	redacted := *event
+	if valueRedacted {
+		redacted.Value = value
+	}
+	if extraRedacted {
+		redacted.Extra = extra
+	}
+	return &redacted
+}
+
+// redact returns the value at the provided thing path with the matching values redacted
+// and true if any value is redacted.
+func (r Redaction) redact(path string, value json.RawMessage) (json.RawMessage, bool) {
+	if len(value) == 0 {
+		return value, false
+	}
+
+	segments := pathSegments(path)
+	var decoded interface{}
+	redacted := false
+	for _, rule := range r {
+		pattern := pathSegments(rule.Path)
+		if len(pattern) <= len(segments) {
+			if segmentsMatch(pattern, segments[:len(pattern)]) {
+				// the whole value is redacted
+				if rule.Action == RedactDrop {
+					return nil, true
+				}
+				return json.RawMessage(`"` + RedactedValue + `"`), true
+			}
+			continue
+		}
+
+		if !segmentsMatch(pattern[:len(segments)], segments) {
+			continue
+		}
+		if decoded == nil {
+			decoder := json.NewDecoder(bytes.NewReader(value))
+			decoder.UseNumber()
+			if err := decoder.Decode(&decoded); err != nil {
+				return value, false
+			}
+		}
+		if redactAt(decoded, pattern[len(segments):], rule.Action) {
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return value, false
+	}
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		return value, false
+	}
+	return data, true
+}
+
+// redactAt redacts the values of the JSON object matching the pattern relative to it.
+func redactAt(value interface{}, pattern []string, action string) bool {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	redacted := false
+	for key, child := range object {
+		if pattern[0] != redactWildcard && pattern[0] != key {
+			continue
+		}
+		if len(pattern) > 1 {
+			if redactAt(child, pattern[1:], action) {
+				redacted = true
+			}
+			continue
+		}
+		if action == RedactDrop {
+			delete(object, key)
+		} else {
+			object[key] = RedactedValue
+		}
+		redacted = true
+	}
+	return redacted
+}
+
+func segmentsMatch(pattern []string, segments []string) bool {
+	for i, segment := range pattern {
+		if segment != redactWildcard && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	redactionRules = `[
+		{"path": "/features/*/desiredProperties/apiKey", "action": "mask"},
+		{"path": "/attributes/secret", "action": "drop"}
+	]`
+
+	modifyDesiredPropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/meter/desiredProperties/%s",
+		"value": %s
+	}`
+)
+
+type RedactionCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestRedactionCommandsSuite(t *testing.T) {
+	suite.Run(t, new(RedactionCommandsSuite))
+}
+
+func (s *RedactionCommandsSuite) SetupTest() {
+	file := filepath.Join(s.T().TempDir(), "redaction.json")
+	require.NoError(s.T(), os.WriteFile(file, []byte(redactionRules), 0600))
+
+	redaction, err := commands.LoadRedaction(file)
+	require.NoError(s.T(), err)
+	s.handler.Redaction = redaction
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithDesiredProperty("apiKey", "old"))
+}
+
+func (s *RedactionCommandsSuite) TearDownTest() {
+	s.handler.Redaction = nil
+	s.CommandsSuite.TearDownTest()
+}
+
+func (s *RedactionCommandsSuite) TestLoadRedactionInvalid() {
+	dir := s.T().TempDir()
+	for name, rules := range map[string]string{
+		"syntax.json": `[{"path": }]`,
+		"path.json":   `[{"path": "features", "action": "mask"}]`,
+		"root.json":   `[{"path": "/", "action": "mask"}]`,
+		"action.json": `[{"path": "/attributes/secret", "action": "hide"}]`,
+	} {
+		file := filepath.Join(dir, name)
+		require.NoError(s.T(), os.WriteFile(file, []byte(rules), 0600))
+		_, err := commands.LoadRedaction(file)
+		assert.Error(s.T(), err, name)
+	}
+
+	_, err := commands.LoadRedaction(filepath.Join(dir, "missing.json"))
+	assert.Error(s.T(), err)
+}
+
+func (s *RedactionCommandsSuite) TestRedactedEvent() {
+	assert.Empty(s.T(), s.handleCommandF(modifyDesiredPropertyCmd, defaultHeaders, "apiKey", `"secret-key"`))
+	assert.True(s.T(), pullPublishedEnvelope(s.S()).Status < 300)
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), "/features/meter/desiredProperties/apiKey", event.Path)
+	assert.JSONEq(s.T(), `"***"`, string(event.Value))
+
+	feature := model.Feature{}
+	s.getFeature(testFeatureID, &feature)
+	assert.Equal(s.T(), "secret-key", feature.DesiredProperties["apiKey"])
+}
+
+func (s *RedactionCommandsSuite) TestApply() {
+	event := &protocol.Envelope{
+		Path: "/",
+		Value: json.RawMessage(`{
+			"attributes": {"secret": "s", "location": "lab"},
+			"features": {
+				"meter": {"desiredProperties": {"apiKey": "k", "x": 1}},
+				"lamp": {"properties": {"apiKey": "k"}}
+			}
+		}`),
+	}
+	redacted := s.handler.Redaction.Apply(event)
+	assert.JSONEq(s.T(), `{
+		"attributes": {"location": "lab"},
+		"features": {
+			"meter": {"desiredProperties": {"apiKey": "***", "x": 1}},
+			"lamp": {"properties": {"apiKey": "k"}}
+		}
+	}`, string(redacted.Value))
+	assert.Contains(s.T(), string(event.Value), `"secret": "s"`)
+
+	event = &protocol.Envelope{Path: "/attributes/secret", Value: json.RawMessage(`"s"`)}
+	assert.Empty(s.T(), s.handler.Redaction.Apply(event).Value)
+
+	event = &protocol.Envelope{Path: "/attributes/location", Value: json.RawMessage(`"lab"`)}
+	assert.Same(s.T(), event, s.handler.Redaction.Apply(event))
+}
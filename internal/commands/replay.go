@@ -0,0 +1,150 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// HeaderReplayed is the header of the replayed offline events, containing the timestamp of their queuing.
+const HeaderReplayed = "replayed"
+
+// topicReplayFormat is the hub topic format of the replayed events of the things, other than the root device.
+const topicReplayFormat = "%s/%s/%s"
+
+// EventReplay queues the locally generated thing events while there is no hub connection and replays them
+// in order to the configured hub topic on reconnect, e.g. a telemetry one, so that the cloud has a record
+// of every intermediate state change made while offline, not only of the final synchronized state.
+// The queue is persisted in the things storage and bounded, the oldest events are dropped once it is full.
+type EventReplay struct {
+	Storage   persistence.ThingsStorage
+	Publisher message.Publisher
+
+	DeviceID string
+	TenantID string
+	// Topic is the hub topic the events are replayed to, e.g. 't' for telemetry. The events of the things,
+	// other than the root device, are replayed to the '<topic>/<tenantId>/<thingId>' topic.
+	Topic string
+	// MaxEvents is the maximum number of the queued events, unlimited if not positive.
+	MaxEvents int
+
+	Logger logger.Logger
+
+	mu        sync.Mutex
+	connected bool
+}
+
+// Connected sets the hub connection state, the events are queued while not connected.
+func (r *EventReplay) Connected(connected bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = connected
+}
+
+func (r *EventReplay) isConnected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+// Record queues the thing event if there is no hub connection. The events of the live channel and
+// of the composite views are not queued, as they do not change the stored things.
+func (r *EventReplay) Record(event *protocol.Envelope) {
+	if r.isConnected() {
+		return
+	}
+	if event.Topic == nil || event.Topic.Channel != protocol.ChannelTwin || strings.HasPrefix(event.Path, pathViews) {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logCmdError("Unable to queue unexpected event for replay", err, event, r.Logger)
+		return
+	}
+
+	dropped, err := r.Storage.QueueReplayEvent(&data.JournalEntry{
+		ThingID:  TopicNamespaceID(event.Topic),
+		Revision: event.Revision,
+		Event:    payload,
+	}, r.MaxEvents)
+	if err != nil {
+		logCmdError("Unable to queue event for replay", err, event, r.Logger)
+		return
+	}
+	if dropped > 0 {
+		r.Logger.Warn("The oldest queued offline events are dropped as the replay queue is full", nil,
+			watermill.LogFields{"dropped": dropped, "maxEvents": r.MaxEvents})
+	}
+}
+
+// Replay publishes the queued events in order while connected, removing the published ones from the queue.
+// The replay is stopped on disconnect or on a publishing error, the rest of the events are replayed on reconnect.
+// Returns the number of the replayed events.
+func (r *EventReplay) Replay() (int, error) {
+	var (
+		replayed int
+		last     int64 = -1
+	)
+	err := r.Storage.ForEachReplayEvent(func(sequence int64, entry *data.JournalEntry) (bool, error) {
+		if !r.isConnected() {
+			return false, nil
+		}
+		if err := r.publish(entry); err != nil {
+			return false, err
+		}
+		last = sequence
+		replayed++
+		return true, nil
+	})
+	if last >= 0 {
+		if removeErr := r.Storage.RemoveReplayEvents(last); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	return replayed, err
+}
+
+func (r *EventReplay) publish(entry *data.JournalEntry) error {
+	event := &protocol.Envelope{}
+	if err := json.Unmarshal(entry.Event, event); err != nil {
+		r.Logger.Error("Skipping invalid queued offline event", err, watermill.LogFields{"thingId": entry.ThingID})
+		return nil
+	}
+	if event.Headers == nil {
+		event.Headers = protocol.NewHeaders()
+	}
+	event.Headers = event.Headers.WithGeneric(HeaderReplayed, entry.Timestamp)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	topic := r.Topic
+	if entry.ThingID != r.DeviceID {
+		topic = fmt.Sprintf(topicReplayFormat, r.Topic, r.TenantID, entry.ThingID)
+	}
+	return r.Publisher.Publish(topic, message.NewMessage(watermill.NewUUID(), payload))
+}
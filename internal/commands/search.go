@@ -0,0 +1,319 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+	"github.com/eclipse-kanto/local-digital-twins/internal/search"
+	"github.com/pkg/errors"
+)
+
+// attributesPrefix is the prefix of the filter properties of the thing attributes.
+const attributesPrefix = "attributes/"
+
+// searchValue represents the value of the things search protocol envelopes.
+type searchValue struct {
+	SubscriptionID string        `json:"subscriptionId,omitempty"`
+	Filter         string        `json:"filter,omitempty"`
+	Options        string        `json:"options,omitempty"`
+	Namespaces     []string      `json:"namespaces,omitempty"`
+	Fields         string        `json:"fields,omitempty"`
+	Demand         int           `json:"demand,omitempty"`
+	Items          []interface{} `json:"items,omitempty"`
+	Error          *ThingError   `json:"error,omitempty"`
+}
+
+// searchSubscription contains the search result pages, which are not requested yet.
+type searchSubscription struct {
+	pages [][]interface{}
+}
+
+// searchRegistry keeps the active search subscriptions by their subscription ID.
+type searchRegistry struct {
+	mutex         sync.Mutex
+	subscriptions map[string]*searchSubscription
+}
+
+// handleSearch answers the things search protocol envelopes using the locally stored things.
+// The search subscription result is evaluated on subscribe and provided page by page on request.
+// Returns false if the envelope is not a supported search one.
+func (h *Handler) handleSearch(command *protocol.Envelope) bool {
+	value := &searchValue{}
+	if len(command.Value) > 0 {
+		if err := json.Unmarshal(command.Value, value); err != nil {
+			logCmdError("Invalid search command value", err, command, h.Logger)
+			return true
+		}
+	}
+
+	switch command.Topic.Action {
+	case protocol.ActionSubscribe:
+		h.searchSubscribe(command, value)
+
+	case protocol.ActionRequest:
+		h.searchRequest(command, value)
+
+	case protocol.ActionCount:
+		h.searchCount(command, value)
+
+	case protocol.ActionCancel:
+		state := h.shared()
+		state.search.mutex.Lock()
+		delete(state.search.subscriptions, value.SubscriptionID)
+		state.search.mutex.Unlock()
+
+	default:
+		return false
+	}
+	logCmdHandled(command, h.Logger)
+	return true
+}
+
+func (h *Handler) searchSubscribe(command *protocol.Envelope, value *searchValue) {
+	subscriptionID := watermill.NewUUID()
+
+	query, err := search.NewQuery(value.Filter, value.Namespaces, value.Options)
+	if err != nil {
+		logCmdError("Invalid search query", err, command, h.Logger)
+		h.publishSearch(command, protocol.ActionFailed, &searchValue{
+			SubscriptionID: subscriptionID,
+			Error:          searchQueryInvalidValue(err),
+		})
+		return
+	}
+
+	pages, err := h.searchPages(query, value.Fields, flatFormat(command.Headers))
+	if err != nil {
+		logCmdError("Search failed", err, command, h.Logger)
+		h.publishSearch(command, protocol.ActionFailed, &searchValue{
+			SubscriptionID: subscriptionID,
+			Error:          searchQueryInvalidValue(err),
+		})
+		return
+	}
+
+	state := h.shared()
+	state.search.mutex.Lock()
+	if state.search.subscriptions == nil {
+		state.search.subscriptions = make(map[string]*searchSubscription)
+	}
+	state.search.subscriptions[subscriptionID] = &searchSubscription{pages: pages}
+	state.search.mutex.Unlock()
+
+	h.publishSearch(command, protocol.ActionCreated, &searchValue{SubscriptionID: subscriptionID})
+}
+
+func (h *Handler) searchRequest(command *protocol.Envelope, value *searchValue) {
+	state := h.shared()
+	state.search.mutex.Lock()
+	defer state.search.mutex.Unlock()
+
+	subscription, ok := state.search.subscriptions[value.SubscriptionID]
+	if !ok {
+		h.publishSearch(command, protocol.ActionFailed, &searchValue{
+			SubscriptionID: value.SubscriptionID,
+			Error:          searchSubscriptionNotFoundValue(value.SubscriptionID),
+		})
+		return
+	}
+
+	for i := 0; i < value.Demand && len(subscription.pages) > 0; i++ {
+		h.publishSearch(command, protocol.ActionNext, &searchValue{
+			SubscriptionID: value.SubscriptionID,
+			Items:          subscription.pages[0],
+		})
+		subscription.pages = subscription.pages[1:]
+	}
+
+	if len(subscription.pages) == 0 {
+		delete(state.search.subscriptions, value.SubscriptionID)
+		h.publishSearch(command, protocol.ActionComplete, &searchValue{SubscriptionID: value.SubscriptionID})
+	}
+}
+
+// searchCount answers the count things command with the number of the stored things matching
+// the command filter and namespaces, if any.
+func (h *Handler) searchCount(command *protocol.Envelope, value *searchValue) {
+	query, err := search.NewQuery(value.Filter, value.Namespaces, "")
+	if err != nil {
+		logCmdError("Invalid count query", err, command, h.Logger)
+		h.publishSearch(command, protocol.ActionFailed, &searchValue{Error: searchQueryInvalidValue(err)})
+		return
+	}
+
+	things, err := h.searchThings(query)
+	if err != nil {
+		logCmdError("Count failed", err, command, h.Logger)
+		h.publishSearch(command, protocol.ActionFailed, &searchValue{Error: searchQueryInvalidValue(err)})
+		return
+	}
+
+	env := &protocol.Envelope{
+		Topic: &protocol.Topic{
+			Namespace: command.Topic.Namespace,
+			EntityID:  command.Topic.EntityID,
+			Group:     protocol.GroupThings,
+			Channel:   protocol.ChannelTwin,
+			Criterion: protocol.CriterionSearch,
+			Action:    protocol.ActionCount,
+		},
+		Headers: responseHeadersWithContent(command.Headers),
+		Path:    command.Path,
+		Status:  ok,
+	}
+	publishResponse(h, env.WithValue(len(things)))
+}
+
+// searchPages evaluates the query against the stored things and splits the result into pages
+// as defined by the query options. The fields selector, if any, is applied to each result item,
+// which is flattened if the flat value format is requested.
+func (h *Handler) searchPages(query *search.Query, fields string, flat bool) ([][]interface{}, error) {
+	things, err := h.searchThings(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages [][]interface{}
+	for {
+		page, cursor, err := query.Page(things)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) > 0 {
+			if len(fields) > 0 {
+				if page, err = itemsWithFields(page, fields); err != nil {
+					return nil, err
+				}
+			}
+			if flat {
+				if page, err = flatItems(page); err != nil {
+					return nil, err
+				}
+			}
+			pages = append(pages, page)
+		}
+		if len(cursor) == 0 {
+			return pages, nil
+		}
+		query.Options.Cursor = cursor
+	}
+}
+
+// searchThings returns the JSON values of the stored things matching the query, sorted as defined by the query options.
+func (h *Handler) searchThings(query *search.Query) ([]interface{}, error) {
+	ids, err := h.searchThingIDs(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var things []interface{}
+	for _, id := range ids {
+		if !query.MatchNamespace(id) {
+			continue
+		}
+		thing := model.Thing{}
+		if err := h.Storage.GetThing(id, &thing); err != nil {
+			continue // removed in the meantime
+		}
+		value, err := thingValue(&thing)
+		if err != nil {
+			return nil, err
+		}
+		things = append(things, value)
+	}
+	return query.Select(things), nil
+}
+
+// searchThingIDs returns the identifiers of the things, which could match the query filter.
+// If the filter requires indexed attributes to be equal to some values, the things are looked up
+// by the most selective index, otherwise all stored things are returned.
+func (h *Handler) searchThingIDs(query *search.Query) ([]string, error) {
+	var candidates []string
+	if query.Filter != nil {
+		for property, values := range rql.Equalities(query.Filter) {
+			if !strings.HasPrefix(property, attributesPrefix) {
+				continue
+			}
+			ids, err := h.Storage.GetIndexedThingIDs(strings.TrimPrefix(property, attributesPrefix), values...)
+			if errors.Is(err, persistence.ErrNotIndexed) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if candidates == nil || len(ids) < len(candidates) {
+				candidates = ids
+			}
+		}
+	}
+	if candidates != nil {
+		return candidates, nil
+	}
+	return h.Storage.GetThingIDs()
+}
+
+func itemsWithFields(items []interface{}, fields string) ([]interface{}, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	subset, err := jsonutil.JSONArraySubset(string(data), fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []interface{}
+	if err := json.Unmarshal([]byte(subset), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// flatItems returns the leaf values of each item mapped by their JSON pointers.
+func flatItems(items []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		flat, err := flatValue(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = flat
+	}
+	return result, nil
+}
+
+func (h *Handler) publishSearch(command *protocol.Envelope, action protocol.TopicAction, value *searchValue) {
+	env := &protocol.Envelope{
+		Topic: &protocol.Topic{
+			Namespace: command.Topic.Namespace,
+			EntityID:  command.Topic.EntityID,
+			Group:     protocol.GroupThings,
+			Channel:   protocol.ChannelTwin,
+			Criterion: protocol.CriterionSearch,
+			Action:    action,
+		},
+		Headers: responseHeadersWithContent(command.Headers),
+		Path:    command.Path,
+	}
+	publishResponse(h, env.WithValue(value))
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	searchSubscribeCmd = `{
+		"topic": "_/_/things/twin/search/subscribe",
+		"headers": {"correlation-id": "test/local-digital-twins/search"},
+		"path": "/",
+		"value": %s
+	}`
+
+	searchRequestCmd = `{
+		"topic": "_/_/things/twin/search/request",
+		"headers": {"correlation-id": "test/local-digital-twins/search"},
+		"path": "/",
+		"value": {"subscriptionId": "%s", "demand": %d}
+	}`
+
+	searchCountCmd = `{
+		"topic": "_/_/things/twin/search/count",
+		"headers": {"correlation-id": "test/local-digital-twins/search"},
+		"path": "/",
+		"value": %s
+	}`
+
+	searchCancelCmd = `{
+		"topic": "_/_/things/twin/search/cancel",
+		"headers": {"correlation-id": "test/local-digital-twins/search"},
+		"path": "/",
+		"value": {"subscriptionId": "%s"}
+	}`
+)
+
+var searchThingIDs = []string{"org.eclipse.kanto:search1", "org.eclipse.kanto:search2", "other:search3"}
+
+type SearchCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestSearchCommandsSuite(t *testing.T) {
+	suite.Run(t, new(SearchCommandsSuite))
+}
+
+func (s *SearchCommandsSuite) SetupTest() {
+	for i, thingID := range searchThingIDs {
+		s.createThing((&model.Thing{}).
+			WithIDFrom(thingID).
+			WithAttribute("floor", i).
+			WithAttribute("kind", "sensor"))
+	}
+}
+
+func (s *SearchCommandsSuite) TearDownTest() {
+	for _, thingID := range searchThingIDs {
+		s.deleteCreatedThing(thingID)
+	}
+	s.CommandsSuite.TearDownTest()
+}
+
+func (s *SearchCommandsSuite) TestSearch() {
+	msgs := s.handleCommandF(searchSubscribeCmd, `{
+		"filter": "and(eq(attributes/kind,\"sensor\"),ge(attributes/floor,0))",
+		"namespaces": ["org.eclipse.kanto", "other"],
+		"options": "sort(-attributes/floor),size(2)",
+		"fields": "thingId"
+	}`)
+	assert.Empty(s.T(), msgs)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+	require.NotEmpty(s.T(), subscriptionID)
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{"thingId": "other:search3"},
+		map[string]interface{}{"thingId": "org.eclipse.kanto:search2"},
+	}, s.pullSearch(protocol.ActionNext).Items)
+	assertPublishedNone(s.S())
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{"thingId": "org.eclipse.kanto:search1"},
+	}, s.pullSearch(protocol.ActionNext).Items)
+	assert.Equal(s.T(), subscriptionID, s.pullSearch(protocol.ActionComplete).SubscriptionID)
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	assert.Equal(s.T(), 404, s.pullSearch(protocol.ActionFailed).Error.Status)
+}
+
+func (s *SearchCommandsSuite) TestSearchFlat() {
+	s.handleCommandF(`{
+		"topic": "_/_/things/twin/search/subscribe",
+		"headers": {"correlation-id": "test/local-digital-twins/search", "value-format": "flat"},
+		"path": "/",
+		"value": %s
+	}`, `{"filter": "eq(attributes/floor,1)", "fields": "thingId,attributes"}`)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{
+			"/thingId":          "org.eclipse.kanto:search2",
+			"/attributes/floor": float64(1),
+			"/attributes/kind":  "sensor",
+		},
+	}, s.pullSearch(protocol.ActionNext).Items)
+	s.pullSearch(protocol.ActionComplete)
+}
+
+func (s *SearchCommandsSuite) TestSearchNamespaces() {
+	s.handleCommandF(searchSubscribeCmd, `{"namespaces": ["org.eclipse.kanto"]}`)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 10)
+	items := s.pullSearch(protocol.ActionNext).Items
+	require.Equal(s.T(), 2, len(items))
+	assert.Equal(s.T(), "org.eclipse.kanto:search1", items[0].(map[string]interface{})["thingId"])
+	s.pullSearch(protocol.ActionComplete)
+}
+
+func (s *SearchCommandsSuite) TestSearchNoResult() {
+	s.handleCommandF(searchSubscribeCmd, `{"filter": "eq(attributes/kind,\"actuator\")"}`)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	s.pullSearch(protocol.ActionComplete)
+	assertPublishedNone(s.S())
+}
+
+func (s *SearchCommandsSuite) TestSearchCancel() {
+	s.handleCommandF(searchSubscribeCmd, `{}`)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+
+	s.handleCommandF(searchCancelCmd, subscriptionID)
+	assertPublishedNone(s.S())
+
+	s.handleCommandF(searchRequestCmd, subscriptionID, 1)
+	assert.Equal(s.T(), "thing-search:subscription.notfound", s.pullSearch(protocol.ActionFailed).Error.Error)
+}
+
+func (s *SearchCommandsSuite) TestSearchInvalidQuery() {
+	s.handleCommandF(searchSubscribeCmd, `{"filter": "eq(attributes/kind)"}`)
+	assert.Equal(s.T(), "thing-search:query.invalid", s.pullSearch(protocol.ActionFailed).Error.Error)
+
+	s.handleCommandF(searchSubscribeCmd, `{"options": "size(1000)"}`)
+	assert.Equal(s.T(), 400, s.pullSearch(protocol.ActionFailed).Error.Status)
+}
+
+func (s *SearchCommandsSuite) TestSearchCount() {
+	for filter, count := range map[string]int{
+		`{}`:                                    3,
+		`{"namespaces": ["org.eclipse.kanto"]}`: 2,
+		`{"filter": "ge(attributes/floor,1)"}`:  2,
+		`{"filter": "eq(attributes/kind,\"actuator\")"}`: 0,
+	} {
+		assert.Empty(s.T(), s.handleCommandF(searchCountCmd, filter))
+		env := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), protocol.ActionCount, env.Topic.Action)
+		assert.Equal(s.T(), 200, env.Status)
+		assert.Equal(s.T(), "test/local-digital-twins/search", env.Headers.CorrelationID())
+		assert.Equal(s.T(), fmt.Sprint(count), string(env.Value), filter)
+	}
+
+	s.handleCommandF(searchCountCmd, `{"filter": "eq(attributes/kind)"}`)
+	assert.Equal(s.T(), "thing-search:query.invalid", s.pullSearch(protocol.ActionFailed).Error.Error)
+}
+
+func (s *SearchCommandsSuite) TestSearchIndexed() {
+	require.NoError(s.T(), s.handler.Storage.SetIndexedAttributes([]string{"floor", "kind"}))
+	defer s.handler.Storage.SetIndexedAttributes(nil)
+
+	for filter, count := range map[string]int{
+		`{"filter": "in(attributes/floor,0,2)"}`:                                       2,
+		`{"filter": "and(eq(attributes/kind,\"sensor\"),eq(attributes/floor,1))"}`:     1,
+		`{"filter": "and(eq(attributes/kind,\"sensor\"),ne(attributes/floor,1))"}`:     2,
+		`{"filter": "or(eq(attributes/floor,0),eq(attributes/floor,1))"}`:              2,
+		`{"filter": "eq(attributes/floor,2)", "namespaces": ["org.eclipse.kanto"]}`:    0,
+		`{"filter": "and(eq(attributes/kind,\"actuator\"),exists(attributes/floor))"}`: 0,
+	} {
+		assert.Empty(s.T(), s.handleCommandF(searchCountCmd, filter))
+		env := pullPublishedEnvelope(s.S())
+		assert.Equal(s.T(), 200, env.Status)
+		assert.Equal(s.T(), fmt.Sprint(count), string(env.Value), filter)
+	}
+
+	// the modified things are looked up by their current attributes
+	thing := &model.Thing{}
+	require.NoError(s.T(), s.handler.Storage.GetThing(searchThingIDs[0], thing))
+	_, err := s.handler.Storage.AddThing(thing.WithAttribute("kind", "actuator"))
+	require.NoError(s.T(), err)
+
+	s.handleCommandF(searchSubscribeCmd, `{"filter": "eq(attributes/kind,\"actuator\")", "fields": "thingId"}`)
+	subscriptionID := s.pullSearch(protocol.ActionCreated).SubscriptionID
+	s.handleCommandF(searchRequestCmd, subscriptionID, 10)
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{"thingId": searchThingIDs[0]},
+	}, s.pullSearch(protocol.ActionNext).Items)
+	s.pullSearch(protocol.ActionComplete)
+}
+
+func (s *SearchCommandsSuite) TestSearchUnsupportedAction() {
+	msgs := s.handleCommand(`{
+		"topic": "_/_/things/twin/search/unknown",
+		"path": "/"
+	}`)
+	assert.Equal(s.T(), 1, len(msgs))
+	assertPublishedNone(s.S())
+}
+
+type searchResponseValue struct {
+	SubscriptionID string               `json:"subscriptionId"`
+	Items          []interface{}        `json:"items"`
+	Error          *commands.ThingError `json:"error"`
+}
+
+func (s *SearchCommandsSuite) pullSearch(action protocol.TopicAction) *searchResponseValue {
+	env := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), protocol.CriterionSearch, env.Topic.Criterion)
+	assert.Equal(s.T(), action, env.Topic.Action)
+
+	value := &searchResponseValue{}
+	require.NoError(s.T(), json.Unmarshal(env.Value, value))
+	return value
+}
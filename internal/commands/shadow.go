@@ -0,0 +1,258 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+const defaultShadowTimeout = 10 * time.Second
+
+// ShadowVerifier verifies a sampled percentage of the locally answered retrieve commands against the cloud twin.
+// The copies of the sampled commands forwarded to the cloud require a response, which is compared
+// with the local one and any divergence is logged, so that the local twin conformance to the cloud
+// twin semantics is continuously evidenced.
+type ShadowVerifier struct {
+	// Percentage is the percentage of the verified retrieve commands, from 0 to 100.
+	Percentage float64
+	// Timeout is the time to wait for the cloud response, 10 seconds if not set.
+	Timeout time.Duration
+
+	Logger logger.Logger
+
+	mutex   sync.Mutex
+	pending map[string]*shadowCommand
+	stats   ShadowStats
+}
+
+// ShadowStats contains the numbers of the verified retrieve commands.
+type ShadowStats struct {
+	// Matched is the number of the commands with equal local and cloud responses.
+	Matched uint64 `json:"matched"`
+	// Diverged is the number of the commands with different local and cloud responses.
+	Diverged uint64 `json:"diverged"`
+	// TimedOut is the number of the commands without a cloud response in time.
+	TimedOut uint64 `json:"timedOut"`
+}
+
+// Divergence describes the difference between the local and the cloud responses of a retrieve command.
+type Divergence struct {
+	ThingID     string `json:"thingId"`
+	Path        string `json:"path"`
+	Fields      string `json:"fields,omitempty"`
+	LocalStatus int    `json:"localStatus"`
+	CloudStatus int    `json:"cloudStatus"`
+	// Diff is the JSON merge patch transforming the local response value into the cloud one.
+	Diff interface{} `json:"diff,omitempty"`
+}
+
+// shadowCommand is a verified retrieve command awaiting the cloud response.
+type shadowCommand struct {
+	command *protocol.Envelope
+	local   *protocol.Envelope
+	timer   *time.Timer
+}
+
+// Stats returns the numbers of the verified retrieve commands.
+func (v *ShadowVerifier) Stats() ShadowStats {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.stats
+}
+
+// sampled returns true if the next retrieve command is to be verified.
+func (v *ShadowVerifier) sampled() bool {
+	return v.Percentage > 0 && rand.Float64()*100 < v.Percentage
+}
+
+// await registers the local response of the command, which copy with the returned correlation ID is forwarded
+// to the cloud, and starts waiting for the cloud response.
+func (v *ShadowVerifier) await(command, local *protocol.Envelope) string {
+	correlationID := watermill.NewUUID()
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultShadowTimeout
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.pending == nil {
+		v.pending = make(map[string]*shadowCommand)
+	}
+	v.pending[correlationID] = &shadowCommand{
+		command: command,
+		local:   local,
+		timer: time.AfterFunc(timeout, func() {
+			v.timeout(correlationID)
+		}),
+	}
+	return correlationID
+}
+
+// cancel stops waiting for the cloud response, e.g. if the command copy is not forwarded.
+func (v *ShadowVerifier) cancel(correlationID string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if pending, ok := v.pending[correlationID]; ok {
+		pending.timer.Stop()
+		delete(v.pending, correlationID)
+	}
+}
+
+func (v *ShadowVerifier) timeout(correlationID string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	pending, ok := v.pending[correlationID]
+	if !ok {
+		return
+	}
+	delete(v.pending, correlationID)
+	v.stats.TimedOut++
+	v.Logger.Debugf("Cloud response of verified command with correlation ID '%s' timed out",
+		pending.command.Headers.CorrelationID())
+}
+
+// ResponseReceived compares the cloud response with the local one of the verified retrieve command.
+// Returns false if there is no command awaiting the response, i.e. it is not consumed.
+func (v *ShadowVerifier) ResponseReceived(response *protocol.Envelope) bool {
+	if response.Topic == nil || response.Status == 0 {
+		return false
+	}
+	correlationID := response.Headers.CorrelationID()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	pending, ok := v.pending[correlationID]
+	if !ok {
+		return false
+	}
+	pending.timer.Stop()
+	delete(v.pending, correlationID)
+
+	divergence := shadowDivergence(pending, response)
+	if divergence == nil {
+		v.stats.Matched++
+		return true
+	}
+	v.stats.Diverged++
+
+	fields := watermill.LogFields{
+		"thingId":       divergence.ThingID,
+		"path":          divergence.Path,
+		"correlationId": pending.command.Headers.CorrelationID(),
+		"localStatus":   divergence.LocalStatus,
+		"cloudStatus":   divergence.CloudStatus,
+	}
+	if len(divergence.Fields) > 0 {
+		fields["fields"] = divergence.Fields
+	}
+	if divergence.Diff != nil {
+		if diff, err := json.Marshal(divergence.Diff); err == nil {
+			fields["diff"] = string(diff)
+		}
+	}
+	v.Logger.Warn("Local and cloud responses of retrieve command diverge", nil, fields)
+	return true
+}
+
+// shadowDivergence returns the divergence of the local and the cloud responses, nil if they are equal.
+// The responses of different statuses are compared by status only.
+func shadowDivergence(pending *shadowCommand, cloud *protocol.Envelope) *Divergence {
+	divergence := &Divergence{
+		ThingID:     TopicNamespaceID(pending.command.Topic),
+		Path:        pending.command.Path,
+		Fields:      pending.command.Fields,
+		LocalStatus: pending.local.Status,
+		CloudStatus: cloud.Status,
+	}
+	if divergence.LocalStatus != divergence.CloudStatus {
+		return divergence
+	}
+	if pending.local.Status != ok {
+		return nil
+	}
+
+	local, localOk := echoValue(pending.local.Value)
+	remote, remoteOk := echoValue(cloud.Value)
+	if !localOk || !remoteOk {
+		return divergence
+	}
+	diff, changed := jsonutil.MergeDiff(local, remote)
+	if !changed {
+		return nil
+	}
+	divergence.Diff = diff
+	return divergence
+}
+
+// shadowForward returns the copy of the locally answered retrieve command to be forwarded to the cloud
+// for verification and the correlation ID of its awaited cloud response, nil if the command is not sampled.
+// The commands on the things with local changes not synchronized yet are not verified,
+// as their local state is expected to be ahead of the cloud one.
+func (h *Handler) shadowForward(
+	msg *message.Message, command *protocol.Envelope, output *CommandOutput,
+) (*message.Message, string) {
+	if h.Shadow == nil || output.response == nil ||
+		command.Topic.Namespace == protocol.TopicPlaceholder ||
+		command.Topic.EntityID == protocol.TopicPlaceholder ||
+		!h.Shadow.sampled() {
+		return nil, ""
+	}
+
+	data, err := h.Storage.GetSystemThingData(TopicNamespaceID(command.Topic))
+	if err == nil && (len(data.UnsynchronizedFeatures) > 0 || len(data.DeletedFeatures) > 0) {
+		return nil, ""
+	}
+
+	correlationID := h.Shadow.await(command, output.response)
+	forwardMsg := shadowCommandCopy(msg, command, h.TenantID, correlationID)
+	if forwardMsg == nil {
+		h.Shadow.cancel(correlationID)
+		return nil, ""
+	}
+	return forwardMsg, correlationID
+}
+
+// shadowCommandCopy returns the message of the command copy requiring the cloud response
+// with the provided correlation ID, nil if the copy cannot be encoded.
+func shadowCommandCopy(
+	msg *message.Message, command *protocol.Envelope, tenantID, correlationID string,
+) *message.Message {
+	shadow := *command
+	shadow.Headers = command.Headers.Clone().
+		WithCorrelationID(correlationID).
+		WithReplyTo("command/" + tenantID).
+		WithResponseRequired(true)
+	buf, err := json.Marshal(&shadow)
+	if err != nil {
+		return nil
+	}
+	newMsg := msg.Copy()
+	newMsg.Payload = buf
+	newMsg.SetContext(msg.Context())
+	return newMsg
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	shadowRetrievePropertyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "test/local-digital-twins/shadow"},
+		"path": "/features/meter/properties/x"
+	}`
+
+	shadowRetrievePropertyResponse = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		"headers": {"correlation-id": "%s"},
+		"path": "/features/meter/properties/x",
+		"value": %s,
+		"status": %d
+	}`
+)
+
+type ShadowCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestShadowCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ShadowCommandsSuite))
+}
+
+func (s *ShadowCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperties(map[string]interface{}{"x": 10}))
+
+	s.handler.Shadow = &commands.ShadowVerifier{
+		Percentage: 100,
+		Timeout:    time.Second,
+		Logger:     s.handler.Logger,
+	}
+}
+
+func (s *ShadowCommandsSuite) TearDownTest() {
+	s.handler.Shadow = nil
+	s.CommandsSuite.TearDownTest()
+}
+
+func (s *ShadowCommandsSuite) TestResponsesMatched() {
+	correlationID := s.retrieveVerified()
+
+	assert.True(s.T(), s.cloudResponse(correlationID, "10", 200))
+	assert.Equal(s.T(), commands.ShadowStats{Matched: 1}, s.handler.Shadow.Stats())
+
+	// already verified
+	assert.False(s.T(), s.cloudResponse(correlationID, "10", 200))
+}
+
+func (s *ShadowCommandsSuite) TestResponsesDiverged() {
+	correlationID := s.retrieveVerified()
+	assert.True(s.T(), s.cloudResponse(correlationID, "11", 200))
+
+	correlationID = s.retrieveVerified()
+	assert.True(s.T(), s.cloudResponse(correlationID, "null", 404))
+
+	assert.Equal(s.T(), commands.ShadowStats{Diverged: 2}, s.handler.Shadow.Stats())
+}
+
+func (s *ShadowCommandsSuite) TestResponseTimedOut() {
+	s.handler.Shadow.Timeout = 10 * time.Millisecond
+	correlationID := s.retrieveVerified()
+
+	assert.Eventually(s.T(), func() bool {
+		return s.handler.Shadow.Stats().TimedOut == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.False(s.T(), s.cloudResponse(correlationID, "10", 200))
+}
+
+func (s *ShadowCommandsSuite) TestNotVerified() {
+	s.handler.Shadow.Percentage = 0
+	s.assertNotVerified()
+
+	// the local state is ahead of the cloud one
+	s.handler.Shadow.Percentage = 100
+	_, err := s.handler.Storage.AddFeature(testThingID, "unsynchronized", &model.Feature{})
+	require.NoError(s.T(), err)
+	s.assertNotVerified()
+
+	assert.Equal(s.T(), commands.ShadowStats{}, s.handler.Shadow.Stats())
+}
+
+func (s *ShadowCommandsSuite) assertNotVerified() {
+	forwarded := s.retrieveForwarded()
+	assert.Equal(s.T(), "test/local-digital-twins/shadow", forwarded.Headers.CorrelationID())
+	assert.False(s.T(), forwarded.Headers.ResponseRequired())
+}
+
+// retrieveVerified handles the retrieve command and returns the correlation ID of its forwarded copy.
+func (s *ShadowCommandsSuite) retrieveVerified() string {
+	forwarded := s.retrieveForwarded()
+	assert.True(s.T(), forwarded.Headers.ResponseRequired())
+	assert.Equal(s.T(), "command/"+testThingID, forwarded.Headers.ReplyTo())
+
+	correlationID := forwarded.Headers.CorrelationID()
+	assert.NotEqual(s.T(), "test/local-digital-twins/shadow", correlationID)
+	return correlationID
+}
+
+// retrieveForwarded handles the retrieve command and returns the command forwarded to the cloud.
+func (s *ShadowCommandsSuite) retrieveForwarded() *protocol.Envelope {
+	s.handleCommandF(shadowRetrievePropertyCmd)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.Equal(s.T(), "test/local-digital-twins/shadow", response.Headers.CorrelationID())
+	assertPublishedNone(s.S())
+
+	buffer := s.handler.HonoPub.(*testPublisher).buffer
+	require.Equal(s.T(), 1, buffer.Len())
+	msg := buffer.Remove(buffer.Front()).(*message.Message)
+
+	forwarded := &protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal(msg.Payload, forwarded))
+	assert.Equal(s.T(), protocol.ActionRetrieve, forwarded.Topic.Action)
+	return forwarded
+}
+
+func (s *ShadowCommandsSuite) cloudResponse(correlationID, value string, status int) bool {
+	response := &protocol.Envelope{}
+	payload := fmt.Sprintf(shadowRetrievePropertyResponse, correlationID, value, status)
+	require.NoError(s.T(), json.Unmarshal([]byte(payload), response))
+	return s.handler.Shadow.ResponseReceived(response)
+}
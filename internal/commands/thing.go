@@ -15,22 +15,39 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/search"
 	"github.com/pkg/errors"
 )
 
 const (
-	thingIDs = "thingIds"
+	thingIDs      = "thingIds"
+	thingsOptions = "options"
+
+	copyPolicyFromPrefix = "{{ ref:things/"
+	copyPolicyFromSuffix = "/policyId }}"
 )
 
+// copyPolicyFromValue is the create thing command value field referencing the policy to be applied to the new thing.
+type copyPolicyFromValue struct {
+	CopyPolicyFrom string `json:"_copyPolicyFrom"`
+}
+
+// thingsPage is the retrieve multiple things response value if sort or pagination options are provided.
+type thingsPage struct {
+	Items  []interface{} `json:"items"`
+	Cursor string        `json:"cursor,omitempty"`
+}
+
 // createThing handles create thing commands and builds the command output.
 func createThing(h *Handler, cmd *Command, out *CommandOutput) {
 	thing := commandThing(cmd.thingID, cmd.envelope, out)
-	if thing != nil {
+	if thing != nil && copyPolicyFrom(h, cmd.envelope, thing, out) {
 		thingData := model.Thing{}
 		if err := h.Storage.GetThingData(cmd.thingID, &thingData); err != nil {
 			performModifyThing(h, cmd.envelope, thing, created, protocol.ActionCreated, out)
@@ -53,54 +70,221 @@ func modifyThing(h *Handler, cmd *Command, out *CommandOutput) {
 	}
 }
 
+// mergeThing handles merge thing commands and builds the command output.
+// The command value is applied as JSON merge patch to the whole thing, including
+// the properties and desired properties of all its features, as a single storage update.
+func mergeThing(h *Handler, cmd *Command, out *CommandOutput) {
+	thing, err := h.LoadThing(cmd.thingID, cmd.envelope)
+	if err != nil {
+		out.response = h.resourceNotFound("Merge thing failed. Unknown thing",
+			err, cmd.envelope, cmd.thingID, noValue)
+		return
+	}
+
+	merged := model.Thing{}
+	if err := mergeCommandValue(cmd.envelope, thing, &merged, out); err != nil {
+		return
+	}
+
+	if merged.ID == nil || merged.ID.String() != cmd.thingID {
+		out.response = NewIDNotSettableError(cmd.envelope)
+		return
+	}
+	keepMetadata(thing, &merged)
+
+	if rev, err := h.Storage.AddThing(&merged); err != nil {
+		out.response = commandUnknownError("Merge thing failed", err, cmd.envelope, h.Logger)
+	} else {
+		out.response = withETag(responseEnvelope(cmd.envelope, modified), revisionETag(rev))
+		out.event = h.eventEnvelope(cmd.thingID, cmd.envelope, protocol.ActionMerged)
+		out.thingID = cmd.thingID
+		out.revision = rev
+	}
+}
+
 // retrieveThing handles retrieve a thing or list of things when multiple thing IDs provided
-// commands and builds the command output.
+// commands and builds the command output. Only the thing state not synchronized with the hub
+// is retrieved if the 'unsynchronized' header is set.
 func retrieveThing(h *Handler, cmd *Command, out *CommandOutput) {
 	if cmd.envelope.Topic.Namespace == protocol.TopicPlaceholder ||
 		cmd.envelope.Topic.EntityID == protocol.TopicPlaceholder {
 		retrieveThings(h, cmd, out)
+	} else if unsynchronizedRequested(cmd) {
+		retrieveUnsynchronized(h, cmd, out)
+	} else if len(cmd.envelope.Fields) == 0 {
+		thing, err := h.encodedThing(cmd.thingID)
+		if err != nil {
+			out.response = h.thingNotFound("Retrieve thing failed", err, cmd.envelope, cmd.thingID)
+			return
+		}
+
+		out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, thing)
+		if out.response != nil {
+			withETag(out.response, revisionETag(thing.Revision))
+		}
 	} else {
 		thing := model.Thing{}
 
-		if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		var err error
+		if featureIDs, all := selectedFeatures(cmd.envelope.Fields); all {
+			err = h.Storage.GetThing(cmd.thingID, &thing)
+		} else {
+			err = h.retrieveThingFeatures(cmd.thingID, &thing, featureIDs)
+		}
+		if err != nil {
 			out.response = h.thingNotFound("Retrieve thing failed", err, cmd.envelope, cmd.thingID)
 			return
 		}
 
-		if len(cmd.envelope.Fields) == 0 {
-			out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, thing)
-		} else {
-			out.response = h.responseEnvelopeWithFields(cmd.envelope, thing)
+		out.response = h.responseEnvelopeWithFields(cmd.envelope, thing)
+		if out.response != nil && out.response.Status == ok {
+			withETag(out.response, revisionETag(thing.Revision))
+		}
+	}
+}
+
+// retrieveThingFeatures loads the thing data with only the selected features that are stored,
+// skipping the lookups of the features that are not present.
+func (h *Handler) retrieveThingFeatures(thingID string, thing *model.Thing, featureIDs []string) error {
+	storedIDs, err := h.Storage.GetFeatureIDs(thingID)
+	if err != nil {
+		return err
+	}
+
+	var presentIDs []string
+	for _, featureID := range featureIDs {
+		if i := sort.SearchStrings(storedIDs, featureID); i < len(storedIDs) && storedIDs[i] == featureID {
+			presentIDs = append(presentIDs, featureID)
+		}
+	}
+	return h.Storage.GetThingFeatures(thingID, thing, presentIDs...)
+}
+
+// encodedThing is the thing representation with its features encoded one by one as loaded,
+// so that the features of a large thing are not kept decoded in memory all at once.
+type encodedThing struct {
+	*model.Thing
+	Features map[string]json.RawMessage `json:"features,omitempty"`
+}
+
+func (h *Handler) encodedThing(thingID string) (*encodedThing, error) {
+	thing := &encodedThing{Thing: &model.Thing{}}
+	if err := h.Storage.GetThingData(thingID, thing.Thing); err != nil {
+		return nil, err
+	}
+
+	err := h.Storage.ForEachFeature(thingID, func(featureID string, feature *model.Feature) (bool, error) {
+		value, err := json.Marshal(feature)
+		if err != nil {
+			return false, err
+		}
+		if thing.Features == nil {
+			thing.Features = make(map[string]json.RawMessage)
+		}
+		thing.Features[featureID] = value
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thing, nil
+}
+
+// selectedFeatures returns the IDs of the features selected by the fields selector or true if all features
+// are selected, e.g. by the 'features' or the '_metadata' fields. An invalid selector selects all features,
+// so that it is reported as invalid on selecting the thing fields.
+func selectedFeatures(fields string) ([]string, bool) {
+	pointers, err := jsonutil.SelectorToJSONPointers(fields)
+	if err != nil {
+		return nil, true
+	}
+
+	var featureIDs []string
+	prefix := "/" + segmentFeatures + "/"
+	for _, pointer := range pointers {
+		if pointer == "/"+segmentFeatures || strings.HasPrefix(pointer, "/"+fieldMetadata) {
+			return nil, true
+		}
+		if strings.HasPrefix(pointer, prefix) {
+			featureID := strings.SplitN(pointer[len(prefix):], "/", 2)[0]
+			featureIDs = append(featureIDs, featureID)
 		}
 	}
+	return featureIDs, false
 }
 
 // retrieveThings handles retrieve multiple things commands and builds the command output.
+// If 'options' are provided, e.g. 'sort(-attributes/floor),size(10)', the found things are sorted and
+// paginated, i.e. a single page of items is provided with the cursor of the next page, if any.
+// If no 'thingIds' are provided, all locally stored things are retrieved.
 func retrieveThings(h *Handler, cmd *Command, out *CommandOutput) {
-	var cmdValue map[string][]string
-	if err := json.Unmarshal(cmd.envelope.Value, &cmdValue); err != nil {
-		out.response = NewInvalidJSONValueError(cmd.envelope, err)
-	} else {
-		thingIds := cmdValue[thingIDs]
-		if len(thingIds) == 0 {
-			out.response = NewInvalidJSONValueError(cmd.envelope,
-				errors.New(fmt.Sprintf("Empty '%s' value", thingIDs)))
-		} else {
-			out.response = doRetrieveThings(h, cmd.envelope, thingIds)
+	var cmdValue map[string]json.RawMessage
+	var thingIds []string
+	var options string
+	if len(cmd.envelope.Value) > 0 {
+		if err := json.Unmarshal(cmd.envelope.Value, &cmdValue); err != nil {
+			out.response = NewInvalidJSONValueError(cmd.envelope, err)
+			return
 		}
 	}
+	value, hasIDs := cmdValue[thingIDs]
+	if hasIDs {
+		if err := json.Unmarshal(value, &thingIds); err != nil {
+			out.response = NewInvalidJSONValueError(cmd.envelope, err)
+			return
+		}
+	}
+	if value, ok := cmdValue[thingsOptions]; ok {
+		if err := json.Unmarshal(value, &options); err != nil {
+			out.response = NewInvalidJSONValueError(cmd.envelope, err)
+			return
+		}
+	}
+
+	if !hasIDs {
+		var err error
+		if thingIds, err = h.Storage.GetThingIDs(); err != nil {
+			out.response = commandUnknownError("Retrieve all things failed", err, cmd.envelope, h.Logger)
+			return
+		}
+		sort.Strings(thingIds)
+	} else if len(thingIds) == 0 {
+		out.response = NewInvalidJSONValueError(cmd.envelope,
+			errors.New(fmt.Sprintf("Empty '%s' value", thingIDs)))
+		return
+	}
+
+	if len(options) > 0 {
+		out.response = doRetrieveThingsPage(h, cmd.envelope, thingIds, options)
+	} else {
+		out.response = doRetrieveThings(h, cmd.envelope, thingIds)
+	}
 }
 
 // deleteThing handles delete thing commands and builds the command output.
+// The thing tombstone is kept until the deletion is forwarded to the hub.
 func deleteThing(h *Handler, cmd *Command, out *CommandOutput) {
-	if thing, err := h.LoadThing(cmd.thingID, cmd.envelope); err != nil {
+	thing, err := h.LoadThing(cmd.thingID, cmd.envelope)
+	if err != nil {
 		out.response = h.resourceNotFound("Delete thing failed. Unknown thing",
 			err, cmd.envelope, cmd.thingID, noValue)
+		return
+	}
 
-	} else {
-		out.response = responseEnvelope(cmd.envelope, deleted)
-		out.event = eventEnvelope(cmd.envelope, thing.Revision, thing.Timestamp, protocol.ActionDeleted)
+	sysData, err := h.Storage.GetSystemThingData(cmd.thingID)
+	if err == nil {
+		err = h.Storage.RemoveThing(cmd.thingID)
+	}
+	if err != nil {
+		out.response = commandUnknownError("Delete thing failed", err, cmd.envelope, h.Logger)
+		return
 	}
+
+	out.response = responseEnvelope(cmd.envelope, deleted)
+	out.event = eventEnvelope(cmd.envelope, thing, protocol.ActionDeleted)
+	out.thingID = cmd.thingID
+	out.revision = sysData.Revision
+	out.thingDeleted = true
 }
 
 func commandThing(thingID string, env *protocol.Envelope, out *CommandOutput) *model.Thing {
@@ -122,6 +306,38 @@ func commandThing(thingID string, env *protocol.Envelope, out *CommandOutput) *m
 	return &thing
 }
 
+// copyPolicyFrom applies the policy ID referenced by the command value '_copyPolicyFrom' field, if any.
+// The reference is either a policy ID or a '{{ ref:things/<thingId>/policyId }}' placeholder,
+// which is resolved to the policy ID of the locally stored thing.
+// Returns false if the reference cannot be applied and the error response is set.
+func copyPolicyFrom(h *Handler, env *protocol.Envelope, thing *model.Thing, out *CommandOutput) bool {
+	value := copyPolicyFromValue{}
+	if err := json.Unmarshal(env.Value, &value); err != nil || len(value.CopyPolicyFrom) == 0 {
+		return true
+	}
+
+	if thing.PolicyID != nil {
+		out.response = NewPolicyIDNotAllowedError(env, thing.ID.String())
+		return false
+	}
+
+	ref := strings.TrimSpace(value.CopyPolicyFrom)
+	if !strings.HasPrefix(ref, copyPolicyFromPrefix) || !strings.HasSuffix(ref, copyPolicyFromSuffix) {
+		thing.WithPolicyIDFrom(ref)
+		return true
+	}
+
+	refThingID := strings.TrimSuffix(strings.TrimPrefix(ref, copyPolicyFromPrefix), copyPolicyFromSuffix)
+	refThing := model.Thing{}
+	if err := h.Storage.GetThingData(refThingID, &refThing); err != nil {
+		out.response = h.thingNotFound("Create thing failed. Unknown thing to copy policy from",
+			err, env, refThingID)
+		return false
+	}
+	thing.WithPolicyID(refThing.PolicyID)
+	return true
+}
+
 func performModifyThing(h *Handler, env *protocol.Envelope, thing *model.Thing,
 	status int, action protocol.TopicAction, out *CommandOutput) {
 	if rev, err := h.Storage.AddThing(thing); err != nil {
@@ -132,6 +348,7 @@ func performModifyThing(h *Handler, env *protocol.Envelope, thing *model.Thing,
 		} else {
 			out.response = responseEnvelope(env, status)
 		}
+		withETag(out.response, revisionETag(rev))
 
 		out.event = eventThingCreatedEnvelope(env, action, thing)
 
@@ -142,21 +359,78 @@ func performModifyThing(h *Handler, env *protocol.Envelope, thing *model.Thing,
 
 func doRetrieveThings(h *Handler, env *protocol.Envelope, thingIds []string) *protocol.Envelope {
 	thingsArray := make([]model.Thing, 0)
-	for _, thingID := range thingIds {
+	found := make([]bool, len(thingIds))
+	for i, thingID := range thingIds {
 		if !strings.Contains(thingID, ":") {
 			return NewIDInvalidError(env, thingID)
 		}
 		thing := model.Thing{}
 		if err := h.Storage.GetThing(thingID, &thing); err == nil {
 			thingsArray = append(thingsArray, thing)
+			found[i] = true
 		}
 	}
 
-	if len(env.Fields) == 0 {
+	if len(env.Fields) != 0 {
+		var errEnv *protocol.Envelope
+		if thingsArray, errEnv = h.thingsWithFields(env, thingsArray); errEnv != nil {
+			return errEnv
+		}
+	}
+
+	if len(thingsArray) == 0 || len(thingsArray) == len(thingIds) {
 		return ResponseEnvelopeWithValue(env, ok, thingsArray)
 	}
 
-	return h.responseEnvelopeWithFieldsArr(env, thingsArray)
+	// partially retrieved, report the status of each requested thing
+	items := make([]*MultiStatusItem, len(thingIds))
+	next := 0
+	for i, thingID := range thingIds {
+		if found[i] {
+			items[i] = NewMultiStatusItem(thingID, ok, thingsArray[next])
+			next++
+		} else {
+			items[i] = NewMultiStatusErrorItem(thingID, thingNotFoundValue(thingID))
+		}
+	}
+	return NewMultiStatusResponse(env, items)
+}
+
+func doRetrieveThingsPage(h *Handler, env *protocol.Envelope, thingIds []string, options string) *protocol.Envelope {
+	query, err := search.NewQuery(noValue, nil, options)
+	if err != nil {
+		return NewInvalidOptionsError(env, err)
+	}
+
+	things := make([]interface{}, 0)
+	for _, thingID := range thingIds {
+		if !strings.Contains(thingID, ":") {
+			return NewIDInvalidError(env, thingID)
+		}
+		thing := model.Thing{}
+		if err := h.Storage.GetThing(thingID, &thing); err == nil {
+			value, err := thingValue(&thing)
+			if err != nil {
+				return commandUnknownError("Thing marshal error", err, env, h.Logger)
+			}
+			things = append(things, value)
+		}
+	}
+
+	if len(query.Options.Sort) > 0 {
+		query.Sort(things)
+	}
+	page, cursor, err := query.Page(things)
+	if err != nil {
+		return NewInvalidOptionsError(env, err)
+	}
+
+	if len(env.Fields) != 0 {
+		if page, err = itemsWithFields(page, env.Fields); err != nil {
+			return h.invalidFieldSelector("Invalid field selector", err, env)
+		}
+	}
+	return ResponseEnvelopeWithValue(env, ok, &thingsPage{Items: page, Cursor: cursor})
 }
 
 func (h *Handler) conflictError(msg string, err error, env *protocol.Envelope, thingID string, featureID string,
@@ -179,7 +453,7 @@ func (h *Handler) invalidFieldSelector(msg string, err error, env *protocol.Enve
 }
 
 func (h *Handler) responseEnvelopeWithFields(env *protocol.Envelope, thing model.Thing) *protocol.Envelope {
-	thingByte, err := json.Marshal(thing)
+	thingByte, err := json.Marshal(h.thingSpecialFields(&thing, env.Fields))
 	if err != nil {
 		return commandUnknownError("Thing marshal error", err, env, h.Logger)
 	}
@@ -189,27 +463,27 @@ func (h *Handler) responseEnvelopeWithFields(env *protocol.Envelope, thing model
 		return h.invalidFieldSelector("Invalid field selector", err, env)
 	}
 
-	fieldsThing := model.Thing{}
+	fieldsThing := thingWithSpecialFields{}
 	if err := json.Unmarshal([]byte(str), &fieldsThing); err != nil {
 		return commandUnknownError("Thing unmarshal error", err, env, h.Logger)
 	}
 	return ResponseEnvelopeWithValue(env, ok, fieldsThing)
 }
 
-func (h *Handler) responseEnvelopeWithFieldsArr(env *protocol.Envelope, things []model.Thing) *protocol.Envelope {
+func (h *Handler) thingsWithFields(env *protocol.Envelope, things []model.Thing) ([]model.Thing, *protocol.Envelope) {
 	marshalArray, err := json.Marshal(things)
 	if err != nil {
-		return commandUnknownError("Things array marshal error", err, env, h.Logger)
+		return nil, commandUnknownError("Things array marshal error", err, env, h.Logger)
 	}
 
 	subArray, err := jsonutil.JSONArraySubset(string(marshalArray), env.Fields)
 	if err != nil {
-		return h.invalidFieldSelector("Invalid field selector", err, env)
+		return nil, h.invalidFieldSelector("Invalid field selector", err, env)
 	}
 
 	subThingsArray := make([]model.Thing, 0)
 	if err := json.Unmarshal([]byte(subArray), &subThingsArray); err != nil {
-		return commandUnknownError("Things array unmarshal error", err, env, h.Logger)
+		return nil, commandUnknownError("Things array unmarshal error", err, env, h.Logger)
 	}
-	return ResponseEnvelopeWithValue(env, ok, subThingsArray)
+	return subThingsArray, nil
 }
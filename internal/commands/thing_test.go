@@ -13,11 +13,16 @@
 package commands_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -108,7 +113,8 @@ func (s *ThingCommandsSuite) TestCreateThing() {
 	s.getThing(&thingOut)
 	assert.NotNil(s.T(), thingOut)
 
-	assertPublishedSkipVersioning(s.S(), withHeadersThingValueF(response),
+	assertPublishedSkipVersioning(s.S(),
+		withETag(s.T(), withHeadersThingValueF(response), revisionETag(thingOut.Revision)),
 		s.asEnvelope(withHeadersThingValueF(event)))
 }
 
@@ -144,7 +150,9 @@ func (s *ThingCommandsSuite) TestCreateThingSkipThingID() {
 
 	s.handleCommandF(command, defaultHeaders)
 
-	assertPublishedSkipVersioning(s.S(), withResponseHeadersF(response),
+	thingOut := model.Thing{}
+	require.NoError(s.T(), s.handler.Storage.GetThing("org.eclipse.kanto:test2", &thingOut))
+	assertPublishedSkipVersioning(s.S(), withETag(s.T(), withResponseHeadersF(response), revisionETag(thingOut.Revision)),
 		s.asEnvelopeWithID(withResponseHeadersF(event), "org.eclipse.kanto:test2"))
 }
 
@@ -202,6 +210,46 @@ func (s *ThingCommandsSuite) TestCreateThingNoSettableError() {
 	assertPublishedOnErrorF(s.S(), response)
 }
 
+func (s *ThingCommandsSuite) TestCreateThingCopyPolicyFrom() {
+	refThingID := "org.eclipse.kanto:ref"
+	s.createThing((&model.Thing{}).WithIDFrom(refThingID).WithPolicyIDFrom("org.eclipse.kanto:ref_policy"))
+	defer s.deleteCreatedThing(refThingID)
+
+	s.handleCommandF(createThingCmd, defaultHeaders,
+		`"value": {"_copyPolicyFrom": "{{ ref:things/org.eclipse.kanto:ref/policyId }}"}`)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), "org.eclipse.kanto:ref_policy", thing.PolicyID.String())
+}
+
+func (s *ThingCommandsSuite) TestCreateThingCopyPolicyFromPolicyID() {
+	s.handleCommandF(createThingCmd, defaultHeaders, `"value": {"_copyPolicyFrom": "org.eclipse.kanto:other_policy"}`)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 201, response.Status)
+
+	thing := model.Thing{}
+	s.getThing(&thing)
+	assert.Equal(s.T(), "org.eclipse.kanto:other_policy", thing.PolicyID.String())
+}
+
+func (s *ThingCommandsSuite) TestCreateThingCopyPolicyFromErrors() {
+	s.handleCommandF(createThingCmd, defaultHeaders,
+		`"value": {"_copyPolicyFrom": "{{ ref:things/org.eclipse.kanto:unknown/policyId }}"}`)
+	s.assertErrorResponse(404, "things:thing.notfound")
+
+	s.handleCommandF(createThingCmd, defaultHeaders, `"value": {
+		"policyId": "org.eclipse.kanto:the_policy_id",
+		"_copyPolicyFrom": "org.eclipse.kanto:other_policy"
+	}`)
+	s.assertErrorResponse(400, "things:policyId.notallowed")
+
+	thing := model.Thing{}
+	assert.Error(s.T(), s.handler.Storage.GetThing(testThingID, &thing))
+}
+
 func (s *ThingCommandsSuite) TestCreateThingJsonInvalid() {
 	command := `{
 		"topic": "org.eclipse.kanto/test/things/twin/commands/create",
@@ -257,7 +305,8 @@ func (s *ThingCommandsSuite) TestModifyCreateThing() {
 	s.getThing(&thingOut)
 	assert.NotNil(s.T(), thingOut)
 
-	assertPublishedSkipVersioning(s.S(), withHeadersThingValueF(response),
+	assertPublishedSkipVersioning(s.S(),
+		withETag(s.T(), withHeadersThingValueF(response), revisionETag(thingOut.Revision)),
 		s.asEnvelope(withHeadersThingValueF(event)))
 }
 
@@ -299,7 +348,8 @@ func (s *ThingCommandsSuite) TestModifyThing() {
 	s.getThing(&thingOut)
 	assert.NotNil(s.T(), thingOut)
 
-	assertPublishedSkipVersioning(s.S(), withHeadersNoResponseRequired(response),
+	assertPublishedSkipVersioning(s.S(),
+		withETag(s.T(), withHeadersNoResponseRequired(response), revisionETag(thingOut.Revision)),
 		s.asEnvelopeWithValueF(event))
 }
 
@@ -357,6 +407,83 @@ func (s *ThingCommandsSuite) TestModifyThingJsonInvalid() {
 	assertPublishedOnErrorF(s.S(), response)
 }
 
+func (s *ThingCommandsSuite) TestMergeThing() {
+	command := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/",
+		"value": {
+			"policyId": "org.eclipse.kanto:the_policy_id",
+			"features": {
+				"meter": {"desiredProperties": {"x": 5}},
+				"sensor": {"properties": {"y": 1}, "desiredProperties": {"y": 2}}
+			}
+		}
+	}`
+	response := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/",
+		"status": 204
+	}`
+	event := `{
+		"topic": "org.eclipse.kanto/test/things/twin/events/merged",
+		%s,
+		"path": "/",
+		"value": {
+			"policyId": "org.eclipse.kanto:the_policy_id",
+			"features": {
+				"meter": {"desiredProperties": {"x": 5}},
+				"sensor": {"properties": {"y": 1}, "desiredProperties": {"y": 2}}
+			}
+		}
+	}`
+
+	s.addThing(featuresAsMapValue(s.T(), `{"meter": {"properties": {"x": 1}}}`))
+
+	s.handleCommandF(command, defaultHeaders)
+
+	thingOut := model.Thing{}
+	s.getThing(&thingOut)
+	assert.Equal(s.T(), "org.eclipse.kanto:the_policy_id", thingOut.PolicyID.String())
+	assert.EqualValues(s.T(), featuresAsMapValue(s.T(), `{
+		"meter": {"properties": {"x": 1}, "desiredProperties": {"x": 5}},
+		"sensor": {"properties": {"y": 1}, "desiredProperties": {"y": 2}}
+	}`), thingOut.Features)
+
+	assertPublished(s.S(),
+		withETag(s.T(), withHeadersNoResponseRequired(response), revisionETag(thingOut.Revision)),
+		s.asEnvelopeWithValueF(event))
+}
+
+func (s *ThingCommandsSuite) TestMergeThingNoSettableError() {
+	command := `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/merge",
+		%s,
+		"path": "/",
+		"value": {
+			"thingId": "org.eclipse.kanto:unknown"
+		}
+	}`
+	response := `{
+		"topic": "org.eclipse.kanto/test/things/twin/errors",
+		%s,
+		"path": "/",
+		"value": {
+			"status": 400,
+			"error": "things:id.notsettable",
+			"message": "The Thing ID in the command value is not equal to the Thing ID in the command topic.",
+			"description": "Either delete the Thing ID from the command value or use the same Thing ID as in the command topic."
+		},
+		"status": 400
+	}`
+
+	s.addThing(nil)
+	s.handleCommandF(command, defaultHeaders)
+
+	assertPublishedOnErrorF(s.S(), response)
+}
+
 func (s *ThingCommandsSuite) TestRetrieveThing() {
 	response := `{
 		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
@@ -392,7 +519,9 @@ func (s *ThingCommandsSuite) TestRetrieveThing() {
 	s.createThing(thing)
 
 	s.handleCommandF(retrieveThingCmd, defaultHeaders)
-	assertPublishedSkipVersioning(s.S(), s.asEnvelope(withHeadersThingValueF(response)))
+	expected := s.asEnvelope(withHeadersThingValueF(response))
+	expected.Headers.WithETag(revisionETag(expected.Revision))
+	assertPublishedSkipVersioning(s.S(), expected)
 }
 
 func (s *ThingCommandsSuite) TestRetrieveThingWithFields() {
@@ -443,8 +572,9 @@ func (s *ThingCommandsSuite) TestRetrieveThingWithFields() {
 			}
 		}
 	}`
-	assertPublishedSkipVersioning(
-		s.S(), s.asEnvelope(fmt.Sprintf(response, responseHeaders, thingWithFieldsValue)))
+	expected := s.asEnvelope(fmt.Sprintf(response, responseHeaders, thingWithFieldsValue))
+	expected.Headers.WithETag(revisionETag(expected.Revision))
+	assertPublishedSkipVersioning(s.S(), expected)
 }
 
 func (s *ThingCommandsSuite) TestRetrieveThingNotFoundError() {
@@ -514,8 +644,35 @@ func (s *ThingCommandsSuite) TestDeleteThing() {
 	s.addTestThing()
 
 	s.handleCommandF(deleteThingCmd, defaultHeaders)
-	assertPublishedSkipVersioning(s.S(), withHeadersNoResponseRequired(response),
-		s.asEnvelopeNoValueF(event))
+	expected := &protocol.Envelope{}
+	require.NoError(s.T(), json.Unmarshal([]byte(withHeadersNoResponseRequired(event)), expected))
+	assertPublishedSkipVersioning(s.S(), withHeadersNoResponseRequired(response), expected)
+
+	// the thing is removed locally and its deletion is forwarded to the hub
+	assert.ErrorIs(s.T(), s.handler.Storage.GetThingData(testThingID, &model.Thing{}), persistence.ErrThingNotFound)
+	tombstones := &data.TombstonesData{}
+	if err := s.handler.Storage.GetTombstones(tombstones); err != nil {
+		require.ErrorIs(s.T(), err, persistence.ErrNotFound)
+	}
+	assert.NotContains(s.T(), tombstones.Things, testThingID)
+}
+
+func (s *ThingCommandsSuite) TestDeleteThingOffline() {
+	s.addTestThing()
+
+	hono := s.handler.HonoPub
+	defer func() {
+		s.handler.HonoPub = hono
+	}()
+	s.handler.HonoPub = &offlinePublisher{}
+
+	s.handleCommandF(deleteThingCmd, defaultHeaders)
+	assert.ErrorIs(s.T(), s.handler.Storage.GetThingData(testThingID, &model.Thing{}), persistence.ErrThingNotFound)
+
+	// the tombstone is kept until the deletion is synchronized on reconnect
+	tombstones := &data.TombstonesData{}
+	require.NoError(s.T(), s.handler.Storage.GetTombstones(tombstones))
+	assert.Contains(s.T(), tombstones.Things, testThingID)
 }
 
 func (s *ThingCommandsSuite) TestDeleteThingNotFoundError() {
@@ -13,9 +13,13 @@
 package commands_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -235,7 +239,7 @@ func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsInvalidJSONError() {
 		%s,
 		"path": "/",
 		"value": {
-			"test": []
+			"thingIds": []
 		}
 	}`
 
@@ -255,6 +259,28 @@ func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsInvalidJSONError() {
 	assertPublishedSkipVersioning(s.S(), withResponseHeadersF(response))
 }
 
+func (s *ThingsCommandsSuite) TestRetrieveAllThings() {
+	retrieveAllThingsCmd := `{
+		"topic": "_/_/things/twin/commands/retrieve",
+		%s,
+		"path": "/",
+		"fields": "thingId"
+	}`
+
+	thingIDs := []string{testThingID, "org.eclipse.kanto:testAll"}
+	for _, thingID := range thingIDs {
+		s.createThing((&model.Thing{}).WithIDFrom(thingID).WithAttribute("location", "edge"))
+	}
+	defer s.deleteCreatedThing(thingIDs[1])
+
+	s.handleCommandF(retrieveAllThingsCmd, defaultHeaders)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(),
+		`[{"thingId": "org.eclipse.kanto:test"}, {"thingId": "org.eclipse.kanto:testAll"}]`,
+		string(response.Value))
+}
+
 func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsIDsNotArrayValue() {
 	retrieveAllThingsCmd := `{
 		"topic": "_/_/things/twin/commands/retrieve",
@@ -380,3 +406,114 @@ func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsWithNotExistingIDs() {
 	s.handleCommandF(retrieveAllThingsCmd, defaultHeaders)
 	assertPublishedSkipVersioning(s.S(), withResponseHeadersF(response))
 }
+
+func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsPartially() {
+	retrieveThingsCmd := `{
+		"topic": "_/_/things/twin/commands/retrieve",
+		%s,
+		"path": "/",
+		"fields": "thingId,attributes",
+		"value": {
+			"thingIds": [
+				"org.eclipse.kanto:testNotExisting",
+				"org.eclipse.kanto:test"
+			]
+		}
+	}`
+
+	response := `{
+		"topic": "_/_/things/twin/commands/retrieve",
+		%s,
+		"path": "/",
+		"fields": "thingId,attributes",
+		"value": [
+			{
+				"id": "org.eclipse.kanto:testNotExisting",
+				"status": 404,
+				"error": {
+					"status": 404,
+					"error": "things:thing.notfound",
+					"message": "The Thing with ID 'org.eclipse.kanto:testNotExisting' could not be found.",
+					"description": "Check if the ID of your requested Thing was correct."
+				}
+			},
+			{
+				"id": "org.eclipse.kanto:test",
+				"status": 200,
+				"value": {
+					"thingId": "org.eclipse.kanto:test",
+					"attributes": {"location": "edge"}
+				}
+			}
+		],
+		"status": 207
+	}`
+
+	thing := (&model.Thing{}).
+		WithIDFrom(testThingID).
+		WithAttribute("location", "edge").
+		WithFeatures(featuresAsMapValue(s.T(), `{"meter": {"properties": {"x": 1}}}`))
+	s.createThing(thing)
+
+	s.handleCommandF(retrieveThingsCmd, defaultHeaders)
+	assertPublishedSkipVersioning(s.S(), withResponseHeadersF(response))
+}
+
+func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsPaginated() {
+	retrieveThingsCmd := `{
+		"topic": "_/_/things/twin/commands/retrieve",
+		%s,
+		"path": "/",
+		"fields": "thingId",
+		"value": {
+			"thingIds": [
+				"org.eclipse.kanto:test",
+				"org.eclipse.kanto:testNotExisting",
+				"org.eclipse.kanto:testPage1",
+				"org.eclipse.kanto:testPage2"
+			],
+			"options": "sort(-attributes/floor),size(2)%s"
+		}
+	}`
+
+	thingIDs := []string{testThingID, "org.eclipse.kanto:testPage1", "org.eclipse.kanto:testPage2"}
+	for i, thingID := range thingIDs {
+		s.createThing((&model.Thing{}).WithIDFrom(thingID).WithAttribute("floor", i))
+	}
+	defer func() {
+		s.deleteCreatedThing(thingIDs[1])
+		s.deleteCreatedThing(thingIDs[2])
+	}()
+
+	s.handleCommandF(retrieveThingsCmd, defaultHeaders, "")
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+
+	page := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(response.Value, &page))
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{"thingId": "org.eclipse.kanto:testPage2"},
+		map[string]interface{}{"thingId": "org.eclipse.kanto:testPage1"},
+	}, page["items"])
+	require.NotEmpty(s.T(), page["cursor"])
+
+	s.handleCommandF(retrieveThingsCmd, defaultHeaders, fmt.Sprintf(",cursor(%s)", page["cursor"]))
+	response = pullPublishedEnvelope(s.S())
+	assert.JSONEq(s.T(), `{"items": [{"thingId": "org.eclipse.kanto:test"}]}`, string(response.Value))
+}
+
+func (s *ThingsCommandsSuite) TestRetrieveMultipleThingsInvalidOptions() {
+	retrieveThingsCmd := `{
+		"topic": "_/_/things/twin/commands/retrieve",
+		%s,
+		"path": "/",
+		"value": {
+			"thingIds": ["org.eclipse.kanto:test"],
+			"options": "size(0)"
+		}
+	}`
+	s.addTestThing()
+
+	s.handleCommandF(retrieveThingsCmd, defaultHeaders)
+	s.assertErrorResponse(400, "things:options.invalid")
+}
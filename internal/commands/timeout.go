@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"math"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+const (
+	// MetadataReceived is the message metadata key of the time when the message is received, formatted as RFC 3339.
+	// If set, the command timeout includes the time that the message has waited to be handled.
+	MetadataReceived = "received"
+
+	headerTimeout = "timeout"
+	honoTTLFormat = "%s/?hono-ttl=%d"
+)
+
+// commandDeadline returns the time when the command timeout elapses or the zero time if the command has no timeout.
+func commandDeadline(msg *message.Message, command *protocol.Envelope) time.Time {
+	timeout := command.Headers.Timeout()
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	received := time.Now()
+	if value := msg.Metadata.Get(MetadataReceived); len(value) > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			received = t
+		}
+	}
+	return received.Add(timeout)
+}
+
+// timedOut checks if the command deadline has elapsed and if so, builds the command timeout error response, if required.
+func (h *Handler) timedOut(cmd *Command, deadline time.Time, out *CommandOutput) bool {
+	if deadline.IsZero() || time.Now().Before(deadline) {
+		return false
+	}
+
+	h.Logger.Debug("Thing command timed out", CmdLogFields(cmd.envelope))
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewCommandTimeoutError(cmd.envelope)
+	}
+	return true
+}
+
+// honoTTL returns the time to live of the command forwarded to hono, i.e. the remaining time until the command deadline.
+// Returns 0 if the command timeout is not explicitly set, so that the default hono message expiry is kept.
+func honoTTL(command *protocol.Envelope, deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return 0
+	}
+	if _, ok := command.Headers.Generic(headerTimeout); !ok {
+		return 0
+	}
+	if ttl := time.Until(deadline); ttl > 0 {
+		return ttl
+	}
+	return time.Second
+}
+
+// honoTTLSeconds rounds up the time to live to the whole seconds as expected by hono.
+func honoTTLSeconds(ttl time.Duration) int64 {
+	return int64(math.Ceil(ttl.Seconds()))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// topicWildcards are the MQTT topic level separator and wildcards, which must not be part of a topic level.
+const topicWildcards = "/+#"
+
+// ErrInvalidDevice is returned if the device or tenant ID would produce ambiguous local or hub topics.
+var ErrInvalidDevice = errors.New("invalid device configuration")
+
+// ResolvedTopics contains the topics used for the responses and events of a thing.
+type ResolvedTopics struct {
+	// ThingID is the ID of the thing the topics are resolved for.
+	ThingID string `json:"thingId"`
+	// RootDevice is true if the thing is the root device, i.e. its local topics have no thing ID.
+	RootDevice bool `json:"rootDevice"`
+	// Response is the local broker topic of the responses to the thing commands with the action.
+	Response string `json:"response"`
+	// Event is the local broker topic of the thing events with the action.
+	Event string `json:"event"`
+	// HubEvent is the hub topic of the thing events, empty if the tenant is unknown for a virtual device thing.
+	HubEvent string `json:"hubEvent,omitempty"`
+}
+
+// ValidateDevice validates that the device and the tenant IDs produce unambiguous local and hub topics,
+// i.e. the device ID is a valid namespaced ID, so that only the root device thing responses and events
+// are published on the root device topics, and neither ID contains MQTT topic separators or wildcards.
+func ValidateDevice(deviceID, tenantID string) error {
+	if len(deviceID) == 0 {
+		return errors.Wrap(ErrInvalidDevice, "device ID is missing")
+	}
+	if model.NewNamespacedIDFrom(deviceID) == nil {
+		return errors.Wrapf(ErrInvalidDevice, "device ID '%s' is not a valid namespaced ID", deviceID)
+	}
+	if strings.ContainsAny(deviceID, topicWildcards) {
+		return errors.Wrapf(ErrInvalidDevice, "device ID '%s' contains MQTT topic separators or wildcards", deviceID)
+	}
+	if len(tenantID) == 0 {
+		return errors.Wrap(ErrInvalidDevice, "tenant ID is missing")
+	}
+	if strings.ContainsAny(tenantID, topicWildcards) {
+		return errors.Wrapf(ErrInvalidDevice, "tenant ID '%s' contains MQTT topic separators or wildcards", tenantID)
+	}
+	return nil
+}
+
+// ResolveTopics returns the topics used for the responses and events with the provided action of the thing,
+// e.g. 'modify' or 'modified', of the provided device. The hub topic is resolved if the tenant ID is provided
+// or the thing is the root device.
+// Returns error if the thing ID is not a valid namespaced ID.
+func ResolveTopics(deviceID, tenantID, thingID string, action protocol.TopicAction) (*ResolvedTopics, error) {
+	id := model.NewNamespacedIDFrom(thingID)
+	if id == nil {
+		return nil, errors.Errorf("thing ID '%s' is not a valid namespaced ID", thingID)
+	}
+
+	topic := &protocol.Topic{
+		Namespace: id.Namespace,
+		EntityID:  id.Name,
+		Group:     protocol.GroupThings,
+		Channel:   protocol.ChannelTwin,
+		Action:    action,
+	}
+	topics := &ResolvedTopics{
+		ThingID:    thingID,
+		RootDevice: deviceID == TopicNamespaceID(topic),
+		Response:   ResponsePublishTopic(deviceID, topic),
+		Event:      EventPublishTopic(deviceID, topic),
+	}
+	if topics.RootDevice {
+		topics.HubEvent = topicEventRootDevice
+	} else if len(tenantID) > 0 {
+		topics.HubEvent = fmt.Sprintf(topicEventFormat, tenantID, thingID)
+	}
+	return topics, nil
+}
+
+// TopicSchemeFields returns the log fields describing the topics scheme of the provided device,
+// i.e. which topics are used for the root device thing and which ones for the other things.
+func TopicSchemeFields(deviceID, tenantID string) watermill.LogFields {
+	return watermill.LogFields{
+		"rootDevice":      deviceID,
+		"rootResponses":   fmt.Sprintf(topicCmdResponseFormatRootDevice, "<action>"),
+		"rootEvents":      fmt.Sprintf(topicCmdEventFormatRootDevice, "<action>"),
+		"rootHubEvents":   topicEventRootDevice,
+		"thingsResponses": fmt.Sprintf(topicCmdResponseFormat, "<namespace>", "<name>", "<action>"),
+		"thingsEvents":    fmt.Sprintf(topicCmdEventFormat, "<namespace>", "<name>", "<action>"),
+		"thingsHubEvents": fmt.Sprintf(topicEventFormat, tenantID, "<thingId>"),
+	}
+}
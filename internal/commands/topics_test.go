@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+func TestValidateDevice(t *testing.T) {
+	assert.NoError(t, commands.ValidateDevice("org.eclipse.kanto:test", "tenant"))
+
+	invalid := [][2]string{
+		{"", "tenant"},
+		{"test", "tenant"},
+		{"org.eclipse.kanto:test/a", "tenant"},
+		{"org.eclipse.kanto:test+", "tenant"},
+		{"org.eclipse.kanto:#", "tenant"},
+		{"org.eclipse.kanto:test", ""},
+		{"org.eclipse.kanto:test", "tenant/a"},
+		{"org.eclipse.kanto:test", "+"},
+	}
+	for _, test := range invalid {
+		assert.ErrorIs(t, commands.ValidateDevice(test[0], test[1]), commands.ErrInvalidDevice, test)
+	}
+}
+
+func TestResolveTopics(t *testing.T) {
+	deviceID := "org.eclipse.kanto:test"
+
+	topics, err := commands.ResolveTopics(deviceID, "tenant", deviceID, protocol.ActionModify)
+	require.NoError(t, err)
+	assert.Equal(t, &commands.ResolvedTopics{
+		ThingID:    deviceID,
+		RootDevice: true,
+		Response:   "command///req//modify-response",
+		Event:      "command///req//modify",
+		HubEvent:   "e",
+	}, topics)
+
+	topics, err = commands.ResolveTopics(deviceID, "tenant", "org.eclipse.kanto:test:temp", protocol.ActionModified)
+	require.NoError(t, err)
+	assert.Equal(t, &commands.ResolvedTopics{
+		ThingID:  "org.eclipse.kanto:test:temp",
+		Response: "command//org.eclipse.kanto:test:temp/req//modified-response",
+		Event:    "command//org.eclipse.kanto:test:temp/req//modified",
+		HubEvent: "e/tenant/org.eclipse.kanto:test:temp",
+	}, topics)
+
+	topics, err = commands.ResolveTopics(deviceID, "", "org.eclipse.kanto:other", protocol.ActionModify)
+	require.NoError(t, err)
+	assert.False(t, topics.RootDevice)
+	assert.Empty(t, topics.HubEvent)
+
+	_, err = commands.ResolveTopics(deviceID, "tenant", "invalid", protocol.ActionModify)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+// txEvents keeps the events published on a command execution within a storage transaction,
+// so that they are published only once the transaction is committed.
+type txEvents struct {
+	events []*protocol.Envelope
+}
+
+// executeAtomically executes the modifying command within a single storage transaction, i.e. its condition check,
+// the command itself, e.g. a thing auto-provisioning along with the features update, and its metadata update.
+// Thus, a crash never leaves the command half-applied. The events published on the execution are kept
+// in the output to be published once the transaction is committed and discarded along with the changes, if it fails.
+// The retrieve commands are executed directly, as they do not modify the storage.
+func (h *Handler) executeAtomically(cmd *Command, out *CommandOutput, execute func(h *Handler, out *CommandOutput)) {
+	if cmd.envelope.Topic.Action == protocol.ActionRetrieve {
+		execute(h, out)
+		return
+	}
+
+	pub := &batchPublisher{}
+	events := &txEvents{}
+	err := h.Storage.Batch(func(storage persistence.ThingsStorage) error {
+		execute(h.transactional(storage, pub, events), out)
+		return nil
+	})
+	if err != nil {
+		*out = CommandOutput{response: commandUnknownError("Command failed", err, cmd.envelope, h.Logger)}
+		return
+	}
+
+	out.committed = func() {
+		pub.flush(h.MosquittoPub)
+		for _, event := range events.events {
+			publishEvent(h, event)
+		}
+	}
+}
+
+// transactional returns a copy of the handler using the transaction storage and publishing
+// to the provided publisher and events, if any, until the transaction is committed.
+func (h *Handler) transactional(
+	storage persistence.ThingsStorage, pub message.Publisher, events *txEvents,
+) *Handler {
+	h.shared()
+	tx := *h
+	tx.Storage = storage
+	tx.MosquittoPub = pub
+	tx.tx = events
+	return &tx
+}
+
+// publishCommitted publishes the events of the command committed within a storage transaction.
+func (out *CommandOutput) publishCommitted() {
+	if out.committed != nil {
+		out.committed()
+		out.committed = nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"sort"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+)
+
+// headerUnsynchronized requests only the thing state that is not synchronized with the hub on retrieve.
+const headerUnsynchronized = "unsynchronized"
+
+// unsynchronizedThing is the retrieve thing response value if only the unsynchronized state is requested.
+type unsynchronizedThing struct {
+	ThingID         string                    `json:"thingId"`
+	Revision        int64                     `json:"revision"`
+	Features        map[string]*model.Feature `json:"features,omitempty"`
+	DeletedFeatures []string                  `json:"deletedFeatures,omitempty"`
+}
+
+func unsynchronizedRequested(cmd *Command) bool {
+	value, _ := cmd.envelope.Headers.Generic(headerUnsynchronized)
+	return value == true
+}
+
+// retrieveUnsynchronized builds the retrieve thing command output with the features modified and deleted
+// locally that are still not synchronized with the hub, i.e. the data that would be lost if
+// the local storage is wiped before the next synchronization. The command is answered locally only.
+func retrieveUnsynchronized(h *Handler, cmd *Command, out *CommandOutput) {
+	out.local = true
+
+	sysData, err := h.Storage.GetSystemThingData(cmd.thingID)
+	if err != nil {
+		out.response = h.thingNotFound("Retrieve unsynchronized thing failed", err, cmd.envelope, cmd.thingID)
+		return
+	}
+
+	result := &unsynchronizedThing{ThingID: cmd.thingID, Revision: sysData.Revision}
+	if len(sysData.UnsynchronizedFeatures) > 0 {
+		result.Features = make(map[string]*model.Feature, len(sysData.UnsynchronizedFeatures))
+		if err := h.Storage.ForEachFeature(cmd.thingID, func(featureID string, feature *model.Feature) (bool, error) {
+			if _, ok := sysData.UnsynchronizedFeatures[featureID]; ok {
+				result.Features[featureID] = feature
+			}
+			return true, nil
+		}); err != nil {
+			out.response = commandUnknownError("Retrieve unsynchronized features failed", err, cmd.envelope, h.Logger)
+			return
+		}
+	}
+	for featureID := range sysData.DeletedFeatures {
+		result.DeletedFeatures = append(result.DeletedFeatures, featureID)
+	}
+	sort.Strings(result.DeletedFeatures)
+
+	out.response = ResponseEnvelopeWithValue(cmd.envelope, ok, result)
+	if out.response != nil {
+		withETag(out.response, revisionETag(sysData.Revision))
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const retrieveUnsynchronizedCmd = `{
+	"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+	"headers": {"correlation-id": "test/local-digital-twins/unsynchronized", "unsynchronized": %v},
+	"path": "/"
+}`
+
+type UnsynchronizedCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestUnsynchronizedCommandsSuite(t *testing.T) {
+	suite.Run(t, new(UnsynchronizedCommandsSuite))
+}
+
+func (s *UnsynchronizedCommandsSuite) SetupTest() {
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("x", 1))
+	s.addFeature("door", (&model.Feature{}).WithProperty("open", false))
+	s.addFeature("window", (&model.Feature{}).WithProperty("open", false))
+}
+
+func (s *UnsynchronizedCommandsSuite) TestRetrieveUnsynchronized() {
+	_, err := s.handler.Storage.AddFeature(testThingID, "door", (&model.Feature{}).WithProperty("open", true))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.handler.Storage.RemoveFeature(testThingID, "window"))
+
+	s.handleCommandF(retrieveUnsynchronizedCmd, true)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{
+		"thingId": "org.eclipse.kanto:test",
+		"revision": 5,
+		"features": {"door": {"properties": {"open": true}}},
+		"deletedFeatures": ["window"]
+	}`, string(response.Value))
+	// answered locally only
+	assert.Equal(s.T(), 0, s.handler.HonoPub.(*testPublisher).buffer.Len())
+}
+
+func (s *UnsynchronizedCommandsSuite) TestRetrieveSynchronized() {
+	s.handleCommandF(retrieveUnsynchronizedCmd, true)
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{"thingId": "org.eclipse.kanto:test", "revision": 3}`, string(response.Value))
+
+	s.handleCommandF(retrieveUnsynchronizedCmd, false)
+	response = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.Contains(s.T(), string(response.Value), "window")
+}
+
+func (s *UnsynchronizedCommandsSuite) TestRetrieveUnsynchronizedThingNotFound() {
+	s.deleteThing()
+
+	s.handleCommandF(retrieveUnsynchronizedCmd, true)
+	s.assertErrorResponse(404, "things:thing.notfound")
+}
@@ -13,13 +13,17 @@
 package commands
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
 	"github.com/eclipse-kanto/suite-connector/logger"
@@ -45,6 +49,9 @@ const (
 	ScopeFeatureDesiredProperties
 	ScopeFeatureDesiredProperty
 	ScopeFeatureDefinition // unsupported
+
+	ScopeView
+	ScopeLease
 )
 
 const (
@@ -59,6 +66,9 @@ const (
 	topicEventFormat     = "e/%s/%s"
 	topicEventRootDevice = "e"
 
+	eTagRevisionFormat = `"rev:%d"`
+	eTagHashFormat     = `"hash:%s"`
+
 	noValue = ""
 )
 
@@ -88,6 +98,12 @@ func ParseCmdPath(path string) (Scope, string, string) {
 	} else if strings.HasPrefix(path, things.PathThingAttributes) {
 		return ScopeAttributes, path[len(things.PathThingAttributes):], noValue // /attributes/<attributePath>
 
+	} else if separators == 2 && strings.HasPrefix(path, pathViews+"/") && len(path) > len(pathViews)+1 {
+		return ScopeView, path[len(pathViews)+1:], noValue // /views/<viewName>
+
+	} else if separators == 2 && strings.HasPrefix(path, pathLeases+"/") && len(path) > len(pathLeases)+1 {
+		return ScopeLease, path[len(pathLeases)+1:], noValue // /leases/<leaseName>
+
 	} else {
 		return ScopeUnknown, noValue, noValue
 	}
@@ -152,10 +168,24 @@ func commandValue(cmd *protocol.Envelope, value interface{}, out *CommandOutput)
 	return nil
 }
 
+func mergeCommandValue(cmd *protocol.Envelope, current interface{}, value interface{}, out *CommandOutput) error {
+	if err := jsonutil.MergeJSON(current, cmd.Value, value); err != nil {
+		out.invalidValueError = errors.Wrap(err, "invalid command payload")
+		if cmd.Headers.ResponseRequired() {
+			out.response = NewInvalidJSONValueError(cmd, err)
+		}
+		return err
+	}
+	return nil
+}
+
 func commandUnknownError(msg string, err error, cmd *protocol.Envelope, logger logger.Logger) *protocol.Envelope {
 	logCmdError(msg, err, cmd, logger)
 
 	if cmd.Headers.ResponseRequired() {
+		if errors.Is(err, persistence.ErrLimitExceeded) {
+			return NewLimitExceededError(cmd, TopicNamespaceID(cmd.Topic), err)
+		}
 		return NewUnknownError(cmd, msg, err)
 	}
 	return nil
@@ -172,6 +202,30 @@ func commandPropertyNotFoundError(msg string, err error, cmd *Command, desired b
 }
 
 func publishEvent(h *Handler, event *protocol.Envelope) {
+	if h.tx != nil {
+		h.tx.events = append(h.tx.events, event)
+		return
+	}
+	event = h.Redaction.Apply(event)
+	if h.SortedKeys {
+		event.Value = sortedKeysValue(event.Value)
+	}
+	if h.Journal != nil {
+		h.Journal.Append(event)
+	}
+	if h.Replay != nil {
+		h.Replay.Record(event)
+	}
+	if h.Aggregator == nil || !h.Aggregator.Add(h, event) {
+		publishLocalEvent(h, event)
+	}
+	if h.Federation != nil {
+		h.Federation.Forward(h.MosquittoPub, event)
+	}
+}
+
+// publishLocalEvent publishes the event to the local subscribers.
+func publishLocalEvent(h *Handler, event *protocol.Envelope) {
 	if data, err := json.Marshal(event); err != nil {
 		logCmdError("Unable to publish unexpected event", err, event, h.Logger)
 	} else {
@@ -189,6 +243,9 @@ func EventPublishTopic(deviceID string, topic *protocol.Topic) string {
 }
 
 func publishResponse(h *Handler, response *protocol.Envelope) {
+	if h.SortedKeys {
+		response.Value = sortedKeysValue(response.Value)
+	}
 	if data, err := json.Marshal(response); err != nil {
 		logCmdError("Unable to publish unexpected respose", err, response, h.Logger)
 	} else {
@@ -197,6 +254,27 @@ func publishResponse(h *Handler, response *protocol.Envelope) {
 	}
 }
 
+// sortedKeysValue returns the JSON value with lexicographically sorted object keys at all levels.
+// The numbers and strings are kept as they are, the value is returned unchanged if it is not a valid JSON.
+func sortedKeysValue(value json.RawMessage) json.RawMessage {
+	if len(value) == 0 {
+		return value
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return value
+	}
+
+	sorted, err := json.Marshal(decoded)
+	if err != nil {
+		return value
+	}
+	return sorted
+}
+
 // ResponsePublishTopic builds the message topic from the provided response envelope topic.
 func ResponsePublishTopic(deviceID string, envTopic *protocol.Topic) string {
 	if deviceID == TopicNamespaceID(envTopic) {
@@ -256,14 +334,84 @@ func responseEnvelope(cmdEnvelope *protocol.Envelope, status int) *protocol.Enve
 	return nil
 }
 
+// retrieveResponse builds the ok response of a retrieve command with the provided resource value and entity tag.
+// If the command fields selector is provided, only the selected fields of a JSON object value are responded.
+// If the flat value format is requested, the value is responded as its leaf values mapped by their JSON pointers.
+func (h *Handler) retrieveResponse(env *protocol.Envelope, value interface{}) *protocol.Envelope {
+	eTag := contentETag(value)
+	if len(env.Fields) > 0 {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return commandUnknownError("Value marshal error", err, env, h.Logger)
+		}
+
+		if len(data) > 0 && data[0] == '{' {
+			subset, err := jsonutil.JSONSubset(string(data), env.Fields)
+			if err != nil {
+				return h.invalidFieldSelector("Invalid field selector", err, env)
+			}
+			value = json.RawMessage(subset)
+		}
+	}
+	if flatFormat(env.Headers) {
+		flat, err := flatValue(value)
+		if err != nil {
+			return commandUnknownError("Value marshal error", err, env, h.Logger)
+		}
+		value = flat
+	}
+	return withETag(ResponseEnvelopeWithValue(env, ok, value), eTag)
+}
+
+// flatFormat returns true if the compact flat value format is requested with the provided headers.
+func flatFormat(headers *protocol.Headers) bool {
+	return headers != nil && headers.ValueFormat() == protocol.ValueFormatFlat
+}
+
+// flatValue returns the leaf values of the provided value mapped by their JSON pointers.
+func flatValue(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return jsonutil.Flatten(generic), nil
+}
+
+// withETag sets the entity tag header of the provided response envelope, if any.
+func withETag(response *protocol.Envelope, eTag string) *protocol.Envelope {
+	if response != nil && response.Headers != nil {
+		response.Headers.WithETag(eTag)
+	}
+	return response
+}
+
+// revisionETag returns a thing entity tag, derived from the thing revision.
+func revisionETag(revision int64) string {
+	return fmt.Sprintf(eTagRevisionFormat, revision)
+}
+
+// contentETag returns a thing sub-resource entity tag, derived from the resource canonical JSON content hash,
+// so that the equal contents have equal entity tags regardless of their members order and numbers format.
+func contentETag(value interface{}) string {
+	hash, err := jsonutil.Hash(value, jsonutil.HashFNV64a)
+	if err != nil {
+		return noValue
+	}
+	return fmt.Sprintf(eTagHashFormat, hash)
+}
+
 func eventEnvelope(
-	cmdEnvelope *protocol.Envelope, revision int64, timestamp string, action protocol.TopicAction,
+	cmdEnvelope *protocol.Envelope, thing *model.Thing, action protocol.TopicAction,
 ) *protocol.Envelope {
 	env := &protocol.Envelope{
 		Topic:     eventTopic(cmdEnvelope.Topic, action),
 		Path:      cmdEnvelope.Path,
-		Revision:  revision,
-		Timestamp: timestamp,
+		Revision:  thing.Revision,
+		Timestamp: thing.Timestamp,
 	}
 
 	if action != protocol.ActionDeleted {
@@ -272,6 +420,7 @@ func eventEnvelope(
 	} else {
 		env.WithHeaders(responseHeaders(cmdEnvelope.Headers))
 	}
+	env.Headers.WithTimestampQuality(thing.TimestampQuality)
 	return env
 }
 
@@ -285,7 +434,7 @@ func eventThingCreatedEnvelope(
 		Timestamp: thing.Timestamp,
 	}
 
-	env.WithHeaders(responseHeadersWithContent(cmdEnvelope.Headers)).
+	env.WithHeaders(responseHeadersWithContent(cmdEnvelope.Headers).WithTimestampQuality(thing.TimestampQuality)).
 		WithValue(thing)
 	return env
 }
@@ -303,12 +452,23 @@ func eventTopic(topic *protocol.Topic, action protocol.TopicAction) *protocol.To
 
 // PublishHonoMsg publishes the message with device to cloud messaging topic.
 func PublishHonoMsg(msg *message.Message, publisher message.Publisher, dInfo DeviceInfo, thingID string) error {
+	return PublishHonoMsgTTL(msg, publisher, dInfo, thingID, 0)
+}
+
+// PublishHonoMsgTTL publishes the message to hono with the provided time to live as hono message expiry.
+// The default hono message expiry is used if the time to live is not positive.
+func PublishHonoMsgTTL(
+	msg *message.Message, publisher message.Publisher, dInfo DeviceInfo, thingID string, ttl time.Duration,
+) error {
 	var pubTopic string
 	if dInfo.DeviceID == thingID {
 		pubTopic = topicEventRootDevice
 	} else {
 		pubTopic = fmt.Sprintf(topicEventFormat, dInfo.TenantID, thingID)
 	}
+	if ttl > 0 {
+		pubTopic = fmt.Sprintf(honoTTLFormat, pubTopic, honoTTLSeconds(ttl))
+	}
 	return publisher.Publish(pubTopic, msg)
 }
 
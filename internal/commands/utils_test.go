@@ -88,6 +88,16 @@ func TestPathParse(t *testing.T) {
 			target:  "meter",
 			path:    "/prop/field",
 		},
+		{
+			cmdPath: "/views/dashboard",
+			scope:   commands.ScopeView,
+			target:  "dashboard",
+		},
+		{
+			cmdPath: "/leases/updater",
+			scope:   commands.ScopeLease,
+			target:  "updater",
+		},
 	}
 	for _, test := range tests {
 		cmd, id, path := commands.ParseCmdPath(test.cmdPath)
@@ -102,6 +112,8 @@ func TestPathParseInvalid(t *testing.T) {
 		"/unknown", "/attributes_", "/definitionA",
 		"/policyId_!", "/features.",
 		"/features/meter/unknown", "/features/meter/unknown/prop/field",
+		"/views", "/views/", "/views/dashboard/field",
+		"/leases", "/leases/", "/leases/updater/holder",
 	}
 	for _, test := range tests {
 		cmd, target, path := commands.ParseCmdPath(test)
@@ -0,0 +1,308 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// DefinitionValidator validates the properties of the features with a given definition.
+type DefinitionValidator interface {
+	// Validate validates the properties value at the provided JSON pointer, empty for all properties.
+	// If partial, the value is a JSON merge patch.
+	Validate(pointer string, value interface{}, partial bool) error
+}
+
+// DefaultsProvider is optionally implemented by the definition validators providing default properties values,
+// e.g. the 'default' values of a WoT Thing Model property affordances.
+type DefaultsProvider interface {
+	// Defaults returns the default properties values, nil if there are no such.
+	Defaults() interface{}
+}
+
+// Validators is the registry of the feature definitions validators, keyed by the definition ID,
+// e.g. 'org.eclipse.kanto:Meter:1.0.0'.
+type Validators struct {
+	mutex      sync.RWMutex
+	validators map[string]DefinitionValidator
+}
+
+// Register registers the validator of the features with the provided definition ID.
+func (v *Validators) Register(definitionID string, validator DefinitionValidator) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.validators == nil {
+		v.validators = make(map[string]DefinitionValidator)
+	}
+	v.validators[definitionID] = validator
+}
+
+// Validator returns the validator of the features with the provided definition ID, nil if there is no such.
+func (v *Validators) Validator(definitionID string) DefinitionValidator {
+	if v == nil {
+		return nil
+	}
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.validators[definitionID]
+}
+
+func (v *Validators) empty() bool {
+	if v == nil {
+		return true
+	}
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return len(v.validators) == 0
+}
+
+// SchemaValidator validates the feature properties against a JSON schema.
+type SchemaValidator struct {
+	Schema *jsonutil.Schema
+}
+
+// Validate implementation.
+func (v *SchemaValidator) Validate(pointer string, value interface{}, partial bool) error {
+	schema, ok := v.Schema.At(pointer)
+	if !ok {
+		return errors.Errorf("%s: property is not allowed", pointer)
+	}
+	if err := schema.Validate(value, partial); err != nil && len(pointer) > 0 {
+		return errors.Wrap(err, pointer)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Defaults implementation.
+func (v *SchemaValidator) Defaults() interface{} {
+	return v.Schema.Defaults()
+}
+
+// NewJSONSchemaValidator creates a validator of the feature properties against the provided JSON schema.
+func NewJSONSchemaValidator(data []byte) (*SchemaValidator, error) {
+	schema, err := jsonutil.ParseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaValidator{Schema: schema}, nil
+}
+
+// NewThingModelValidator creates a validator of the feature properties against the property affordances
+// of the provided WoT Thing Model (https://www.w3.org/TR/wot-thing-description11/#thing-model).
+func NewThingModelValidator(data []byte) (*SchemaValidator, error) {
+	thingModel := struct {
+		Properties map[string]*jsonutil.Schema `json:"properties"`
+	}{}
+	if err := json.Unmarshal(data, &thingModel); err != nil {
+		return nil, err
+	}
+
+	schema := &jsonutil.Schema{Type: jsonutil.SchemaTypes{"object"}, Properties: thingModel.Properties}
+	if err := schema.Compile(); err != nil {
+		return nil, err
+	}
+	return &SchemaValidator{Schema: schema}, nil
+}
+
+// LoadValidators reads the feature definitions validators from the provided JSON file, mapping the definition IDs
+// to WoT Thing Model or JSON schema files, e.g. {"org.eclipse.kanto:Meter:1.0.0": "meter.tm.jsonld"}.
+// The relative model paths are resolved against the directory of the provided file.
+func LoadValidators(file string) (*Validators, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	models := map[string]string{}
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, errors.Wrapf(err, "invalid definitions models file %s", file)
+	}
+
+	validators := &Validators{}
+	for definitionID, modelFile := range models {
+		if model.NewDefinitionIDFrom(definitionID) == nil {
+			return nil, errors.Errorf("invalid definition ID '%s'", definitionID)
+		}
+		if !filepath.IsAbs(modelFile) {
+			modelFile = filepath.Join(filepath.Dir(file), modelFile)
+		}
+		validator, err := loadValidator(modelFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid model of definition '%s'", definitionID)
+		}
+		validators.Register(definitionID, validator)
+	}
+	return validators, nil
+}
+
+func loadValidator(file string) (DefinitionValidator, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if _, ok := header["@context"]; ok {
+		return NewThingModelValidator(data)
+	}
+	return NewJSONSchemaValidator(data)
+}
+
+// definitionsConformed checks if the feature properties modified by the command conform to the registered models
+// of the feature definitions and if not, builds the validation error response, if required.
+func (h *Handler) definitionsConformed(cmd *Command, out *CommandOutput) bool {
+	if h.Validators.empty() {
+		return true
+	}
+
+	action := cmd.envelope.Topic.Action
+	if action != protocol.ActionCreate && action != protocol.ActionModify && action != protocol.ActionMerge {
+		return true
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(cmd.envelope.Value, &value); err != nil {
+		return true // left to the command for the invalid value error
+	}
+
+	partial := action == protocol.ActionMerge
+	if partial && value == nil {
+		return true // removal
+	}
+	scope, _, _ := ParseCmdPath(cmd.envelope.Path)
+	if properties, ok := value.(map[string]interface{}); ok && scope == ScopeFeatureProperties {
+		value = withoutNullProperties(properties)
+	}
+
+	var err error
+	switch scope {
+	case ScopeThing:
+		if thing, ok := value.(map[string]interface{}); ok {
+			err = h.validateFeatures(cmd.thingID, thing["features"], partial)
+		}
+	case ScopeFeatures:
+		err = h.validateFeatures(cmd.thingID, value, partial)
+	case ScopeFeature:
+		err = h.validateFeature(cmd.thingID, cmd.target, value, partial)
+	case ScopeFeatureProperties:
+		err = h.validateProperties(h.storedDefinition(cmd.thingID, cmd.target), "", value, partial)
+	case ScopeFeatureProperty:
+		err = h.validateProperties(h.storedDefinition(cmd.thingID, cmd.target), cmd.path, value, partial)
+	}
+	if err == nil {
+		return true
+	}
+
+	logCmdError("Thing command does not conform to the feature definition", err, cmd.envelope, h.Logger)
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewPayloadValidationError(cmd.envelope, err)
+	}
+	return false
+}
+
+func (h *Handler) validateFeatures(thingID string, value interface{}, partial bool) error {
+	features, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for featureID, feature := range features {
+		if err := h.validateFeature(thingID, featureID, feature, partial); err != nil {
+			return errors.Wrapf(err, "feature '%s'", featureID)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) validateFeature(thingID, featureID string, value interface{}, partial bool) error {
+	feature, ok := value.(map[string]interface{})
+	if !ok {
+		return nil // removed on merge or invalid
+	}
+
+	var definition []string
+	if definitionValue, ok := feature["definition"].([]interface{}); ok {
+		for _, id := range definitionValue {
+			if s, ok := id.(string); ok {
+				definition = append(definition, s)
+			}
+		}
+	} else if partial {
+		definition = h.storedDefinition(thingID, featureID)
+	}
+
+	properties, ok := feature["properties"]
+	if !ok {
+		if partial {
+			return nil
+		}
+		properties = map[string]interface{}{}
+	}
+	return h.validateProperties(definition, "", properties, partial)
+}
+
+func (h *Handler) validateProperties(definition []string, pointer string, value interface{}, partial bool) error {
+	for _, definitionID := range definition {
+		if validator := h.Validators.Validator(definitionID); validator != nil {
+			if err := validator.Validate(pointer, value, partial); err != nil {
+				return errors.Wrapf(err, "definition '%s'", definitionID)
+			}
+		}
+	}
+	return nil
+}
+
+// propertiesDefaults returns the default properties values provided by the validators of the given definition,
+// the defaults of the preceding definitions take precedence.
+func (h *Handler) propertiesDefaults(definition []string) interface{} {
+	var defaults interface{}
+	for i := len(definition) - 1; i >= 0; i-- {
+		if provider, ok := h.Validators.Validator(definition[i]).(DefaultsProvider); ok {
+			if value := provider.Defaults(); value != nil {
+				defaults = jsonutil.MergePatch(defaults, value)
+			}
+		}
+	}
+	return defaults
+}
+
+func (h *Handler) storedDefinition(thingID, featureID string) []string {
+	feature := model.Feature{}
+	if err := h.Storage.GetFeature(thingID, featureID, &feature); err != nil {
+		return nil
+	}
+	definition := make([]string, 0, len(feature.Definition))
+	for _, id := range feature.Definition {
+		if id != nil {
+			definition = append(definition, id.String())
+		}
+	}
+	return definition
+}
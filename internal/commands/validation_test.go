@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	meterDefinition = "org.eclipse.kanto:Meter:1.0.0"
+	lampDefinition  = "org.eclipse.kanto:Lamp:1.0.0"
+
+	meterThingModel = `{
+		"@context": ["https://www.w3.org/2022/wot/td/v1.1"],
+		"@type": "tm:ThingModel",
+		"title": "Meter",
+		"properties": {
+			"x": {"type": "integer", "minimum": 0, "maximum": 100},
+			"status": {
+				"type": "object",
+				"properties": {
+					"mode": {"type": "string", "enum": ["auto", "manual"]}
+				}
+			}
+		}
+	}`
+	lampSchema = `{
+		"type": "object",
+		"properties": {
+			"on": {"type": "boolean"}
+		},
+		"required": ["on"],
+		"additionalProperties": false
+	}`
+
+	validatedModifyCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/%s",
+		%s,
+		"path": "%s",
+		"value": %s
+	}`
+)
+
+type ValidationCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestValidationCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ValidationCommandsSuite))
+}
+
+func (s *ValidationCommandsSuite) SetupTest() {
+	dir := s.T().TempDir()
+	require.NoError(s.T(), os.WriteFile(filepath.Join(dir, "meter.tm.jsonld"), []byte(meterThingModel), 0600))
+	require.NoError(s.T(), os.WriteFile(filepath.Join(dir, "lamp.schema.json"), []byte(lampSchema), 0600))
+
+	models := filepath.Join(dir, "models.json")
+	require.NoError(s.T(), os.WriteFile(models, []byte(`{
+		"`+meterDefinition+`": "meter.tm.jsonld",
+		"`+lampDefinition+`": "lamp.schema.json"
+	}`), 0600))
+
+	validators, err := commands.LoadValidators(models)
+	require.NoError(s.T(), err)
+	s.handler.Validators = validators
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithDefinitionFrom(meterDefinition).WithProperty("x", 1))
+}
+
+func (s *ValidationCommandsSuite) TestLoadValidatorsInvalid() {
+	dir := s.T().TempDir()
+	require.NoError(s.T(), os.WriteFile(filepath.Join(dir, "invalid.json"), []byte(`{"type": 1}`), 0600))
+
+	for name, mapping := range map[string]string{
+		"syntax.json":     `{"` + meterDefinition + `": [}`,
+		"definition.json": `{"meter": "invalid.json"}`,
+		"missing.json":    `{"` + meterDefinition + `": "missing.tm.jsonld"}`,
+		"model.json":      `{"` + meterDefinition + `": "invalid.json"}`,
+	} {
+		file := filepath.Join(dir, name)
+		require.NoError(s.T(), os.WriteFile(file, []byte(mapping), 0600))
+		_, err := commands.LoadValidators(file)
+		assert.Error(s.T(), err, name)
+	}
+}
+
+func (s *ValidationCommandsSuite) TestValidProperties() {
+	valid := [][3]string{
+		{"modify", "/features/meter/properties/x", `50`},
+		{"modify", "/features/meter/properties/status/mode", `"auto"`},
+		{"modify", "/features/meter/properties", `{"x": 2, "other": true}`},
+		{"modify", "/features/lamp", `{"definition": ["` + lampDefinition + `"], "properties": {"on": true}}`},
+		{"merge", "/features", `{"lamp": {"properties": {"on": false}}}`},
+		{"merge", "/", `{"features": {"meter": {"properties": {"x": 3}}}}`},
+		{"modify", "/features/unknown", `{"properties": {"on": "any"}}`},
+		{"modify", "/features/meter/properties", `{"x": null, "other": true}`},
+		{"merge", "/features/meter/properties", `{"x": null, "status": {"mode": "manual"}}`},
+		{"merge", "/features/meter/properties/x", `null`},
+	}
+	for _, cmd := range valid {
+		assert.Empty(s.T(), s.handleCommandF(validatedModifyCmd, cmd[0], defaultHeaders, cmd[1], cmd[2]))
+		response := pullPublishedEnvelope(s.S())
+		assert.True(s.T(), response.Status < 300, cmd[1])
+		pullPublishedEnvelope(s.S()) // event
+	}
+}
+
+func (s *ValidationCommandsSuite) TestInvalidProperties() {
+	invalid := [][3]string{
+		{"modify", "/features/meter/properties/x", `101`},
+		{"modify", "/features/meter/properties/x", `"50"`},
+		{"modify", "/features/meter/properties/status/mode", `"off"`},
+		{"modify", "/features/meter/properties", `{"x": 1.5}`},
+		{"modify", "/features/meter", `{"definition": ["` + meterDefinition + `"], "properties": {"x": -1}}`},
+		{"modify", "/features/lamp", `{"definition": ["` + lampDefinition + `"], "properties": {}}`},
+		{"modify", "/features/lamp", `{"definition": ["` + lampDefinition + `"], "properties": {"on": true, "x": 1}}`},
+		{"modify", "/features", `{"meter": {"definition": ["` + meterDefinition + `"], "properties": {"x": 200}}}`},
+		{"merge", "/", `{"features": {"meter": {"properties": {"x": 200}}}}`},
+		{"merge", "/features/meter/properties/x", `101`},
+	}
+	hono := s.handler.HonoPub.(*testPublisher)
+	for _, cmd := range invalid {
+		assert.Empty(s.T(), s.handleCommandF(validatedModifyCmd, cmd[0], defaultHeaders, cmd[1], cmd[2]))
+		s.assertErrorResponse(400, "wot:payload.validation.error")
+		_, err := hono.Pull()
+		assert.Error(s.T(), err, cmd[1])
+	}
+
+	feature := &model.Feature{}
+	require.NoError(s.T(), s.handler.Storage.GetFeature(testThingID, testFeatureID, feature))
+	assert.EqualValues(s.T(), 1, feature.Properties["x"])
+}
+
+func (s *ValidationCommandsSuite) TestInvalidBatchProperties() {
+	s.handleCommandF(batchCmd, `[{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 20
+	}, {
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"path": "/features/meter/properties/x",
+		"value": 200
+	}]`)
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 400, response.Status)
+	assertPublishedNone(s.S())
+
+	feature := &model.Feature{}
+	require.NoError(s.T(), s.handler.Storage.GetFeature(testThingID, testFeatureID, feature))
+	assert.EqualValues(s.T(), 1, feature.Properties["x"])
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	parser "github.com/Jeffail/gabs/v2"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+const pathViews = "/views"
+
+// ViewMember is a feature property composed in a view.
+type ViewMember struct {
+	Feature  string `json:"feature"`
+	Property string `json:"property"`
+}
+
+// View is a named composite view, mapping the view fields to the composed feature properties.
+type View map[string]ViewMember
+
+// Views contains the composite views of selected properties of multiple features, retrieved on path
+// '/views/<viewName>' of any thing, e.g. {"dashboard": {"temp": {"feature": "meter", "property": "temperature"}}}.
+type Views map[string]View
+
+// LoadViews reads the composite views definition from the provided JSON file.
+func LoadViews(file string) (Views, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	views := Views{}
+	if err := json.Unmarshal(data, &views); err != nil {
+		return nil, errors.Wrapf(err, "invalid views definition file %s", file)
+	}
+	for name, view := range views {
+		if len(name) == 0 || strings.Contains(name, "/") {
+			return nil, errors.Errorf("invalid view name '%s'", name)
+		}
+		for field, member := range view {
+			if len(member.Feature) == 0 || len(member.Property) == 0 {
+				return nil, errors.Errorf("invalid view '%s' field '%s': feature and property are required", name, field)
+			}
+		}
+	}
+	return views, nil
+}
+
+// retrieveView handles retrieve view commands and builds the command output.
+// The view is assembled of the current feature properties, the missing ones are omitted.
+func retrieveView(h *Handler, cmd *Command, out *CommandOutput) {
+	out.local = true
+
+	view, ok := h.Views[cmd.target]
+	if !ok {
+		h.Logger.Debug("Unable to retrieve unknown view", CmdLogFields(cmd.envelope))
+		if cmd.envelope.Headers.ResponseRequired() {
+			out.response = NewViewNotFoundError(cmd.envelope, cmd.target)
+		}
+		return
+	}
+
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		out.response = h.thingNotFound("Retrieve view failed", err, cmd.envelope, cmd.thingID)
+		return
+	}
+	out.response = h.retrieveResponse(cmd.envelope, view.assemble(&thing))
+}
+
+// publishViewEvents publishes a view modified event for each view composing a property affected by the command.
+func (h *Handler) publishViewEvents(cmd *Command, out *CommandOutput) {
+	if len(h.Views) == 0 || out.event == nil {
+		return
+	}
+
+	scope, featureID, path := ParseCmdPath(cmd.envelope.Path)
+	names := make([]string, 0, len(h.Views))
+	for name, view := range h.Views {
+		if view.affected(scope, featureID, path) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	thing := model.Thing{}
+	if err := h.Storage.GetThing(cmd.thingID, &thing); err != nil {
+		return // no view events for deleted things
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		event := &protocol.Envelope{
+			Topic:     eventTopic(cmd.envelope.Topic, protocol.ActionModified),
+			Path:      pathViews + "/" + name,
+			Revision:  thing.Revision,
+			Timestamp: thing.Timestamp,
+		}
+		event.WithHeaders(responseHeadersWithContent(cmd.envelope.Headers)).
+			WithValue(h.Views[name].assemble(&thing))
+		publishEvent(h, event)
+	}
+}
+
+func (v View) assemble(thing *model.Thing) map[string]interface{} {
+	value := make(map[string]interface{}, len(v))
+	for field, member := range v {
+		feature, ok := thing.Features[member.Feature]
+		if !ok || feature == nil || feature.Properties == nil {
+			continue
+		}
+		if property, err := parser.Wrap(feature.Properties).JSONPointer(propertyPointer(member.Property)); err == nil {
+			value[field] = property.Data()
+		}
+	}
+	return value
+}
+
+func (v View) affected(scope Scope, featureID, path string) bool {
+	switch scope {
+	case ScopeThing, ScopeFeatures:
+		return true
+	case ScopeFeature, ScopeFeatureProperties:
+		for _, member := range v {
+			if member.Feature == featureID {
+				return true
+			}
+		}
+	case ScopeFeatureProperty:
+		path = strings.Trim(path, "/")
+		for _, member := range v {
+			property := strings.Trim(member.Property, "/")
+			if member.Feature == featureID &&
+				(property == path || strings.HasPrefix(property, path+"/") || strings.HasPrefix(path, property+"/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func propertyPointer(property string) string {
+	if strings.HasPrefix(property, "/") {
+		return property
+	}
+	return "/" + property
+}
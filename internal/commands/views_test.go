@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	viewsDefinition = `{
+		"dashboard": {
+			"temperature": {"feature": "meter", "property": "temperature/value"},
+			"humidity": {"feature": "hygrometer", "property": "humidity"}
+		}
+	}`
+
+	retrieveViewCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/retrieve",
+		%s,
+		"path": "/views/%s"
+	}`
+	modifyViewMemberCmd = `{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		%s,
+		"path": "/features/%s/properties/%s",
+		"value": %s
+	}`
+)
+
+type ViewsCommandsSuite struct {
+	CommandsSuite
+}
+
+func TestViewsCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ViewsCommandsSuite))
+}
+
+func (s *ViewsCommandsSuite) SetupTest() {
+	file := filepath.Join(s.T().TempDir(), "views.json")
+	require.NoError(s.T(), os.WriteFile(file, []byte(viewsDefinition), 0600))
+
+	views, err := commands.LoadViews(file)
+	require.NoError(s.T(), err)
+	s.handler.Views = views
+
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("temperature", map[string]interface{}{"value": 21}))
+	s.addFeature("hygrometer", (&model.Feature{}).WithProperty("humidity", 40))
+	s.addFeature("lamp", (&model.Feature{}).WithProperty("on", true))
+}
+
+func (s *ViewsCommandsSuite) TestLoadViewsInvalid() {
+	dir := s.T().TempDir()
+	for name, definition := range map[string]string{
+		"syntax.json":  `{"dashboard": [}`,
+		"name.json":    `{"dash/board": {"temperature": {"feature": "meter", "property": "temperature"}}}`,
+		"feature.json": `{"dashboard": {"temperature": {"property": "temperature"}}}`,
+	} {
+		file := filepath.Join(dir, name)
+		require.NoError(s.T(), os.WriteFile(file, []byte(definition), 0600))
+		_, err := commands.LoadViews(file)
+		assert.Error(s.T(), err, name)
+	}
+
+	_, err := commands.LoadViews(filepath.Join(dir, "missing.json"))
+	assert.Error(s.T(), err)
+}
+
+func (s *ViewsCommandsSuite) TestRetrieveView() {
+	assert.Empty(s.T(), s.handleCommandF(retrieveViewCmd, defaultHeaders, "dashboard"))
+
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.Equal(s.T(), "/views/dashboard", response.Path)
+	assert.JSONEq(s.T(), `{"temperature": 21, "humidity": 40}`, string(response.Value))
+
+	// views are local only
+	_, err := s.handler.HonoPub.(*testPublisher).Pull()
+	assert.Error(s.T(), err)
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveViewCmd, defaultHeaders, "unknown"))
+	s.assertErrorResponse(404, "things:view.notfound")
+}
+
+func (s *ViewsCommandsSuite) TestRetrieveViewMissingProperty() {
+	s.deleteCreatedThing(testThingID)
+	s.addTestThing()
+	s.addFeature(testFeatureID, (&model.Feature{}).WithProperty("temperature", map[string]interface{}{"value": 21}))
+
+	assert.Empty(s.T(), s.handleCommandF(retrieveViewCmd, defaultHeaders, "dashboard"))
+	response := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), 200, response.Status)
+	assert.JSONEq(s.T(), `{"temperature": 21}`, string(response.Value))
+}
+
+func (s *ViewsCommandsSuite) TestViewEvents() {
+	assert.Empty(s.T(), s.handleCommandF(modifyViewMemberCmd, headersNoResponseRequired, "hygrometer", "humidity", "55"))
+
+	event := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), "/features/hygrometer/properties/humidity", event.Path)
+
+	viewEvent := pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), "/views/dashboard", viewEvent.Path)
+	assert.EqualValues(s.T(), "modified", viewEvent.Topic.Action)
+
+	value := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(viewEvent.Value, &value))
+	assert.EqualValues(s.T(), 55, value["humidity"])
+	assert.EqualValues(s.T(), 21, value["temperature"])
+
+	// not a view member
+	assert.Empty(s.T(), s.handleCommandF(modifyViewMemberCmd, headersNoResponseRequired, "lamp", "on", "false"))
+	pullPublishedEnvelope(s.S())
+	assertPublishedNone(s.S())
+
+	// parent of a view member
+	assert.Empty(s.T(), s.handleCommandF(modifyViewMemberCmd, headersNoResponseRequired, testFeatureID, "temperature",
+		`{"value": 23}`))
+	pullPublishedEnvelope(s.S())
+	viewEvent = pullPublishedEnvelope(s.S())
+	assert.Equal(s.T(), "/views/dashboard", viewEvent.Path)
+	assert.JSONEq(s.T(), `{"temperature": 23, "humidity": 55}`, string(viewEvent.Value))
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/pkg/errors"
+)
+
+// errStorageStalled is the logged error of the command executions exceeding the storage deadline.
+var errStorageStalled = errors.New("storage operation stalled")
+
+// StorageWatchdog detects the command executions stalled on the things storage for longer than a deadline,
+// e.g. on a hung fsync of a failing SD card, and fails them with a thing unavailable error instead of
+// blocking the command sender.
+//
+// The stalled execution cannot be aborted, it is left to complete in background. Its changes,
+// if any, are persisted once it completes but neither published nor forwarded to the hub,
+// they are left unsynchronized to be sent by the synchronizer.
+type StorageWatchdog struct {
+	Deadline time.Duration
+
+	// Counters, if set, counts the stalled command executions.
+	Counters *status.Counters
+
+	Logger logger.Logger
+
+	stalled int32
+}
+
+// Run executes the command with a separate output and copies it to the provided one if completed
+// within the deadline. Otherwise, the thing unavailable error response is set, if required, and false is returned.
+func (w *StorageWatchdog) Run(cmd *Command, out *CommandOutput, execute func(out *CommandOutput)) bool {
+	started := time.Now()
+	result := &CommandOutput{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		execute(result)
+	}()
+
+	timer := time.NewTimer(w.Deadline)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		*out = *result
+		return true
+	case <-timer.C:
+	}
+
+	atomic.AddInt32(&w.stalled, 1)
+	w.Counters.Inc(status.CounterStorageStalls)
+
+	fields := CmdLogFields(cmd.envelope)
+	fields["deadline"] = w.Deadline.String()
+	w.Logger.Error("Thing command failed on stalled storage", errStorageStalled, fields)
+
+	go func() {
+		<-done
+		atomic.AddInt32(&w.stalled, -1)
+
+		fields := CmdLogFields(cmd.envelope)
+		fields["duration"] = time.Since(started).Round(time.Millisecond).String()
+		w.Logger.Warn("Stalled thing command execution completed", nil, fields)
+	}()
+
+	if cmd.envelope.Headers.ResponseRequired() {
+		out.response = NewThingUnavailableError(cmd.envelope, cmd.thingID)
+	}
+	return false
+}
+
+// Stalled returns the number of the command executions currently stalled on the storage.
+func (w *StorageWatchdog) Stalled() int {
+	return int(atomic.LoadInt32(&w.stalled))
+}
+
+// Check fails while there are command executions stalled on the storage, e.g. for the readiness probe.
+func (w *StorageWatchdog) Check() error {
+	if stalled := w.Stalled(); stalled > 0 {
+		return fmt.Errorf("%d command executions stalled on storage for longer than %s", stalled, w.Deadline)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package federation registers the local digital twins as a connectivity source of an Eclipse Ditto instance
+// running on the edge. The locally generated thing events are mapped to the twin commands applying the same
+// changes and published to a dedicated local broker topic, consumed by a Ditto connection to that broker.
+package federation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// Ditto connection types.
+const (
+	// ConnectionMQTT connects Ditto to the local MQTT broker, it is the default connection type.
+	ConnectionMQTT = "mqtt"
+	// ConnectionAMQP connects Ditto to an AMQP 1.0 endpoint, e.g. a router bridging the local broker topic.
+	ConnectionAMQP = "amqp-10"
+)
+
+const (
+	// DefaultTopic is the default prefix of the local broker topics the mapped twin commands are published to.
+	DefaultTopic = "ditto/things"
+
+	defaultConnectionID = "local-digital-twins"
+	defaultAuthSubject  = "integration:local-digital-twins"
+)
+
+// ConnectionSettings contains the settings of the generated Ditto connection.
+type ConnectionSettings struct {
+	// ID is the Ditto connection ID, 'local-digital-twins' if empty.
+	ID string
+	// Type is the Ditto connection type, mqtt if empty.
+	Type string
+	// URI is the URI of the endpoint Ditto connects to, e.g. 'tcp://localhost:1883'.
+	URI string
+	// Topic is the prefix of the local broker topics the twin commands are published to, DefaultTopic if empty.
+	Topic string
+	// AuthSubject is the authorization subject of the source, which has to be granted write access
+	// by the policies of the federated things, 'integration:local-digital-twins' if empty.
+	AuthSubject string
+}
+
+// Connection represents the Ditto connection configuration, as expected by the Ditto connectivity API.
+type Connection struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	ConnectionType   string   `json:"connectionType"`
+	ConnectionStatus string   `json:"connectionStatus"`
+	URI              string   `json:"uri"`
+	FailoverEnabled  bool     `json:"failoverEnabled"`
+	Sources          []Source `json:"sources"`
+	Targets          []Source `json:"targets"`
+}
+
+// Source represents a Ditto connection source.
+type Source struct {
+	Addresses            []string `json:"addresses"`
+	AuthorizationContext []string `json:"authorizationContext"`
+	QoS                  *int     `json:"qos,omitempty"`
+	PayloadMapping       []string `json:"payloadMapping,omitempty"`
+}
+
+// NewConnection generates the Ditto connection configuration consuming the twin commands published by
+// the local digital twins. Returns error if the connection type is unknown or the URI is missing.
+func NewConnection(settings ConnectionSettings) (*Connection, error) {
+	if len(settings.URI) == 0 {
+		return nil, errors.New("connection URI is mandatory")
+	}
+
+	id := valueOrDefault(settings.ID, defaultConnectionID)
+	source := Source{
+		AuthorizationContext: []string{valueOrDefault(settings.AuthSubject, defaultAuthSubject)},
+		PayloadMapping:       []string{"Ditto"},
+	}
+
+	topic := valueOrDefault(settings.Topic, DefaultTopic)
+	switch valueOrDefault(settings.Type, ConnectionMQTT) {
+	case ConnectionMQTT:
+		qos := 1
+		source.Addresses = []string{topic + "/#"}
+		source.QoS = &qos
+	case ConnectionAMQP:
+		source.Addresses = []string{topic}
+	default:
+		return nil, errors.Errorf("unknown connection type '%s'", settings.Type)
+	}
+
+	return &Connection{
+		ID:               id,
+		Name:             id,
+		ConnectionType:   valueOrDefault(settings.Type, ConnectionMQTT),
+		ConnectionStatus: "open",
+		URI:              settings.URI,
+		FailoverEnabled:  true,
+		Sources:          []Source{source},
+		Targets:          []Source{},
+	}, nil
+}
+
+// CommandTopic returns the local broker topic the twin commands of the thing are published to.
+func CommandTopic(prefix string, thingID string) string {
+	return fmt.Sprintf("%s/%s", valueOrDefault(prefix, DefaultTopic), thingID)
+}
+
+// eventCommands maps the twin event actions to the actions of the twin commands applying the same changes.
+var eventCommands = map[protocol.TopicAction]protocol.TopicAction{
+	protocol.ActionCreated:  protocol.ActionCreate,
+	protocol.ActionModified: protocol.ActionModify,
+	protocol.ActionMerged:   protocol.ActionMerge,
+	protocol.ActionDeleted:  protocol.ActionDelete,
+}
+
+// EventCommand maps the twin event to the twin command applying the same change, without a response required.
+// Returns nil if the event is not a twin event changing a thing, e.g. a live or a composite view one.
+func EventCommand(event *protocol.Envelope) *protocol.Envelope {
+	if event.Topic == nil || event.Topic.Channel != protocol.ChannelTwin ||
+		event.Topic.Criterion != protocol.CriterionEvents || strings.HasPrefix(event.Path, "/views") {
+		return nil
+	}
+
+	action, ok := eventCommands[event.Topic.Action]
+	if !ok {
+		return nil
+	}
+
+	topic := *event.Topic
+	topic.Criterion = protocol.CriterionCommands
+	topic.Action = action
+
+	headers := protocol.NewHeaders().WithResponseRequired(false)
+	if event.Headers != nil {
+		headers.WithCorrelationID(event.Headers.CorrelationID())
+	}
+	if action == protocol.ActionMerge {
+		headers.WithContentType(protocol.ContentTypeJSONMerge)
+	}
+
+	command := &protocol.Envelope{
+		Topic:   &topic,
+		Headers: headers,
+		Path:    event.Path,
+	}
+	if action != protocol.ActionDelete {
+		command.Value = event.Value
+	}
+	return command
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package federation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/federation"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+func TestNewConnection(t *testing.T) {
+	connection, err := federation.NewConnection(federation.ConnectionSettings{URI: "tcp://localhost:1883"})
+	require.NoError(t, err)
+	assert.Equal(t, "local-digital-twins", connection.ID)
+	assert.Equal(t, federation.ConnectionMQTT, connection.ConnectionType)
+	require.Len(t, connection.Sources, 1)
+	assert.Equal(t, []string{"ditto/things/#"}, connection.Sources[0].Addresses)
+	assert.Equal(t, []string{"integration:local-digital-twins"}, connection.Sources[0].AuthorizationContext)
+	require.NotNil(t, connection.Sources[0].QoS)
+	assert.Equal(t, 1, *connection.Sources[0].QoS)
+
+	connection, err = federation.NewConnection(federation.ConnectionSettings{
+		ID: "edge", Type: federation.ConnectionAMQP, URI: "amqp://localhost:5672", Topic: "twins", AuthSubject: "edge:twins",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "edge", connection.ID)
+	assert.Equal(t, federation.ConnectionAMQP, connection.ConnectionType)
+	assert.Equal(t, []string{"twins"}, connection.Sources[0].Addresses)
+	assert.Equal(t, []string{"edge:twins"}, connection.Sources[0].AuthorizationContext)
+	assert.Nil(t, connection.Sources[0].QoS)
+
+	_, err = federation.NewConnection(federation.ConnectionSettings{})
+	assert.Error(t, err)
+	_, err = federation.NewConnection(federation.ConnectionSettings{Type: "kafka", URI: "tcp://localhost:9092"})
+	assert.Error(t, err)
+}
+
+func TestCommandTopic(t *testing.T) {
+	assert.Equal(t, "ditto/things/org.eclipse.kanto:test", federation.CommandTopic("", "org.eclipse.kanto:test"))
+	assert.Equal(t, "twins/org.eclipse.kanto:test", federation.CommandTopic("twins", "org.eclipse.kanto:test"))
+}
+
+func TestEventCommand(t *testing.T) {
+	actions := map[string]string{
+		"created":  "create",
+		"modified": "modify",
+		"merged":   "merge",
+		"deleted":  "delete",
+	}
+	for event, action := range actions {
+		env := &protocol.Envelope{}
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"topic": "org.eclipse.kanto/test/things/twin/events/`+event+`",
+			"headers": {"correlation-id": "test", "timestamp-quality": "synced"},
+			"path": "/features/meter/properties/x",
+			"value": 1,
+			"revision": 5
+		}`), env))
+
+		command := federation.EventCommand(env)
+		require.NotNil(t, command, event)
+		assert.Equal(t, "org.eclipse.kanto/test/things/twin/commands/"+action, command.Topic.String())
+		assert.Equal(t, "test", command.Headers.CorrelationID())
+		assert.False(t, command.Headers.ResponseRequired())
+		assert.Equal(t, "/features/meter/properties/x", command.Path)
+		assert.Equal(t, int64(0), command.Revision)
+		if action == "delete" {
+			assert.Empty(t, command.Value)
+		} else {
+			assert.Equal(t, "1", string(command.Value))
+		}
+		if action == "merge" {
+			assert.Equal(t, protocol.ContentTypeJSONMerge, command.Headers.ContentType())
+		}
+	}
+
+	for _, topic := range []string{
+		"org.eclipse.kanto/test/things/live/events/modified",
+		"org.eclipse.kanto/test/things/twin/commands/modify",
+		"org.eclipse.kanto/test/things/twin/events/definitionMigrated",
+	} {
+		env := &protocol.Envelope{}
+		require.NoError(t, json.Unmarshal([]byte(`{"topic": "`+topic+`", "path": "/"}`), env))
+		assert.Nil(t, federation.EventCommand(env), topic)
+	}
+	views := &protocol.Envelope{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"topic": "org.eclipse.kanto/test/things/twin/events/modified", "path": "/views/energy"
+	}`), views))
+	assert.Nil(t, federation.EventCommand(views))
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package health
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errNotConnected is the error of the connection check while disconnected.
+var errNotConnected = errors.New("not connected")
+
+// Connection tracks the state of a broker connection, being its connection listener.
+type Connection struct {
+	connected int32
+}
+
+// Connected records the connection state change.
+func (c *Connection) Connected(connected bool, err error) {
+	if connected {
+		atomic.StoreInt32(&c.connected, 1)
+	} else {
+		atomic.StoreInt32(&c.connected, 0)
+	}
+}
+
+// Check checks that the connection is established.
+func (c *Connection) Check() error {
+	if atomic.LoadInt32(&c.connected) == 0 {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Progress tracks the messages in handling, so that a handling stalled for too long is detected.
+// An idle handler, i.e. one without messages in handling, is progressing.
+type Progress struct {
+	mutex    sync.Mutex
+	next     uint64
+	handling map[uint64]time.Time
+}
+
+// Begin records the start of a message handling and returns the function recording its end.
+func (p *Progress) Begin() func() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.handling == nil {
+		p.handling = make(map[uint64]time.Time)
+	}
+	id := p.next
+	p.next++
+	p.handling[id] = time.Now()
+
+	return func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		delete(p.handling, id)
+	}
+}
+
+// Check returns the check failing if a message is in handling for longer than the provided timeout.
+func (p *Progress) Check(timeout time.Duration) Check {
+	return func() error {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		for _, started := range p.handling {
+			if stalled := time.Since(started); stalled > timeout {
+				return fmt.Errorf("message handling stalled for %s", stalled.Round(time.Second))
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package health serves the liveness and readiness probes of the local digital twins service over HTTP,
+// so that the container orchestrators and watchdogs could restart a wedged service.
+package health
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+// Probes endpoints.
+const (
+	// PathLive is the liveness probe, failing if the service is wedged and has to be restarted.
+	PathLive = "/live"
+	// PathReady is the readiness probe, failing also while the service cannot handle the commands,
+	// e.g. on the local broker disconnection or while the things storage is maintained.
+	PathReady = "/ready"
+)
+
+// Status values.
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+// Check returns an error if the checked component is not healthy.
+type Check func() error
+
+// Status is the response of the probes.
+type Status struct {
+	// Status is StatusUp if all checks passed, otherwise StatusDown.
+	Status string `json:"status"`
+	// Checks contains StatusUp or the error of each check by its name.
+	Checks map[string]string `json:"checks"`
+}
+
+// Server serves the liveness and readiness probes on a TCP address. The readiness probe runs
+// the liveness checks too, as a service which is not live is not ready either.
+type Server struct {
+	Liveness  map[string]Check
+	Readiness map[string]Check
+
+	Logger logger.Logger
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// Start starts serving the probes on the provided TCP address.
+func (s *Server) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrap(err, "cannot listen for health probes")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathLive, func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, run(s.Liveness))
+	})
+	mux.HandleFunc(PathReady, func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, run(s.Liveness, s.Readiness))
+	})
+
+	s.listener = listener
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Error("Health probes server stopped", err, nil)
+		}
+	}()
+	s.Logger.Infof("Serving health probes on %s", listener.Addr())
+	return nil
+}
+
+// Addr returns the address the probes are served on, nil if not started.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop stops serving the probes.
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// StorageCheck checks that the things storage is opened and readable.
+func StorageCheck(storage persistence.ThingsStorage) Check {
+	return func() error {
+		_, err := storage.GetThingIDs()
+		return err
+	}
+}
+
+func run(checks ...map[string]Check) *Status {
+	status := &Status{Status: StatusUp, Checks: make(map[string]string)}
+	for _, group := range checks {
+		names := make([]string, 0, len(group))
+		for name := range group {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := group[name](); err != nil {
+				status.Status = StatusDown
+				status.Checks[name] = err.Error()
+			} else {
+				status.Checks[name] = StatusUp
+			}
+		}
+	}
+	return status
+}
+
+func writeStatus(w http.ResponseWriter, status *Status) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
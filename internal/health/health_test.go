@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/health"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+func TestProbes(t *testing.T) {
+	storage := persistence.NewInMemoryThingsDB("org.eclipse.kanto:device", 0)
+	defer storage.Close()
+
+	progress := &health.Progress{}
+	broker := &health.Connection{}
+	server := &health.Server{
+		Liveness: map[string]health.Check{"handler": progress.Check(time.Hour)},
+		Readiness: map[string]health.Check{
+			"storage": health.StorageCheck(storage),
+			"broker":  broker.Check,
+		},
+		Logger: testutil.NewLogger("health", logger.DEBUG, t),
+	}
+	require.NoError(t, server.Start("127.0.0.1:0"))
+	defer server.Stop()
+	url := "http://" + server.Addr().String()
+
+	status := probe(t, url+health.PathLive, http.StatusOK)
+	assert.Equal(t, map[string]string{"handler": health.StatusUp}, status.Checks)
+
+	status = probe(t, url+health.PathReady, http.StatusServiceUnavailable)
+	assert.Equal(t, health.StatusDown, status.Status)
+	assert.Equal(t, health.StatusUp, status.Checks["storage"])
+	assert.Equal(t, "not connected", status.Checks["broker"])
+
+	broker.Connected(true, nil)
+	status = probe(t, url+health.PathReady, http.StatusOK)
+	assert.Equal(t, health.StatusUp, status.Status)
+	assert.Len(t, status.Checks, 3)
+
+	require.NoError(t, storage.Close())
+	status = probe(t, url+health.PathReady, http.StatusServiceUnavailable)
+	assert.NotEqual(t, health.StatusUp, status.Checks["storage"])
+	probe(t, url+health.PathLive, http.StatusOK)
+}
+
+func TestProgressStalled(t *testing.T) {
+	progress := &health.Progress{}
+	check := progress.Check(10 * time.Millisecond)
+	assert.NoError(t, check())
+
+	done := progress.Begin()
+	assert.NoError(t, check())
+	assert.Eventually(t, func() bool {
+		return check() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	done()
+	assert.NoError(t, check())
+}
+
+func probe(t *testing.T, url string, code int) *health.Status {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, code, resp.StatusCode)
+	status := &health.Status{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(status))
+	return status
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil
+
+import (
+	"strconv"
+	"strings"
+)
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// Flatten returns the leaf values of the provided value mapped by their JSON pointers
+// (https://datatracker.ietf.org/doc/html/rfc6901), e.g. {"/properties/x": 1} for {"properties": {"x": 1}}.
+// The value is expected to be in its generic decoded form, i.e. map[string]interface{},
+// []interface{} or JSON primitive value. The array items are mapped by their indexes
+// and the empty objects and arrays are kept as leaf values, so that no member is lost.
+// A primitive value is mapped by the empty root pointer.
+func Flatten(value interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	flatten("", value, flat)
+	return flat
+}
+
+func flatten(pointer string, value interface{}, flat map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			flat[pointer] = v
+		}
+		for key, member := range v {
+			flatten(pointer+"/"+pointerEscaper.Replace(key), member, flat)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			flat[pointer] = v
+		}
+		for i, item := range v {
+			flatten(pointer+"/"+strconv.Itoa(i), item, flat)
+		}
+	default:
+		flat[pointer] = v
+	}
+}
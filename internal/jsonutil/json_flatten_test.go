@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		flat  string
+	}{
+		"nested": {
+			value: `{"definition": ["a:b:1"], "properties": {"x": 1, "y": {"z": "v"}}}`,
+			flat:  `{"/definition/0": "a:b:1", "/properties/x": 1, "/properties/y/z": "v"}`,
+		},
+		"escaped": {
+			value: `{"a/b": {"c~d": true}}`,
+			flat:  `{"/a~1b/c~0d": true}`,
+		},
+		"empty": {
+			value: `{"properties": {}, "definition": [], "x": null}`,
+			flat:  `{"/properties": {}, "/definition": [], "/x": null}`,
+		},
+		"primitive": {
+			value: `23.4`,
+			flat:  `{"": 23.4}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var value interface{}
+			require.NoError(t, json.Unmarshal([]byte(test.value), &value))
+
+			flat, err := json.Marshal(jsonutil.Flatten(value))
+			require.NoError(t, err)
+			assert.JSONEq(t, test.flat, string(flat))
+		})
+	}
+}
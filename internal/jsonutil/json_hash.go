@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// HashAlgorithm is the name of a hash function of the canonical JSON state.
+type HashAlgorithm string
+
+// Supported hash algorithms.
+const (
+	// HashFNV64a is a fast non-cryptographic hash, e.g. for entity tags and caching keys.
+	HashFNV64a HashAlgorithm = "fnv64a"
+	// HashSHA1 is provided for interoperability only.
+	HashSHA1 HashAlgorithm = "sha1"
+	// HashSHA256 is the default hash, e.g. for state divergence checks and digests.
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA512 HashAlgorithm = "sha512"
+)
+
+// ErrUnknownHashAlgorithm is returned if the requested hash algorithm is not supported.
+var ErrUnknownHashAlgorithm = errors.New("unknown hash algorithm")
+
+// NewHash returns a new hash of the provided algorithm, SHA-256 if not set.
+func NewHash(algorithm HashAlgorithm) (hash.Hash, error) {
+	switch algorithm {
+	case HashSHA256, "":
+		return sha256.New(), nil
+	case HashFNV64a:
+		return fnv.New64a(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownHashAlgorithm, "'%s'", algorithm)
+	}
+}
+
+// Hash returns the hex encoded hash of the canonical JSON form of the provided value,
+// so that the equal JSON states are hashed identically regardless of their member order and number format.
+func Hash(value interface{}, algorithm HashAlgorithm) (string, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	data, err := CanonicalJSON(value)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CanonicalJSON returns the canonical JSON form of the provided value, i.e. without any whitespace,
+// with the object members sorted by their names, the strings not HTML escaped and the numbers normalized,
+// e.g. 1, 1.0 and 1e0 are all written as 1. The numbers are normalized as float64 values, so the integers
+// beyond its precision are hashed as their nearest float64 value.
+// The value could be a JSON document as json.RawMessage or any value marshaled by encoding/json.
+func CanonicalJSON(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case string:
+		return writeCanonicalString(buf, v)
+
+	case json.Number:
+		number, err := v.Float64()
+		if err != nil {
+			return errors.Wrapf(err, "invalid number '%s'", v)
+		}
+		buf.WriteString(canonicalNumber(number))
+
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+
+	case nil:
+		buf.WriteString("null")
+
+	default:
+		return errors.Errorf("unexpected JSON value type %T", value)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, value string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // the new line written by the encoder
+	return nil
+}
+
+// canonicalNumber writes the integral numbers without fraction and exponent, as long as they are exact,
+// and all others in their shortest form.
+func canonicalNumber(number float64) string {
+	if number == 0 {
+		return "0" // including the negative zero
+	}
+	if number == math.Trunc(number) && math.Abs(number) < 1e21 {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(number, 'g', -1, 64)
+}
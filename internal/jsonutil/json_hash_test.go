@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	type canonicalTest struct {
+		input    string
+		expected string
+	}
+
+	tests := []canonicalTest{
+		{`{"b": 1, "a": {"d": [3, 2, 1], "c": null}}`, `{"a":{"c":null,"d":[3,2,1]},"b":1}`},
+		{`[1.0, 1e0, 10E-1, -0, 0.5, 1.5e2]`, `[1,1,1,0,0.5,150]`},
+		{`[1e21, 1e-7, 123456789012]`, `[1e+21,1e-07,123456789012]`},
+		{`{"html": "<a & b>", "unicode": "ä"}`, `{"html":"<a & b>","unicode":"ä"}`},
+		{`[true, false, "", {}, []]`, `[true,false,"",{},[]]`},
+	}
+
+	for _, test := range tests {
+		canonical, err := jsonutil.CanonicalJSON(json.RawMessage(test.input))
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, string(canonical), test.input)
+	}
+}
+
+func TestHash(t *testing.T) {
+	value := map[string]interface{}{"a": 1, "b": []interface{}{"x", 2.0}}
+	same := json.RawMessage(`{"b": ["x", 2], "a": 1.0}`)
+
+	for _, algorithm := range []jsonutil.HashAlgorithm{
+		jsonutil.HashFNV64a, jsonutil.HashSHA1, jsonutil.HashSHA256, jsonutil.HashSHA512,
+	} {
+		expected, err := jsonutil.Hash(value, algorithm)
+		require.NoError(t, err)
+		actual, err := jsonutil.Hash(same, algorithm)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual, algorithm)
+
+		other, err := jsonutil.Hash(map[string]interface{}{"a": 2}, algorithm)
+		require.NoError(t, err)
+		assert.NotEqual(t, expected, other, algorithm)
+	}
+
+	// the sha256 of {"a":1}
+	sha, err := jsonutil.Hash(json.RawMessage(`{ "a" : 1.0 }`), "")
+	require.NoError(t, err)
+	assert.Equal(t, "015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862", sha)
+
+	_, err = jsonutil.Hash(value, "md4")
+	assert.True(t, errors.Is(err, jsonutil.ErrUnknownHashAlgorithm), err)
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// MergePatch applies the provided JSON merge patch (https://datatracker.ietf.org/doc/html/rfc7396)
+// to the target value and returns the merged result.
+// Both values are expected to be in their generic decoded form, i.e. map[string]interface{},
+// []interface{} or JSON primitive value. The target map is modified in place.
+//
+// If the patch is not a JSON object it replaces the target. Any patch member with nil value
+// removes the corresponding target member, all other members are merged recursively.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = MergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// MergePatchAt applies the provided JSON merge patch to the member of the target value at the path,
+// given as JSON pointer reference tokens, and returns the merged result.
+// The missing path members are created and a nil patch removes the member at the path.
+// The target maps are modified in place.
+func MergePatchAt(target interface{}, path []string, patch interface{}) interface{} {
+	if len(path) == 0 {
+		return MergePatch(target, patch)
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	if len(path) == 1 && patch == nil {
+		delete(targetObject, path[0])
+	} else {
+		targetObject[path[0]] = MergePatchAt(targetObject[path[0]], path[1:], patch)
+	}
+	return targetObject
+}
+
+// MergeDiff returns the JSON merge patch transforming the source value into the target one,
+// i.e. MergePatch(source, MergeDiff(source, target)) results in the target value.
+// Both values are expected to be in their generic decoded form. Returns false if the values are equal.
+//
+// The members of the target objects with nil value cannot be expressed by a merge patch
+// and are treated as missing ones.
+func MergeDiff(source, target interface{}) (interface{}, bool) {
+	sourceObject, sourceOk := source.(map[string]interface{})
+	targetObject, targetOk := target.(map[string]interface{})
+	if !sourceOk || !targetOk {
+		if reflect.DeepEqual(source, target) {
+			return nil, false
+		}
+		return target, true
+	}
+
+	patch := make(map[string]interface{})
+	for key := range sourceObject {
+		if value, ok := targetObject[key]; !ok || value == nil {
+			patch[key] = nil
+		}
+	}
+	for key, value := range targetObject {
+		if value == nil {
+			continue
+		}
+		if sourceValue, ok := sourceObject[key]; ok && sourceValue != nil {
+			if diff, changed := MergeDiff(sourceValue, value); changed {
+				patch[key] = diff
+			}
+		} else {
+			patch[key] = value
+		}
+	}
+	return patch, len(patch) > 0
+}
+
+// MergeRemovals returns the members of the source object removed in the target value as a JSON merge patch
+// removing them, i.e. a nil value for each removed member, nil if none are removed.
+// The members of a removed object are all removed too, i.e. it has the object of its removed members
+// instead of nil, so that they are still removed if the object is added back.
+func MergeRemovals(source, target interface{}) map[string]interface{} {
+	sourceObject, ok := source.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	targetObject, _ := target.(map[string]interface{})
+
+	removals := make(map[string]interface{})
+	for key, value := range sourceObject {
+		targetValue, ok := targetObject[key]
+		removed := MergeRemovals(value, targetValue)
+		if removed != nil {
+			removals[key] = removed
+		} else if !ok || targetValue == nil {
+			removals[key] = nil
+		}
+	}
+	if len(removals) == 0 {
+		return nil
+	}
+	return removals
+}
+
+// AddRemovals adds the removals to the previous ones, both returned by MergeRemovals, and returns the result.
+// The previous removals map is modified in place.
+func AddRemovals(previous, removals map[string]interface{}) map[string]interface{} {
+	if len(removals) == 0 {
+		return previous
+	}
+	if previous == nil {
+		previous = make(map[string]interface{}, len(removals))
+	}
+	for key, value := range removals {
+		previousObject, _ := previous[key].(map[string]interface{})
+		object, _ := value.(map[string]interface{})
+		if object != nil {
+			previous[key] = AddRemovals(previousObject, object)
+		} else if previousObject == nil {
+			previous[key] = nil
+		}
+	}
+	return previous
+}
+
+// WithRemovals returns the JSON merge patch replacing the target members with the value ones, i.e. the value
+// with the removed members, returned by MergeRemovals, which are not present in it, set to nil.
+// The value is not modified.
+func WithRemovals(value interface{}, removals map[string]interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(removals) == 0 {
+		return value
+	}
+
+	patch := make(map[string]interface{}, len(object)+len(removals))
+	for key, member := range object {
+		patch[key] = member
+	}
+	for key, removed := range removals {
+		member, ok := object[key]
+		if !ok || member == nil {
+			patch[key] = nil
+		} else if removedObject, isObject := removed.(map[string]interface{}); isObject {
+			patch[key] = WithRemovals(member, removedObject)
+		}
+	}
+	return patch
+}
+
+// MergeJSON applies the provided JSON merge patch to the JSON representation of the target
+// and decodes the merged result into the provided result value.
+// Returns error if the target cannot be encoded, the patch is not a valid JSON or
+// the merged value cannot be decoded into the result.
+func MergeJSON(target interface{}, patch []byte, result interface{}) error {
+	targetData, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+
+	var targetValue interface{}
+	if err := json.Unmarshal(targetData, &targetValue); err != nil {
+		return err
+	}
+
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return err
+	}
+
+	mergedData, err := json.Marshal(MergePatch(targetValue, patchValue))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(mergedData, result)
+}
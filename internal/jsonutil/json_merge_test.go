@@ -0,0 +1,223 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	type mergeTest struct {
+		target   string
+		patch    string
+		expected string
+	}
+
+	// test cases from https://datatracker.ietf.org/doc/html/rfc7396#appendix-A
+	tests := []mergeTest{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, test := range tests {
+		var target, patch interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.target), &target))
+		require.NoError(t, json.Unmarshal([]byte(test.patch), &patch))
+
+		merged, err := json.Marshal(jsonutil.MergePatch(target, patch))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.expected, string(merged), test.patch)
+	}
+}
+
+func TestMergePatchAt(t *testing.T) {
+	type mergeAtTest struct {
+		target   string
+		path     []string
+		patch    string
+		expected string
+	}
+
+	tests := []mergeAtTest{
+		{`{"a":{"b":"c"}}`, nil, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, []string{"a"}, `{"b":null,"c":"d"}`, `{"a":{"c":"d"}}`},
+		{`{"a":{"b":"c"}}`, []string{"a", "b"}, `null`, `{"a":{}}`},
+		{`{"a":{"b":"c"}}`, []string{"a", "b"}, `{"c":"d"}`, `{"a":{"b":{"c":"d"}}}`},
+		{`{"a":{"b":"c"}}`, []string{"x", "y"}, `1`, `{"a":{"b":"c"},"x":{"y":1}}`},
+		{`{"a":{"b":"c"}}`, []string{"x", "y"}, `null`, `{"a":{"b":"c"},"x":{}}`},
+		{`{"a":"b"}`, []string{"a", "b"}, `"c"`, `{"a":{"b":"c"}}`},
+	}
+
+	for _, test := range tests {
+		var target, patch interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.target), &target))
+		require.NoError(t, json.Unmarshal([]byte(test.patch), &patch))
+
+		merged, err := json.Marshal(jsonutil.MergePatchAt(target, test.path, patch))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.expected, string(merged), test.patch)
+	}
+}
+
+func TestMergeDiff(t *testing.T) {
+	type diffTest struct {
+		source string
+		target string
+		patch  string
+	}
+
+	tests := []diffTest{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"a":"b","b":"c"}`, `{"b":"c"}`},
+		{`{"a":"b","b":"c"}`, `{"b":"c"}`, `{"a":null}`},
+		{`{"a":{"b":"c","d":1}}`, `{"a":{"b":"d","d":1}}`, `{"a":{"b":"d"}}`},
+		{`{"a":["b"]}`, `{"a":["b","c"]}`, `{"a":["b","c"]}`},
+		{`{"a":"c"}`, `{"a":{"b":1}}`, `{"a":{"b":1}}`},
+		{`["a","b"]`, `{"a":"b"}`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		var source, target interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.source), &source))
+		require.NoError(t, json.Unmarshal([]byte(test.target), &target))
+
+		patch, changed := jsonutil.MergeDiff(source, target)
+		require.True(t, changed, test.target)
+		patchData, err := json.Marshal(patch)
+		require.NoError(t, err)
+		assert.JSONEq(t, test.patch, string(patchData), test.target)
+
+		merged, err := json.Marshal(jsonutil.MergePatch(source, patch))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.target, string(merged), test.target)
+	}
+
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"a":{"b":[1,2]}}`), &value))
+	_, changed := jsonutil.MergeDiff(value, value)
+	assert.False(t, changed)
+}
+
+func TestMergeRemovals(t *testing.T) {
+	type removalsTest struct {
+		source   string
+		target   string
+		removals string
+	}
+
+	tests := []removalsTest{
+		{`{"a":"b","b":"c"}`, `{"b":"d"}`, `{"a":null}`},
+		{`{"a":{"b":"c","d":1}}`, `{"a":{"b":"d"}}`, `{"a":{"d":null}}`},
+		{`{"a":{"b":"c","d":{"e":1}},"f":2}`, `{"f":2}`, `{"a":{"b":null,"d":{"e":null}}}`},
+		{`{"a":{"b":"c"}}`, `{"a":1}`, `{"a":{"b":null}}`},
+		{`{"a":{}}`, `{}`, `{"a":null}`},
+		{`{"a":"b"}`, `null`, `{"a":null}`},
+	}
+
+	for _, test := range tests {
+		var source, target interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.source), &source))
+		require.NoError(t, json.Unmarshal([]byte(test.target), &target))
+
+		removalsData, err := json.Marshal(jsonutil.MergeRemovals(source, target))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.removals, string(removalsData), test.target)
+	}
+
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"a":{"b":[1,2]}}`), &value))
+	assert.Nil(t, jsonutil.MergeRemovals(value, value))
+	assert.Nil(t, jsonutil.MergeRemovals("a", value))
+	assert.Nil(t, jsonutil.MergeRemovals(value, map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": 2}}))
+}
+
+func TestAddRemovals(t *testing.T) {
+	var removals map[string]interface{}
+	removals = jsonutil.AddRemovals(removals, map[string]interface{}{"a": map[string]interface{}{"b": nil}})
+	removals = jsonutil.AddRemovals(removals, map[string]interface{}{"a": nil, "c": nil})
+	removals = jsonutil.AddRemovals(removals, map[string]interface{}{"a": map[string]interface{}{"d": nil}})
+	removals = jsonutil.AddRemovals(removals, nil)
+
+	removalsData, err := json.Marshal(removals)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":{"b":null,"d":null},"c":null}`, string(removalsData))
+}
+
+func TestWithRemovals(t *testing.T) {
+	type withRemovalsTest struct {
+		value    string
+		removals string
+		patch    string
+	}
+
+	tests := []withRemovalsTest{
+		{`{"a":"b"}`, `{"c":null}`, `{"a":"b","c":null}`},
+		{`{"a":"b"}`, `{"a":null}`, `{"a":"b"}`},
+		{`{"a":{"b":1}}`, `{"a":{"b":null,"c":null},"d":{"e":null}}`, `{"a":{"b":1,"c":null},"d":null}`},
+		{`{"a":1}`, `{"a":{"b":null}}`, `{"a":1}`},
+		{`{"a":"b"}`, `{}`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		var value interface{}
+		var removals map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.value), &value))
+		require.NoError(t, json.Unmarshal([]byte(test.removals), &removals))
+
+		patchData, err := json.Marshal(jsonutil.WithRemovals(value, removals))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.patch, string(patchData), test.removals)
+	}
+}
+
+func TestMergeJSON(t *testing.T) {
+	target := map[string]interface{}{
+		"meter": map[string]interface{}{
+			"properties":        map[string]interface{}{"x": 1},
+			"desiredProperties": map[string]interface{}{"x": 2},
+		},
+	}
+	patch := `{"meter": {"desiredProperties": {"x": null, "y": 3}}, "sensor": {"properties": {"z": 4}}}`
+
+	result := make(map[string]interface{})
+	require.NoError(t, jsonutil.MergeJSON(target, []byte(patch), &result))
+
+	merged, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"meter": {"properties": {"x": 1}, "desiredProperties": {"y": 3}}, "sensor": {"properties": {"z": 4}}}`,
+		string(merged))
+}
+
+func TestMergeJSONInvalidPatch(t *testing.T) {
+	result := make(map[string]interface{})
+	assert.Error(t, jsonutil.MergeJSON(map[string]interface{}{}, []byte(`{"a":`), &result))
+}
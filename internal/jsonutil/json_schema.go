@@ -0,0 +1,309 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	parser "github.com/Jeffail/gabs/v2"
+	"github.com/pkg/errors"
+)
+
+// Schema is the supported subset of JSON schema (https://json-schema.org), used also by the WoT data schemas.
+// The values are expected to be in their generic decoded form, i.e. map[string]interface{},
+// []interface{} or JSON primitive value.
+type Schema struct {
+	Type                 SchemaTypes        `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaTypes contains the allowed JSON types of a schema, provided as a single type or as an array of types.
+type SchemaTypes []string
+
+// UnmarshalJSON unmarshals a single type or an array of types.
+func (t *SchemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = SchemaTypes{single}
+		return nil
+	}
+	var types []string
+	if err := json.Unmarshal(data, &types); err != nil {
+		return err
+	}
+	*t = types
+	return nil
+}
+
+// ParseSchema parses and compiles the JSON schema.
+func ParseSchema(data []byte) (*Schema, error) {
+	schema := &Schema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+	if err := schema.Compile(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Compile compiles the schema patterns at all levels.
+func (s *Schema) Compile() error {
+	if len(s.Pattern) > 0 {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid schema pattern '%s'", s.Pattern)
+		}
+		s.pattern = pattern
+	}
+	for _, property := range s.Properties {
+		if property != nil {
+			if err := property.Compile(); err != nil {
+				return err
+			}
+		}
+	}
+	if s.Items != nil {
+		return s.Items.Compile()
+	}
+	return nil
+}
+
+// At returns the schema of the value at the provided JSON pointer, e.g. '/temperature/value'.
+// Returns false if the value is not described by the schema and the schema does not allow it as additional property.
+func (s *Schema) At(pointer string) (*Schema, bool) {
+	if len(pointer) == 0 || pointer == "/" {
+		return s, true
+	}
+	hierarchy, err := parser.JSONPointerToSlice(pointer)
+	if err != nil {
+		return nil, false
+	}
+
+	current := s
+	for _, key := range hierarchy {
+		if current == nil {
+			return nil, true // not restricted
+		}
+		if current.Items != nil && current.isType("array") {
+			current = current.Items
+			continue
+		}
+		if next, ok := current.Properties[key]; ok {
+			current = next
+			continue
+		}
+		if current.AdditionalProperties != nil && !*current.AdditionalProperties {
+			return nil, false
+		}
+		current = nil
+	}
+	return current, true
+}
+
+// Defaults returns the default value described by the schema, i.e. its 'default' value or for objects
+// without such, the object of the default values of its properties. Returns nil if there is no default value.
+func (s *Schema) Defaults() interface{} {
+	if s.Default != nil || len(s.Properties) == 0 {
+		return s.Default
+	}
+	defaults := make(map[string]interface{})
+	for name, property := range s.Properties {
+		if property == nil {
+			continue
+		}
+		if value := property.Defaults(); value != nil {
+			defaults[name] = value
+		}
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+	return defaults
+}
+
+// Validate validates the value against the schema. If partial, the value is a JSON merge patch,
+// i.e. the required object members could be missing and the nil members are removals.
+func (s *Schema) Validate(value interface{}, partial bool) error {
+	return s.validate("", value, partial)
+}
+
+func (s *Schema) validate(path string, value interface{}, partial bool) error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Type) > 0 && !s.matchType(value) {
+		return schemaError(path, "expected type %s", strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		return schemaError(path, "value is not one of the allowed values")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, v, partial)
+	case []interface{}:
+		return s.validateArray(path, v)
+	case string:
+		return s.validateString(path, v)
+	case float64:
+		return s.validateNumber(path, v)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return s.validateNumber(path, f)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, value map[string]interface{}, partial bool) error {
+	if !partial {
+		for _, required := range s.Required {
+			if _, ok := value[required]; !ok {
+				return schemaError(path, "missing required property '%s'", required)
+			}
+		}
+	}
+	for key, member := range value {
+		if partial && member == nil {
+			continue
+		}
+		if property, ok := s.Properties[key]; ok {
+			if err := property.validate(path+"/"+key, member, partial); err != nil {
+				return err
+			}
+		} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			return schemaError(path, "property '%s' is not allowed", key)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(path string, value []interface{}) error {
+	if s.MinItems != nil && len(value) < *s.MinItems {
+		return schemaError(path, "expected at least %d items", *s.MinItems)
+	}
+	if s.MaxItems != nil && len(value) > *s.MaxItems {
+		return schemaError(path, "expected at most %d items", *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i, item := range value {
+			if err := s.Items.validate(fmt.Sprintf("%s/%d", path, i), item, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(path string, value string) error {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		return schemaError(path, "expected at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return schemaError(path, "expected at most %d characters", *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		return schemaError(path, "value does not match pattern '%s'", s.Pattern)
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(path string, value float64) error {
+	if s.Minimum != nil && value < *s.Minimum {
+		return schemaError(path, "expected minimum %v", *s.Minimum)
+	}
+	if s.Maximum != nil && value > *s.Maximum {
+		return schemaError(path, "expected maximum %v", *s.Maximum)
+	}
+	return nil
+}
+
+func (s *Schema) isType(jsonType string) bool {
+	for _, t := range s.Type {
+		if t == jsonType {
+			return true
+		}
+	}
+	return len(s.Type) == 0
+}
+
+func (s *Schema) matchType(value interface{}) bool {
+	for _, t := range s.Type {
+		if valueOfType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func valueOfType(value interface{}, jsonType string) bool {
+	switch v := value.(type) {
+	case nil:
+		return jsonType == "null"
+	case bool:
+		return jsonType == "boolean"
+	case string:
+		return jsonType == "string"
+	case map[string]interface{}:
+		return jsonType == "object"
+	case []interface{}:
+		return jsonType == "array"
+	case float64:
+		return jsonType == "number" || (jsonType == "integer" && v == math.Trunc(v))
+	case json.Number:
+		if jsonType == "number" {
+			return true
+		}
+		_, err := v.Int64()
+		return jsonType == "integer" && err == nil
+	}
+	return false
+}
+
+func (s *Schema) inEnum(value interface{}) bool {
+	for _, allowed := range s.Enum {
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaError(path string, format string, a ...interface{}) error {
+	if len(path) == 0 {
+		path = "/"
+	}
+	return errors.Errorf("%s: %s", path, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package jsonutil_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"temperature": {
+			"type": "object",
+			"properties": {
+				"value": {"type": "number", "minimum": -40, "maximum": 125},
+				"unit": {"type": "string", "enum": ["C", "F"]}
+			},
+			"required": ["value"]
+		},
+		"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+		"count": {"type": ["integer", "null"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+	},
+	"required": ["temperature"],
+	"additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := jsonutil.ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	type schemaTest struct {
+		value   string
+		partial bool
+		valid   bool
+	}
+	tests := []schemaTest{
+		{`{"temperature": {"value": 21.5, "unit": "C"}}`, false, true},
+		{`{"temperature": {"value": 21}, "name": "meter", "count": 3, "tags": ["a", "b"]}`, false, true},
+		{`{"temperature": {"value": 21}, "count": null}`, false, true},
+		{`{"name": "meter"}`, true, true},
+		{`{"temperature": null}`, true, true},
+		{`{"name": "meter"}`, false, false},
+		{`{"temperature": {"unit": "C"}}`, false, false},
+		{`{"temperature": {"value": 200}}`, false, false},
+		{`{"temperature": {"value": "21"}}`, false, false},
+		{`{"temperature": {"value": 21, "unit": "K"}}`, false, false},
+		{`{"temperature": {"value": 21}, "name": ""}`, false, false},
+		{`{"temperature": {"value": 21}, "name": "too-long-name"}`, false, false},
+		{`{"temperature": {"value": 21}, "name": "Meter"}`, false, false},
+		{`{"temperature": {"value": 21}, "count": 1.5}`, false, false},
+		{`{"temperature": {"value": 21}, "tags": ["a", 1]}`, false, false},
+		{`{"temperature": {"value": 21}, "tags": ["a", "b", "c"]}`, false, false},
+		{`{"temperature": {"value": 21}, "unknown": true}`, false, false},
+		{`[]`, false, false},
+	}
+	for _, test := range tests {
+		var value interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.value), &value))
+		err := schema.Validate(value, test.partial)
+		if test.valid {
+			assert.NoError(t, err, test.value)
+		} else {
+			assert.Error(t, err, test.value)
+		}
+	}
+}
+
+func TestSchemaAt(t *testing.T) {
+	schema, err := jsonutil.ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	value, ok := schema.At("/temperature/value")
+	require.True(t, ok)
+	assert.Error(t, value.Validate(200.0, false))
+	assert.NoError(t, value.Validate(20.0, false))
+
+	item, ok := schema.At("/tags/0")
+	require.True(t, ok)
+	assert.Error(t, item.Validate(1.0, false))
+
+	_, ok = schema.At("/unknown")
+	assert.False(t, ok)
+
+	// not restricted
+	unit, ok := schema.At("/temperature/extra/value")
+	assert.True(t, ok)
+	assert.Nil(t, unit)
+
+	root, ok := schema.At("")
+	assert.True(t, ok)
+	assert.Equal(t, schema, root)
+}
+
+func TestSchemaDefaults(t *testing.T) {
+	schema, err := jsonutil.ParseSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"temperature": {
+				"type": "object",
+				"properties": {
+					"value": {"type": "number", "default": 0},
+					"unit": {"type": "string", "default": "C"}
+				}
+			},
+			"interval": {"type": "integer", "default": 10},
+			"name": {"type": "string"},
+			"location": {"type": "object", "default": {"room": "hall"}}
+		}
+	}`))
+	require.NoError(t, err)
+
+	expected := map[string]interface{}{
+		"temperature": map[string]interface{}{"value": 0.0, "unit": "C"},
+		"interval":    10.0,
+		"location":    map[string]interface{}{"room": "hall"},
+	}
+	assert.Equal(t, expected, schema.Defaults())
+
+	schema, err = jsonutil.ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+	assert.Nil(t, schema.Defaults())
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	_, err := jsonutil.ParseSchema([]byte(`{"type": 1}`))
+	assert.Error(t, err)
+
+	_, err = jsonutil.ParseSchema([]byte(`{"properties": {"name": {"pattern": "[a-"}}}`))
+	assert.Error(t, err)
+}
@@ -18,6 +18,7 @@ type Feature struct {
 	Definition        []*DefinitionID        `json:"definition,omitempty"`
 	Properties        map[string]interface{} `json:"properties,omitempty"`
 	DesiredProperties map[string]interface{} `json:"desiredProperties,omitempty"`
+	Metadata          map[string]interface{} `json:"-"`
 }
 
 // WithDefinitionFrom is an auxiliary method to set the Feature's definition from an array of strings converted into the proper DefinitionID instances.
@@ -68,3 +69,9 @@ func (feature *Feature) WithDesiredProperty(id string, value interface{}) *Featu
 	feature.DesiredProperties[id] = value
 	return feature
 }
+
+// WithMetadata sets the metadata of the current Feature instance.
+func (feature *Feature) WithMetadata(metadata map[string]interface{}) *Feature {
+	feature.Metadata = metadata
+	return feature
+}
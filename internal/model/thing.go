@@ -22,6 +22,10 @@ type Thing struct {
 	Features     map[string]*Feature    `json:"features,omitempty"`
 	Revision     int64                  `json:"-"`
 	Timestamp    string                 `json:"-"`
+	// TimestampQuality is non-empty if the timestamp is affected by a detected wall clock jump.
+	TimestampQuality string `json:"-"`
+	// Metadata contains the thing level metadata, i.e. without its features metadata.
+	Metadata map[string]interface{} `json:"-"`
 }
 
 // WithID sets the provided NamespacedID as the current Thing's instance ID value.
@@ -76,6 +80,12 @@ func (thing *Thing) WithAttribute(id string, value interface{}) *Thing {
 	return thing
 }
 
+// WithMetadata sets the thing level metadata of the current Thing instance.
+func (thing *Thing) WithMetadata(metadata map[string]interface{}) *Thing {
+	thing.Metadata = metadata
+	return thing
+}
+
 // WithFeatures sets all features to the current Thing instance.
 func (thing *Thing) WithFeatures(features map[string]*Feature) *Thing {
 	thing.Features = features
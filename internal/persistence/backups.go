@@ -0,0 +1,210 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BackupRetention defines the retention policy of the things database backup files created automatically,
+// i.e. the files stored next to the database file and named with the database file name, a suffix and
+// their creation time, e.g. 'things.db.<device-id>.<timestamp>' created on device ID change or
+// 'things.db.corrupted.<timestamp>' created on corrupted database recovery. The backup files created by
+// the previous versions on device ID change, i.e. 'things.db.<device-id>' with the ':' of the device ID replaced
+// by '_' or 'things.db.<unix-time>', are managed too. Any other files, e.g. the backups made by the user,
+// are never removed. The SQLite side files of a backup are kept and removed together with it.
+type BackupRetention struct {
+	// MaxCount is the maximum number of the newest backup files to be kept, zero or negative for unlimited.
+	MaxCount int
+	// MaxSize is the maximum total size in bytes of the backup files to be kept, zero or negative for unlimited.
+	MaxSize int64
+	// MaxAge is the maximum age of the backup files to be kept, zero or negative for unlimited.
+	MaxAge time.Duration
+}
+
+// backupFile is a backup file with its SQLite side files, if any.
+type backupFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	side    []string
+}
+
+// backupTempSuffix is the suffix of the temporary file a backup is written to.
+const backupTempSuffix = ".tmp"
+
+// backupTimeLayout is the layout of the creation time ending the automatically created backup file names.
+const backupTimeLayout = "20060102T150405.000000000Z"
+
+// backupTimePattern matches the creation time ending the automatically created backup file names.
+var backupTimePattern = regexp.MustCompile(`\.\d{8}T\d{6}\.\d{9}Z$`)
+
+// legacyBackupPattern matches the name suffix of the backup files created by the previous versions
+// on device ID change, i.e. the previous device ID with the ':' replaced by '_' or the Unix time.
+var legacyBackupPattern = regexp.MustCompile(`^(?:\d+|(?:[a-zA-Z]\w*(?:[.\-][a-zA-Z]\w*)*)?_[^\x00-\x1F\x7F-\xFF/.]+)$`)
+
+// ErrBackupExists is returned when the backup file already exists.
+var ErrBackupExists = errors.New("the backup file already exists")
+
+// writeBackup writes a consistent copy of the database with the provided snapshot function into a temporary file
+// next to the backup file, which is synced and then renamed to the backup file, so that an incomplete backup
+// is never left on the backup file path. Returns ErrBackupExists if the backup file already exists.
+func writeBackup(path string, snapshot func(w io.Writer) error) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.Wrapf(ErrBackupExists, "cannot backup to '%s'", path)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+backupTempSuffix)
+	if err != nil {
+		return errors.Wrap(err, "error creating the temporary backup file")
+	}
+	temp := out.Name()
+
+	if err := snapshot(out); err != nil {
+		out.Close()
+		os.Remove(temp)
+		return errors.Wrap(err, "error writing the backup")
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(temp)
+		return errors.Wrap(err, "error syncing the backup")
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(temp)
+		return errors.Wrap(err, "error closing the backup")
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return errors.Wrapf(err, "error renaming the backup to '%s'", path)
+	}
+	return nil
+}
+
+// CleanupBackups removes the oldest backup files of the database located on the provided path
+// which are exceeding the retention policy. Returns the paths of the removed backup files.
+func CleanupBackups(path string, retention BackupRetention) ([]string, error) {
+	backups, err := listBackups(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+
+	var removed []string
+	var size int64
+	now := time.Now()
+	for i, backup := range backups {
+		size = size + backup.size
+		if (retention.MaxCount > 0 && i >= retention.MaxCount) ||
+			(retention.MaxSize > 0 && size > retention.MaxSize) ||
+			(retention.MaxAge > 0 && now.Sub(backup.modTime) > retention.MaxAge) {
+			for _, side := range backup.side {
+				if err := os.Remove(side); err != nil && !os.IsNotExist(err) {
+					return removed, errors.Wrapf(err, "error removing backup file '%s'", side)
+				}
+			}
+			if err := os.Remove(backup.path); err != nil {
+				return removed, errors.Wrapf(err, "error removing backup file '%s'", backup.path)
+			}
+			removed = append(removed, backup.path)
+		}
+	}
+	return removed, nil
+}
+
+// listBackups lists the automatically created backup files of the database located on the provided path.
+// The SQLite side files are listed with their backup file, the orphan ones are listed as backup files themselves.
+func listBackups(path string) ([]*backupFile, error) {
+	dir := filepath.Dir(path)
+	prefix := filepath.Base(path) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error listing backup files on location '%s'", dir)
+	}
+
+	files := make(map[string]*backupFile)
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) ||
+			!isBackupName(strings.TrimPrefix(sqliteBackupOf(name), prefix)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		files[name] = &backupFile{path: filepath.Join(dir, name), modTime: info.ModTime(), size: info.Size()}
+		names = append(names, name)
+	}
+
+	var backups []*backupFile
+	for _, name := range names {
+		backup := files[name]
+		if owner, ok := files[sqliteBackupOf(name)]; ok && owner != backup {
+			owner.side = append(owner.side, backup.path)
+			owner.size = owner.size + backup.size
+			continue
+		}
+		backups = append(backups, backup)
+	}
+	return backups, nil
+}
+
+// isBackupName reports whether the name suffix following the database file name is of an automatically
+// created backup file, including the ones created by the previous versions.
+func isBackupName(suffix string) bool {
+	return backupTimePattern.MatchString(suffix) || legacyBackupPattern.MatchString(suffix)
+}
+
+// sqliteBackupOf returns the name of the backup file of a SQLite side file, or the name itself
+// if it is not a SQLite side file.
+func sqliteBackupOf(name string) string {
+	for _, suffix := range sqliteSideFiles {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+// rotatedBackupPath returns the path of a new automatically created backup of the database located
+// on the provided path, named with the suffix and the creation time, which never replaces an existing backup,
+// so that the older backups are rotated by the retention policy only.
+func rotatedBackupPath(path, suffix string) string {
+	created := time.Now().UTC()
+	for {
+		backup := fmt.Sprintf("%s.%s.%s", path, suffix, created.Format(backupTimeLayout))
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return backup
+		}
+		created = created.Add(time.Nanosecond)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backupTime is the creation time ending the automatically created backup file names.
+const backupTime = ".20220101T120000.000000000Z"
+
+func TestCleanupBackups(t *testing.T) {
+	dbDir := t.TempDir()
+	location := filepath.Join(dbDir, "things.db")
+
+	createBackupFile(t, location, 10, 0)
+	backups := []string{
+		createBackupFile(t, location+".device_1"+backupTime, 10, 4*time.Hour),
+		createBackupFile(t, location+".device_2"+backupTime, 10, 3*time.Hour),
+		createBackupFile(t, location+".device_3"+backupTime, 10, 2*time.Hour),
+		createBackupFile(t, location+".device_4"+backupTime, 10, time.Hour),
+	}
+	other := createBackupFile(t, filepath.Join(dbDir, "other.db.device_1"+backupTime), 10, 5*time.Hour)
+	// the user backups are not managed
+	userBackups := []string{
+		createBackupFile(t, location+".bak", 10, 6*time.Hour),
+		createBackupFile(t, location+".corrupted.1", 10, 6*time.Hour),
+		createBackupFile(t, location+".my_backup.1", 10, 6*time.Hour),
+	}
+
+	removed, err := persistence.CleanupBackups(location, persistence.BackupRetention{})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	removed, err = persistence.CleanupBackups(location, persistence.BackupRetention{MaxCount: 3})
+	require.NoError(t, err)
+	assert.Equal(t, backups[:1], removed)
+
+	removed, err = persistence.CleanupBackups(location, persistence.BackupRetention{MaxSize: 25})
+	require.NoError(t, err)
+	assert.Equal(t, backups[1:2], removed)
+
+	for _, path := range backups[:2] {
+		assert.NoFileExists(t, path)
+	}
+	for _, path := range append(append(backups[2:], location, other), userBackups...) {
+		assert.FileExists(t, path)
+	}
+}
+
+func TestCleanupBackupsLegacy(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	legacy := []string{
+		createBackupFile(t, location+".org.eclipse.kanto_device", 10, 4*time.Hour),
+		createBackupFile(t, location+"._device", 10, 3*time.Hour),
+		createBackupFile(t, location+".1640995200", 10, 2*time.Hour),
+	}
+	recent := createBackupFile(t, location+".device_1"+backupTime, 10, time.Hour)
+	user := createBackupFile(t, location+".bak", 10, 5*time.Hour)
+
+	removed, err := persistence.CleanupBackups(location, persistence.BackupRetention{MaxCount: 1})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, legacy, removed)
+	for _, path := range legacy {
+		assert.NoFileExists(t, path)
+	}
+	assert.FileExists(t, recent)
+	assert.FileExists(t, user)
+}
+
+func TestCleanupBackupsMaxAge(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	old := createBackupFile(t, location+".device_1"+backupTime, 10, 3*time.Hour)
+	recent := createBackupFile(t, location+".device_2"+backupTime, 10, time.Hour)
+
+	removed, err := persistence.CleanupBackups(location, persistence.BackupRetention{MaxAge: 2 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{old}, removed)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, recent)
+}
+
+func TestCleanupBackupsSideFiles(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	corrupted := createBackupFile(t, location+".corrupted"+backupTime, 10, 3*time.Hour)
+	sideFiles := []string{
+		createBackupFile(t, corrupted+"-wal", 10, 3*time.Hour),
+		createBackupFile(t, corrupted+"-shm", 10, 3*time.Hour),
+	}
+	backup := createBackupFile(t, location+".device_1"+backupTime, 20, 2*time.Hour)
+	// neither the backups nor the compaction in progress are backups
+	inProgress := []string{
+		createBackupFile(t, location+".device_2"+backupTime+".123.tmp", 10, 4*time.Hour),
+		createBackupFile(t, location+".compact", 10, 4*time.Hour),
+	}
+
+	removed, err := persistence.CleanupBackups(location, persistence.BackupRetention{MaxSize: 25})
+	require.NoError(t, err)
+	assert.Equal(t, []string{corrupted}, removed)
+	for _, path := range append(sideFiles, corrupted) {
+		assert.NoFileExists(t, path)
+	}
+	for _, path := range append(inProgress, backup) {
+		assert.FileExists(t, path)
+	}
+}
+
+func TestCleanupBackupsNoLocation(t *testing.T) {
+	removed, err := persistence.CleanupBackups(filepath.Join(t.TempDir(), "missing", "things.db"),
+		persistence.BackupRetention{MaxCount: 1})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func createBackupFile(t *testing.T, path string, size int, age time.Duration) string {
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0600))
+	modTime := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	return path
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// CBOR major types.
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+)
+
+const (
+	cborFalse     = 0xf4
+	cborTrue      = 0xf5
+	cborNull      = 0xf6
+	cborUndefined = 0xf7
+	cborFloat16   = 0xf9
+	cborFloat32   = 0xfa
+	cborFloat64   = 0xfb
+)
+
+// cborMaxExactInt is the maximum integer exactly represented by a float64.
+const cborMaxExactInt = 1 << 53
+
+// cborMaxDepth limits the nesting of the decoded arrays and maps.
+const cborMaxDepth = 1000
+
+var errCBORInvalid = errors.New("invalid CBOR value")
+
+// encodeCBOR writes the JSON value in its generic form, with deterministically ordered map keys
+// and the integral numbers encoded as integers.
+func encodeCBOR(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(cborNull)
+
+	case bool:
+		if v {
+			buffer.WriteByte(cborTrue)
+		} else {
+			buffer.WriteByte(cborFalse)
+		}
+
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				writeCBORHead(buffer, cborUnsigned, uint64(n))
+			} else {
+				writeCBORHead(buffer, cborNegative, uint64(-(n + 1)))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeCBORHead(buffer, cborUnsigned, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return encodeCBOR(buffer, f)
+
+	case float64:
+		switch {
+		case v == math.Trunc(v) && v >= 0 && v <= cborMaxExactInt:
+			writeCBORHead(buffer, cborUnsigned, uint64(v))
+		case v == math.Trunc(v) && v < 0 && v >= -cborMaxExactInt:
+			writeCBORHead(buffer, cborNegative, uint64(-v-1))
+		default:
+			buffer.WriteByte(cborFloat64)
+			var bits [8]byte
+			binary.BigEndian.PutUint64(bits[:], math.Float64bits(v))
+			buffer.Write(bits[:])
+		}
+
+	case string:
+		writeCBORHead(buffer, cborText, uint64(len(v)))
+		buffer.WriteString(v)
+
+	case []interface{}:
+		writeCBORHead(buffer, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeCBOR(buffer, item); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeCBORHead(buffer, cborMap, uint64(len(v)))
+		for _, key := range keys {
+			writeCBORHead(buffer, cborText, uint64(len(key)))
+			buffer.WriteString(key)
+			if err := encodeCBOR(buffer, v[key]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.Errorf("unexpected CBOR value type %T", value)
+	}
+	return nil
+}
+
+func writeCBORHead(buffer *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buffer.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buffer.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buffer.WriteByte(major | 25)
+		var bits [2]byte
+		binary.BigEndian.PutUint16(bits[:], uint16(n))
+		buffer.Write(bits[:])
+	case n <= math.MaxUint32:
+		buffer.WriteByte(major | 26)
+		var bits [4]byte
+		binary.BigEndian.PutUint32(bits[:], uint32(n))
+		buffer.Write(bits[:])
+	default:
+		buffer.WriteByte(major | 27)
+		var bits [8]byte
+		binary.BigEndian.PutUint64(bits[:], n)
+		buffer.Write(bits[:])
+	}
+}
+
+// decodeCBOR reads a single CBOR value in its generic JSON form. The integers are decoded as json.Number values,
+// so that they are exact, the floating-point numbers as float64 values, the byte strings as []byte
+// and the tags are ignored. The indefinite length items are not supported.
+func decodeCBOR(data []byte) (interface{}, error) {
+	decoder := &cborDecoder{data: data}
+	value, err := decoder.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.offset != len(data) {
+		return nil, errors.Wrap(errCBORInvalid, "trailing data")
+	}
+	return value, nil
+}
+
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.offset) {
+		return nil, errors.Wrap(errCBORInvalid, "unexpected end of data")
+	}
+	start := d.offset
+	d.offset += int(n)
+	return d.data[start:d.offset], nil
+}
+
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	initial, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info := initial[0]>>5, initial[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		bits, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n := uint64(0)
+		for _, b := range bits {
+			n = n<<8 | uint64(b)
+		}
+		return major, info, n, nil
+	default:
+		return 0, 0, 0, errors.Wrapf(errCBORInvalid, "unsupported additional information %d", info)
+	}
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.Wrap(errCBORInvalid, "too deeply nested")
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+
+	case cborNegative:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return json.Number(strconv.FormatInt(-1-int64(n), 10)), nil
+
+	case cborBytes, cborText:
+		bytes, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(bytes), nil
+		}
+		return append([]byte{}, bytes...), nil
+
+	case cborArray:
+		if n > uint64(len(d.data)-d.offset) {
+			return nil, errors.Wrap(errCBORInvalid, "unexpected end of data")
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+
+	case cborMap:
+		if n > uint64(len(d.data)-d.offset) {
+			return nil, errors.Wrap(errCBORInvalid, "unexpected end of data")
+		}
+		members := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.Wrapf(errCBORInvalid, "unsupported map key type %T", key)
+			}
+			if members[name], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return members, nil
+
+	case cborTag:
+		return d.value(depth + 1)
+
+	default:
+		return d.simple(info, n)
+	}
+}
+
+func (d *cborDecoder) simple(info byte, n uint64) (interface{}, error) {
+	switch info {
+	case cborFalse & 0x1f:
+		return false, nil
+	case cborTrue & 0x1f:
+		return true, nil
+	case cborNull & 0x1f, cborUndefined & 0x1f:
+		return nil, nil
+	case cborFloat16 & 0x1f:
+		return float16(uint16(n)), nil
+	case cborFloat32 & 0x1f:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case cborFloat64 & 0x1f:
+		return math.Float64frombits(n), nil
+	default:
+		return nil, errors.Wrapf(errCBORInvalid, "unsupported simple value %d", n)
+	}
+}
+
+// float16 converts the IEEE 754 half-precision bits to a float64 value.
+func float16(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 0x1f:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
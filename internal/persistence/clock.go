@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package persistence
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// TimestampQualityClockJump is the quality of the timestamps taken on or after a detected wall clock jump,
+	// until the wall clock catches up with the previous timestamp.
+	TimestampQualityClockJump = "clock-jump"
+
+	// clockJumpTolerance is the maximum difference between the wall clock and the monotonic clock elapsed times,
+	// which is not considered as a wall clock jump.
+	clockJumpTolerance = 2 * time.Second
+)
+
+// systemClock provides the things timestamps.
+var systemClock = NewSystemClock()
+
+// Clock provides timestamps, which are not decreasing on wall clock jumps, e.g. on NTP corrections.
+// The jumps are detected by comparing the wall clock and the monotonic clock elapsed times.
+type Clock struct {
+	wall func() time.Time
+	mono func() time.Duration
+
+	mutex    sync.Mutex
+	started  bool
+	lastWall time.Time
+	lastMono time.Duration
+	// last is the previous timestamp, it is after lastWall if the wall clock is set back.
+	last time.Time
+}
+
+// NewSystemClock creates a clock using the system wall and monotonic clocks.
+func NewSystemClock() *Clock {
+	start := time.Now()
+	return NewClock(
+		func() time.Time { return time.Now().Round(0) },
+		func() time.Duration { return time.Since(start) },
+	)
+}
+
+// NewClock creates a clock using the provided wall and monotonic clocks.
+func NewClock(wall func() time.Time, mono func() time.Duration) *Clock {
+	return &Clock{
+		wall: wall,
+		mono: mono,
+	}
+}
+
+// Now returns the current timestamp and its quality. The quality is TimestampQualityClockJump
+// if a wall clock jump is detected since the previous timestamp or the wall clock is still behind it,
+// in which case the previous timestamp is returned. Otherwise the quality is empty.
+func (c *Clock) Now() (time.Time, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	wall, mono := c.wall(), c.mono()
+	timestamp := wall
+	quality := ""
+	if c.started {
+		drift := wall.Sub(c.lastWall) - (mono - c.lastMono)
+		if drift > clockJumpTolerance || drift < -clockJumpTolerance {
+			quality = TimestampQualityClockJump
+		}
+		if wall.Before(c.last) {
+			timestamp = c.last
+			quality = TimestampQualityClockJump
+		}
+	}
+
+	c.started = true
+	c.lastWall = wall
+	c.lastMono = mono
+	c.last = timestamp
+	return timestamp, quality
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package persistence_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+type testClock struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *testClock) advance(wall, mono time.Duration) {
+	c.wall = c.wall.Add(wall)
+	c.mono += mono
+}
+
+func TestClockNow(t *testing.T) {
+	source := &testClock{wall: time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)}
+	clock := persistence.NewClock(
+		func() time.Time { return source.wall },
+		func() time.Duration { return source.mono },
+	)
+
+	assertTimestamp(t, clock, source.wall, "")
+
+	source.advance(time.Minute, time.Minute)
+	assertTimestamp(t, clock, source.wall, "")
+
+	// NTP correction forward
+	source.advance(time.Hour, time.Second)
+	assertTimestamp(t, clock, source.wall, persistence.TimestampQualityClockJump)
+	source.advance(time.Second, time.Second)
+	assertTimestamp(t, clock, source.wall, "")
+
+	// NTP correction backward, the previous timestamp is kept until the wall clock catches up
+	last := source.wall
+	source.advance(-time.Minute, time.Second)
+	assertTimestamp(t, clock, last, persistence.TimestampQualityClockJump)
+	source.advance(30*time.Second, 30*time.Second)
+	assertTimestamp(t, clock, last, persistence.TimestampQualityClockJump)
+	source.advance(time.Minute, time.Minute)
+	assertTimestamp(t, clock, source.wall, "")
+}
+
+func TestSystemClockNow(t *testing.T) {
+	clock := persistence.NewSystemClock()
+	first, quality := clock.Now()
+	assert.Empty(t, quality)
+
+	second, quality := clock.Now()
+	assert.Empty(t, quality)
+	assert.False(t, second.Before(first))
+}
+
+func assertTimestamp(t *testing.T, clock *persistence.Clock, expected time.Time, quality string) {
+	timestamp, actualQuality := clock.Now()
+	assert.Equal(t, expected, timestamp)
+	assert.Equal(t, quality, actualQuality)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Codecs of the stored values.
+const (
+	// CodecGob encodes the values using encoding/gob, it is the default codec, readable by all versions.
+	CodecGob = "gob"
+	// CodecJSON encodes the values as JSON documents, so that they could be read by external tools.
+	CodecJSON = "json"
+	// CodecCBOR encodes the values as compact CBOR (RFC 8949) documents, so that they could be read by external tools.
+	CodecCBOR = "cbor"
+)
+
+// codecMarker starts the values encoded by a portable codec, followed by the codec tag.
+// The gob encoded values never start with it, as it is the length of an empty gob message.
+const codecMarker = 0x00
+
+const (
+	codecTagJSON = 'j'
+	codecTagCBOR = 'c'
+)
+
+// ErrUnknownCodec is returned if the values codec is not supported.
+var ErrUnknownCodec = errors.New("unknown values codec")
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// ValidateCodec returns ErrUnknownCodec if the values codec is not supported, the empty one is CodecGob.
+func ValidateCodec(codec string) error {
+	switch codec {
+	case "", CodecGob, CodecJSON, CodecCBOR:
+		return nil
+	default:
+		return errors.Wrapf(ErrUnknownCodec, "'%s'", codec)
+	}
+}
+
+// encoded is implemented by the databases, which values could be written with any of the codecs.
+// The stored values are decoded regardless of the codec they are written with, so that the values
+// of a database are migrated to its codec as they are modified.
+type encoded interface {
+	// setCodec sets the codec of the written values, CodecGob if empty.
+	setCodec(codec string)
+}
+
+// encodeAs encodes the value with the provided codec, CodecGob if empty.
+// Note that unlike gob, the portable codecs decode all generic numbers, e.g. within the feature properties,
+// as float64 values, the same way as they are decoded from the received JSON messages.
+func encodeAs(codec string, value interface{}) ([]byte, error) {
+	switch codec {
+	case CodecJSON:
+		buffer := bytes.NewBuffer([]byte{codecMarker, codecTagJSON})
+		if err := json.NewEncoder(buffer).Encode(value); err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+
+	case CodecCBOR:
+		generic, err := genericValue(value)
+		if err != nil {
+			return nil, err
+		}
+		buffer := bytes.NewBuffer([]byte{codecMarker, codecTagCBOR})
+		if err := encodeCBOR(buffer, generic); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+
+	default:
+		buffer := &bytes.Buffer{}
+		encBuffer := gob.NewEncoder(buffer)
+		if err := encBuffer.Encode(value); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+}
+
+// decodeAs decodes the value, detecting the codec it is encoded with.
+func decodeAs(data []byte, value interface{}) error {
+	if len(data) < 2 || data[0] != codecMarker {
+		buffer := bytes.NewBuffer(data)
+		decBuffer := gob.NewDecoder(buffer)
+		return decBuffer.Decode(value)
+	}
+
+	switch data[1] {
+	case codecTagJSON:
+		return json.Unmarshal(data[2:], value)
+
+	case codecTagCBOR:
+		generic, err := decodeCBOR(data[2:])
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(payload, value)
+
+	default:
+		return errors.Wrapf(ErrUnknownCodec, "tag 0x%02x", data[1])
+	}
+}
+
+// genericValue returns the JSON form of the value, decoded as map[string]interface{}, []interface{}
+// or a JSON primitive value, with the numbers as json.Number values, so that the integers are kept exact.
+func genericValue(value interface{}) (interface{}, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
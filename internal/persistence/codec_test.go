@@ -0,0 +1,290 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+var codecs = []string{persistence.CodecGob, persistence.CodecJSON, persistence.CodecCBOR}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []string{persistence.CodecJSON, persistence.CodecCBOR} {
+		t.Run(codec, func(t *testing.T) {
+			testCodec(t, codec)
+		})
+	}
+
+	_, err := persistence.OpenThingsStorage(filepath.Join(t.TempDir(), "things.db"), "codec:thing",
+		persistence.StorageOptions{Codec: "protobuf"})
+	assert.ErrorIs(t, err, persistence.ErrUnknownCodec)
+	assert.ErrorIs(t, persistence.ValidateCodec("protobuf"), persistence.ErrUnknownCodec)
+	assert.NoError(t, persistence.ValidateCodec(""))
+}
+
+func testCodec(t *testing.T, codec string) {
+	const thingID = "codec:thing"
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	// the gob records of a previous version are read and migrated on modification
+	db, err := persistence.NewThingsDB(location, thingID)
+	require.NoError(t, err)
+	thing := createThing(thingID)
+	revision, err := db.AddThing(thing)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = persistence.OpenThingsStorage(location, thingID, persistence.StorageOptions{Codec: codec})
+	require.NoError(t, err)
+	stored := &model.Thing{}
+	require.NoError(t, db.GetThing(thingID, stored))
+	assert.Equal(t, thing.Attributes, stored.Attributes)
+
+	feature := (&model.Feature{}).
+		WithDefinitionFrom("org.eclipse.kanto:Meter:1.0.0").
+		WithProperty("value", 1.5).
+		WithProperty("counter", float64(1<<53))
+	_, err = db.AddFeature(thingID, "meter", feature)
+	require.NoError(t, err)
+
+	// the codec is kept on reopening
+	require.NoError(t, db.Reopen())
+	_, err = db.AddFeature(thingID, "reopened", feature)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// the values are written with the codec of the storage only
+	raw, err := persistence.NewDatabase(location)
+	require.NoError(t, err)
+	for _, featureID := range []string{"meter", "reopened"} {
+		value, err := raw.Get(data.FeatureKey(thingID, featureID))
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x00), value[0], featureID)
+	}
+	require.NoError(t, raw.Close())
+
+	// the values written by any codec are read regardless of the configured one
+	db, err = persistence.NewThingsDB(location, thingID)
+	require.NoError(t, err)
+	defer db.Close()
+	stored = &model.Thing{}
+	require.NoError(t, db.GetThing(thingID, stored))
+	assert.Equal(t, revision+2, stored.Revision)
+	assert.Equal(t, thing.Attributes, stored.Attributes)
+	assert.Equal(t, feature, stored.Features["meter"])
+	system, err := db.GetSystemThingData(thingID)
+	require.NoError(t, err)
+	assert.Contains(t, system.UnsynchronizedFeatures, "meter")
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	const thingID = "codec:round-trip"
+	for _, engine := range []string{persistence.EngineBolt, persistence.EngineSQLite, persistence.EngineMemory} {
+		for _, codec := range codecs {
+			options := persistence.StorageOptions{Engine: engine, Codec: codec}
+			t.Run(engine+"/"+codec, func(t *testing.T) {
+				db, err := persistence.OpenThingsStorage(filepath.Join(t.TempDir(), "things.db"), thingID, options)
+				require.NoError(t, err)
+				defer db.Close()
+				testCodecRoundTrip(t, db, thingID)
+			})
+			t.Run(engine+"/"+codec+"/partitioned", func(t *testing.T) {
+				partitions, err := persistence.OpenPartitions(filepath.Join(t.TempDir(), "things.db"), options)
+				require.NoError(t, err)
+				defer partitions.Close()
+				// the first device keeps its records unpartitioned
+				_, err = partitions.Storage("codec:gateway")
+				require.NoError(t, err)
+				db, err := partitions.Storage(thingID)
+				require.NoError(t, err)
+				testCodecRoundTrip(t, db, thingID)
+			})
+		}
+	}
+}
+
+func testCodecRoundTrip(t *testing.T, db persistence.ThingsStorage, thingID string) {
+	const bigRevision = int64(1<<60 + 1)
+
+	// the generic values, i.e. the JSON ones, are decoded the same way by all codecs
+	thing := &model.Thing{
+		ID:           model.NewNamespacedIDFrom(thingID),
+		PolicyID:     model.NewNamespacedIDFrom("codec:policy"),
+		DefinitionID: model.NewDefinitionIDFrom("org.eclipse.kanto:Device:1.0.0"),
+		Attributes: map[string]interface{}{
+			"number": 1.5,
+			"exact":  float64(1 << 53),
+			"nested": map[string]interface{}{"list": []interface{}{"a", nil, true, 2.0}},
+		},
+		Features: map[string]*model.Feature{
+			"meter": (&model.Feature{}).
+				WithDefinitionFrom("org.eclipse.kanto:Meter:1.0.0").
+				WithProperty("value", -2.25).
+				WithProperty("unit", "kWh").
+				WithDesiredProperty("value", 3.0),
+		},
+		Revision: bigRevision,
+	}
+	thing.Features["meter"].Metadata = map[string]interface{}{"properties": map[string]interface{}{"value": 1.0}}
+	revision, err := db.AddThing(thing)
+	require.NoError(t, err)
+	assert.Equal(t, bigRevision, revision)
+
+	stored := &model.Thing{}
+	require.NoError(t, db.GetThing(thingID, stored))
+	assert.Equal(t, thing.PolicyID, stored.PolicyID)
+	assert.Equal(t, thing.DefinitionID, stored.DefinitionID)
+	assert.Equal(t, thing.Attributes, stored.Attributes)
+	assert.Equal(t, thing.Features["meter"].Properties, stored.Features["meter"].Properties)
+	assert.Equal(t, thing.Features["meter"].DesiredProperties, stored.Features["meter"].DesiredProperties)
+	assert.Equal(t, thing.Features["meter"].Definition, stored.Features["meter"].Definition)
+	assert.Equal(t, bigRevision, stored.Revision)
+
+	// the system thing data integers, e.g. the unsynchronized features revisions, are kept exact
+	feature := thing.Features["meter"]
+	delete(feature.Properties, "unit")
+	featureRevision, err := db.AddFeature(thingID, "meter", feature)
+	require.NoError(t, err)
+	_, err = db.AddFeature(thingID, "other", (&model.Feature{}).WithProperty("value", 1.0))
+	require.NoError(t, err)
+	require.NoError(t, db.RemoveFeature(thingID, "other"))
+	system, err := db.GetSystemThingData(thingID)
+	require.NoError(t, err)
+	assert.Equal(t, bigRevision+3, system.Revision)
+	assert.Equal(t, map[string]int64{"meter": featureRevision}, system.UnsynchronizedFeatures)
+	assert.Contains(t, system.DeletedFeatures, "other")
+	assert.Contains(t, system.UnsynchronizedDesired, "meter")
+	assert.Equal(t, map[string]map[string]interface{}{"meter": {"unit": nil}}, system.RemovedProperties)
+	assert.Equal(t, int64(1), system.UnsynchronizedAttributes)
+
+	counters := &data.CountersData{
+		Values: map[string]uint64{"big": math.MaxUint64, "exact": 1<<53 + 1, "zero": 0},
+		Since:  "2022-01-01T00:00:00Z",
+	}
+	require.NoError(t, db.SetCounters(counters))
+	storedCounters := &data.CountersData{}
+	require.NoError(t, db.GetCounters(storedCounters))
+	assert.Equal(t, counters, storedCounters)
+
+	pending := &data.PendingData{Commands: []byte{0x00, 0x01, 0xff}, Reason: "stopped", Stopped: "2022-01-01T00:00:00Z"}
+	require.NoError(t, db.SetPendingCommands(pending))
+	storedPending := &data.PendingData{}
+	require.NoError(t, db.GetPendingCommands(storedPending))
+	assert.Equal(t, pending, storedPending)
+
+	retrieves := map[string]data.RetrieveData{"correlation": {ThingID: thingID, Issued: "2022-01-01T00:00:00Z"}}
+	require.NoError(t, db.SetRetrieves(&data.RetrievesData{Retrieves: retrieves}))
+	storedRetrieves := &data.RetrievesData{}
+	require.NoError(t, db.GetRetrieves(storedRetrieves))
+	assert.Equal(t, retrieves, storedRetrieves.Retrieves)
+
+	session := &data.SyncSessionData{
+		Started: "2022-01-01T00:00:00Z", Phase: "things", Remaining: []string{thingID}, Retrieves: retrieves,
+	}
+	require.NoError(t, db.SetSyncSession(session))
+	storedSession := &data.SyncSessionData{}
+	require.NoError(t, db.GetSyncSession(storedSession))
+	assert.Equal(t, session, storedSession)
+
+	entry := &data.JournalEntry{ThingID: thingID, Revision: bigRevision, Event: []byte(`{"value":1}`)}
+	require.NoError(t, db.AppendEvent(entry))
+	entries, err := db.GetEvents(thingID, bigRevision, bigRevision)
+	require.NoError(t, err)
+	assert.Equal(t, []*data.JournalEntry{entry}, entries)
+
+	_, err = db.AddPolicy("codec:policy", []byte(`{"entries":{}}`))
+	require.NoError(t, err)
+	policy, err := db.GetPolicy("codec:policy")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"entries":{}}`, string(policy.Policy))
+
+	require.NoError(t, db.RemoveThing(thingID))
+	tombstones := &data.TombstonesData{}
+	require.NoError(t, db.GetTombstones(tombstones))
+	assert.Equal(t, bigRevision+3, tombstones.Things[thingID].Revision)
+}
+
+func TestCodecPortable(t *testing.T) {
+	const thingID = "codec:thing"
+	feature := (&model.Feature{}).
+		WithDefinitionFrom("org.eclipse.kanto:Meter:1.0.0").
+		WithProperty("value", 1.5).
+		WithProperty("tags", []interface{}{"a", nil, true})
+
+	location := filepath.Join(t.TempDir(), "things.db")
+	db, err := persistence.OpenThingsStorage(location, thingID, persistence.StorageOptions{Codec: persistence.CodecJSON})
+	require.NoError(t, err)
+	_, err = db.AddThing(&model.Thing{ID: model.NewNamespacedIDFrom(thingID)})
+	require.NoError(t, err)
+	_, err = db.AddFeature(thingID, "meter", feature)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	raw, err := persistence.NewDatabase(location)
+	require.NoError(t, err)
+	value, err := raw.Get(data.FeatureKey(thingID, "meter"))
+	require.NoError(t, err)
+	require.Greater(t, len(value), 2)
+	assert.Equal(t, []byte{0x00, 'j'}, value[:2])
+	readable := &data.FeatureData{}
+	require.NoError(t, json.Unmarshal(value[2:], readable))
+	assert.Equal(t, feature.Properties, readable.Properties)
+	require.NoError(t, raw.Close())
+
+	db, err = persistence.OpenThingsStorage(location, thingID, persistence.StorageOptions{Codec: persistence.CodecCBOR})
+	require.NoError(t, err)
+	_, err = db.AddFeature(thingID, "meter", feature)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	raw, err = persistence.NewDatabase(location)
+	require.NoError(t, err)
+	value, err = raw.Get(data.FeatureKey(thingID, "meter"))
+	require.NoError(t, err)
+	// a map starting with the text "Created"
+	assert.Equal(t, []byte{0x00, 'c', 0x67, 'C', 'r', 'e', 'a', 't', 'e', 'd'}, append(value[:2:2], value[3:11]...))
+
+	// the codec applies to the partitions of all devices
+	require.NoError(t, raw.Close())
+	partitions, err := persistence.OpenPartitions(location, persistence.StorageOptions{Codec: persistence.CodecJSON})
+	require.NoError(t, err)
+	db, err = partitions.Storage("codec:other")
+	require.NoError(t, err)
+	_, err = db.AddThing(&model.Thing{ID: model.NewNamespacedIDFrom("codec:other")})
+	require.NoError(t, err)
+	_, err = db.AddFeature("codec:other", "meter", feature)
+	require.NoError(t, err)
+	require.NoError(t, partitions.Close())
+
+	raw, err = persistence.NewDatabase(location)
+	require.NoError(t, err)
+	defer raw.Close()
+	value, err = raw.Get("@PARTITION/codec:other/" + data.FeatureKey("codec:other", "meter"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 'j'}, value[:2])
+
+	require.NoError(t, raw.Set(data.FeatureKey(thingID, "meter"), []byte{0x00, 'c', 0xa1, 0x61}))
+	assert.Error(t, raw.GetAs(data.FeatureKey(thingID, "meter"), &data.FeatureData{}))
+	require.NoError(t, raw.Set(data.FeatureKey(thingID, "meter"), []byte{0x00, 'x'}))
+	assert.ErrorIs(t, raw.GetAs(data.FeatureKey(thingID, "meter"), &data.FeatureData{}), persistence.ErrUnknownCodec)
+}
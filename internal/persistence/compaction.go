@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// compactSuffix is the suffix of the fresh database file the records are copied to on compaction.
+const compactSuffix = ".compact"
+
+// compactTxMaxSize is the size of the records copied within a single transaction on compaction.
+const compactTxMaxSize = 4 << 20
+
+// ErrCompactionUnsupported is returned if the database file cannot be compacted by the storage,
+// e.g. as it is shared with the storages of other devices.
+var ErrCompactionUnsupported = errors.New("database file compaction is not supported")
+
+// CompactionReport contains the database file sizes before and after its compaction.
+type CompactionReport struct {
+	// SizeBefore is the size in bytes of the database file before the compaction.
+	SizeBefore int64 `json:"sizeBefore"`
+	// SizeAfter is the size in bytes of the database file after the compaction.
+	SizeAfter int64 `json:"sizeAfter"`
+}
+
+// Compact compacts the closed database file of the provided storage engine.
+// The records of a bbolt database file are copied to a fresh file, which then replaces the original one,
+// so that the original file is kept intact if the compaction fails. A SQLite database file is vacuumed.
+// There is nothing to be compacted for the in-memory storage.
+func Compact(engine, path string) (*CompactionReport, error) {
+	if engine == EngineMemory {
+		return &CompactionReport{}, nil
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "database file size could not be retrieved")
+	}
+
+	switch engine {
+	case EngineBolt, "":
+		err = compactBolt(path)
+	case EngineSQLite:
+		err = compactSQLite(path)
+	default:
+		err = errors.Errorf("unknown storage engine '%s'", engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "compacted database file size could not be retrieved")
+	}
+	return &CompactionReport{SizeBefore: before.Size(), SizeAfter: after.Size()}, nil
+}
+
+func compactBolt(path string) error {
+	src, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: readOnlyOpenTimeout})
+	if err != nil {
+		if errors.Is(err, bbolt.ErrTimeout) {
+			return ErrDatabaseLocked
+		}
+		return err
+	}
+	defer src.Close()
+
+	compactPath := path + compactSuffix
+	if err := os.Remove(compactPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "stale compacted database file could not be removed")
+	}
+	dst, err := bbolt.Open(compactPath, 0600, nil)
+	if err != nil {
+		return errors.Wrap(err, "compacted database file could not be created")
+	}
+
+	if err := bbolt.Compact(dst, src, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(compactPath)
+		return errors.Wrap(err, "database file could not be compacted")
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(compactPath)
+		return errors.Wrap(err, "compacted database file could not be closed")
+	}
+	src.Close()
+	return os.Rename(compactPath, path)
+}
+
+func compactSQLite(path string) error {
+	database, err := newSQLiteDatabase(path, false)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if _, err := database.db.Exec("VACUUM"); err != nil {
+		return errors.Wrap(err, "database file could not be vacuumed")
+	}
+	return nil
+}
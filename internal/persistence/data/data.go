@@ -12,7 +12,11 @@
 
 package data
 
-import "github.com/eclipse-kanto/local-digital-twins/internal/model"
+import (
+	"fmt"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+)
 
 // IDSeparator is used for key definition system symbol.
 // Should be a control character, i.e. invalid namespace/entityID symbol.
@@ -38,6 +42,8 @@ type ThingData struct {
 	DefinitionID string
 	// Attributes represents the model.Thing attributes.
 	Attributes map[string]interface{}
+	// Metadata represents the model.Thing metadata.
+	Metadata map[string]interface{}
 }
 
 // FeatureData represents the persistable model.Feature structure.
@@ -52,6 +58,15 @@ type FeatureData struct {
 	Properties map[string]interface{}
 	// Properties represents model.Feature desired properties.
 	DesiredProperties map[string]interface{}
+	// Metadata represents model.Feature metadata.
+	Metadata map[string]interface{}
+	// Created is the timestamp of the feature creation, empty if the feature is stored by a previous version.
+	Created string
+	// Modified is the timestamp of the last modification of the feature definition or properties.
+	Modified string
+	// Revision is the thing revision of the last modification of the feature definition or properties,
+	// 0 if the feature is not modified since stored by a previous version.
+	Revision int64
 }
 
 // SystemThingData is used for Things Storage system data representation.
@@ -64,6 +79,11 @@ type SystemThingData struct {
 	// Timestamp represents the thing local timestamp that is the timestamp of each
 	// thing's data modification, including its features modifications.
 	Timestamp string
+	// TimestampQuality is non-empty if the timestamp is affected by a detected wall clock jump,
+	// e.g. it is kept equal to the previous one as the wall clock is set back.
+	TimestampQuality string
+	// Created is the timestamp of the thing creation, empty if the thing is stored by a previous version.
+	Created string
 	// DeletedFeatures is a system field that contains the feature IDs of locally deleted features only,
 	// i.e. not synchronized with the remote feature existence state.
 	DeletedFeatures map[string]interface{}
@@ -71,6 +91,128 @@ type SystemThingData struct {
 	// i.e. not synchronized with the remote feature state.
 	// For each unsynchronized feature the revision for its offline change is stored.
 	UnsynchronizedFeatures map[string]int64
+	// UnsynchronizedDefinitions is a system field that contains the feature IDs of the features with locally
+	// modified definitions only, i.e. not synchronized with the remote feature definition.
+	// It is nil for the things stored by a previous version.
+	UnsynchronizedDefinitions map[string]interface{}
+	// UnsynchronizedDesired is a system field that contains the feature IDs of the features with locally
+	// modified desired properties only, i.e. not synchronized with the remote feature desired properties.
+	// It is nil for the things stored by a previous version.
+	UnsynchronizedDesired map[string]interface{}
+	// UnsynchronizedAttributes is a system field that counts the local modifications of the thing attributes
+	// not synchronized with the remote thing attributes yet, 0 if they are synchronized.
+	UnsynchronizedAttributes int64
+	// RemovedProperties is a system field that contains by feature ID the locally removed feature properties
+	// not synchronized with the remote feature properties yet, as the JSON merge patch removing them.
+	// It is nil if there are none or for the things stored by a previous version.
+	RemovedProperties map[string]map[string]interface{}
+	// RemovedAttributes is a system field that contains the locally removed thing attributes
+	// not synchronized with the remote thing attributes yet, as the JSON merge patch removing them.
+	// It is nil if there are none or for the things stored by a previous version.
+	RemovedAttributes map[string]interface{}
+}
+
+// CountersData represents the persistable metrics counters.
+type CountersData struct {
+	// Values contains the counters values by their names.
+	Values map[string]uint64
+	// Since is the timestamp of the counters last reset, i.e. the start of their counting.
+	Since string
+}
+
+// PendingData represents the persistable commands awaiting responses on the messages router stop,
+// so that they are awaited again after the router restart.
+type PendingData struct {
+	// Commands contains the JSON encoded pending commands.
+	Commands []byte
+	// Reason is the reason of the router stop.
+	Reason string
+	// Stopped is the timestamp of the router stop.
+	Stopped string
+}
+
+// RetrieveData represents a persistable retrieve desired properties command issued to the hub.
+type RetrieveData struct {
+	// ThingID is the ID of the thing the desired properties are retrieved for.
+	ThingID string
+	// Issued is the timestamp of the command publishing.
+	Issued string
+}
+
+// RetrievesData represents the persistable retrieve desired properties commands awaiting responses,
+// so that their responses are still accepted after a restart.
+type RetrievesData struct {
+	// Retrieves contains the issued retrieve commands by their correlation IDs.
+	Retrieves map[string]RetrieveData
+}
+
+// SyncSessionData represents the persistable progress of a synchronization session with the hub,
+// so that a session interrupted by a restart, e.g. on a binary update, is resumed instead of started over.
+type SyncSessionData struct {
+	// Started is the timestamp of the session start.
+	Started string
+	// Phase is the session phase, i.e. issuing the retrieve desired properties commands or awaiting their responses.
+	Phase string
+	// Remaining contains the IDs of the things not synchronized yet within the session.
+	Remaining []string
+	// Retrieves contains the issued retrieve desired properties commands awaiting responses by their correlation IDs.
+	Retrieves map[string]RetrieveData
+}
+
+// TombstoneData represents a persistable record of a locally deleted thing.
+type TombstoneData struct {
+	// Revision is the thing revision at its deletion.
+	Revision int64
+	// Deleted is the timestamp of the thing deletion.
+	Deleted string
+}
+
+// TombstonesData represents the persistable records of the locally deleted things, which deletion is not
+// synchronized with the hub yet, so that the things deleted while offline are deleted in the hub on reconnect.
+type TombstonesData struct {
+	// Things contains the tombstones of the deleted things by their IDs.
+	Things map[string]TombstoneData
+}
+
+// JournalEntry represents a persistable locally generated event of a thing.
+type JournalEntry struct {
+	// ThingID is the ID of the thing the event is about.
+	ThingID string
+	// Revision is the thing revision of the event.
+	Revision int64
+	// Timestamp is the timestamp of the event journaling in the JournalTimestampFormat.
+	Timestamp string
+	// Event contains the JSON encoded event envelope.
+	Event []byte
+}
+
+// ReplayQueueData represents the persistable bounds of the queue of the events generated while offline,
+// which are replayed to the hub on reconnect.
+type ReplayQueueData struct {
+	// First is the sequence number of the oldest queued event.
+	First int64
+	// Next is the sequence number of the next queued event.
+	Next int64
+}
+
+// PolicyData represents a persistable policy document.
+type PolicyData struct {
+	// ID is the policy namespaced ID string representation.
+	ID string
+	// Policy contains the JSON encoded policy document.
+	Policy []byte
+	// Revision is the policy local revision, initialised with 1 on its creation and increased on each modification.
+	Revision int64
+	// Created is the timestamp of the policy creation.
+	Created string
+	// Modified is the timestamp of the last modification of the policy.
+	Modified string
+}
+
+// IndexesData represents the persistable configuration of the attribute indexes.
+type IndexesData struct {
+	// Paths contains the sorted slash-separated paths of the indexed attributes, e.g. 'location' or 'building/floor'.
+	Paths []string
 }
 
 // Key retuens the datatabase key.
@@ -99,6 +241,7 @@ func (data *ThingData) Value(value interface{}) {
 	}
 	thing.Features = nil
 	thing.Attributes = data.Attributes
+	thing.Metadata = data.Metadata
 }
 
 // Key returns the datatabase key.
@@ -116,7 +259,8 @@ func (data *FeatureData) Value(value interface{}) {
 	value.(*model.Feature).
 		WithDefinitionFrom(data.Definition...).
 		WithProperties(data.Properties).
-		WithDesiredProperties(data.DesiredProperties)
+		WithDesiredProperties(data.DesiredProperties).
+		WithMetadata(data.Metadata)
 }
 
 // FeatureKey represents a feature database key.
@@ -146,9 +290,72 @@ func (data *SystemThingData) Value(value interface{}) {
 	thing := value.(*model.Thing)
 	thing.Revision = data.Revision
 	thing.Timestamp = data.Timestamp
+	thing.TimestampQuality = data.TimestampQuality
 }
 
 // SystemThingKey returns the SystemThingData key.
 func SystemThingKey(thingID string) string {
 	return IDSeparator + thingID
 }
+
+// Events journal
+
+// JournalKeyPrefix is the database key prefix of all journaled events.
+const JournalKeyPrefix = "@JOURNAL/"
+
+// JournalTimestampFormat is the fixed width format of the journaled events timestamps,
+// so that the events of the same thing revision are ordered by their timestamps.
+const JournalTimestampFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Key returns the database key.
+func (data *JournalEntry) Key() string {
+	return fmt.Sprintf("%s%020d%s%s", JournalThingKeyPrefix(data.ThingID), data.Revision, IDSeparator, data.Timestamp)
+}
+
+// JournalThingKeyPrefix returns the database key prefix of the journaled events of a thing.
+func JournalThingKeyPrefix(thingID string) string {
+	return JournalKeyPrefix + thingID + IDSeparator
+}
+
+// Offline events replay queue
+
+// ReplayKeyPrefix is the database key prefix of all queued offline events.
+const ReplayKeyPrefix = "@REPLAY/"
+
+// ReplayKey returns the database key of the queued offline event with the provided sequence number,
+// so that the events are ordered by their sequence numbers.
+func ReplayKey(sequence int64) string {
+	return fmt.Sprintf("%s%020d", ReplayKeyPrefix, sequence)
+}
+
+// Policies
+
+// PolicyKeyPrefix is the database key prefix of all policies.
+const PolicyKeyPrefix = "@POLICY/"
+
+// Key returns the database key.
+func (data *PolicyData) Key() string {
+	return PolicyKey(data.ID)
+}
+
+// PolicyKey returns the database key of the policy with the provided ID.
+func PolicyKey(policyID string) string {
+	return PolicyKeyPrefix + policyID
+}
+
+// Attribute indexes
+
+// IndexKeyPrefix is the database key prefix of all attribute index entries.
+const IndexKeyPrefix = "@INDEX/"
+
+// IndexValueKeyPrefix returns the database key prefix of the index entries of the things
+// with the attribute on the provided path equal to the provided canonical JSON value.
+func IndexValueKeyPrefix(path string, value string) string {
+	return IndexKeyPrefix + path + IDSeparator + value + IDSeparator
+}
+
+// IndexKey returns the database key of the index entry of the thing with the attribute
+// on the provided path equal to the provided canonical JSON value.
+func IndexKey(path string, value string, thingID string) string {
+	return IndexValueKeyPrefix(path, value) + thingID
+}
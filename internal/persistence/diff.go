@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+)
+
+// Thing change types.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// ThingChange describes how a thing has changed between two states of the things database.
+type ThingChange struct {
+	ThingID string `json:"thingId"`
+	Change  string `json:"change"`
+	// Patch is the JSON merge patch transforming the old thing into the new one,
+	// the whole new thing if it is created and empty if it is deleted.
+	Patch interface{} `json:"patch,omitempty"`
+	// Revision and Timestamp are the new thing ones, empty if the thing is deleted.
+	Revision  int64  `json:"revision,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// ChangeSet contains the changes of the things between two states of the things database,
+// ordered by thing ID.
+type ChangeSet struct {
+	Changes []ThingChange `json:"changes"`
+}
+
+// Diff returns the changes of the things stored in the from storage to the ones stored in the to storage,
+// e.g. between a snapshot and the live things database.
+func Diff(from, to ThingsStorage) (*ChangeSet, error) {
+	fromIDs, err := from.GetThingIDs()
+	if err != nil {
+		return nil, err
+	}
+	toIDs, err := to.GetThingIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(fromIDs)+len(toIDs))
+	for _, thingID := range fromIDs {
+		ids[thingID] = true
+	}
+	for _, thingID := range toIDs {
+		ids[thingID] = true
+	}
+	sorted := make([]string, 0, len(ids))
+	for thingID := range ids {
+		sorted = append(sorted, thingID)
+	}
+	sort.Strings(sorted)
+
+	changes := &ChangeSet{Changes: make([]ThingChange, 0)}
+	for _, thingID := range sorted {
+		oldThing, err := diffThing(from, thingID)
+		if err != nil {
+			return nil, err
+		}
+		newThing, err := diffThing(to, thingID)
+		if err != nil {
+			return nil, err
+		}
+
+		if change, changed := thingChange(thingID, oldThing, newThing); changed {
+			changes.Changes = append(changes.Changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// DiffFiles returns the changes of the things between two snapshots of the things database,
+// e.g. two backup files. Both files are opened read-only.
+func DiffFiles(from, to string) (*ChangeSet, error) {
+	fromStorage, err := openSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	defer fromStorage.Close()
+
+	toStorage, err := openSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	defer toStorage.Close()
+
+	return Diff(fromStorage, toStorage)
+}
+
+func openSnapshot(path string) (ThingsStorage, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: migrationOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open things db snapshot '%s'", path)
+	}
+	return &thingsDB{path: path, db: &storage{path: path, db: db, counters: &writeCounters{}}}, nil
+}
+
+type diffState struct {
+	value     interface{}
+	revision  int64
+	timestamp string
+}
+
+func diffThing(storage ThingsStorage, thingID string) (*diffState, error) {
+	thing := model.Thing{}
+	if err := storage.GetThing(thingID, &thing); err != nil {
+		if errors.Is(err, ErrThingNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, err := json.Marshal(&thing)
+	if err != nil {
+		return nil, err
+	}
+	state := &diffState{revision: thing.Revision, timestamp: thing.Timestamp}
+	if err := json.Unmarshal(data, &state.value); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func thingChange(thingID string, oldThing, newThing *diffState) (ThingChange, bool) {
+	change := ThingChange{ThingID: thingID}
+	switch {
+	case oldThing == nil && newThing == nil:
+		return change, false
+
+	case oldThing == nil:
+		change.Change = ChangeCreated
+		change.Patch = newThing.value
+
+	case newThing == nil:
+		change.Change = ChangeDeleted
+		return change, true
+
+	default:
+		patch, changed := jsonutil.MergeDiff(oldThing.value, newThing.value)
+		if !changed {
+			return change, false
+		}
+		change.Change = ChangeModified
+		change.Patch = patch
+	}
+	change.Revision = newThing.revision
+	change.Timestamp = newThing.timestamp
+	return change, true
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	diffDir      = "diff"
+	diffBefore   = diffDir + "/things-before.db"
+	diffAfter    = diffDir + "/things-after.db"
+	diffDeviceID = "org.eclipse.kanto:TestDiff"
+)
+
+func TestDiffFiles(t *testing.T) {
+	defer os.RemoveAll(diffDir)
+
+	before := assertDBDevice(t, diffBefore, diffDeviceID)
+	addDiffThing(t, before, "org.eclipse.kanto:kept", "lab")
+	addDiffThing(t, before, "org.eclipse.kanto:modified", "lab")
+	addDiffThing(t, before, "org.eclipse.kanto:deleted", "lab")
+	require.NoError(t, before.Close())
+
+	after := assertDBDevice(t, diffAfter, diffDeviceID)
+	addDiffThing(t, after, "org.eclipse.kanto:kept", "lab")
+	addDiffThing(t, after, "org.eclipse.kanto:modified", "office")
+	addDiffThing(t, after, "org.eclipse.kanto:created", "lab")
+	require.NoError(t, after.Close())
+
+	changes, err := persistence.DiffFiles(diffBefore, diffAfter)
+	require.NoError(t, err)
+
+	require.Len(t, changes.Changes, 3)
+	assert.Equal(t, "org.eclipse.kanto:created", changes.Changes[0].ThingID)
+	assert.Equal(t, persistence.ChangeCreated, changes.Changes[0].Change)
+	assert.Equal(t, "org.eclipse.kanto:deleted", changes.Changes[1].ThingID)
+	assert.Equal(t, persistence.ChangeDeleted, changes.Changes[1].Change)
+	assert.Nil(t, changes.Changes[1].Patch)
+	assert.Equal(t, "org.eclipse.kanto:modified", changes.Changes[2].ThingID)
+	assert.Equal(t, persistence.ChangeModified, changes.Changes[2].Change)
+
+	patch, err := json.Marshal(changes.Changes[2].Patch)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"attributes": {"location": "office"},
+		"features": {"meter": {"properties": {"location": "office"}}}
+	}`, string(patch))
+
+	unchanged, err := persistence.DiffFiles(diffAfter, diffAfter)
+	require.NoError(t, err)
+	assert.Empty(t, unchanged.Changes)
+}
+
+func addDiffThing(t *testing.T, db persistence.ThingsStorage, thingID, location string) {
+	thing := (&model.Thing{}).
+		WithIDFrom(thingID).
+		WithAttribute("location", location).
+		WithFeature("meter", (&model.Feature{}).WithProperty("location", location))
+	_, err := db.AddThing(thing)
+	require.NoError(t, err)
+}
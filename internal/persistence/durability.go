@@ -0,0 +1,175 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Durability modes of the things database writes.
+const (
+	// DurabilityAlways syncs each committed transaction to the disk, it is the default mode.
+	DurabilityAlways = "always"
+	// DurabilityInterval writes the committed transactions to the disk at once on an interval,
+	// so that the high-frequency updates do not wear the flash media with a write and a sync per update.
+	DurabilityInterval = "interval"
+	// DurabilityShutdown writes the committed transactions to the disk on closing the database only.
+	DurabilityShutdown = "shutdown"
+)
+
+// Durability is the policy of writing the committed transactions of the database file to the disk.
+// Unless the mode is DurabilityAlways, the writes of the committed transactions are not written on commit.
+// The bbolt database keeps them in memory, coalescing the writes of the same keys, and writes them at once
+// within a single transaction, which is synced to the disk. Thus, the transactions committed after the last write
+// are lost on a power loss or on an abnormal termination of the process, but the database file is never corrupted.
+// The SQLite database writes them to its write-ahead log without syncing it, and syncs them on a checkpoint.
+// Thus, the transactions committed after the last checkpoint are lost on a power loss only.
+// The in-memory storage is not synced at all.
+type Durability struct {
+	// Mode is the durability mode, DurabilityAlways if empty.
+	Mode string
+	// Interval is the period of writing the committed transactions in DurabilityInterval mode.
+	Interval time.Duration
+	// MaxPending is the number of the committed transactions not written yet, on reaching which they are written
+	// regardless of the mode, 0 for unlimited. It bounds the transactions lost on a power loss.
+	MaxPending int
+}
+
+// Validate returns error if the durability mode is unknown or its interval is not positive.
+func (durability Durability) Validate() error {
+	switch durability.Mode {
+	case DurabilityAlways, DurabilityShutdown, "":
+	case DurabilityInterval:
+		if durability.Interval <= 0 {
+			return errors.Errorf("durability interval %v is not positive", durability.Interval)
+		}
+	default:
+		return errors.Errorf("unknown durability mode '%s'", durability.Mode)
+	}
+	if durability.MaxPending < 0 {
+		return errors.Errorf("durability max pending transactions %d is negative", durability.MaxPending)
+	}
+	return nil
+}
+
+// lazy returns true if the committed transactions are not written on commit.
+func (durability Durability) lazy() bool {
+	return len(durability.Mode) > 0 && durability.Mode != DurabilityAlways
+}
+
+// durable is implemented by the databases, which committed transactions could be synced lazily.
+type durable interface {
+	// setDurability applies the durability policy, syncing the pending transactions if any.
+	setDurability(durability Durability) error
+}
+
+// syncer writes or syncs the committed transactions of a database according to its durability policy.
+type syncer struct {
+	sync       func() error
+	maxPending int64
+
+	// pending is the number of the committed transactions not synced yet.
+	pending int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newSyncer returns a syncer of the lazy durability policy, syncing the committed transactions
+// on an interval in the background, if any.
+func newSyncer(durability Durability, sync func() error) *syncer {
+	s := &syncer{
+		sync:       sync,
+		maxPending: int64(durability.MaxPending),
+	}
+	if durability.Mode == DurabilityInterval {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.run(durability.Interval)
+	}
+	return s
+}
+
+func (s *syncer) run(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// committed counts the committed transaction, syncing the pending ones if their max number is reached.
+// The transaction is committed even if the sync fails, it is synced again on the next sync.
+func (s *syncer) committed() {
+	if s == nil {
+		return
+	}
+	if pending := atomic.AddInt64(&s.pending, 1); s.maxPending > 0 && pending >= s.maxPending {
+		s.flush()
+	}
+}
+
+// flush syncs the pending transactions, if any.
+func (s *syncer) flush() error {
+	if s == nil {
+		return nil
+	}
+	pending := atomic.SwapInt64(&s.pending, 0)
+	if pending == 0 {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		atomic.AddInt64(&s.pending, pending)
+		return errors.Wrap(err, "committed transactions could not be synced")
+	}
+	return nil
+}
+
+// close stops the background syncing and syncs the pending transactions.
+func (s *syncer) close() error {
+	if s == nil {
+		return nil
+	}
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return s.flush()
+}
+
+func (storage *thingsDB) SetDurability(durability Durability) error {
+	if err := durability.Validate(); err != nil {
+		return err
+	}
+	storage.durability = durability
+	return storage.applyDurability()
+}
+
+// applyDurability applies the durability policy of the storage to its database, e.g. after reopening.
+func (storage *thingsDB) applyDurability() error {
+	if db, ok := storage.db.(durable); ok {
+		return db.setDurability(storage.durability)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurability(t *testing.T) {
+	durabilities := []persistence.Durability{
+		{Mode: persistence.DurabilityAlways},
+		{Mode: persistence.DurabilityInterval, Interval: time.Millisecond},
+		{Mode: persistence.DurabilityShutdown, MaxPending: 2},
+		{Mode: persistence.DurabilityShutdown},
+	}
+	for _, engine := range []string{persistence.EngineBolt, persistence.EngineSQLite} {
+		for _, durability := range durabilities {
+			location := filepath.Join(t.TempDir(), "things.db")
+			storage, err := persistence.NewThingsStorage(engine, location, testThingID)
+			require.NoError(t, err)
+			require.NoError(t, storage.SetDurability(durability), engine)
+
+			for i := 0; i < 3; i++ {
+				_, err := storage.AddThing(createThing(fmt.Sprintf("durability:%d", i)))
+				require.NoError(t, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			// the durability is kept on reopening
+			require.NoError(t, storage.Reopen())
+			_, err = storage.AddThing(createThing("durability:reopened"))
+			require.NoError(t, err)
+			require.NoError(t, storage.Close())
+
+			storage, err = persistence.NewThingsStorage(engine, location, testThingID)
+			require.NoError(t, err)
+			count, err := storage.CountThings()
+			require.NoError(t, err)
+			assert.Equal(t, 4, count, "%s %s", engine, durability.Mode)
+			require.NoError(t, storage.GetThing("durability:reopened", &model.Thing{}))
+			require.NoError(t, storage.Close())
+		}
+	}
+}
+
+func TestDurabilityCoalesced(t *testing.T) {
+	pageBytes := make(map[string]uint64)
+	for _, mode := range []string{persistence.DurabilityAlways, persistence.DurabilityShutdown} {
+		location := filepath.Join(t.TempDir(), "things.db")
+		storage, err := persistence.NewThingsStorage(persistence.EngineBolt, location, testThingID)
+		require.NoError(t, err)
+		require.NoError(t, storage.SetDurability(persistence.Durability{Mode: mode}))
+		initial := storage.GetWriteStats()
+
+		_, err = storage.AddThing(createThing("durability:removed"))
+		require.NoError(t, err)
+		_, err = storage.AddThing(createThingWithFeatures("durability:coalesced", "feature1", "feature2"))
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			_, err = storage.AddFeature("durability:coalesced", "feature1", &model.Feature{
+				Properties: map[string]interface{}{"value": i},
+			})
+			require.NoError(t, err)
+		}
+		require.NoError(t, storage.RemoveThing("durability:removed"))
+
+		// the pending writes are read
+		feature := &model.Feature{}
+		require.NoError(t, storage.GetFeature("durability:coalesced", "feature1", feature))
+		assert.EqualValues(t, 9, feature.Properties["value"], mode)
+		ids, err := storage.GetThingIDs()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"durability:coalesced"}, ids, mode)
+		assert.ErrorIs(t, storage.GetThing("durability:removed", &model.Thing{}), persistence.ErrNotFound, mode)
+
+		// the pending writes are written on snapshot and on close
+		require.NoError(t, storage.Snapshot(io.Discard))
+		pageBytes[mode] = storage.GetWriteStats().PageBytes - initial.PageBytes
+		require.NoError(t, storage.Close())
+
+		storage, err = persistence.NewThingsStorage(persistence.EngineBolt, location, testThingID)
+		require.NoError(t, err)
+		feature = &model.Feature{}
+		require.NoError(t, storage.GetFeature("durability:coalesced", "feature1", feature))
+		assert.EqualValues(t, 9, feature.Properties["value"], mode)
+		count, err := storage.CountThings()
+		require.NoError(t, err)
+		assert.Equal(t, 1, count, mode)
+		require.NoError(t, storage.Close())
+	}
+	assert.Less(t, pageBytes[persistence.DurabilityShutdown], pageBytes[persistence.DurabilityAlways])
+}
+
+func TestDurabilityInvalid(t *testing.T) {
+	storage := persistence.NewInMemoryThingsDB(testThingID, 0)
+	defer storage.Close()
+
+	assert.NoError(t, storage.SetDurability(persistence.Durability{Mode: persistence.DurabilityShutdown}))
+	assert.Error(t, storage.SetDurability(persistence.Durability{Mode: persistence.DurabilityInterval}))
+	assert.Error(t, storage.SetDurability(persistence.Durability{Mode: "never"}))
+	assert.Error(t, storage.SetDurability(persistence.Durability{MaxPending: -1}))
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// exportVersion is the version of the exported things document format.
+const exportVersion = 1
+
+// ErrImportMismatch indicates that the imported things document is not compatible with the things storage,
+// e.g. it is exported from another device or in an unknown format version.
+var ErrImportMismatch = errors.New("things document is not compatible with the storage")
+
+// exportDocument is the portable representation of all stored things, independent of the storage engine.
+type exportDocument struct {
+	Version  int              `json:"version"`
+	DeviceID string           `json:"deviceId"`
+	Things   []*exportedThing `json:"things"`
+}
+
+// exportedThing contains the thing data together with its synchronization metadata.
+type exportedThing struct {
+	ID           string                 `json:"thingId"`
+	PolicyID     string                 `json:"policyId,omitempty"`
+	DefinitionID string                 `json:"definition,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Metadata     map[string]interface{} `json:"_metadata,omitempty"`
+
+	Revision         int64  `json:"_revision"`
+	Timestamp        string `json:"_modified,omitempty"`
+	TimestampQuality string `json:"_modifiedQuality,omitempty"`
+	Created          string `json:"_created,omitempty"`
+
+	Features map[string]*exportedFeature `json:"features,omitempty"`
+
+	// DeletedFeatures are the IDs of the locally deleted features, not synchronized yet.
+	DeletedFeatures []string `json:"deletedFeatures,omitempty"`
+	// UnsynchronizedFeatures are the revisions of the locally modified features, not synchronized yet.
+	UnsynchronizedFeatures map[string]int64 `json:"unsynchronizedFeatures,omitempty"`
+	// UnsynchronizedDefinitions are the IDs of the features with locally modified definitions, not synchronized yet.
+	UnsynchronizedDefinitions []string `json:"unsynchronizedDefinitions,omitempty"`
+	// UnsynchronizedAttributes is the count of the local attributes modifications, not synchronized yet.
+	UnsynchronizedAttributes int64 `json:"unsynchronizedAttributes,omitempty"`
+}
+
+// exportedFeature contains the feature data.
+type exportedFeature struct {
+	Definition        []string               `json:"definition,omitempty"`
+	Properties        map[string]interface{} `json:"properties,omitempty"`
+	DesiredProperties map[string]interface{} `json:"desiredProperties,omitempty"`
+	Metadata          map[string]interface{} `json:"_metadata,omitempty"`
+	Created           string                 `json:"_created,omitempty"`
+	Modified          string                 `json:"_modified,omitempty"`
+	Revision          int64                  `json:"_revision,omitempty"`
+}
+
+func (storage *thingsDB) Export(w io.Writer) error {
+	ids, err := storage.GetThingIDs()
+	if err != nil {
+		return errors.Wrap(err, "things could not be exported")
+	}
+	sort.Strings(ids)
+
+	document := &exportDocument{
+		Version:  exportVersion,
+		DeviceID: storage.deviceID,
+		Things:   make([]*exportedThing, 0, len(ids)),
+	}
+	for _, thingID := range ids {
+		thing, err := storage.exportThing(thingID)
+		if err != nil {
+			if errors.Is(err, ErrThingNotFound) {
+				continue // removed meanwhile
+			}
+			return errors.Wrapf(err, "thing with ID '%s' could not be exported", thingID)
+		}
+		document.Things = append(document.Things, thing)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+func (storage *thingsDB) exportThing(thingID string) (*exportedThing, error) {
+	thingData, systemThingData, err := storage.loadThingData(thingID)
+	if err != nil {
+		return nil, err
+	}
+
+	thing := &exportedThing{
+		ID:           thingData.ID,
+		PolicyID:     thingData.PolicyID,
+		DefinitionID: thingData.DefinitionID,
+		Attributes:   thingData.Attributes,
+		Metadata:     thingData.Metadata,
+		Features:     make(map[string]*exportedFeature),
+	}
+	if systemThingData != nil {
+		thing.Revision = systemThingData.Revision
+		thing.Timestamp = systemThingData.Timestamp
+		thing.TimestampQuality = systemThingData.TimestampQuality
+		thing.Created = systemThingData.Created
+		thing.UnsynchronizedFeatures = systemThingData.UnsynchronizedFeatures
+		thing.UnsynchronizedAttributes = systemThingData.UnsynchronizedAttributes
+		for featureID := range systemThingData.DeletedFeatures {
+			thing.DeletedFeatures = append(thing.DeletedFeatures, featureID)
+		}
+		sort.Strings(thing.DeletedFeatures)
+		for featureID := range systemThingData.UnsynchronizedDefinitions {
+			thing.UnsynchronizedDefinitions = append(thing.UnsynchronizedDefinitions, featureID)
+		}
+		sort.Strings(thing.UnsynchronizedDefinitions)
+	}
+
+	err = storage.db.ForEachAs(data.FeaturesKeyPrefix(thingID), &data.FeatureData{},
+		func(_ string, value interface{}) (bool, error) {
+			featureData := value.(*data.FeatureData)
+			thing.Features[featureData.ID] = &exportedFeature{
+				Definition:        featureData.Definition,
+				Properties:        featureData.Properties,
+				DesiredProperties: featureData.DesiredProperties,
+				Metadata:          featureData.Metadata,
+				Created:           featureData.Created,
+				Modified:          featureData.Modified,
+				Revision:          featureData.Revision,
+			}
+			return true, nil
+		})
+	return thing, err
+}
+
+func (storage *thingsDB) Import(r io.Reader) error {
+	document := &exportDocument{}
+	if err := json.NewDecoder(r).Decode(document); err != nil {
+		return errors.Wrap(err, "invalid things document")
+	}
+	if document.Version != exportVersion {
+		return errors.Wrapf(ErrImportMismatch, "unsupported version %d", document.Version)
+	}
+	if document.DeviceID != storage.deviceID {
+		return errors.Wrapf(ErrImportMismatch, "exported from device '%s'", document.DeviceID)
+	}
+
+	return storage.update(func(tx *thingsDB) error {
+		ids, err := tx.GetThingIDs()
+		if err != nil {
+			return err
+		}
+		removed := make(map[string]int64, len(ids))
+		for _, thingID := range ids {
+			if tx.watchers.active() {
+				if systemThingData, err := tx.loadSystemThingData(thingID); err == nil {
+					removed[thingID] = systemThingData.Revision
+				}
+			}
+			if err := tx.removeThingData(thingID); err != nil {
+				return err
+			}
+		}
+
+		things := make(map[string]interface{}, len(document.Things))
+		for _, thing := range document.Things {
+			if len(thing.ID) == 0 {
+				return errors.New("invalid things document: a thing without ID")
+			}
+			if err := tx.db.SetAllAs(importedThingData(thing)); err != nil {
+				return errors.Wrapf(err, "thing with ID '%s' could not be imported", thing.ID)
+			}
+			if err := tx.indexThing(thing.ID, nil, thing.Attributes); err != nil {
+				return err
+			}
+			things[thing.ID] = nil
+			if _, ok := removed[thing.ID]; ok {
+				tx.changed(thing.ID, "", ChangeModified, thing.Revision)
+			} else {
+				tx.changed(thing.ID, "", ChangeCreated, thing.Revision)
+			}
+		}
+		for thingID, revision := range removed {
+			if _, ok := things[thingID]; !ok {
+				tx.changed(thingID, "", ChangeDeleted, revision)
+			}
+		}
+		return tx.db.SetAs(data.IDSeparator, things)
+	})
+}
+
+func importedThingData(thing *exportedThing) map[string]interface{} {
+	thingData := &data.ThingData{
+		ID:           thing.ID,
+		PolicyID:     thing.PolicyID,
+		DefinitionID: thing.DefinitionID,
+		Attributes:   thing.Attributes,
+		Metadata:     thing.Metadata,
+	}
+	systemThingData := &data.SystemThingData{
+		ID:                     thing.ID,
+		Revision:               thing.Revision,
+		Timestamp:              thing.Timestamp,
+		TimestampQuality:       thing.TimestampQuality,
+		Created:                thing.Created,
+		DeletedFeatures:        make(map[string]interface{}),
+		UnsynchronizedFeatures: thing.UnsynchronizedFeatures,
+
+		UnsynchronizedAttributes: thing.UnsynchronizedAttributes,
+	}
+	if systemThingData.UnsynchronizedFeatures == nil {
+		systemThingData.UnsynchronizedFeatures = make(map[string]int64)
+	}
+	for _, featureID := range thing.DeletedFeatures {
+		systemThingData.DeletedFeatures[featureID] = nil
+	}
+	if len(thing.UnsynchronizedDefinitions) > 0 {
+		systemThingData.UnsynchronizedDefinitions = make(map[string]interface{}, len(thing.UnsynchronizedDefinitions))
+		for _, featureID := range thing.UnsynchronizedDefinitions {
+			systemThingData.UnsynchronizedDefinitions[featureID] = nil
+		}
+	}
+
+	values := map[string]interface{}{
+		thingData.Key():       thingData.Data(),
+		systemThingData.Key(): systemThingData.Data(),
+	}
+	for featureID, feature := range thing.Features {
+		if feature == nil {
+			feature = &exportedFeature{}
+		}
+		featureData := &data.FeatureData{
+			ID:                featureID,
+			ThingID:           thing.ID,
+			Definition:        feature.Definition,
+			Properties:        feature.Properties,
+			DesiredProperties: feature.DesiredProperties,
+			Metadata:          feature.Metadata,
+			Created:           feature.Created,
+			Modified:          feature.Modified,
+			Revision:          feature.Revision,
+		}
+		values[featureData.Key()] = featureData.Data()
+	}
+	return values
+}
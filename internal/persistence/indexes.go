@@ -0,0 +1,261 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// ErrNotIndexed indicates that the things are looked up by an attribute which is not indexed.
+var ErrNotIndexed = errors.New("attribute is not indexed")
+
+const attributePathSeparator = "/"
+
+func (storage *thingsDB) SetIndexedAttributes(paths []string) error {
+	indexed, err := indexPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	err = storage.update(func(tx *thingsDB) error {
+		current, err := tx.indexedAttributes()
+		if err != nil {
+			return err
+		}
+		if samePaths(current, indexed) {
+			return nil
+		}
+
+		if err := tx.db.DeleteAll(data.IndexKeyPrefix); err != nil {
+			return err
+		}
+		if len(indexed) == 0 {
+			if err := tx.db.Delete(systemKeyIndexes); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			return nil
+		}
+
+		ids, err := tx.GetThingIDs()
+		if err != nil {
+			return err
+		}
+		values := map[string]interface{}{systemKeyIndexes: &data.IndexesData{Paths: indexed}}
+		for _, thingID := range ids {
+			thingData := &data.ThingData{}
+			if err := tx.db.GetAs(thingID, thingData); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			for key := range indexKeys(indexed, thingID, thingData.Attributes) {
+				values[key] = thingID
+			}
+		}
+		return tx.db.SetAllAs(values)
+	})
+	if err != nil {
+		return errors.Wrap(err, "attribute indexes could not be updated")
+	}
+	storage.indexed = indexed
+	return nil
+}
+
+func (storage *thingsDB) GetIndexedThingIDs(path string, values ...interface{}) ([]string, error) {
+	indexed, err := storage.indexedAttributes()
+	if err != nil {
+		return nil, err
+	}
+	path = strings.Trim(path, attributePathSeparator)
+	if !containsPath(indexed, path) {
+		return nil, errors.Wrapf(ErrNotIndexed, "attribute '%s'", path)
+	}
+
+	unique := make(map[string]bool)
+	for _, value := range values {
+		encoded, ok := indexValue(value)
+		if !ok {
+			continue
+		}
+		prefix := data.IndexValueKeyPrefix(path, encoded)
+		keys, err := storage.db.Keys(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			unique[strings.TrimPrefix(key, prefix)] = true
+		}
+	}
+
+	ids := make([]string, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// indexedAttributes returns the persisted paths of the indexed attributes, nil if there are no indexes.
+func (storage *thingsDB) indexedAttributes() ([]string, error) {
+	indexes := &data.IndexesData{}
+	if err := storage.db.GetAs(systemKeyIndexes, indexes); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "attribute indexes could not be loaded")
+	}
+	return indexes.Paths, nil
+}
+
+// indexThing updates the index entries of the thing from its previous attributes to the provided ones.
+// The persisted indexes are used, so that the things modified by any storage user, e.g. on import with
+// the ldt-admin tool, are indexed.
+func (storage *thingsDB) indexThing(thingID string, previous, attributes map[string]interface{}) error {
+	indexed, err := storage.indexedAttributes()
+	if err != nil || len(indexed) == 0 {
+		return err
+	}
+
+	keys := indexKeys(indexed, thingID, attributes)
+	for key := range indexKeys(indexed, thingID, previous) {
+		if _, ok := keys[key]; ok {
+			delete(keys, key)
+		} else if err := storage.db.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(keys))
+	for key := range keys {
+		values[key] = thingID
+	}
+	return storage.db.SetAllAs(values)
+}
+
+// unindexThing removes the index entries of the stored thing, if any.
+func (storage *thingsDB) unindexThing(thingID string) error {
+	indexed, err := storage.indexedAttributes()
+	if err != nil || len(indexed) == 0 {
+		return err
+	}
+
+	thingData := &data.ThingData{}
+	if err := storage.db.GetAs(thingID, thingData); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return storage.indexThing(thingID, thingData.Attributes, nil)
+}
+
+// reindex updates the indexes of a reopened database to the ones set on the storage, if any.
+func (storage *thingsDB) reindex() error {
+	if storage.indexed == nil {
+		return nil
+	}
+	return storage.SetIndexedAttributes(storage.indexed)
+}
+
+// indexKeys returns the keys of the index entries of the thing attributes.
+// Only the scalar values are indexed, i.e. the objects and the arrays are skipped.
+func indexKeys(indexed []string, thingID string, attributes map[string]interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	for _, path := range indexed {
+		value, ok := attributeValue(attributes, path)
+		if !ok {
+			continue
+		}
+		if encoded, ok := indexValue(value); ok {
+			keys[data.IndexKey(path, encoded, thingID)] = true
+		}
+	}
+	return keys
+}
+
+func attributeValue(attributes map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = attributes
+	for _, name := range strings.Split(path, attributePathSeparator) {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// indexValue returns the canonical JSON of a scalar value, so that the equal numbers are indexed the same way.
+func indexValue(value interface{}) (string, bool) {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+	encoded, err := jsonutil.CanonicalJSON(value)
+	if err != nil || len(encoded) == 0 || encoded[0] == '{' || encoded[0] == '[' {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// indexPaths returns the sorted unique paths of the indexed attributes without leading and trailing slashes.
+func indexPaths(paths []string) ([]string, error) {
+	unique := make(map[string]bool)
+	for _, path := range paths {
+		path = strings.Trim(strings.TrimSpace(path), attributePathSeparator)
+		if len(path) == 0 {
+			continue
+		}
+		if strings.Contains(path, data.IDSeparator) ||
+			strings.Contains(path, attributePathSeparator+attributePathSeparator) {
+			return nil, errors.Errorf("invalid indexed attribute path '%s'", path)
+		}
+		unique[path] = true
+	}
+
+	indexed := make([]string, 0, len(unique))
+	for path := range unique {
+		indexed = append(indexed, path)
+	}
+	sort.Strings(indexed)
+	return indexed, nil
+}
+
+func samePaths(paths []string, other []string) bool {
+	if len(paths) != len(other) {
+		return false
+	}
+	for i := range paths {
+		if paths[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPath(paths []string, path string) bool {
+	i := sort.SearchStrings(paths, path)
+	return i < len(paths) && paths[i] == path
+}
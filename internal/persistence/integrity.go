@@ -0,0 +1,330 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// IntegrityReport contains the inconsistencies between the things IDs index, the things records,
+// the features records and the things system data found by the integrity check.
+// The features are listed by their paths, e.g. 'org.eclipse.kanto:test/features/meter'.
+type IntegrityReport struct {
+	// Things is the number of the stored things.
+	Things int `json:"things"`
+	// Features is the number of the stored features, including the orphan ones.
+	Features int `json:"features"`
+	// Repaired is true if any inconsistency is found and repaired, except the corrupted records.
+	Repaired bool `json:"repaired"`
+	// Unindexed contains the IDs of the stored things missing from the things IDs index.
+	Unindexed []string `json:"unindexed,omitempty"`
+	// Unstored contains the IDs of the things IDs index without stored things.
+	Unstored []string `json:"unstored,omitempty"`
+	// MissingSystemData contains the IDs of the stored things without system data or with corrupted one,
+	// which is restored with all of their features unsynchronized.
+	MissingSystemData []string `json:"missingSystemData,omitempty"`
+	// OrphanSystemData contains the IDs of the things system data without stored things.
+	OrphanSystemData []string `json:"orphanSystemData,omitempty"`
+	// OrphanFeatures contains the paths of the stored features without stored things.
+	OrphanFeatures []string `json:"orphanFeatures,omitempty"`
+	// MismatchedFeatures contains the paths of the stored features, which thing or feature ID differs
+	// from the one of the record key.
+	MismatchedFeatures []string `json:"mismatchedFeatures,omitempty"`
+	// StaleDeleted contains the paths of the stored features, which are marked as deleted by the system data.
+	StaleDeleted []string `json:"staleDeleted,omitempty"`
+	// StaleUnsynchronized contains the paths of the features marked as unsynchronized by the system data,
+	// which are not stored.
+	StaleUnsynchronized []string `json:"staleUnsynchronized,omitempty"`
+	// Corrupted contains the keys of the records, which could not be decoded. The corrupted things and features
+	// records are reported only, as repairing them would lose the things data.
+	Corrupted []string `json:"corrupted,omitempty"`
+}
+
+// Consistent returns true if no inconsistency is found.
+func (report *IntegrityReport) Consistent() bool {
+	return len(report.Corrupted) == 0 && !report.repairable()
+}
+
+// repairable returns true if any inconsistency, other than a corrupted record, is found.
+func (report *IntegrityReport) repairable() bool {
+	for _, list := range report.lists() {
+		if len(list) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (report *IntegrityReport) lists() [][]string {
+	return [][]string{
+		report.Unindexed, report.Unstored, report.MissingSystemData, report.OrphanSystemData, report.OrphanFeatures,
+		report.MismatchedFeatures, report.StaleDeleted, report.StaleUnsynchronized,
+	}
+}
+
+// thingRecords are the scanned records of a thing, which data, system data or features are stored.
+type thingRecords struct {
+	stored   bool
+	system   *data.SystemThingData
+	features map[string]*data.FeatureData
+}
+
+// RepairIntegrity checks and repairs the integrity of the closed database file of the provided storage engine,
+// e.g. while the service is stopped. There is nothing to be repaired for the in-memory storage.
+func RepairIntegrity(engine, path string) (*IntegrityReport, error) {
+	if engine == EngineMemory {
+		return &IntegrityReport{}, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	database, err := openDatabase(engine, path)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	deviceID, _ := database.GetName()
+	storage := &thingsDB{deviceID: deviceID, path: path, engine: engine, db: database}
+	return storage.CheckIntegrity(true)
+}
+
+func (storage *thingsDB) CheckIntegrity(repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{}
+	if !repair {
+		if err := storage.checkIntegrity(report, false); err != nil {
+			return nil, errors.Wrap(err, "things db integrity could not be checked")
+		}
+		return report, nil
+	}
+
+	err := storage.update(func(tx *thingsDB) error {
+		*report = IntegrityReport{}
+		return tx.checkIntegrity(report, true)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "things db integrity could not be repaired")
+	}
+	report.Repaired = report.repairable()
+	return report, nil
+}
+
+// checkIntegrity scans all database records and reports their inconsistencies, repairing them if requested.
+// The watchers are not notified, as the things data is not modified.
+func (storage *thingsDB) checkIntegrity(report *IntegrityReport, repair bool) error {
+	things, err := storage.scanThingRecords(report)
+	if err != nil {
+		return err
+	}
+
+	indexed := make(map[string]interface{})
+	if err := storage.db.GetAs(data.IDSeparator, &indexed); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	ids := make(map[string]interface{}, len(things))
+	for thingID, records := range things {
+		if records.stored {
+			ids[thingID] = nil
+			if _, ok := indexed[thingID]; !ok {
+				report.Unindexed = append(report.Unindexed, thingID)
+			}
+		}
+	}
+	for thingID := range indexed {
+		if _, ok := ids[thingID]; !ok {
+			report.Unstored = append(report.Unstored, thingID)
+		}
+	}
+	report.Things = len(ids)
+
+	for thingID, records := range things {
+		if err := storage.checkThingRecords(report, thingID, records, repair); err != nil {
+			return err
+		}
+	}
+
+	for _, list := range append(report.lists(), report.Corrupted) {
+		sort.Strings(list)
+	}
+	if repair && (len(report.Unindexed) > 0 || len(report.Unstored) > 0) {
+		return storage.db.SetAs(data.IDSeparator, ids)
+	}
+	return nil
+}
+
+// scanThingRecords reads all things records, reporting the corrupted ones.
+func (storage *thingsDB) scanThingRecords(report *IntegrityReport) (map[string]*thingRecords, error) {
+	things := make(map[string]*thingRecords)
+	recordsOf := func(thingID string) *thingRecords {
+		records, ok := things[thingID]
+		if !ok {
+			records = &thingRecords{features: make(map[string]*data.FeatureData)}
+			things[thingID] = records
+		}
+		return records
+	}
+
+	after := ""
+	for {
+		keys, err := storage.db.KeysAfter("", after, countBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			switch {
+			case key == data.IDSeparator, strings.HasPrefix(key, systemKeysPrefix):
+				continue
+			case strings.HasPrefix(key, data.IDSeparator):
+				system := &data.SystemThingData{}
+				records := recordsOf(strings.TrimPrefix(key, data.IDSeparator))
+				if decoded, err := storage.decodeRecord(key, system, report); err != nil {
+					return nil, err
+				} else if decoded {
+					records.system = system
+				}
+			case strings.Contains(key, data.IDSeparator):
+				i := strings.Index(key, data.IDSeparator)
+				feature := &data.FeatureData{}
+				decoded, err := storage.decodeRecord(key, feature, report)
+				if err != nil {
+					return nil, err
+				}
+				if !decoded {
+					feature = nil // stored, but not to be repaired
+				}
+				recordsOf(key[:i]).features[key[i+len(data.IDSeparator):]] = feature
+				report.Features++
+			default:
+				if _, err := storage.decodeRecord(key, &data.ThingData{}, report); err != nil {
+					return nil, err
+				}
+				recordsOf(key).stored = true
+			}
+		}
+		if len(keys) < countBatchSize {
+			return things, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// decodeRecord decodes the record with the provided key, reporting it as corrupted if it could not be decoded.
+// Returns true if the record is decoded.
+func (storage *thingsDB) decodeRecord(key string, value interface{}, report *IntegrityReport) (bool, error) {
+	raw, err := storage.db.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if err := decodeAs(raw, value); err != nil {
+		report.Corrupted = append(report.Corrupted, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkThingRecords reports the inconsistencies of the records of a thing, repairing them if requested.
+func (storage *thingsDB) checkThingRecords(
+	report *IntegrityReport, thingID string, records *thingRecords, repair bool,
+) error {
+	if !records.stored {
+		if records.system != nil {
+			report.OrphanSystemData = append(report.OrphanSystemData, thingID)
+		}
+		for featureID := range records.features {
+			report.OrphanFeatures = append(report.OrphanFeatures, featurePath(thingID, featureID))
+		}
+		if repair {
+			return storage.removeThingData(thingID)
+		}
+		return nil
+	}
+
+	for featureID, feature := range records.features {
+		if feature == nil || feature.ThingID == thingID && feature.ID == featureID {
+			continue
+		}
+		report.MismatchedFeatures = append(report.MismatchedFeatures, featurePath(thingID, featureID))
+		if repair {
+			feature.ThingID, feature.ID = thingID, featureID
+			if err := storage.db.SetAs(feature.Key(), feature.Data()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if records.system == nil {
+		report.MissingSystemData = append(report.MissingSystemData, thingID)
+		if repair {
+			return storage.restoreSystemThingData(thingID)
+		}
+		return nil
+	}
+	return storage.checkSystemThingData(report, records, repair)
+}
+
+// checkSystemThingData reports the features marked as deleted, which are stored, and the features marked as
+// unsynchronized, which are not stored. On repair, the stored features are marked as unsynchronized
+// and the missing ones as deleted, so that their state is synchronized with the hub.
+func (storage *thingsDB) checkSystemThingData(report *IntegrityReport, records *thingRecords, repair bool) error {
+	system := records.system
+	modified := false
+	for featureID := range system.DeletedFeatures {
+		if _, ok := records.features[featureID]; !ok {
+			continue
+		}
+		report.StaleDeleted = append(report.StaleDeleted, featurePath(system.ID, featureID))
+		delete(system.DeletedFeatures, featureID)
+		if system.UnsynchronizedFeatures == nil {
+			system.UnsynchronizedFeatures = make(map[string]int64)
+		}
+		system.UnsynchronizedFeatures[featureID] = system.Revision
+		modified = true
+	}
+
+	stale := make(map[string]bool)
+	for featureID := range system.UnsynchronizedFeatures {
+		stale[featureID] = true
+	}
+	for featureID := range system.UnsynchronizedDefinitions {
+		stale[featureID] = true
+	}
+	for featureID := range stale {
+		if _, ok := records.features[featureID]; ok {
+			continue
+		}
+		report.StaleUnsynchronized = append(report.StaleUnsynchronized, featurePath(system.ID, featureID))
+		delete(system.UnsynchronizedFeatures, featureID)
+		delete(system.UnsynchronizedDefinitions, featureID)
+		if system.DeletedFeatures == nil {
+			system.DeletedFeatures = make(map[string]interface{})
+		}
+		system.DeletedFeatures[featureID] = nil
+		modified = true
+	}
+
+	if repair && modified {
+		return storage.db.SetAs(system.Key(), system.Data())
+	}
+	return nil
+}
+
+func featurePath(thingID, featureID string) string {
+	return thingID + "/features/" + featureID
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	const (
+		thingA = "integrity:a"
+		thingB = "integrity:b"
+		orphan = "integrity:orphan"
+	)
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	storage, err := persistence.NewThingsDB(location, testThingID)
+	require.NoError(t, err)
+	for _, thingID := range []string{thingA, thingB} {
+		_, err := storage.AddThing(createThing(thingID))
+		require.NoError(t, err)
+	}
+
+	report, err := storage.CheckIntegrity(false)
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.IntegrityReport{Things: 2, Features: 4}, report)
+	assert.True(t, report.Consistent())
+	require.NoError(t, storage.Close())
+
+	// the records drift apart, e.g. as written by a previous version or on a crash
+	db, err := persistence.NewDatabase(location)
+	require.NoError(t, err)
+	require.NoError(t, db.SetAs(data.IDSeparator, map[string]interface{}{thingA: nil, "integrity:ghost": nil}))
+	require.NoError(t, db.Delete(data.SystemThingKey(thingB)))
+	require.NoError(t, db.SetAs(data.FeatureKey(orphan, "meter"), &data.FeatureData{ThingID: orphan, ID: "meter"}))
+	require.NoError(t, db.Set(data.FeatureKey(thingB, "broken"), []byte("x")))
+
+	feature := &data.FeatureData{}
+	require.NoError(t, db.GetAs(data.FeatureKey(thingA, testFeatureID1), feature))
+	feature.ID = "other"
+	require.NoError(t, db.SetAs(data.FeatureKey(thingA, testFeatureID1), feature))
+
+	system := &data.SystemThingData{}
+	require.NoError(t, db.GetAs(data.SystemThingKey(thingA), system))
+	system.DeletedFeatures = map[string]interface{}{testFeatureID2: nil}
+	system.UnsynchronizedFeatures = map[string]int64{"gone": system.Revision}
+	require.NoError(t, db.SetAs(data.SystemThingKey(thingA), system))
+	require.NoError(t, db.Close())
+
+	storage, err = persistence.NewThingsDB(location, testThingID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	expected := &persistence.IntegrityReport{
+		Things:              2,
+		Features:            6,
+		Unindexed:           []string{thingB},
+		Unstored:            []string{"integrity:ghost"},
+		MissingSystemData:   []string{thingB},
+		OrphanFeatures:      []string{orphan + "/features/meter"},
+		MismatchedFeatures:  []string{thingA + "/features/" + testFeatureID1},
+		StaleDeleted:        []string{thingA + "/features/" + testFeatureID2},
+		StaleUnsynchronized: []string{thingA + "/features/gone"},
+		Corrupted:           []string{data.FeatureKey(thingB, "broken")},
+	}
+	report, err = storage.CheckIntegrity(false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report)
+	assert.False(t, report.Consistent())
+
+	// the check does not modify the records
+	report, err = storage.CheckIntegrity(false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report)
+
+	report, err = storage.CheckIntegrity(true)
+	require.NoError(t, err)
+	expected.Repaired = true
+	assert.Equal(t, expected, report)
+
+	// the corrupted records are not repaired
+	report, err = storage.CheckIntegrity(false)
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.IntegrityReport{
+		Things:    2,
+		Features:  5,
+		Corrupted: []string{data.FeatureKey(thingB, "broken")},
+	}, report)
+	assert.False(t, report.Consistent())
+
+	count, err := storage.CountThings()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	system, err = storage.GetSystemThingData(thingA)
+	require.NoError(t, err)
+	assert.Contains(t, system.UnsynchronizedFeatures, testFeatureID2)
+	assert.NotContains(t, system.UnsynchronizedFeatures, "gone")
+	assert.Contains(t, system.DeletedFeatures, "gone")
+	assert.NotContains(t, system.DeletedFeatures, testFeatureID2)
+
+	system, err = storage.GetSystemThingData(thingB)
+	require.NoError(t, err)
+	assert.Contains(t, system.UnsynchronizedFeatures, testFeatureID1)
+
+	thing := &model.Thing{}
+	require.NoError(t, storage.GetThing(thingA, thing))
+	assert.Len(t, thing.Features, 2)
+	assert.Equal(t, "prop1Val", thing.Features[testFeatureID1].Properties["prop1"])
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// JournalRetention defines the retention policy of the journaled events.
+type JournalRetention struct {
+	// MaxSize is the maximum total size in bytes of the newest events to be kept, zero or negative for unlimited.
+	MaxSize int64
+	// MaxAge is the maximum age of the events to be kept, zero or negative for unlimited.
+	MaxAge time.Duration
+}
+
+type journalRecord struct {
+	key       string
+	timestamp string
+	size      int64
+}
+
+func (storage *thingsDB) AppendEvent(entry *data.JournalEntry) error {
+	if len(entry.Timestamp) == 0 {
+		entry.Timestamp = time.Now().UTC().Format(data.JournalTimestampFormat)
+	}
+	if err := storage.db.SetAs(entry.Key(), entry); err != nil {
+		return errors.Wrapf(err, "event of the thing with ID '%s' could not be journaled", entry.ThingID)
+	}
+	return nil
+}
+
+func (storage *thingsDB) GetEvents(thingID string, fromRevision int64, toRevision int64) ([]*data.JournalEntry, error) {
+	var entries []*data.JournalEntry
+	err := storage.db.ForEachAs(data.JournalThingKeyPrefix(thingID), &data.JournalEntry{},
+		func(_ string, value interface{}) (bool, error) {
+			entry := value.(*data.JournalEntry)
+			if toRevision > 0 && entry.Revision > toRevision {
+				return false, nil
+			}
+			if entry.Revision >= fromRevision {
+				entries = append(entries, entry)
+			}
+			return true, nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "journaled events of the thing with ID '%s' could not be loaded", thingID)
+	}
+	return entries, nil
+}
+
+func (storage *thingsDB) PruneEvents(retention JournalRetention, now time.Time) (int, error) {
+	if retention.MaxSize <= 0 && retention.MaxAge <= 0 {
+		return 0, nil
+	}
+
+	var records []journalRecord
+	if err := storage.db.ForEachAs(data.JournalKeyPrefix, &data.JournalEntry{},
+		func(key string, value interface{}) (bool, error) {
+			entry := value.(*data.JournalEntry)
+			records = append(records, journalRecord{key, entry.Timestamp, int64(len(entry.Event))})
+			return true, nil
+		}); err != nil {
+		return 0, errors.Wrap(err, "journaled events could not be iterated")
+	}
+
+	// newest first
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].timestamp > records[j].timestamp
+	})
+
+	oldest := ""
+	if retention.MaxAge > 0 {
+		oldest = now.Add(-retention.MaxAge).UTC().Format(data.JournalTimestampFormat)
+	}
+
+	var size int64
+	var expired []string
+	for _, record := range records {
+		size = size + record.size
+		if (retention.MaxSize > 0 && size > retention.MaxSize) || record.timestamp < oldest {
+			expired = append(expired, record.key)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	err := storage.db.Batch(func(db Database) error {
+		for _, key := range expired {
+			if err := db.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "expired journaled events could not be removed")
+	}
+	return len(expired), nil
+}
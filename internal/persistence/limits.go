@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/pkg/errors"
+)
+
+// ErrLimitExceeded indicates that a thing or feature is not stored as it would exceed the configured storage limits.
+var ErrLimitExceeded = errors.New("storage limit is exceeded")
+
+// Limits contains the storage limits, preventing a runaway local application from filling the storage.
+// A zero or negative limit is unlimited.
+type Limits struct {
+	// MaxThings is the maximum number of the stored things.
+	MaxThings int
+	// MaxFeatures is the maximum number of the stored features per thing.
+	MaxFeatures int
+	// MaxPropertySize is the maximum size in bytes of the JSON value of a feature property or desired property.
+	MaxPropertySize int
+}
+
+// checkThing checks if storing the thing would exceed the limits.
+func (storage *thingsDB) checkThing(thingID string, thing *model.Thing, created bool) error {
+	if created && storage.limits.MaxThings > 0 {
+		things := make(map[string]interface{})
+		if err := storage.db.GetAs(data.IDSeparator, &things); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if len(things) >= storage.limits.MaxThings {
+			return errors.Wrapf(ErrLimitExceeded, "maximum number of '%d' things is reached", storage.limits.MaxThings)
+		}
+	}
+	if storage.limits.MaxFeatures > 0 && len(thing.Features) > storage.limits.MaxFeatures {
+		return errors.Wrapf(ErrLimitExceeded, "thing with ID '%s' exceeds the maximum number of '%d' features",
+			thingID, storage.limits.MaxFeatures)
+	}
+	for featureID, feature := range thing.Features {
+		if err := storage.checkProperties(featureID, feature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFeature checks if storing the feature of the thing would exceed the limits.
+func (storage *thingsDB) checkFeature(thingID string, featureID string, feature *model.Feature) error {
+	if storage.limits.MaxFeatures > 0 {
+		if _, err := storage.db.Get(data.FeatureKey(thingID, featureID)); errors.Is(err, ErrNotFound) {
+			featureIDs, err := storage.featureIDs(thingID)
+			if err != nil {
+				return err
+			}
+			if len(featureIDs) >= storage.limits.MaxFeatures {
+				return errors.Wrapf(ErrLimitExceeded, "thing with ID '%s' has the maximum number of '%d' features",
+					thingID, storage.limits.MaxFeatures)
+			}
+		}
+	}
+	return storage.checkProperties(featureID, feature)
+}
+
+func (storage *thingsDB) checkProperties(featureID string, feature *model.Feature) error {
+	if storage.limits.MaxPropertySize <= 0 || feature == nil {
+		return nil
+	}
+	for _, properties := range []map[string]interface{}{feature.Properties, feature.DesiredProperties} {
+		for name, value := range properties {
+			payload, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			if len(payload) > storage.limits.MaxPropertySize {
+				return errors.Wrapf(ErrLimitExceeded,
+					"property '%s' of feature with ID '%s' of '%d' bytes exceeds the maximum size of '%d' bytes",
+					name, featureID, len(payload), storage.limits.MaxPropertySize)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Maintenance provides exclusive access to the things storage for maintenance operations on its file,
+// e.g. compaction, backup restore or encryption key rotation, without restarting the service.
+type Maintenance struct {
+	storage ThingsStorage
+	lock    sync.RWMutex
+
+	// inUse is the number of the current storage usages.
+	inUse int32
+	// released is the time in Unix nanoseconds of the last usage release.
+	released int64
+}
+
+// NewMaintenance creates the maintenance access manager of the provided things storage.
+func NewMaintenance(storage ThingsStorage) *Maintenance {
+	return &Maintenance{storage: storage}
+}
+
+// Use blocks while a maintenance operation is running and then marks the storage as in use
+// until the returned release function is invoked.
+func (m *Maintenance) Use() func() {
+	m.lock.RLock()
+	atomic.AddInt32(&m.inUse, 1)
+	return func() {
+		atomic.StoreInt64(&m.released, time.Now().UnixNano())
+		atomic.AddInt32(&m.inUse, -1)
+		m.lock.RUnlock()
+	}
+}
+
+// Busy returns true if the storage is in use or its last usage is released within the provided quiet period,
+// e.g. while a synchronization or a burst of commands is in-flight, so that a maintenance operation is postponed.
+func (m *Maintenance) Busy(quiet time.Duration) bool {
+	if atomic.LoadInt32(&m.inUse) > 0 {
+		return true
+	}
+	released := atomic.LoadInt64(&m.released)
+	return released != 0 && time.Since(time.Unix(0, released)) < quiet
+}
+
+// Run waits for the current storage usages to be released, closes the storage and runs the maintenance operation.
+// The storage is reopened afterwards, even if the operation fails. Any new usages are blocked until then.
+// The maintenance time is persisted if the operation succeeds.
+// Returns the operation error or the storage close or reopen error.
+func (m *Maintenance) Run(operation func() error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := m.storage.Close(); err != nil && !errors.Is(err, ErrDatabaseClosed) {
+		return errors.Wrap(err, "failed to close the storage for maintenance")
+	}
+
+	opErr := operation()
+
+	if err := m.storage.Reopen(); err != nil {
+		return errors.Wrap(err, "failed to reopen the storage after maintenance")
+	}
+	if opErr != nil {
+		return opErr
+	}
+	return m.storage.SetCompacted(time.Now())
+}
+
+// Compact compacts the things db file, so that the space of the removed things, features and journaled events
+// is released, e.g. as the bbolt database files never shrink. The compaction is run as a maintenance operation,
+// i.e. it waits for the current storage usages to be released.
+// Returns ErrCompactionUnsupported if the database file is shared with other devices.
+func (m *Maintenance) Compact() (*CompactionReport, error) {
+	storage, ok := m.storage.(*thingsDB)
+	if !ok || storage.partitions != nil {
+		return nil, ErrCompactionUnsupported
+	}
+
+	var report *CompactionReport
+	if err := m.Run(func() error {
+		var err error
+		report, err = Compact(storage.engine, storage.path)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	maintenanceDir      = "maintenance"
+	maintenanceLocation = maintenanceDir + "/things.db"
+	maintenanceDeviceID = "org.eclipse.kanto:TestMaintenance"
+	maintenanceThingID  = "org.eclipse.kanto:testThing"
+)
+
+func TestMaintenanceRun(t *testing.T) {
+	db := maintenanceDB(t)
+	defer removeMaintenanceDB(t, db)
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	assert.Empty(t, stats.Compacted)
+
+	maintenance := persistence.NewMaintenance(db)
+	require.NoError(t, maintenance.Run(func() error {
+		_, err := db.GetThingIDs()
+		assert.True(t, errors.Is(err, persistence.ErrDatabaseClosed))
+		return nil
+	}))
+	assertThing(t, db, maintenanceThingID, true)
+
+	stats, err = db.Stats()
+	require.NoError(t, err)
+	assert.NotEmpty(t, stats.Compacted)
+
+	operationErr := errors.New("maintenance failed")
+	assert.Equal(t, operationErr, maintenance.Run(func() error {
+		return operationErr
+	}))
+	assertThing(t, db, maintenanceThingID, true)
+}
+
+func TestMaintenanceRunRestore(t *testing.T) {
+	db := maintenanceDB(t)
+	defer removeMaintenanceDB(t, db)
+
+	maintenance := persistence.NewMaintenance(db)
+	require.NoError(t, maintenance.Run(func() error {
+		return os.Remove(maintenanceLocation)
+	}))
+	assertThing(t, db, maintenanceThingID, false)
+	assert.Equal(t, maintenanceDeviceID, db.GetDeviceID())
+}
+
+func TestMaintenanceRunWaitsUsage(t *testing.T) {
+	db := maintenanceDB(t)
+	defer removeMaintenanceDB(t, db)
+
+	maintenance := persistence.NewMaintenance(db)
+	release := maintenance.Use()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- maintenance.Run(func() error { return nil })
+	}()
+
+	select {
+	case <-done:
+		require.Fail(t, "maintenance run while the storage is in use")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assertThing(t, db, maintenanceThingID, true)
+
+	release()
+	require.NoError(t, <-done)
+
+	release = maintenance.Use()
+	defer release()
+	assertThing(t, db, maintenanceThingID, true)
+}
+
+func TestMaintenanceBusy(t *testing.T) {
+	db := persistence.NewInMemoryThingsDB(maintenanceDeviceID, 0)
+	defer db.Close()
+
+	maintenance := persistence.NewMaintenance(db)
+	assert.False(t, maintenance.Busy(time.Hour))
+
+	release := maintenance.Use()
+	assert.True(t, maintenance.Busy(0))
+	release()
+	assert.True(t, maintenance.Busy(time.Hour))
+	assert.False(t, maintenance.Busy(0))
+}
+
+func TestMaintenanceCompact(t *testing.T) {
+	for _, engine := range []string{persistence.EngineBolt, persistence.EngineSQLite} {
+		t.Run(engine, func(t *testing.T) {
+			testMaintenanceCompact(t, engine)
+		})
+	}
+
+	db := persistence.NewInMemoryThingsDB(maintenanceDeviceID, 0)
+	defer db.Close()
+	report, err := persistence.NewMaintenance(db).Compact()
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.CompactionReport{}, report)
+}
+
+func testMaintenanceCompact(t *testing.T, engine string) {
+	location := filepath.Join(t.TempDir(), "things.db")
+	db, err := persistence.NewThingsStorage(engine, location, maintenanceDeviceID)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.AddThing((&model.Thing{}).WithIDFrom(maintenanceThingID))
+	require.NoError(t, err)
+	value := strings.Repeat("x", 4096)
+	for i := 0; i < 200; i++ {
+		_, err := db.AddFeature(maintenanceThingID, fmt.Sprintf("feature%d", i),
+			(&model.Feature{}).WithProperty("value", value))
+		require.NoError(t, err)
+	}
+	for i := 1; i < 200; i++ {
+		require.NoError(t, db.RemoveFeature(maintenanceThingID, fmt.Sprintf("feature%d", i)))
+	}
+
+	report, err := persistence.NewMaintenance(db).Compact()
+	require.NoError(t, err)
+	assert.Less(t, report.SizeAfter, report.SizeBefore)
+	assert.NoFileExists(t, location+".compact")
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, report.SizeAfter, stats.FileSize)
+	assert.NotEmpty(t, stats.Compacted)
+
+	thing := &model.Thing{}
+	require.NoError(t, db.GetThing(maintenanceThingID, thing))
+	assert.Len(t, thing.Features, 1)
+	assert.Equal(t, value, thing.Features["feature0"].Properties["value"])
+}
+
+func TestReopen(t *testing.T) {
+	db := maintenanceDB(t)
+	defer removeMaintenanceDB(t, db)
+
+	require.NoError(t, db.Reopen())
+	assertThing(t, db, maintenanceThingID, true)
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Reopen())
+	assertThing(t, db, maintenanceThingID, true)
+}
+
+func maintenanceDB(t *testing.T) persistence.ThingsStorage {
+	db := assertDBDevice(t, maintenanceLocation, maintenanceDeviceID)
+	_, err := db.AddThing((&model.Thing{}).WithIDFrom(maintenanceThingID))
+	require.NoError(t, err)
+	return db
+}
+
+func removeMaintenanceDB(t *testing.T, db persistence.ThingsStorage) {
+	db.Close()
+	require.NoError(t, os.RemoveAll(maintenanceDir))
+}
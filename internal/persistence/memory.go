@@ -0,0 +1,435 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDatabaseFull is returned when a write would exceed the size limit of an in-memory database.
+var ErrDatabaseFull = errors.New("database size limit is reached")
+
+// memoryRecords are the key-value records of an in-memory database, the stored values are never modified in place,
+// so that they could be read outside of the lock.
+type memoryRecords struct {
+	lock    sync.RWMutex
+	values  map[string][]byte
+	size    int64
+	maxSize int64
+	closed  bool
+}
+
+// memoryTx collects the changes of a write operation or of a batch, applied to the records on commit.
+// A nil changed value is a removed record.
+type memoryTx struct {
+	records *memoryRecords
+	changes map[string][]byte
+}
+
+// memoryStorage is a Database keeping its records in memory only, e.g. for ephemeral gateways or tests.
+type memoryStorage struct {
+	records *memoryRecords
+
+	// tx is the transaction of a batch, all operations are applied within it if set.
+	tx *memoryTx
+
+	counters *writeCounters
+
+	// codec is the codec of the written values, CodecGob if empty.
+	codec string
+}
+
+// NewMemoryDatabase creates an empty in-memory database. If the max size is positive, the writes
+// are rejected with ErrDatabaseFull when the total size of the keys and encoded values would exceed it.
+func NewMemoryDatabase(maxSize int64) Database {
+	return &memoryStorage{
+		records:  &memoryRecords{values: make(map[string][]byte), maxSize: maxSize},
+		counters: &writeCounters{},
+	}
+}
+
+func (storage *memoryStorage) Close() error {
+	if storage.tx != nil {
+		return errBatchClose
+	}
+
+	storage.records.lock.Lock()
+	defer storage.records.lock.Unlock()
+
+	if storage.records.closed {
+		return ErrDatabaseClosed
+	}
+	storage.records.closed = true
+	return nil
+}
+
+func (storage *memoryStorage) setCodec(codec string) {
+	storage.codec = codec
+}
+
+// reopen opens the closed database again, its records are kept.
+func (storage *memoryStorage) reopen() {
+	storage.records.lock.Lock()
+	defer storage.records.lock.Unlock()
+
+	storage.records.closed = false
+}
+
+func (storage *memoryStorage) Batch(f func(db Database) error) error {
+	if storage.tx != nil {
+		return f(storage) // already within a batch
+	}
+
+	return storage.update(func(tx *memoryTx) error {
+		return f(&memoryStorage{
+			records:  storage.records,
+			tx:       tx,
+			counters: storage.counters,
+			codec:    storage.codec,
+		})
+	})
+}
+
+// view runs the function within the batch transaction, if any, otherwise with the records read locked.
+func (storage *memoryStorage) view(f func(tx *memoryTx) error) error {
+	if storage.tx != nil {
+		return f(storage.tx)
+	}
+
+	storage.records.lock.RLock()
+	defer storage.records.lock.RUnlock()
+
+	if storage.records.closed {
+		return ErrDatabaseClosed
+	}
+	return f(&memoryTx{records: storage.records})
+}
+
+// update runs the function within the batch transaction, if any, otherwise within a new transaction,
+// committed if the function returns no error.
+func (storage *memoryStorage) update(f func(tx *memoryTx) error) error {
+	if storage.tx != nil {
+		return f(storage.tx)
+	}
+
+	storage.records.lock.Lock()
+	defer storage.records.lock.Unlock()
+
+	if storage.records.closed {
+		return ErrDatabaseClosed
+	}
+	tx := &memoryTx{records: storage.records, changes: make(map[string][]byte)}
+	if err := f(tx); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&storage.counters.commits, 1)
+	return nil
+}
+
+func (tx *memoryTx) get(key string) []byte {
+	if value, ok := tx.changes[key]; ok {
+		return value
+	}
+	return tx.records.values[key]
+}
+
+func (tx *memoryTx) put(key string, value []byte) {
+	tx.changes[key] = append([]byte{}, value...)
+}
+
+func (tx *memoryTx) delete(key string) {
+	if _, ok := tx.records.values[key]; ok {
+		tx.changes[key] = nil
+	} else {
+		delete(tx.changes, key)
+	}
+}
+
+// keys returns the sorted keys with the prefix.
+func (tx *memoryTx) keys(prefix string) []string {
+	var keys []string
+	for key := range tx.records.values {
+		if _, changed := tx.changes[key]; !changed && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key, value := range tx.changes {
+		if value != nil && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// commit applies the changes to the records, unless the size limit would be exceeded.
+func (tx *memoryTx) commit() error {
+	size := tx.records.size
+	for key, value := range tx.changes {
+		if old, ok := tx.records.values[key]; ok {
+			size -= int64(len(key) + len(old))
+		}
+		if value != nil {
+			size += int64(len(key) + len(value))
+		}
+	}
+	if tx.records.maxSize > 0 && size > tx.records.maxSize && size > tx.records.size {
+		return ErrDatabaseFull
+	}
+
+	for key, value := range tx.changes {
+		if value == nil {
+			delete(tx.records.values, key)
+		} else {
+			tx.records.values[key] = value
+		}
+	}
+	tx.records.size = size
+	return nil
+}
+
+func (storage *memoryStorage) GetName() (string, error) {
+	name, err := storage.Get(systemKeyDbName)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+func (storage *memoryStorage) SetName(name string) error {
+	return storage.Set(systemKeyDbName, []byte(name))
+}
+
+func (storage *memoryStorage) Get(key string) ([]byte, error) {
+	var data []byte
+	if err := storage.view(func(tx *memoryTx) error {
+		data = tx.get(key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (storage *memoryStorage) GetAs(key string, value interface{}) error {
+	data, err := storage.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if vb, ok := value.([]byte); ok {
+		copy(vb, data)
+		return nil
+	}
+	return decodeAs(data, value)
+}
+
+func (storage *memoryStorage) GetAllAs(prefix string, value interface{}) ([]interface{}, error) {
+	var values []interface{}
+	if err := storage.ForEachAs(prefix, value, func(_ string, nextValue interface{}) (bool, error) {
+		values = append(values, nextValue)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (storage *memoryStorage) ForEachAs(
+	prefix string, value interface{}, f func(key string, value interface{}) (bool, error),
+) error {
+	valueType := reflect.ValueOf(value).Elem().Type()
+
+	var keys []string
+	var values [][]byte
+	if err := storage.view(func(tx *memoryTx) error {
+		keys = tx.keys(prefix)
+		values = make([][]byte, len(keys))
+		for i, key := range keys {
+			values[i] = tx.get(key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for i, data := range values {
+		nextValue := reflect.New(valueType).Interface()
+		if err := decodeAs(data, nextValue); err != nil {
+			return err
+		}
+		if proceed, err := f(keys[i], nextValue); err != nil || !proceed {
+			return err
+		}
+	}
+	return nil
+}
+
+func (storage *memoryStorage) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := storage.view(func(tx *memoryTx) error {
+		keys = tx.keys(prefix)
+		return nil
+	})
+	return keys, err
+}
+
+func (storage *memoryStorage) KeysAfter(prefix string, after string, limit int) ([]string, error) {
+	var keys []string
+	err := storage.view(func(tx *memoryTx) error {
+		all := tx.keys(prefix)
+		start := sort.SearchStrings(all, after)
+		if start < len(all) && all[start] == after {
+			start++
+		}
+		keys = all[start:]
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (storage *memoryStorage) Set(key string, value []byte) error {
+	return storage.update(func(tx *memoryTx) error {
+		tx.put(key, value)
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(value)))
+		atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(value)))
+		return nil
+	})
+}
+
+func (storage *memoryStorage) SetAs(key string, value interface{}) error {
+	return storage.update(func(tx *memoryTx) error {
+		return storage.put(tx, key, value)
+	})
+}
+
+func (storage *memoryStorage) SetAllAs(values map[string]interface{}) error {
+	return storage.update(func(tx *memoryTx) error {
+		for key, value := range values {
+			if err := storage.put(tx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *memoryStorage) UpdateAllAs(prefix string, values map[string]interface{}) error {
+	return storage.update(func(tx *memoryTx) error {
+		for _, key := range tx.keys(prefix) {
+			tx.delete(key)
+		}
+		for key, value := range values {
+			if err := storage.put(tx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// put encodes and puts the value, counting its payload and encoded sizes.
+func (storage *memoryStorage) put(tx *memoryTx, key string, value interface{}) error {
+	valueBytes, err := encodeAs(storage.codec, value)
+	if err != nil {
+		return err
+	}
+	tx.put(key, valueBytes)
+
+	if payload, err := json.Marshal(value); err == nil {
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(payload)))
+	}
+	atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(valueBytes)))
+	return nil
+}
+
+func (storage *memoryStorage) Delete(key string) error {
+	return storage.update(func(tx *memoryTx) error {
+		tx.delete(key)
+		return nil
+	})
+}
+
+func (storage *memoryStorage) DeleteAll(prefix string) error {
+	return storage.update(func(tx *memoryTx) error {
+		for _, key := range tx.keys(prefix) {
+			tx.delete(key)
+		}
+		return nil
+	})
+}
+
+// Snapshot writes the records as a bbolt database file, built in a temporary file,
+// so that the snapshots of the in-memory and of the bbolt databases are interchangeable.
+func (storage *memoryStorage) Snapshot(w io.Writer) error {
+	records := make(map[string][]byte)
+	if err := storage.view(func(tx *memoryTx) error {
+		for _, key := range tx.keys("") {
+			records[key] = tx.get(key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDatabase(filepath.Join(dir, "things.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Batch(func(db Database) error {
+		for key, value := range records {
+			if err := db.Set(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return db.Snapshot(w)
+}
+
+// WriteStats returns the written data sizes, no pages are written as the records are kept in memory only.
+func (storage *memoryStorage) WriteStats() WriteStats {
+	return WriteStats{
+		PayloadBytes: atomic.LoadUint64(&storage.counters.payloadBytes),
+		EncodedBytes: atomic.LoadUint64(&storage.counters.encodedBytes),
+	}
+}
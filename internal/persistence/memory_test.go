@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const memoryDeviceID = "org.eclipse.kanto:TestMemory"
+
+func TestInMemoryThingsDBMaxSize(t *testing.T) {
+	db := persistence.NewInMemoryThingsDB(memoryDeviceID, 8192)
+	defer db.Close()
+	assert.Equal(t, memoryDeviceID, db.GetDeviceID())
+
+	_, err := db.AddThing(createThing("org.eclipse.kanto:small"))
+	require.NoError(t, err)
+
+	large := createThing("org.eclipse.kanto:large").
+		WithAttribute("data", string(make([]byte, 8192)))
+	_, err = db.AddThing(large)
+	assert.ErrorIs(t, err, persistence.ErrDatabaseFull)
+
+	ids, err := db.GetThingIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org.eclipse.kanto:small"}, ids)
+
+	// the removals are allowed even if the limit is exceeded
+	require.NoError(t, db.RemoveThing("org.eclipse.kanto:small"))
+	ids, err = db.GetThingIDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestInMemoryThingsDBMaintenance(t *testing.T) {
+	db := persistence.NewInMemoryThingsDB(memoryDeviceID, 0)
+	defer db.Close()
+
+	_, err := db.AddThing(createThing(maintenanceThingID))
+	require.NoError(t, err)
+
+	maintenance := persistence.NewMaintenance(db)
+	require.NoError(t, maintenance.Run(func() error {
+		_, err := db.GetThingIDs()
+		assert.ErrorIs(t, err, persistence.ErrDatabaseClosed)
+		return nil
+	}))
+
+	thing := &model.Thing{}
+	require.NoError(t, db.GetThing(maintenanceThingID, thing))
+	assert.Equal(t, maintenanceThingID, thing.ID.String())
+}
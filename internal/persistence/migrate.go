@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	migrationBatchSize   = 1024
+	migrationOpenTimeout = 5 * time.Second
+)
+
+// MigrationReport contains the data used to verify a things database migration.
+type MigrationReport struct {
+	// Records is the number of the database records.
+	Records int
+	// Hash is the hex encoded SHA-256 hash of all database records keys and values, in keys order.
+	Hash string
+}
+
+// Migrate copies all records of the things database located on the source path into a new database
+// located on the target path. The source database is opened read-only and stays untouched.
+// The target database is verified to contain the same records, i.e. the same records count and hash.
+// Returns error if the target file already exists or the source database is opened by another process
+// for more than a few seconds, e.g. by the running local digital twins service.
+func Migrate(source, target string) (*MigrationReport, error) {
+	if _, err := os.Stat(target); err == nil {
+		return nil, errors.Errorf("target things db '%s' already exists", target)
+	}
+
+	src, err := bbolt.Open(source, 0600, &bbolt.Options{ReadOnly: true, Timeout: migrationOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open source things db '%s'", source)
+	}
+	defer src.Close()
+
+	dst, err := bbolt.Open(target, 0600, &bbolt.Options{Timeout: migrationOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create target things db '%s'", target)
+	}
+	defer dst.Close()
+
+	if err := copyRecords(src, dst); err != nil {
+		return nil, errors.Wrapf(err, "cannot copy records to target things db '%s'", target)
+	}
+
+	expected, err := checksum(src)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := checksum(dst)
+	if err != nil {
+		return nil, err
+	}
+	if *expected != *actual {
+		return nil, errors.Errorf("target things db verification failed: %d records with hash %s, expected %d with %s",
+			actual.Records, actual.Hash, expected.Records, expected.Hash)
+	}
+	return actual, nil
+}
+
+// Checksum returns the records count and hash of the things database located on the provided path.
+// The database is opened read-only.
+func Checksum(path string) (*MigrationReport, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: migrationOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open things db '%s'", path)
+	}
+	defer db.Close()
+
+	return checksum(db)
+}
+
+// copyRecords copies the things bucket records in batches, each written within its own transaction.
+func copyRecords(src, dst *bbolt.DB) error {
+	if err := dst.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bboltBucket)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return src.View(func(srcTx *bbolt.Tx) error {
+		bucket := srcTx.Bucket(bboltBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		it := bucket.Cursor()
+		k, v := it.First()
+		for k != nil {
+			if err := dst.Update(func(dstTx *bbolt.Tx) error {
+				dstBucket := dstTx.Bucket(bboltBucket)
+				for i := 0; k != nil && i < migrationBatchSize; i++ {
+					if err := dstBucket.Put(append([]byte{}, k...), append([]byte{}, v...)); err != nil {
+						return err
+					}
+					k, v = it.Next()
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func checksum(db *bbolt.DB) (*MigrationReport, error) {
+	report := &MigrationReport{}
+	hash := sha256.New()
+	if err := db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bboltBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			report.Records++
+			// length prefixed, so that the key and value boundaries are part of the hash
+			for _, data := range [][]byte{k, v} {
+				hash.Write([]byte{byte(len(data) >> 24), byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))})
+				hash.Write(data)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, errors.Wrap(err, "cannot compute things db checksum")
+	}
+	report.Hash = hex.EncodeToString(hash.Sum(nil))
+	return report, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	migrateDir      = "migrate"
+	migrateSource   = migrateDir + "/things.db"
+	migrateTarget   = migrateDir + "/things-migrated.db"
+	migrateDeviceID = "org.eclipse.kanto:TestMigrate"
+)
+
+func TestMigrate(t *testing.T) {
+	defer os.RemoveAll(migrateDir)
+
+	db := assertDBDevice(t, migrateSource, migrateDeviceID)
+	for _, id := range []string{"org.eclipse.kanto:thing1", "org.eclipse.kanto:thing2"} {
+		_, err := db.AddThing((&model.Thing{}).WithIDFrom(id))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	source, err := persistence.Checksum(migrateSource)
+	require.NoError(t, err)
+
+	report, err := persistence.Migrate(migrateSource, migrateTarget)
+	require.NoError(t, err)
+	assert.Equal(t, source, report)
+	assert.True(t, report.Records > 2)
+
+	target, err := persistence.Checksum(migrateTarget)
+	require.NoError(t, err)
+	assert.Equal(t, source, target)
+
+	migrated := assertDBDevice(t, migrateTarget, migrateDeviceID)
+	defer migrated.Close()
+	assertThing(t, migrated, "org.eclipse.kanto:thing1", true)
+	assertThing(t, migrated, "org.eclipse.kanto:thing2", true)
+
+	unchanged, err := persistence.Checksum(migrateSource)
+	require.NoError(t, err)
+	assert.Equal(t, source, unchanged)
+}
+
+func TestMigrateTargetExists(t *testing.T) {
+	defer os.RemoveAll(migrateDir)
+
+	db := assertDBDevice(t, migrateSource, migrateDeviceID)
+	require.NoError(t, db.Close())
+
+	_, err := persistence.Migrate(migrateSource, migrateSource)
+	assert.Error(t, err)
+
+	_, err = persistence.Migrate(migrateDir+"/missing.db", migrateTarget)
+	assert.Error(t, err)
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// partitionKeyPrefix is the database key prefix of the records of the partitioned devices.
+	partitionKeyPrefix = "@PARTITION/"
+	// systemKeyPartitions is the key of the sorted IDs of the partitioned devices.
+	systemKeyPartitions = "@SYSTEM/PARTITIONS"
+)
+
+// Partitions hosts the things storages of multiple gateway devices in a single database file,
+// so that the twins of each gateway identity the database file is used with are kept without destroying
+// the ones of the others. The twins service serves a single gateway identity at a time, opening its partition
+// if the things db partitioning is configured, see StorageOptions.Partitioned, while the tools could access
+// the storages of all devices at once.
+//
+// The device the database file is initialized for keeps its records unpartitioned, as if the partitioning
+// is disabled, the records of each other device are kept in its own partition, i.e. under its own key namespace.
+// The database file is shared by all devices, i.e. its snapshots, backups and maintenance include all partitions.
+type Partitions struct {
+	engine string
+	path   string
+	codec  string
+	db     Database
+}
+
+// OpenPartitions opens the things database file shared by the storages of multiple devices with the provided
+// options. The codec applies to the values written by the storages of all devices, the partitioning is implied.
+// Returns error if the storage engine or the codec is unknown.
+func OpenPartitions(path string, options StorageOptions) (*Partitions, error) {
+	if err := ValidateCodec(options.Codec); err != nil {
+		return nil, err
+	}
+
+	engine := options.Engine
+	var database Database
+	if engine == EngineMemory {
+		database = NewMemoryDatabase(0)
+	} else {
+		dir := filepath.Dir(path)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, 0711); err != nil {
+				return nil, errors.Wrapf(err, "error creating directory for partitioned storage on location '%s'", path)
+			}
+		}
+
+		var err error
+		if database, err = openDatabase(engine, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if db, ok := database.(encoded); ok {
+		db.setCodec(options.Codec)
+	}
+	return &Partitions{engine: engine, path: path, codec: options.Codec, db: database}, nil
+}
+
+// Storage returns the things storage of the device, creating its partition if not available yet.
+// Closing the returned storage does not close the shared database file.
+func (p *Partitions) Storage(deviceID string) (ThingsStorage, error) {
+	db, err := partitionOf(p.db, deviceID, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initializing device '%s' storage on location '%s'", deviceID, p.path)
+	}
+	return &thingsDB{
+		deviceID:   deviceID,
+		path:       p.path,
+		engine:     p.engine,
+		codec:      p.codec,
+		db:         db,
+		partitions: p,
+		watchers:   newWatchers(),
+	}, nil
+}
+
+// DeviceIDs returns the sorted IDs of the devices which data is stored into the database file.
+func (p *Partitions) DeviceIDs() ([]string, error) {
+	name, err := p.db.GetName()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	ids := []string{}
+	if err := p.db.GetAs(systemKeyPartitions, &ids); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if len(name) > 0 {
+		ids = append(ids, name)
+		sort.Strings(ids)
+	}
+	return ids, nil
+}
+
+// Close closes the shared database file, the storages of all devices are closed with it.
+func (p *Partitions) Close() error {
+	return p.db.Close()
+}
+
+// partitionOf returns the database of the device records, creating its partition if not available yet.
+// If owned, the provided database is closed on closing the returned one.
+func partitionOf(db Database, deviceID string, owned bool) (Database, error) {
+	name, err := db.GetName()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if len(name) == 0 {
+		if err := db.SetName(deviceID); err != nil {
+			return nil, err
+		}
+		name = deviceID
+	}
+	if name == deviceID {
+		return &partitionDB{db: db, owned: owned}, nil
+	}
+
+	partition := &partitionDB{db: db, prefix: partitionKeyPrefix + deviceID + "/", owned: owned}
+	if err := db.Batch(func(tx Database) error {
+		ids := []string{}
+		if err := tx.GetAs(systemKeyPartitions, &ids); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if i := sort.SearchStrings(ids, deviceID); i < len(ids) && ids[i] == deviceID {
+			return nil
+		}
+		ids = append(ids, deviceID)
+		sort.Strings(ids)
+		if err := tx.SetAs(systemKeyPartitions, ids); err != nil {
+			return err
+		}
+		return (&partitionDB{db: tx, prefix: partition.prefix}).SetName(deviceID)
+	}); err != nil {
+		return nil, err
+	}
+	return partition, nil
+}
+
+// partitionDB is the database of a single device records, i.e. of the keys with the partition prefix.
+type partitionDB struct {
+	db     Database
+	prefix string
+
+	// owned is true if the underlying database is closed on closing the partition.
+	owned  bool
+	closed bool
+}
+
+func (p *partitionDB) opened() error {
+	if p.closed {
+		return ErrDatabaseClosed
+	}
+	return nil
+}
+
+func (p *partitionDB) GetName() (string, error) {
+	name, err := p.Get(systemKeyDbName)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+func (p *partitionDB) SetName(name string) error {
+	return p.Set(systemKeyDbName, []byte(name))
+}
+
+func (p *partitionDB) Get(key string) ([]byte, error) {
+	if err := p.opened(); err != nil {
+		return nil, err
+	}
+	return p.db.Get(p.prefix + key)
+}
+
+func (p *partitionDB) GetAs(key string, value interface{}) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.GetAs(p.prefix+key, value)
+}
+
+func (p *partitionDB) GetAllAs(keyPrefix string, valuesType interface{}) ([]interface{}, error) {
+	if err := p.opened(); err != nil {
+		return nil, err
+	}
+	return p.db.GetAllAs(p.prefix+keyPrefix, valuesType)
+}
+
+func (p *partitionDB) ForEachAs(
+	keyPrefix string, valuesType interface{}, f func(key string, value interface{}) (bool, error),
+) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.ForEachAs(p.prefix+keyPrefix, valuesType, func(key string, value interface{}) (bool, error) {
+		return f(key[len(p.prefix):], value)
+	})
+}
+
+func (p *partitionDB) Keys(keyPrefix string) ([]string, error) {
+	if err := p.opened(); err != nil {
+		return nil, err
+	}
+	keys, err := p.db.Keys(p.prefix + keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = key[len(p.prefix):]
+	}
+	return keys, nil
+}
+
+func (p *partitionDB) KeysAfter(keyPrefix string, after string, limit int) ([]string, error) {
+	if err := p.opened(); err != nil {
+		return nil, err
+	}
+	if len(after) > 0 {
+		after = p.prefix + after
+	}
+	keys, err := p.db.KeysAfter(p.prefix+keyPrefix, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = key[len(p.prefix):]
+	}
+	return keys, nil
+}
+
+func (p *partitionDB) Set(key string, data []byte) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.Set(p.prefix+key, data)
+}
+
+func (p *partitionDB) SetAs(key string, value interface{}) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.SetAs(p.prefix+key, value)
+}
+
+func (p *partitionDB) SetAllAs(data map[string]interface{}) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.SetAllAs(p.prefixed(data))
+}
+
+func (p *partitionDB) UpdateAllAs(keyPrefix string, data map[string]interface{}) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.UpdateAllAs(p.prefix+keyPrefix, p.prefixed(data))
+}
+
+func (p *partitionDB) prefixed(data map[string]interface{}) map[string]interface{} {
+	if len(p.prefix) == 0 {
+		return data
+	}
+	values := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		values[p.prefix+key] = value
+	}
+	return values
+}
+
+func (p *partitionDB) Delete(key string) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.Delete(p.prefix + key)
+}
+
+func (p *partitionDB) DeleteAll(keyPrefix string) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.DeleteAll(p.prefix + keyPrefix)
+}
+
+func (p *partitionDB) Batch(f func(db Database) error) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.Batch(func(db Database) error {
+		return f(&partitionDB{db: db, prefix: p.prefix})
+	})
+}
+
+func (p *partitionDB) WriteStats() WriteStats {
+	return p.db.WriteStats()
+}
+
+func (p *partitionDB) Snapshot(w io.Writer) error {
+	if err := p.opened(); err != nil {
+		return err
+	}
+	return p.db.Snapshot(w)
+}
+
+// setDurability applies the durability policy to the underlying database, if owned,
+// otherwise it is up to its owner.
+func (p *partitionDB) setDurability(durability Durability) error {
+	if db, ok := p.db.(durable); ok && p.owned {
+		return db.setDurability(durability)
+	}
+	return nil
+}
+
+// setCodec sets the codec of the underlying database, if owned, otherwise it is up to its owner,
+// e.g. the shared database file of the partitions is opened with the codec of all devices.
+func (p *partitionDB) setCodec(codec string) {
+	if db, ok := p.db.(encoded); ok && p.owned {
+		db.setCodec(codec)
+	}
+}
+
+func (p *partitionDB) Close() error {
+	if p.owned {
+		return p.db.Close()
+	}
+	if p.closed {
+		return ErrDatabaseClosed
+	}
+	p.closed = true
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// ErrPolicyNotFound indicates that a policy with such ID does not exist.
+var ErrPolicyNotFound = errors.Wrap(ErrNotFound, "policy could not be found")
+
+func (storage *thingsDB) AddPolicy(policyID string, policy []byte) (int64, error) {
+	if len(policyID) == 0 {
+		return -1, errors.New("policy ID is mandatory on adding policy")
+	}
+
+	revision := int64(-1)
+	err := storage.update(func(tx *thingsDB) error {
+		timestamp, _ := systemClock.Now()
+		policyData, err := tx.GetPolicy(policyID)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			policyData = &data.PolicyData{ID: policyID, Created: timestamp.Format(time.RFC3339)}
+		}
+
+		policyData.Policy = policy
+		policyData.Revision = policyData.Revision + 1
+		policyData.Modified = timestamp.Format(time.RFC3339)
+		revision = policyData.Revision
+		return tx.db.SetAs(policyData.Key(), policyData)
+	})
+	if err != nil {
+		return -1, errors.Wrapf(err, "policy with ID '%s' could not be stored", policyID)
+	}
+	return revision, nil
+}
+
+func (storage *thingsDB) GetPolicy(policyID string) (*data.PolicyData, error) {
+	policyData := &data.PolicyData{}
+	if err := storage.db.GetAs(data.PolicyKey(policyID), policyData); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrPolicyNotFound
+		}
+		return nil, err
+	}
+	return policyData, nil
+}
+
+func (storage *thingsDB) GetPolicyIDs() ([]string, error) {
+	keys, err := storage.db.Keys(data.PolicyKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = strings.TrimPrefix(key, data.PolicyKeyPrefix)
+	}
+	return ids, nil
+}
+
+func (storage *thingsDB) RemovePolicy(policyID string) error {
+	err := storage.update(func(tx *thingsDB) error {
+		if _, err := tx.GetPolicy(policyID); err != nil {
+			return err
+		}
+		return tx.db.Delete(data.PolicyKey(policyID))
+	})
+	return errors.Wrapf(err, "policy with ID '%s' could not be deleted", policyID)
+}
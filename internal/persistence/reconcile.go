@@ -0,0 +1,225 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// Reconciliation modes of the things IDs index on startup.
+const (
+	// ReconcileOff skips the reconciliation.
+	ReconcileOff = "off"
+	// ReconcileVerify verifies the index against the stored things system data keys
+	// and rebuilds it only if they differ, it is the default mode.
+	ReconcileVerify = "verify"
+	// ReconcileRebuild always rebuilds the index by scanning all database keys.
+	ReconcileRebuild = "rebuild"
+)
+
+// systemKeysPrefix is the database key prefix of the system records, which are not things data.
+const systemKeysPrefix = "@"
+
+// ReconcileReport contains the changes of the things IDs index reconciliation.
+type ReconcileReport struct {
+	// Things is the number of the stored things.
+	Things int `json:"things"`
+	// Rebuilt is true if the index is rebuilt, false if it is verified to be consistent.
+	Rebuilt bool `json:"rebuilt"`
+	// Added contains the IDs of the stored things missing from the index.
+	Added []string `json:"added,omitempty"`
+	// Removed contains the IDs of the index without stored things.
+	Removed []string `json:"removed,omitempty"`
+	// Restored contains the IDs of the stored things, which missing system data is restored
+	// with all of their features unsynchronized.
+	Restored []string `json:"restored,omitempty"`
+	// Orphans contains the IDs of the removed system data and features, which things data is missing.
+	Orphans []string `json:"orphans,omitempty"`
+}
+
+// Repaired returns true if any inconsistency is repaired.
+func (report *ReconcileReport) Repaired() bool {
+	return len(report.Added) > 0 || len(report.Removed) > 0 || len(report.Restored) > 0 || len(report.Orphans) > 0
+}
+
+// Reconcile rebuilds the things IDs index of the closed database file of the provided storage engine,
+// e.g. while the service is stopped. There is nothing to be reconciled for the in-memory storage.
+func Reconcile(engine, path string) (*ReconcileReport, error) {
+	if engine == EngineMemory {
+		return &ReconcileReport{}, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	database, err := openDatabase(engine, path)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	deviceID, _ := database.GetName()
+	storage := &thingsDB{deviceID: deviceID, path: path, engine: engine, db: database}
+	return storage.ReconcileThingIDs(true)
+}
+
+func (storage *thingsDB) ReconcileThingIDs(rebuild bool) (*ReconcileReport, error) {
+	if !rebuild {
+		count, consistent, err := storage.verifyThingIDs()
+		if err != nil {
+			return nil, errors.Wrap(err, "things IDs index could not be verified")
+		}
+		if consistent {
+			return &ReconcileReport{Things: count}, nil
+		}
+	}
+
+	report := &ReconcileReport{}
+	err := storage.update(func(tx *thingsDB) error {
+		*report = ReconcileReport{Rebuilt: true}
+		return tx.rebuildThingIDs(report)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "things IDs index could not be rebuilt")
+	}
+	return report, nil
+}
+
+// verifyThingIDs compares the things IDs index with the things system data keys, without decoding them.
+// Returns the number of the stored things and whether the index is consistent.
+func (storage *thingsDB) verifyThingIDs() (int, bool, error) {
+	indexed := make(map[string]interface{})
+	if err := storage.db.GetAs(data.IDSeparator, &indexed); err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, false, err
+	}
+
+	count := 0
+	after := data.IDSeparator
+	for {
+		keys, err := storage.db.KeysAfter(data.IDSeparator, after, countBatchSize)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, key := range keys {
+			if _, ok := indexed[strings.TrimPrefix(key, data.IDSeparator)]; !ok {
+				return 0, false, nil
+			}
+		}
+		count += len(keys)
+		if len(keys) < countBatchSize {
+			return count, count == len(indexed), nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// rebuildThingIDs scans all database keys and rebuilds the things IDs index from the things data keys.
+// The missing things system data is restored and the system data and the features of missing things are removed.
+// The watchers are not notified, as the stored things are not modified.
+func (storage *thingsDB) rebuildThingIDs(report *ReconcileReport) error {
+	things := make(map[string]bool)
+	system := make(map[string]bool)
+	orphans := make(map[string]bool)
+
+	after := ""
+	for {
+		keys, err := storage.db.KeysAfter("", after, countBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			switch {
+			case key == data.IDSeparator, strings.HasPrefix(key, systemKeysPrefix):
+			case strings.HasPrefix(key, data.IDSeparator):
+				system[strings.TrimPrefix(key, data.IDSeparator)] = true
+			case strings.Contains(key, data.IDSeparator):
+				orphans[key[:strings.Index(key, data.IDSeparator)]] = true
+			default:
+				things[key] = true
+			}
+		}
+		if len(keys) < countBatchSize {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	for thingID := range system {
+		orphans[thingID] = true
+	}
+
+	for thingID := range things {
+		delete(orphans, thingID)
+		if !system[thingID] {
+			if err := storage.restoreSystemThingData(thingID); err != nil {
+				return err
+			}
+			report.Restored = append(report.Restored, thingID)
+		}
+	}
+	for thingID := range orphans {
+		if err := storage.removeThingData(thingID); err != nil {
+			return err
+		}
+		report.Orphans = append(report.Orphans, thingID)
+	}
+
+	indexed := make(map[string]interface{})
+	if err := storage.db.GetAs(data.IDSeparator, &indexed); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	ids := make(map[string]interface{}, len(things))
+	for thingID := range things {
+		ids[thingID] = nil
+		if _, ok := indexed[thingID]; !ok {
+			report.Added = append(report.Added, thingID)
+		}
+	}
+	for thingID := range indexed {
+		if !things[thingID] {
+			report.Removed = append(report.Removed, thingID)
+		}
+	}
+
+	report.Things = len(things)
+	for _, list := range [][]string{report.Added, report.Removed, report.Restored, report.Orphans} {
+		sort.Strings(list)
+	}
+	return storage.db.SetAs(data.IDSeparator, ids)
+}
+
+// restoreSystemThingData stores a new system data of the thing with all of its features unsynchronized,
+// so that they are synchronized with the hub.
+func (storage *thingsDB) restoreSystemThingData(thingID string) error {
+	featureIDs, err := storage.featureIDs(thingID)
+	if err != nil {
+		return err
+	}
+
+	systemThingData := &data.SystemThingData{
+		ID:                     thingID,
+		DeletedFeatures:        make(map[string]interface{}),
+		UnsynchronizedFeatures: make(map[string]int64),
+	}
+	updateSystemThingData(systemThingData)
+	systemThingData.Created = systemThingData.Timestamp
+	for _, featureID := range featureIDs {
+		systemThingData.UnsynchronizedFeatures[featureID] = systemThingData.Revision
+	}
+	return storage.db.SetAs(systemThingData.Key(), systemThingData.Data())
+}
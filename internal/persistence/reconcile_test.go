@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence_test
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileThingIDs(t *testing.T) {
+	const (
+		thingA = "reconcile:a"
+		thingB = "reconcile:b"
+	)
+	location := filepath.Join(t.TempDir(), "things.db")
+
+	storage, err := persistence.NewThingsDB(location, testThingID)
+	require.NoError(t, err)
+	for _, thingID := range []string{thingA, thingB} {
+		_, err := storage.AddThing(createThing(thingID))
+		require.NoError(t, err)
+	}
+
+	report, err := storage.ReconcileThingIDs(false)
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.ReconcileReport{Things: 2}, report)
+	require.NoError(t, storage.Close())
+
+	// the index and the system data drift, e.g. as written by a previous version
+	db, err := persistence.NewDatabase(location)
+	require.NoError(t, err)
+	require.NoError(t, db.SetAs(data.IDSeparator, map[string]interface{}{thingA: nil, "reconcile:ghost": nil}))
+	require.NoError(t, db.Delete(data.SystemThingKey(thingA)))
+	require.NoError(t, db.SetAs(data.FeatureKey("reconcile:orphan", "meter"), &data.FeatureData{ID: "meter"}))
+	require.NoError(t, db.SetAs(data.SystemThingKey("reconcile:system"), &data.SystemThingData{ID: "reconcile:system"}))
+	require.NoError(t, db.Close())
+
+	storage, err = persistence.NewThingsDB(location, testThingID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	report, err = storage.ReconcileThingIDs(false)
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.ReconcileReport{
+		Things:   2,
+		Rebuilt:  true,
+		Added:    []string{thingB},
+		Removed:  []string{"reconcile:ghost"},
+		Restored: []string{thingA},
+		Orphans:  []string{"reconcile:orphan", "reconcile:system"},
+	}, report)
+	assert.True(t, report.Repaired())
+
+	ids, err := storage.GetThingIDs()
+	require.NoError(t, err)
+	sort.Strings(ids)
+	assert.Equal(t, []string{thingA, thingB}, ids)
+	count, err := storage.CountThings()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// the features of the thing with restored system data are synchronized
+	system, err := storage.GetSystemThingData(thingA)
+	require.NoError(t, err)
+	assert.Contains(t, system.UnsynchronizedFeatures, testFeatureID1)
+	assert.Contains(t, system.UnsynchronizedFeatures, testFeatureID2)
+	thing := &model.Thing{}
+	require.NoError(t, storage.GetThing(thingA, thing))
+	assert.Len(t, thing.Features, 2)
+	assert.ErrorIs(t, storage.GetThing("reconcile:system", &model.Thing{}), persistence.ErrThingNotFound)
+
+	report, err = storage.ReconcileThingIDs(false)
+	require.NoError(t, err)
+	assert.False(t, report.Rebuilt)
+	assert.False(t, report.Repaired())
+
+	report, err = storage.ReconcileThingIDs(true)
+	require.NoError(t, err)
+	assert.Equal(t, &persistence.ReconcileReport{Things: 2, Rebuilt: true}, report)
+}
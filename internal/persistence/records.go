@@ -0,0 +1,194 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// records are the raw key-value records of a database transaction.
+type records interface {
+	// get returns the value of the key, nil if it does not exist. The value is valid within the transaction only.
+	get(key []byte) []byte
+	// put sets the value of the key.
+	put(key []byte, value []byte) error
+	// delete removes the key and its value.
+	delete(key []byte) error
+	// seek passes the records starting at the provided key to the function in the keys order,
+	// while it returns true. The passed keys and values are valid within the function call only.
+	seek(start []byte, f func(key []byte, value []byte) bool)
+}
+
+// bucketRecords are the records of a bbolt bucket.
+type bucketRecords struct {
+	bucket *bbolt.Bucket
+}
+
+func (r bucketRecords) get(key []byte) []byte {
+	return r.bucket.Get(key)
+}
+
+func (r bucketRecords) put(key []byte, value []byte) error {
+	return r.bucket.Put(key, value)
+}
+
+func (r bucketRecords) delete(key []byte) error {
+	return r.bucket.Delete(key)
+}
+
+func (r bucketRecords) seek(start []byte, f func(key []byte, value []byte) bool) {
+	it := r.bucket.Cursor()
+	for k, v := it.Seek(start); k != nil && f(k, v); k, v = it.Next() {
+	}
+}
+
+// writes are the written values by key, which are not applied to the underlying records yet.
+// The value of a deleted key is nil, the value of a put one is never nil.
+type writes map[string][]byte
+
+// seek passes the records starting at the provided key to the function in the keys order, as the writes are applied
+// to the underlying records.
+func (w writes) seek(base records, start []byte, f func(key []byte, value []byte) bool) {
+	keys := make([]string, 0, len(w))
+	for key := range w {
+		if key >= string(start) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// passes the written keys up to the provided one, returns false if the iteration is stopped
+	written := func(until []byte) bool {
+		for ; len(keys) > 0 && (until == nil || keys[0] < string(until)); keys = keys[1:] {
+			if value := w[keys[0]]; value != nil && !f([]byte(keys[0]), value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	proceed := true
+	base.seek(start, func(key []byte, value []byte) bool {
+		if proceed = written(key); !proceed {
+			return false
+		}
+		if len(keys) > 0 && keys[0] == string(key) {
+			value = w[keys[0]]
+			keys = keys[1:]
+			if value == nil {
+				return true // deleted
+			}
+		}
+		proceed = f(key, value)
+		return proceed
+	})
+	if proceed {
+		written(nil)
+	}
+}
+
+// overlay are the records of a write transaction, which writes are kept aside of the underlying records.
+type overlay struct {
+	writes writes
+	base   records
+}
+
+func (r overlay) get(key []byte) []byte {
+	if value, ok := r.writes[string(key)]; ok {
+		return value
+	}
+	return r.base.get(key)
+}
+
+func (r overlay) put(key []byte, value []byte) error {
+	r.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (r overlay) delete(key []byte) error {
+	r.writes[string(key)] = nil
+	return nil
+}
+
+func (r overlay) seek(start []byte, f func(key []byte, value []byte) bool) {
+	r.writes.seek(r.base, start, f)
+}
+
+// pendingWrites are the writes of the committed transactions, which are not written to the database yet,
+// so that they are written at once within a single synced transaction.
+type pendingWrites struct {
+	// writer serializes the write transactions and the writing of the pending writes to the database.
+	writer sync.Mutex
+
+	mutex  sync.RWMutex
+	writes writes
+}
+
+// add adds the writes of a committed transaction.
+func (p *pendingWrites) add(w writes) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, value := range w {
+		p.writes[key] = value
+	}
+}
+
+// written resets the pending writes, once they are written to the database.
+func (p *pendingWrites) written() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.writes = writes{}
+}
+
+// pendingRecords are the records of a read transaction, which the pending writes are applied to.
+type pendingRecords struct {
+	pending *pendingWrites
+	base    records
+}
+
+func (r pendingRecords) get(key []byte) []byte {
+	r.pending.mutex.RLock()
+	value, ok := r.pending.writes[string(key)]
+	r.pending.mutex.RUnlock()
+
+	if ok {
+		return value
+	}
+	return r.base.get(key)
+}
+
+func (r pendingRecords) put(key []byte, value []byte) error {
+	return bbolt.ErrTxNotWritable
+}
+
+func (r pendingRecords) delete(key []byte) error {
+	return bbolt.ErrTxNotWritable
+}
+
+func (r pendingRecords) seek(start []byte, f func(key []byte, value []byte) bool) {
+	r.pending.mutex.RLock()
+	w := make(writes)
+	for key, value := range r.pending.writes {
+		if key >= string(start) {
+			w[key] = value
+		}
+	}
+	r.pending.mutex.RUnlock()
+
+	w.seek(r.base, start, f)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// corruptedSuffix is the infix of the corrupted database files moved aside on opening.
+const corruptedSuffix = "corrupted"
+
+// sqliteSideFiles are the suffixes of the SQLite files moved aside together with the database file.
+var sqliteSideFiles = []string{"-wal", "-shm"}
+
+// corrupted returns true if the database open or read error is caused by a corrupted database file.
+func corrupted(err error) bool {
+	if errors.Is(err, bbolt.ErrInvalid) || errors.Is(err, bbolt.ErrChecksum) || errors.Is(err, bbolt.ErrVersionMismatch) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+	}
+	return false
+}
+
+// recoverCorrupted moves the corrupted database file aside, as on a device change,
+// and opens a clean database in its place, recording the path the corrupted file is moved to.
+func recoverCorrupted(path, deviceID string, options StorageOptions, cause error) (*thingsDB, error) {
+	moved := rotatedBackupPath(path, corruptedSuffix)
+	if err := os.Rename(path, moved); err != nil {
+		return nil, errors.Wrapf(cause, "error moving aside the corrupted device '%s' storage on location '%s'",
+			deviceID, path)
+	}
+	if options.Engine == EngineSQLite {
+		for _, suffix := range sqliteSideFiles {
+			if err := os.Rename(path+suffix, moved+suffix); err != nil && !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "error moving aside the corrupted device '%s' storage on location '%s'",
+					deviceID, path)
+			}
+		}
+	}
+
+	storage, err := openThingsStorage(path, deviceID, options)
+	if err != nil {
+		return nil, err
+	}
+	storage.recovered = moved
+	return storage, nil
+}
+
+func (storage *thingsDB) Recovered() string {
+	return storage.recovered
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+func (storage *thingsDB) QueueReplayEvent(entry *data.JournalEntry, maxEvents int) (int, error) {
+	if len(entry.Timestamp) == 0 {
+		entry.Timestamp = time.Now().UTC().Format(data.JournalTimestampFormat)
+	}
+
+	dropped := 0
+	err := storage.update(func(tx *thingsDB) error {
+		dropped = 0
+		queue, err := tx.replayQueue()
+		if err != nil {
+			return err
+		}
+
+		values := map[string]interface{}{data.ReplayKey(queue.Next): entry}
+		queue.Next = queue.Next + 1
+		for maxEvents > 0 && queue.Next-queue.First > int64(maxEvents) {
+			if err := tx.db.Delete(data.ReplayKey(queue.First)); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			queue.First = queue.First + 1
+			dropped++
+		}
+		values[systemKeyReplay] = queue
+		return tx.db.SetAllAs(values)
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "event of the thing with ID '%s' could not be queued for replay", entry.ThingID)
+	}
+	return dropped, nil
+}
+
+func (storage *thingsDB) ForEachReplayEvent(f func(sequence int64, entry *data.JournalEntry) (bool, error)) error {
+	return storage.db.ForEachAs(data.ReplayKeyPrefix, &data.JournalEntry{},
+		func(key string, value interface{}) (bool, error) {
+			sequence, err := strconv.ParseInt(strings.TrimPrefix(key, data.ReplayKeyPrefix), 10, 64)
+			if err != nil {
+				return false, errors.Wrapf(err, "invalid queued event key '%s'", key)
+			}
+			return f(sequence, value.(*data.JournalEntry))
+		})
+}
+
+func (storage *thingsDB) RemoveReplayEvents(upTo int64) error {
+	return storage.update(func(tx *thingsDB) error {
+		queue, err := tx.replayQueue()
+		if err != nil {
+			return err
+		}
+
+		for ; queue.First <= upTo && queue.First < queue.Next; queue.First++ {
+			if err := tx.db.Delete(data.ReplayKey(queue.First)); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		return tx.db.SetAs(systemKeyReplay, queue)
+	})
+}
+
+// replayQueue returns the bounds of the offline events queue, empty if no events are queued yet.
+func (storage *thingsDB) replayQueue() (*data.ReplayQueueData, error) {
+	queue := &data.ReplayQueueData{}
+	if err := storage.db.GetAs(systemKeyReplay, queue); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, errors.Wrap(err, "offline events queue could not be loaded")
+	}
+	return queue, nil
+}
@@ -0,0 +1,484 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	// registers the sqlite3 database driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqliteDriver = "sqlite3"
+
+	sqliteCreateTable = "CREATE TABLE IF NOT EXISTS things (key TEXT PRIMARY KEY, value BLOB NOT NULL)"
+	sqliteGet         = "SELECT value FROM things WHERE key = ?"
+	sqliteIterate     = "SELECT key, value FROM things WHERE key >= ? ORDER BY key"
+	sqliteKeys        = "SELECT key FROM things WHERE key >= ? ORDER BY key"
+	sqliteKeysAfter   = "SELECT key FROM things WHERE key >= ? AND key > ? ORDER BY key LIMIT ?"
+	sqlitePut         = "INSERT OR REPLACE INTO things (key, value) VALUES (?, ?)"
+	sqliteDelete      = "DELETE FROM things WHERE key = ?"
+	sqliteDeleteRange = "DELETE FROM things WHERE key >= ? AND substr(CAST(key AS BLOB), 1, ?) = CAST(? AS BLOB)"
+
+	sqliteSynchronousFull   = "PRAGMA synchronous = FULL"
+	sqliteSynchronousNormal = "PRAGMA synchronous = NORMAL"
+	sqliteCheckpoint        = "PRAGMA wal_checkpoint(PASSIVE)"
+)
+
+// sqlRunner executes the SQL statements either directly on the database or within a transaction.
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqliteStorage is a Database stored into a single SQLite table of key-value records,
+// the values are encoded as the bbolt database ones, so that both are interchangeable.
+type sqliteStorage struct {
+	path   string
+	db     *sql.DB
+	closed bool
+
+	// tx is the transaction of a batch, all operations are applied within it if set.
+	tx *sql.Tx
+
+	counters *writeCounters
+
+	// codec is the codec of the written values, CodecGob if empty.
+	codec string
+
+	// syncer syncs the committed transactions lazily, nil if each of them is synced on commit.
+	syncer *syncer
+}
+
+// NewSQLiteDatabase opens the SQLite database, creating it if missing.
+func NewSQLiteDatabase(path string) (Database, error) {
+	return newSQLiteDatabase(path, false)
+}
+
+func newSQLiteDatabase(path string, readOnly bool) (*sqliteStorage, error) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path)
+	if readOnly {
+		dsn = fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path)
+	}
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// single writer as the bbolt database, the batches are not interleaved with other operations
+	db.SetMaxOpenConns(1)
+
+	if !readOnly {
+		if _, err := db.Exec(sqliteCreateTable); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &sqliteStorage{
+		path:     path,
+		db:       db,
+		counters: &writeCounters{},
+	}, nil
+}
+
+func (storage *sqliteStorage) Close() error {
+	if storage.db == nil {
+		return ErrDatabaseNil
+	}
+	if storage.tx != nil {
+		return errBatchClose
+	}
+	if storage.closed {
+		return ErrDatabaseClosed
+	}
+
+	synced := storage.syncer.close()
+	if err := storage.db.Close(); err != nil {
+		return err
+	}
+	storage.closed = true
+	return synced
+}
+
+// setDurability relaxes the synchronous mode, so that the write-ahead log is not synced on commit
+// but on checkpoint, and checkpoints it lazily.
+func (storage *sqliteStorage) setDurability(durability Durability) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	if err := storage.syncer.close(); err != nil {
+		return err
+	}
+	storage.syncer = nil
+	mode := sqliteSynchronousFull
+	if durability.lazy() {
+		mode = sqliteSynchronousNormal
+	}
+	if _, err := storage.db.Exec(mode); err != nil {
+		return err
+	}
+	if durability.lazy() {
+		storage.syncer = newSyncer(durability, func() error {
+			_, err := storage.db.Exec(sqliteCheckpoint)
+			return err
+		})
+	}
+	return nil
+}
+
+func (storage *sqliteStorage) setCodec(codec string) {
+	storage.codec = codec
+}
+
+func (storage *sqliteStorage) dbOpened() error {
+	if storage.db == nil {
+		return ErrDatabaseNil
+	}
+	if storage.closed {
+		return ErrDatabaseClosed
+	}
+	return nil
+}
+
+func (storage *sqliteStorage) Batch(f func(db Database) error) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	if storage.tx != nil {
+		return f(storage) // already within a batch
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		return f(&sqliteStorage{
+			path:     storage.path,
+			db:       storage.db,
+			tx:       tx,
+			counters: storage.counters,
+			codec:    storage.codec,
+		})
+	})
+}
+
+// runner returns the batch transaction, if any, otherwise the database.
+func (storage *sqliteStorage) runner() sqlRunner {
+	if storage.tx != nil {
+		return storage.tx
+	}
+	return storage.db
+}
+
+// update runs the function within the batch transaction, if any, otherwise within a new transaction.
+func (storage *sqliteStorage) update(f func(tx *sql.Tx) error) error {
+	if storage.tx != nil {
+		return f(storage.tx)
+	}
+
+	tx, err := storage.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&storage.counters.commits, 1)
+	storage.syncer.committed()
+	return nil
+}
+
+func (storage *sqliteStorage) GetName() (string, error) {
+	name, err := storage.Get(systemKeyDbName)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+func (storage *sqliteStorage) SetName(name string) error {
+	return storage.Set(systemKeyDbName, []byte(name))
+}
+
+func (storage *sqliteStorage) Get(key string) ([]byte, error) {
+	if err := storage.dbOpened(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if err := storage.runner().QueryRow(sqliteGet, key).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (storage *sqliteStorage) GetAs(key string, value interface{}) error {
+	data, err := storage.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if vb, ok := value.([]byte); ok {
+		copy(vb, data)
+		return nil
+	}
+	return decodeAs(data, value)
+}
+
+func (storage *sqliteStorage) GetAllAs(prefix string, value interface{}) ([]interface{}, error) {
+	var values []interface{}
+	if err := storage.ForEachAs(prefix, value, func(_ string, nextValue interface{}) (bool, error) {
+		values = append(values, nextValue)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (storage *sqliteStorage) ForEachAs(
+	prefix string, value interface{}, f func(key string, value interface{}) (bool, error),
+) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	valueType := reflect.ValueOf(value).Elem().Type()
+
+	// the query reads a consistent snapshot of the records within its implicit read transaction
+	rows, err := storage.runner().Query(sqliteIterate, prefix)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		nextValue := reflect.New(valueType).Interface()
+		if err := decodeAs(data, nextValue); err != nil {
+			return err
+		}
+		if proceed, err := f(key, nextValue); err != nil || !proceed {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (storage *sqliteStorage) Keys(prefix string) ([]string, error) {
+	if err := storage.dbOpened(); err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.runner().Query(sqliteKeys, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (storage *sqliteStorage) KeysAfter(prefix string, after string, limit int) ([]string, error) {
+	if err := storage.dbOpened(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		// a negative limit is unlimited in SQLite
+		limit = -1
+	}
+
+	rows, err := storage.runner().Query(sqliteKeysAfter, prefix, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (storage *sqliteStorage) Set(key string, value []byte) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(sqlitePut, key, value); err != nil {
+			return err
+		}
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(value)))
+		atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(value)))
+		return nil
+	})
+}
+
+func (storage *sqliteStorage) SetAs(key string, value interface{}) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		return storage.put(tx, key, value)
+	})
+}
+
+func (storage *sqliteStorage) SetAllAs(values map[string]interface{}) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		for key, value := range values {
+			if err := storage.put(tx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *sqliteStorage) UpdateAllAs(prefix string, values map[string]interface{}) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		if err := deleteRange(tx, prefix); err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := storage.put(tx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// put encodes and puts the value, counting its payload and encoded sizes.
+func (storage *sqliteStorage) put(tx *sql.Tx, key string, value interface{}) error {
+	valueBytes, err := encodeAs(storage.codec, value)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(sqlitePut, key, valueBytes); err != nil {
+		return err
+	}
+
+	if payload, err := json.Marshal(value); err == nil {
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(payload)))
+	}
+	atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(valueBytes)))
+	return nil
+}
+
+func (storage *sqliteStorage) Delete(key string) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	_, err := storage.runner().Exec(sqliteDelete, key)
+	return err
+}
+
+func (storage *sqliteStorage) DeleteAll(prefix string) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	return storage.update(func(tx *sql.Tx) error {
+		return deleteRange(tx, prefix)
+	})
+}
+
+func deleteRange(tx *sql.Tx, prefix string) error {
+	_, err := tx.Exec(sqliteDeleteRange, prefix, len(prefix), prefix)
+	return err
+}
+
+// Snapshot writes a copy of the database made by vacuum into a temporary file next to the database file.
+func (storage *sqliteStorage) Snapshot(w io.Writer) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(storage.path), "snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, filepath.Base(storage.path))
+	if _, err := storage.runner().Exec("VACUUM INTO ?", snapshot); err != nil {
+		return err
+	}
+
+	file, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// WriteStats returns the written data sizes, the written pages size is not tracked by the SQLite database.
+func (storage *sqliteStorage) WriteStats() WriteStats {
+	return WriteStats{
+		PayloadBytes: atomic.LoadUint64(&storage.counters.payloadBytes),
+		EncodedBytes: atomic.LoadUint64(&storage.counters.encodedBytes),
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+// Stats contains the size statistics of the things storage, so that its growth could be monitored.
+type Stats struct {
+	// FileSize is the size in bytes of the database file, 0 for the in-memory storage.
+	FileSize int64
+	// Things is the number of the stored things.
+	Things int
+	// Features is the total number of the features of the stored things.
+	Features int
+	// JournalSize is the total size in bytes of the journaled events.
+	JournalSize int64
+	// Compacted is the timestamp of the last maintenance of the database file, e.g. its compaction,
+	// empty if the database file is not maintained yet.
+	Compacted string
+}
+
+func (storage *thingsDB) Stats() (*Stats, error) {
+	stats := &Stats{}
+	if storage.engine != EngineMemory {
+		info, err := os.Stat(storage.path)
+		if err != nil {
+			return nil, errors.Wrap(err, "database file size could not be retrieved")
+		}
+		stats.FileSize = info.Size()
+	}
+
+	thingIDs, err := storage.GetThingIDs()
+	if err != nil {
+		return nil, err
+	}
+	stats.Things = len(thingIDs)
+	for _, thingID := range thingIDs {
+		featureIDs, err := storage.featureIDs(thingID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "features of the thing with ID '%s' could not be counted", thingID)
+		}
+		stats.Features += len(featureIDs)
+	}
+
+	if err := storage.db.ForEachAs(data.JournalKeyPrefix, &data.JournalEntry{},
+		func(_ string, value interface{}) (bool, error) {
+			stats.JournalSize = stats.JournalSize + int64(len(value.(*data.JournalEntry).Event))
+			return true, nil
+		}); err != nil {
+		return nil, errors.Wrap(err, "journaled events could not be iterated")
+	}
+
+	compacted, err := storage.db.Get(systemKeyCompacted)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	stats.Compacted = string(compacted)
+	return stats, nil
+}
+
+func (storage *thingsDB) SetCompacted(at time.Time) error {
+	if err := storage.db.Set(systemKeyCompacted, []byte(at.UTC().Format(time.RFC3339))); err != nil {
+		return errors.Wrap(err, "database maintenance time could not be persisted")
+	}
+	return nil
+}
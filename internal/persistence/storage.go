@@ -14,9 +14,11 @@ package persistence
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/json"
 	"errors"
+	"io"
 	"reflect"
+	"sync/atomic"
 
 	"go.etcd.io/bbolt"
 )
@@ -34,6 +36,17 @@ type Database interface {
 	GetAs(key string, value interface{}) error
 	// GetAllAs reads all values matching the key prefix and decoded to the specified type.
 	GetAllAs(keyPrefix string, valuesType interface{}) ([]interface{}, error)
+	// ForEachAs decodes the values matching the key prefix one by one to the specified type
+	// and passes each of them to the provided function, without keeping all of them in memory.
+	// The values are read and passed within a single read transaction, i.e. the iteration is over a consistent
+	// snapshot of them, so the function must not access the database.
+	// The iteration is stopped if the function returns false or error, the error is returned.
+	ForEachAs(keyPrefix string, valuesType interface{}, f func(key string, value interface{}) (bool, error)) error
+	// Keys returns the sorted keys matching the key prefix, without reading their values.
+	Keys(keyPrefix string) ([]string, error)
+	// KeysAfter returns at most limit sorted keys matching the key prefix, which are greater than the provided key,
+	// without reading their values, so that the keys could be paged. A non-positive limit is unlimited.
+	KeysAfter(keyPrefix string, after string, limit int) ([]string, error)
 
 	// Set updates key data.
 	Set(key string, data []byte) error
@@ -51,11 +64,51 @@ type Database interface {
 	// DeleteAll removes all keys matching the prefix and their data.
 	DeleteAll(keyPrefix string) error
 
+	// Batch runs the function with a database, which operations are all applied within a single transaction.
+	// The transaction is committed if the function returns no error, otherwise it is rolled back
+	// and the function error is returned. The provided database must not be used after the function returns.
+	// A batch run with the provided database is nested, i.e. its operations are applied within the same transaction.
+	Batch(f func(db Database) error) error
+
+	// WriteStats returns the write statistics of the database since it is opened.
+	WriteStats() WriteStats
+
+	// Snapshot writes a consistent copy of the database file, while the database is still in use.
+	Snapshot(w io.Writer) error
+
 	// Close closes the opened database.
 	Close() error
 }
 
-const systemKeyDbName = "@SYSTEM/NAME"
+// WriteStats contains the cumulative sizes of the data written into a database, so that
+// the write amplification of the encoding and of the rewrite-on-update storage could be estimated.
+type WriteStats struct {
+	// PayloadBytes is the logical size of the written values, i.e. the size of their JSON representation.
+	PayloadBytes uint64
+	// EncodedBytes is the size of the written keys and encoded values.
+	EncodedBytes uint64
+	// PageBytes is the size of the database pages written on the transactions commits, i.e. written on the disk.
+	PageBytes uint64
+}
+
+// writeCounters counts the written data sizes of a database and of its batches.
+type writeCounters struct {
+	payloadBytes uint64
+	encodedBytes uint64
+	commits      uint64
+}
+
+const (
+	systemKeyDbName     = "@SYSTEM/NAME"
+	systemKeyCounters   = "@SYSTEM/COUNTERS"
+	systemKeyPending    = "@SYSTEM/PENDING"
+	systemKeyRetrieves  = "@SYSTEM/RETRIEVES"
+	systemKeySession    = "@SYSTEM/SYNC_SESSION"
+	systemKeyCompacted  = "@SYSTEM/COMPACTED"
+	systemKeyReplay     = "@SYSTEM/REPLAY"
+	systemKeyIndexes    = "@SYSTEM/INDEXES"
+	systemKeyTombstones = "@SYSTEM/TOMBSTONES"
+)
 
 var bboltBucket = []byte("things")
 
@@ -64,6 +117,20 @@ type storage struct {
 	path   string
 	db     *bbolt.DB
 	closed bool
+
+	// batch are the records of a batch transaction, all operations are applied within it if set.
+	batch records
+
+	counters *writeCounters
+
+	// codec is the codec of the written values, CodecGob if empty.
+	codec string
+
+	// pending are the writes of the committed transactions, which are written to the database lazily,
+	// nil if each transaction is written and synced on commit.
+	pending *pendingWrites
+	// syncer writes the pending writes lazily, nil if each transaction is written and synced on commit.
+	syncer *syncer
 }
 
 var (
@@ -75,29 +142,10 @@ var (
 
 	// ErrNotFound if the key does not exist.
 	ErrNotFound = errors.New("not found")
-)
-
-// Decode utils
-
-func init() {
-	gob.Register(map[string]interface{}{})
-	gob.Register([]interface{}{})
-}
-
-func encodeAs(value interface{}) ([]byte, error) {
-	buffer := &bytes.Buffer{}
-	encBuffer := gob.NewEncoder(buffer)
-	if err := encBuffer.Encode(value); err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
-}
 
-func decodeAs(data []byte, value interface{}) error {
-	buffer := bytes.NewBuffer(data)
-	decBuffer := gob.NewDecoder(buffer)
-	return decBuffer.Decode(value)
-}
+	errBatchClose    = errors.New("database cannot be closed within a batch")
+	errBatchSnapshot = errors.New("database cannot be snapshot within a batch")
+)
 
 // NewDatabase opens the database
 func NewDatabase(path string) (Database, error) {
@@ -116,9 +164,10 @@ func NewDatabase(path string) (Database, error) {
 	}
 
 	return &storage{
-		path:   path,
-		db:     db,
-		closed: false,
+		path:     path,
+		db:       db,
+		closed:   false,
+		counters: &writeCounters{},
 	}, nil
 }
 
@@ -127,17 +176,74 @@ func (storage *storage) Close() error {
 	if storage.db == nil {
 		return ErrDatabaseNil
 	}
+	if storage.batch != nil {
+		return errBatchClose
+	}
 	if storage.closed {
 		return ErrDatabaseClosed
 	}
 
+	synced := storage.syncer.close()
 	if err := storage.db.Close(); err != nil {
 		return err
 	}
 	storage.closed = true
+	return synced
+}
+
+func (storage *storage) setDurability(durability Durability) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	if err := storage.syncer.close(); err != nil {
+		return err
+	}
+	storage.syncer = nil
+	storage.pending = nil
+	if durability.lazy() {
+		storage.pending = &pendingWrites{writes: writes{}}
+		storage.syncer = newSyncer(durability, storage.flush)
+	}
+	return nil
+}
+
+// flush writes the pending writes to the database within a single transaction, which is synced on commit.
+// The pending writes are kept if the transaction fails, so that they are written on the next flush.
+func (storage *storage) flush() error {
+	pending := storage.pending
+	pending.writer.Lock()
+	defer pending.writer.Unlock()
+
+	// the pending writes are added by the write transactions only, i.e. while holding the writer lock
+	if len(pending.writes) == 0 {
+		return nil
+	}
+	if err := storage.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bboltBucket)
+		for key, value := range pending.writes {
+			var err error
+			if value == nil {
+				err = bucket.Delete([]byte(key))
+			} else {
+				err = bucket.Put([]byte(key), value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	atomic.AddUint64(&storage.counters.commits, 1)
+	pending.written()
 	return nil
 }
 
+func (storage *storage) setCodec(codec string) {
+	storage.codec = codec
+}
+
 func (storage *storage) dbOpened() error {
 	if storage.db == nil {
 		return ErrDatabaseNil
@@ -148,6 +254,132 @@ func (storage *storage) dbOpened() error {
 	return nil
 }
 
+func (storage *storage) Batch(f func(db Database) error) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	if storage.batch != nil {
+		return f(storage) // already within a batch
+	}
+
+	return storage.update(func(r records) error {
+		return f(withBatch(storage, r))
+	})
+}
+
+// withBatch returns a storage, which operations are applied to the provided records of a batch transaction.
+func withBatch(db *storage, r records) *storage {
+	return &storage{
+		path:     db.path,
+		db:       db.db,
+		batch:    r,
+		counters: db.counters,
+		codec:    db.codec,
+		pending:  db.pending,
+	}
+}
+
+// view runs the function within the batch transaction, if any, otherwise within a new read-only transaction.
+// The pending writes, if any, are applied to the read records.
+func (storage *storage) view(f func(r records) error) error {
+	if storage.batch != nil {
+		return f(storage.batch)
+	}
+	return storage.db.View(func(tx *bbolt.Tx) error {
+		var r records = bucketRecords{bucket: tx.Bucket(bboltBucket)}
+		if storage.pending != nil {
+			r = pendingRecords{pending: storage.pending, base: r}
+		}
+		return f(r)
+	})
+}
+
+// update runs the function within the batch transaction, if any, otherwise within a new write transaction.
+// If the transaction writes are written lazily, they are added to the pending writes on commit instead,
+// so that the writes of many transactions are written at once and a key updated by them is written once.
+func (storage *storage) update(f func(r records) error) error {
+	if storage.batch != nil {
+		return f(storage.batch)
+	}
+
+	pending := storage.pending
+	if pending == nil {
+		if err := storage.db.Update(func(tx *bbolt.Tx) error {
+			return f(bucketRecords{bucket: tx.Bucket(bboltBucket)})
+		}); err != nil {
+			return err
+		}
+		atomic.AddUint64(&storage.counters.commits, 1)
+		return nil
+	}
+
+	pending.writer.Lock()
+	w := writes{}
+	err := storage.db.View(func(tx *bbolt.Tx) error {
+		return f(overlay{
+			writes: w,
+			base:   pendingRecords{pending: pending, base: bucketRecords{bucket: tx.Bucket(bboltBucket)}},
+		})
+	})
+	if err == nil {
+		pending.add(w)
+	}
+	pending.writer.Unlock()
+
+	if err != nil {
+		return err
+	}
+	storage.syncer.committed()
+	return nil
+}
+
+// put encodes and puts the value into the records, counting its payload and encoded sizes.
+func (storage *storage) put(r records, key string, value interface{}) error {
+	valueBytes, err := encodeAs(storage.codec, value)
+	if err != nil {
+		return err
+	}
+	if err := r.put([]byte(key), valueBytes); err != nil {
+		return err
+	}
+
+	if payload, err := json.Marshal(value); err == nil {
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(payload)))
+	}
+	atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(valueBytes)))
+	return nil
+}
+
+func (storage *storage) Snapshot(w io.Writer) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
+	if storage.batch != nil {
+		return errBatchSnapshot
+	}
+	if err := storage.syncer.flush(); err != nil {
+		return err
+	}
+
+	return storage.db.View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+func (storage *storage) WriteStats() WriteStats {
+	stats := WriteStats{
+		PayloadBytes: atomic.LoadUint64(&storage.counters.payloadBytes),
+		EncodedBytes: atomic.LoadUint64(&storage.counters.encodedBytes),
+	}
+	if storage.dbOpened() == nil {
+		// the allocated dirty pages and the meta page are written on each commit
+		commits := atomic.LoadUint64(&storage.counters.commits)
+		stats.PageBytes = uint64(storage.db.Stats().TxStats.PageAlloc) + commits*uint64(storage.db.Info().PageSize)
+	}
+	return stats
+}
+
 func (storage *storage) GetName() (string, error) {
 	name, err := storage.Get(systemKeyDbName)
 	if err != nil {
@@ -166,8 +398,8 @@ func (storage *storage) Get(key string) ([]byte, error) {
 	}
 
 	var data []byte
-	if err := storage.db.View(func(tx *bbolt.Tx) error {
-		data = tx.Bucket(bboltBucket).Get([]byte(key))
+	if err := storage.view(func(r records) error {
+		data = r.get([]byte(key))
 		return nil
 	}); err != nil {
 		return nil, err
@@ -200,8 +432,8 @@ func (storage *storage) GetAs(key string, value interface{}) error {
 		return nil
 	}
 
-	if err := storage.db.View(func(tx *bbolt.Tx) error {
-		data := tx.Bucket(bboltBucket).Get([]byte(key))
+	if err := storage.view(func(r records) error {
+		data := r.get([]byte(key))
 		if data == nil {
 			return ErrNotFound
 		}
@@ -216,43 +448,81 @@ func (storage *storage) GetAs(key string, value interface{}) error {
 }
 
 func (storage *storage) GetAllAs(prefix string, value interface{}) ([]interface{}, error) {
-	if err := storage.dbOpened(); err != nil {
+	var values []interface{}
+	if err := storage.ForEachAs(prefix, value, func(_ string, nextValue interface{}) (bool, error) {
+		values = append(values, nextValue)
+		return true, nil
+	}); err != nil {
 		return nil, err
 	}
+	return values, nil
+}
+
+func (storage *storage) ForEachAs(
+	prefix string, value interface{}, f func(key string, value interface{}) (bool, error),
+) error {
+	if err := storage.dbOpened(); err != nil {
+		return err
+	}
 	valueType := reflect.ValueOf(value).Elem().Type()
 
-	var values []interface{}
-	f := func(val []byte) error {
-		nextValue := reflect.New(valueType).Interface()
-		if vb, ok := nextValue.([]byte); ok {
-			if cap(vb) < len(val) {
-				vb = make([]byte, len(val))
+	keyPrefix := []byte(prefix)
+	return storage.view(func(r records) error {
+		var err error
+		r.seek(keyPrefix, func(k []byte, v []byte) bool {
+			if !bytes.HasPrefix(k, keyPrefix) {
+				return false
 			}
-			copy(vb, val)
-			return nil
-		}
+			nextValue := reflect.New(valueType).Interface()
+			if err = decodeAs(v, nextValue); err != nil {
+				return false
+			}
+			proceed := false
+			proceed, err = f(string(k), nextValue)
+			return proceed && err == nil
+		})
+		return err
+	})
+}
 
-		if err := decodeAs(val, nextValue); err != nil {
-			return err
-		}
-		values = append(values, nextValue)
-		return nil
+func (storage *storage) Keys(prefix string) ([]string, error) {
+	if err := storage.dbOpened(); err != nil {
+		return nil, err
 	}
 
-	if err := storage.db.View(func(tx *bbolt.Tx) error {
-		it := tx.Bucket(bboltBucket).Cursor()
-
-		keyPrefix := []byte(prefix)
-		for k, v := it.Seek(keyPrefix); k != nil && bytes.HasPrefix(k, keyPrefix); k, v = it.Next() {
-			if err := f(v); err != nil {
-				return err
-			}
-		}
+	var keys []string
+	err := storage.view(func(r records) error {
+		keys = prefixKeys(r, prefix)
 		return nil
-	}); err != nil {
+	})
+	return keys, err
+}
+
+func (storage *storage) KeysAfter(prefix string, after string, limit int) ([]string, error) {
+	if err := storage.dbOpened(); err != nil {
 		return nil, err
 	}
-	return values, nil
+
+	var keys []string
+	keyPrefix := []byte(prefix)
+	start := keyPrefix
+	if after > prefix {
+		start = []byte(after)
+	}
+	err := storage.view(func(r records) error {
+		r.seek(start, func(k []byte, _ []byte) bool {
+			if string(k) == after {
+				return true
+			}
+			if !bytes.HasPrefix(k, keyPrefix) || limit > 0 && len(keys) == limit {
+				return false
+			}
+			keys = append(keys, string(k))
+			return true
+		})
+		return nil
+	})
+	return keys, err
 }
 
 func (storage *storage) Set(key string, value []byte) error {
@@ -260,8 +530,13 @@ func (storage *storage) Set(key string, value []byte) error {
 		return err
 	}
 
-	return storage.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(bboltBucket).Put([]byte(key), value)
+	return storage.update(func(r records) error {
+		if err := r.put([]byte(key), value); err != nil {
+			return err
+		}
+		atomic.AddUint64(&storage.counters.payloadBytes, uint64(len(value)))
+		atomic.AddUint64(&storage.counters.encodedBytes, uint64(len(key)+len(value)))
+		return nil
 	})
 }
 
@@ -271,19 +546,9 @@ func (storage *storage) SetAs(key string, value interface{}) error {
 		return err
 	}
 
-	f := func(tx *bbolt.Tx) error {
-		b := tx.Bucket(bboltBucket)
-		valueBytes, err := encodeAs(value)
-		if err != nil {
-			return err
-		}
-		if err := b.Put([]byte(key), valueBytes); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	return storage.db.Update(f)
+	return storage.update(func(r records) error {
+		return storage.put(r, key, value)
+	})
 }
 
 func (storage *storage) SetAllAs(values map[string]interface{}) error {
@@ -291,21 +556,16 @@ func (storage *storage) SetAllAs(values map[string]interface{}) error {
 		return err
 	}
 
-	f := func(tx *bbolt.Tx) error {
-		b := tx.Bucket(bboltBucket)
+	f := func(r records) error {
 		for key, value := range values {
-			valueBytes, err := encodeAs(value)
-			if err != nil {
-				return err
-			}
-			if err := b.Put([]byte(key), valueBytes); err != nil {
+			if err := storage.put(r, key, value); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 
-	return storage.db.Update(f)
+	return storage.update(f)
 }
 
 func (storage *storage) UpdateAllAs(prefix string, values map[string]interface{}) error {
@@ -313,31 +573,20 @@ func (storage *storage) UpdateAllAs(prefix string, values map[string]interface{}
 		return err
 	}
 
-	f := func(tx *bbolt.Tx) error {
-		b := tx.Bucket(bboltBucket)
-
-		it := b.Cursor()
-		keyPrefix := []byte(prefix)
-		for k, _ := it.Seek(keyPrefix); k != nil && bytes.HasPrefix(k, keyPrefix); k, _ = it.Next() {
-			err := b.Delete(k)
-			if err != nil {
-				return err
-			}
+	f := func(r records) error {
+		if err := deletePrefix(r, prefix); err != nil {
+			return err
 		}
 
 		for key, value := range values {
-			valueBytes, err := encodeAs(value)
-			if err != nil {
-				return err
-			}
-			if err := b.Put([]byte(key), valueBytes); err != nil {
+			if err := storage.put(r, key, value); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 
-	return storage.db.Update(f)
+	return storage.update(f)
 }
 
 func (storage *storage) Delete(key string) error {
@@ -345,8 +594,8 @@ func (storage *storage) Delete(key string) error {
 		return err
 	}
 
-	return storage.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(bboltBucket).Delete([]byte(key))
+	return storage.update(func(r records) error {
+		return r.delete([]byte(key))
 	})
 }
 
@@ -354,19 +603,32 @@ func (storage *storage) DeleteAll(prefix string) error {
 	if err := storage.dbOpened(); err != nil {
 		return err
 	}
-	f := func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(bboltBucket)
-		it := bucket.Cursor()
+	return storage.update(func(r records) error {
+		return deletePrefix(r, prefix)
+	})
+}
 
-		keyPrefix := []byte(prefix)
-		for k, _ := it.Seek(keyPrefix); k != nil && bytes.HasPrefix(k, keyPrefix); k, _ = it.Next() {
-			err := bucket.Delete(k)
-			if err != nil {
-				return err
-			}
+// prefixKeys returns the sorted keys of the records matching the prefix.
+func prefixKeys(r records, prefix string) []string {
+	var keys []string
+	keyPrefix := []byte(prefix)
+	r.seek(keyPrefix, func(k []byte, _ []byte) bool {
+		if !bytes.HasPrefix(k, keyPrefix) {
+			return false
 		}
-		return nil
-	}
-	return storage.db.Update(f)
+		keys = append(keys, string(k))
+		return true
+	})
+	return keys
+}
 
+// deletePrefix deletes all keys of the records matching the prefix. The keys are collected first,
+// as deleting while iterating skips keys of the nodes already modified within the transaction.
+func deletePrefix(r records, prefix string) error {
+	for _, k := range prefixKeys(r, prefix) {
+		if err := r.delete([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
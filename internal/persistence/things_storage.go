@@ -13,26 +13,85 @@
 package persistence
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
 )
 
+// Timestamps contains the creation and last modification timestamps of a stored entity
+// and the thing revision of its last modification.
+type Timestamps struct {
+	Created  string
+	Modified string
+	Revision int64
+}
+
+// ThingTimestamps contains the timestamps of a stored thing and of its features, keyed by the feature IDs.
+type ThingTimestamps struct {
+	Timestamps
+	Features map[string]Timestamps
+}
+
+// ThingIDsPage is a page of the sorted identifiers of the stored things.
+type ThingIDsPage struct {
+	// ThingIDs are the thing identifiers of the page.
+	ThingIDs []string `json:"thingIds"`
+	// Next is the cursor of the next page, i.e. the last thing identifier of the page,
+	// empty if there are no more things.
+	Next string `json:"next,omitempty"`
+}
+
 // ThingsStorage provides handles things and features model data persistency.
 type ThingsStorage interface {
 	// GetThingIDs returns the identifiers of the currently stored things.
 	// ErrDatabaseClosed is returned on invocation if the database is closed.
 	GetThingIDs() ([]string, error)
 
+	// GetThingIDsPage returns at most limit sorted identifiers of the stored things, which are greater than
+	// the provided cursor, i.e. the Next cursor of the previous page or empty for the first page,
+	// so that the things are enumerated incrementally without loading all of their identifiers in memory.
+	// A non-positive limit is unlimited.
+	GetThingIDsPage(after string, limit int) (*ThingIDsPage, error)
+
+	// CountThings returns the number of the stored things, without loading all of their identifiers in memory.
+	CountThings() (int, error)
+
+	// ReconcileThingIDs reconciles the things IDs index, used by GetThingIDs, with the stored things, e.g. on startup
+	// after a crash. Unless rebuild is requested, the index is verified against the things system data keys first
+	// and is rebuilt only if they differ. The index is rebuilt by scanning all database keys, restoring the missing
+	// things system data and removing the system data and the features of the missing things.
+	ReconcileThingIDs(rebuild bool) (*ReconcileReport, error)
+
+	// CheckIntegrity verifies that the things IDs index, the things records, the features records and the things
+	// system data are mutually consistent, e.g. there are no orphan features and no missing system data,
+	// by scanning all database records. The found inconsistencies are repaired if requested,
+	// except the corrupted records, which are only reported.
+	CheckIntegrity(repair bool) (*IntegrityReport, error)
+
+	// SetIndexedAttributes sets the slash-separated paths of the attributes indexed by their scalar values,
+	// e.g. 'location' or 'building/floor', so that the things with an attribute value are looked up
+	// without loading all things. The indexes are rebuilt if the paths differ from the persisted ones
+	// and are removed if no paths are provided.
+	SetIndexedAttributes(paths []string) error
+
+	// GetIndexedThingIDs returns the sorted identifiers of the things with the attribute on the provided path
+	// equal to any of the provided values. Returns ErrNotIndexed if the attribute is not indexed.
+	GetIndexedThingIDs(path string, values ...interface{}) ([]string, error)
+
 	// AddThing persists the thing data and its features data.
 	// Updates the data if the thing data is already available.
+	// Returns ErrLimitExceeded if the thing would exceed the storage limits.
 	// Returns the thing's unsynchronized revision value on success.
 	AddThing(thing *model.Thing) (int64, error)
 
@@ -45,12 +104,29 @@ type ThingsStorage interface {
 	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
 	GetThingData(thingID string, thing *model.Thing) error
 
-	// RemoveThing removes the thing data and all of its features data.
+	// GetThingFeatures retrieves the stored thing's data and only the provided features into the pointed thing,
+	// so that the rest of its features are not loaded in memory. The provided features not stored are skipped.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
+	GetThingFeatures(thingID string, thing *model.Thing, featureIDs ...string) error
+
+	// GetFeatureIDs returns the sorted IDs of the stored features of the thing, without loading the features data.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
+	GetFeatureIDs(thingID string) ([]string, error)
+
+	// ForEachFeature passes the stored features of the thing one by one to the provided function,
+	// without loading all of them in memory. The iteration is stopped if the function returns false or error.
+	// The features are iterated within a single read transaction, so the function must not access the storage.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
+	ForEachFeature(thingID string, f func(featureID string, feature *model.Feature) (bool, error)) error
+
+	// RemoveThing removes the thing data and all of its features data, keeping a tombstone of the thing,
+	// so that its deletion is synchronized with the hub.
 	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
 	RemoveThing(thingID string) error
 
 	// AddFeature persists the feature data. Updates the data if the feature data is already available.
-	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID or
+	// ErrLimitExceeded if the feature would exceed the storage limits.
 	// Returns the feature's unsynchronized revision value on success.
 	AddFeature(thingID string, featureID string, feature *model.Feature) (int64, error)
 
@@ -64,6 +140,24 @@ type ThingsStorage interface {
 	// ErrorFeatureNotFound if the referenced thing has no feature with the provided feature ID.
 	RemoveFeature(thingID string, featureID string) error
 
+	// GetTimestamps returns the creation and last modification timestamps of the thing and of its features.
+	// The creation timestamps are empty for the things and features stored by a previous version.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID.
+	GetTimestamps(thingID string) (*ThingTimestamps, error)
+
+	// GetFeatureTimestamps returns the creation and last modification timestamps of the feature
+	// and the thing revision of its last modification, 0 if not modified since stored by a previous version.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID or
+	// ErrorFeatureNotFound if the referenced thing has no feature with the provided feature ID.
+	GetFeatureTimestamps(thingID string, featureID string) (*Timestamps, error)
+
+	// UpdateMetadata merges the provided metadata into the stored thing metadata or into the feature metadata
+	// if feature ID is provided, the metadata fields with nil value are removed.
+	// The metadata update does not modify the thing revision.
+	// Returns ErrorThingNotFound if no thing is found with the provided thing ID or
+	// ErrorFeatureNotFound if the referenced thing has no feature with the provided feature ID.
+	UpdateMetadata(thingID string, featureID string, metadata map[string]interface{}) error
+
 	// ThingSynchronized removes all thing's system data that is related to thing's synchronization state
 	// if the revision matches the current thing's revision. Returns true in such case.
 	ThingSynchronized(thingID string, revision int64) (bool, error)
@@ -74,15 +168,163 @@ type ThingsStorage interface {
 	// If the feature is marked as unsynchronized or deleted, its system synchronization data is removed.
 	FeatureSynchronized(thingID string, featureID string, revision int64) (bool, error)
 
+	// AttributesSynchronized marks the thing attributes as synchronized if the revision matches
+	// the count of their local modifications, i.e. they are not modified again meanwhile.
+	// Returns true if the attributes are synchronized.
+	AttributesSynchronized(thingID string, revision int64) (bool, error)
+
 	// GetSystemThingData retrieves the system data related to the thing and its features synchronization state.
 	GetSystemThingData(thingID string) (*data.SystemThingData, error)
 
+	// GetCounters retrieves the persisted metrics counters into the pointed counters data.
+	// Returns ErrNotFound if no counters are persisted yet.
+	GetCounters(counters *data.CountersData) error
+
+	// SetCounters persists the metrics counters data, replacing the previously persisted one.
+	SetCounters(counters *data.CountersData) error
+
+	// GetPendingCommands retrieves the persisted pending commands into the pointed pending data.
+	// Returns ErrNotFound if no pending commands are persisted.
+	GetPendingCommands(pending *data.PendingData) error
+
+	// SetPendingCommands persists the pending commands data, replacing the previously persisted one.
+	SetPendingCommands(pending *data.PendingData) error
+
+	// RemovePendingCommands removes the persisted pending commands data, if any.
+	RemovePendingCommands() error
+
+	// GetRetrieves retrieves the persisted retrieve desired properties commands into the pointed retrieves data.
+	// Returns ErrNotFound if no retrieve commands are persisted.
+	GetRetrieves(retrieves *data.RetrievesData) error
+
+	// SetRetrieves persists the retrieve desired properties commands data, replacing the previously persisted one.
+	SetRetrieves(retrieves *data.RetrievesData) error
+
+	// GetSyncSession retrieves the persisted synchronization session progress into the pointed session data.
+	// Returns ErrNotFound if no synchronization session is persisted.
+	GetSyncSession(session *data.SyncSessionData) error
+
+	// SetSyncSession persists the synchronization session progress, replacing the previously persisted one.
+	SetSyncSession(session *data.SyncSessionData) error
+
+	// RemoveSyncSession removes the persisted synchronization session progress, if any.
+	RemoveSyncSession() error
+
+	// GetTombstones retrieves the tombstones of the things deleted locally, which deletion is not synchronized
+	// with the hub yet, into the pointed tombstones data. Returns ErrNotFound if there are no tombstones.
+	GetTombstones(tombstones *data.TombstonesData) error
+
+	// PurgeTombstone removes the tombstone of the deleted thing once its deletion is synchronized with the hub.
+	// The tombstone is kept if the thing is deleted again with another revision meanwhile.
+	// Returns true if the tombstone is removed.
+	PurgeTombstone(thingID string, revision int64) (bool, error)
+
+	// AppendEvent appends the locally generated event of a thing to the events journal.
+	// The entry timestamp is set to the current time, if empty.
+	AppendEvent(entry *data.JournalEntry) error
+
+	// GetEvents returns the journaled events of the thing with revisions within the provided inclusive range,
+	// ordered by revision. A zero or negative upper bound is unlimited.
+	GetEvents(thingID string, fromRevision int64, toRevision int64) ([]*data.JournalEntry, error)
+
+	// PruneEvents removes the oldest journaled events exceeding the retention policy at the provided time.
+	// Returns the number of the removed events.
+	PruneEvents(retention JournalRetention, now time.Time) (int, error)
+
+	// QueueReplayEvent appends the event generated while offline to the queue of the events replayed to the hub
+	// on reconnect. The entry timestamp is set to the current time, if empty. If the max events is positive,
+	// the oldest queued events exceeding it are dropped. Returns the number of the dropped events.
+	QueueReplayEvent(entry *data.JournalEntry, maxEvents int) (int, error)
+
+	// ForEachReplayEvent passes the queued offline events one by one, in their queuing order, to the provided
+	// function with their sequence numbers. The iteration is stopped if the function returns false or error.
+	// The events are iterated within a single read transaction, so the function must not access the storage.
+	ForEachReplayEvent(f func(sequence int64, entry *data.JournalEntry) (bool, error)) error
+
+	// RemoveReplayEvents removes the queued offline events up to the provided sequence number inclusive,
+	// e.g. once they are replayed.
+	RemoveReplayEvents(upTo int64) error
+
+	// AddPolicy stores the JSON encoded policy document, replacing the stored one with the same ID, if any.
+	// Returns the policy revision, which is 1 on its creation and is increased on each modification.
+	AddPolicy(policyID string, policy []byte) (int64, error)
+
+	// GetPolicy returns the stored policy with the provided ID.
+	// Returns ErrPolicyNotFound if no policy is found with the provided ID.
+	GetPolicy(policyID string) (*data.PolicyData, error)
+
+	// GetPolicyIDs returns the sorted IDs of the stored policies.
+	GetPolicyIDs() ([]string, error)
+
+	// RemovePolicy removes the stored policy with the provided ID.
+	// Returns ErrPolicyNotFound if no policy is found with the provided ID.
+	RemovePolicy(policyID string) error
+
 	// GetDeviceID returns the device ID which data is stored into the database.
 	GetDeviceID() string
 
+	// Recovered returns the path the corrupted database file is moved aside to on opening,
+	// so that the storage is started clean, or empty if the database file is not corrupted.
+	Recovered() string
+
+	// SetLimits sets the storage limits enforced on adding things and features.
+	SetLimits(limits Limits)
+
+	// SetDurability sets the policy of syncing the committed transactions of the database file to the disk,
+	// kept on reopening. Returns error if the policy is invalid.
+	SetDurability(durability Durability) error
+
+	// GetWriteStats returns the write statistics of the database since it is opened or reopened.
+	GetWriteStats() WriteStats
+
+	// Stats returns the size statistics of the storage, i.e. the database file size, the number of the stored
+	// things and features, the journal size and the last database file maintenance time.
+	Stats() (*Stats, error)
+
+	// SetCompacted persists the time of the last database file maintenance, e.g. its compaction.
+	SetCompacted(at time.Time) error
+
+	// Snapshot writes a consistent copy of the database file, while the storage is still in use,
+	// e.g. to inspect the things of a running service.
+	Snapshot(w io.Writer) error
+
+	// Backup writes a consistent copy of the database file to the provided path, while the storage is still in use.
+	// The copy is written into a temporary file renamed to the path when complete.
+	// Returns ErrBackupExists if the path already exists.
+	Backup(path string) error
+
+	// Export writes all stored things, their features and synchronization metadata as a portable JSON document,
+	// independent of the storage engine.
+	Export(w io.Writer) error
+
+	// Import replaces all stored things with the ones of a JSON document written by Export.
+	// The things are replaced within a single transaction. Returns ErrImportMismatch if the document
+	// is exported from another device.
+	Import(r io.Reader) error
+
+	// Batch runs the function with a things storage, which operations are applied atomically, i.e. all or none.
+	// The changes are persisted if the function returns no error, otherwise all of them are discarded
+	// and the function error is returned. The provided storage must not be used after the function returns.
+	// Each modifying operation is applied within a single transaction, joining the batch one, if any.
+	// The batch commands and each of the modifying twin commands are executed within a batch,
+	// so that a crash never leaves them half-applied.
+	Batch(f func(storage ThingsStorage) error) error
+
+	// Watch registers a listener notified about each added, updated and deleted thing or feature
+	// once the change is committed, and returns the function unregistering it.
+	// The listener is invoked synchronously on the modifying goroutine, so it must not block
+	// nor modify the storage. The changes of a read-only storage cannot be watched.
+	Watch(listener func(change StorageChange)) func()
+
 	// Close closes the opened database.
 	// ErrDatabaseClosed is returned on invocation of database operation on closed database.
 	Close() error
+
+	// Reopen opens again the database from its location, closing it first if still opened.
+	// It is used after maintenance of the database file, e.g. compaction or backup restore.
+	// If the restored database is of another device, it is backed up and a clean storage is initialized,
+	// unless the storage is partitioned, then the device partition of the restored database is used.
+	Reopen() error
 }
 
 var (
@@ -91,16 +333,113 @@ var (
 
 	// ErrFeatureNotFound indicates that a feature with such ID does not exist within the specified thing's features.
 	ErrFeatureNotFound = errors.Wrap(ErrNotFound, "feature could not be found")
+
+	// ErrDatabaseLocked indicates that the database file is held open by another process, e.g. the running service.
+	ErrDatabaseLocked = errors.New("database is locked by another process")
+)
+
+// readOnlyOpenTimeout is the time to wait for the database file lock on read-only opening.
+const readOnlyOpenTimeout = time.Second
+
+// countBatchSize is the number of the keys read at once on counting the stored things.
+const countBatchSize = 1024
+
+// Keys of the feature metadata of the properties and of the desired properties.
+const (
+	metadataProperties        = "properties"
+	metadataDesiredProperties = "desiredProperties"
+)
+
+// Storage engines of the things database.
+const (
+	// EngineBolt stores the things into a bbolt database file, it is the default engine.
+	EngineBolt = "bbolt"
+	// EngineSQLite stores the things into a table of a SQLite database file, so that they could be inspected via SQL.
+	EngineSQLite = "sqlite"
+	// EngineMemory keeps the things in memory only, they are lost when the service is stopped.
+	EngineMemory = "memory"
 )
 
 type thingsDB struct {
 	deviceID string
 	path     string
+	engine   string
 	db       Database
+	limits   Limits
+
+	// durability is the policy of syncing the committed transactions, applied again on reopening.
+	durability Durability
+	// codec is the codec of the written values, applied again on reopening.
+	codec string
+
+	// indexed are the paths of the indexed attributes set on the storage, nil if not set,
+	// so that the indexes are updated on reopening of a restored database.
+	indexed []string
+
+	// recovered is the path the corrupted database file is moved aside to on opening, if any.
+	recovered string
+
+	// partitioned is true if the device data is kept in its own partition of the database file.
+	partitioned bool
+	// partitions is the shared database file of multiple devices the storage is opened from, if any.
+	partitions *Partitions
+
+	// watchers are the listeners of the storage changes, nil if the storage is read-only.
+	watchers *watchers
+	// changes are the changes of the current transaction, notified once it is committed.
+	changes *[]StorageChange
 }
 
-// NewThingsDB opens the things database.
+// StorageOptions are the options of opening a things storage.
+type StorageOptions struct {
+	// Engine is the storage engine, EngineBolt if empty.
+	Engine string
+	// Codec is the codec of the values written by the storage, CodecGob if empty.
+	// The stored values are read regardless of the codec they are written with.
+	Codec string
+	// Partitioned keeps the data of the device in its own partition of the database file,
+	// see NewPartitionedThingsStorage.
+	Partitioned bool
+}
+
+// NewThingsDB opens the things database using the default storage engine.
 func NewThingsDB(path, deviceID string) (ThingsStorage, error) {
+	return NewThingsStorage(EngineBolt, path, deviceID)
+}
+
+// NewThingsStorage opens the things database using the provided storage engine.
+// Returns error if the storage engine is unknown.
+func NewThingsStorage(engine, path, deviceID string) (ThingsStorage, error) {
+	return OpenThingsStorage(path, deviceID, StorageOptions{Engine: engine})
+}
+
+// NewPartitionedThingsStorage opens the things database using the provided storage engine, keeping the data
+// of the device in its own partition of the database file. Unlike NewThingsStorage, the database file
+// of another device is not moved aside but its data is kept, so that it is available once the device is back.
+func NewPartitionedThingsStorage(engine, path, deviceID string) (ThingsStorage, error) {
+	return OpenThingsStorage(path, deviceID, StorageOptions{Engine: engine, Partitioned: true})
+}
+
+// OpenThingsStorage opens the things database with the provided options.
+// Returns error if the storage engine or the codec is unknown.
+func OpenThingsStorage(path, deviceID string, options StorageOptions) (ThingsStorage, error) {
+	if err := ValidateCodec(options.Codec); err != nil {
+		return nil, err
+	}
+	storage, err := openThingsStorage(path, deviceID, options)
+	if err != nil {
+		return nil, err
+	}
+	storage.setCodec(options.Codec)
+	return storage, nil
+}
+
+func openThingsStorage(path, deviceID string, options StorageOptions) (*thingsDB, error) {
+	engine, partitioned := options.Engine, options.Partitioned
+	if engine == EngineMemory {
+		return NewInMemoryThingsDB(deviceID, 0).(*thingsDB), nil
+	}
+
 	dir := filepath.Dir(path)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0711); err != nil {
@@ -108,12 +447,36 @@ func NewThingsDB(path, deviceID string) (ThingsStorage, error) {
 		}
 	}
 
-	database, err := NewDatabase(path)
+	database, err := openDatabase(engine, path)
 	if err != nil {
+		if corrupted(err) {
+			return recoverCorrupted(path, deviceID, options, err)
+		}
 		return nil, err
 	}
 
-	name, _ := database.GetName()
+	name, err := database.GetName()
+	if err != nil && corrupted(err) {
+		database.Close()
+		return recoverCorrupted(path, deviceID, options, err)
+	}
+
+	if partitioned {
+		partition, err := partitionOf(database, deviceID, true)
+		if err != nil {
+			database.Close()
+			return nil, errors.Wrapf(err, "error initializing device '%s' storage on location '%s'", deviceID, path)
+		}
+		return &thingsDB{
+			deviceID:    deviceID,
+			path:        path,
+			engine:      engine,
+			db:          partition,
+			partitioned: true,
+			watchers:    newWatchers(),
+		}, nil
+	}
+
 	if len(name) == 0 {
 		database.SetName(deviceID)
 
@@ -124,33 +487,242 @@ func NewThingsDB(path, deviceID string) (ThingsStorage, error) {
 				return nil,
 					errors.Wrapf(err, "error initializing clean device '%s' storage on location '%s'", deviceID, path)
 			}
-			return NewThingsDB(path, deviceID)
+			return openThingsStorage(path, deviceID, options)
 		}
 	}
 
 	return &thingsDB{
 		deviceID: deviceID,
 		path:     path,
+		engine:   engine,
 		db:       database,
+		watchers: newWatchers(),
 	}, nil
 }
 
+// NewInMemoryThingsDB creates an empty things storage kept in memory only, without any database file.
+// If the max size is positive, the writes exceeding it are rejected with ErrDatabaseFull.
+func NewInMemoryThingsDB(deviceID string, maxSize int64) ThingsStorage {
+	database := NewMemoryDatabase(maxSize)
+	database.SetName(deviceID)
+	return &thingsDB{
+		deviceID: deviceID,
+		engine:   EngineMemory,
+		db:       database,
+		watchers: newWatchers(),
+	}
+}
+
+// OpenReadOnly opens the things database file for reading only, e.g. to inspect it while the service is stopped.
+// Returns ErrDatabaseLocked if the file is held open by a running service.
+func OpenReadOnly(engine, path string) (ThingsStorage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	var database Database
+	switch engine {
+	case EngineBolt, EngineMemory, "":
+		// the in-memory storage snapshots are bbolt database files
+		db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: readOnlyOpenTimeout})
+		if err != nil {
+			if errors.Is(err, bbolt.ErrTimeout) {
+				return nil, ErrDatabaseLocked
+			}
+			return nil, err
+		}
+		database = &storage{path: path, db: db, counters: &writeCounters{}}
+	case EngineSQLite:
+		db, err := newSQLiteDatabase(path, true)
+		if err != nil {
+			return nil, err
+		}
+		database = db
+	default:
+		return nil, errors.Errorf("unknown storage engine '%s'", engine)
+	}
+
+	deviceID, _ := database.GetName()
+	return &thingsDB{deviceID: deviceID, path: path, engine: engine, db: database}, nil
+}
+
+func openDatabase(engine, path string) (Database, error) {
+	switch engine {
+	case EngineBolt, "":
+		return NewDatabase(path)
+	case EngineSQLite:
+		return NewSQLiteDatabase(path)
+	default:
+		return nil, errors.Errorf("unknown storage engine '%s'", engine)
+	}
+}
+
+// backupDB moves aside the database file of the device with the provided name, keeping its previous backups.
 func backupDB(path, name string) error {
 	backupSuffix := strings.ReplaceAll(name, ":", "_")
-	if err := os.Rename(path, fmt.Sprintf("%s.%s", path, backupSuffix)); err != nil {
-		return os.Rename(path, fmt.Sprintf("%s.%d", path, time.Now().Unix()))
+	if err := os.Rename(path, rotatedBackupPath(path, backupSuffix)); err != nil {
+		return os.Rename(path, rotatedBackupPath(path, "device"))
 	}
 	return nil
 }
 
+// setCodec sets the codec of the values written by the storage, applied again on reopening.
+func (storage *thingsDB) setCodec(codec string) {
+	storage.codec = codec
+	if db, ok := storage.db.(encoded); ok {
+		db.setCodec(codec)
+	}
+}
+
 func (storage *thingsDB) Close() error {
 	return storage.db.Close()
 }
 
+func (storage *thingsDB) Batch(f func(storage ThingsStorage) error) error {
+	return storage.update(func(tx *thingsDB) error {
+		return f(tx)
+	})
+}
+
+// update runs the function with a things storage, which operations are applied within a single transaction,
+// so that the multi-step operations, e.g. storing a thing and updating the things IDs index, are atomic.
+// Within a batch, the function is run within the batch transaction.
+// The watchers are notified about the changes once the outermost transaction is committed.
+func (storage *thingsDB) update(f func(tx *thingsDB) error) error {
+	changes := storage.changes
+	if changes == nil {
+		changes = &[]StorageChange{}
+	}
+	if err := storage.db.Batch(func(db Database) error {
+		return f(&thingsDB{
+			deviceID: storage.deviceID,
+			path:     storage.path,
+			engine:   storage.engine,
+			db:       db,
+			limits:   storage.limits,
+			watchers: storage.watchers,
+			changes:  changes,
+		})
+	}); err != nil {
+		return err
+	}
+	if storage.changes == nil {
+		storage.watchers.notify(*changes)
+	}
+	return nil
+}
+
+func (storage *thingsDB) Reopen() error {
+	if memory, ok := storage.db.(*memoryStorage); ok {
+		// there is no database file to be maintained, the records are kept
+		if err := memory.Close(); err != nil && !errors.Is(err, ErrDatabaseClosed) {
+			return err
+		}
+		memory.reopen()
+		return nil
+	}
+
+	if err := storage.db.Close(); err != nil && !errors.Is(err, ErrDatabaseClosed) {
+		return err
+	}
+
+	if storage.partitions != nil {
+		// the shared database file is not reopened, its maintenance is up to its owner
+		partition, err := partitionOf(storage.partitions.db, storage.deviceID, false)
+		if err != nil {
+			return err
+		}
+		storage.db = partition
+		return storage.reindex()
+	}
+
+	reopened, err := openThingsStorage(storage.path, storage.deviceID, StorageOptions{
+		Engine:      storage.engine,
+		Partitioned: storage.partitioned,
+	})
+	if err != nil {
+		return err
+	}
+	storage.db = reopened.db
+	storage.setCodec(storage.codec)
+	if err := storage.applyDurability(); err != nil {
+		return err
+	}
+	return storage.reindex()
+}
+
+func (storage *thingsDB) GetCounters(counters *data.CountersData) error {
+	return storage.db.GetAs(systemKeyCounters, counters)
+}
+
+func (storage *thingsDB) SetCounters(counters *data.CountersData) error {
+	if err := storage.db.SetAs(systemKeyCounters, counters); err != nil {
+		return errors.Wrap(err, "metrics counters could not be persisted")
+	}
+	return nil
+}
+
+func (storage *thingsDB) GetPendingCommands(pending *data.PendingData) error {
+	return storage.db.GetAs(systemKeyPending, pending)
+}
+
+func (storage *thingsDB) SetPendingCommands(pending *data.PendingData) error {
+	if err := storage.db.SetAs(systemKeyPending, pending); err != nil {
+		return errors.Wrap(err, "pending commands could not be persisted")
+	}
+	return nil
+}
+
+func (storage *thingsDB) RemovePendingCommands() error {
+	return storage.db.Delete(systemKeyPending)
+}
+
+func (storage *thingsDB) GetRetrieves(retrieves *data.RetrievesData) error {
+	return storage.db.GetAs(systemKeyRetrieves, retrieves)
+}
+
+func (storage *thingsDB) SetRetrieves(retrieves *data.RetrievesData) error {
+	if err := storage.db.SetAs(systemKeyRetrieves, retrieves); err != nil {
+		return errors.Wrap(err, "retrieve commands could not be persisted")
+	}
+	return nil
+}
+
+func (storage *thingsDB) GetSyncSession(session *data.SyncSessionData) error {
+	return storage.db.GetAs(systemKeySession, session)
+}
+
+func (storage *thingsDB) SetSyncSession(session *data.SyncSessionData) error {
+	if err := storage.db.SetAs(systemKeySession, session); err != nil {
+		return errors.Wrap(err, "synchronization session could not be persisted")
+	}
+	return nil
+}
+
+func (storage *thingsDB) RemoveSyncSession() error {
+	return storage.db.Delete(systemKeySession)
+}
+
 func (storage *thingsDB) GetDeviceID() string {
 	return storage.deviceID
 }
 
+func (storage *thingsDB) SetLimits(limits Limits) {
+	storage.limits = limits
+}
+
+func (storage *thingsDB) GetWriteStats() WriteStats {
+	return storage.db.WriteStats()
+}
+
+func (storage *thingsDB) Snapshot(w io.Writer) error {
+	return storage.db.Snapshot(w)
+}
+
+func (storage *thingsDB) Backup(path string) error {
+	return writeBackup(path, storage.db.Snapshot)
+}
+
 func (storage *thingsDB) GetThingIDs() ([]string, error) {
 	things := make(map[string]interface{})
 	if err := storage.db.GetAs(data.IDSeparator, &things); err != nil {
@@ -168,6 +740,45 @@ func (storage *thingsDB) GetThingIDs() ([]string, error) {
 	return ids, nil
 }
 
+func (storage *thingsDB) GetThingIDsPage(after string, limit int) (*ThingIDsPage, error) {
+	// the system data keys of the things are sorted as their identifiers,
+	// the one more key tells if there are more things
+	fetch := limit
+	if limit > 0 {
+		fetch = limit + 1
+	}
+	keys, err := storage.db.KeysAfter(data.IDSeparator, data.SystemThingKey(after), fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThingIDsPage{ThingIDs: make([]string, 0, len(keys))}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		page.Next = strings.TrimPrefix(keys[limit-1], data.IDSeparator)
+	}
+	for _, key := range keys {
+		page.ThingIDs = append(page.ThingIDs, strings.TrimPrefix(key, data.IDSeparator))
+	}
+	return page, nil
+}
+
+func (storage *thingsDB) CountThings() (int, error) {
+	count := 0
+	after := data.IDSeparator
+	for {
+		keys, err := storage.db.KeysAfter(data.IDSeparator, after, countBatchSize)
+		if err != nil {
+			return 0, err
+		}
+		count += len(keys)
+		if len(keys) < countBatchSize {
+			return count, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
 func (storage *thingsDB) AddThing(thing *model.Thing) (int64, error) {
 	if thing == nil || thing.ID == nil {
 		return -1, errors.New("thing with provided ID is mandatory on adding thing")
@@ -179,28 +790,62 @@ func (storage *thingsDB) AddThing(thing *model.Thing) (int64, error) {
 	}
 
 	thingID := thing.ID.String()
-	thingData, systemThingData, _ := storage.loadThingData(thingID)
+	revision := int64(-1)
+	err := storage.update(func(tx *thingsDB) error {
+		thingData, systemThingData, _ := tx.loadThingData(thingID)
 
-	if thingData == nil {
-		thingData = &data.ThingData{}
-	}
+		if thingData == nil {
+			thingData = &data.ThingData{}
+		}
+		previous := thingData.Attributes
+
+		created := systemThingData == nil
+		if created {
+			systemThingData = &data.SystemThingData{
+				ID:                     thingID,
+				Revision:               thing.Revision - 1,
+				DeletedFeatures:        make(map[string]interface{}),
+				UnsynchronizedFeatures: make(map[string]int64),
+			}
+		}
 
-	if systemThingData == nil {
-		systemThingData = &data.SystemThingData{
-			ID:                     thingID,
-			Revision:               thing.Revision - 1,
-			DeletedFeatures:        make(map[string]interface{}),
-			UnsynchronizedFeatures: make(map[string]int64),
+		if err := tx.checkThing(thingID, thing, created); err != nil {
+			return err
+		}
+		if created {
+			if err := tx.removeTombstone(thingID); err != nil {
+				return err
+			}
 		}
-	}
 
-	updateThingData(thingData, thingID, thing)
-	updateSystemThingData(systemThingData)
-	err := storage.persistThingData(thingData, systemThingData, thing.Features)
-	if err == nil {
-		storage.updateThingIDs(thingID, true)
+		updateThingData(thingData, thingID, thing)
+		updateSystemThingData(systemThingData)
+		if created {
+			systemThingData.Created = systemThingData.Timestamp
+		}
+		if !sameValues(thing.Attributes, previous) {
+			systemThingData.UnsynchronizedAttributes++
+			systemThingData.RemovedAttributes = jsonutil.AddRemovals(systemThingData.RemovedAttributes,
+				jsonutil.MergeRemovals(previous, thing.Attributes))
+		}
+		if err := tx.persistThingData(thingData, systemThingData, thing.Features); err != nil {
+			return err
+		}
+		if err := tx.indexThing(thingID, previous, thing.Attributes); err != nil {
+			return err
+		}
+		revision = systemThingData.Revision
+		if created {
+			tx.changed(thingID, "", ChangeCreated, revision)
+		} else {
+			tx.changed(thingID, "", ChangeModified, revision)
+		}
+		return tx.updateThingIDs(thingID, true)
+	})
+	if err != nil {
+		return -1, err
 	}
-	return systemThingData.Revision, err
+	return revision, nil
 }
 
 func (storage *thingsDB) GetThing(thingID string, thing *model.Thing) error {
@@ -215,14 +860,10 @@ func (storage *thingsDB) GetThing(thingID string, thing *model.Thing) error {
 			systemThingData.Value(thing)
 		}
 
-		var featuresData []interface{}
-		featuresData, err = storage.db.GetAllAs(data.FeaturesKeyPrefix(thingID), &data.FeatureData{})
-		if err == nil {
-			for _, val := range featuresData {
-				feature := model.Feature{}
-				val.(*data.FeatureData).Value(&feature)
-				thing.WithFeature(val.(*data.FeatureData).ID, &feature)
-			}
+		if err = storage.forEachFeature(thingID, func(featureID string, feature *model.Feature) (bool, error) {
+			thing.WithFeature(featureID, feature)
+			return true, nil
+		}); err == nil {
 			return nil
 		}
 	}
@@ -230,6 +871,75 @@ func (storage *thingsDB) GetThing(thingID string, thing *model.Thing) error {
 	return errors.Wrapf(err, "thing with ID '%s' could not be loaded", thingID)
 }
 
+func (storage *thingsDB) GetThingFeatures(thingID string, thing *model.Thing, featureIDs ...string) error {
+	err := storage.GetThingData(thingID, thing)
+	if err != nil {
+		return err
+	}
+
+	for _, featureID := range featureIDs {
+		featureData := data.FeatureData{}
+		if err = storage.db.GetAs(data.FeatureKey(thingID, featureID), &featureData); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return errors.Wrapf(err, "thing with ID '%s' could not be loaded", thingID)
+		}
+		feature := model.Feature{}
+		featureData.Value(&feature)
+		thing.WithFeature(featureID, &feature)
+	}
+	return nil
+}
+
+func (storage *thingsDB) GetFeatureIDs(thingID string) ([]string, error) {
+	var err error
+	if _, err = storage.loadSystemThingData(thingID); err == nil {
+		var featureIDs []string
+		if featureIDs, err = storage.featureIDs(thingID); err == nil {
+			return featureIDs, nil
+		}
+	}
+	return nil, errors.Wrapf(err, "feature IDs of thing with ID '%s' could not be loaded", thingID)
+}
+
+func (storage *thingsDB) featureIDs(thingID string) ([]string, error) {
+	prefix := data.FeaturesKeyPrefix(thingID)
+	keys, err := storage.db.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	featureIDs := make([]string, len(keys))
+	for i, key := range keys {
+		featureIDs[i] = key[len(prefix):]
+	}
+	return featureIDs, nil
+}
+
+func (storage *thingsDB) ForEachFeature(
+	thingID string, f func(featureID string, feature *model.Feature) (bool, error),
+) error {
+	var err error
+	if _, err = storage.loadSystemThingData(thingID); err == nil {
+		if err = storage.forEachFeature(thingID, f); err == nil {
+			return nil
+		}
+	}
+	return errors.Wrapf(err, "features of thing with ID '%s' could not be iterated", thingID)
+}
+
+func (storage *thingsDB) forEachFeature(
+	thingID string, f func(featureID string, feature *model.Feature) (bool, error),
+) error {
+	return storage.db.ForEachAs(data.FeaturesKeyPrefix(thingID), &data.FeatureData{},
+		func(_ string, value interface{}) (bool, error) {
+			featureData := value.(*data.FeatureData)
+			feature := model.Feature{}
+			featureData.Value(&feature)
+			return f(featureData.ID, &feature)
+		})
+}
+
 func (storage *thingsDB) GetThingData(thingID string, thing *model.Thing) error {
 	thingData, systemThingData, err := storage.loadThingData(thingID)
 
@@ -252,28 +962,64 @@ func (storage *thingsDB) GetSystemThingData(thingID string) (*data.SystemThingDa
 }
 
 func (storage *thingsDB) RemoveThing(thingID string) error {
-	var err error
-	if _, err = storage.loadSystemThingData(thingID); err == nil {
-		if err = storage.db.Delete(thingID); err == nil {
-			if err = storage.db.DeleteAll(data.FeaturesKeyPrefix(thingID)); err == nil {
-				storage.db.DeleteAll(data.SystemThingKey(thingID))
-				storage.updateThingIDs(thingID, false)
-			}
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.loadSystemThingData(thingID)
+		if err != nil {
+			return err
 		}
-	}
+		if err := tx.removeThingData(thingID); err != nil {
+			return err
+		}
+		if err := tx.addTombstone(thingID, systemThingData.Revision); err != nil {
+			return err
+		}
+		tx.changed(thingID, "", ChangeDeleted, systemThingData.Revision)
+		return tx.updateThingIDs(thingID, false)
+	})
 	return errors.Wrapf(err, "thing data for ID '%s' could not be deleted", thingID)
 }
 
+// removeThingData removes the thing data, its features, its system data and its attribute index entries.
+func (storage *thingsDB) removeThingData(thingID string) error {
+	if err := storage.unindexThing(thingID); err != nil {
+		return err
+	}
+	if err := storage.db.Delete(thingID); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := storage.db.DeleteAll(data.FeaturesKeyPrefix(thingID)); err != nil {
+		return err
+	}
+	return storage.db.Delete(data.SystemThingKey(thingID))
+}
+
 func (storage *thingsDB) AddFeature(thingID string, featureID string, feature *model.Feature) (int64, error) {
-	systemThingData, err := storage.updateSystemThingData(thingID)
-	if err == nil {
-		if revision, err := storage.persistFeature(featureID, feature, systemThingData); err == nil {
-			return revision, nil
+	revision := int64(-1)
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.updateSystemThingData(thingID)
+		if err != nil {
+			return err
+		}
+		if err := tx.checkFeature(thingID, featureID, feature); err != nil {
+			return err
 		}
+		change := ChangeModified
+		if tx.watchers.active() {
+			if _, err := tx.db.Get(data.FeatureKey(thingID, featureID)); errors.Is(err, ErrNotFound) {
+				change = ChangeCreated
+			}
+		}
+		if revision, err = tx.persistFeature(featureID, feature, systemThingData); err != nil {
+			return err
+		}
+		tx.changed(thingID, featureID, change, systemThingData.Revision)
+		return nil
+	})
+	if err != nil {
+		return -1, errors.Wrapf(err,
+			"feature with ID '%s' on the thing with ID '%s' could not be stored", featureID, thingID)
 	}
-
-	return -1, errors.Wrapf(err,
-		"feature with ID '%s' on the thing with ID '%s' could not be stored", featureID, thingID)
+	return revision, nil
 }
 
 func (storage *thingsDB) GetFeature(thingID string, featureID string, feature *model.Feature) error {
@@ -295,67 +1041,222 @@ func (storage *thingsDB) GetFeature(thingID string, featureID string, feature *m
 		"feature with ID '%s' on the thing with ID '%s' could not be loaded", featureID, thingID)
 }
 
+func (storage *thingsDB) GetTimestamps(thingID string) (*ThingTimestamps, error) {
+	systemThingData, err := storage.loadSystemThingData(thingID)
+	if err == nil {
+		timestamps := &ThingTimestamps{
+			Timestamps: Timestamps{
+				Created:  systemThingData.Created,
+				Modified: systemThingData.Timestamp,
+				Revision: systemThingData.Revision,
+			},
+			Features: make(map[string]Timestamps),
+		}
+		if err = storage.db.ForEachAs(data.FeaturesKeyPrefix(thingID), &data.FeatureData{},
+			func(_ string, value interface{}) (bool, error) {
+				featureData := value.(*data.FeatureData)
+				timestamps.Features[featureData.ID] = Timestamps{
+					Created:  featureData.Created,
+					Modified: featureData.Modified,
+					Revision: featureData.Revision,
+				}
+				return true, nil
+			}); err == nil {
+			return timestamps, nil
+		}
+	}
+	return nil, errors.Wrapf(err, "timestamps of thing with ID '%s' could not be loaded", thingID)
+}
+
+func (storage *thingsDB) GetFeatureTimestamps(thingID string, featureID string) (*Timestamps, error) {
+	var err error
+	if _, err = storage.loadSystemThingData(thingID); err == nil {
+		featureData := data.FeatureData{}
+		if err = storage.db.GetAs(data.FeatureKey(thingID, featureID), &featureData); err == nil {
+			return &Timestamps{
+				Created:  featureData.Created,
+				Modified: featureData.Modified,
+				Revision: featureData.Revision,
+			}, nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			err = ErrFeatureNotFound
+		}
+	}
+	return nil, errors.Wrapf(err, "timestamps of feature with ID '%s' on the thing with ID '%s' could not be loaded",
+		featureID, thingID)
+}
+
 func (storage *thingsDB) RemoveFeature(thingID string, featureID string) error {
-	systemThingData, err := storage.updateSystemThingData(thingID)
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.updateSystemThingData(thingID)
+		if err != nil {
+			return err
+		}
 
-	if err == nil {
 		featureKey := data.FeatureKey(thingID, featureID)
-		if _, err = storage.db.Get(featureKey); err == ErrNotFound {
-			err = ErrFeatureNotFound
-		} else {
-			if err = storage.db.Delete(featureKey); err == nil {
-				systemThingData.DeletedFeatures[featureID] = nil
-				delete(systemThingData.UnsynchronizedFeatures, featureID)
-				storage.db.SetAs(systemThingData.Key(), systemThingData)
-				return nil
+		if _, err := tx.db.Get(featureKey); err != nil {
+			if err == ErrNotFound {
+				return ErrFeatureNotFound
 			}
+			return err
 		}
-	}
+		if err := tx.db.Delete(featureKey); err != nil {
+			return err
+		}
+		systemThingData.DeletedFeatures[featureID] = nil
+		delete(systemThingData.UnsynchronizedFeatures, featureID)
+		delete(systemThingData.UnsynchronizedDefinitions, featureID)
+		delete(systemThingData.UnsynchronizedDesired, featureID)
+		delete(systemThingData.RemovedProperties, featureID)
+		if err := tx.db.SetAs(systemThingData.Key(), systemThingData); err != nil {
+			return err
+		}
+		tx.changed(thingID, featureID, ChangeDeleted, systemThingData.Revision)
+		return nil
+	})
 	return errors.Wrapf(err,
 		"feature with ID '%s' on the thing with ID '%s' could not be deleted", featureID, thingID)
 }
 
-func (storage *thingsDB) ThingSynchronized(thingID string, revision int64) (bool, error) {
-	systemThingData, err := storage.loadSystemThingData(thingID)
-	if err != nil {
-		return false, err
+func (storage *thingsDB) UpdateMetadata(thingID string, featureID string, metadata map[string]interface{}) error {
+	if len(featureID) == 0 {
+		err := storage.update(func(tx *thingsDB) error {
+			thingData := data.ThingData{}
+			if err := tx.db.GetAs(thingID, &thingData); err != nil {
+				if err == ErrNotFound {
+					return ErrThingNotFound
+				}
+				return err
+			}
+			thingData.Metadata = mergeMetadata(thingData.Metadata, metadata)
+			return tx.db.SetAs(thingData.Key(), thingData.Data())
+		})
+		return errors.Wrapf(err, "metadata of thing with ID '%s' could not be updated", thingID)
 	}
 
-	if revision == systemThingData.Revision {
+	err := storage.update(func(tx *thingsDB) error {
+		if _, err := tx.loadSystemThingData(thingID); err != nil {
+			return err
+		}
+		featureData := data.FeatureData{}
+		if err := tx.db.GetAs(data.FeatureKey(thingID, featureID), &featureData); err != nil {
+			if err == ErrNotFound {
+				return ErrFeatureNotFound
+			}
+			return err
+		}
+		featureData.Metadata = mergeMetadata(featureData.Metadata, metadata)
+		return tx.db.SetAs(featureData.Key(), featureData.Data())
+	})
+	return errors.Wrapf(err,
+		"metadata of feature with ID '%s' on the thing with ID '%s' could not be updated", featureID, thingID)
+}
+
+func mergeMetadata(current map[string]interface{}, metadata map[string]interface{}) map[string]interface{} {
+	if current == nil {
+		current = make(map[string]interface{}, len(metadata))
+	}
+	for key, value := range metadata {
+		patch, isPatch := value.(map[string]interface{})
+		target, isTarget := current[key].(map[string]interface{})
+		if value == nil {
+			delete(current, key)
+		} else if isPatch && isTarget {
+			current[key] = mergeMetadata(target, patch)
+		} else {
+			current[key] = value
+		}
+	}
+	return current
+}
+
+func (storage *thingsDB) ThingSynchronized(thingID string, revision int64) (bool, error) {
+	synchronized := false
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.loadSystemThingData(thingID)
+		if err != nil {
+			return err
+		}
+		if revision != systemThingData.Revision {
+			return nil
+		}
+
 		systemThingData.DeletedFeatures = make(map[string]interface{})
 		systemThingData.UnsynchronizedFeatures = make(map[string]int64)
-		if err = storage.db.SetAs(systemThingData.Key(), systemThingData.Data()); err != nil {
-			return false, errors.Wrapf(err, "thing system data for ID '%s' could not be updated", thingID)
+		systemThingData.UnsynchronizedDefinitions = nil
+		systemThingData.UnsynchronizedDesired = nil
+		systemThingData.UnsynchronizedAttributes = 0
+		systemThingData.RemovedProperties = nil
+		systemThingData.RemovedAttributes = nil
+		if err = tx.db.SetAs(systemThingData.Key(), systemThingData.Data()); err != nil {
+			return errors.Wrapf(err, "thing system data for ID '%s' could not be updated", thingID)
 		}
-		return true, nil
-	}
-	return false, nil
+		synchronized = true
+		return nil
+	})
+	return synchronized && err == nil, err
 }
 
 func (storage *thingsDB) FeatureSynchronized(thingID string, featureID string, revision int64) (bool, error) {
-	systemThingData, err := storage.loadSystemThingData(thingID)
-	if err != nil {
-		return false, err
-	}
+	synchronized := false
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.loadSystemThingData(thingID)
+		if err != nil {
+			return err
+		}
 
-	rev, ok := systemThingData.UnsynchronizedFeatures[featureID]
-	if !ok {
-		if _, deleted := systemThingData.DeletedFeatures[featureID]; !deleted {
-			return true, nil
+		rev, ok := systemThingData.UnsynchronizedFeatures[featureID]
+		if !ok {
+			synchronized = true
+			if _, deleted := systemThingData.DeletedFeatures[featureID]; !deleted {
+				return nil
+			}
+			delete(systemThingData.DeletedFeatures, featureID)
+
+		} else if rev != revision {
+			return nil
+
+		} else {
+			synchronized = true
+			delete(systemThingData.UnsynchronizedFeatures, featureID)
+			delete(systemThingData.UnsynchronizedDefinitions, featureID)
+			delete(systemThingData.UnsynchronizedDesired, featureID)
+			delete(systemThingData.RemovedProperties, featureID)
 		}
-		delete(systemThingData.DeletedFeatures, featureID)
 
-	} else if rev != revision {
-		return false, nil
+		if err = tx.db.SetAs(systemThingData.Key(), systemThingData.Data()); err != nil {
+			return errors.Wrapf(err, "thing system data for ID '%s' could not be updated", thingID)
+		}
+		return nil
+	})
+	return synchronized && err == nil, err
+}
 
-	} else {
-		delete(systemThingData.UnsynchronizedFeatures, featureID)
-	}
+func (storage *thingsDB) AttributesSynchronized(thingID string, revision int64) (bool, error) {
+	synchronized := false
+	err := storage.update(func(tx *thingsDB) error {
+		systemThingData, err := tx.loadSystemThingData(thingID)
+		if err != nil {
+			return err
+		}
+		if systemThingData.UnsynchronizedAttributes == 0 {
+			synchronized = true
+			return nil
+		}
+		if systemThingData.UnsynchronizedAttributes != revision {
+			return nil
+		}
 
-	if err = storage.db.SetAs(systemThingData.Key(), systemThingData.Data()); err != nil {
-		return false, errors.Wrapf(err, "thing system data for ID '%s' could not be updated", thingID)
-	}
-	return true, nil
+		systemThingData.UnsynchronizedAttributes = 0
+		systemThingData.RemovedAttributes = nil
+		if err = tx.db.SetAs(systemThingData.Key(), systemThingData.Data()); err != nil {
+			return errors.Wrapf(err, "thing system data for ID '%s' could not be updated", thingID)
+		}
+		synchronized = true
+		return nil
+	})
+	return synchronized && err == nil, err
 }
 
 func (storage *thingsDB) loadThingData(thingID string) (*data.ThingData, *data.SystemThingData, error) {
@@ -396,14 +1297,32 @@ func (storage *thingsDB) persistThingData(
 	persistData[systemThingData.Key()] = systemThingData.Data()
 
 	systemThingData.UnsynchronizedFeatures = make(map[string]int64)
-	if prevFeatures, err := storage.db.GetAllAs(data.FeaturesKeyPrefix(thingData.ID), &data.FeatureData{}); err == nil {
-		for _, val := range prevFeatures {
-			systemThingData.DeletedFeatures[val.(*data.FeatureData).ID] = nil
-		}
-	}
+	previous := make(map[string]*data.FeatureData)
+	storage.db.ForEachAs(data.FeaturesKeyPrefix(thingData.ID), &data.FeatureData{},
+		func(_ string, value interface{}) (bool, error) {
+			featureData := value.(*data.FeatureData)
+			systemThingData.DeletedFeatures[featureData.ID] = nil
+			previous[featureData.ID] = featureData
+			return true, nil
+		})
 
 	for featureID, feature := range features {
-		putFeatureData(persistData, featureID, feature, systemThingData)
+		putFeatureData(persistData, featureID, feature, systemThingData, previous[featureID])
+	}
+	for featureID := range systemThingData.UnsynchronizedDefinitions {
+		if _, ok := features[featureID]; !ok {
+			delete(systemThingData.UnsynchronizedDefinitions, featureID)
+		}
+	}
+	for featureID := range systemThingData.UnsynchronizedDesired {
+		if _, ok := features[featureID]; !ok {
+			delete(systemThingData.UnsynchronizedDesired, featureID)
+		}
+	}
+	for featureID := range systemThingData.RemovedProperties {
+		if _, ok := features[featureID]; !ok {
+			delete(systemThingData.RemovedProperties, featureID)
+		}
 	}
 
 	return storage.persistAll(thingData.ID, persistData)
@@ -411,13 +1330,68 @@ func (storage *thingsDB) persistThingData(
 
 func putFeatureData(
 	persistData map[string]interface{}, featureID string,
-	feature *model.Feature, systemThingData *data.SystemThingData,
+	feature *model.Feature, systemThingData *data.SystemThingData, previous *data.FeatureData,
 ) {
 	featureData := featureData(systemThingData.ID, featureID, feature)
+	featureTimestamps(featureData, previous, systemThingData.Revision, systemThingData.Timestamp)
 	persistData[featureData.Key()] = featureData.Data()
 
 	delete(systemThingData.DeletedFeatures, featureID)
 	systemThingData.UnsynchronizedFeatures[featureID] = systemThingData.UnsynchronizedFeatures[featureID] + 1
+
+	if (previous != nil || len(featureData.DesiredProperties) > 0) &&
+		(previous == nil || !sameValues(featureData.DesiredProperties, previous.DesiredProperties)) {
+		if systemThingData.UnsynchronizedDesired == nil {
+			systemThingData.UnsynchronizedDesired = make(map[string]interface{})
+		}
+		systemThingData.UnsynchronizedDesired[featureID] = nil
+	}
+
+	if previous != nil {
+		if removed := jsonutil.MergeRemovals(previous.Properties, featureData.Properties); removed != nil {
+			if systemThingData.RemovedProperties == nil {
+				systemThingData.RemovedProperties = make(map[string]map[string]interface{})
+			}
+			systemThingData.RemovedProperties[featureID] =
+				jsonutil.AddRemovals(systemThingData.RemovedProperties[featureID], removed)
+		}
+	}
+
+	if previous == nil && len(featureData.Definition) == 0 {
+		return
+	}
+	if previous == nil || !sameDefinition(featureData.Definition, previous.Definition) {
+		if systemThingData.UnsynchronizedDefinitions == nil {
+			systemThingData.UnsynchronizedDefinitions = make(map[string]interface{})
+		}
+		systemThingData.UnsynchronizedDefinitions[featureID] = nil
+	}
+}
+
+// sameValues returns true if the JSON objects, e.g. the desired properties, are equal regardless of
+// their numbers encoding.
+func sameValues(values map[string]interface{}, previous map[string]interface{}) bool {
+	if len(values) == 0 || len(previous) == 0 {
+		return len(values) == len(previous)
+	}
+	encoded, err := jsonutil.CanonicalJSON(values)
+	if err != nil {
+		return false
+	}
+	encodedPrevious, err := jsonutil.CanonicalJSON(previous)
+	return err == nil && bytes.Equal(encoded, encodedPrevious)
+}
+
+func sameDefinition(definition []string, previous []string) bool {
+	if len(definition) != len(previous) {
+		return false
+	}
+	for i := range definition {
+		if definition[i] != previous[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (storage *thingsDB) persistAll(thingID string, values map[string]interface{}) error {
@@ -430,6 +1404,7 @@ func (storage *thingsDB) persistAll(thingID string, values map[string]interface{
 func updateThingData(data *data.ThingData, thingID string, thing *model.Thing) {
 	data.ID = thingID
 	data.Attributes = thing.Attributes
+	data.Metadata = thing.Metadata
 	if thing.PolicyID != nil {
 		data.PolicyID = thing.PolicyID.String()
 	}
@@ -449,7 +1424,9 @@ func (storage *thingsDB) updateSystemThingData(thingID string) (*data.SystemThin
 
 func updateSystemThingData(systemThingData *data.SystemThingData) {
 	systemThingData.Revision = systemThingData.Revision + 1
-	systemThingData.Timestamp = time.Now().Format(time.RFC3339)
+	timestamp, quality := systemClock.Now()
+	systemThingData.Timestamp = timestamp.Format(time.RFC3339)
+	systemThingData.TimestampQuality = quality
 }
 
 func (storage *thingsDB) updateThingIDs(thingID string, present bool) error {
@@ -472,7 +1449,12 @@ func (storage *thingsDB) persistFeature(featureID string, feature *model.Feature
 ) (int64, error) {
 	persistData := make(map[string]interface{})
 
-	putFeatureData(persistData, featureID, feature, systemThingData)
+	var previous *data.FeatureData
+	previousData := data.FeatureData{}
+	if err := storage.db.GetAs(data.FeatureKey(systemThingData.ID, featureID), &previousData); err == nil {
+		previous = &previousData
+	}
+	putFeatureData(persistData, featureID, feature, systemThingData, previous)
 	persistData[systemThingData.Key()] = systemThingData.Data()
 
 	return systemThingData.UnsynchronizedFeatures[featureID], storage.db.SetAllAs(persistData)
@@ -484,6 +1466,7 @@ func featureData(thingID string, featureID string, feature *model.Feature) *data
 		ThingID:           thingID,
 		Properties:        feature.Properties,
 		DesiredProperties: feature.DesiredProperties,
+		Metadata:          featureMetadata(feature),
 	}
 	definitions := feature.Definition
 	var dataDefinitions []string
@@ -493,3 +1476,75 @@ func featureData(thingID string, featureID string, feature *model.Feature) *data
 	fData.Definition = dataDefinitions
 	return fData
 }
+
+// featureMetadata returns the feature metadata kept parallel to the feature properties, i.e. without the metadata
+// of the properties and of the desired properties that are not present, e.g. once deleted.
+func featureMetadata(feature *model.Feature) map[string]interface{} {
+	if len(feature.Metadata) == 0 {
+		return feature.Metadata
+	}
+
+	metadata := make(map[string]interface{}, len(feature.Metadata))
+	for key, value := range feature.Metadata {
+		metadata[key] = value
+	}
+	pruneMetadata(metadata, metadataProperties, feature.Properties)
+	pruneMetadata(metadata, metadataDesiredProperties, feature.DesiredProperties)
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+func pruneMetadata(metadata map[string]interface{}, key string, properties map[string]interface{}) {
+	propertiesMetadata, ok := metadata[key].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	pruned := make(map[string]interface{}, len(propertiesMetadata))
+	for name, value := range propertiesMetadata {
+		if _, ok := properties[name]; ok {
+			pruned[name] = value
+		}
+	}
+	if len(pruned) == 0 {
+		delete(metadata, key)
+	} else {
+		metadata[key] = pruned
+	}
+}
+
+// featureTimestamps sets the creation timestamp of the feature data to the one of the previously stored data, if any,
+// and the modification timestamp and revision to the provided ones, unless the feature definition and properties
+// are unchanged.
+func featureTimestamps(featureData *data.FeatureData, previous *data.FeatureData, revision int64, timestamp string) {
+	if previous == nil {
+		featureData.Created = timestamp
+		featureData.Modified = timestamp
+		featureData.Revision = revision
+		return
+	}
+
+	featureData.Created = previous.Created
+	if sameFeatureState(featureData, previous) {
+		featureData.Modified = previous.Modified
+		featureData.Revision = previous.Revision
+	} else {
+		featureData.Modified = timestamp
+		featureData.Revision = revision
+	}
+}
+
+func sameFeatureState(featureData *data.FeatureData, previous *data.FeatureData) bool {
+	current, err := json.Marshal([]interface{}{
+		featureData.Definition, featureData.Properties, featureData.DesiredProperties,
+	})
+	if err != nil {
+		return false
+	}
+	stored, err := json.Marshal([]interface{}{
+		previous.Definition, previous.Properties, previous.DesiredProperties,
+	})
+	return err == nil && string(current) == string(stored)
+}
@@ -13,6 +13,7 @@
 package persistence_test
 
 import (
+	"bytes"
 	"errors"
 	"os"
 	"testing"
@@ -64,6 +65,12 @@ func TestInitStorageOnDeviceChange(t *testing.T) {
 	// assert expected db files
 	entries, _ = os.ReadDir(dbDir)
 	assert.Equal(t, 3, len(entries), "current", deviceID, newDeviceID)
+
+	// the existing device backup is kept
+	db = assertDBDevice(t, location, newDeviceID)
+	require.NoError(t, db.Close())
+	entries, _ = os.ReadDir(dbDir)
+	assert.Equal(t, 4, len(entries), "current", deviceID, deviceID+" rotated", newDeviceID)
 }
 
 func assertDBDevice(t *testing.T, path string, deviceID string) persistence.ThingsStorage {
@@ -84,3 +91,142 @@ func assertThing(t *testing.T, db persistence.ThingsStorage, thingID string, pre
 		assert.True(t, errors.Is(err, persistence.ErrThingNotFound), thingID, err)
 	}
 }
+
+func TestInitStorageOnCorruption(t *testing.T) {
+	deviceID := "org.eclipse.kanto:TestInitStorageOnCorruption"
+	dbDir := "test"
+
+	for _, engine := range []string{persistence.EngineBolt, persistence.EngineSQLite} {
+		t.Run(engine, func(t *testing.T) {
+			require.NoError(t, os.MkdirAll(dbDir, 0700))
+			defer os.RemoveAll(dbDir)
+
+			location := dbDir + "/TestInitStorageOnCorruption.db"
+			require.NoError(t, os.WriteFile(location, bytes.Repeat([]byte("corrupted"), 1024), 0600))
+
+			db, err := persistence.NewThingsStorage(engine, location, deviceID)
+			require.NoError(t, err)
+			defer db.Close()
+
+			assert.Equal(t, deviceID, db.GetDeviceID())
+			assert.FileExists(t, db.Recovered())
+			ids, err := db.GetThingIDs()
+			require.NoError(t, err)
+			assert.Empty(t, ids)
+		})
+	}
+}
+
+func TestInitPartitionedStorageOnDeviceChange(t *testing.T) {
+	deviceID := "org.eclipse.kanto:TestInitPartitionedStorage"
+	newDeviceID := deviceID + "_new"
+	dbDir := "test"
+
+	for _, engine := range []string{persistence.EngineBolt, persistence.EngineSQLite} {
+		t.Run(engine, func(t *testing.T) {
+			require.NoError(t, os.MkdirAll(dbDir, 0700))
+			defer os.RemoveAll(dbDir)
+
+			location := dbDir + "/TestInitPartitionedStorage.db"
+			thingID := "org.eclipse.kanto:testThing"
+			newThingID := "org.eclipse.kanto:testNewThing"
+
+			db, err := persistence.NewPartitionedThingsStorage(engine, location, deviceID)
+			require.NoError(t, err)
+			_, err = db.AddThing((&model.Thing{}).WithIDFrom(thingID))
+			require.NoError(t, err)
+			require.NoError(t, db.Close())
+
+			db, err = persistence.NewPartitionedThingsStorage(engine, location, newDeviceID)
+			require.NoError(t, err)
+			assert.Equal(t, newDeviceID, db.GetDeviceID())
+			assertThing(t, db, thingID, false)
+			_, err = db.AddThing((&model.Thing{}).WithIDFrom(newThingID))
+			require.NoError(t, err)
+			ids, err := db.GetThingIDs()
+			require.NoError(t, err)
+			assert.Equal(t, []string{newThingID}, ids)
+			require.NoError(t, db.Reopen())
+			assertThing(t, db, newThingID, true)
+			require.NoError(t, db.Close())
+
+			// the unpartitioned data of the initial device is kept
+			db, err = persistence.NewThingsStorage(engine, location, deviceID)
+			require.NoError(t, err)
+			assertThing(t, db, thingID, true)
+			assertThing(t, db, newThingID, false)
+			ids, err = db.GetThingIDs()
+			require.NoError(t, err)
+			assert.Equal(t, []string{thingID}, ids)
+			require.NoError(t, db.Close())
+
+			db, err = persistence.NewPartitionedThingsStorage(engine, location, newDeviceID)
+			require.NoError(t, err)
+			assertThing(t, db, newThingID, true)
+			require.NoError(t, db.Close())
+
+			// the database file is not moved aside
+			entries, err := os.ReadDir(dbDir)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.NotContains(t, entry.Name(), "_new")
+			}
+		})
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	deviceID := "org.eclipse.kanto:TestPartitions"
+	dbDir := "test"
+	require.NoError(t, os.MkdirAll(dbDir, 0700))
+	defer os.RemoveAll(dbDir)
+
+	partitions, err := persistence.OpenPartitions(dbDir+"/TestPartitions.db",
+		persistence.StorageOptions{Engine: persistence.EngineBolt})
+	require.NoError(t, err)
+	defer partitions.Close()
+
+	devices := []string{deviceID + "_1", deviceID + "_2", deviceID + "_3"}
+	storages := make([]persistence.ThingsStorage, len(devices))
+	for i, device := range devices {
+		storages[i], err = partitions.Storage(device)
+		require.NoError(t, err)
+		assert.Equal(t, device, storages[i].GetDeviceID())
+
+		thing := (&model.Thing{}).WithIDFrom(device).
+			WithFeature("meter", (&model.Feature{}).WithProperty("x", i))
+		_, err = storages[i].AddThing(thing)
+		require.NoError(t, err)
+	}
+
+	ids, err := partitions.DeviceIDs()
+	require.NoError(t, err)
+	assert.Equal(t, devices, ids)
+
+	page, err := storages[1].GetThingIDsPage("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{devices[1]}, page.ThingIDs)
+	count, err := storages[2].CountThings()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	for i, device := range devices {
+		for j, other := range devices {
+			assertThing(t, storages[i], other, i == j)
+		}
+		featureIDs, err := storages[i].GetFeatureIDs(device)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"meter"}, featureIDs)
+
+		require.NoError(t, storages[i].RemoveThing(device))
+		assertThing(t, storages[i], device, false)
+		require.NoError(t, storages[i].Close())
+	}
+
+	// closing a device storage does not close the shared database file
+	storage, err := partitions.Storage(devices[0])
+	require.NoError(t, err)
+	ids, err = storage.GetThingIDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
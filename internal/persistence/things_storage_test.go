@@ -13,11 +13,13 @@
 package persistence_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
@@ -39,14 +41,23 @@ const (
 type PersistenceTestSuite struct {
 	suite.Suite
 	storage persistence.ThingsStorage
+	engine  string
 }
 
 func TestPersistenceTestSuite(t *testing.T) {
 	suite.Run(t, new(PersistenceTestSuite))
 }
 
+func TestPersistenceSQLiteTestSuite(t *testing.T) {
+	suite.Run(t, &PersistenceTestSuite{engine: persistence.EngineSQLite})
+}
+
+func TestPersistenceMemoryTestSuite(t *testing.T) {
+	suite.Run(t, &PersistenceTestSuite{engine: persistence.EngineMemory})
+}
+
 func (s *PersistenceTestSuite) SetupSuite() {
-	db, err := persistence.NewThingsDB(dbLocation, testThingID)
+	db, err := persistence.NewThingsStorage(s.engine, dbLocation, testThingID)
 	require.NoError(s.T(), err)
 	assert.Equal(s.T(), testThingID, db.GetDeviceID())
 	s.storage = db
@@ -111,6 +122,19 @@ func (s *PersistenceTestSuite) assertErrorsDatabaseClosed() {
 	ok, err = s.storage.FeatureSynchronized(thingID, featureID, 1)
 	assert.True(s.T(), errors.Is(err, persistence.ErrDatabaseClosed), err)
 	assert.False(s.T(), ok)
+
+	err = s.storage.UpdateMetadata(thingID, featureID, map[string]interface{}{"key": "value"})
+	assert.True(s.T(), errors.Is(err, persistence.ErrDatabaseClosed), err)
+
+	err = s.storage.ForEachFeature(thingID, func(featureID string, feature *model.Feature) (bool, error) {
+		return true, nil
+	})
+	assert.True(s.T(), errors.Is(err, persistence.ErrDatabaseClosed), err)
+
+	err = s.storage.Batch(func(storage persistence.ThingsStorage) error {
+		return nil
+	})
+	assert.True(s.T(), errors.Is(err, persistence.ErrDatabaseClosed), err)
 }
 
 func (s *PersistenceTestSuite) TestThingNotFound() {
@@ -165,6 +189,46 @@ func (s *PersistenceTestSuite) TestGetThingIDs() {
 	assert.Equal(s.T(), 1, len(ids), ids)
 }
 
+func (s *PersistenceTestSuite) TestGetThingIDsPage() {
+	thingIDs := []string{testThingID + "0", testThingID + "1", testThingID + "2", testThingID + "3", testThingID + "4"}
+	for _, thingID := range []string{thingIDs[3], thingIDs[0], thingIDs[4], thingIDs[2], thingIDs[1]} {
+		_, err := s.storage.AddThing(createThing(thingID))
+		require.NoError(s.T(), err)
+		defer s.storage.RemoveThing(thingID)
+	}
+
+	count, err := s.storage.CountThings()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), len(thingIDs), count)
+
+	page, err := s.storage.GetThingIDsPage("", 2)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: thingIDs[:2], Next: thingIDs[1]}, page)
+
+	page, err = s.storage.GetThingIDsPage(page.Next, 2)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: thingIDs[2:4], Next: thingIDs[3]}, page)
+
+	page, err = s.storage.GetThingIDsPage(page.Next, 2)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: thingIDs[4:]}, page)
+
+	// the cursor thing could be removed meanwhile
+	require.NoError(s.T(), s.storage.RemoveThing(thingIDs[1]))
+	page, err = s.storage.GetThingIDsPage(thingIDs[1], 0)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), &persistence.ThingIDsPage{ThingIDs: thingIDs[2:]}, page)
+
+	page, err = s.storage.GetThingIDsPage(thingIDs[4], 2)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), page.ThingIDs)
+	assert.Empty(s.T(), page.Next)
+
+	count, err = s.storage.CountThings()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), len(thingIDs)-1, count)
+}
+
 func (s *PersistenceTestSuite) TestAddThing() {
 	thingID := testThingID + "_TestAddThing"
 	thing := createThing(thingID)
@@ -260,6 +324,130 @@ func (s *PersistenceTestSuite) TestDeleteThing() {
 	require.Nil(s.T(), systemData)
 }
 
+func (s *PersistenceTestSuite) TestDeleteThingTombstone() {
+	thingID := testThingID + "_TestDeleteThingTombstone"
+
+	revision, err := s.storage.AddThing(createThing(thingID))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.RemoveThing(thingID))
+
+	tombstones := &data.TombstonesData{}
+	require.NoError(s.T(), s.storage.GetTombstones(tombstones))
+	require.Contains(s.T(), tombstones.Things, thingID)
+	assert.Equal(s.T(), revision, tombstones.Things[thingID].Revision)
+	assert.NotEmpty(s.T(), tombstones.Things[thingID].Deleted)
+
+	// the tombstone of another deletion revision is kept
+	ok, err := s.storage.PurgeTombstone(thingID, revision+1)
+	require.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+
+	ok, err = s.storage.PurgeTombstone(thingID, revision)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+
+	tombstones = &data.TombstonesData{}
+	if err := s.storage.GetTombstones(tombstones); err != nil {
+		require.ErrorIs(s.T(), err, persistence.ErrNotFound)
+	}
+	assert.NotContains(s.T(), tombstones.Things, thingID)
+
+	// the tombstone is removed on the thing creation again
+	_, err = s.storage.AddThing(createThing(thingID))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.RemoveThing(thingID))
+	_, err = s.storage.AddThing(createThing(thingID))
+	require.NoError(s.T(), err)
+	defer s.storage.RemoveThing(thingID)
+
+	tombstones = &data.TombstonesData{}
+	if err := s.storage.GetTombstones(tombstones); err != nil {
+		require.ErrorIs(s.T(), err, persistence.ErrNotFound)
+	}
+	assert.NotContains(s.T(), tombstones.Things, thingID)
+}
+
+func (s *PersistenceTestSuite) TestUnsynchronizedDesiredProperties() {
+	thingID := testThingID + "_TestUnsynchronizedDesired"
+
+	revision, err := s.storage.AddThing(createThing(thingID))
+	require.NoError(s.T(), err)
+	defer s.storage.RemoveThing(thingID)
+
+	system, err := s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), system.UnsynchronizedDesired, testFeatureID1)
+	assert.NotContains(s.T(), system.UnsynchronizedDesired, testFeatureID2)
+
+	ok, err := s.storage.ThingSynchronized(thingID, revision)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+
+	// the desired properties are not modified, regardless of their numbers encoding
+	feature := &model.Feature{}
+	require.NoError(s.T(), s.storage.GetFeature(thingID, testFeatureID1, feature))
+	feature.Properties["prop1"] = "modified"
+	_, err = s.storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+
+	system, err = s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), system.UnsynchronizedFeatures, testFeatureID1)
+	assert.NotContains(s.T(), system.UnsynchronizedDesired, testFeatureID1)
+
+	feature.DesiredProperties["prop1"] = "modified"
+	_, err = s.storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+
+	system, err = s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), system.UnsynchronizedDesired, testFeatureID1)
+
+	ok, err = s.storage.FeatureSynchronized(thingID, testFeatureID1, system.UnsynchronizedFeatures[testFeatureID1])
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+
+	system, err = s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.NotContains(s.T(), system.UnsynchronizedDesired, testFeatureID1)
+}
+
+func (s *PersistenceTestSuite) TestAttributesSynchronized() {
+	thingID := testThingID + "_TestAttributesSynchronized"
+
+	thing := createThing(thingID)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	defer s.storage.RemoveThing(thingID)
+
+	system, err := s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), system.UnsynchronizedAttributes)
+
+	// the unchanged attributes are not marked as modified
+	_, err = s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	thing.Attributes["key1"] = "modified"
+	_, err = s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+
+	system, err = s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), system.UnsynchronizedAttributes)
+
+	ok, err := s.storage.AttributesSynchronized(thingID, 1)
+	require.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+
+	ok, err = s.storage.AttributesSynchronized(thingID, 2)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+
+	system, err = s.storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), system.UnsynchronizedAttributes)
+}
+
 func (s *PersistenceTestSuite) TestAddFeature() {
 	thing := createThing(testThingID)
 	_, err := s.storage.AddThing(thing)
@@ -384,6 +572,764 @@ func (s *PersistenceTestSuite) TestDeleteFeature() {
 	s.assertFeatureSynchState(thingID, testFeatureID1, true)
 }
 
+func (s *PersistenceTestSuite) TestUpdateMetadata() {
+	thing := createThing(testThingID)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+
+	require.NoError(s.T(), s.storage.UpdateMetadata(testThingID, "", map[string]interface{}{
+		"attributes": map[string]interface{}{"key1": map[string]interface{}{"issuedBy": "test"}},
+	}))
+	require.NoError(s.T(), s.storage.UpdateMetadata(testThingID, "", map[string]interface{}{
+		"attributes": map[string]interface{}{"key1": map[string]interface{}{"issuedAt": 1.0}},
+	}))
+	require.NoError(s.T(), s.storage.UpdateMetadata(testThingID, testFeatureID1, map[string]interface{}{
+		"properties": map[string]interface{}{"prop1": map[string]interface{}{"unit": "C"}},
+	}))
+
+	thingLoaded := &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thingLoaded))
+	assert.Equal(s.T(), map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"key1": map[string]interface{}{"issuedBy": "test", "issuedAt": 1.0},
+		},
+	}, thingLoaded.Metadata)
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{"prop1": map[string]interface{}{"unit": "C"}},
+	}, thingLoaded.Features[testFeatureID1].Metadata)
+	assert.Nil(s.T(), thingLoaded.Features[testFeatureID2].Metadata)
+	// metadata update is not a thing modification
+	assert.Equal(s.T(), thing.Revision, thingLoaded.Revision)
+
+	// nil values remove the metadata fields
+	require.NoError(s.T(), s.storage.UpdateMetadata(testThingID, "", map[string]interface{}{
+		"attributes": map[string]interface{}{"key1": map[string]interface{}{"issuedBy": nil}},
+	}))
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thingLoaded))
+	assert.Equal(s.T(), map[string]interface{}{
+		"attributes": map[string]interface{}{"key1": map[string]interface{}{"issuedAt": 1.0}},
+	}, thingLoaded.Metadata)
+
+	err = s.storage.UpdateMetadata(testThingID, "unknown", map[string]interface{}{"key": "value"})
+	assert.True(s.T(), errors.Is(err, persistence.ErrFeatureNotFound), err)
+	err = s.storage.UpdateMetadata("unknown:thing", "", map[string]interface{}{"key": "value"})
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+}
+
+func (s *PersistenceTestSuite) TestFeatureMetadataPruned() {
+	_, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+
+	feature := (&model.Feature{}).
+		WithProperties(map[string]interface{}{"x": 1, "y": 2}).
+		WithDesiredProperties(map[string]interface{}{"x": 3}).
+		WithMetadata(map[string]interface{}{
+			"issuedBy": "test",
+			"properties": map[string]interface{}{
+				"x": map[string]interface{}{"unit": "C"},
+				"z": map[string]interface{}{"unit": "K"},
+			},
+			"desiredProperties": map[string]interface{}{"y": map[string]interface{}{"unit": "C"}},
+		})
+	_, err = s.storage.AddFeature(testThingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+
+	// the metadata of the properties which are not present is not stored
+	stored := &model.Feature{}
+	require.NoError(s.T(), s.storage.GetFeature(testThingID, testFeatureID1, stored))
+	assert.Equal(s.T(), map[string]interface{}{
+		"issuedBy":   "test",
+		"properties": map[string]interface{}{"x": map[string]interface{}{"unit": "C"}},
+	}, stored.Metadata)
+
+	_, err = s.storage.AddFeature(testThingID, testFeatureID1, stored.WithProperties(map[string]interface{}{"y": 2}))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.GetFeature(testThingID, testFeatureID1, stored))
+	assert.Equal(s.T(), map[string]interface{}{"issuedBy": "test"}, stored.Metadata)
+}
+
+func (s *PersistenceTestSuite) TestPendingCommands() {
+	pending := &data.PendingData{}
+	err := s.storage.GetPendingCommands(pending)
+	assert.True(s.T(), errors.Is(err, persistence.ErrNotFound), err)
+
+	persisted := &data.PendingData{Commands: []byte(`[{"kind":"acks"}]`), Reason: "stopped", Stopped: "now"}
+	require.NoError(s.T(), s.storage.SetPendingCommands(persisted))
+	require.NoError(s.T(), s.storage.GetPendingCommands(pending))
+	assert.Equal(s.T(), persisted, pending)
+
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), ids)
+
+	require.NoError(s.T(), s.storage.RemovePendingCommands())
+	err = s.storage.GetPendingCommands(&data.PendingData{})
+	assert.True(s.T(), errors.Is(err, persistence.ErrNotFound), err)
+	require.NoError(s.T(), s.storage.RemovePendingCommands())
+}
+
+func (s *PersistenceTestSuite) TestRetrieves() {
+	retrieves := &data.RetrievesData{}
+	err := s.storage.GetRetrieves(retrieves)
+	assert.True(s.T(), errors.Is(err, persistence.ErrNotFound), err)
+
+	persisted := &data.RetrievesData{Retrieves: map[string]data.RetrieveData{
+		"correlation": {ThingID: testThingID, Issued: "now"},
+	}}
+	require.NoError(s.T(), s.storage.SetRetrieves(persisted))
+	require.NoError(s.T(), s.storage.GetRetrieves(retrieves))
+	assert.Equal(s.T(), persisted, retrieves)
+
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), ids)
+}
+
+func (s *PersistenceTestSuite) TestSyncSession() {
+	session := &data.SyncSessionData{}
+	err := s.storage.GetSyncSession(session)
+	assert.True(s.T(), errors.Is(err, persistence.ErrNotFound), err)
+
+	persisted := &data.SyncSessionData{
+		Started:   "now",
+		Phase:     "sync",
+		Remaining: []string{testThingID},
+		Retrieves: map[string]data.RetrieveData{"correlation": {ThingID: testThingID, Issued: "now"}},
+	}
+	require.NoError(s.T(), s.storage.SetSyncSession(persisted))
+	require.NoError(s.T(), s.storage.GetSyncSession(session))
+	assert.Equal(s.T(), persisted, session)
+
+	require.NoError(s.T(), s.storage.RemoveSyncSession())
+	err = s.storage.GetSyncSession(&data.SyncSessionData{})
+	assert.True(s.T(), errors.Is(err, persistence.ErrNotFound), err)
+	require.NoError(s.T(), s.storage.RemoveSyncSession())
+}
+
+func (s *PersistenceTestSuite) TestStats() {
+	const otherThingID = testThingID + "x"
+	defer s.storage.RemoveThing(otherThingID)
+
+	initial, err := s.storage.Stats()
+	require.NoError(s.T(), err)
+
+	_, err = s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+	_, err = s.storage.AddThing(createThingWithFeatures(otherThingID, testFeatureID1, "feature3"))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.AppendEvent(&data.JournalEntry{
+		ThingID: otherThingID, Revision: 1, Event: make([]byte, 100),
+	}))
+	require.NoError(s.T(), s.storage.SetCompacted(time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)))
+
+	stats, err := s.storage.Stats()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), initial.Things+2, stats.Things)
+	assert.Equal(s.T(), initial.Features+4, stats.Features)
+	assert.Equal(s.T(), initial.JournalSize+100, stats.JournalSize)
+	assert.Equal(s.T(), "2022-03-04T05:06:07Z", stats.Compacted)
+	if s.engine == persistence.EngineMemory {
+		assert.Zero(s.T(), stats.FileSize)
+	} else {
+		assert.True(s.T(), stats.FileSize > 0)
+	}
+}
+
+func (s *PersistenceTestSuite) TestEventsJournal() {
+	const otherThingID = testThingID + "x"
+	for revision := int64(1); revision <= 5; revision++ {
+		require.NoError(s.T(), s.storage.AppendEvent(&data.JournalEntry{
+			ThingID: testThingID, Revision: revision, Event: make([]byte, 100),
+		}))
+	}
+	require.NoError(s.T(), s.storage.AppendEvent(&data.JournalEntry{
+		ThingID: otherThingID, Revision: 1, Event: make([]byte, 100),
+	}))
+
+	entries, err := s.storage.GetEvents(testThingID, 2, 4)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 3)
+	for i, entry := range entries {
+		assert.Equal(s.T(), testThingID, entry.ThingID)
+		assert.Equal(s.T(), int64(i+2), entry.Revision)
+		assert.NotEmpty(s.T(), entry.Timestamp)
+	}
+
+	entries, err = s.storage.GetEvents(testThingID, 0, 0)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 5)
+
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), ids)
+
+	// the oldest events exceeding the size are removed
+	removed, err := s.storage.PruneEvents(persistence.JournalRetention{MaxSize: 400}, time.Now())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, removed)
+
+	entries, err = s.storage.GetEvents(testThingID, 0, 0)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 3)
+	assert.Equal(s.T(), int64(3), entries[0].Revision)
+
+	removed, err = s.storage.PruneEvents(persistence.JournalRetention{MaxAge: time.Hour}, time.Now())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 0, removed)
+
+	removed, err = s.storage.PruneEvents(persistence.JournalRetention{MaxAge: time.Hour}, time.Now().Add(2*time.Hour))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 4, removed)
+
+	entries, err = s.storage.GetEvents(otherThingID, 0, 0)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), entries)
+}
+
+func (s *PersistenceTestSuite) TestReplayQueue() {
+	collect := func() ([]int64, []int64) {
+		var sequences, revisions []int64
+		require.NoError(s.T(), s.storage.ForEachReplayEvent(func(sequence int64, entry *data.JournalEntry) (bool, error) {
+			sequences = append(sequences, sequence)
+			revisions = append(revisions, entry.Revision)
+			return true, nil
+		}))
+		return sequences, revisions
+	}
+
+	for revision := int64(1); revision <= 5; revision++ {
+		dropped, err := s.storage.QueueReplayEvent(&data.JournalEntry{
+			ThingID: testThingID, Revision: revision, Event: []byte("{}"),
+		}, 3)
+		require.NoError(s.T(), err)
+		if revision <= 3 {
+			assert.Equal(s.T(), 0, dropped)
+		} else {
+			assert.Equal(s.T(), 1, dropped)
+		}
+	}
+
+	// the oldest events exceeding the limit are dropped
+	sequences, revisions := collect()
+	require.Len(s.T(), sequences, 3)
+	assert.Equal(s.T(), []int64{3, 4, 5}, revisions)
+
+	require.NoError(s.T(), s.storage.RemoveReplayEvents(sequences[1]))
+	_, revisions = collect()
+	assert.Equal(s.T(), []int64{5}, revisions)
+
+	// the sequence numbers keep increasing after removal
+	_, err := s.storage.QueueReplayEvent(&data.JournalEntry{ThingID: testThingID, Revision: 6}, 0)
+	require.NoError(s.T(), err)
+	next, revisions := collect()
+	assert.Equal(s.T(), []int64{5, 6}, revisions)
+	assert.Equal(s.T(), sequences[2]+1, next[1])
+
+	require.NoError(s.T(), s.storage.RemoveReplayEvents(next[1]))
+	sequences, _ = collect()
+	assert.Empty(s.T(), sequences)
+}
+
+func (s *PersistenceTestSuite) TestPolicies() {
+	const policyID = "org.eclipse.kanto:policy"
+
+	_, err := s.storage.GetPolicy(policyID)
+	assert.ErrorIs(s.T(), err, persistence.ErrPolicyNotFound)
+	assert.ErrorIs(s.T(), s.storage.RemovePolicy(policyID), persistence.ErrNotFound)
+
+	revision, err := s.storage.AddPolicy(policyID, []byte(`{"entries":{}}`))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), revision)
+	revision, err = s.storage.AddPolicy(policyID, []byte(`{"entries":{"DEFAULT":{}}}`))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), revision)
+
+	policy, err := s.storage.GetPolicy(policyID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), policyID, policy.ID)
+	assert.Equal(s.T(), int64(2), policy.Revision)
+	assert.JSONEq(s.T(), `{"entries":{"DEFAULT":{}}}`, string(policy.Policy))
+	assert.NotEmpty(s.T(), policy.Created)
+
+	// the policies are not things
+	ids, err := s.storage.GetPolicyIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{policyID}, ids)
+	thingIDs, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.NotContains(s.T(), thingIDs, policyID)
+
+	require.NoError(s.T(), s.storage.RemovePolicy(policyID))
+	_, err = s.storage.GetPolicy(policyID)
+	assert.ErrorIs(s.T(), err, persistence.ErrPolicyNotFound)
+	ids, err = s.storage.GetPolicyIDs()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), ids)
+}
+
+func (s *PersistenceTestSuite) TestAttributeIndexes() {
+	const otherThingID = testThingID + "x"
+
+	thing := createThing(testThingID)
+	thing.Attributes = map[string]interface{}{"location": "plant-7", "building": map[string]interface{}{"floor": 2}}
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	other := createThing(otherThingID)
+	other.Attributes = map[string]interface{}{"location": "plant-8", "building": map[string]interface{}{"floor": 2.0}}
+	_, err = s.storage.AddThing(other)
+	require.NoError(s.T(), err)
+	defer s.storage.RemoveThing(otherThingID)
+
+	_, err = s.storage.GetIndexedThingIDs("location", "plant-7")
+	assert.ErrorIs(s.T(), err, persistence.ErrNotIndexed)
+
+	// the stored things are indexed
+	require.NoError(s.T(), s.storage.SetIndexedAttributes([]string{"location", "/building/floor/", "location"}))
+	defer s.storage.SetIndexedAttributes(nil)
+	lookup := func(path string, values ...interface{}) []string {
+		ids, err := s.storage.GetIndexedThingIDs(path, values...)
+		require.NoError(s.T(), err)
+		return ids
+	}
+	assert.Equal(s.T(), []string{testThingID}, lookup("location", "plant-7"))
+	assert.Equal(s.T(), []string{testThingID, otherThingID}, lookup("location", "plant-7", "plant-8"))
+	assert.Equal(s.T(), []string{testThingID, otherThingID}, lookup("building/floor", 2.0))
+	assert.Empty(s.T(), lookup("location", "plant-9"))
+	_, err = s.storage.GetIndexedThingIDs("building", "plant-7")
+	assert.ErrorIs(s.T(), err, persistence.ErrNotIndexed)
+
+	// the modified and the removed things are reindexed
+	thing.Attributes["location"] = "plant-8"
+	_, err = s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), lookup("location", "plant-7"))
+	assert.Equal(s.T(), []string{testThingID, otherThingID}, lookup("location", "plant-8"))
+
+	require.NoError(s.T(), s.storage.RemoveThing(otherThingID))
+	assert.Equal(s.T(), []string{testThingID}, lookup("location", "plant-8"))
+	assert.Equal(s.T(), []string{testThingID}, lookup("building/floor", 2))
+
+	// the imported things are indexed
+	exported := &bytes.Buffer{}
+	require.NoError(s.T(), s.storage.Export(exported))
+	thing.Attributes["location"] = "plant-9"
+	_, err = s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.Import(exported))
+	assert.Empty(s.T(), lookup("location", "plant-9"))
+	assert.Equal(s.T(), []string{testThingID}, lookup("location", "plant-8"))
+
+	require.NoError(s.T(), s.storage.Reopen())
+	assert.Equal(s.T(), []string{testThingID}, lookup("location", "plant-8"))
+
+	require.NoError(s.T(), s.storage.SetIndexedAttributes(nil))
+	_, err = s.storage.GetIndexedThingIDs("location", "plant-8")
+	assert.ErrorIs(s.T(), err, persistence.ErrNotIndexed)
+	assert.Error(s.T(), s.storage.SetIndexedAttributes([]string{"building//floor"}))
+}
+
+func (s *PersistenceTestSuite) TestExportImport() {
+	const otherThingID = testThingID + "x"
+
+	_, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.RemoveFeature(testThingID, testFeatureID2))
+	system, err := s.storage.GetSystemThingData(testThingID)
+	require.NoError(s.T(), err)
+
+	var exported bytes.Buffer
+	require.NoError(s.T(), s.storage.Export(&exported))
+
+	// portable to the other storage engines
+	memory := persistence.NewInMemoryThingsDB(testThingID, 0)
+	defer memory.Close()
+	require.NoError(s.T(), memory.Import(bytes.NewReader(exported.Bytes())))
+
+	var reexported bytes.Buffer
+	require.NoError(s.T(), memory.Export(&reexported))
+	assert.JSONEq(s.T(), exported.String(), reexported.String())
+
+	imported, err := memory.GetSystemThingData(testThingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), system.Revision, imported.Revision)
+	assert.Equal(s.T(), system.Timestamp, imported.Timestamp)
+	assert.Contains(s.T(), imported.DeletedFeatures, testFeatureID2)
+	assert.Equal(s.T(), system.UnsynchronizedFeatures, imported.UnsynchronizedFeatures)
+
+	// the import replaces all stored things
+	_, err = s.storage.AddThing(createThing(otherThingID))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.Import(bytes.NewReader(exported.Bytes())))
+
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{testThingID}, ids)
+	_, err = s.storage.GetSystemThingData(otherThingID)
+	assert.ErrorIs(s.T(), err, persistence.ErrThingNotFound)
+	err = s.storage.GetFeature(otherThingID, testFeatureID1, &model.Feature{})
+	assert.Error(s.T(), err)
+
+	other := persistence.NewInMemoryThingsDB("other:device", 0)
+	defer other.Close()
+	err = other.Import(bytes.NewReader(exported.Bytes()))
+	assert.ErrorIs(s.T(), err, persistence.ErrImportMismatch)
+}
+
+func (s *PersistenceTestSuite) TestRemoveThingFeatures() {
+	thing := createThing(testThingID)
+	thing.WithFeature("TestFeature3", &model.Feature{})
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.RemoveThing(testThingID))
+
+	_, err = s.storage.AddThing(createThingWithFeatures(testThingID, "TestFeature4", "TestFeature5"))
+	require.NoError(s.T(), err)
+	system, err := s.storage.GetSystemThingData(testThingID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), system.DeletedFeatures)
+}
+
+func (s *PersistenceTestSuite) TestNestedTransactions() {
+	const otherThingID = testThingID + "x"
+
+	err := s.storage.Batch(func(storage persistence.ThingsStorage) error {
+		if _, err := storage.AddThing(createThing(otherThingID)); err != nil {
+			return err
+		}
+		return storage.RemoveFeature(otherThingID, "missing")
+	})
+	assert.ErrorIs(s.T(), err, persistence.ErrFeatureNotFound)
+
+	// the thing and the things IDs index are rolled back together
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.NotContains(s.T(), ids, otherThingID)
+	_, err = s.storage.GetSystemThingData(otherThingID)
+	assert.ErrorIs(s.T(), err, persistence.ErrThingNotFound)
+}
+
+func (s *PersistenceTestSuite) TestLimits() {
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+
+	s.storage.SetLimits(persistence.Limits{MaxThings: len(ids) + 1, MaxFeatures: 2, MaxPropertySize: 16})
+	defer s.storage.SetLimits(persistence.Limits{})
+
+	thing := createThing(testThingID)
+	thing.WithFeature("TestFeature3", &model.Feature{})
+	_, err = s.storage.AddThing(thing)
+	assert.ErrorIs(s.T(), err, persistence.ErrLimitExceeded)
+
+	_, err = s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+	_, err = s.storage.AddThing(createThing(testThingID + "x"))
+	assert.ErrorIs(s.T(), err, persistence.ErrLimitExceeded)
+
+	_, err = s.storage.AddFeature(testThingID, "TestFeature3", &model.Feature{})
+	assert.ErrorIs(s.T(), err, persistence.ErrLimitExceeded)
+
+	feature := (&model.Feature{}).WithDesiredProperty("x", "a too long property value")
+	_, err = s.storage.AddFeature(testThingID, testFeatureID1, feature)
+	assert.ErrorIs(s.T(), err, persistence.ErrLimitExceeded)
+	_, err = s.storage.AddFeature(testThingID, testFeatureID1, feature.WithDesiredProperty("x", "value"))
+	require.NoError(s.T(), err)
+}
+
+func (s *PersistenceTestSuite) TestGetWriteStats() {
+	initial := s.storage.GetWriteStats()
+
+	_, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+
+	stats := s.storage.GetWriteStats()
+	assert.True(s.T(), stats.PayloadBytes > initial.PayloadBytes)
+	assert.True(s.T(), stats.EncodedBytes > initial.EncodedBytes)
+	if s.engine == persistence.EngineBolt || s.engine == "" {
+		assert.True(s.T(), stats.PageBytes-initial.PageBytes >= uint64(os.Getpagesize()))
+	}
+
+	// the reads are not counted
+	thing := &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thing))
+	assert.Equal(s.T(), stats, s.storage.GetWriteStats())
+}
+
+func (s *PersistenceTestSuite) TestSnapshot() {
+	_, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+
+	location := filepath.Join(s.T().TempDir(), "snapshot.db")
+	file, err := os.Create(location)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.Snapshot(file))
+	require.NoError(s.T(), file.Close())
+
+	snapshot, err := persistence.OpenReadOnly(s.engine, location)
+	require.NoError(s.T(), err)
+	defer snapshot.Close()
+
+	assert.Equal(s.T(), testThingID, snapshot.GetDeviceID())
+	thing := &model.Thing{}
+	require.NoError(s.T(), snapshot.GetThing(testThingID, thing))
+	assert.Len(s.T(), thing.Features, 2)
+}
+
+func (s *PersistenceTestSuite) TestBackup() {
+	_, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+
+	dir := s.T().TempDir()
+	location := filepath.Join(dir, "backup.db")
+	require.NoError(s.T(), s.storage.Backup(location))
+	assert.ErrorIs(s.T(), s.storage.Backup(location), persistence.ErrBackupExists)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 1, "no temporary backup file is expected")
+
+	backup, err := persistence.OpenReadOnly(s.engine, location)
+	require.NoError(s.T(), err)
+	defer backup.Close()
+
+	thing := &model.Thing{}
+	require.NoError(s.T(), backup.GetThing(testThingID, thing))
+	assert.Len(s.T(), thing.Features, 2)
+}
+
+func (s *PersistenceTestSuite) TestOpenReadOnlyLocked() {
+	if s.engine == persistence.EngineSQLite {
+		s.T().Skip("the SQLite database file is not locked while opened")
+	}
+	if s.engine == persistence.EngineMemory {
+		s.T().Skip("the in-memory storage has no database file")
+	}
+	_, err := persistence.OpenReadOnly(s.engine, dbLocation)
+	assert.ErrorIs(s.T(), err, persistence.ErrDatabaseLocked)
+
+	_, err = persistence.OpenReadOnly(s.engine, filepath.Join(s.T().TempDir(), "missing.db"))
+	assert.Error(s.T(), err)
+}
+
+func (s *PersistenceTestSuite) TestGetTimestamps() {
+	thing := createThing(testThingID)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+
+	created, err := s.storage.GetTimestamps(testThingID)
+	require.NoError(s.T(), err)
+	assert.NotEmpty(s.T(), created.Created)
+	assert.Equal(s.T(), created.Created, created.Modified)
+	require.Len(s.T(), created.Features, 2)
+	assert.Equal(s.T(), persistence.Timestamps{
+		Created: created.Created, Modified: created.Modified, Revision: created.Revision,
+	}, created.Features[testFeatureID1])
+
+	// the creation timestamps are kept on modification
+	thing.Features[testFeatureID1].Properties["prop1"] = "modified"
+	_, err = s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	_, err = s.storage.AddFeature(testThingID, testFeatureID2, thing.Features[testFeatureID2])
+	require.NoError(s.T(), err)
+	_, err = s.storage.AddFeature(testThingID, "added", &model.Feature{})
+	require.NoError(s.T(), err)
+
+	modified, err := s.storage.GetTimestamps(testThingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), created.Created, modified.Created)
+	assert.Equal(s.T(), created.Features[testFeatureID1].Created, modified.Features[testFeatureID1].Created)
+	assert.Less(s.T(), created.Revision, modified.Features[testFeatureID1].Revision)
+	assert.Less(s.T(), modified.Features[testFeatureID1].Revision, modified.Revision)
+	// not modified features keep their modification timestamp
+	assert.Equal(s.T(), created.Features[testFeatureID2], modified.Features[testFeatureID2])
+	assert.Equal(s.T(), modified.Modified, modified.Features["added"].Created)
+
+	thingLoaded := &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thingLoaded))
+	assert.Equal(s.T(), thingLoaded.Timestamp, modified.Modified)
+
+	_, err = s.storage.GetTimestamps("unknown:thing")
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+
+	feature, err := s.storage.GetFeatureTimestamps(testThingID, "added")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), modified.Features["added"], *feature)
+	assert.Equal(s.T(), modified.Revision, feature.Revision)
+
+	_, err = s.storage.GetFeatureTimestamps(testThingID, "unknown")
+	assert.True(s.T(), errors.Is(err, persistence.ErrFeatureNotFound), err)
+	_, err = s.storage.GetFeatureTimestamps("unknown:thing", "added")
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+}
+
+func (s *PersistenceTestSuite) TestForEachFeature() {
+	features := make(map[string]*model.Feature)
+	for i := 0; i < 150; i++ {
+		features[fmt.Sprintf("feature%d", i)] = (&model.Feature{}).WithProperty("index", i)
+	}
+	s.addThing(testThingID, features)
+
+	iterated := make(map[string]*model.Feature)
+	err := s.storage.ForEachFeature(testThingID, func(featureID string, feature *model.Feature) (bool, error) {
+		iterated[featureID] = feature
+		return true, nil
+	})
+	require.NoError(s.T(), err)
+	assert.EqualValues(s.T(), features, iterated)
+
+	count := 0
+	err = s.storage.ForEachFeature(testThingID, func(featureID string, feature *model.Feature) (bool, error) {
+		count++
+		return count < 70, nil
+	})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 70, count)
+
+	expectedErr := errors.New("iteration error")
+	err = s.storage.ForEachFeature(testThingID, func(featureID string, feature *model.Feature) (bool, error) {
+		return true, expectedErr
+	})
+	assert.True(s.T(), errors.Is(err, expectedErr), err)
+
+	err = s.storage.ForEachFeature("unknown:thing", func(featureID string, feature *model.Feature) (bool, error) {
+		return true, nil
+	})
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+}
+
+func (s *PersistenceTestSuite) TestGetThingFeatures() {
+	thing := createThing(testThingID)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+
+	loaded := &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThingFeatures(testThingID, loaded, testFeatureID2, "unknown"))
+	assert.Equal(s.T(), thing.Attributes, loaded.Attributes)
+	require.Len(s.T(), loaded.Features, 1)
+	assert.EqualValues(s.T(), thing.Features[testFeatureID2], loaded.Features[testFeatureID2])
+
+	loaded = &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThingFeatures(testThingID, loaded))
+	assert.Equal(s.T(), thing.ID, loaded.ID)
+	assert.Nil(s.T(), loaded.Features)
+
+	err = s.storage.GetThingFeatures("unknown:thing", &model.Thing{}, testFeatureID1)
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+}
+
+func (s *PersistenceTestSuite) TestGetFeatureIDs() {
+	thing := createThing(testThingID)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+
+	featureIDs, err := s.storage.GetFeatureIDs(testThingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{testFeatureID1, testFeatureID2}, featureIDs)
+
+	require.NoError(s.T(), s.storage.RemoveFeature(testThingID, testFeatureID1))
+	featureIDs, err = s.storage.GetFeatureIDs(testThingID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{testFeatureID2}, featureIDs)
+
+	_, err = s.storage.GetFeatureIDs("unknown:thing")
+	assert.True(s.T(), errors.Is(err, persistence.ErrThingNotFound), err)
+}
+
+func (s *PersistenceTestSuite) TestBatch() {
+	s.addThing(testThingID, map[string]*model.Feature{
+		testFeatureID1: (&model.Feature{}).WithProperty("x", 1),
+	})
+
+	err := s.storage.Batch(func(storage persistence.ThingsStorage) error {
+		if _, err := storage.AddFeature(testThingID, testFeatureID1, (&model.Feature{}).WithProperty("x", 2)); err != nil {
+			return err
+		}
+		_, err := storage.AddFeature(testThingID, testFeatureID2, (&model.Feature{}).WithProperty("y", 3))
+		return err
+	})
+	require.NoError(s.T(), err)
+
+	thing := &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thing))
+	assert.EqualValues(s.T(), 2, thing.Features[testFeatureID1].Properties["x"])
+	assert.EqualValues(s.T(), 3, thing.Features[testFeatureID2].Properties["y"])
+	revision := thing.Revision
+
+	otherThingID := "things.storage:other"
+	batchErr := errors.New("batch failed")
+	err = s.storage.Batch(func(storage persistence.ThingsStorage) error {
+		if _, err := storage.AddThing(createThing(otherThingID)); err != nil {
+			return err
+		}
+		if err := storage.RemoveFeature(testThingID, testFeatureID2); err != nil {
+			return err
+		}
+		if _, err := storage.AddFeature(testThingID, "unknown", &model.Feature{}); err != nil {
+			return err
+		}
+		return batchErr
+	})
+	assert.True(s.T(), errors.Is(err, batchErr), err)
+
+	s.assertThingPresent(otherThingID, false)
+	thing = &model.Thing{}
+	require.NoError(s.T(), s.storage.GetThing(testThingID, thing))
+	assert.Equal(s.T(), 2, len(thing.Features))
+	assert.Equal(s.T(), revision, thing.Revision)
+
+	ids, err := s.storage.GetThingIDs()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{testThingID}, ids)
+}
+
+func (s *PersistenceTestSuite) TestWatch() {
+	var changes []persistence.StorageChange
+	unwatch := s.storage.Watch(func(change persistence.StorageChange) {
+		changes = append(changes, change)
+	})
+	defer unwatch()
+
+	revision, err := s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []persistence.StorageChange{
+		{ThingID: testThingID, Change: persistence.ChangeCreated, Revision: revision},
+	}, changes)
+
+	changes = nil
+	_, err = s.storage.AddFeature(testThingID, testFeatureID1, (&model.Feature{}).WithProperty("x", 1))
+	require.NoError(s.T(), err)
+	_, err = s.storage.AddFeature(testThingID, "TestFeature3", &model.Feature{})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.storage.RemoveFeature(testThingID, testFeatureID2))
+	assert.Equal(s.T(), []persistence.StorageChange{
+		{ThingID: testThingID, FeatureID: testFeatureID1, Change: persistence.ChangeModified, Revision: revision + 1},
+		{ThingID: testThingID, FeatureID: "TestFeature3", Change: persistence.ChangeCreated, Revision: revision + 2},
+		{ThingID: testThingID, FeatureID: testFeatureID2, Change: persistence.ChangeDeleted, Revision: revision + 3},
+	}, changes)
+
+	// the changes are notified once the batch is committed, the discarded ones are not notified
+	changes = nil
+	err = s.storage.Batch(func(storage persistence.ThingsStorage) error {
+		if _, err := storage.AddThing(createThing(testThingID)); err != nil {
+			return err
+		}
+		assert.Empty(s.T(), changes)
+		return storage.RemoveFeature(testThingID, "missing")
+	})
+	assert.ErrorIs(s.T(), err, persistence.ErrFeatureNotFound)
+	assert.Empty(s.T(), changes)
+
+	require.NoError(s.T(), s.storage.RemoveThing(testThingID))
+	assert.Equal(s.T(), []persistence.StorageChange{
+		{ThingID: testThingID, Change: persistence.ChangeDeleted, Revision: revision + 3},
+	}, changes)
+
+	changes = nil
+	unwatch()
+	_, err = s.storage.AddThing(createThing(testThingID))
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), changes)
+}
+
 func (s *PersistenceTestSuite) TestGetWithNilInterface() {
 	_, err := s.storage.AddThing(createThing(testThingID))
 	require.NoError(s.T(), err)
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+)
+
+func (storage *thingsDB) GetTombstones(tombstones *data.TombstonesData) error {
+	return storage.db.GetAs(systemKeyTombstones, tombstones)
+}
+
+func (storage *thingsDB) PurgeTombstone(thingID string, revision int64) (bool, error) {
+	purged := false
+	err := storage.update(func(tx *thingsDB) error {
+		purged = false
+		tombstones, err := tx.tombstones()
+		if err != nil {
+			return err
+		}
+		if tombstone, ok := tombstones.Things[thingID]; !ok || tombstone.Revision != revision {
+			return nil
+		}
+		delete(tombstones.Things, thingID)
+		purged = true
+		return tx.setTombstones(tombstones)
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "tombstone of the thing with ID '%s' could not be purged", thingID)
+	}
+	return purged, nil
+}
+
+// addTombstone records the deletion of the thing with the provided revision.
+func (storage *thingsDB) addTombstone(thingID string, revision int64) error {
+	tombstones, err := storage.tombstones()
+	if err != nil {
+		return err
+	}
+	tombstones.Things[thingID] = data.TombstoneData{
+		Revision: revision,
+		Deleted:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	return storage.setTombstones(tombstones)
+}
+
+// removeTombstone removes the tombstone of the thing, if any, e.g. on its creation again.
+// The hub twin is then updated by the synchronization of the created thing instead of deleted.
+func (storage *thingsDB) removeTombstone(thingID string) error {
+	tombstones, err := storage.tombstones()
+	if err != nil {
+		return err
+	}
+	if _, ok := tombstones.Things[thingID]; !ok {
+		return nil
+	}
+	delete(tombstones.Things, thingID)
+	return storage.setTombstones(tombstones)
+}
+
+// tombstones returns the persisted tombstones, empty if there are none.
+func (storage *thingsDB) tombstones() (*data.TombstonesData, error) {
+	tombstones := &data.TombstonesData{}
+	if err := storage.db.GetAs(systemKeyTombstones, tombstones); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if tombstones.Things == nil {
+		tombstones.Things = make(map[string]data.TombstoneData)
+	}
+	return tombstones, nil
+}
+
+// setTombstones persists the tombstones, the record is removed if there are none.
+func (storage *thingsDB) setTombstones(tombstones *data.TombstonesData) error {
+	if len(tombstones.Things) == 0 {
+		if err := storage.db.Delete(systemKeyTombstones); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	return storage.db.SetAs(systemKeyTombstones, tombstones)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package persistence
+
+import (
+	"sync"
+)
+
+// StorageChange describes a committed change of a stored thing or of one of its features.
+type StorageChange struct {
+	ThingID string
+	// FeatureID is the ID of the changed feature, empty if the thing itself is changed.
+	FeatureID string
+	// Change is ChangeCreated, ChangeModified or ChangeDeleted.
+	Change string
+	// Revision is the thing revision of the change, the last revision of the thing if it is deleted.
+	Revision int64
+}
+
+// watchers are the listeners of the storage changes.
+// The nil watchers, e.g. of a read-only storage, have no listeners and cannot be watched.
+type watchers struct {
+	mutex     sync.RWMutex
+	next      int
+	listeners map[int]func(change StorageChange)
+}
+
+func newWatchers() *watchers {
+	return &watchers{listeners: make(map[int]func(change StorageChange))}
+}
+
+func (w *watchers) watch(listener func(change StorageChange)) func() {
+	if w == nil {
+		return func() {}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	id := w.next
+	w.next++
+	w.listeners[id] = listener
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+
+			delete(w.listeners, id)
+		})
+	}
+}
+
+// active returns true if there are listeners, so that the changes are to be collected.
+func (w *watchers) active() bool {
+	if w == nil {
+		return false
+	}
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return len(w.listeners) > 0
+}
+
+func (w *watchers) notify(changes []StorageChange) {
+	if w == nil || len(changes) == 0 {
+		return
+	}
+
+	w.mutex.RLock()
+	listeners := make([]func(change StorageChange), 0, len(w.listeners))
+	for _, listener := range w.listeners {
+		listeners = append(listeners, listener)
+	}
+	w.mutex.RUnlock()
+
+	for _, change := range changes {
+		for _, listener := range listeners {
+			listener(change)
+		}
+	}
+}
+
+func (storage *thingsDB) Watch(listener func(change StorageChange)) func() {
+	return storage.watchers.watch(listener)
+}
+
+// changed records the change to be notified once the transaction of the storage is committed.
+func (storage *thingsDB) changed(thingID, featureID, change string, revision int64) {
+	if storage.changes == nil || !storage.watchers.active() {
+		return
+	}
+	*storage.changes = append(*storage.changes, StorageChange{
+		ThingID:   thingID,
+		FeatureID: featureID,
+		Change:    change,
+		Revision:  revision,
+	})
+}
@@ -39,8 +39,41 @@ const (
 	headerETag             = "etag"
 	headerIfMatch          = "if-match"
 	headerIfNoneMatch      = "if-none-match"
+	headerCondition        = "condition"
+	headerRequestedAcks    = "requested-acks"
+	headerPutMetadata      = "put-metadata"
+
+	headerLiveChannelCondition        = "live-channel-condition"
+	headerLiveChannelConditionMatched = "live-channel-condition-matched"
+	headerOnLiveChannelTimeout        = "on-live-channel-timeout"
+	headerChannel                     = "channel"
+	headerTimestampQuality            = "timestamp-quality"
+	headerValueFormat                 = "value-format"
+	headerFeatureRevision             = "feature-revision"
+	headerFeatureModified             = "feature-modified"
+
+	// LiveChannelTimeoutFail defines the 'on-live-channel-timeout' header value to respond with timeout error
+	// if no live response is received in time. This is the default strategy.
+	LiveChannelTimeoutFail = "fail"
+
+	// LiveChannelTimeoutUseTwin defines the 'on-live-channel-timeout' header value to respond with the twin value
+	// if no live response is received in time.
+	LiveChannelTimeoutUseTwin = "use-twin"
+
+	// ValueFormatFlat defines the 'value-format' header value to respond with a compact representation
+	// of the value, i.e. a single JSON object of the JSON pointers of the leaf values to the values,
+	// e.g. {"/properties/temperature/value": 23.4}, which is parsed with no nesting.
+	ValueFormatFlat = "flat"
 )
 
+// MetadataEntry represents a single entry of the 'put-metadata' header value,
+// i.e. the metadata value to be set for the key relative to the command path,
+// e.g. 'properties/x/issuedBy' for a feature command.
+type MetadataEntry struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
 // Headers represents currently used Ditto headers along with additional HTTP headers
 // that can be applied depending on the transport used.
 // All protocol defined headers are case-insensitive and by default lowercase is used.
@@ -266,6 +299,120 @@ func (h *Headers) WithIfNoneMatch(ifNoneMatch string) *Headers {
 	return h
 }
 
+// ConflictingHeaders returns the names of the headers which values contradict each other,
+// or nil if there are no such headers, i.e.:
+//   - 'if-match' with 'if-none-match' set to '*', as the entity is required to both exist and not exist;
+//   - 'timeout' of zero with 'response-required' explicitly set to 'true', as no response is awaited.
+func (h *Headers) ConflictingHeaders() []string {
+	if len(h.IfMatch()) > 0 && h.IfNoneMatch() == "*" {
+		return []string{headerIfMatch, headerIfNoneMatch}
+	}
+	if value, ok := h.values[headerTimeout].(string); ok {
+		if required, ok := h.values[headerResponseRequired].(bool); ok && required {
+			if timeout, err := parseTimeout(value); err == nil && timeout == 0 {
+				return []string{headerTimeout, headerResponseRequired}
+			}
+		}
+	}
+	return nil
+}
+
+// Condition returns the 'condition' header value or empty string if not set.
+// The condition is an RQL expression which must hold for the command to be applied.
+func (h *Headers) Condition() string {
+	if value, ok := h.values[headerCondition]; ok {
+		return value.(string)
+	}
+	return ""
+}
+
+// WithCondition sets the 'condition' header value if non-empty condition is provided,
+// otherwise removes the 'condition' header.
+func (h *Headers) WithCondition(condition string) *Headers {
+	if len(condition) > 0 {
+		h.values[headerCondition] = condition
+	} else {
+		delete(h.values, headerCondition)
+	}
+	return h
+}
+
+// RequestedAcks returns the 'requested-acks' header value, i.e. the labels of the acknowledgements
+// requested to be received before the command is completed, or nil if not set.
+// Both JSON array and comma-separated string representations of the header value are supported.
+func (h *Headers) RequestedAcks() []string {
+	var labels []string
+	switch value := h.values[headerRequestedAcks].(type) {
+	case []string:
+		labels = value
+	case []interface{}:
+		for _, label := range value {
+			if s, ok := label.(string); ok {
+				labels = append(labels, s)
+			}
+		}
+	case string:
+		if err := json.Unmarshal([]byte(value), &labels); err != nil {
+			labels = strings.Split(value, ",")
+		}
+	}
+
+	var acks []string
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); len(label) > 0 {
+			acks = append(acks, label)
+		}
+	}
+	return acks
+}
+
+// WithRequestedAcks sets the 'requested-acks' header value if non-empty acknowledgement labels are provided,
+// otherwise removes the 'requested-acks' header.
+func (h *Headers) WithRequestedAcks(labels ...string) *Headers {
+	if len(labels) > 0 {
+		h.values[headerRequestedAcks] = labels
+	} else {
+		delete(h.values, headerRequestedAcks)
+	}
+	return h
+}
+
+// PutMetadata returns the 'put-metadata' header value or nil if not set or invalid.
+// Both JSON array and JSON array string representations of the header value are supported.
+func (h *Headers) PutMetadata() []MetadataEntry {
+	value, ok := h.values[headerPutMetadata]
+	if !ok {
+		return nil
+	}
+
+	var data []byte
+	if s, ok := value.(string); ok {
+		data = []byte(s)
+	} else {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil
+		}
+	}
+
+	var entries []MetadataEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+	return entries
+}
+
+// WithPutMetadata sets the 'put-metadata' header value if non-empty metadata entries are provided,
+// otherwise removes the 'put-metadata' header.
+func (h *Headers) WithPutMetadata(entries ...MetadataEntry) *Headers {
+	if len(entries) > 0 {
+		h.values[headerPutMetadata] = entries
+	} else {
+		delete(h.values, headerPutMetadata)
+	}
+	return h
+}
+
 // Generic returns the value of the provided key header and if a header with such key is present.
 func (h *Headers) Generic(key string) (interface{}, bool) {
 	v, ok := h.values[strings.ToLower(key)]
@@ -288,6 +435,163 @@ func (h *Headers) WithGeneric(key string, value interface{}) *Headers {
 	return h
 }
 
+// LiveChannelCondition returns the 'live-channel-condition' header value or empty string if not set.
+// The condition is an RQL expression which must hold for a twin retrieve command to be routed to the live channel.
+func (h *Headers) LiveChannelCondition() string {
+	if value, ok := h.values[headerLiveChannelCondition].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// WithLiveChannelCondition sets the 'live-channel-condition' header value if non-empty condition is provided,
+// otherwise removes the 'live-channel-condition' header.
+func (h *Headers) WithLiveChannelCondition(condition string) *Headers {
+	if len(condition) > 0 {
+		h.values[headerLiveChannelCondition] = condition
+	} else {
+		delete(h.values, headerLiveChannelCondition)
+	}
+	return h
+}
+
+// LiveChannelConditionMatched returns true if the 'live-channel-condition-matched' header is set to true.
+func (h *Headers) LiveChannelConditionMatched() bool {
+	switch value := h.values[headerLiveChannelConditionMatched].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// WithLiveChannelConditionMatched sets the 'live-channel-condition-matched' header value.
+func (h *Headers) WithLiveChannelConditionMatched(matched bool) *Headers {
+	h.values[headerLiveChannelConditionMatched] = matched
+	return h
+}
+
+// OnLiveChannelTimeout returns the 'on-live-channel-timeout' header value, i.e. LiveChannelTimeoutFail
+// or LiveChannelTimeoutUseTwin. LiveChannelTimeoutFail is returned if not set or set to unknown value.
+func (h *Headers) OnLiveChannelTimeout() string {
+	if value, ok := h.values[headerOnLiveChannelTimeout].(string); ok && value == LiveChannelTimeoutUseTwin {
+		return value
+	}
+	return LiveChannelTimeoutFail
+}
+
+// WithOnLiveChannelTimeout sets the 'on-live-channel-timeout' header value if non-empty strategy is provided,
+// otherwise removes the 'on-live-channel-timeout' header.
+func (h *Headers) WithOnLiveChannelTimeout(strategy string) *Headers {
+	if len(strategy) > 0 {
+		h.values[headerOnLiveChannelTimeout] = strategy
+	} else {
+		delete(h.values, headerOnLiveChannelTimeout)
+	}
+	return h
+}
+
+// Channel returns the 'channel' header value, i.e. the channel providing the response value, or empty string if not set.
+func (h *Headers) Channel() TopicChannel {
+	if value, ok := h.values[headerChannel].(string); ok {
+		return TopicChannel(value)
+	}
+	return ""
+}
+
+// WithChannel sets the 'channel' header value if non-empty channel is provided,
+// otherwise removes the 'channel' header.
+func (h *Headers) WithChannel(channel TopicChannel) *Headers {
+	if len(channel) > 0 {
+		h.values[headerChannel] = string(channel)
+	} else {
+		delete(h.values, headerChannel)
+	}
+	return h
+}
+
+// TimestampQuality returns the 'timestamp-quality' header value or empty string if not set,
+// i.e. if the event timestamp is not affected by a detected wall clock jump.
+func (h *Headers) TimestampQuality() string {
+	if value, ok := h.values[headerTimestampQuality].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// WithTimestampQuality sets the 'timestamp-quality' header value if non-empty quality is provided,
+// otherwise removes the 'timestamp-quality' header.
+func (h *Headers) WithTimestampQuality(quality string) *Headers {
+	if len(quality) > 0 {
+		h.values[headerTimestampQuality] = quality
+	} else {
+		delete(h.values, headerTimestampQuality)
+	}
+	return h
+}
+
+// FeatureRevision returns the 'feature-revision' header value, i.e. the thing revision of the last modification
+// of the feature the envelope is about, or 0 if not set.
+func (h *Headers) FeatureRevision() int64 {
+	if value, ok := h.values[headerFeatureRevision].(string); ok {
+		if revision, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return revision
+		}
+	}
+	return 0
+}
+
+// WithFeatureRevision sets the 'feature-revision' header value if positive revision is provided,
+// otherwise removes the 'feature-revision' header.
+func (h *Headers) WithFeatureRevision(revision int64) *Headers {
+	if revision > 0 {
+		h.values[headerFeatureRevision] = strconv.FormatInt(revision, 10)
+	} else {
+		delete(h.values, headerFeatureRevision)
+	}
+	return h
+}
+
+// FeatureModified returns the 'feature-modified' header value, i.e. the timestamp of the last modification
+// of the feature the envelope is about, or empty string if not set.
+func (h *Headers) FeatureModified() string {
+	if value, ok := h.values[headerFeatureModified].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// WithFeatureModified sets the 'feature-modified' header value if non-empty timestamp is provided,
+// otherwise removes the 'feature-modified' header.
+func (h *Headers) WithFeatureModified(timestamp string) *Headers {
+	if len(timestamp) > 0 {
+		h.values[headerFeatureModified] = timestamp
+	} else {
+		delete(h.values, headerFeatureModified)
+	}
+	return h
+}
+
+// ValueFormat returns the 'value-format' header value or empty string if not set, i.e. the nested JSON value.
+func (h *Headers) ValueFormat() string {
+	if value, ok := h.values[headerValueFormat].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// WithValueFormat sets the 'value-format' header value if non-empty format is provided,
+// otherwise removes the 'value-format' header.
+func (h *Headers) WithValueFormat(format string) *Headers {
+	if len(format) > 0 {
+		h.values[headerValueFormat] = format
+	} else {
+		delete(h.values, headerValueFormat)
+	}
+	return h
+}
+
 // NewHeaders creates an instance with no headers set.
 func NewHeaders() *Headers {
 	return &Headers{
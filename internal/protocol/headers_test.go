@@ -31,7 +31,14 @@ func TestHeaders(t *testing.T) {
         "reply-to":"command/t9138cc86fcd14181aa7b_hub",
         "etag": "hash:ba930ee8",
         "If-Match":"hash:ba930ee8",
-        "If-None-Match":"hash:ba930ee8"
+        "If-None-Match":"hash:ba930ee8",
+        "condition": "eq(attributes/location,\"kitchen\")",
+        "requested-acks": ["twin-persisted", "custom"],
+        "put-metadata": [{"key": "properties/x/issuedBy", "value": {"name": "kanto"}}],
+        "live-channel-condition": "exists(features/meter)",
+        "live-channel-condition-matched": "true",
+        "on-live-channel-timeout": "use-twin",
+        "channel": "live"
 	}`
 
 	var headers protocol.Headers
@@ -44,6 +51,15 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, "hash:ba930ee8", headers.ETag())
 	assert.Equal(t, "hash:ba930ee8", headers.IfMatch())
 	assert.Equal(t, "hash:ba930ee8", headers.IfNoneMatch())
+	assert.Equal(t, `eq(attributes/location,"kitchen")`, headers.Condition())
+	assert.Equal(t, []string{"twin-persisted", "custom"}, headers.RequestedAcks())
+	assert.Equal(t, []protocol.MetadataEntry{
+		{Key: "properties/x/issuedBy", Value: map[string]interface{}{"name": "kanto"}},
+	}, headers.PutMetadata())
+	assert.Equal(t, "exists(features/meter)", headers.LiveChannelCondition())
+	assert.True(t, headers.LiveChannelConditionMatched())
+	assert.Equal(t, protocol.LiveChannelTimeoutUseTwin, headers.OnLiveChannelTimeout())
+	assert.Equal(t, protocol.ChannelLive, headers.Channel())
 	assert.Equal(t, time.Second*60, headers.Timeout())
 }
 
@@ -55,6 +71,17 @@ func TestHeadersBuild(t *testing.T) {
 		WithETag("hash:ba930ee8").
 		WithIfMatch("hash:ba930ee8").
 		WithIfNoneMatch("hash:ba930ee8").
+		WithCondition("exists(features/meter)").
+		WithRequestedAcks("twin-persisted").
+		WithPutMetadata(protocol.MetadataEntry{Key: "x/issuedAt", Value: "now"}).
+		WithLiveChannelCondition("exists(features/meter)").
+		WithLiveChannelConditionMatched(true).
+		WithOnLiveChannelTimeout(protocol.LiveChannelTimeoutFail).
+		WithChannel(protocol.ChannelTwin).
+		WithTimestampQuality("clock-jump").
+		WithValueFormat(protocol.ValueFormatFlat).
+		WithFeatureRevision(42).
+		WithFeatureModified("2022-01-01T00:00:00Z").
 		WithGeneric("Name", "value")
 
 	assert.Equal(t, protocol.ContentTypeJSONMerge, headers.ContentType())
@@ -63,12 +90,45 @@ func TestHeadersBuild(t *testing.T) {
 	assert.Equal(t, "hash:ba930ee8", headers.ETag())
 	assert.Equal(t, "hash:ba930ee8", headers.IfMatch())
 	assert.Equal(t, "hash:ba930ee8", headers.IfNoneMatch())
+	assert.Equal(t, "exists(features/meter)", headers.Condition())
+	assert.Equal(t, []string{"twin-persisted"}, headers.RequestedAcks())
+	assert.Equal(t, []protocol.MetadataEntry{{Key: "x/issuedAt", Value: "now"}}, headers.PutMetadata())
+	assert.Equal(t, "exists(features/meter)", headers.LiveChannelCondition())
+	assert.True(t, headers.LiveChannelConditionMatched())
+	assert.Equal(t, protocol.LiveChannelTimeoutFail, headers.OnLiveChannelTimeout())
+	assert.Equal(t, protocol.ChannelTwin, headers.Channel())
+	assert.Equal(t, "clock-jump", headers.TimestampQuality())
+	assert.Empty(t, headers.Clone().WithTimestampQuality("").TimestampQuality())
+	assert.Equal(t, protocol.ValueFormatFlat, headers.ValueFormat())
+	assert.Empty(t, headers.Clone().WithValueFormat("").ValueFormat())
+	assert.Equal(t, int64(42), headers.FeatureRevision())
+	assert.Zero(t, headers.Clone().WithFeatureRevision(0).FeatureRevision())
+	assert.Equal(t, "2022-01-01T00:00:00Z", headers.FeatureModified())
+	assert.Empty(t, headers.Clone().WithFeatureModified("").FeatureModified())
 
 	v, ok := headers.Generic("name")
 	assert.True(t, ok)
 	assert.Equal(t, "value", v)
 }
 
+func TestRequestedAcksString(t *testing.T) {
+	var headers protocol.Headers
+	require.NoError(t, json.Unmarshal([]byte(`{"requested-acks": "[\"twin-persisted\",\"custom\"]"}`), &headers))
+	assert.Equal(t, []string{"twin-persisted", "custom"}, headers.RequestedAcks())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"requested-acks": "twin-persisted, custom"}`), &headers))
+	assert.Equal(t, []string{"twin-persisted", "custom"}, headers.RequestedAcks())
+}
+
+func TestPutMetadataString(t *testing.T) {
+	var headers protocol.Headers
+	require.NoError(t, json.Unmarshal([]byte(`{"put-metadata": "[{\"key\": \"x/issuedAt\", \"value\": 1}]"}`), &headers))
+	assert.Equal(t, []protocol.MetadataEntry{{Key: "x/issuedAt", Value: 1.0}}, headers.PutMetadata())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"put-metadata": "invalid"}`), &headers))
+	assert.Nil(t, headers.PutMetadata())
+}
+
 func TestHeadersClone(t *testing.T) {
 	headers := protocol.NewHeaders().
 		WithContentType("application/vnd.eclipse.ditto+json").
@@ -145,6 +205,25 @@ func TestTimeoutInvalid(t *testing.T) {
 	}
 }
 
+func TestConflictingHeaders(t *testing.T) {
+	conflictsTests := map[string][]string{
+		`{ "if-match": "\"rev:1\"", "if-none-match": "*" }`:  {"if-match", "if-none-match"},
+		`{ "if-match": "*", "if-none-match": "\"rev:1\"" }`:  nil,
+		`{ "timeout": "0", "response-required": true }`:      {"timeout", "response-required"},
+		`{ "timeout": "0ms", "response-required": true }`:    {"timeout", "response-required"},
+		`{ "timeout": "0", "response-required": false }`:     nil,
+		`{ "timeout": "0" }`:                                 nil,
+		`{ "timeout": "10s", "response-required": true }`:    nil,
+		`{ "correlation-id": "test", "if-none-match": "*" }`: nil,
+	}
+
+	for h, conflicts := range conflictsTests {
+		var headers protocol.Headers
+		require.NoError(t, json.Unmarshal([]byte(h), &headers), h)
+		assert.Equal(t, conflicts, headers.ConflictingHeaders(), h)
+	}
+}
+
 func TestTimeoutBuild(t *testing.T) {
 	headers := protocol.NewHeaders().
 		WithTimeout(10 * time.Second)
@@ -187,6 +266,12 @@ func TestHeadersRemove(t *testing.T) {
         "etag": "hash:ba930ee8",
         "If-Match":"hash:ba930ee8",
         "If-None-Match":"hash:ba930ee8",
+        "condition": "exists(features/meter)",
+        "requested-acks": "twin-persisted,custom",
+        "put-metadata": "[{\"key\": \"x/issuedAt\", \"value\": \"now\"}]",
+        "live-channel-condition": "exists(features/meter)",
+        "on-live-channel-timeout": "use-twin",
+        "channel": "live",
         "name": "value"
 	}`
 
@@ -198,6 +283,12 @@ func TestHeadersRemove(t *testing.T) {
 		WithETag("").
 		WithIfMatch("").
 		WithIfNoneMatch("").
+		WithCondition("").
+		WithRequestedAcks().
+		WithPutMetadata().
+		WithLiveChannelCondition("").
+		WithOnLiveChannelTimeout("").
+		WithChannel("").
 		WithResponseRequired(true).
 		WithReplyTo("").
 		WithTimeout(0*time.Second).
@@ -211,6 +302,12 @@ func TestHeadersRemove(t *testing.T) {
 	assert.Equal(t, 0, len(headers.ETag()))
 	assert.Equal(t, 0, len(headers.IfMatch()))
 	assert.Equal(t, 0, len(headers.IfNoneMatch()))
+	assert.Equal(t, 0, len(headers.Condition()))
+	assert.Equal(t, 0, len(headers.RequestedAcks()))
+	assert.Equal(t, 0, len(headers.PutMetadata()))
+	assert.Equal(t, 0, len(headers.LiveChannelCondition()))
+	assert.Equal(t, protocol.LiveChannelTimeoutFail, headers.OnLiveChannelTimeout())
+	assert.Equal(t, 0, len(headers.Channel()))
 
 	_, ok := headers.Generic("name")
 	assert.False(t, ok)
@@ -35,6 +35,8 @@ const (
 	CriterionMessages TopicCriterion = "messages"
 	// CriterionErrors represents the errors topic criterion.
 	CriterionErrors TopicCriterion = "errors"
+	// CriterionAcks represents the acknowledgements topic criterion.
+	CriterionAcks TopicCriterion = "acks"
 )
 
 // TopicChannel is a representation of the defined by Ditto topic channel options.
@@ -67,6 +69,11 @@ const (
 	ActionNext      TopicAction = "next"
 	ActionComplete  TopicAction = "complete"
 	ActionFailed    TopicAction = "failed"
+	ActionBatch     TopicAction = "batch"
+	ActionCount     TopicAction = "count"
+
+	ActionMigrateDefinition  TopicAction = "migrateDefinition"
+	ActionDefinitionMigrated TopicAction = "definitionMigrated"
 )
 
 // TopicGroup is a representation of the defined by Ditto topic group options.
@@ -82,9 +89,10 @@ const (
 const TopicPlaceholder = "_"
 
 const (
-	topicFormatPolicies       = "%s/%s/%s/%s/%s"
-	topicFormatThings         = "%s/%s/%s/%s/%s/%s"
-	topicFormatThingsNoAction = "%s/%s/%s/%s/%s"
+	topicFormatPolicies         = "%s/%s/%s/%s/%s"
+	topicFormatPoliciesNoAction = "%s/%s/%s/%s"
+	topicFormatThings           = "%s/%s/%s/%s/%s/%s"
+	topicFormatThingsNoAction   = "%s/%s/%s/%s/%s"
 )
 
 // Topic represents the Ditto protocol's Topic entity. It's represented in the form of:
@@ -108,6 +116,9 @@ func (topic *Topic) String() string {
 		}
 		return fmt.Sprintf(topicFormatThings, topic.Namespace, topic.EntityID, topic.Group, topic.Channel, topic.Criterion, topic.Action)
 	case GroupPolicies:
+		if len(topic.Action) == 0 {
+			return fmt.Sprintf(topicFormatPoliciesNoAction, topic.Namespace, topic.EntityID, topic.Group, topic.Criterion)
+		}
 		return fmt.Sprintf(topicFormatPolicies, topic.Namespace, topic.EntityID, topic.Group, topic.Criterion, topic.Action)
 	default:
 		return ""
@@ -126,6 +137,9 @@ func (topic *Topic) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	elements := strings.Split(v, "/")
+	if len(elements) < 4 || (TopicGroup(elements[2]) == GroupThings && len(elements) < 5) {
+		return fmt.Errorf("topic '%s' has too few segments", v)
+	}
 
 	index := 0
 	ns := elements[index]
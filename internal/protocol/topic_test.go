@@ -43,6 +43,19 @@ func TestTopicPolicies(t *testing.T) {
 	assert.Equal(t, test, string(mTopic))
 }
 
+func TestTopicPoliciesNoAction(t *testing.T) {
+	test := `"org.eclipse.kanto/test/policies/errors"`
+
+	var topic protocol.Topic
+	require.NoError(t, json.Unmarshal([]byte(test), &topic))
+
+	assert.Equal(t, protocol.GroupPolicies, topic.Group)
+	assert.Equal(t, protocol.CriterionErrors, topic.Criterion)
+	assert.Equal(t, 0, len(topic.Action))
+
+	assert.Equal(t, test, fmt.Sprintf("%q", topic.String()))
+}
+
 func TestTopicThingsWithAction(t *testing.T) {
 	test := `"org.eclipse.kanto/test/things/twin/commands/modify"`
 
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// maxFragmentLength is the maximum length of the offending JSON fragment quoted in the envelope errors.
+const maxFragmentLength = 64
+
+// EnvelopeError describes the envelope field, which could not be parsed.
+type EnvelopeError struct {
+	// Field is the name of the invalid envelope field, empty if the envelope itself is not a JSON object.
+	Field string
+	// Reason describes why the field is invalid.
+	Reason string
+	// Fragment is the offending JSON fragment, truncated if too long.
+	Fragment string
+}
+
+func (e *EnvelopeError) Error() string {
+	if len(e.Field) == 0 {
+		return fmt.Sprintf("invalid envelope: %s, in '%s'", e.Reason, e.Fragment)
+	}
+	return fmt.Sprintf("invalid envelope field '%s': %s, in '%s'", e.Field, e.Reason, e.Fragment)
+}
+
+// envelopeFields are the envelope fields in validation order with the types they are parsed into,
+// so that the topic and the headers of the envelope are available even if a later field is invalid.
+var envelopeFields = []struct {
+	name     string
+	expected string
+	value    func(env *Envelope) interface{}
+}{
+	{"topic", "string", func(env *Envelope) interface{} { return &env.Topic }},
+	{"headers", "object", func(env *Envelope) interface{} { return &env.Headers }},
+	{"path", "string", func(env *Envelope) interface{} { return &env.Path }},
+	{"value", "JSON value", func(env *Envelope) interface{} { return &env.Value }},
+	{"fields", "string", func(env *Envelope) interface{} { return &env.Fields }},
+	{"extra", "JSON value", func(env *Envelope) interface{} { return &env.Extra }},
+	{"status", "integer", func(env *Envelope) interface{} { return &env.Status }},
+	{"revision", "integer", func(env *Envelope) interface{} { return &env.Revision }},
+	{"timestamp", "string", func(env *Envelope) interface{} { return &env.Timestamp }},
+}
+
+// ValidateEnvelope parses the JSON encoded envelope field by field, reporting exactly which field is invalid.
+// Returns the envelope with the fields parsed before the invalid one, e.g. to respond with an error,
+// and an *EnvelopeError if the envelope is invalid.
+func ValidateEnvelope(payload []byte) (*Envelope, error) {
+	env := &Envelope{}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return env, &EnvelopeError{Reason: jsonReason(err, "object"), Fragment: fragment(payload)}
+	}
+
+	for _, field := range envelopeFields {
+		value, ok := raw[field.name]
+		if !ok {
+			continue
+		}
+		// the field is kept unset if invalid
+		parsed := *env
+		if err := json.Unmarshal(value, field.value(&parsed)); err != nil {
+			return env, &EnvelopeError{
+				Field:    field.name,
+				Reason:   jsonReason(err, field.expected),
+				Fragment: fragment(value),
+			}
+		}
+		*env = parsed
+	}
+	return env, nil
+}
+
+func jsonReason(err error, expected string) string {
+	switch jsonErr := err.(type) {
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf("expected %s, found %s", expected, jsonErr.Value)
+	case *json.SyntaxError:
+		return fmt.Sprintf("malformed JSON at offset %d: %s", jsonErr.Offset, jsonErr)
+	default:
+		return err.Error()
+	}
+}
+
+func fragment(value []byte) string {
+	if len(value) > maxFragmentLength {
+		return string(value[:maxFragmentLength]) + "..."
+	}
+	return string(value)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package protocol_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+func TestValidateEnvelope(t *testing.T) {
+	env, err := protocol.ValidateEnvelope([]byte(`{
+		"topic": "org.eclipse.kanto/test/things/twin/commands/modify",
+		"headers": {"correlation-id": "test"},
+		"path": "/features/meter",
+		"value": {"properties": {"x": 1}},
+		"status": 200
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "org.eclipse.kanto", env.Topic.Namespace)
+	assert.Equal(t, "test", env.Headers.CorrelationID())
+	assert.Equal(t, "/features/meter", env.Path)
+	assert.Equal(t, 200, env.Status)
+}
+
+func TestValidateEnvelopeInvalid(t *testing.T) {
+	tests := []struct {
+		payload  string
+		field    string
+		reason   string
+		fragment string
+		topic    bool
+		headers  bool
+	}{
+		{
+			payload:  `[1, 2]`,
+			reason:   "expected object, found array",
+			fragment: `[1, 2]`,
+		},
+		{
+			payload:  `{"topic": "org.eclipse.kanto/test/things"}`,
+			field:    "topic",
+			reason:   "topic 'org.eclipse.kanto/test/things' has too few segments",
+			fragment: `"org.eclipse.kanto/test/things"`,
+		},
+		{
+			payload:  `{"topic": "org.eclipse.kanto/t§est/things/twin/commands/modify"}`,
+			field:    "topic",
+			reason:   "invalid topic namespaced ID, namespace: org.eclipse.kanto, entity name: t§est",
+			fragment: `"org.eclipse.kanto/t§est/things/twin/commands/modify"`,
+		},
+		{
+			payload:  `{"topic": "org.eclipse.kanto/test/things/twin/commands/modify", "headers": "test"}`,
+			field:    "headers",
+			reason:   "expected object, found string",
+			fragment: `"test"`,
+			topic:    true,
+		},
+		{
+			payload:  `{"topic": "org.eclipse.kanto/test/things/twin/commands/modify", "headers": {}, "path": 1}`,
+			field:    "path",
+			reason:   "expected string, found number",
+			fragment: `1`,
+			topic:    true,
+			headers:  true,
+		},
+		{
+			payload:  `{"topic": "org.eclipse.kanto/test/things/twin/commands/modify", "headers": {}, "status": "ok"}`,
+			field:    "status",
+			reason:   "expected integer, found string",
+			fragment: `"ok"`,
+			topic:    true,
+			headers:  true,
+		},
+	}
+
+	for _, test := range tests {
+		env, err := protocol.ValidateEnvelope([]byte(test.payload))
+		envelopeErr := &protocol.EnvelopeError{}
+		require.True(t, errors.As(err, &envelopeErr), test.payload)
+		assert.Equal(t, test.field, envelopeErr.Field, test.payload)
+		assert.Equal(t, test.reason, envelopeErr.Reason, test.payload)
+		assert.Equal(t, test.fragment, envelopeErr.Fragment, test.payload)
+		assert.Equal(t, test.topic, env.Topic != nil, test.payload)
+		assert.Equal(t, test.headers, env.Headers != nil, test.payload)
+	}
+}
+
+func TestValidateEnvelopeFragmentTruncated(t *testing.T) {
+	path := `"` + strings.Repeat("a", 100) + `"`
+	_, err := protocol.ValidateEnvelope([]byte(`{"path": [` + path + `]}`))
+	envelopeErr := &protocol.EnvelopeError{}
+	require.True(t, errors.As(err, &envelopeErr))
+	assert.Equal(t, "path", envelopeErr.Field)
+	assert.Equal(t, "["+path[:63]+"...", envelopeErr.Fragment)
+}
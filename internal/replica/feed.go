@@ -0,0 +1,140 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package replica
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/pkg/errors"
+)
+
+const defaultFeedSize = 1024
+
+// Change is a thing change streamed to the followers, with the complete current thing state
+// or without thing if the thing is deleted.
+type Change struct {
+	ThingID string       `json:"thingId"`
+	Change  string       `json:"change"`
+	Thing   *model.Thing `json:"thing,omitempty"`
+
+	seq uint64
+}
+
+// Feed records the things changes and retains the latest of them, so that the followers could resume
+// their streams from a resume token instead of receiving a new snapshot.
+type Feed struct {
+	Storage persistence.ThingsStorage
+
+	// Size is the number of the retained changes, 1024 if not set.
+	Size int
+
+	Logger logger.Logger
+
+	mutex   sync.Mutex
+	epoch   string
+	seq     uint64
+	changes []Change
+	notify  chan struct{}
+}
+
+// ThingChanged records the current state of the changed thing.
+func (f *Feed) ThingChanged(thingID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	change := Change{ThingID: thingID, Change: persistence.ChangeModified}
+	thing := &model.Thing{}
+	if err := f.Storage.GetThing(thingID, thing); err == nil {
+		change.Thing = thing
+	} else if errors.Is(err, persistence.ErrThingNotFound) {
+		change.Change = persistence.ChangeDeleted
+	} else {
+		f.Logger.Errorf("Thing '%s' change not recorded for the read replicas: %v", thingID, err)
+		return
+	}
+
+	f.init()
+	f.seq++
+	change.seq = f.seq
+	f.changes = append(f.changes, change)
+	if size := f.size(); len(f.changes) > size {
+		f.changes = append(f.changes[:0], f.changes[len(f.changes)-size:]...)
+	}
+
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// since returns the retained changes after the provided sequence number, the current sequence number
+// and a channel closed on the next change. Returns false if the changes after the provided sequence number
+// are no longer retained.
+func (f *Feed) since(seq uint64) ([]Change, uint64, <-chan struct{}, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.init()
+	if seq > f.seq {
+		return nil, f.seq, f.notify, false
+	}
+	if seq == f.seq {
+		return nil, f.seq, f.notify, true
+	}
+	if len(f.changes) == 0 || f.changes[0].seq > seq+1 {
+		return nil, f.seq, f.notify, false
+	}
+
+	start := int(seq + 1 - f.changes[0].seq)
+	changes := make([]Change, len(f.changes)-start)
+	copy(changes, f.changes[start:])
+	return changes, f.seq, f.notify, true
+}
+
+// token returns the resume token of the provided sequence number, valid for the feed lifetime.
+func (f *Feed) token(seq uint64) string {
+	return fmt.Sprintf("%s.%d", f.epoch, seq)
+}
+
+// resumeSeq returns the sequence number of the resume token, false if the token is not issued by the feed.
+func (f *Feed) resumeSeq(token string) (uint64, bool) {
+	f.mutex.Lock()
+	f.init()
+	epoch := f.epoch
+	f.mutex.Unlock()
+
+	if !strings.HasPrefix(token, epoch+".") {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(token[len(epoch)+1:], 10, 64)
+	return seq, err == nil
+}
+
+func (f *Feed) init() {
+	if f.notify == nil {
+		f.epoch = watermill.NewShortUUID()
+		f.notify = make(chan struct{})
+	}
+}
+
+func (f *Feed) size() int {
+	if f.Size > 0 {
+		return f.Size
+	}
+	return defaultFeedSize
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package replica
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultHeartbeatTimeout = 3 * defaultHeartbeat
+	defaultRetryInterval    = time.Second
+)
+
+// Follower maintains an in-memory read replica of the local digital twins things, following the things snapshot
+// and changes streamed by the twins replica server. The stream is resumed from the latest received token
+// on reconnecting, so that a new snapshot is received only if the missed changes are no longer retained.
+type Follower struct {
+	// Address is the replica server address, e.g. 'localhost:9080'.
+	Address string
+
+	// HeartbeatTimeout is the time without any update after which the stream is reconnected, 30 seconds if not set.
+	HeartbeatTimeout time.Duration
+	// RetryInterval is the interval of the reconnect attempts, 1 second if not set.
+	RetryInterval time.Duration
+
+	// OnChange, if set, is called with each change applied to the replica after the initial snapshot.
+	OnChange func(change Change)
+	// OnError, if set, is called with the stream error before reconnecting.
+	OnError func(err error)
+
+	mutex  sync.RWMutex
+	things map[string]*model.Thing
+	token  string
+	synced bool
+}
+
+// Run follows the things changes until the context is done, reconnecting on stream errors.
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		if err := f.follow(ctx); err != nil && f.OnError != nil && ctx.Err() == nil {
+			f.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.retryInterval()):
+		}
+	}
+}
+
+// Thing returns the replicated thing, false if there is no such thing. The returned thing must not be modified.
+func (f *Follower) Thing(thingID string) (*model.Thing, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	thing, ok := f.things[thingID]
+	return thing, ok
+}
+
+// ThingIDs returns the sorted IDs of the replicated things.
+func (f *Follower) ThingIDs() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	thingIDs := make([]string, 0, len(f.things))
+	for thingID := range f.things {
+		thingIDs = append(thingIDs, thingID)
+	}
+	sort.Strings(thingIDs)
+	return thingIDs
+}
+
+// Synchronized checks if the replica has received a consistent snapshot of the things.
+func (f *Follower) Synchronized() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.synced
+}
+
+// Token returns the resume token of the replica, empty if no snapshot is received yet.
+func (f *Follower) Token() string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.token
+}
+
+func (f *Follower) follow(ctx context.Context) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, err := grpc.DialContext(streamCtx, f.Address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(streamCtx, &serviceDesc.Streams[0], "/"+serviceName+"/"+methodFollow)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&FollowRequest{ResumeToken: f.Token()}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	timeout := f.heartbeatTimeout()
+	watchdog := time.AfterFunc(timeout, cancel)
+	defer watchdog.Stop()
+
+	var snapshot map[string]*model.Thing
+	for {
+		update := &Update{}
+		if err := stream.RecvMsg(update); err != nil {
+			return err
+		}
+		watchdog.Reset(timeout)
+		snapshot = f.apply(update, snapshot)
+	}
+}
+
+// apply applies the update to the replica, collecting the snapshot things until the snapshot is completed.
+func (f *Follower) apply(update *Update, snapshot map[string]*model.Thing) map[string]*model.Thing {
+	switch update.Type {
+	case UpdateSnapshot:
+		if snapshot == nil {
+			snapshot = make(map[string]*model.Thing)
+		}
+		if update.Change != nil && update.Change.Thing != nil {
+			snapshot[update.Change.ThingID] = update.Change.Thing
+		}
+
+	case UpdateSnapshotEnd:
+		if snapshot == nil {
+			snapshot = make(map[string]*model.Thing)
+		}
+		f.mutex.Lock()
+		f.things = snapshot
+		f.token = update.Token
+		f.synced = true
+		f.mutex.Unlock()
+		return nil
+
+	case UpdateChange:
+		if update.Change == nil {
+			return snapshot
+		}
+		f.mutex.Lock()
+		if f.things == nil {
+			f.things = make(map[string]*model.Thing)
+		}
+		if update.Change.Change == persistence.ChangeDeleted || update.Change.Thing == nil {
+			delete(f.things, update.Change.ThingID)
+		} else {
+			f.things[update.Change.ThingID] = update.Change.Thing
+		}
+		f.token = update.Token
+		f.mutex.Unlock()
+
+		if f.OnChange != nil {
+			f.OnChange(*update.Change)
+		}
+
+	case UpdateHeartbeat:
+		if snapshot == nil && len(update.Token) > 0 {
+			f.mutex.Lock()
+			f.token = update.Token
+			f.mutex.Unlock()
+		}
+	}
+	return snapshot
+}
+
+func (f *Follower) heartbeatTimeout() time.Duration {
+	if f.HeartbeatTimeout > 0 {
+		return f.HeartbeatTimeout
+	}
+	return defaultHeartbeatTimeout
+}
+
+func (f *Follower) retryInterval() time.Duration {
+	if f.RetryInterval > 0 {
+		return f.RetryInterval
+	}
+	return defaultRetryInterval
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package replica_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/replica"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testDeviceID = "org.eclipse.kanto:device"
+	thingA       = "org.eclipse.kanto:a"
+	thingB       = "org.eclipse.kanto:b"
+
+	waitFor = 5 * time.Second
+	tick    = 10 * time.Millisecond
+)
+
+type ReplicaSuite struct {
+	suite.Suite
+
+	storage persistence.ThingsStorage
+	feed    *replica.Feed
+	server  *replica.Server
+
+	mutex   sync.Mutex
+	changes []replica.Change
+}
+
+func TestReplicaSuite(t *testing.T) {
+	suite.Run(t, new(ReplicaSuite))
+}
+
+func (s *ReplicaSuite) SetupTest() {
+	storage, err := persistence.NewThingsDB(filepath.Join(s.T().TempDir(), "things.db"), testDeviceID)
+	require.NoError(s.T(), err)
+	s.storage = storage
+
+	log := testutil.NewLogger("replica", logger.DEBUG, s.T())
+	s.feed = &replica.Feed{Storage: storage, Size: 2, Logger: log}
+	s.server = &replica.Server{Feed: s.feed, Heartbeat: 50 * time.Millisecond, Logger: log}
+	require.NoError(s.T(), s.server.Start("127.0.0.1:0"))
+
+	s.changes = nil
+}
+
+func (s *ReplicaSuite) TearDownTest() {
+	s.server.Stop()
+	s.storage.Close()
+}
+
+func (s *ReplicaSuite) follower() *replica.Follower {
+	return &replica.Follower{
+		Address:          s.server.Addr().String(),
+		HeartbeatTimeout: time.Second,
+		RetryInterval:    50 * time.Millisecond,
+		OnChange: func(change replica.Change) {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			s.changes = append(s.changes, change)
+		},
+	}
+}
+
+func (s *ReplicaSuite) run(follower *replica.Follower) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follower.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (s *ReplicaSuite) receivedChanges() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.changes)
+}
+
+func (s *ReplicaSuite) modifyThing(thingID string, value interface{}) {
+	thing := (&model.Thing{}).WithIDFrom(thingID).WithAttribute("value", value)
+	_, err := s.storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	s.feed.ThingChanged(thingID)
+}
+
+func (s *ReplicaSuite) removeThing(thingID string) {
+	require.NoError(s.T(), s.storage.RemoveThing(thingID))
+	s.feed.ThingChanged(thingID)
+}
+
+func (s *ReplicaSuite) assertValue(follower *replica.Follower, thingID string, value interface{}) {
+	assert.Eventually(s.T(), func() bool {
+		thing, ok := follower.Thing(thingID)
+		return ok && thing.Attributes["value"] == value
+	}, waitFor, tick)
+}
+
+func (s *ReplicaSuite) TestFollow() {
+	s.modifyThing(thingA, "a1")
+
+	follower := s.follower()
+	stop := s.run(follower)
+	defer stop()
+
+	assert.Eventually(s.T(), follower.Synchronized, waitFor, tick)
+	s.assertValue(follower, thingA, "a1")
+	assert.Equal(s.T(), 0, s.receivedChanges())
+
+	s.modifyThing(thingB, "b1")
+	s.assertValue(follower, thingB, "b1")
+	assert.Equal(s.T(), []string{thingA, thingB}, follower.ThingIDs())
+
+	s.removeThing(thingA)
+	assert.Eventually(s.T(), func() bool {
+		_, ok := follower.Thing(thingA)
+		return !ok
+	}, waitFor, tick)
+
+	assert.Equal(s.T(), 2, s.receivedChanges())
+	assert.Equal(s.T(), persistence.ChangeDeleted, s.changes[1].Change)
+	assert.NotEmpty(s.T(), follower.Token())
+}
+
+func (s *ReplicaSuite) TestResume() {
+	s.modifyThing(thingA, "a1")
+
+	follower := s.follower()
+	stop := s.run(follower)
+	assert.Eventually(s.T(), follower.Synchronized, waitFor, tick)
+	stop()
+
+	// resumed from the token, the missed change is streamed
+	s.modifyThing(thingA, "a2")
+	stop = s.run(follower)
+	s.assertValue(follower, thingA, "a2")
+	assert.Equal(s.T(), 1, s.receivedChanges())
+	stop()
+
+	// more changes missed than retained, a new snapshot is streamed
+	s.modifyThing(thingA, "a3")
+	s.modifyThing(thingB, "b1")
+	s.modifyThing(thingB, "b2")
+	stop = s.run(follower)
+	defer stop()
+
+	s.assertValue(follower, thingA, "a3")
+	s.assertValue(follower, thingB, "b2")
+	assert.Equal(s.T(), 1, s.receivedChanges())
+}
+
+func (s *ReplicaSuite) TestHeartbeat() {
+	follower := s.follower()
+	stop := s.run(follower)
+	defer stop()
+
+	assert.Eventually(s.T(), follower.Synchronized, waitFor, tick)
+	token := follower.Token()
+
+	// no reconnect on the heartbeats only, the token is kept
+	time.Sleep(3 * s.server.Heartbeat)
+	assert.Equal(s.T(), token, follower.Token())
+	assert.Empty(s.T(), follower.ThingIDs())
+}
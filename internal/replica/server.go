@@ -0,0 +1,233 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package replica
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Update types.
+const (
+	// UpdateSnapshot carries a thing of the initial snapshot.
+	UpdateSnapshot = "snapshot"
+	// UpdateSnapshotEnd completes the initial snapshot, the replica is consistent as of its token.
+	UpdateSnapshotEnd = "snapshotEnd"
+	// UpdateChange carries a thing change following the snapshot or the resume token.
+	UpdateChange = "change"
+	// UpdateHeartbeat is sent periodically while there are no changes.
+	UpdateHeartbeat = "heartbeat"
+)
+
+const (
+	serviceName      = "kanto.ldt.replica.Replica"
+	methodFollow     = "Follow"
+	defaultHeartbeat = 10 * time.Second
+)
+
+// FollowRequest starts following the things changes. If the resume token is still valid,
+// only the changes after it are streamed, otherwise a new snapshot is streamed first.
+type FollowRequest struct {
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+// Update is a message streamed to a follower. The token is the resume token of the follower replica
+// after the update is applied.
+type Update struct {
+	Type   string  `json:"type"`
+	Token  string  `json:"token,omitempty"`
+	Change *Change `json:"change,omitempty"`
+}
+
+// followServer is the handler type of the replica service.
+type followServer interface {
+	follow(req *FollowRequest, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*followServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    methodFollow,
+			Handler:       followHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func followHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &FollowRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(followServer).follow(req, stream)
+}
+
+// jsonCodec encodes the replica stream messages as JSON, so that no generated protocol buffers are required.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// Server streams the things snapshot and changes to the read replicas followers over gRPC.
+type Server struct {
+	Feed *Feed
+
+	// Heartbeat is the interval of the heartbeats sent while there are no changes, 10 seconds if not set.
+	Heartbeat time.Duration
+
+	Logger logger.Logger
+
+	mutex    sync.Mutex
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// Start starts serving the followers on the provided TCP address.
+func (s *Server) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrap(err, "cannot listen for read replicas followers")
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+	server.RegisterService(&serviceDesc, s)
+	s.mutex.Lock()
+	s.listener = listener
+	s.server = server
+	s.mutex.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			s.Logger.Error("Read replicas server stopped", err, nil)
+		}
+	}()
+	s.Logger.Infof("Serving read replicas followers on %s", listener.Addr())
+	return nil
+}
+
+// Addr returns the address the followers are served on, nil if not started.
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop closes the followers streams and stops the server.
+func (s *Server) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		s.server.Stop()
+	}
+}
+
+func (s *Server) follow(req *FollowRequest, stream grpc.ServerStream) error {
+	seq, ok := s.Feed.resumeSeq(req.ResumeToken)
+	if ok {
+		_, _, _, ok = s.Feed.since(seq)
+	}
+	if !ok {
+		var err error
+		if seq, err = s.sendSnapshot(stream); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(s.heartbeat())
+	defer ticker.Stop()
+
+	for {
+		changes, current, next, retained := s.Feed.since(seq)
+		if !retained {
+			return status.Error(codes.Aborted, "the follower is too slow, follow again for a new snapshot")
+		}
+		for i := range changes {
+			update := &Update{Type: UpdateChange, Token: s.Feed.token(changes[i].seq), Change: &changes[i]}
+			if err := stream.SendMsg(update); err != nil {
+				return err
+			}
+		}
+		seq = current
+
+		select {
+		case <-next:
+		case <-ticker.C:
+			if err := stream.SendMsg(&Update{Type: UpdateHeartbeat, Token: s.Feed.token(seq)}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// sendSnapshot streams all things and returns the sequence number the snapshot is consistent with.
+// The changes recorded while streaming the snapshot are streamed after it.
+func (s *Server) sendSnapshot(stream grpc.ServerStream) (uint64, error) {
+	_, seq, _, _ := s.Feed.since(0)
+
+	thingIDs, err := s.Feed.Storage.GetThingIDs()
+	if err != nil {
+		return 0, status.Error(codes.Unavailable, err.Error())
+	}
+	for _, thingID := range thingIDs {
+		thing := &model.Thing{}
+		if err := s.Feed.Storage.GetThing(thingID, thing); err != nil {
+			if errors.Is(err, persistence.ErrThingNotFound) {
+				continue // deleted meanwhile
+			}
+			return 0, status.Error(codes.Unavailable, err.Error())
+		}
+		update := &Update{
+			Type:   UpdateSnapshot,
+			Change: &Change{ThingID: thingID, Change: persistence.ChangeCreated, Thing: thing},
+		}
+		if err := stream.SendMsg(update); err != nil {
+			return 0, err
+		}
+	}
+	return seq, stream.SendMsg(&Update{Type: UpdateSnapshotEnd, Token: s.Feed.token(seq)})
+}
+
+func (s *Server) heartbeat() time.Duration {
+	if s.Heartbeat > 0 {
+		return s.Heartbeat
+	}
+	return defaultHeartbeat
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package rql provides parsing and evaluation of the RQL (Resource Query Language) expressions
+// as used by the Ditto 'condition' header, e.g. and(eq(attributes/location,"kitchen"),exists(features/meter)).
+// See https://www.eclipse.org/ditto/basic-rql.html
+package rql
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opAnd    = "and"
+	opOr     = "or"
+	opNot    = "not"
+	opEq     = "eq"
+	opNe     = "ne"
+	opGt     = "gt"
+	opGe     = "ge"
+	opLt     = "lt"
+	opLe     = "le"
+	opIn     = "in"
+	opLike   = "like"
+	opILike  = "ilike"
+	opExists = "exists"
+
+	openingParenthesis = '('
+	closingParenthesis = ')'
+	argsSeparator      = ','
+	pathSeparator      = "/"
+)
+
+// Condition is a parsed RQL expression.
+type Condition interface {
+	// Evaluate returns true if the condition holds for the provided JSON value,
+	// i.e. a value as decoded by json.Unmarshal into an empty interface.
+	Evaluate(value interface{}) bool
+}
+
+type logicalCondition struct {
+	op         string
+	conditions []Condition
+}
+
+type notCondition struct {
+	condition Condition
+}
+
+type existsCondition struct {
+	property []string
+}
+
+type relationalCondition struct {
+	op       string
+	property []string
+	values   []interface{}
+	pattern  *regexp.Regexp
+}
+
+// Parse parses the provided RQL expression.
+// Returns error if the expression is not a valid RQL one.
+func Parse(expression string) (Condition, error) {
+	p := &parser{input: expression}
+	condition, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if !p.end() {
+		return nil, p.errorf("unexpected trailing characters '%s'", p.input[p.pos:])
+	}
+	return condition, nil
+}
+
+func (c *logicalCondition) Evaluate(value interface{}) bool {
+	for _, condition := range c.conditions {
+		if condition.Evaluate(value) == (c.op == opOr) {
+			return c.op == opOr
+		}
+	}
+	return c.op == opAnd
+}
+
+func (c *notCondition) Evaluate(value interface{}) bool {
+	return !c.condition.Evaluate(value)
+}
+
+func (c *existsCondition) Evaluate(value interface{}) bool {
+	_, ok := propertyValue(value, c.property)
+	return ok
+}
+
+func (c *relationalCondition) Evaluate(value interface{}) bool {
+	actual, ok := propertyValue(value, c.property)
+
+	switch c.op {
+	case opEq:
+		return ok && reflect.DeepEqual(actual, c.values[0])
+
+	case opNe:
+		return !ok || !reflect.DeepEqual(actual, c.values[0])
+
+	case opIn:
+		if ok {
+			for _, v := range c.values {
+				if reflect.DeepEqual(actual, v) {
+					return true
+				}
+			}
+		}
+		return false
+
+	case opLike, opILike:
+		s, isString := actual.(string)
+		return ok && isString && c.pattern.MatchString(s)
+
+	default:
+		if !ok {
+			return false
+		}
+		result, comparable := compare(actual, c.values[0])
+		if !comparable {
+			return false
+		}
+		switch c.op {
+		case opGt:
+			return result > 0
+		case opGe:
+			return result >= 0
+		case opLt:
+			return result < 0
+		default: // opLe
+			return result <= 0
+		}
+	}
+}
+
+// PropertyValue returns the value of the slash-separated property path within the provided JSON value,
+// e.g. 'attributes/location', and false if there is no such property.
+func PropertyValue(value interface{}, property string) (interface{}, bool) {
+	return propertyValue(value, strings.Split(strings.Trim(property, pathSeparator), pathSeparator))
+}
+
+// Compare compares two JSON values of the same number or string type.
+// Returns false if the values are not comparable.
+func Compare(a interface{}, b interface{}) (int, bool) {
+	return compare(a, b)
+}
+
+// Equalities returns the slash-separated property paths, which values must be equal to any of the returned values
+// for the condition to hold, e.g. 'attributes/location' and ["plant-7"] for and(eq(attributes/location,"plant-7"),
+// gt(attributes/floor,2)), so that the matching values could be looked up before evaluating the condition.
+// The properties of the or conditions are returned only if constrained by all of their conditions.
+func Equalities(c Condition) map[string][]interface{} {
+	switch condition := c.(type) {
+	case *relationalCondition:
+		switch condition.op {
+		case opEq:
+			return map[string][]interface{}{strings.Join(condition.property, pathSeparator): condition.values[:1]}
+		case opIn:
+			return map[string][]interface{}{strings.Join(condition.property, pathSeparator): condition.values}
+		}
+
+	case *logicalCondition:
+		var result map[string][]interface{}
+		for i, child := range condition.conditions {
+			equalities := Equalities(child)
+			switch {
+			case i == 0:
+				result = equalities
+			case condition.op == opAnd:
+				for property, values := range equalities {
+					if _, ok := result[property]; !ok {
+						result[property] = values
+					}
+				}
+			default:
+				for property, values := range result {
+					if other, ok := equalities[property]; ok {
+						result[property] = append(append([]interface{}{}, values...), other...)
+					} else {
+						delete(result, property)
+					}
+				}
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+	}
+	return map[string][]interface{}{}
+}
+
+func propertyValue(value interface{}, property []string) (interface{}, bool) {
+	current := value
+	for _, name := range property {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func compare(actual interface{}, expected interface{}) (int, bool) {
+	switch a := actual.(type) {
+	case float64:
+		if e, ok := expected.(float64); ok {
+			if a < e {
+				return -1, true
+			} else if a > e {
+				return 1, true
+			}
+			return 0, true
+		}
+
+	case string:
+		if e, ok := expected.(string); ok {
+			return strings.Compare(a, e), true
+		}
+	}
+	return 0, false
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) query() (Condition, error) {
+	op := p.operator()
+	if err := p.expect(openingParenthesis); err != nil {
+		return nil, err
+	}
+
+	var condition Condition
+	var err error
+
+	switch op {
+	case opAnd, opOr:
+		condition, err = p.logical(op)
+
+	case opNot:
+		var inner Condition
+		if inner, err = p.query(); err == nil {
+			condition = &notCondition{condition: inner}
+		}
+
+	case opExists:
+		var property []string
+		if property, err = p.property(); err == nil {
+			condition = &existsCondition{property: property}
+		}
+
+	case opEq, opNe, opGt, opGe, opLt, opLe, opIn, opLike, opILike:
+		condition, err = p.relational(op)
+
+	default:
+		return nil, p.errorf("unknown operator '%s'", op)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(closingParenthesis); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+func (p *parser) logical(op string) (Condition, error) {
+	condition := &logicalCondition{op: op}
+	for {
+		inner, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		condition.conditions = append(condition.conditions, inner)
+
+		if !p.next(argsSeparator) {
+			return condition, nil
+		}
+	}
+}
+
+func (p *parser) relational(op string) (Condition, error) {
+	property, err := p.property()
+	if err != nil {
+		return nil, err
+	}
+
+	condition := &relationalCondition{op: op, property: property}
+	for p.next(argsSeparator) {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		condition.values = append(condition.values, value)
+	}
+
+	if len(condition.values) == 0 || (op != opIn && len(condition.values) > 1) {
+		return nil, p.errorf("invalid number of arguments of operator '%s'", op)
+	}
+
+	if op == opLike || op == opILike {
+		pattern, ok := condition.values[0].(string)
+		if !ok {
+			return nil, p.errorf("operator '%s' requires a string value", op)
+		}
+		condition.pattern = likePattern(pattern, op == opILike)
+	}
+	return condition, nil
+}
+
+func likePattern(pattern string, caseInsensitive bool) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	if caseInsensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+func (p *parser) operator() string {
+	p.skipSpaces()
+	start := p.pos
+	for !p.end() && p.input[p.pos] >= 'a' && p.input[p.pos] <= 'z' {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) property() ([]string, error) {
+	token := strings.Trim(p.token(), pathSeparator)
+	if len(token) == 0 {
+		return nil, p.errorf("missing property")
+	}
+	return strings.Split(token, pathSeparator), nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	p.skipSpaces()
+	if !p.end() && (p.input[p.pos] == '"' || p.input[p.pos] == '\'') {
+		return p.quoted()
+	}
+
+	token := p.token()
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	number, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, p.errorf("invalid value '%s'", token)
+	}
+	return number, nil
+}
+
+func (p *parser) quoted() (string, error) {
+	quote := p.input[p.pos]
+	p.pos++
+
+	var value strings.Builder
+	for !p.end() {
+		c := p.input[p.pos]
+		p.pos++
+
+		if c == quote {
+			return value.String(), nil
+		}
+		if c == '\\' && !p.end() {
+			c = p.input[p.pos]
+			p.pos++
+		}
+		value.WriteByte(c)
+	}
+	return "", p.errorf("missing closing quote")
+}
+
+func (p *parser) token() string {
+	start := p.pos
+	for !p.end() && p.input[p.pos] != argsSeparator && p.input[p.pos] != closingParenthesis {
+		p.pos++
+	}
+	return strings.TrimSpace(p.input[start:p.pos])
+}
+
+func (p *parser) expect(c byte) error {
+	if !p.next(c) {
+		return p.errorf("expected '%c'", c)
+	}
+	return nil
+}
+
+func (p *parser) next(c byte) bool {
+	p.skipSpaces()
+	if !p.end() && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpaces() {
+	for !p.end() && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) end() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("invalid RQL expression '%s' at position %d: "+format,
+		append([]interface{}{p.input, p.pos}, args...)...)
+}
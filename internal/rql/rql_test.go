@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package rql_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testThing = `{
+	"thingId": "org.eclipse.kanto:test",
+	"attributes": {
+		"location": "Kitchen",
+		"floor": 2,
+		"active": true,
+		"owner": null
+	},
+	"features": {
+		"meter": {
+			"properties": {
+				"x": 10.5
+			}
+		}
+	}
+}`
+
+func TestEvaluate(t *testing.T) {
+	var thing interface{}
+	require.NoError(t, json.Unmarshal([]byte(testThing), &thing))
+
+	tests := map[string]bool{
+		`eq(attributes/location,"Kitchen")`:                  true,
+		`eq(attributes/location,'Kitchen')`:                  true,
+		`eq(/attributes/location,"Bedroom")`:                 false,
+		`eq(attributes/floor,2)`:                             true,
+		`eq(attributes/active,true)`:                         true,
+		`eq(attributes/owner,null)`:                          true,
+		`eq(attributes/missing,null)`:                        false,
+		`ne(attributes/floor,3)`:                             true,
+		`ne(attributes/missing,3)`:                           true,
+		`gt(features/meter/properties/x,10)`:                 true,
+		`ge(features/meter/properties/x,10.5)`:               true,
+		`lt(features/meter/properties/x,10.5)`:               false,
+		`le(attributes/location,"Living")`:                   true,
+		`gt(attributes/location,1)`:                          false,
+		`in(attributes/floor,1,2,3)`:                         true,
+		`in(attributes/floor,"2")`:                           false,
+		`like(attributes/location,"Kit*")`:                   true,
+		`like(attributes/location,"kit*")`:                   false,
+		`ilike(attributes/location,"kit?hen")`:               true,
+		`like(attributes/floor,"*")`:                         false,
+		`exists(features/meter)`:                             true,
+		`exists(features/sensor)`:                            false,
+		`not(exists(features/sensor))`:                       true,
+		`and(exists(attributes),eq(attributes/floor,2))`:     true,
+		`and(exists(attributes),eq(attributes/floor,3))`:     false,
+		`or(eq(attributes/floor,3), eq(attributes/floor,2))`: true,
+		`or(eq(attributes/floor,3),eq(attributes/floor,4))`:  false,
+		`eq(attributes/location,"Kit\"chen")`:                false,
+	}
+
+	for expression, expected := range tests {
+		condition, err := rql.Parse(expression)
+		require.NoError(t, err, expression)
+		assert.Equal(t, expected, condition.Evaluate(thing), expression)
+	}
+}
+
+func TestEvaluateNoValue(t *testing.T) {
+	condition, err := rql.Parse(`not(exists(attributes/location))`)
+	require.NoError(t, err)
+	assert.True(t, condition.Evaluate(nil))
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`eq`,
+		`eq(attributes/location,"Kitchen"`,
+		`eq(attributes/location,Kitchen)`,
+		`eq(attributes/location,"Kitchen)`,
+		`eq(attributes/location)`,
+		`eq(attributes/location,1,2)`,
+		`eq(,1)`,
+		`like(attributes/location,1)`,
+		`exists(attributes,1)`,
+		`unknown(attributes/location,1)`,
+		`and()`,
+		`not(eq(attributes/floor,2),eq(attributes/floor,2))`,
+		`eq(attributes/floor,2)eq(attributes/floor,2)`,
+	}
+
+	for _, expression := range tests {
+		_, err := rql.Parse(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestPropertyValue(t *testing.T) {
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(testThing), &value))
+
+	x, ok := rql.PropertyValue(value, "/features/meter/properties/x")
+	assert.True(t, ok)
+	assert.Equal(t, 10.5, x)
+
+	_, ok = rql.PropertyValue(value, "features/meter/properties/y")
+	assert.False(t, ok)
+
+	result, ok := rql.Compare("a", "b")
+	assert.True(t, ok)
+	assert.Equal(t, -1, result)
+
+	_, ok = rql.Compare("a", 1.0)
+	assert.False(t, ok)
+}
+
+func TestEqualities(t *testing.T) {
+	tests := map[string]map[string][]interface{}{
+		`eq(attributes/location,"plant-7")`: {"attributes/location": {"plant-7"}},
+		`in(attributes/floor,1,2)`:          {"attributes/floor": {1.0, 2.0}},
+		`and(eq(attributes/location,"plant-7"),gt(attributes/floor,2),eq(attributes/active,true))`: {
+			"attributes/location": {"plant-7"},
+			"attributes/active":   {true},
+		},
+		`or(eq(attributes/location,"a"),and(eq(attributes/location,"b"),eq(attributes/floor,1)))`: {
+			"attributes/location": {"a", "b"},
+		},
+		`or(eq(attributes/location,"a"),eq(attributes/floor,1))`: {},
+		`not(eq(attributes/location,"a"))`:                       {},
+		`exists(attributes/location)`:                            {},
+		`ne(attributes/location,"a")`:                            {},
+	}
+
+	for expression, expected := range tests {
+		t.Run(expression, func(t *testing.T) {
+			condition, err := rql.Parse(expression)
+			require.NoError(t, err)
+			assert.Equal(t, expected, rql.Equalities(condition))
+		})
+	}
+}
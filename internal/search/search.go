@@ -0,0 +1,262 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package search provides evaluation of the Ditto things search queries, i.e. RQL filter,
+// namespaces and options, against the JSON values of the locally stored things.
+// See https://www.eclipse.org/ditto/basic-search.html
+package search
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/rql"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSize is the page size used if no size option is provided.
+	DefaultSize = 25
+	// MaxSize is the maximum allowed page size.
+	MaxSize = 200
+
+	optionSort   = "sort"
+	optionSize   = "size"
+	optionCursor = "cursor"
+
+	propertyThingID    = "thingId"
+	namespaceSeparator = ":"
+)
+
+// SortOption defines a single search result sort criterion.
+type SortOption struct {
+	// Property is the slash-separated property path, e.g. 'attributes/location'.
+	Property string
+	// Descending is true if the results are sorted in descending order by the property.
+	Descending bool
+}
+
+// Options represents the parsed search options, e.g. 'sort(+thingId,-attributes/floor),size(10),cursor(MTA)'.
+type Options struct {
+	Sort   []SortOption
+	Size   int
+	Cursor string
+}
+
+// Query represents a things search query.
+type Query struct {
+	// Filter is the RQL filter, nil if all things are matching.
+	Filter rql.Condition
+	// Namespaces restricts the things namespaces, all are matching if empty.
+	Namespaces []string
+	// Options contains the sort and pagination options.
+	Options *Options
+}
+
+// ParseOptions parses the comma-separated search options.
+// Returns error if an option is unknown or not well-formed.
+func ParseOptions(options string) (*Options, error) {
+	result := &Options{Size: DefaultSize}
+	for _, option := range splitArgs(options) {
+		if len(option) == 0 {
+			continue
+		}
+
+		start := strings.IndexRune(option, '(')
+		if start <= 0 || !strings.HasSuffix(option, ")") {
+			return nil, errors.Errorf("invalid search option '%s'", option)
+		}
+		name := option[:start]
+		args := option[start+1 : len(option)-1]
+
+		switch name {
+		case optionSort:
+			for _, arg := range splitArgs(args) {
+				if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+					return nil, errors.Errorf("invalid search sort option '%s'", arg)
+				}
+				result.Sort = append(result.Sort, SortOption{
+					Property:   strings.Trim(arg[1:], "/"),
+					Descending: arg[0] == '-',
+				})
+			}
+
+		case optionSize:
+			size, err := strconv.Atoi(args)
+			if err != nil || size <= 0 || size > MaxSize {
+				return nil, errors.Errorf("invalid search size option '%s', expected value between 1 and %d",
+					args, MaxSize)
+			}
+			result.Size = size
+
+		case optionCursor:
+			if _, err := cursorOffset(args); err != nil {
+				return nil, err
+			}
+			result.Cursor = args
+
+		default:
+			return nil, errors.Errorf("unknown search option '%s'", name)
+		}
+	}
+	return result, nil
+}
+
+// NewQuery creates a search query from the provided RQL filter, namespaces and options.
+// Empty filter matches all things.
+func NewQuery(filter string, namespaces []string, options string) (*Query, error) {
+	query := &Query{Namespaces: namespaces}
+	if len(strings.TrimSpace(filter)) > 0 {
+		condition, err := rql.Parse(filter)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter = condition
+	}
+
+	parsed, err := ParseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	query.Options = parsed
+	return query, nil
+}
+
+// MatchNamespace returns true if the thing ID is within the query namespaces.
+func (q *Query) MatchNamespace(thingID string) bool {
+	if len(q.Namespaces) == 0 {
+		return true
+	}
+	for _, namespace := range q.Namespaces {
+		if strings.HasPrefix(thingID, namespace+namespaceSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the thing JSON value is within the query namespaces and matches the query filter.
+func (q *Query) Match(thing interface{}) bool {
+	thingID, _ := rql.PropertyValue(thing, propertyThingID)
+	if id, ok := thingID.(string); !ok || !q.MatchNamespace(id) {
+		return false
+	}
+	return q.Filter == nil || q.Filter.Evaluate(thing)
+}
+
+// Select returns the things JSON values matching the query, sorted as defined by the sort option,
+// by ascending thing ID by default.
+func (q *Query) Select(things []interface{}) []interface{} {
+	result := make([]interface{}, 0)
+	for _, thing := range things {
+		if q.Match(thing) {
+			result = append(result, thing)
+		}
+	}
+	q.Sort(result)
+	return result
+}
+
+// Sort sorts the things JSON values as defined by the sort option, by ascending thing ID for equal ones.
+func (q *Query) Sort(things []interface{}) {
+	sortOptions := append(append([]SortOption{}, q.Options.Sort...), SortOption{Property: propertyThingID})
+	sort.SliceStable(things, func(i, j int) bool {
+		for _, option := range sortOptions {
+			if c := compareProperty(things[i], things[j], option.Property); c != 0 {
+				if option.Descending {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// Page returns the page of the provided matching things as defined by the cursor and size options
+// and the cursor of the next page or an empty string if this is the last one.
+func (q *Query) Page(things []interface{}) ([]interface{}, string, error) {
+	offset, err := cursorOffset(q.Options.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if offset >= len(things) {
+		return make([]interface{}, 0), "", nil
+	}
+
+	end := offset + q.Options.Size
+	if end >= len(things) {
+		return things[offset:], "", nil
+	}
+	return things[offset:end], pageCursor(end), nil
+}
+
+// compareProperty compares the property values of two things, missing values are ordered first.
+func compareProperty(a interface{}, b interface{}, property string) int {
+	valueA, okA := rql.PropertyValue(a, property)
+	valueB, okB := rql.PropertyValue(b, property)
+	if !okA || !okB {
+		if okA {
+			return 1
+		}
+		if okB {
+			return -1
+		}
+		return 0
+	}
+	result, _ := rql.Compare(valueA, valueB)
+	return result
+}
+
+func pageCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func cursorOffset(cursor string) (int, error) {
+	if len(cursor) == 0 {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		var offset int
+		if offset, err = strconv.Atoi(string(data)); err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, errors.Errorf("invalid search cursor '%s'", cursor)
+}
+
+// splitArgs splits the comma-separated arguments, ignoring the commas within parentheses.
+func splitArgs(args string) []string {
+	var result []string
+	depth := 0
+	start := 0
+	for i, c := range args {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, strings.TrimSpace(args[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(args[start:]); len(rest) > 0 || len(result) > 0 {
+		result = append(result, rest)
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testThings = `[
+	{"thingId": "org.eclipse.kanto:c", "attributes": {"floor": 1, "location": "kitchen"}},
+	{"thingId": "org.eclipse.kanto:a", "attributes": {"floor": 2, "location": "hall"}},
+	{"thingId": "org.eclipse.kanto:b", "attributes": {"floor": 1}},
+	{"thingId": "other:d", "attributes": {"floor": 3, "location": "kitchen"}}
+]`
+
+func TestParseOptions(t *testing.T) {
+	options, err := search.ParseOptions("sort(+thingId,-attributes/floor),size(10),cursor(MTA)")
+	require.NoError(t, err)
+	assert.Equal(t, &search.Options{
+		Sort: []search.SortOption{
+			{Property: "thingId"},
+			{Property: "attributes/floor", Descending: true},
+		},
+		Size:   10,
+		Cursor: "MTA",
+	}, options)
+
+	options, err = search.ParseOptions("")
+	require.NoError(t, err)
+	assert.Equal(t, &search.Options{Size: search.DefaultSize}, options)
+}
+
+func TestParseOptionsInvalid(t *testing.T) {
+	tests := []string{
+		"size",
+		"size(0)",
+		"size(201)",
+		"size(a)",
+		"sort(thingId)",
+		"sort(+)",
+		"cursor(invalid!)",
+		"limit(0,10)",
+	}
+
+	for _, options := range tests {
+		_, err := search.ParseOptions(options)
+		assert.Error(t, err, options)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	query, err := search.NewQuery(`eq(attributes/location,"kitchen")`, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org.eclipse.kanto:c", "other:d"}, thingIDs(query.Select(things(t))))
+
+	query, err = search.NewQuery("", []string{"org.eclipse.kanto"}, "sort(-attributes/floor)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org.eclipse.kanto:a", "org.eclipse.kanto:b", "org.eclipse.kanto:c"},
+		thingIDs(query.Select(things(t))))
+
+	query, err = search.NewQuery("exists(attributes/location)", nil, "sort(+attributes/location,-thingId)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org.eclipse.kanto:a", "other:d", "org.eclipse.kanto:c"},
+		thingIDs(query.Select(things(t))))
+}
+
+func TestPage(t *testing.T) {
+	query, err := search.NewQuery("", nil, "size(3)")
+	require.NoError(t, err)
+
+	selected := query.Select(things(t))
+	page, cursor, err := query.Page(selected)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org.eclipse.kanto:a", "org.eclipse.kanto:b", "org.eclipse.kanto:c"}, thingIDs(page))
+	require.NotEmpty(t, cursor)
+
+	query.Options.Cursor = cursor
+	page, cursor, err = query.Page(selected)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other:d"}, thingIDs(page))
+	assert.Empty(t, cursor)
+}
+
+func TestNewQueryInvalid(t *testing.T) {
+	_, err := search.NewQuery("eq(attributes/location)", nil, "")
+	assert.Error(t, err)
+
+	_, err = search.NewQuery("", nil, "size(-1)")
+	assert.Error(t, err)
+}
+
+func things(t *testing.T) []interface{} {
+	var values []interface{}
+	require.NoError(t, json.Unmarshal([]byte(testThings), &values))
+	return values
+}
+
+func thingIDs(things []interface{}) []string {
+	ids := make([]string, len(things))
+	for i, thing := range things {
+		ids[i] = thing.(map[string]interface{})["thingId"].(string)
+	}
+	return ids
+}
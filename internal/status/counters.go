@@ -0,0 +1,185 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+// Counter names.
+const (
+	// CounterCommandsHandled counts the twin commands handled locally.
+	CounterCommandsHandled = "commandsHandled"
+	// CounterFeaturesSynchronized counts the features synchronized with the hub after being modified offline.
+	CounterFeaturesSynchronized = "featuresSynchronized"
+	// CounterErrors counts the twin commands replied with an error response.
+	CounterErrors = "errors"
+	// CounterStorageStalls counts the twin commands failed as their execution stalled on the things storage.
+	CounterStorageStalls = "storageStalls"
+
+	// PropertyCounters is the status feature property containing the metrics counters.
+	PropertyCounters = "counters"
+)
+
+// CountersValue is the value of the metrics counters property.
+type CountersValue struct {
+	Since  string            `json:"since"`
+	Values map[string]uint64 `json:"values"`
+}
+
+// Counters contains the metrics counters, which are persisted periodically in the things storage,
+// so that they survive restarts.
+//
+// The counters are cumulative since their last reset, i.e. since they were first persisted in the things db
+// or since Reset was invoked. The increments since the last persistence are lost if the process is killed,
+// and all counters start again from zero if the things db is replaced, e.g. by a backup of another device.
+type Counters struct {
+	Storage  persistence.ThingsStorage
+	Interval time.Duration
+
+	Logger logger.Logger
+
+	mutex  sync.Mutex
+	values map[string]uint64
+	since  time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Load loads the persisted counters, adding them to the ones counted so far.
+// The counting starts from now if no counters are persisted yet.
+func (c *Counters) Load() error {
+	persisted := data.CountersData{}
+	err := c.Storage.GetCounters(&persisted)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.init()
+	if err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for name, value := range persisted.Values {
+		c.values[name] += value
+	}
+	if since, err := time.Parse(time.RFC3339, persisted.Since); err == nil {
+		c.since = since
+	}
+	return nil
+}
+
+// Inc increments the named counter, it is a no-op on nil counters.
+func (c *Counters) Inc(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.init()
+	c.values[name]++
+}
+
+// Value returns the current counters.
+func (c *Counters) Value() CountersValue {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.init()
+	values := make(map[string]uint64, len(c.values))
+	for name, value := range c.values {
+		values[name] = value
+	}
+	return CountersValue{
+		Since:  c.since.Format(time.RFC3339),
+		Values: values,
+	}
+}
+
+// Reset sets all counters to zero and starts the counting from now. The reset counters are persisted.
+func (c *Counters) Reset() error {
+	c.mutex.Lock()
+	c.values = make(map[string]uint64)
+	c.since = time.Now()
+	c.mutex.Unlock()
+
+	return c.Persist()
+}
+
+// Persist persists the current counters in the things storage.
+func (c *Counters) Persist() error {
+	value := c.Value()
+	return c.Storage.SetCounters(&data.CountersData{
+		Values: value.Values,
+		Since:  value.Since,
+	})
+}
+
+// Start starts the periodic persistence, it is a no-op if the persistence interval is not positive.
+func (c *Counters) Start() {
+	if c.Interval <= 0 {
+		return
+	}
+
+	c.stop = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := c.Persist(); err != nil {
+					c.Logger.Error("Failed to persist the metrics counters", err, nil)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic persistence and persists the current counters.
+func (c *Counters) Stop() {
+	if c.stop != nil {
+		close(c.stop)
+		c.wg.Wait()
+		c.stop = nil
+	}
+
+	if err := c.Persist(); err != nil {
+		c.Logger.Error("Failed to persist the metrics counters", err, nil)
+	}
+}
+
+func (c *Counters) init() {
+	if c.values == nil {
+		c.values = make(map[string]uint64)
+		c.since = time.Now()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const countersDB = "things_counters_test.db"
+
+func TestCountersSurviveRestart(t *testing.T) {
+	defer os.Remove(countersDB)
+
+	storage, err := persistence.NewThingsDB(countersDB, testDeviceID)
+	require.NoError(t, err)
+
+	counters := newTestCounters(t, storage)
+	require.NoError(t, counters.Load())
+	counters.Inc(status.CounterCommandsHandled)
+	counters.Inc(status.CounterCommandsHandled)
+	counters.Inc(status.CounterErrors)
+	since := counters.Value().Since
+	counters.Stop()
+	require.NoError(t, storage.Close())
+
+	storage, err = persistence.NewThingsDB(countersDB, testDeviceID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	restarted := newTestCounters(t, storage)
+	require.NoError(t, restarted.Load())
+	restarted.Inc(status.CounterCommandsHandled)
+
+	value := restarted.Value()
+	assert.Equal(t, since, value.Since)
+	assert.Equal(t, map[string]uint64{
+		status.CounterCommandsHandled: 3,
+		status.CounterErrors:          1,
+	}, value.Values)
+
+	require.NoError(t, restarted.Reset())
+	assert.Empty(t, restarted.Value().Values)
+
+	reset := newTestCounters(t, storage)
+	require.NoError(t, reset.Load())
+	assert.Empty(t, reset.Value().Values)
+}
+
+func TestCountersNil(t *testing.T) {
+	var counters *status.Counters
+	assert.NotPanics(t, func() {
+		counters.Inc(status.CounterErrors)
+	})
+}
+
+func TestReportCounters(t *testing.T) {
+	counters := &status.Counters{}
+	counters.Inc(status.CounterFeaturesSynchronized)
+
+	pub := &testPublisher{}
+	reporter := &status.Reporter{
+		DeviceID:  testDeviceID,
+		Publisher: pub,
+		Counters:  counters,
+		Logger:    testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	require.NoError(t, reporter.Report())
+
+	msgs := pub.published()
+	require.Equal(t, 1, len(msgs))
+
+	value := map[string]struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}{}
+	require.NoError(t, json.Unmarshal(msgs[0].Value, &value))
+
+	reported := status.CountersValue{}
+	require.NoError(t, json.Unmarshal(value[status.FeatureID].Properties[status.PropertyCounters], &reported))
+	assert.NotEmpty(t, reported.Since)
+	assert.Equal(t, map[string]uint64{status.CounterFeaturesSynchronized: 1}, reported.Values)
+}
+
+func newTestCounters(t *testing.T, storage persistence.ThingsStorage) *status.Counters {
+	return &status.Counters{
+		Storage: storage,
+		Logger:  testutil.NewLogger("status", logger.DEBUG, t),
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// SubjectQuotaExceeded is the subject of the status feature outbox message published when the stored size
+// of a thing exceeds the configured per-thing quota.
+const SubjectQuotaExceeded = "quotaExceeded"
+
+// QuotaWarning is the payload of the quota exceeded message.
+type QuotaWarning struct {
+	// ThingID is the ID of the thing exceeding the quota.
+	ThingID string `json:"thingId"`
+	// Usage is the stored size of the thing in bytes.
+	Usage int64 `json:"usage"`
+	// Quota is the configured per-thing quota in bytes.
+	Quota int64 `json:"quota"`
+}
+
+// PublishQuotaExceeded publishes the quota exceeded message of the status feature of the device thing.
+func PublishQuotaExceeded(publisher message.Publisher, deviceID string, warning *QuotaWarning) error {
+	msg := things.NewMessage(model.NewNamespacedIDFrom(deviceID)).
+		Feature(FeatureID).
+		Outbox(SubjectQuotaExceeded).
+		WithPayload(warning)
+	return publishLocal(publisher, msg.Envelope(protocol.NewHeaders().WithContentType(protocol.ContentTypeJSON)))
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// SubjectRecovered is the subject of the status feature outbox message published when the messages router
+// is restarted and the commands pending on its stop are awaited again.
+const SubjectRecovered = "recovered"
+
+// Recovery reasons.
+const (
+	// RecoveryRestarted is the recovery after a requested restart, e.g. on provisioning change or service restart.
+	RecoveryRestarted = "restarted"
+	// RecoveryRouterStopped is the recovery after an unexpected messages router stop,
+	// e.g. as all of its subscriptions are closed.
+	RecoveryRouterStopped = "routerStopped"
+)
+
+// Recovery is the payload of the recovered message.
+type Recovery struct {
+	// Reason is the reason of the router stop, RecoveryRestarted or RecoveryRouterStopped.
+	Reason string `json:"reason"`
+	// Stopped is the timestamp of the router stop.
+	Stopped string `json:"stopped,omitempty"`
+	// Resumed is the number of the pending commands awaited again.
+	Resumed int `json:"resumed"`
+	// Expired is the number of the pending commands timed out while the router was stopped.
+	Expired int `json:"expired"`
+}
+
+// PublishRecovery publishes the recovered message of the status feature of the device thing.
+func PublishRecovery(publisher message.Publisher, deviceID string, recovery *Recovery) error {
+	msg := things.NewMessage(model.NewNamespacedIDFrom(deviceID)).
+		Feature(FeatureID).
+		Outbox(SubjectRecovered).
+		WithPayload(recovery)
+	return publishLocal(publisher, msg.Envelope(protocol.NewHeaders().WithContentType(protocol.ContentTypeJSON)))
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package status
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+const (
+	// FeatureID is the ID of the status feature of the device thing.
+	FeatureID = "status"
+	// PropertyProcess is the status feature property containing the process stats.
+	PropertyProcess = "process"
+	// PropertyStorage is the status feature property containing the things storage write and size stats.
+	PropertyStorage = "storage"
+	// SubjectResourceWarning is the subject of the status feature outbox message
+	// published when a process stats threshold is exceeded.
+	SubjectResourceWarning = "resourceWarning"
+
+	// topicLocalCommand is the local broker topic the commands of the status feature are published on,
+	// so that they are handled as any other local command.
+	topicLocalCommand = "e"
+)
+
+// Thresholds contains the process stats limits, on exceeding which a warning is published.
+// A zero limit is not checked.
+type Thresholds struct {
+	HeapAlloc  uint64
+	Goroutines int
+	OpenFiles  int
+	CPUUsage   float64
+}
+
+// Warning is the payload of the resource warning message.
+type Warning struct {
+	Resource  string  `json:"resource"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Reporter periodically publishes the process stats as property of the status feature of the device thing,
+// together with the metrics counters and the things storage write and size stats, if set.
+type Reporter struct {
+	DeviceID   string
+	Publisher  message.Publisher
+	Interval   time.Duration
+	Thresholds Thresholds
+	Counters   *Counters
+	Storage    persistence.ThingsStorage
+
+	Logger logger.Logger
+
+	sampler sampler
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Start starts the periodic reporting, it is a no-op if the reporting interval is not positive.
+func (r *Reporter) Start() {
+	if r.Interval <= 0 {
+		return
+	}
+
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := r.Report(); err != nil {
+					r.Logger.Error("Failed to publish the process stats", err, nil)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic reporting and waits for the reporting in progress, if any.
+func (r *Reporter) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	r.wg.Wait()
+	r.stop = nil
+}
+
+// Report samples the process stats and publishes them together with the warnings for the exceeded thresholds.
+func (r *Reporter) Report() error {
+	stats := r.sampler.sample()
+	thingID := model.NewNamespacedIDFrom(r.DeviceID)
+
+	properties := map[string]interface{}{PropertyProcess: stats}
+	if r.Counters != nil {
+		properties[PropertyCounters] = r.Counters.Value()
+	}
+	if r.Storage != nil {
+		stats, err := r.Storage.Stats()
+		if err != nil {
+			r.Logger.Error("Failed to retrieve the things storage stats", err, nil)
+		}
+		properties[PropertyStorage] = newStorageStats(r.Storage.GetWriteStats(), stats)
+	}
+	cmd := things.NewCommand(thingID).Twin().Features().
+		Merge(map[string]interface{}{
+			FeatureID: map[string]interface{}{
+				"properties": properties,
+			},
+		})
+	headers := protocol.NewHeaders().
+		WithContentType(protocol.ContentTypeJSONMerge).
+		WithResponseRequired(false)
+	if err := r.publish(cmd.Envelope(headers)); err != nil {
+		return err
+	}
+
+	for _, warning := range r.Thresholds.exceeded(stats) {
+		r.Logger.Warn("Process resource threshold exceeded", nil, watermill.LogFields{
+			"resource":  warning.Resource,
+			"value":     warning.Value,
+			"threshold": warning.Threshold,
+		})
+
+		msg := things.NewMessage(thingID).Feature(FeatureID).Outbox(SubjectResourceWarning).WithPayload(warning)
+		if err := r.publish(msg.Envelope(protocol.NewHeaders().WithContentType(protocol.ContentTypeJSON))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reporter) publish(env *protocol.Envelope) error {
+	return publishLocal(r.Publisher, env)
+}
+
+// publishLocal publishes the envelope as a local command.
+func publishLocal(publisher message.Publisher, env *protocol.Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return publisher.Publish(topicLocalCommand, message.NewMessage(watermill.NewUUID(), data))
+}
+
+func (t Thresholds) exceeded(stats *ProcessStats) []Warning {
+	var warnings []Warning
+	if t.HeapAlloc > 0 && stats.HeapAlloc > t.HeapAlloc {
+		warnings = append(warnings, Warning{"heapAlloc", float64(stats.HeapAlloc), float64(t.HeapAlloc)})
+	}
+	if t.Goroutines > 0 && stats.Goroutines > t.Goroutines {
+		warnings = append(warnings, Warning{"goroutines", float64(stats.Goroutines), float64(t.Goroutines)})
+	}
+	if t.OpenFiles > 0 && stats.OpenFiles > t.OpenFiles {
+		warnings = append(warnings, Warning{"openFiles", float64(stats.OpenFiles), float64(t.OpenFiles)})
+	}
+	if t.CPUUsage > 0 && stats.CPUUsage > t.CPUUsage {
+		warnings = append(warnings, Warning{"cpuUsage", stats.CPUUsage, t.CPUUsage})
+	}
+	return warnings
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package status_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const testDeviceID = "org.eclipse.kanto:test"
+
+type testPublisher struct {
+	mutex sync.Mutex
+	msgs  []*protocol.Envelope
+}
+
+func (p *testPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, msg := range msgs {
+		env := &protocol.Envelope{}
+		if err := json.Unmarshal(msg.Payload, env); err != nil {
+			return err
+		}
+		p.msgs = append(p.msgs, env)
+	}
+	return nil
+}
+
+func (p *testPublisher) Close() error {
+	return nil
+}
+
+func (p *testPublisher) published() []*protocol.Envelope {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.msgs
+}
+
+func TestReport(t *testing.T) {
+	pub := &testPublisher{}
+	reporter := &status.Reporter{
+		DeviceID:  testDeviceID,
+		Publisher: pub,
+		Logger:    testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	require.NoError(t, reporter.Report())
+
+	msgs := pub.published()
+	require.Equal(t, 1, len(msgs))
+	assert.Equal(t, "org.eclipse.kanto/test/things/twin/commands/merge", msgs[0].Topic.String())
+	assert.Equal(t, "/features", msgs[0].Path)
+	assert.False(t, msgs[0].Headers.ResponseRequired())
+
+	value := map[string]struct {
+		Properties map[string]status.ProcessStats `json:"properties"`
+	}{}
+	require.NoError(t, json.Unmarshal(msgs[0].Value, &value))
+	stats := value[status.FeatureID].Properties[status.PropertyProcess]
+	assert.True(t, stats.HeapAlloc > 0)
+	assert.True(t, stats.Goroutines > 0)
+}
+
+func TestReportStorage(t *testing.T) {
+	storage, err := persistence.NewThingsDB(filepath.Join(t.TempDir(), "things.db"), testDeviceID)
+	require.NoError(t, err)
+	defer storage.Close()
+	_, err = storage.AddThing((&model.Thing{}).WithIDFrom(testDeviceID))
+	require.NoError(t, err)
+
+	pub := &testPublisher{}
+	reporter := &status.Reporter{
+		DeviceID:  testDeviceID,
+		Publisher: pub,
+		Storage:   storage,
+		Logger:    testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	require.NoError(t, reporter.Report())
+
+	msgs := pub.published()
+	require.Equal(t, 1, len(msgs))
+	value := map[string]struct {
+		Properties map[string]status.StorageStats `json:"properties"`
+	}{}
+	require.NoError(t, json.Unmarshal(msgs[0].Value, &value))
+	stats := value[status.FeatureID].Properties[status.PropertyStorage]
+	assert.True(t, stats.PayloadBytes > 0)
+	assert.True(t, stats.EncodedBytes > 0)
+	assert.True(t, stats.WrittenBytes > stats.PayloadBytes)
+	assert.Equal(t, float64(stats.WrittenBytes)/float64(stats.PayloadBytes), stats.WriteAmplification)
+	assert.True(t, stats.FileSize > 0)
+	assert.Equal(t, 1, stats.Things)
+	assert.Empty(t, stats.Compacted)
+}
+
+func TestReportWarnings(t *testing.T) {
+	pub := &testPublisher{}
+	reporter := &status.Reporter{
+		DeviceID:   testDeviceID,
+		Publisher:  pub,
+		Thresholds: status.Thresholds{HeapAlloc: 1, Goroutines: 1},
+		Logger:     testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	require.NoError(t, reporter.Report())
+
+	msgs := pub.published()
+	require.Equal(t, 3, len(msgs))
+	for i, resource := range []string{"heapAlloc", "goroutines"} {
+		msg := msgs[i+1]
+		assert.Equal(t, protocol.CriterionMessages, msg.Topic.Criterion)
+		assert.Equal(t, "/features/status/outbox/messages/resourceWarning", msg.Path)
+
+		warning := status.Warning{}
+		require.NoError(t, json.Unmarshal(msg.Value, &warning))
+		assert.Equal(t, resource, warning.Resource)
+		assert.EqualValues(t, 1, warning.Threshold)
+		assert.True(t, warning.Value > warning.Threshold)
+	}
+}
+
+func TestReporterStartStop(t *testing.T) {
+	pub := &testPublisher{}
+	reporter := &status.Reporter{
+		DeviceID:  testDeviceID,
+		Publisher: pub,
+		Interval:  10 * time.Millisecond,
+		Logger:    testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	reporter.Start()
+	time.Sleep(100 * time.Millisecond)
+	reporter.Stop()
+
+	published := len(pub.published())
+	assert.True(t, published > 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, published, len(pub.published()))
+
+	disabled := &status.Reporter{Publisher: pub}
+	disabled.Start()
+	disabled.Stop()
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package status
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+)
+
+// ProcessStats contains the resource usage of the local digital twins process.
+type ProcessStats struct {
+	HeapAlloc      uint64  `json:"heapAlloc"`
+	HeapSys        uint64  `json:"heapSys"`
+	Goroutines     int     `json:"goroutines"`
+	GCCount        uint32  `json:"gcCount"`
+	GCPauseTotalNs uint64  `json:"gcPauseTotalNs"`
+	GCLastPauseNs  uint64  `json:"gcLastPauseNs"`
+	OpenFiles      int     `json:"openFiles"`
+	CPUUsage       float64 `json:"cpuUsage"`
+}
+
+// StorageStats contains the sizes of the data written into the things storage since it is opened,
+// together with the current size of the storage, if available.
+// The write amplification is the ratio of the bytes written on the disk to the logical payload bytes,
+// e.g. an estimate of the flash wear per workload, 0 if nothing is written yet.
+type StorageStats struct {
+	PayloadBytes       uint64  `json:"payloadBytes"`
+	EncodedBytes       uint64  `json:"encodedBytes"`
+	WrittenBytes       uint64  `json:"writtenBytes"`
+	WriteAmplification float64 `json:"writeAmplification"`
+
+	FileSize    int64  `json:"fileSize"`
+	Things      int    `json:"things"`
+	Features    int    `json:"features"`
+	JournalSize int64  `json:"journalSize"`
+	Compacted   string `json:"compacted,omitempty"`
+}
+
+func newStorageStats(writes persistence.WriteStats, stats *persistence.Stats) *StorageStats {
+	storageStats := &StorageStats{
+		PayloadBytes: writes.PayloadBytes,
+		EncodedBytes: writes.EncodedBytes,
+		WrittenBytes: writes.PageBytes,
+	}
+	if writes.PayloadBytes > 0 {
+		storageStats.WriteAmplification = float64(writes.PageBytes) / float64(writes.PayloadBytes)
+	}
+	if stats != nil {
+		storageStats.FileSize = stats.FileSize
+		storageStats.Things = stats.Things
+		storageStats.Features = stats.Features
+		storageStats.JournalSize = stats.JournalSize
+		storageStats.Compacted = stats.Compacted
+	}
+	return storageStats
+}
+
+// sampler collects the process stats, the CPU usage is evaluated as percentage
+// of the CPU time spent by the process since the previous sample.
+type sampler struct {
+	cpuTime  time.Duration
+	sampleAt time.Time
+}
+
+func (s *sampler) sample() *ProcessStats {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+
+	stats := &ProcessStats{
+		HeapAlloc:      mem.HeapAlloc,
+		HeapSys:        mem.HeapSys,
+		Goroutines:     runtime.NumGoroutine(),
+		GCCount:        mem.NumGC,
+		GCPauseTotalNs: mem.PauseTotalNs,
+		OpenFiles:      openFiles(),
+	}
+	if mem.NumGC > 0 {
+		stats.GCLastPauseNs = mem.PauseNs[(mem.NumGC+255)%256]
+	}
+
+	now := time.Now()
+	if cpuTime, ok := processCPUTime(); ok {
+		if !s.sampleAt.IsZero() && now.After(s.sampleAt) {
+			stats.CPUUsage = 100 * float64(cpuTime-s.cpuTime) / float64(now.Sub(s.sampleAt))
+		}
+		s.cpuTime = cpuTime
+	}
+	s.sampleAt = now
+	return stats
+}
+
+// openFiles returns the number of the process open file descriptors or -1 if not available on the platform.
+func openFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+	"github.com/eclipse-kanto/suite-connector/logger"
+)
+
+const (
+	// PropertyBlockedTopics is the status feature property containing the local broker topics
+	// blocked as persistently rejected.
+	PropertyBlockedTopics = "blockedTopics"
+	// SubjectConfigurationProblem is the subject of the status feature outbox message published
+	// when a local broker topic is blocked as persistently rejected.
+	SubjectConfigurationProblem = "configurationProblem"
+)
+
+// ErrTopicBlocked is returned on publishing to a local broker topic blocked as persistently rejected.
+var ErrTopicBlocked = errors.New("topic is blocked as rejected by the local broker")
+
+// ConfigurationProblem is the payload of the configuration problem message.
+type ConfigurationProblem struct {
+	// Topic is the blocked local broker topic.
+	Topic string `json:"topic"`
+	// Error is the last rejection error of the topic.
+	Error string `json:"error"`
+}
+
+// TopicGuard detects the local broker topics persistently rejected by the broker authorization, e.g. a response
+// or an event topic not allowed by the broker ACL. Once the publishing to a topic is rejected the configured number
+// of consecutive times, the topic is blocked and no further publishing to it is attempted until restart,
+// a configuration problem message is published and the blocked topics are set as property of the status feature.
+type TopicGuard struct {
+	DeviceID string
+	// MaxRejections is the number of the consecutive rejections of a topic, on reaching which it is blocked.
+	MaxRejections int
+
+	Logger logger.Logger
+
+	mutex      sync.Mutex
+	rejections map[string]int
+	blocked    map[string]bool
+}
+
+// Wrap returns the publisher guarding the publishing of the provided one.
+func (g *TopicGuard) Wrap(publisher message.Publisher) message.Publisher {
+	return &guardedPublisher{guard: g, publisher: publisher}
+}
+
+// BlockedTopics returns the sorted blocked topics.
+func (g *TopicGuard) BlockedTopics() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.blockedTopics()
+}
+
+func (g *TopicGuard) blockedTopics() []string {
+	topics := make([]string, 0, len(g.blocked))
+	for topic := range g.blocked {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// published records the publishing result of the topic and returns the blocked topics if the topic gets blocked.
+func (g *TopicGuard) published(topic string, err error) []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if err == nil || !rejected(err) {
+		delete(g.rejections, topic)
+		return nil
+	}
+
+	if g.rejections == nil {
+		g.rejections = make(map[string]int)
+	}
+	g.rejections[topic]++
+	if g.rejections[topic] < g.MaxRejections {
+		return nil
+	}
+
+	delete(g.rejections, topic)
+	if g.blocked == nil {
+		g.blocked = make(map[string]bool)
+	}
+	g.blocked[topic] = true
+	return g.blockedTopics()
+}
+
+func (g *TopicGuard) isBlocked(topic string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.blocked[topic]
+}
+
+// reportBlocked publishes the configuration problem message and the blocked topics property of the status feature.
+func (g *TopicGuard) reportBlocked(publisher message.Publisher, topic string, err error, blocked []string) {
+	g.Logger.Error("Local broker topic is blocked as persistently rejected", err, watermill.LogFields{
+		"topic":         topic,
+		"rejections":    g.MaxRejections,
+		"blockedTopics": blocked,
+	})
+
+	thingID := model.NewNamespacedIDFrom(g.DeviceID)
+	problem := &ConfigurationProblem{Topic: topic, Error: err.Error()}
+	msg := things.NewMessage(thingID).Feature(FeatureID).Outbox(SubjectConfigurationProblem).WithPayload(problem)
+	headers := protocol.NewHeaders().WithContentType(protocol.ContentTypeJSON)
+	if err := publishLocal(publisher, msg.Envelope(headers)); err != nil {
+		g.Logger.Error("Failed to publish the configuration problem", err, nil)
+	}
+
+	cmd := things.NewCommand(thingID).Twin().Features().
+		Merge(map[string]interface{}{
+			FeatureID: map[string]interface{}{
+				"properties": map[string]interface{}{PropertyBlockedTopics: blocked},
+			},
+		})
+	headers = protocol.NewHeaders().
+		WithContentType(protocol.ContentTypeJSONMerge).
+		WithResponseRequired(false)
+	if err := publishLocal(publisher, cmd.Envelope(headers)); err != nil {
+		g.Logger.Error("Failed to publish the blocked topics", err, nil)
+	}
+}
+
+// rejected returns true if the publishing error is an authorization rejection of the broker.
+func rejected(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not authorized") || strings.Contains(msg, "not authorised")
+}
+
+type guardedPublisher struct {
+	guard     *TopicGuard
+	publisher message.Publisher
+}
+
+func (p *guardedPublisher) Publish(topic string, msgs ...*message.Message) error {
+	if p.guard.isBlocked(topic) {
+		return errors.Wrapf(ErrTopicBlocked, "cannot publish to topic '%s'", topic)
+	}
+
+	err := p.publisher.Publish(topic, msgs...)
+	if blocked := p.guard.published(topic, err); blocked != nil {
+		p.guard.reportBlocked(p.publisher, topic, err, blocked)
+	}
+	return err
+}
+
+func (p *guardedPublisher) Close() error {
+	return p.publisher.Close()
+}
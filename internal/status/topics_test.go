@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package status_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const deniedTopic = "command///res/test/200"
+
+// rejectingPublisher rejects the publishing to the denied topic as not authorized.
+type rejectingPublisher struct {
+	testPublisher
+	attempts int
+}
+
+func (p *rejectingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	if topic == deniedTopic {
+		p.attempts++
+		return errors.New("publish failed: not authorized")
+	}
+	return p.testPublisher.Publish(topic, msgs...)
+}
+
+func TestTopicGuard(t *testing.T) {
+	pub := &rejectingPublisher{}
+	guard := &status.TopicGuard{
+		DeviceID:      testDeviceID,
+		MaxRejections: 2,
+		Logger:        testutil.NewLogger("status", logger.DEBUG, t),
+	}
+	guarded := guard.Wrap(pub)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	assert.Error(t, guarded.Publish(deniedTopic, msg))
+	assert.Empty(t, guard.BlockedTopics())
+	assert.Empty(t, pub.published())
+
+	assert.Error(t, guarded.Publish(deniedTopic, msg))
+	assert.Equal(t, []string{deniedTopic}, guard.BlockedTopics())
+
+	err := guarded.Publish(deniedTopic, msg)
+	assert.True(t, errors.Is(err, status.ErrTopicBlocked), err)
+	assert.Equal(t, 2, pub.attempts)
+
+	msgs := pub.published()
+	require.Equal(t, 2, len(msgs))
+	assert.Equal(t, "/features/status/outbox/messages/configurationProblem", msgs[0].Path)
+	problem := status.ConfigurationProblem{}
+	require.NoError(t, json.Unmarshal(msgs[0].Value, &problem))
+	assert.Equal(t, deniedTopic, problem.Topic)
+	assert.Contains(t, problem.Error, "not authorized")
+
+	value := map[string]map[string]map[string][]string{}
+	require.NoError(t, json.Unmarshal(msgs[1].Value, &value))
+	assert.Equal(t, []string{deniedTopic}, value["status"]["properties"][status.PropertyBlockedTopics])
+
+	assert.NoError(t, guarded.Publish("e", msg))
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// SubjectSyncStatus is the subject of the device thing outbox message reporting
+// the progress of a budgeted synchronization.
+const SubjectSyncStatus = "syncStatus"
+
+var (
+	// ErrBudgetExhausted indicates that the outbound messages budget of the synchronization session is exhausted
+	// and the rest of the synchronization is deferred to the next session.
+	ErrBudgetExhausted = errors.New("synchronization budget exhausted")
+)
+
+// SyncStatus is the payload of the synchronization status message, published on each synchronized
+// or deferred thing while the synchronization session is budgeted.
+type SyncStatus struct {
+	ThingID      string   `json:"thingId"`
+	Synchronized bool     `json:"synchronized"`
+	Budget       int64    `json:"budget"`
+	Spent        int64    `json:"spent"`
+	Deferred     []string `json:"deferred,omitempty"`
+}
+
+// budget tracks the outbound bytes of a synchronization session.
+// Once a message does not fit, the budget stays exhausted until the session is reset,
+// so that a lower priority message does not overtake a deferred one.
+type budget struct {
+	limit     int64
+	spent     int64
+	exhausted bool
+	deferred  []string
+}
+
+func (b *budget) reset(limit int64) {
+	b.limit = limit
+	b.spent = 0
+	b.exhausted = false
+	b.deferred = nil
+}
+
+func (b *budget) reserve(size int) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	if b.exhausted || b.spent+int64(size) > b.limit {
+		b.exhausted = true
+		return false
+	}
+	b.spent += int64(size)
+	return true
+}
+
+func (b *budget) deferThing(thingID string) {
+	for _, id := range b.deferred {
+		if id == thingID {
+			return
+		}
+	}
+	b.deferred = append(b.deferred, thingID)
+}
+
+func (b *budget) thingSynchronized(thingID string) {
+	for i, id := range b.deferred {
+		if id == thingID {
+			b.deferred = append(b.deferred[:i], b.deferred[i+1:]...)
+			return
+		}
+	}
+}
+
+// prioritizedThings orders the things with pending changes first, the oldest modified of them first,
+// so that they are the ones synchronized within the session budget.
+func (s *Synchronizer) prioritizedThings(thingIDs []string) []string {
+	type entry struct {
+		id       string
+		pending  bool
+		modified time.Time
+	}
+
+	entries := make([]entry, len(thingIDs))
+	for i, thingID := range thingIDs {
+		entries[i].id = thingID
+		if sysData, err := s.Storage.GetSystemThingData(thingID); err == nil {
+			entries[i].pending = len(sysData.UnsynchronizedFeatures) > 0 || len(sysData.DeletedFeatures) > 0
+			entries[i].modified, _ = time.Parse(time.RFC3339, sysData.Timestamp)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].pending != entries[j].pending {
+			return entries[i].pending
+		}
+		return entries[i].modified.Before(entries[j].modified)
+	})
+
+	ordered := make([]string, len(entries))
+	for i, e := range entries {
+		ordered[i] = e.id
+	}
+	return ordered
+}
+
+// prioritizedFeatures orders the unsynchronized features by their size, the smallest first,
+// so that most of them fit in the session budget. The features modification time is not tracked,
+// the age is taken into account only on the things level.
+func prioritizedFeatures(features map[string]*model.Feature) []string {
+	sizes := make(map[string]int, len(features))
+	ids := make([]string, 0, len(features))
+	for featureID, feature := range features {
+		if data, err := json.Marshal(feature); err == nil {
+			sizes[featureID] = len(data)
+		}
+		ids = append(ids, featureID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if sizes[ids[i]] != sizes[ids[j]] {
+			return sizes[ids[i]] < sizes[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (s *Synchronizer) deferSync(thingID string) {
+	s.Logger.Infof("Thing '%s' synchronization is deferred to the next session", thingID)
+	s.budget.deferThing(thingID)
+	s.publishSyncStatus(thingID, false)
+}
+
+func (s *Synchronizer) publishSyncStatus(thingID string, synchronized bool) {
+	if s.Budget <= 0 {
+		return
+	}
+
+	status := SyncStatus{
+		ThingID:      thingID,
+		Synchronized: synchronized,
+		Budget:       s.budget.limit,
+		Spent:        s.budget.spent,
+		Deferred:     s.budget.deferred,
+	}
+	env := things.NewMessage(model.NewNamespacedIDFrom(s.DeviceInfo.DeviceID)).
+		Outbox(SubjectSyncStatus).
+		WithPayload(status).
+		Envelope(protocol.NewHeaders().WithContentType(protocol.ContentTypeJSON))
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		s.Logger.Errorf("Unexpected synchronization status content: %v", err)
+		return
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), data)
+	if err := s.MosquittoPub.Publish(commands.EventPublishTopic(s.DeviceInfo.DeviceID, env.Topic), msg); err != nil {
+		s.Logger.Debugf("Error on publishing thing '%s' synchronization status: %v", thingID, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"container/list"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+func TestSyncBudget(t *testing.T) {
+	const (
+		budgetDB       = "things_test_budget.db"
+		thingID        = "things.budget:test"
+		smallerFeature = "smaller"
+		biggerFeature  = "bigger"
+	)
+
+	storage, err := persistence.NewThingsDB(budgetDB, thingID)
+	require.NoError(t, err)
+	defer func() {
+		storage.Close()
+		os.Remove(budgetDB)
+	}()
+
+	_, err = storage.AddThing(&model.Thing{ID: model.NewNamespacedIDFrom(thingID)})
+	require.NoError(t, err)
+	_, err = storage.AddFeature(thingID, smallerFeature, featureNoDesiredProperties())
+	require.NoError(t, err)
+	_, err = storage.AddFeature(thingID, biggerFeature,
+		(&model.Feature{}).WithProperty("data", strings.Repeat("x", 8192)))
+	require.NoError(t, err)
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	mosquittoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := &sync.Synchronizer{
+		DeviceInfo: commands.DeviceInfo{
+			DeviceID: "things.budget:device",
+			TenantID: "tenantID",
+		},
+		HonoPub:      honoPub,
+		MosquittoPub: mosquittoPub,
+		Storage:      storage,
+		Budget:       4096,
+		Logger:       testutil.NewLogger("sync", logger.TRACE, t),
+	}
+
+	// the smaller feature fits in the budget, the bigger one is deferred
+	require.NoError(t, synchronizer.Start())
+	assert.ErrorIs(t, synchronizer.SyncThings(thingID), sync.ErrBudgetExhausted)
+
+	_, err = honoPub.Pull(EnvelopeKey(thingID, "/features/"+smallerFeature))
+	assert.NoError(t, err)
+	_, err = honoPub.Pull(EnvelopeKey(thingID, "/features/"+biggerFeature))
+	assert.Error(t, err)
+
+	sysData, err := storage.GetSystemThingData(thingID)
+	require.NoError(t, err)
+	assert.Len(t, sysData.UnsynchronizedFeatures, 1)
+	assert.Contains(t, sysData.UnsynchronizedFeatures, biggerFeature)
+
+	status := pullSyncStatus(t, mosquittoPub, synchronizer.DeviceInfo.DeviceID)
+	assert.Equal(t, thingID, status.ThingID)
+	assert.False(t, status.Synchronized)
+	assert.Equal(t, int64(4096), status.Budget)
+	assert.True(t, status.Spent > 0 && status.Spent <= 4096)
+	assert.Equal(t, []string{thingID}, status.Deferred)
+
+	// the next session synchronizes the remainder
+	synchronizer.Budget = 16384
+	require.NoError(t, synchronizer.Start())
+	require.NoError(t, synchronizer.SyncThings(thingID))
+
+	_, err = honoPub.Pull(EnvelopeKey(thingID, "/features/"+biggerFeature))
+	assert.NoError(t, err)
+
+	sysData, err = storage.GetSystemThingData(thingID)
+	require.NoError(t, err)
+	assert.Empty(t, sysData.UnsynchronizedFeatures)
+
+	status = pullSyncStatus(t, mosquittoPub, synchronizer.DeviceInfo.DeviceID)
+	assert.True(t, status.Synchronized)
+	assert.Empty(t, status.Deferred)
+}
+
+func pullSyncStatus(t *testing.T, pub *testPublisher, deviceID string) sync.SyncStatus {
+	env, err := pub.Pull(EnvelopeKey(deviceID, "/outbox/messages/"+sync.SubjectSyncStatus))
+	require.NoError(t, err)
+
+	status := sync.SyncStatus{}
+	require.NoError(t, json.Unmarshal(env.Value, &status))
+	return status
+}
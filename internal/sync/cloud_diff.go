@@ -0,0 +1,264 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// defaultDiffTimeout is the time to wait for the cloud thing if no synchronization timeout is set.
+const defaultDiffTimeout = 10 * time.Second
+
+// cloudDiffFields are the thing fields compared with the cloud ones, i.e. the ones synchronized with the cloud.
+var cloudDiffFields = []string{"thingId", "policyId", "definition", "attributes", "features"}
+
+// ErrDiffTimeout is returned if the cloud thing is not retrieved in time.
+var ErrDiffTimeout = errors.New("cloud thing is not retrieved in time")
+
+// CloudDiff contains the differences of the local thing from the cloud one, as JSON pointers of the thing fields.
+type CloudDiff struct {
+	ThingID string `json:"thingId"`
+	// Synchronized is false if the local thing has changes not synchronized yet, i.e. it is expected to differ.
+	Synchronized bool `json:"synchronized"`
+	// LocalOnly contains the paths available in the local thing only.
+	LocalOnly []string `json:"localOnly"`
+	// CloudOnly contains the paths available in the cloud thing only.
+	CloudOnly []string `json:"cloudOnly"`
+	// Differing contains the paths with different local and cloud values.
+	Differing []PathDiff `json:"differing"`
+	// LocalHash and CloudHash are the SHA-256 hashes of the compared canonical local and cloud things,
+	// empty if the thing is not available, so that the states could be compared with the ones of other tools.
+	LocalHash string `json:"localHash,omitempty"`
+	CloudHash string `json:"cloudHash,omitempty"`
+}
+
+// PathDiff contains the different local and cloud values at a thing path.
+type PathDiff struct {
+	Path  string      `json:"path"`
+	Local interface{} `json:"local"`
+	Cloud interface{} `json:"cloud"`
+}
+
+// Equal returns true if there are no differences.
+func (d *CloudDiff) Equal() bool {
+	return len(d.LocalOnly) == 0 && len(d.CloudOnly) == 0 && len(d.Differing) == 0
+}
+
+// CloudDiff retrieves the cloud thing and compares it with the local one without modifying any of them,
+// e.g. to verify the synchronization of a device on demand.
+// Returns ErrNoConnection if there is no hub connection or persistence.ErrThingNotFound if the thing
+// is neither stored locally nor available in the cloud.
+func (s *Synchronizer) CloudDiff(thingID string) (*CloudDiff, error) {
+	if !s.connected {
+		return nil, ErrNoConnection
+	}
+
+	local, synchronized, err := s.localDiffThing(thingID)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultDiffTimeout
+	}
+	correlationID := watermill.NewUUID()
+	headers := protocol.NewHeaders().
+		WithReplyTo("command/" + s.DeviceInfo.TenantID).
+		WithTimeout(timeout).
+		WithCorrelationID(correlationID)
+	env := things.NewCommand(model.NewNamespacedIDFrom(thingID)).
+		Retrieve().
+		Envelope(headers).
+		WithFields(strings.Join(cloudDiffFields, ","))
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.awaitDiff(correlationID)
+	defer s.diffDone(correlationID)
+
+	msg := message.NewMessage(watermill.NewUUID(), data)
+	if err := commands.PublishHonoMsg(msg, s.HonoPub, s.DeviceInfo, thingID); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var cloudEnv *protocol.Envelope
+	select {
+	case cloudEnv = <-response:
+	case <-timer.C:
+		return nil, ErrDiffTimeout
+	}
+
+	var cloud map[string]interface{}
+	switch {
+	case cloudEnv.Status == http.StatusNotFound:
+		if local == nil {
+			return nil, persistence.ErrThingNotFound
+		}
+	case cloudEnv.Status >= http.StatusBadRequest:
+		thingErr := commands.ThingError{}
+		if err := json.Unmarshal(cloudEnv.Value, &thingErr); err == nil && len(thingErr.Message) > 0 {
+			return nil, errors.Errorf("cloud thing retrieve failed with status %d: %s", cloudEnv.Status, thingErr.Message)
+		}
+		return nil, errors.Errorf("cloud thing retrieve failed with status %d", cloudEnv.Status)
+	default:
+		if err := json.Unmarshal(cloudEnv.Value, &cloud); err != nil {
+			return nil, errors.Wrap(err, "unexpected cloud thing")
+		}
+		cloud = diffFields(cloud)
+	}
+
+	diff := &CloudDiff{
+		ThingID:      thingID,
+		Synchronized: synchronized,
+		LocalOnly:    make([]string, 0),
+		CloudOnly:    make([]string, 0),
+		Differing:    make([]PathDiff, 0),
+	}
+	if local != nil {
+		if diff.LocalHash, err = jsonutil.Hash(local, jsonutil.HashSHA256); err != nil {
+			return nil, err
+		}
+	}
+	if cloud != nil {
+		if diff.CloudHash, err = jsonutil.Hash(cloud, jsonutil.HashSHA256); err != nil {
+			return nil, err
+		}
+	}
+	diff.compare("", local, cloud)
+	sort.Strings(diff.LocalOnly)
+	sort.Strings(diff.CloudOnly)
+	sort.Slice(diff.Differing, func(i, j int) bool {
+		return diff.Differing[i].Path < diff.Differing[j].Path
+	})
+	return diff, nil
+}
+
+// localDiffThing returns the compared fields of the local thing, nil if not stored,
+// and false if it has changes not synchronized yet.
+func (s *Synchronizer) localDiffThing(thingID string) (map[string]interface{}, bool, error) {
+	thing := model.Thing{}
+	if err := s.Storage.GetThing(thingID, &thing); err != nil {
+		if errors.Is(err, persistence.ErrThingNotFound) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+
+	synchronized := true
+	if data, err := s.Storage.GetSystemThingData(thingID); err == nil {
+		synchronized = len(data.UnsynchronizedFeatures) == 0 && len(data.DeletedFeatures) == 0
+	}
+
+	data, err := json.Marshal(&thing)
+	if err != nil {
+		return nil, false, err
+	}
+	local := make(map[string]interface{})
+	if err := json.Unmarshal(data, &local); err != nil {
+		return nil, false, err
+	}
+	return diffFields(local), synchronized, nil
+}
+
+func diffFields(thing map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(cloudDiffFields))
+	for _, field := range cloudDiffFields {
+		if value, ok := thing[field]; ok {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// compare records the differences of the local and the cloud objects at the provided path.
+func (d *CloudDiff) compare(path string, local, cloud map[string]interface{}) {
+	for key, localValue := range local {
+		keyPath := path + "/" + key
+		cloudValue, ok := cloud[key]
+		if !ok {
+			d.LocalOnly = append(d.LocalOnly, keyPath)
+			continue
+		}
+
+		localObject, localIsObject := localValue.(map[string]interface{})
+		cloudObject, cloudIsObject := cloudValue.(map[string]interface{})
+		if localIsObject && cloudIsObject {
+			d.compare(keyPath, localObject, cloudObject)
+			continue
+		}
+		if !reflect.DeepEqual(localValue, cloudValue) {
+			d.Differing = append(d.Differing, PathDiff{Path: keyPath, Local: localValue, Cloud: cloudValue})
+		}
+	}
+	for key := range cloud {
+		if _, ok := local[key]; !ok {
+			d.CloudOnly = append(d.CloudOnly, path+"/"+key)
+		}
+	}
+}
+
+func (s *Synchronizer) awaitDiff(correlationID string) <-chan *protocol.Envelope {
+	s.diffsMutex.Lock()
+	defer s.diffsMutex.Unlock()
+
+	if s.diffs == nil {
+		s.diffs = make(map[string]chan *protocol.Envelope)
+	}
+	response := make(chan *protocol.Envelope, 1)
+	s.diffs[correlationID] = response
+	return response
+}
+
+func (s *Synchronizer) diffDone(correlationID string) {
+	s.diffsMutex.Lock()
+	defer s.diffsMutex.Unlock()
+
+	delete(s.diffs, correlationID)
+}
+
+// diffResponse passes the cloud response to the awaiting cloud diff, returns false if there is no such.
+func (s *Synchronizer) diffResponse(env *protocol.Envelope) bool {
+	s.diffsMutex.Lock()
+	defer s.diffsMutex.Unlock()
+
+	response, ok := s.diffs[env.Headers.CorrelationID()]
+	if !ok {
+		return false
+	}
+	delete(s.diffs, env.Headers.CorrelationID())
+	response <- env
+	return true
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+// cloudResponder replies to the published retrieve commands with the provided cloud thing via the synchronizer.
+type cloudResponder struct {
+	sync   *sync.Synchronizer
+	status int
+	value  interface{}
+}
+
+func (r *cloudResponder) Publish(topic string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		command := protocol.Envelope{}
+		if err := json.Unmarshal(msg.Payload, &command); err != nil {
+			return err
+		}
+		response := protocol.Envelope{Topic: command.Topic, Path: command.Path}
+		response.WithHeaders(protocol.NewHeaders().WithCorrelationID(command.Headers.CorrelationID())).
+			WithStatus(r.status).
+			WithValue(r.value)
+		payload, err := json.Marshal(&response)
+		if err != nil {
+			return err
+		}
+		go r.sync.HandleResponse(message.NewMessage(watermill.NewUUID(), payload))
+	}
+	return nil
+}
+
+func (r *cloudResponder) Close() error {
+	return nil
+}
+
+func TestCloudDiff(t *testing.T) {
+	const thingID = "cloud.diff:thing"
+	location := "things_test_cloud_diff.db"
+	db, err := persistence.NewThingsDB(location, thingID)
+	require.NoError(t, err)
+	defer os.Remove(location)
+	defer db.Close()
+
+	thing := (&model.Thing{}).WithIDFrom(thingID).
+		WithAttributes(map[string]interface{}{"location": "hall", "floor": 1}).
+		WithFeature("meter", (&model.Feature{}).
+			WithProperties(map[string]interface{}{"x": 1, "y": 2}))
+	_, err = db.AddThing(thing)
+	require.NoError(t, err)
+
+	synchronizer := &sync.Synchronizer{
+		DeviceInfo: commands.DeviceInfo{DeviceID: thingID, TenantID: "cloud:diff:tenant"},
+		Storage:    db,
+		Timeout:    time.Second,
+		Logger:     testutil.NewLogger("sync", logger.TRACE, t),
+	}
+	responder := &cloudResponder{sync: synchronizer, status: http.StatusOK}
+	synchronizer.HonoPub = responder
+
+	_, err = synchronizer.CloudDiff(thingID)
+	assert.True(t, errors.Is(err, sync.ErrNoConnection), err)
+	synchronizer.Connected(true)
+
+	responder.value = map[string]interface{}{
+		"thingId":    thingID,
+		"attributes": map[string]interface{}{"location": "hall", "owner": "test"},
+		"features": map[string]interface{}{
+			"meter": map[string]interface{}{
+				"properties": map[string]interface{}{"x": 1, "y": 3},
+			},
+		},
+		"_revision": 5,
+	}
+	diff, err := synchronizer.CloudDiff(thingID)
+	require.NoError(t, err)
+	assert.Equal(t, thingID, diff.ThingID)
+	assert.False(t, diff.Synchronized)
+	assert.False(t, diff.Equal())
+	assert.Equal(t, []string{"/attributes/floor"}, diff.LocalOnly)
+	assert.Equal(t, []string{"/attributes/owner"}, diff.CloudOnly)
+	require.Len(t, diff.Differing, 1)
+	assert.Equal(t, "/features/meter/properties/y", diff.Differing[0].Path)
+	assert.EqualValues(t, 2, diff.Differing[0].Local)
+	assert.EqualValues(t, 3, diff.Differing[0].Cloud)
+	assert.NotEmpty(t, diff.LocalHash)
+	assert.NotEqual(t, diff.LocalHash, diff.CloudHash)
+
+	// nothing is modified
+	stored := model.Thing{}
+	require.NoError(t, db.GetThing(thingID, &stored))
+	assert.EqualValues(t, 2, stored.Features["meter"].Properties["y"])
+
+	responder.status = http.StatusNotFound
+	responder.value = nil
+	diff, err = synchronizer.CloudDiff(thingID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/thingId", "/attributes", "/features"}, diff.LocalOnly)
+	assert.Empty(t, diff.CloudOnly)
+	assert.Empty(t, diff.CloudHash)
+
+	_, err = synchronizer.CloudDiff("cloud.diff:missing")
+	assert.True(t, errors.Is(err, persistence.ErrThingNotFound), err)
+
+	responder.status = http.StatusOK
+	responder.value = map[string]interface{}{"thingId": "cloud.diff:other"}
+	diff, err = synchronizer.CloudDiff("cloud.diff:other")
+	require.NoError(t, err)
+	assert.True(t, diff.Synchronized)
+	assert.Equal(t, []string{"/thingId"}, diff.CloudOnly)
+}
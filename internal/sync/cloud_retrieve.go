@@ -17,10 +17,12 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
 	"github.com/eclipse-kanto/suite-connector/logger"
@@ -30,6 +32,12 @@ import (
 	"github.com/pkg/errors"
 )
 
+// cloudResponse holds the expected thing and the deadline of a retrieve desired properties response.
+type cloudResponse struct {
+	thingID  string
+	deadline time.Time
+}
+
 // RetrieveDesiredPropertiesCommand returns a command, which can be used to retrieve the provided thing's desired
 // properties from the cloud.
 func (s *Synchronizer) RetrieveDesiredPropertiesCommand(thing *model.Thing) *protocol.Envelope {
@@ -49,28 +57,55 @@ func (s *Synchronizer) RetrieveDesiredPropertiesCommand(thing *model.Thing) *pro
 		i++
 	}
 	fieldsBuilder.WriteString(")")
+	if s.Resolver != nil {
+		// the last modification of the hub thing is used on the conflicts resolution
+		fieldsBuilder.WriteString(",_modified")
+	}
+
+	headers := protocol.NewHeaders().WithReplyTo("command/" + s.DeviceInfo.TenantID)
+	if s.Timeout > 0 {
+		headers.WithTimeout(s.Timeout)
+	}
+	headers.WithCorrelationID(s.newCorrelationID(thing.ID.String(), headers.Timeout()))
 
 	return things.NewCommand(thing.ID).
 		Retrieve().
-		Envelope(protocol.NewHeaders().
-			WithCorrelationID(s.newCorrelationID(thing.ID.String())).
-			WithReplyTo("command/" + s.DeviceInfo.TenantID)).
+		Envelope(headers).
 		WithFields(fieldsBuilder.String())
 }
 
 // HandleResponse checks and manages retrieve desired properties commands' responses.
+// The local twins of the things deleted in the hub are removed on the hub deletion events.
 func (s *Synchronizer) HandleResponse(msg *message.Message) ([]*message.Message, error) {
 	env := protocol.Envelope{}
 	if err := json.Unmarshal(msg.Payload, &env); err != nil {
 		s.Logger.Debugf("Unexpected cloud to device command payload: %v", err)
 		return []*message.Message{msg}, nil
 	}
+	if s.diffResponse(&env) {
+		return nil, nil
+	}
+	s.cloudThingDeleted(&env)
 
 	thingID := model.NewNamespacedID(env.Topic.Namespace, env.Topic.EntityID).String()
 	correlationID := env.Headers.CorrelationID()
 
-	if expectedThingID, ok := s.cloudResponsesIDs[correlationID]; ok {
-		if expectedThingID != thingID {
+	expected, ok := s.cloudResponsesIDs[correlationID]
+	if !ok {
+		expected, ok = s.restoredResponse(correlationID)
+	}
+	if ok {
+		if time.Now().After(expected.deadline) {
+			s.responseDone(correlationID)
+			s.Logger.Warnf(
+				"Desired properties response of thing '%s' with correlation-id '%s' received after the timeout, discarded",
+				thingID,
+				correlationID,
+			)
+			return nil, nil
+		}
+
+		if expected.thingID != thingID {
 			s.Logger.Errorf(
 				"Correlation-id '%s' and thing '%s' pair mismatch on desired properties response",
 				correlationID,
@@ -79,10 +114,11 @@ func (s *Synchronizer) HandleResponse(msg *message.Message) ([]*message.Message,
 		} else {
 			responseValue, err := s.RetrievedProperties(env)
 			if responseValue != nil {
-				if err = s.UpdateLocalDesiredProperties(thingID, responseValue); err == nil {
-					delete(s.cloudResponsesIDs, correlationID)
+				err = s.updateLocalDesiredProperties(thingID, responseValue, retrievedModified(env))
+				if err == nil {
+					s.responseDone(correlationID)
 
-					s.cloudResponseHandled(thingID)
+					s.sessionResponseHandled(correlationID, thingID, s.cloudResponseHandled(thingID))
 				}
 			}
 			return nil, err
@@ -91,16 +127,38 @@ func (s *Synchronizer) HandleResponse(msg *message.Message) ([]*message.Message,
 	return []*message.Message{msg}, nil
 }
 
-func (s *Synchronizer) cloudResponseHandled(thingID string) {
+// cloudResponseHandled synchronizes the thing with retrieved desired properties.
+// Returns true if the thing is synchronized.
+func (s *Synchronizer) cloudResponseHandled(thingID string) bool {
+	if !s.connected {
+		// a response to a retrieve issued before the restart or reconnect, synchronized on the next start
+		if s.retrieved == nil {
+			s.retrieved = make(map[string]bool)
+		}
+		s.retrieved[thingID] = true
+		return false
+	}
 	if err := s.SyncThings(thingID); err != nil {
 		s.Logger.Debugf("Error on synchronizing thing %s: %v ", thingID, err)
+		return false
 	}
+	return true
 }
 
 // UpdateLocalDesiredProperties overwrites the locally persisted desired properties with the provided response value.
+// The conflicts with the desired properties changed locally since the last synchronization are resolved
+// by the synchronizer resolver, if set.
 func (s *Synchronizer) UpdateLocalDesiredProperties(
 	thingID string,
 	cloudFeatures map[string]model.Feature,
+) error {
+	return s.updateLocalDesiredProperties(thingID, cloudFeatures, time.Time{})
+}
+
+func (s *Synchronizer) updateLocalDesiredProperties(
+	thingID string,
+	cloudFeatures map[string]model.Feature,
+	cloudModified time.Time,
 ) error {
 	localThing := &model.Thing{}
 	if err := s.Storage.GetThing(thingID, localThing); err != nil {
@@ -116,6 +174,7 @@ func (s *Synchronizer) UpdateLocalDesiredProperties(
 	}
 
 	featureNotSynchronizedBeforeUpdate := true
+	changed := false
 	for featureID, localFeature := range localThing.Features {
 		if err = s.Storage.GetFeature(thingID, featureID, localFeature); err != nil {
 			if errors.Is(err, persistence.ErrThingNotFound) {
@@ -123,6 +182,7 @@ func (s *Synchronizer) UpdateLocalDesiredProperties(
 			}
 		}
 
+		localDesired := localFeature.DesiredProperties
 		if !desiredPropertiesChangedOnSync(featureID, cloudFeatures, localFeature) {
 			continue
 		}
@@ -130,10 +190,19 @@ func (s *Synchronizer) UpdateLocalDesiredProperties(
 		if data != nil {
 			_, featureNotSynchronizedBeforeUpdate = data.UnsynchronizedFeatures[featureID]
 		}
+		if s.desiredConflict(data, featureID) &&
+			s.resolveConflict(thingID, featureID, localDesired, localFeature.DesiredProperties,
+				cloudModified) == ResolutionLocal {
+			if err = s.publishLocalDesiredProperties(thingID, featureID, localDesired); err != nil {
+				s.Logger.Debug("Unable to publish the local desired properties", logFeatureError(thingID, featureID, err))
+			}
+			continue
+		}
 		if _, err = s.Storage.AddFeature(thingID, featureID, localFeature); err != nil {
 			s.Logger.Debug("Error on updating feature desired properties", logFeatureError(thingID, featureID, err))
 			continue
 		}
+		changed = true
 
 		if err = s.publishDesiredPropertiesModified(thingID, featureID, localFeature); err != nil {
 			s.Logger.Debug(
@@ -147,6 +216,9 @@ func (s *Synchronizer) UpdateLocalDesiredProperties(
 			}
 		}
 	}
+	if changed && s.Changes != nil {
+		s.Changes.ThingChanged(thingID)
+	}
 	return nil
 }
 
@@ -169,18 +241,32 @@ func desiredPropertiesChangedOnSync(
 	return true
 }
 
+// retrievedModified returns the last modification of the hub thing, if retrieved, zero otherwise.
+func retrievedModified(env protocol.Envelope) time.Time {
+	value := struct {
+		Modified string `json:"_modified"`
+	}{}
+	if err := json.Unmarshal(env.Value, &value); err != nil || len(value.Modified) == 0 {
+		return time.Time{}
+	}
+	modified, _ := time.Parse(time.RFC3339, value.Modified)
+	return modified
+}
+
 // RetrievedProperties extracts features' desired properties from an envelope.
 // Returns error if the content is with unexpected topic, path, status or value.
 func (s *Synchronizer) RetrievedProperties(env protocol.Envelope) (map[string]model.Feature, error) {
 	if !responseValid(env, s.Logger) {
 		return nil, nil
 	}
-	responseValue := make(map[string]map[string]model.Feature)
+	responseValue := struct {
+		Features map[string]model.Feature `json:"features"`
+	}{}
 	if err := json.Unmarshal(env.Value, &responseValue); err != nil {
 		return nil, err
 	}
 
-	data := responseValue["features"]
+	data := responseValue.Features
 	if data == nil {
 		return make(map[string]model.Feature), nil
 	}
@@ -231,15 +317,119 @@ func responseValid(env protocol.Envelope, logger logger.Logger) bool {
 	return true
 }
 
-func (s *Synchronizer) newCorrelationID(thingID string) string {
+func (s *Synchronizer) newCorrelationID(thingID string, timeout time.Duration) string {
 	if s.cloudResponsesIDs == nil {
-		s.cloudResponsesIDs = make(map[string]string)
+		s.cloudResponsesIDs = make(map[string]cloudResponse)
 	}
 	correlationID := watermill.NewUUID()
-	s.cloudResponsesIDs[correlationID] = thingID
+	s.cloudResponsesIDs[correlationID] = cloudResponse{thingID: thingID, deadline: time.Now().Add(timeout)}
+
+	if s.RetrievesValidity > 0 {
+		s.loadRetrieves()
+		s.retrieves[correlationID] = data.RetrieveData{
+			ThingID: thingID,
+			Issued:  time.Now().Format(time.RFC3339Nano),
+		}
+		s.storeRetrieves()
+	}
 	return correlationID
 }
 
+// restoredResponse returns the expected response of a retrieve command issued before a restart or a hub reconnect,
+// if persisted and still valid.
+func (s *Synchronizer) restoredResponse(correlationID string) (cloudResponse, bool) {
+	if s.RetrievesValidity <= 0 {
+		return cloudResponse{}, false
+	}
+	s.loadRetrieves()
+	retrieve, ok := s.retrieves[correlationID]
+	if !ok {
+		return cloudResponse{}, false
+	}
+	issued, err := time.Parse(time.RFC3339Nano, retrieve.Issued)
+	if err != nil {
+		return cloudResponse{}, false
+	}
+	return cloudResponse{thingID: retrieve.ThingID, deadline: issued.Add(s.RetrievesValidity)}, true
+}
+
+// restoredRetrieves filters out the things still awaiting a response of a retrieve command issued before a restart
+// or a hub reconnect and synchronizes the things with such responses already received.
+func (s *Synchronizer) restoredRetrieves(thingIDs []string) []string {
+	if s.RetrievesValidity <= 0 {
+		return thingIDs
+	}
+	s.loadRetrieves()
+
+	skipped := make(map[string]bool)
+	now := time.Now()
+	for correlationID := range s.retrieves {
+		if expected, ok := s.restoredResponse(correlationID); ok && now.Before(expected.deadline) {
+			skipped[expected.thingID] = true
+		}
+	}
+
+	var retrieved []string
+	for thingID := range s.retrieved {
+		skipped[thingID] = true
+		retrieved = append(retrieved, thingID)
+	}
+	s.retrieved = nil
+	if err := s.SyncThings(retrieved...); err != nil {
+		s.Logger.Debugf("Error on synchronizing things with restored retrieve responses: %v", err)
+	}
+
+	var remaining []string
+	for _, thingID := range thingIDs {
+		if skipped[thingID] {
+			s.Logger.Debugf("Retrieve desired properties of thing '%s' restored, not issued again", thingID)
+			continue
+		}
+		remaining = append(remaining, thingID)
+	}
+	return remaining
+}
+
+// responseDone removes the expected response, including its persisted retrieve command, if any.
+func (s *Synchronizer) responseDone(correlationID string) {
+	delete(s.cloudResponsesIDs, correlationID)
+	if _, ok := s.retrieves[correlationID]; ok {
+		delete(s.retrieves, correlationID)
+		s.storeRetrieves()
+	}
+}
+
+func (s *Synchronizer) loadRetrieves() {
+	if s.retrieves != nil {
+		return
+	}
+	s.retrieves = make(map[string]data.RetrieveData)
+
+	persisted := &data.RetrievesData{}
+	if err := s.Storage.GetRetrieves(persisted); err != nil {
+		if !errors.Is(err, persistence.ErrNotFound) {
+			s.Logger.Errorf("Error on loading the persisted retrieve commands: %v", err)
+		}
+		return
+	}
+	for correlationID, retrieve := range persisted.Retrieves {
+		s.retrieves[correlationID] = retrieve
+	}
+}
+
+// storeRetrieves persists the issued retrieve commands, dropping the ones no longer valid.
+func (s *Synchronizer) storeRetrieves() {
+	now := time.Now()
+	for correlationID := range s.retrieves {
+		if expected, ok := s.restoredResponse(correlationID); !ok || now.After(expected.deadline) {
+			delete(s.retrieves, correlationID)
+		}
+	}
+	if err := s.Storage.SetRetrieves(&data.RetrievesData{Retrieves: s.retrieves}); err != nil {
+		s.Logger.Errorf("Error on persisting the retrieve commands: %v", err)
+	}
+}
+
 func (s *Synchronizer) publishDesiredPropertiesModified(
 	thingID, featureID string, localFeature *model.Feature,
 ) error {
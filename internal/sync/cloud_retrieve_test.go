@@ -14,11 +14,13 @@ package sync_test
 
 import (
 	"container/list"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/pkg/errors"
@@ -30,6 +32,7 @@ import (
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
 	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
@@ -257,6 +260,128 @@ func (s *CloudRetrieveSuite) TestHandleResponseUnknownCorrelationID() {
 	assertNoThingUpdate(s, initialThing)
 }
 
+func (s *CloudRetrieveSuite) TestHandleResponseAfterTimeout() {
+	initialThing := &model.Thing{}
+	require.NoError(s.T(), s.sync.Storage.GetThing(testThingID, initialThing))
+
+	s.sync.Timeout = time.Millisecond
+	defer func() {
+		s.sync.Timeout = 0
+	}()
+
+	cmd := s.sync.RetrieveDesiredPropertiesCommand(initialThing)
+	require.NotNil(s.T(), cmd)
+	assert.Equal(s.T(), time.Millisecond, cmd.Headers.Timeout())
+	time.Sleep(5 * time.Millisecond)
+
+	response := responseEnv
+	response.Headers = protocol.NewHeaders().WithCorrelationID(cmd.Headers.CorrelationID())
+	response.Value = json.RawMessage(`{"features":{"heater":{"desiredProperties":{"air-temperature":30}}}}`)
+	payload, err := json.Marshal(response)
+	require.NoError(s.T(), err)
+
+	msgs, err := s.sync.HandleResponse(message.NewMessage("late", payload))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), msgs)
+	assertNoThingUpdate(s, initialThing)
+
+	// the correlation-id is no longer expected
+	msgs, err = s.sync.HandleResponse(message.NewMessage("late", payload))
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), msgs)
+}
+
+func (s *CloudRetrieveSuite) TestHandleResponseAfterRestart() {
+	initialThing := &model.Thing{}
+	require.NoError(s.T(), s.sync.Storage.GetThing(testThingID, initialThing))
+
+	s.sync.RetrievesValidity = time.Minute
+	defer func() {
+		s.sync.RetrievesValidity = 0
+	}()
+	cmd := s.sync.RetrieveDesiredPropertiesCommand(initialThing)
+	require.NotNil(s.T(), cmd)
+
+	retrieves := &data.RetrievesData{}
+	require.NoError(s.T(), s.sync.Storage.GetRetrieves(retrieves))
+	require.Contains(s.T(), retrieves.Retrieves, cmd.Headers.CorrelationID())
+	assert.Equal(s.T(), testThingID, retrieves.Retrieves[cmd.Headers.CorrelationID()].ThingID)
+
+	// the response is not expected after a restart without retrieves validity
+	restarted := &sync.Synchronizer{
+		MosquittoPub: s.sync.MosquittoPub,
+		DeviceInfo:   s.sync.DeviceInfo,
+		Storage:      s.sync.Storage,
+		Logger:       s.sync.Logger,
+	}
+
+	features, err := json.Marshal(map[string]interface{}{"features": initialThing.Features})
+	require.NoError(s.T(), err)
+	response := responseEnv
+	response.Headers = protocol.NewHeaders().WithCorrelationID(cmd.Headers.CorrelationID())
+	response.Value = features
+	payload, err := json.Marshal(response)
+	require.NoError(s.T(), err)
+
+	msgs, err := restarted.HandleResponse(message.NewMessage("restarted", payload))
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), msgs)
+
+	// the response is applied after a restart within the retrieves validity
+	restarted.RetrievesValidity = time.Minute
+	msgs, err = restarted.HandleResponse(message.NewMessage("restarted", payload))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), msgs)
+
+	thing := &model.Thing{}
+	require.NoError(s.T(), s.sync.Storage.GetThing(testThingID, thing))
+	assert.EqualValues(s.T(), 15, thing.Features["heater"].DesiredProperties["air-temperature"])
+
+	retrieves = &data.RetrievesData{}
+	require.NoError(s.T(), s.sync.Storage.GetRetrieves(retrieves))
+	assert.Empty(s.T(), retrieves.Retrieves)
+
+	msgs, err = restarted.HandleResponse(message.NewMessage("restarted", payload))
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), msgs)
+}
+
+func (s *CloudRetrieveSuite) TestHandleResponseAfterRestartExpired() {
+	initialThing := &model.Thing{}
+	require.NoError(s.T(), s.sync.Storage.GetThing(testThingID, initialThing))
+
+	s.sync.RetrievesValidity = time.Millisecond
+	defer func() {
+		s.sync.RetrievesValidity = 0
+	}()
+	cmd := s.sync.RetrieveDesiredPropertiesCommand(initialThing)
+	require.NotNil(s.T(), cmd)
+	time.Sleep(5 * time.Millisecond)
+
+	restarted := &sync.Synchronizer{
+		MosquittoPub:      s.sync.MosquittoPub,
+		DeviceInfo:        s.sync.DeviceInfo,
+		Storage:           s.sync.Storage,
+		Logger:            s.sync.Logger,
+		RetrievesValidity: time.Millisecond,
+	}
+
+	response := responseEnv
+	response.Headers = protocol.NewHeaders().WithCorrelationID(cmd.Headers.CorrelationID())
+	response.Value = json.RawMessage(`{"features":{"heater":{"desiredProperties":{"air-temperature":30}}}}`)
+	payload, err := json.Marshal(response)
+	require.NoError(s.T(), err)
+
+	msgs, err := restarted.HandleResponse(message.NewMessage("expired", payload))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), msgs)
+	assertNoThingUpdate(s, initialThing)
+
+	retrieves := &data.RetrievesData{}
+	require.NoError(s.T(), s.sync.Storage.GetRetrieves(retrieves))
+	assert.Empty(s.T(), retrieves.Retrieves)
+}
+
 func assertNoThingUpdate(s *CloudRetrieveSuite, initialThing *model.Thing) {
 	thing := &model.Thing{}
 	require.NoError(s.T(), s.sync.Storage.GetThing(testThingID, thing))
@@ -0,0 +1,163 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// Conflict resolution strategies of the desired properties changed both locally and in the hub.
+const (
+	// ConflictCloudWins overwrites the local desired properties with the hub ones, it is the default strategy.
+	ConflictCloudWins = "cloud-wins"
+	// ConflictLocalWins keeps the local desired properties and overwrites the hub ones with them.
+	ConflictLocalWins = "local-wins"
+	// ConflictLastWriterWins keeps the last modified desired properties, the hub ones if the modification
+	// timestamps are not comparable.
+	ConflictLastWriterWins = "last-writer-wins"
+)
+
+// Resolution is the resolution of a desired properties conflict.
+type Resolution int
+
+const (
+	// ResolutionCloud resolves the conflict with the hub desired properties.
+	ResolutionCloud Resolution = iota
+	// ResolutionLocal resolves the conflict with the local desired properties.
+	ResolutionLocal
+)
+
+// String returns the name of the resolution used on logging.
+func (r Resolution) String() string {
+	if r == ResolutionLocal {
+		return "local"
+	}
+	return "cloud"
+}
+
+// Conflict is a difference of a feature desired properties in the hub and in the local twin,
+// which desired properties are modified locally since their last synchronization, e.g. while offline.
+type Conflict struct {
+	ThingID   string
+	FeatureID string
+
+	// Local and Cloud are the local and the hub desired properties of the feature.
+	Local map[string]interface{}
+	Cloud map[string]interface{}
+
+	// LocalModified is the last local modification of the feature and CloudModified is the last modification
+	// of the hub thing, as the hub does not provide the features modification. Zero if unknown.
+	LocalModified time.Time
+	CloudModified time.Time
+}
+
+// ConflictResolver resolves the desired properties conflicts on the synchronization with the hub.
+type ConflictResolver interface {
+	Resolve(conflict *Conflict) Resolution
+}
+
+// ConflictResolverFunc is a custom function resolving the desired properties conflicts.
+type ConflictResolverFunc func(conflict *Conflict) Resolution
+
+// Resolve calls the function itself.
+func (f ConflictResolverFunc) Resolve(conflict *Conflict) Resolution {
+	return f(conflict)
+}
+
+// NewConflictResolver returns the resolver of the provided strategy, the cloud-wins one if empty.
+// Returns error if the strategy is unknown.
+func NewConflictResolver(strategy string) (ConflictResolver, error) {
+	switch strategy {
+	case ConflictCloudWins, "":
+		return ConflictResolverFunc(cloudWins), nil
+	case ConflictLocalWins:
+		return ConflictResolverFunc(localWins), nil
+	case ConflictLastWriterWins:
+		return ConflictResolverFunc(lastWriterWins), nil
+	default:
+		return nil, errors.Errorf("unknown conflict resolution strategy '%s'", strategy)
+	}
+}
+
+func cloudWins(*Conflict) Resolution {
+	return ResolutionCloud
+}
+
+func localWins(*Conflict) Resolution {
+	return ResolutionLocal
+}
+
+func lastWriterWins(conflict *Conflict) Resolution {
+	if conflict.LocalModified.IsZero() || conflict.CloudModified.IsZero() ||
+		!conflict.LocalModified.After(conflict.CloudModified) {
+		return ResolutionCloud
+	}
+	return ResolutionLocal
+}
+
+// desiredConflict returns true if the feature desired properties differing from the hub ones are to be resolved,
+// i.e. they are modified locally since the last synchronization.
+func (s *Synchronizer) desiredConflict(sysData *data.SystemThingData, featureID string) bool {
+	if s.Resolver == nil || sysData == nil {
+		return false
+	}
+	_, modified := sysData.UnsynchronizedDesired[featureID]
+	return modified
+}
+
+// resolveConflict resolves and logs the conflict of the local and the hub desired properties of the feature.
+func (s *Synchronizer) resolveConflict(
+	thingID, featureID string, local, cloud map[string]interface{}, cloudModified time.Time,
+) Resolution {
+	conflict := &Conflict{
+		ThingID:       thingID,
+		FeatureID:     featureID,
+		Local:         local,
+		Cloud:         cloud,
+		CloudModified: cloudModified,
+	}
+	if timestamps, err := s.Storage.GetFeatureTimestamps(thingID, featureID); err == nil {
+		conflict.LocalModified, _ = time.Parse(time.RFC3339, timestamps.Modified)
+	}
+
+	resolution := s.Resolver.Resolve(conflict)
+	s.Logger.Info("Desired properties conflict resolved", watermill.LogFields{
+		"thing":      thingID,
+		"feature":    featureID,
+		"resolution": resolution.String(),
+	})
+	return resolution
+}
+
+// publishLocalDesiredProperties overwrites the hub desired properties of the feature with the local ones.
+func (s *Synchronizer) publishLocalDesiredProperties(thingID, featureID string, desired map[string]interface{}) error {
+	headers := protocol.NewHeaders().
+		WithResponseRequired(false).
+		WithCorrelationID(watermill.NewUUID())
+
+	cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).FeatureDesiredProperties(featureID)
+	if len(desired) == 0 {
+		cmd.Delete()
+	} else {
+		cmd.Modify(desired)
+	}
+	return s.publishHonoMsg(cmd.Envelope(headers), thingID)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"container/list"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+)
+
+const conflictThingID = "things.conflict:test"
+
+func TestConflictResolvers(t *testing.T) {
+	now := time.Now()
+	conflict := &sync.Conflict{LocalModified: now, CloudModified: now.Add(-time.Minute)}
+
+	for strategy, expected := range map[string]sync.Resolution{
+		"":                          sync.ResolutionCloud,
+		sync.ConflictCloudWins:      sync.ResolutionCloud,
+		sync.ConflictLocalWins:      sync.ResolutionLocal,
+		sync.ConflictLastWriterWins: sync.ResolutionLocal,
+	} {
+		resolver, err := sync.NewConflictResolver(strategy)
+		require.NoError(t, err, strategy)
+		assert.Equal(t, expected, resolver.Resolve(conflict), strategy)
+	}
+
+	resolver, err := sync.NewConflictResolver(sync.ConflictLastWriterWins)
+	require.NoError(t, err)
+	assert.Equal(t, sync.ResolutionCloud, resolver.Resolve(&sync.Conflict{
+		LocalModified: now.Add(-time.Minute), CloudModified: now}))
+	assert.Equal(t, sync.ResolutionCloud, resolver.Resolve(&sync.Conflict{LocalModified: now}))
+
+	_, err = sync.NewConflictResolver("device-wins")
+	assert.Error(t, err)
+}
+
+func TestResolveDesiredPropertiesConflict(t *testing.T) {
+	storage, err := persistence.NewThingsDB(filepath.Join(t.TempDir(), "things.db"), sessionDeviceID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	revision, err := storage.AddThing((&model.Thing{}).WithIDFrom(conflictThingID).
+		WithFeature(sessionFeature, featureWithDesiredProperties()).
+		WithFeature(sessionAdded, featureWithDesiredProperties()))
+	require.NoError(t, err)
+	_, err = storage.ThingSynchronized(conflictThingID, revision)
+	require.NoError(t, err)
+
+	// the desired properties of the first feature and the reported ones of the second feature are modified offline
+	local := featureWithDesiredProperties()
+	local.DesiredProperties["prop1"] = "local"
+	_, err = storage.AddFeature(conflictThingID, sessionFeature, local)
+	require.NoError(t, err)
+	reported := featureWithDesiredProperties()
+	reported.Properties["prop1"] = "reported"
+	_, err = storage.AddFeature(conflictThingID, sessionAdded, reported)
+	require.NoError(t, err)
+
+	cloudFeatures := map[string]model.Feature{
+		sessionFeature: {DesiredProperties: map[string]interface{}{"prop1": "cloud"}},
+		sessionAdded:   {DesiredProperties: map[string]interface{}{"prop1": "cloud"}},
+	}
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := newSessionSynchronizer(t, storage, honoPub)
+	synchronizer.Resolver = sync.ConflictResolverFunc(func(conflict *sync.Conflict) sync.Resolution {
+		assert.Equal(t, conflictThingID, conflict.ThingID)
+		assert.Equal(t, sessionFeature, conflict.FeatureID, "the reported properties change is not a conflict")
+		assert.Equal(t, "local", conflict.Local["prop1"])
+		assert.Equal(t, "cloud", conflict.Cloud["prop1"])
+		assert.False(t, conflict.LocalModified.IsZero())
+		return sync.ResolutionLocal
+	})
+	require.NoError(t, synchronizer.UpdateLocalDesiredProperties(conflictThingID, cloudFeatures))
+
+	feature := &model.Feature{}
+	require.NoError(t, storage.GetFeature(conflictThingID, sessionFeature, feature))
+	assert.Equal(t, "local", feature.DesiredProperties["prop1"])
+	require.NoError(t, storage.GetFeature(conflictThingID, sessionAdded, feature))
+	assert.Equal(t, "cloud", feature.DesiredProperties["prop1"])
+
+	env, err := honoPub.Pull(EnvelopeKey(conflictThingID, "/features/"+sessionFeature+"/desiredProperties"))
+	require.NoError(t, err)
+	assert.Equal(t, protocol.ActionModify, env.Topic.Action)
+
+	// the hub desired properties win
+	synchronizer.Resolver, err = sync.NewConflictResolver(sync.ConflictCloudWins)
+	require.NoError(t, err)
+	require.NoError(t, synchronizer.UpdateLocalDesiredProperties(conflictThingID, cloudFeatures))
+	require.NoError(t, storage.GetFeature(conflictThingID, sessionFeature, feature))
+	assert.Equal(t, map[string]interface{}{"prop1": "cloud"}, feature.DesiredProperties)
+}
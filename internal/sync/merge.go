@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/jsonutil"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// mergeThing synchronizes all the local changes of the thing with a single merge command
+// and marks them synchronized once it is published. Returns true if there are changes to synchronize.
+func (s *Synchronizer) mergeThing(thingID string, sysData *data.SystemThingData) (bool, error) {
+	patch, err := s.thingMergePatch(thingID, sysData)
+	if err != nil || patch == nil {
+		return false, err
+	}
+
+	mergeHeader := protocol.NewHeaders().
+		WithResponseRequired(false).
+		WithContentType(protocol.ContentTypeJSONMerge).
+		WithCorrelationID(watermill.NewUUID())
+
+	cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).
+		Twin().
+		Merge(patch)
+
+	if !s.connected {
+		return false, ErrNoConnection
+	}
+
+	if err := s.publishHonoMsg(cmd.Envelope(mergeHeader), thingID); err != nil {
+		return false, err
+	}
+
+	if sysData.UnsynchronizedAttributes != 0 {
+		s.attributesSynchronized(thingID, sysData.UnsynchronizedAttributes)
+	}
+	for featureID := range sysData.DeletedFeatures {
+		s.featureSynchronized(thingID, featureID, 0)
+	}
+	for featureID, revision := range sysData.UnsynchronizedFeatures {
+		s.featureSynchronized(thingID, featureID, revision)
+	}
+	return true, nil
+}
+
+// thingMergePatch returns the JSON merge patch of the thing local changes, nil if there are none.
+func (s *Synchronizer) thingMergePatch(
+	thingID string, sysData *data.SystemThingData,
+) (map[string]interface{}, error) {
+	patch := make(map[string]interface{})
+
+	if sysData.UnsynchronizedAttributes != 0 {
+		thing := &model.Thing{}
+		if err := s.Storage.GetThingData(thingID, thing); err != nil {
+			return nil, err
+		}
+		patch["attributes"] = jsonutil.WithRemovals(nullIfEmpty(thing.Attributes), sysData.RemovedAttributes)
+	}
+
+	features := make(map[string]interface{}, len(sysData.DeletedFeatures)+len(sysData.UnsynchronizedFeatures))
+	for featureID := range sysData.DeletedFeatures {
+		features[featureID] = nil
+	}
+	if len(sysData.UnsynchronizedFeatures) > 0 {
+		unsyncFeatures, err := s.unsynchronizedFeatures(thingID, sysData)
+		if err != nil {
+			return nil, err
+		}
+		for featureID, feature := range unsyncFeatures {
+			_, definitionChanged := sysData.UnsynchronizedDefinitions[featureID]
+			features[featureID] = featureMergePatch(feature, definitionChanged, sysData.RemovedProperties[featureID])
+		}
+	}
+	if len(features) > 0 {
+		patch["features"] = features
+	}
+
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return patch, nil
+}
+
+// featureMergePatch returns the JSON merge patch synchronizing the feature, equivalent to the featureSyncCmds ones.
+// If the feature has desired properties, its properties are synchronized only, followed by its definition,
+// if it is changed. Otherwise, the hub feature members not available locally are removed.
+// The locally removed properties are removed from the hub ones, as they are not replaced by a merge.
+func featureMergePatch(
+	feature *model.Feature, definitionChanged bool, removedProperties map[string]interface{},
+) map[string]interface{} {
+	patch := map[string]interface{}{
+		"properties": jsonutil.WithRemovals(nullIfEmpty(feature.Properties), removedProperties),
+	}
+	if len(feature.DesiredProperties) == 0 {
+		patch["desiredProperties"] = nil
+		definitionChanged = true
+	}
+	if definitionChanged {
+		if len(feature.Definition) == 0 {
+			patch["definition"] = nil
+		} else {
+			patch["definition"] = feature.Definition
+		}
+	}
+	return patch
+}
+
+// nullIfEmpty returns nil for an empty value, removing the hub member on merge.
+func nullIfEmpty(value map[string]interface{}) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
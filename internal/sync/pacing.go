@@ -0,0 +1,180 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+const (
+	// defaultPacerMaxBatch is the default maximum number of the messages published back to back.
+	defaultPacerMaxBatch = 32
+	// pacerSmoothing is the weight of the latest sample in the smoothed link metrics.
+	pacerSmoothing = 0.2
+	// pacerLossyRate and pacerGoodRate are the publish failure rates, above which the link is lossy
+	// and below which it is good.
+	pacerLossyRate = 0.1
+	pacerGoodRate  = 0.01
+	// pacerDelaySteps is the number of the halvings of the maximum delay, below which no delay is applied.
+	pacerDelaySteps = 16
+	// pacerPollInterval is the interval of checking the hub connection while waiting.
+	pacerPollInterval = 100 * time.Millisecond
+)
+
+// Pacer adapts the pace of the synchronization messages to the hub link quality, measured by the round trip time
+// and the failure rate of the acknowledged publishing. On a good link, the messages are published in growing
+// batches without any delay. On a lossy or slow link, the batches are shrunk and the delay between them
+// is increased up to the maximum one, so that the synchronization does not flood a degraded link.
+type Pacer struct {
+	// TargetRTT is the round trip time of a good link, twice of it is regarded as a slow link.
+	TargetRTT time.Duration
+	// MaxDelay is the maximum delay between the published batches.
+	MaxDelay time.Duration
+	// MaxBatch is the maximum number of the messages published back to back, 32 if not set.
+	MaxBatch int
+
+	mutex    gosync.Mutex
+	rtt      time.Duration
+	failures float64
+	batch    int
+	delay    time.Duration
+	sent     int
+}
+
+// PacerStats contains the measured hub link quality and the current pace of the synchronization messages.
+type PacerStats struct {
+	// RTT is the smoothed round trip time of the acknowledged publishing.
+	RTT time.Duration
+	// FailureRate is the smoothed rate of the failed publishing, from 0 to 1.
+	FailureRate float64
+	// Batch is the number of the messages published back to back.
+	Batch int
+	// Delay is the delay between the published batches.
+	Delay time.Duration
+}
+
+// Reset forgets the measured link quality, e.g. on a new hub connection.
+func (p *Pacer) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.rtt = 0
+	p.failures = 0
+	p.batch = 1
+	p.delay = 0
+	p.sent = 0
+}
+
+// Published records the duration and the result of a publishing and returns the time to wait before the next one.
+func (p *Pacer) Published(duration time.Duration, err error) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.batch == 0 {
+		p.batch = 1
+	}
+
+	failure := 0.0
+	if err != nil {
+		failure = 1
+	} else if p.rtt == 0 {
+		p.rtt = duration
+	} else {
+		p.rtt += time.Duration(pacerSmoothing * float64(duration-p.rtt))
+	}
+	p.failures += pacerSmoothing * (failure - p.failures)
+
+	switch {
+	case p.failures > pacerLossyRate || (p.TargetRTT > 0 && p.rtt > 2*p.TargetRTT):
+		p.throttle()
+	case p.failures < pacerGoodRate && (p.TargetRTT <= 0 || p.rtt <= p.TargetRTT):
+		p.push()
+	}
+
+	p.sent++
+	if p.delay == 0 || p.sent < p.batch {
+		return 0
+	}
+	p.sent = 0
+	return p.delay
+}
+
+// throttle halves the batch and doubles the delay, multiplicatively backing off the degraded link.
+func (p *Pacer) throttle() {
+	if p.batch > 1 {
+		p.batch /= 2
+	}
+	step := p.MaxDelay / pacerDelaySteps
+	if step <= 0 {
+		step = p.MaxDelay
+	}
+	p.delay *= 2
+	if p.delay < step {
+		p.delay = step
+	}
+	if p.delay > p.MaxDelay {
+		p.delay = p.MaxDelay
+	}
+}
+
+// push doubles the batch and halves the delay, quickly recovering the full pace on a good link.
+func (p *Pacer) push() {
+	maxBatch := p.MaxBatch
+	if maxBatch <= 0 {
+		maxBatch = defaultPacerMaxBatch
+	}
+	p.batch *= 2
+	if p.batch > maxBatch {
+		p.batch = maxBatch
+	}
+	p.delay /= 2
+	if p.delay < p.MaxDelay/pacerDelaySteps {
+		p.delay = 0
+	}
+}
+
+// Stats returns the measured link quality and the current pace.
+func (p *Pacer) Stats() PacerStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return PacerStats{
+		RTT:         p.rtt,
+		FailureRate: p.failures,
+		Batch:       p.batch,
+		Delay:       p.delay,
+	}
+}
+
+// pace records the hub publishing result and waits before the next synchronization message, if required.
+// The wait is interrupted if the hub connection is lost.
+func (s *Synchronizer) pace(duration time.Duration, err error) {
+	if s.Pacer == nil {
+		return
+	}
+	wait := s.Pacer.Published(duration, err)
+	if wait <= 0 {
+		return
+	}
+
+	s.Logger.Tracef("Synchronization is paced for %v, link stats %+v", wait, s.Pacer.Stats())
+	deadline := time.Now().Add(wait)
+	for s.connected && time.Now().Before(deadline) {
+		remaining := time.Until(deadline)
+		if remaining > pacerPollInterval {
+			remaining = pacerPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+)
+
+func TestPacerGoodLink(t *testing.T) {
+	pacer := &sync.Pacer{TargetRTT: 100 * time.Millisecond, MaxDelay: time.Second, MaxBatch: 8}
+	pacer.Reset()
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), pacer.Published(20*time.Millisecond, nil))
+	}
+	stats := pacer.Stats()
+	assert.Equal(t, 8, stats.Batch)
+	assert.Equal(t, time.Duration(0), stats.Delay)
+	assert.Equal(t, 20*time.Millisecond, stats.RTT)
+}
+
+func TestPacerLossyLink(t *testing.T) {
+	pacer := &sync.Pacer{TargetRTT: 100 * time.Millisecond, MaxDelay: time.Second, MaxBatch: 8}
+	pacer.Reset()
+	for i := 0; i < 5; i++ {
+		pacer.Published(20*time.Millisecond, nil)
+	}
+
+	failure := errors.New("publish timeout")
+	var waited time.Duration
+	for i := 0; i < 10; i++ {
+		waited += pacer.Published(0, failure)
+	}
+	stats := pacer.Stats()
+	assert.Equal(t, 1, stats.Batch)
+	assert.Equal(t, time.Second, stats.Delay)
+	assert.Greater(t, stats.FailureRate, 0.5)
+	assert.Greater(t, waited, time.Second)
+
+	// recovered once the link is good again
+	for i := 0; i < 30; i++ {
+		pacer.Published(20*time.Millisecond, nil)
+	}
+	stats = pacer.Stats()
+	assert.Equal(t, 8, stats.Batch)
+	assert.Equal(t, time.Duration(0), stats.Delay)
+}
+
+func TestPacerSlowLink(t *testing.T) {
+	pacer := &sync.Pacer{TargetRTT: 100 * time.Millisecond, MaxDelay: 800 * time.Millisecond}
+	pacer.Reset()
+
+	assert.Equal(t, 50*time.Millisecond, pacer.Published(300*time.Millisecond, nil))
+	assert.Equal(t, 100*time.Millisecond, pacer.Published(300*time.Millisecond, nil))
+	stats := pacer.Stats()
+	assert.Equal(t, 1, stats.Batch)
+	assert.Equal(t, 300*time.Millisecond, stats.RTT)
+
+	// throttled while the smoothed round trip time is above twice the target
+	for i := 0; i < 20; i++ {
+		pacer.Published(150*time.Millisecond, nil)
+	}
+	stats = pacer.Stats()
+	assert.Equal(t, 800*time.Millisecond, stats.Delay)
+	assert.Less(t, stats.RTT, 200*time.Millisecond)
+
+	// neither pushed nor throttled between the target and twice of it
+	for i := 0; i < 20; i++ {
+		pacer.Published(150*time.Millisecond, nil)
+	}
+	assert.Equal(t, 800*time.Millisecond, pacer.Stats().Delay)
+}
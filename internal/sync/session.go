@@ -0,0 +1,210 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+)
+
+const (
+	// sessionPhaseRetrieve is the session phase of issuing the retrieve desired properties commands.
+	sessionPhaseRetrieve = "retrieve"
+	// sessionPhaseSync is the session phase of awaiting the retrieve responses and synchronizing the things.
+	sessionPhaseSync = "sync"
+)
+
+// session is the progress of the synchronization session, persisted if the sessions are resumable.
+type session struct {
+	started   time.Time
+	phase     string
+	remaining map[string]bool
+	retrieves map[string]data.RetrieveData
+}
+
+// resumedSession starts a new synchronization session of the provided things or resumes the persisted one,
+// if it is started within the session validity. On resume, the things synchronized within the session
+// are not retrieved again, the ones of them with pending local changes are synchronized only,
+// and the responses of the retrieve commands issued within the session are awaited instead of issuing them again.
+// Returns the things to be retrieved.
+func (s *Synchronizer) resumedSession(thingIDs []string) []string {
+	if s.SessionValidity <= 0 {
+		return thingIDs
+	}
+
+	now := time.Now()
+	persisted := s.loadSession(now)
+	s.session = &session{
+		started:   now,
+		phase:     sessionPhaseRetrieve,
+		remaining: make(map[string]bool, len(thingIDs)),
+		retrieves: make(map[string]data.RetrieveData),
+	}
+	if persisted == nil {
+		for _, thingID := range thingIDs {
+			s.session.remaining[thingID] = true
+		}
+		s.storeSession()
+		return thingIDs
+	}
+
+	s.Logger.Infof("Resuming synchronization session started at %s in phase '%s' with %d remaining things",
+		persisted.Started, persisted.Phase, len(persisted.Remaining))
+	s.session.started, _ = time.Parse(time.RFC3339Nano, persisted.Started)
+
+	remaining := make(map[string]bool, len(persisted.Remaining))
+	for _, thingID := range persisted.Remaining {
+		remaining[thingID] = true
+	}
+
+	awaited := make(map[string]bool)
+	timeout := s.retrieveTimeout()
+	for correlationID, retrieve := range persisted.Retrieves {
+		issued, err := time.Parse(time.RFC3339Nano, retrieve.Issued)
+		if err != nil || !remaining[retrieve.ThingID] || now.After(issued.Add(timeout)) {
+			continue
+		}
+		s.cloudResponsesIDs[correlationID] = cloudResponse{thingID: retrieve.ThingID, deadline: issued.Add(timeout)}
+		s.session.retrieves[correlationID] = retrieve
+		awaited[retrieve.ThingID] = true
+	}
+
+	var retrieved, pending []string
+	for _, thingID := range thingIDs {
+		switch {
+		case !remaining[thingID]:
+			if s.pendingChanges(thingID) {
+				pending = append(pending, thingID)
+			}
+		case awaited[thingID]:
+			s.session.remaining[thingID] = true
+			s.Logger.Debugf("Retrieve desired properties of thing '%s' resumed, not issued again", thingID)
+		default:
+			s.session.remaining[thingID] = true
+			retrieved = append(retrieved, thingID)
+		}
+	}
+	s.storeSession()
+
+	if err := s.SyncThings(pending...); err != nil {
+		s.Logger.Debugf("Error on synchronizing things of the resumed session: %v", err)
+	}
+	return retrieved
+}
+
+// loadSession returns the persisted synchronization session, if started within the session validity.
+func (s *Synchronizer) loadSession(now time.Time) *data.SyncSessionData {
+	persisted := &data.SyncSessionData{}
+	if err := s.Storage.GetSyncSession(persisted); err != nil {
+		if !errors.Is(err, persistence.ErrNotFound) {
+			s.Logger.Errorf("Error on loading the persisted synchronization session: %v", err)
+		}
+		return nil
+	}
+	started, err := time.Parse(time.RFC3339Nano, persisted.Started)
+	if err != nil || now.After(started.Add(s.SessionValidity)) {
+		s.Logger.Debugf("Persisted synchronization session started at %s expired", persisted.Started)
+		return nil
+	}
+	return persisted
+}
+
+// sessionRetrieveIssued records the retrieve command issued within the session. If no command is issued,
+// i.e. the thing has no features, there is nothing to be awaited and the thing is synchronized.
+func (s *Synchronizer) sessionRetrieveIssued(thingID string, env *protocol.Envelope) {
+	if s.session == nil {
+		return
+	}
+	if env == nil {
+		delete(s.session.remaining, thingID)
+		return
+	}
+	s.session.retrieves[env.Headers.CorrelationID()] = data.RetrieveData{
+		ThingID: thingID,
+		Issued:  time.Now().Format(time.RFC3339Nano),
+	}
+}
+
+// sessionRetrievesIssued persists the session once its retrieve commands are issued.
+func (s *Synchronizer) sessionRetrievesIssued() {
+	if s.session == nil {
+		return
+	}
+	s.session.phase = sessionPhaseSync
+	s.storeSession()
+}
+
+// sessionResponseHandled records the handled retrieve response and the thing synchronization, if succeeded.
+// The thing is retrieved again on the session resume, if its synchronization has failed.
+func (s *Synchronizer) sessionResponseHandled(correlationID, thingID string, synchronized bool) {
+	if s.session == nil {
+		return
+	}
+	if _, ok := s.session.retrieves[correlationID]; !ok {
+		return
+	}
+	delete(s.session.retrieves, correlationID)
+	if synchronized {
+		delete(s.session.remaining, thingID)
+		s.storeSession()
+	}
+}
+
+// storeSession persists the session progress, the completed session is removed.
+func (s *Synchronizer) storeSession() {
+	if s.session.phase == sessionPhaseSync && len(s.session.remaining) == 0 {
+		s.Logger.Infof("Synchronization session started at %s is completed",
+			s.session.started.Format(time.RFC3339))
+		s.session = nil
+		if err := s.Storage.RemoveSyncSession(); err != nil {
+			s.Logger.Errorf("Error on removing the completed synchronization session: %v", err)
+		}
+		return
+	}
+
+	remaining := make([]string, 0, len(s.session.remaining))
+	for thingID := range s.session.remaining {
+		remaining = append(remaining, thingID)
+	}
+	sort.Strings(remaining)
+	persisted := &data.SyncSessionData{
+		Started:   s.session.started.Format(time.RFC3339Nano),
+		Phase:     s.session.phase,
+		Remaining: remaining,
+		Retrieves: s.session.retrieves,
+	}
+	if err := s.Storage.SetSyncSession(persisted); err != nil {
+		s.Logger.Errorf("Error on persisting the synchronization session: %v", err)
+	}
+}
+
+// pendingChanges returns true if the thing has local changes not synchronized with the hub.
+func (s *Synchronizer) pendingChanges(thingID string) bool {
+	sysData, err := s.Storage.GetSystemThingData(thingID)
+	return err == nil && (len(sysData.UnsynchronizedFeatures) > 0 || len(sysData.DeletedFeatures) > 0 ||
+		sysData.UnsynchronizedAttributes != 0)
+}
+
+// retrieveTimeout returns the timeout of the retrieve desired properties commands.
+func (s *Synchronizer) retrieveTimeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return protocol.NewHeaders().Timeout()
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/sync"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+const (
+	sessionDB       = "things_test_session.db"
+	sessionThingA   = "things.session:a"
+	sessionThingB   = "things.session:b"
+	sessionThingC   = "things.session:c"
+	sessionFeature  = "meter"
+	sessionAdded    = "added"
+	sessionDeviceID = "things.session:device"
+)
+
+func TestSyncSessionResume(t *testing.T) {
+	storage, err := persistence.NewThingsDB(sessionDB, sessionDeviceID)
+	require.NoError(t, err)
+	defer func() {
+		storage.Close()
+		os.Remove(sessionDB)
+	}()
+	for _, thingID := range []string{sessionThingA, sessionThingB, sessionThingC} {
+		_, err := storage.AddThing((&model.Thing{}).WithIDFrom(thingID).
+			WithFeature(sessionFeature, featureNoDesiredProperties()))
+		require.NoError(t, err)
+	}
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := newSessionSynchronizer(t, storage, honoPub)
+	require.NoError(t, synchronizer.Start())
+
+	retrieves := make(map[string]protocol.Envelope)
+	for _, thingID := range []string{sessionThingA, sessionThingB, sessionThingC} {
+		retrieves[thingID] = pullRetrieve(t, honoPub, thingID)
+	}
+
+	handleRetrieveResponse(t, synchronizer, retrieves[sessionThingA])
+	session := &data.SyncSessionData{}
+	require.NoError(t, storage.GetSyncSession(session))
+	assert.Equal(t, "sync", session.Phase)
+	assert.Equal(t, []string{sessionThingB, sessionThingC}, session.Remaining)
+	assert.Len(t, session.Retrieves, 2)
+
+	// the restarted synchronizer awaits the issued retrieves and synchronizes the local changes only
+	_, err = storage.AddFeature(sessionThingA, sessionAdded, featureNoDesiredProperties())
+	require.NoError(t, err)
+	honoPub.buffer = make(map[string]*list.List)
+
+	restarted := newSessionSynchronizer(t, storage, honoPub)
+	require.NoError(t, restarted.Start())
+	for _, thingID := range []string{sessionThingA, sessionThingB, sessionThingC} {
+		_, err := honoPub.Pull(EnvelopeKey(thingID, "/"))
+		assert.Error(t, err, thingID)
+	}
+	_, err = honoPub.Pull(EnvelopeKey(sessionThingA, "/features/"+sessionAdded))
+	assert.NoError(t, err)
+
+	handleRetrieveResponse(t, restarted, retrieves[sessionThingB])
+	session = &data.SyncSessionData{}
+	require.NoError(t, storage.GetSyncSession(session))
+	assert.Equal(t, []string{sessionThingC}, session.Remaining)
+
+	// the completed session is removed
+	handleRetrieveResponse(t, restarted, retrieves[sessionThingC])
+	assert.ErrorIs(t, storage.GetSyncSession(&data.SyncSessionData{}), persistence.ErrNotFound)
+
+	// a new session retrieves all things
+	require.NoError(t, restarted.Start())
+	for _, thingID := range []string{sessionThingA, sessionThingB, sessionThingC} {
+		pullRetrieve(t, honoPub, thingID)
+	}
+}
+
+func TestSyncSessionExpired(t *testing.T) {
+	storage, err := persistence.NewThingsDB(sessionDB, sessionDeviceID)
+	require.NoError(t, err)
+	defer func() {
+		storage.Close()
+		os.Remove(sessionDB)
+	}()
+	_, err = storage.AddThing((&model.Thing{}).WithIDFrom(sessionThingA).
+		WithFeature(sessionFeature, featureNoDesiredProperties()))
+	require.NoError(t, err)
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := newSessionSynchronizer(t, storage, honoPub)
+	synchronizer.SessionValidity = time.Millisecond
+	require.NoError(t, synchronizer.Start())
+	pullRetrieve(t, honoPub, sessionThingA)
+	time.Sleep(5 * time.Millisecond)
+
+	restarted := newSessionSynchronizer(t, storage, honoPub)
+	restarted.SessionValidity = time.Millisecond
+	require.NoError(t, restarted.Start())
+	pullRetrieve(t, honoPub, sessionThingA)
+}
+
+func newSessionSynchronizer(t *testing.T, storage persistence.ThingsStorage, honoPub *testPublisher) *sync.Synchronizer {
+	return &sync.Synchronizer{
+		DeviceInfo: commands.DeviceInfo{
+			DeviceID: sessionDeviceID,
+			TenantID: "tenantID",
+		},
+		HonoPub:         honoPub,
+		MosquittoPub:    &testPublisher{buffer: make(map[string]*list.List)},
+		Storage:         storage,
+		SessionValidity: time.Minute,
+		Logger:          testutil.NewLogger("sync", logger.TRACE, t),
+	}
+}
+
+func pullRetrieve(t *testing.T, honoPub *testPublisher, thingID string) protocol.Envelope {
+	env, err := honoPub.Pull(EnvelopeKey(thingID, "/"))
+	require.NoError(t, err, thingID)
+	require.Equal(t, protocol.ActionRetrieve, env.Topic.Action)
+	return env
+}
+
+func handleRetrieveResponse(t *testing.T, synchronizer *sync.Synchronizer, retrieve protocol.Envelope) {
+	response := retrieve
+	response.Headers = protocol.NewHeaders().WithCorrelationID(retrieve.Headers.CorrelationID())
+	response.Value = json.RawMessage(`{"features":{}}`)
+	response.Status = http.StatusOK
+	payload, err := json.Marshal(response)
+	require.NoError(t, err)
+
+	msgs, err := synchronizer.HandleResponse(message.NewMessage("response", payload))
+	require.NoError(t, err)
+	assert.Nil(t, msgs)
+}
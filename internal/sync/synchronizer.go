@@ -14,6 +14,8 @@ package sync
 
 import (
 	"errors"
+	gosync "sync"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -21,8 +23,10 @@ import (
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
 	"github.com/eclipse-kanto/local-digital-twins/internal/model"
 	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+	"github.com/eclipse-kanto/local-digital-twins/internal/status"
 	"github.com/eclipse-kanto/suite-connector/logger"
 )
 
@@ -33,10 +37,56 @@ type Synchronizer struct {
 	MosquittoPub message.Publisher
 	Storage      persistence.ThingsStorage
 
+	// Budget is the maximum size in bytes of the messages published on a synchronization session,
+	// i.e. per hub connection, 0 for unlimited. The changes not fitting in it are synchronized on the next session.
+	Budget int64
+
+	// Counters, if set, counts the synchronized features.
+	Counters *status.Counters
+
+	// Changes, if set, records the things with desired properties updated from the hub.
+	Changes commands.ChangesRecorder
+
+	// Timeout is the timeout of the retrieve desired properties commands, 0 for the default command timeout.
+	// The responses received after it are discarded.
+	Timeout time.Duration
+
+	// RetrievesValidity, if positive, persists the issued retrieve desired properties commands, so that their
+	// responses received after a restart or a hub reconnect are still applied within it since the commands issuing.
+	// The things awaiting such responses are not retrieved again on the next synchronization process.
+	RetrievesValidity time.Duration
+
+	// SessionValidity, if positive, persists the synchronization session progress, i.e. its phase, the things
+	// not synchronized yet and the issued retrieve desired properties commands, so that a session interrupted
+	// by a restart, e.g. on a binary update, or a hub reconnect is resumed within it since the session start.
+	// The things synchronized within the resumed session or added after its start are not retrieved again,
+	// their pending local changes are synchronized only.
+	SessionValidity time.Duration
+
+	// Resolver, if set, resolves the conflicts of the desired properties changed both locally since the last
+	// synchronization, e.g. while offline, and in the hub. Otherwise, the hub desired properties always win.
+	// The reported properties are owned by the device and are always synchronized to the hub.
+	Resolver ConflictResolver
+
+	// Merge, if set, synchronizes all the local changes of a thing, i.e. its attributes and its changed and deleted
+	// features, with a single merge command instead of a command per change. The members removed locally from
+	// the attributes or the features properties are removed from the hub ones by the merge too.
+	Merge bool
+
+	// Pacer, if set, adapts the pace of the synchronization messages to the hub link quality.
+	Pacer *Pacer
+
 	Logger logger.Logger
 
-	cloudResponsesIDs map[string]string
+	cloudResponsesIDs map[string]cloudResponse
+	retrieves         map[string]data.RetrieveData
+	retrieved         map[string]bool
 	connected         bool
+	budget            budget
+	session           *session
+
+	diffs      map[string]chan *protocol.Envelope
+	diffsMutex gosync.Mutex
 }
 
 var (
@@ -45,27 +95,39 @@ var (
 )
 
 // Start is used to trigger a new synchronization process.
-// It will start synchronization for each locally persisted thing.
+// It will delete in the hub the things deleted locally meanwhile
+// and then start synchronization for each locally persisted thing.
 func (s *Synchronizer) Start() error {
-	s.cloudResponsesIDs = make(map[string]string)
+	s.cloudResponsesIDs = make(map[string]cloudResponse)
 	s.connected = true
+	s.budget.reset(s.Budget)
+	if s.Pacer != nil {
+		s.Pacer.Reset()
+	}
+
+	if err := s.syncTombstones(); err != nil {
+		s.Logger.Debugf("Error on synchronizing deleted things: %v", err)
+	}
 
 	thingIDs, err := s.Storage.GetThingIDs()
 	if err != nil {
 		return err
 	}
+	thingIDs = s.restoredRetrieves(thingIDs)
+	thingIDs = s.resumedSession(thingIDs)
 
-	err = s.retrieveDesiredProperties(thingIDs...)
+	err = s.retrieveDesiredProperties(s.prioritizedThings(thingIDs)...)
 	if err != nil {
 		s.Logger.Debugf("Error on retrieve desired properties request: %v", err)
 	}
+	s.sessionRetrievesIssued()
 	return nil
 }
 
 // Stop is used to interrupt a started synchronization process, e.g. on hub connection lost.
 func (s *Synchronizer) Stop() {
 	s.connected = false
-	s.cloudResponsesIDs = make(map[string]string)
+	s.cloudResponsesIDs = make(map[string]cloudResponse)
 }
 
 // Connected is used to modify the connection state.
@@ -79,13 +141,22 @@ func (s *Synchronizer) SyncThings(thingIDs ...string) error {
 		return ErrNoConnection
 	}
 
+	var budgetErr error
 	for _, thingID := range thingIDs {
-		if err := s.syncThing(thingID); err != nil {
+		err := s.syncThing(thingID)
+		if errors.Is(err, ErrBudgetExhausted) {
+			s.deferSync(thingID)
+			budgetErr = err
+			continue
+		}
+		if err != nil {
 			return err
 		}
+		s.budget.thingSynchronized(thingID)
+		s.publishSyncStatus(thingID, true)
 	}
 
-	return nil
+	return budgetErr
 }
 
 func (s *Synchronizer) syncThing(thingID string) error {
@@ -99,14 +170,19 @@ func (s *Synchronizer) syncThing(thingID string) error {
 		s.Logger.Errorf("Error on getting thing '%s' system data: %v", thingID, err)
 		return err
 	}
+	if s.Merge {
+		merged, err := s.mergeThing(thingID, sysData)
+		if err != nil {
+			return err
+		}
+		return s.thingSyncFinished(thingID, sysData.Revision, merged)
+	}
 	syncThing := false
-	unsyncFeatures := sysData.UnsynchronizedFeatures
-	if len(unsyncFeatures) > 0 {
+
+	if sysData.UnsynchronizedAttributes != 0 {
 		syncThing = true
-		for featureID, revision := range unsyncFeatures {
-			if err := s.syncFeatureRevision(thingID, featureID, revision); err != nil {
-				return err
-			}
+		if err := s.syncAttributes(thingID, sysData.UnsynchronizedAttributes); err != nil {
+			return err
 		}
 	}
 
@@ -117,18 +193,64 @@ func (s *Synchronizer) syncThing(thingID string) error {
 			return err
 		}
 	}
-	if syncThing {
-		ok, err := s.Storage.ThingSynchronized(thingID, sysData.Revision)
+
+	if len(sysData.UnsynchronizedFeatures) > 0 {
+		syncThing = true
+		features, err := s.unsynchronizedFeatures(thingID, sysData)
 		if err != nil {
-			s.Logger.Errorf("Error on persisting thing '%s' synchronized state: %v", thingID, err)
 			return err
 		}
 
-		s.Logger.Infof("Thing '%s' synchronization is finished, synchronized '%v'", thingID, ok)
-	} else {
+		for _, featureID := range prioritizedFeatures(features) {
+			_, definitionChanged := sysData.UnsynchronizedDefinitions[featureID]
+			if err := s.syncFeature(
+				thingID, featureID, features[featureID], sysData.UnsynchronizedFeatures[featureID], definitionChanged,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.thingSyncFinished(thingID, sysData.Revision, syncThing)
+}
+
+// unsynchronizedFeatures loads the features of the thing changed locally since their last synchronization.
+func (s *Synchronizer) unsynchronizedFeatures(
+	thingID string, sysData *data.SystemThingData,
+) (map[string]*model.Feature, error) {
+	featureIDs, err := s.Storage.GetFeatureIDs(thingID)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(map[string]*model.Feature, len(sysData.UnsynchronizedFeatures))
+	for _, featureID := range featureIDs {
+		if _, ok := sysData.UnsynchronizedFeatures[featureID]; !ok {
+			continue
+		}
+		feature := &model.Feature{}
+		if err := s.Storage.GetFeature(thingID, featureID, feature); err != nil {
+			return nil, err
+		}
+		features[featureID] = feature
+	}
+	return features, nil
+}
+
+// thingSyncFinished marks the thing synchronized up to the revision, if any of its changes are synchronized.
+func (s *Synchronizer) thingSyncFinished(thingID string, revision int64, synchronized bool) error {
+	if !synchronized {
 		s.Logger.Debugf("Thing '%s' features were already synchronized", thingID)
+		return nil
+	}
+
+	ok, err := s.Storage.ThingSynchronized(thingID, revision)
+	if err != nil {
+		s.Logger.Errorf("Error on persisting thing '%s' synchronized state: %v", thingID, err)
+		return err
 	}
 
+	s.Logger.Infof("Thing '%s' synchronization is finished, synchronized '%v'", thingID, ok)
 	return nil
 }
 
@@ -157,54 +279,114 @@ func (s *Synchronizer) SyncFeature(thingID string, featureID string) error {
 		return nil
 	}
 
-	return s.syncFeature(thingID, featureID, &feature, revision)
+	_, definitionChanged := sysData.UnsynchronizedDefinitions[featureID]
+	return s.syncFeature(thingID, featureID, &feature, revision, definitionChanged)
 }
 
-func (s *Synchronizer) syncFeatureRevision(thingID, featureID string, revision int64) error {
-	feature := model.Feature{}
-	if err := s.Storage.GetFeature(thingID, featureID, &feature); err != nil {
-		s.Logger.Error("Error on getting feature", err, logFieldsFeature(thingID, featureID))
-		return err
+func (s *Synchronizer) syncFeature(
+	thingID string, featureID string, feature *model.Feature, revision int64, definitionChanged bool,
+) error {
+	for _, featureCmd := range featureSyncCmds(model.NewNamespacedIDFrom(thingID), featureID, feature, definitionChanged) {
+		defHeader := protocol.NewHeaders().
+			WithResponseRequired(false).
+			WithCorrelationID(watermill.NewUUID())
+
+		if !s.connected {
+			return ErrNoConnection
+		}
+
+		if err := s.publishHonoMsg(featureCmd.Envelope(defHeader), thingID); err != nil {
+			return err
+		}
+	}
+
+	s.featureSynchronized(thingID, featureID, revision)
+	return nil
+}
+
+// featureSynchronized marks the feature synchronized up to the revision, 0 for a deleted feature.
+func (s *Synchronizer) featureSynchronized(thingID string, featureID string, revision int64) {
+	if ok, err := s.Storage.FeatureSynchronized(thingID, featureID, revision); err != nil {
+		s.Logger.Debug("Error on persisting feature synchronization state", logFeatureError(thingID, featureID, err))
+	} else {
+		s.Logger.Debug("Feature synchronization is finished", logFeatureSynchronized(thingID, featureID, ok))
+		if ok {
+			s.Counters.Inc(status.CounterFeaturesSynchronized)
+		}
+	}
+}
+
+// featureSyncCmds returns the commands synchronizing the feature. If the feature has desired properties,
+// its properties are synchronized only, followed by its definition, if it is changed.
+func featureSyncCmds(
+	thingID *model.NamespacedID, featureID string, thingFeature *model.Feature, definitionChanged bool,
+) []*things.Command {
+	if len(thingFeature.DesiredProperties) == 0 {
+		// No desired properties - publish modify feature
+		return []*things.Command{
+			things.NewCommand(thingID).
+				Feature(featureID).
+				Modify(thingFeature),
+		}
+	}
+
+	// there are desired properties - publish modify feature properties only
+	cmds := []*things.Command{
+		things.NewCommand(thingID).
+			FeatureProperties(featureID).
+			Modify(thingFeature.Properties),
+	}
+	if !definitionChanged {
+		return cmds
 	}
 
-	return s.syncFeature(thingID, featureID, &feature, revision)
+	// the definition is synchronized separately, so that the cloud desired properties are kept
+	if len(thingFeature.Definition) == 0 {
+		return append(cmds, things.NewCommand(thingID).
+			FeatureDefinition(featureID).
+			Delete())
+	}
+	return append(cmds, things.NewCommand(thingID).
+		FeatureDefinition(featureID).
+		Modify(thingFeature.Definition))
 }
 
-func (s *Synchronizer) syncFeature(thingID string, featureID string, feature *model.Feature, revision int64) error {
+// syncAttributes synchronizes the locally modified attributes of the thing, replacing the hub ones.
+func (s *Synchronizer) syncAttributes(thingID string, revision int64) error {
+	thing := &model.Thing{}
+	if err := s.Storage.GetThingData(thingID, thing); err != nil {
+		return err
+	}
+
 	defHeader := protocol.NewHeaders().
 		WithResponseRequired(false).
 		WithCorrelationID(watermill.NewUUID())
 
-	featureCmd := featureSyncCmd(model.NewNamespacedIDFrom(thingID), featureID, feature)
+	cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Attributes()
+	if len(thing.Attributes) == 0 {
+		cmd.Delete()
+	} else {
+		cmd.Modify(thing.Attributes)
+	}
 
 	if !s.connected {
 		return ErrNoConnection
 	}
 
-	if err := publishHonoMsg(featureCmd.Envelope(defHeader), s.HonoPub, s.DeviceInfo, thingID, s.Logger); err != nil {
+	if err := s.publishHonoMsg(cmd.Envelope(defHeader), thingID); err != nil {
 		return err
 	}
 
-	if ok, err := s.Storage.FeatureSynchronized(thingID, featureID, revision); err != nil {
-		s.Logger.Debug("Error on persisting feature synchronization state", logFeatureError(thingID, featureID, err))
-	} else {
-		s.Logger.Debug("Feature synchronization is finished", logFeatureSynchronized(thingID, featureID, ok))
-	}
+	s.attributesSynchronized(thingID, revision)
 	return nil
 }
 
-func featureSyncCmd(thingID *model.NamespacedID, featureID string, thingFeature *model.Feature) *things.Command {
-	if len(thingFeature.DesiredProperties) == 0 {
-		// No desired properties - publish modify feature
-		return things.NewCommand(thingID).
-			Feature(featureID).
-			Modify(thingFeature)
+func (s *Synchronizer) attributesSynchronized(thingID string, revision int64) {
+	if ok, err := s.Storage.AttributesSynchronized(thingID, revision); err != nil {
+		s.Logger.Debugf("Error on persisting thing '%s' attributes synchronization state: %v", thingID, err)
+	} else {
+		s.Logger.Debugf("Thing '%s' attributes synchronization is finished, synchronized '%v'", thingID, ok)
 	}
-
-	// there are desired properties - publish modify feature properties only
-	return things.NewCommand(thingID).
-		FeatureProperties(featureID).
-		Modify(thingFeature.Properties)
 }
 
 func (s *Synchronizer) syncDeletedFeatures(thingID string, deletedFeaturesPatch map[string]interface{}) error {
@@ -221,7 +403,7 @@ func (s *Synchronizer) syncDeletedFeatures(thingID string, deletedFeaturesPatch
 		return ErrNoConnection
 	}
 
-	if err := publishHonoMsg(featureCmd.Envelope(mergeHeader), s.HonoPub, s.DeviceInfo, thingID, s.Logger); err != nil {
+	if err := s.publishHonoMsg(featureCmd.Envelope(mergeHeader), thingID); err != nil {
 		return err
 	}
 
@@ -233,6 +415,9 @@ func (s *Synchronizer) syncDeletedFeatures(thingID string, deletedFeaturesPatch
 			)
 		} else {
 			s.Logger.Debug("Deleted feature synchronization is finished", logFeatureSynchronized(thingID, featureID, ok))
+			if ok {
+				s.Counters.Inc(status.CounterFeaturesSynchronized)
+			}
 		}
 	}
 	return nil
@@ -253,9 +438,14 @@ func (s *Synchronizer) retrieveDesiredProperties(thingIDs ...string) error {
 			return ErrNoConnection
 		}
 
-		if err := publishHonoMsg(env, s.HonoPub, s.DeviceInfo, thingID, s.Logger); err != nil {
+		if err := s.publishHonoMsg(env, thingID); err != nil {
+			if errors.Is(err, ErrBudgetExhausted) {
+				s.deferSync(thingID)
+				continue
+			}
 			return err
 		}
+		s.sessionRetrieveIssued(thingID, env)
 		s.Logger.Tracef("Retrieve desired properties of thing '%s' published", thingID)
 	}
 
@@ -173,6 +173,8 @@ func (s *SynchronizerSuite) TestSynchronizeThings() {
 	require.NoError(s.T(), err)
 
 	pub := s.sync.HonoPub.(*testPublisher)
+	assertPublishedAttributes(s.T(), pub, thingID)
+	assertPublishedAttributes(s.T(), pub, thingID2)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, true)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID2, false)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID2, testFeatureID1, false)
@@ -268,6 +270,7 @@ func (s *SynchronizerSuite) TestSynchronizeDeleteThing() {
 	require.NoError(s.T(), err)
 
 	pub := s.sync.HonoPub.(*testPublisher)
+	assertPublishedAttributes(s.T(), pub, thingID)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, true)
 
 	assertPublishеdEnvelopeOnDelete(s.T(), pub, thingID, testFeatureID2)
@@ -291,6 +294,7 @@ func (s *SynchronizerSuite) TestDeleteFeature() {
 	require.NoError(s.T(), err)
 
 	pub := s.sync.HonoPub.(*testPublisher)
+	assertPublishedAttributes(s.T(), pub, thingID)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, false)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID2, false)
 	assert.Equal(s.T(), 0, len(pub.buffer))
@@ -314,6 +318,52 @@ func (s *SynchronizerSuite) TestDeleteFeature() {
 
 }
 
+func (s *SynchronizerSuite) TestSynchronizeFeatureDefinition() {
+	thingID := syncTestThingID + "_FeatureDefinition"
+	s.unsynchronizeThing(thingID, true, false)
+	defer s.sync.Storage.RemoveThing(thingID)
+
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+	pub := s.sync.HonoPub.(*testPublisher)
+	assertPublishedAttributes(s.T(), pub, thingID)
+	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, true)
+	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID2, false)
+	require.Equal(s.T(), 0, len(pub.buffer))
+
+	// the unchanged definition is not synchronized again
+	feature := featureWithDesiredProperties()
+	feature.Properties["prop1"] = "changed"
+	_, err := s.sync.Storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncFeature(thingID, testFeatureID1))
+	_, err = pub.Pull(EnvelopeKey(thingID, createPath(testFeatureID1, true)))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(pub.buffer))
+
+	feature.Definition = []*model.DefinitionID{model.NewDefinitionIDFrom("def:ini:2.0.0")}
+	_, err = s.sync.Storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	sysData, err := s.sync.Storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), sysData.UnsynchronizedDefinitions, testFeatureID1)
+
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, true)
+	assert.Equal(s.T(), 0, len(pub.buffer))
+	sysData, err = s.sync.Storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), sysData.UnsynchronizedDefinitions)
+
+	feature.Definition = nil
+	_, err = s.sync.Storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncFeature(thingID, testFeatureID1))
+	_, err = pub.Pull(EnvelopeKey(thingID, createPath(testFeatureID1, true)))
+	require.NoError(s.T(), err)
+	assertPublishedDefinition(s.T(), pub, thingID, testFeatureID1, protocol.ActionDelete)
+	assert.Equal(s.T(), 0, len(pub.buffer))
+}
+
 func (s *SynchronizerSuite) TestSyncThingNoChanges() {
 	thingID := syncTestThingID + "_TestSyncThingNoChanges"
 	thing := createThingWithFeatures(thingID, testFeatureID1, false, testFeatureID2, false)
@@ -333,6 +383,158 @@ func (s *SynchronizerSuite) TestSyncThingNoChanges() {
 	assert.Equal(s.T(), 0, len(s.sync.HonoPub.(*testPublisher).buffer))
 }
 
+func (s *SynchronizerSuite) TestSynchronizeAttributes() {
+	thingID := syncTestThingID + "_Attributes"
+	thing := createThingWithFeatures(thingID, testFeatureID1, false, testFeatureID2, false)
+	storage := s.sync.Storage
+	revision, err := storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	defer storage.RemoveThing(thingID)
+	_, err = storage.ThingSynchronized(thingID, revision)
+	require.NoError(s.T(), err)
+
+	// the thing attributes modified offline are synchronized
+	thing.Attributes["key1"] = "modified"
+	_, err = storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+
+	pub := s.sync.HonoPub.(*testPublisher)
+	env, err := pub.Pull(EnvelopeKey(thingID, "/attributes"))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), protocol.ActionModify, env.Topic.Action)
+	attributes := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(env.Value, &attributes))
+	assert.Equal(s.T(), "modified", attributes["key1"])
+
+	sysData, err := storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), sysData.UnsynchronizedAttributes)
+
+	// the removed attributes are deleted
+	thing.Attributes = nil
+	_, err = storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+	env, err = pub.Pull(EnvelopeKey(thingID, "/attributes"))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), protocol.ActionDelete, env.Topic.Action)
+}
+
+func (s *SynchronizerSuite) TestSynchronizeMerge() {
+	thingID := syncTestThingID + "_Merge"
+	thing := createThingWithFeatures(thingID, testFeatureID1, true, testFeatureID2, false)
+	storage := s.sync.Storage
+	revision, err := storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	defer storage.RemoveThing(thingID)
+	_, err = storage.ThingSynchronized(thingID, revision)
+	require.NoError(s.T(), err)
+
+	s.sync.Merge = true
+	defer func() {
+		s.sync.Merge = false
+	}()
+
+	// the attributes, the modified and the deleted features are synchronized with a single merge
+	thing.Attributes["key1"] = "modified"
+	thing.Features[testFeatureID1].Properties["prop1"] = "modified"
+	_, err = storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), storage.RemoveFeature(thingID, testFeatureID2))
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+
+	pub := s.sync.HonoPub.(*testPublisher)
+	env, err := pub.Pull(EnvelopeKey(thingID, "/"))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), protocol.ActionMerge, env.Topic.Action)
+	assert.Equal(s.T(), protocol.ContentTypeJSONMerge, env.Headers.ContentType())
+	assert.Empty(s.T(), pub.buffer, "a single command is published")
+
+	patch := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(env.Value, &patch))
+	assert.Equal(s.T(), "modified", patch["attributes"].(map[string]interface{})["key1"])
+	features := patch["features"].(map[string]interface{})
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{"prop1": "modified", "prop2": 1.234},
+	}, features[testFeatureID1], "the desired properties are not overwritten")
+	assert.Contains(s.T(), features, testFeatureID2)
+	assert.Nil(s.T(), features[testFeatureID2])
+
+	sysData, err := storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), sysData.UnsynchronizedAttributes)
+	assert.Empty(s.T(), sysData.UnsynchronizedFeatures)
+	assert.Empty(s.T(), sysData.DeletedFeatures)
+
+	// no changes, no merge
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+	assert.Empty(s.T(), pub.buffer)
+}
+
+func (s *SynchronizerSuite) TestSynchronizeMergeRemoved() {
+	thingID := syncTestThingID + "_MergeRemoved"
+	thing := createThingWithFeatures(thingID, testFeatureID1, true, testFeatureID2, false)
+	storage := s.sync.Storage
+	revision, err := storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	defer storage.RemoveThing(thingID)
+	_, err = storage.ThingSynchronized(thingID, revision)
+	require.NoError(s.T(), err)
+
+	s.sync.Merge = true
+	defer func() {
+		s.sync.Merge = false
+	}()
+
+	// the attributes and the properties removed offline are removed by the merge
+	delete(thing.Attributes, "key2")
+	_, err = storage.AddThing(thing)
+	require.NoError(s.T(), err)
+	feature := thing.Features[testFeatureID1]
+	delete(feature.Properties, "prop2")
+	_, err = storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	feature.Properties["prop3"] = "added"
+	_, err = storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+
+	pub := s.sync.HonoPub.(*testPublisher)
+	env, err := pub.Pull(EnvelopeKey(thingID, "/"))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), protocol.ActionMerge, env.Topic.Action)
+
+	patch := map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(env.Value, &patch))
+	assert.Equal(s.T(), map[string]interface{}{"key1": 1.22, "key2": nil}, patch["attributes"])
+	features := patch["features"].(map[string]interface{})
+	assert.Equal(s.T(), map[string]interface{}{
+		"properties": map[string]interface{}{"prop1": "prop1Val", "prop2": nil, "prop3": "added"},
+	}, features[testFeatureID1])
+
+	sysData, err := storage.GetSystemThingData(thingID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), sysData.RemovedAttributes)
+	assert.Empty(s.T(), sysData.RemovedProperties)
+
+	// the synchronized removals are not merged again
+	feature.Properties["prop3"] = "modified"
+	_, err = storage.AddFeature(thingID, testFeatureID1, feature)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.sync.SyncThings(thingID))
+	env, err = pub.Pull(EnvelopeKey(thingID, "/"))
+	require.NoError(s.T(), err)
+	patch = map[string]interface{}{}
+	require.NoError(s.T(), json.Unmarshal(env.Value, &patch))
+	assert.NotContains(s.T(), patch, "attributes")
+	assert.Equal(s.T(), map[string]interface{}{
+		testFeatureID1: map[string]interface{}{
+			"properties": map[string]interface{}{"prop1": "prop1Val", "prop3": "modified"},
+		},
+	}, patch["features"])
+}
+
 func (s *SynchronizerSuite) synchronizeThing(
 	suffix string, hasDesiredProps1, hasDesiredProps2 bool,
 ) {
@@ -344,6 +546,7 @@ func (s *SynchronizerSuite) synchronizeThing(
 	require.NoError(s.T(), err)
 
 	pub := s.sync.HonoPub.(*testPublisher)
+	assertPublishedAttributes(s.T(), pub, thingID)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID1, hasDesiredProps1)
 	assertPublishedEnvelopeOnModify(s.T(), pub, thingID, testFeatureID2, hasDesiredProps2)
 	assert.Equal(s.T(), 0, len(pub.buffer))
@@ -424,6 +627,30 @@ func assertPublishedEnvelopeOnModify(t *testing.T, pub *testPublisher, expectedT
 	assert.EqualValues(t, expectedThingID, commands.TopicNamespaceID(pubEnv.Topic))
 	assert.EqualValues(t, protocol.ActionModify, pubEnv.Topic.Action)
 	assert.EqualValues(t, expPath, pubEnv.Path)
+
+	if hasDesiredPropertiesFeature {
+		// the definition of the new feature is synchronized separately
+		assertPublishedDefinition(t, pub, expectedThingID, feature, protocol.ActionModify)
+	}
+}
+
+func assertPublishedAttributes(t *testing.T, pub *testPublisher, expectedThingID string) {
+	env, err := pub.Pull(EnvelopeKey(expectedThingID, "/attributes"))
+	require.NoError(t, err)
+	assert.Equal(t, protocol.ActionModify, env.Topic.Action)
+	assert.False(t, env.Headers.ResponseRequired())
+}
+
+func assertPublishedDefinition(t *testing.T, pub *testPublisher, expectedThingID string,
+	feature string, action protocol.TopicAction,
+) {
+	expPath := fmt.Sprintf("/features/%s/definition", feature)
+	pubEnv, err := pub.Pull(EnvelopeKey(expectedThingID, expPath))
+	require.NoError(t, err)
+
+	assert.EqualValues(t, expectedThingID, commands.TopicNamespaceID(pubEnv.Topic))
+	assert.EqualValues(t, action, pubEnv.Topic.Action)
+	assert.EqualValues(t, expPath, pubEnv.Path)
 }
 
 func assertPublishеdEnvelopeOnDelete(t *testing.T, pub *testPublisher, expectedThingID string, deletedFeatures ...string) {
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync
+
+import (
+	"sort"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/pkg/errors"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+// syncTombstones issues the hub delete commands of the things deleted locally, e.g. while offline,
+// and purges their tombstones once the commands are published.
+func (s *Synchronizer) syncTombstones() error {
+	tombstones := &data.TombstonesData{}
+	if err := s.Storage.GetTombstones(tombstones); err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	thingIDs := make([]string, 0, len(tombstones.Things))
+	for thingID := range tombstones.Things {
+		thingIDs = append(thingIDs, thingID)
+	}
+	sort.Strings(thingIDs)
+
+	for _, thingID := range thingIDs {
+		if !s.connected {
+			return ErrNoConnection
+		}
+
+		headers := protocol.NewHeaders().
+			WithResponseRequired(false).
+			WithCorrelationID(watermill.NewUUID())
+		cmd := things.NewCommand(model.NewNamespacedIDFrom(thingID)).Twin().Delete()
+		if err := s.publishHonoMsg(cmd.Envelope(headers), thingID); err != nil {
+			return err
+		}
+
+		ok, err := s.Storage.PurgeTombstone(thingID, tombstones.Things[thingID].Revision)
+		if err != nil {
+			s.Logger.Errorf("Error on purging the tombstone of thing '%s': %v", thingID, err)
+			continue
+		}
+		s.Logger.Infof("Thing '%s' deletion synchronization is finished, purged '%v'", thingID, ok)
+	}
+	return nil
+}
+
+// cloudThingDeleted removes the local twin of a thing deleted in the hub, if any.
+// The hub deletion event is still dispatched to the local applications.
+func (s *Synchronizer) cloudThingDeleted(env *protocol.Envelope) {
+	if env.Topic == nil || env.Topic.Channel != protocol.ChannelTwin ||
+		env.Topic.Criterion != protocol.CriterionEvents ||
+		env.Topic.Action != protocol.ActionDeleted || env.Path != "/" {
+		return
+	}
+
+	thingID := model.NewNamespacedID(env.Topic.Namespace, env.Topic.EntityID).String()
+	sysData, err := s.Storage.GetSystemThingData(thingID)
+	if err != nil {
+		if !errors.Is(err, persistence.ErrThingNotFound) {
+			s.Logger.Errorf("Error on removing thing '%s' deleted in the hub: %v", thingID, err)
+		}
+		return
+	}
+	if err := s.Storage.RemoveThing(thingID); err != nil {
+		s.Logger.Errorf("Error on removing thing '%s' deleted in the hub: %v", thingID, err)
+		return
+	}
+	// the thing is already deleted in the hub, no need to synchronize its deletion
+	if _, err := s.Storage.PurgeTombstone(thingID, sysData.Revision); err != nil {
+		s.Logger.Errorf("Error on purging the tombstone of thing '%s': %v", thingID, err)
+	}
+
+	s.Logger.Info("Thing deleted in the hub is removed locally", watermill.LogFields{"thing": thingID})
+	if s.Changes != nil {
+		s.Changes.ThingChanged(thingID)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package sync_test
+
+import (
+	"container/list"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/model"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence"
+	"github.com/eclipse-kanto/local-digital-twins/internal/persistence/data"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
+	"github.com/eclipse-kanto/local-digital-twins/internal/protocol/things"
+)
+
+func TestSyncTombstones(t *testing.T) {
+	storage, err := persistence.NewThingsDB(filepath.Join(t.TempDir(), "things.db"), sessionDeviceID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	for _, thingID := range []string{sessionThingA, sessionThingB} {
+		_, err := storage.AddThing((&model.Thing{}).WithIDFrom(thingID).
+			WithFeature(sessionFeature, featureNoDesiredProperties()))
+		require.NoError(t, err)
+	}
+	require.NoError(t, storage.RemoveThing(sessionThingA))
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := newSessionSynchronizer(t, storage, honoPub)
+	synchronizer.SessionValidity = 0
+	require.NoError(t, synchronizer.Start())
+
+	env, err := honoPub.Pull(EnvelopeKey(sessionThingA, "/"))
+	require.NoError(t, err)
+	assert.Equal(t, protocol.ActionDelete, env.Topic.Action)
+	assert.False(t, env.Headers.ResponseRequired())
+	_, err = honoPub.Pull(EnvelopeKey(sessionThingA, "/"))
+	assert.Error(t, err, "the deleted thing is not retrieved")
+	pullRetrieve(t, honoPub, sessionThingB)
+
+	assert.ErrorIs(t, storage.GetTombstones(&data.TombstonesData{}), persistence.ErrNotFound)
+
+	// the purged tombstone is not synchronized again
+	require.NoError(t, synchronizer.Start())
+	pullRetrieve(t, honoPub, sessionThingB)
+	_, err = honoPub.Pull(EnvelopeKey(sessionThingA, "/"))
+	assert.Error(t, err)
+}
+
+func TestCloudThingDeleted(t *testing.T) {
+	storage, err := persistence.NewThingsDB(filepath.Join(t.TempDir(), "things.db"), sessionDeviceID)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	for _, thingID := range []string{sessionThingA, sessionThingB} {
+		_, err := storage.AddThing((&model.Thing{}).WithIDFrom(thingID).
+			WithFeature(sessionFeature, featureNoDesiredProperties()))
+		require.NoError(t, err)
+	}
+
+	honoPub := &testPublisher{buffer: make(map[string]*list.List)}
+	synchronizer := newSessionSynchronizer(t, storage, honoPub)
+
+	deleted := func(thingID, path string) *message.Message {
+		env := things.NewEvent(model.NewNamespacedIDFrom(thingID)).Twin().Deleted().
+			Envelope(protocol.NewHeaders().WithCorrelationID(watermill.NewUUID())).WithPath(path)
+		payload, err := json.Marshal(env)
+		require.NoError(t, err)
+		return message.NewMessage(watermill.NewUUID(), payload)
+	}
+
+	// the feature deletion event does not remove the thing
+	msgs, err := synchronizer.HandleResponse(deleted(sessionThingB, "/features/"+sessionFeature))
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	require.NoError(t, storage.GetThing(sessionThingB, &model.Thing{}))
+
+	msgs, err = synchronizer.HandleResponse(deleted(sessionThingA, "/"))
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1, "the hub deletion event is dispatched locally")
+	assert.ErrorIs(t, storage.GetThing(sessionThingA, &model.Thing{}), persistence.ErrThingNotFound)
+	require.NoError(t, storage.GetThing(sessionThingB, &model.Thing{}))
+
+	// the thing is already deleted in the hub, its deletion is not synchronized
+	assert.ErrorIs(t, storage.GetTombstones(&data.TombstonesData{}), persistence.ErrNotFound)
+
+	// unknown thing deletion is a no-op
+	msgs, err = synchronizer.HandleResponse(deleted(sessionThingA, "/"))
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
@@ -14,25 +14,31 @@ package sync
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/eclipse-kanto/local-digital-twins/internal/commands"
 	"github.com/eclipse-kanto/local-digital-twins/internal/protocol"
-	"github.com/eclipse-kanto/suite-connector/logger"
 )
 
-func publishHonoMsg(env *protocol.Envelope, publisher message.Publisher,
-	dInfo commands.DeviceInfo, thingID string, logger logger.Logger) error {
+func (s *Synchronizer) publishHonoMsg(env *protocol.Envelope, thingID string) error {
 	data, err := json.Marshal(env)
 
 	if err != nil {
-		logger.Error("Unexpected synchronize command content", err, commands.CmdLogFields(env))
+		s.Logger.Error("Unexpected synchronize command content", err, commands.CmdLogFields(env))
 		return err
 	}
 
+	if !s.budget.reserve(len(data)) {
+		return ErrBudgetExhausted
+	}
+
 	message := message.NewMessage(watermill.NewUUID(), []byte(data))
-	return commands.PublishHonoMsg(message, publisher, dInfo, thingID)
+	started := time.Now()
+	err = commands.PublishHonoMsg(message, s.HonoPub, s.DeviceInfo, thingID)
+	s.pace(time.Since(started), err)
+	return err
 }
 
 func logFieldsFeature(thingID string, featureID string) watermill.LogFields {
@@ -0,0 +1,293 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/eclipse-kanto/suite-connector/connector"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	"github.com/eclipse-kanto/suite-connector/testutil"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/pkg/errors"
+)
+
+// Broker is a minimal in-memory MQTT 3.1.1 broker, which is meant to test the local messages flows hermetically.
+// QoS 0 and 1 publications and wildcard subscriptions are supported, sessions and retained messages are not.
+type Broker struct {
+	listener net.Listener
+
+	mutex   sync.Mutex
+	clients map[*brokerClient]bool
+	wg      sync.WaitGroup
+}
+
+type brokerClient struct {
+	conn net.Conn
+
+	mutex     sync.Mutex
+	filters   map[string]byte
+	messageID uint16
+}
+
+// NewBroker starts a broker listening on a random local port.
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot start test broker")
+	}
+
+	b := &Broker{
+		listener: listener,
+		clients:  make(map[*brokerClient]bool),
+	}
+
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// URL returns the broker address to connect to.
+func (b *Broker) URL() string {
+	return fmt.Sprintf("tcp://%s", b.listener.Addr())
+}
+
+// Connect returns a new connected MQTT connection to the broker.
+func (b *Broker) Connect(t *testing.T) (*connector.MQTTConnection, error) {
+	config, err := connector.NewMQTTClientConfig(b.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := connector.NewMQTTConnection(
+		config, watermill.NewShortUUID(), testutil.NewLogger("broker", logger.DEBUG, t),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	future := conn.Connect()
+	<-future.Done()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Subscribed returns true if any client is subscribed to the topic, so that the asynchronous
+// subscriptions could be awaited before publishing.
+func (b *Broker) Subscribed(topic string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for client := range b.clients {
+		if _, ok := client.subscribed(topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the broker and disconnects all its clients.
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+
+	b.mutex.Lock()
+	for client := range b.clients {
+		client.conn.Close()
+	}
+	b.mutex.Unlock()
+
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		client := &brokerClient{
+			conn:    conn,
+			filters: make(map[string]byte),
+		}
+		b.mutex.Lock()
+		b.clients[client] = true
+		b.mutex.Unlock()
+
+		b.wg.Add(1)
+		go b.serve(client)
+	}
+}
+
+func (b *Broker) serve(client *brokerClient) {
+	defer b.wg.Done()
+	defer func() {
+		b.mutex.Lock()
+		delete(b.clients, client)
+		b.mutex.Unlock()
+
+		client.conn.Close()
+	}()
+
+	for {
+		packet, err := packets.ReadPacket(client.conn)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = packets.Accepted
+			if err := client.write(ack); err != nil {
+				return
+			}
+
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			client.mutex.Lock()
+			for i, filter := range p.Topics {
+				qos := p.Qoss[i]
+				if qos > 1 {
+					qos = 1
+				}
+				client.filters[filter] = qos
+				ack.ReturnCodes = append(ack.ReturnCodes, qos)
+			}
+			client.mutex.Unlock()
+			if err := client.write(ack); err != nil {
+				return
+			}
+
+		case *packets.UnsubscribePacket:
+			client.mutex.Lock()
+			for _, filter := range p.Topics {
+				delete(client.filters, filter)
+			}
+			client.mutex.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			if err := client.write(ack); err != nil {
+				return
+			}
+
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				if err := client.write(ack); err != nil {
+					return
+				}
+			}
+			b.route(p)
+
+		case *packets.PingreqPacket:
+			if err := client.write(packets.NewControlPacket(packets.Pingresp)); err != nil {
+				return
+			}
+
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// route delivers the publication to all clients with a matching subscription, with the lower of both QoS levels.
+func (b *Broker) route(publish *packets.PublishPacket) {
+	b.mutex.Lock()
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for client := range b.clients {
+		clients = append(clients, client)
+	}
+	b.mutex.Unlock()
+
+	for _, client := range clients {
+		if qos, ok := client.subscribed(publish.TopicName); ok {
+			if publish.Qos < qos {
+				qos = publish.Qos
+			}
+			client.deliver(publish, qos)
+		}
+	}
+}
+
+func (c *brokerClient) subscribed(topic string) (byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	granted, found := byte(0), false
+	for filter, qos := range c.filters {
+		if TopicMatches(filter, topic) {
+			if !found || qos > granted {
+				granted = qos
+			}
+			found = true
+		}
+	}
+	return granted, found
+}
+
+func (c *brokerClient) deliver(publish *packets.PublishPacket, qos byte) {
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = publish.TopicName
+	msg.Payload = publish.Payload
+	msg.Qos = qos
+
+	c.mutex.Lock()
+	if qos > 0 {
+		c.messageID++
+		if c.messageID == 0 {
+			c.messageID++
+		}
+		msg.MessageID = c.messageID
+	}
+	c.mutex.Unlock()
+
+	c.write(msg)
+}
+
+func (c *brokerClient) write(packet packets.ControlPacket) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return packet.Write(c.conn)
+}
+
+// TopicMatches returns true if the topic is matching the MQTT topic filter, which may contain wildcards.
+func TopicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+package testutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eclipse-kanto/local-digital-twins/internal/testutil"
+	"github.com/eclipse-kanto/suite-connector/connector"
+	"github.com/eclipse-kanto/suite-connector/logger"
+	sctestutil "github.com/eclipse-kanto/suite-connector/testutil"
+)
+
+func TestTopicMatches(t *testing.T) {
+	assert.True(t, testutil.TopicMatches("e", "e"))
+	assert.True(t, testutil.TopicMatches("e/#", "e"))
+	assert.True(t, testutil.TopicMatches("e/#", "e/tenant/thing"))
+	assert.True(t, testutil.TopicMatches("command//+/req/#", "command//ns:thing/req//modified"))
+	assert.True(t, testutil.TopicMatches("#", "event"))
+
+	assert.False(t, testutil.TopicMatches("e", "event"))
+	assert.False(t, testutil.TopicMatches("e/+", "e"))
+	assert.False(t, testutil.TopicMatches("e/+", "e/tenant/thing"))
+	assert.False(t, testutil.TopicMatches("command///req/#", "command//ns:thing/req//modified"))
+}
+
+func TestBrokerPublishSubscribe(t *testing.T) {
+	broker, err := testutil.NewBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	pubConn, err := broker.Connect(t)
+	require.NoError(t, err)
+	defer pubConn.Disconnect()
+
+	subConn, err := broker.Connect(t)
+	require.NoError(t, err)
+	defer subConn.Disconnect()
+
+	log := sctestutil.NewLogger("broker", logger.DEBUG, t)
+	sub := connector.NewSubscriber(subConn, connector.QosAtLeastOnce, false, log, nil)
+	defer sub.Close()
+	msgs, err := sub.Subscribe(context.Background(), "e/#")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return broker.Subscribed("e/tenant/thing")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pub := connector.NewPublisher(pubConn, connector.QosAtLeastOnce, log, nil)
+	defer pub.Close()
+	require.NoError(t, pub.Publish("event", message.NewMessage(watermill.NewUUID(), []byte("skipped"))))
+	require.NoError(t, pub.Publish("e/tenant/thing", message.NewMessage(watermill.NewUUID(), []byte("delivered"))))
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "delivered", string(msg.Payload))
+		msg.Ack()
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no message delivered")
+	}
+}